@@ -0,0 +1,141 @@
+package api
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TimeoutEstimator computes the timeouts of the calls from the latencies observed so far,
+// see SetAdaptiveTimeout. Its methods are called concurrently.
+type TimeoutEstimator interface {
+	// Timeout returns the timeout of the next attempt of a call to resource, zero for none.
+	Timeout(resource string) time.Duration
+	// Observe reports the latency of a call to resource, from sending the request until the
+	// response headers arrived.
+	Observe(resource string, latency time.Duration)
+}
+
+// SetAdaptiveTimeout makes the attempts of the calls without WithTimeout time out after the
+// timeout e computes for their resource, the template of a Template call, from the latencies
+// of the previous calls. Only the first attempts answered by the server are observed, so
+// the retries of failing calls and the timed out attempts don't skew the estimate. A nil e
+// disables the adaptive timeouts.
+func (a *Api) SetAdaptiveTimeout(e TimeoutEstimator) {
+	a.mu.Lock()
+	a.estimator = e
+	a.mu.Unlock()
+}
+
+func (a *Api) timeoutEstimator() TimeoutEstimator {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.estimator
+}
+
+// EWMATimeout is a TimeoutEstimator tracking an exponentially weighted moving average of the
+// latencies of every resource and their variance, the timeout being the average plus K standard
+// deviations, within Min and Max. The zero value isn't usable: Default must be set.
+//
+//	a.SetAdaptiveTimeout(&api.EWMATimeout{Default: 10 * time.Second, Min: 100 * time.Millisecond, Max: 30 * time.Second})
+type EWMATimeout struct {
+	// Default is the timeout of the resources with fewer than MinSamples latencies.
+	Default time.Duration
+	// Min and Max bound the timeouts; zero means no bound.
+	Min, Max time.Duration
+	// Alpha is the weight of a new latency in the average, 0.2 if zero.
+	Alpha float64
+	// K is the number of standard deviations above the average, 3 if zero.
+	K float64
+	// MinSamples is the number of latencies below which Default is used, 3 if zero.
+	MinSamples int
+
+	mu    sync.Mutex
+	stats map[string]*ewmaStats
+}
+
+// TimeoutEstimate is the state of EWMATimeout for a resource.
+type TimeoutEstimate struct {
+	Samples int
+	Mean    time.Duration
+	StdDev  time.Duration
+	// Timeout is the current timeout of the resource.
+	Timeout time.Duration
+}
+
+type ewmaStats struct {
+	samples  int
+	mean     float64
+	variance float64
+}
+
+// Timeout implements TimeoutEstimator.
+func (e *EWMATimeout) Timeout(resource string) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.timeout(e.stats[resource])
+}
+
+func (e *EWMATimeout) timeout(s *ewmaStats) time.Duration {
+	minSamples := e.MinSamples
+	if minSamples <= 0 {
+		minSamples = 3
+	}
+	if s == nil || s.samples < minSamples {
+		return e.Default
+	}
+	k := e.K
+	if k == 0 {
+		k = 3
+	}
+	d := time.Duration(s.mean + k*math.Sqrt(s.variance))
+	if e.Min > 0 && d < e.Min {
+		d = e.Min
+	}
+	if e.Max > 0 && d > e.Max {
+		d = e.Max
+	}
+	return d
+}
+
+// Observe implements TimeoutEstimator. The latencies above Max are counted as Max.
+func (e *EWMATimeout) Observe(resource string, latency time.Duration) {
+	if e.Max > 0 && latency > e.Max {
+		latency = e.Max
+	}
+	alpha := e.Alpha
+	if alpha <= 0 {
+		alpha = 0.2
+	}
+	x := float64(latency)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stats == nil {
+		e.stats = make(map[string]*ewmaStats)
+	}
+	s := e.stats[resource]
+	if s == nil {
+		e.stats[resource] = &ewmaStats{samples: 1, mean: x}
+		return
+	}
+	diff := x - s.mean
+	s.mean += alpha * diff
+	s.variance = (1 - alpha) * (s.variance + alpha*diff*diff)
+	s.samples++
+}
+
+// Estimates returns the current state for every resource observed, e.g. for exporting metrics.
+func (e *EWMATimeout) Estimates() map[string]TimeoutEstimate {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make(map[string]TimeoutEstimate, len(e.stats))
+	for resource, s := range e.stats {
+		out[resource] = TimeoutEstimate{
+			Samples: s.samples,
+			Mean:    time.Duration(s.mean),
+			StdDev:  time.Duration(math.Sqrt(s.variance)),
+			Timeout: e.timeout(s),
+		}
+	}
+	return out
+}
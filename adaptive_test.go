@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestEWMATimeout(t *testing.T) {
+	e := &EWMATimeout{Default: 5 * time.Second, Min: 50 * time.Millisecond, Max: 2 * time.Second, Alpha: 0.5, K: 2}
+
+	// Cold start.
+	assert.Equal(t, 5*time.Second, e.Timeout("/users"))
+	e.Observe("/users", 100*time.Millisecond)
+	e.Observe("/users", 100*time.Millisecond)
+	assert.Equal(t, 5*time.Second, e.Timeout("/users"))
+
+	// mean 100ms, then 150ms with a variance of 0.5*(0+0.5*100ms²) = 25ms², so 150+2*50.
+	e.Observe("/users", 200*time.Millisecond)
+	assert.Equal(t, 250*time.Millisecond, e.Timeout("/users"))
+	est := e.Estimates()["/users"]
+	assert.Equal(t, TimeoutEstimate{Samples: 3, Mean: 150 * time.Millisecond, StdDev: 50 * time.Millisecond, Timeout: 250 * time.Millisecond}, est)
+
+	// Steady fast latencies shrink the timeout down to Min.
+	for i := 0; i < 50; i++ {
+		e.Observe("/users", 10*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, e.Timeout("/users"))
+
+	// Latencies above Max are counted as Max, and the timeout is capped.
+	for i := 0; i < 5; i++ {
+		e.Observe("/users", time.Minute)
+	}
+	assert.Equal(t, 2*time.Second, e.Timeout("/users"))
+	assert.True(t, e.Estimates()["/users"].Mean <= 2*time.Second)
+
+	// Resources are tracked separately.
+	assert.Equal(t, 5*time.Second, e.Timeout("/orders"))
+	assert.Len(t, e.Estimates(), 1)
+}
+
+// recordingEstimator returns a fixed timeout and records the resources observed.
+type recordingEstimator struct {
+	timeout time.Duration
+	mu      sync.Mutex
+	seen    []string
+}
+
+func (e *recordingEstimator) Timeout(resource string) time.Duration { return e.timeout }
+
+func (e *recordingEstimator) Observe(resource string, latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.seen = append(e.seen, resource)
+}
+
+func TestSetAdaptiveTimeout(t *testing.T) {
+	latencies := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond}
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if len(latencies) > 0 {
+			clk.Advance(latencies[0])
+			latencies = latencies[1:]
+		}
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(clk)
+	e := &EWMATimeout{Default: time.Second}
+	a.SetAdaptiveTimeout(e)
+	tmpl := a.Template(GET, "/users/{id}")
+	ctx := context.Background()
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if !assert.NoError(t, tmpl.Do(ctx, map[string]string{"id": id}, nil, nil)) {
+			return
+		}
+	}
+	est := e.Estimates()
+	if !assert.Contains(t, est, "/users/{id}") {
+		return
+	}
+	assert.Equal(t, 4, est["/users/{id}"].Samples)
+	assert.True(t, est["/users/{id}"].Mean > 100*time.Millisecond && est["/users/{id}"].Mean < 200*time.Millisecond)
+
+	// An explicit timeout isn't observed.
+	assert.NoError(t, a.DoJSON(ctx, GET, "/users/5", nil, nil, WithTimeout(time.Second)))
+	assert.Len(t, e.Estimates(), 1)
+	assert.Equal(t, 4, e.Estimates()["/users/{id}"].Samples)
+}
+
+func TestAdaptiveTimeoutRetries(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(fakeClock())
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	e := &recordingEstimator{timeout: time.Minute}
+	a.SetAdaptiveTimeout(e)
+	if !assert.NoError(t, a.DoJSON(context.Background(), GET, "/flaky", nil, nil)) {
+		return
+	}
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []string{"/flaky"}, e.seen)
+
+	a.SetAdaptiveTimeout(nil)
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/flaky", nil, nil))
+	assert.Len(t, e.seen, 1)
+}
+
+func TestAdaptiveTimeoutExpires(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	a := MustNew(srv.URL)
+	e := &recordingEstimator{timeout: 20 * time.Millisecond}
+	a.SetAdaptiveTimeout(e)
+	err := a.DoJSON(context.Background(), GET, "/slow", nil, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.Empty(t, e.seen)
+}
@@ -1,36 +1,41 @@
 // Package api is a helper that simplifies the process of REST APIs bindings creation in Go.
 // Rather than composing URLs and HTTP requests by hand, one can use the api.Request method in order to
 // automatically create such a request. The use case may be as following:
-//   svc, _ := api.New("http://example.com")
-//   args := url.Values{}
-//   args.Set("filter", "1")
-//   args.Set("price", "200")
-//   req, _ := svc.Request(api.GET, "/categories/1", args)
 //
-//   // URL is now http://example.com/categories/1?filter=1&price=200
+//	svc, _ := api.New("http://example.com")
+//	args := url.Values{}
+//	args.Set("filter", "1")
+//	args.Set("price", "200")
+//	req, _ := svc.Request(api.GET, "/categories/1", args)
 //
-//   var cli http.Client
-//   resp, err := cli.Do(req)
+//	// URL is now http://example.com/categories/1?filter=1&price=200
+//
+//	var cli http.Client
+//	resp, err := cli.Do(req)
 //
 // In the case of POST, the arguments will be presented in the Body of request:
 //
-//   req, _ := svc.Request(api.POST, "/categories/1", args)
+//	req, _ := svc.Request(api.POST, "/categories/1", args)
 //
-//   // URL is now http://example.com/categories/1
-//   // Body is now filter=1&price=200
-//   // Header is now has Content-Type: application/x-www-form-urlencoded
+//	// URL is now http://example.com/categories/1
+//	// Body is now filter=1&price=200
+//	// Header is now has Content-Type: application/x-www-form-urlencoded
 //
-//   var cli http.Client
-//   resp, err := cli.Do(req)
+//	var cli http.Client
+//	resp, err := cli.Do(req)
 package api
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
-	"path"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Method represents an HTTP method.
@@ -70,15 +75,155 @@ type Api struct {
 	BaseURI *url.URL
 	// Header is a custom header that will be used for communtication with API (e.g. Authorization).
 	Header http.Header
+	// Client is the HTTP client used by Do. If nil, http.DefaultClient is used.
+	Client *http.Client
+	// Retry is the retry policy of the Do-style helpers. If nil, failed calls aren't retried.
+	Retry *RetryPolicy
+	// MaxRequestBytes, if positive, limits the request bodies of the calls: a body of a known
+	// length over it fails the call before it's sent, and a streamed one is aborted once it
+	// crosses it, with a *RequestTooLargeError. See WithMaxRequestBytes.
+	MaxRequestBytes int64
+
+	envs        map[string]*url.URL
+	tenant      string
+	env         atomic.Pointer[env]
+	prefix      atomic.Pointer[basePrefix]
+	pathPolicy  atomic.Int32
+	strictPaths atomic.Bool
+	target      atomic.Pointer[targetGuard]
+	cert        atomic.Pointer[clientCert]
+	hosts       atomic.Pointer[hostPool]
+	mirror      atomic.Pointer[mirroring]
+	queue       atomic.Pointer[writeQueue]
+	offline     atomic.Int32
+	baseAllow   atomic.Pointer[[]string]
+	fieldsStyle atomic.Int32
+	order       atomic.Pointer[headerOrder]
+	expect      atomic.Pointer[expectContinue]
+	shed        atomic.Pointer[shedder]
+	serial      lazy[fenceSet]
+	deadline    atomic.Pointer[DeadlinePolicy]
+	pool        lazy[poolStats]
+	creds       lazy[credentialStats]
+	querySort   atomic.Pointer[querySort]
+	hints       atomic.Pointer[hints]
+	attribution atomic.Pointer[AttributionPolicy]
+	conditions  atomic.Pointer[ConditionPolicy]
+	tlsAudit    atomic.Pointer[TLSAuditPolicy]
+	usage       lazy[usageTracker]
+	transforms  atomic.Pointer[jsonTransforms]
+	bg          lazy[background]
+	schedule    atomic.Pointer[scheduler]
+	captures    lazy[captureSet]
+	flags       atomic.Pointer[flagSet]
+	idempotency atomic.Pointer[IdempotencyPolicy]
+	compression atomic.Pointer[compression]
+	dictionary  atomic.Pointer[Dictionary]
+	consistency atomic.Pointer[consistency]
+	listPacing  atomic.Pointer[Pacing]
+	migrations  atomic.Pointer[migrations]
+	discovery   atomic.Pointer[discovery]
+	// discoveryPolicy is the policy of SetDiscoveryPolicy, nil until it's called.
+	discoveryPolicy atomic.Pointer[DiscoveryPolicy]
+	asyncPolicy     atomic.Pointer[AsyncPolicy]
+	asyncQueue      atomic.Pointer[asyncQueue]
+	asyncCounters   lazy[asyncCounters]
+	politeness      atomic.Pointer[politeness]
+	registry        *Registry
+
+	mu            sync.Mutex
+	guard         func(from, to string) error
+	locale        string
+	version       Version
+	onDeprecation func(d Deprecation)
+	classifier    Classifier
+	codes         *errorCodes
+	tunnel        *StatusTunnel
+	logger        CallLogger
+	clk           Clock
+	defaults      []Option
+	stale         *staleCache
+	cache         *respCache
+	redactor      *Redactor
+	journal       *journaling
+	params        *paramDecls
+	redactions    *redactions
+	dualReads     map[string]*dualRead
+	pageLimits    *pageLimits
+	queryLint     func(key, value string)
+	rawHeaders    []string
+	memo          *memo
+	memoLimit     int
+	estimator     TimeoutEstimator
+	codec         BodyCodec
+	soap          *SOAPEnvelope
+	validation    *validation
+	validateBody  func(v interface{}) error
+	drift         *drifting
+	tokens        TokenSource
+	rotation      *rotation
+	authRules     []authRule
+	strictTypes   bool
+	taxonomy      bool
+	chain         []preparerEntry
+	closed        bool
+	flights       map[*flight]struct{}
+	wg            sync.WaitGroup
 }
 
-// New creates a new api instance with given base uri.
+// New creates a new api instance with given base uri, an absolute URL or an absolute path.
+// The base uri is normalized: the host is lowercased, the port kept, and the trailing slash of
+// the path dropped, so "https://host/api/" and "https://host/api" make the same Api. A base uri
+// with a fragment is rejected.
+//
+// The resources are joined with the path of the base uri, whatever their leading and trailing
+// slashes, and the joined path is cleaned, e.g. with the base uri "https://host/api":
+//
+//	""          https://host/api
+//	"/"         https://host/api
+//	"users"     https://host/api/users
+//	"/users/"   https://host/api/users
+//	"//users/1" https://host/api/users/1
+//
+// The resource is a path: "?" and "#" are escaped, see SetPathPolicy. ResolveURL returns the URL
+// of a resource.
 func New(uri string) (a *Api, err error) {
 	a = &Api{}
-	a.BaseURI, err = url.ParseRequestURI(uri)
+	a.BaseURI, err = parseBase(uri)
 	return
 }
 
+// parseBase parses and normalizes the base uri, see New.
+func parseBase(uri string) (*url.URL, error) {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(uri, "#") {
+		return nil, &url.Error{Op: "parse", URL: uri, Err: errors.New("base uri with a fragment")}
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+	return u, nil
+}
+
+// ResolveURL returns the URL a GET request for the resource with the query args would be sent
+// to, without building the request: the resource joined with the current base uri like New says,
+// with the path version if set.
+func (a *Api) ResolveURL(resource string, args url.Values) (*url.URL, error) {
+	u, err := a.resourceURL(resource)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = args.Encode()
+	if s := a.querySort.Load(); s != nil && strings.Contains(u.RawQuery, "&") {
+		u.RawQuery = s.sort(u.RawQuery)
+	}
+	u.Host = strings.TrimSuffix(u.Host, ":")
+	return u, nil
+}
+
 // MustNew is like New, but panics if any error has occured.
 func MustNew(uri string) *Api {
 	a, err := New(uri)
@@ -91,46 +236,117 @@ func MustNew(uri string) *Api {
 // Request creates an http request instance properly initialized with the given parameters.
 // In a special case for the POST method it will create a body buffer,
 // in other cases it will just store the parameters in the URL.
-func (a *Api) Request(method Method, resource string, args url.Values) (req *http.Request, err error) {
-	u := *a.BaseURI
-	u.Path = path.Join(u.Path, resource)
+// A POST body encoding to more than 64KB is encoded as it's sent rather than buffered.
+func (a *Api) Request(method Method, resource string, args url.Values, opts ...Option) (req *http.Request, err error) {
+	if err := a.checkParamsFor(resource, args, opts); err != nil {
+		return nil, err
+	}
+	if args, err = a.limitPageSize(resource, args); err != nil {
+		return nil, err
+	}
+	a.lintArgs(args)
+	u, err := a.resourceURL(resource)
+	if err != nil {
+		return nil, err
+	}
 
 	switch method {
 	case GET, HEAD, PUT, DELETE, PATCH:
 		u.RawQuery = args.Encode()
-		if req, err = http.NewRequest(method.String(), u.String(), nil); err != nil {
-			return
-		}
-		for k := range a.Header {
-			req.Header.Add(k, a.Header.Get(k))
-		}
+		req = newRequest(method, u)
 	case POST:
-		data := args.Encode()
-		if req, err = http.NewRequest(method.String(), u.String(), bytes.NewBufferString(data)); err != nil {
-			return
-		}
-		for k := range a.Header {
-			req.Header.Add(k, a.Header.Get(k))
-		}
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+		req = newRequest(method, u)
+		setFormValuesBody(req, args)
 	default:
 		return nil, fmt.Errorf("api: unknown method: %d", method)
 	}
 
+	if err = a.shape(req, opts); err != nil {
+		return nil, err
+	}
 	return req, nil
 }
 
-func (a *Api) RequestBytes(method Method, resource string, contentType string, data []byte) (req *http.Request, err error) {
-	u := *a.BaseURI
-	u.Path = path.Join(u.Path, resource)
-	if req, err = http.NewRequest(method.String(), u.String(), bytes.NewReader(data)); err != nil {
-		return
+func (a *Api) RequestBytes(method Method, resource string, contentType string, data []byte, opts ...Option) (req *http.Request, err error) {
+	if err := a.checkContentType(contentType); err != nil {
+		return nil, err
 	}
-	for k := range a.Header {
-		req.Header.Add(k, a.Header.Get(k))
+	u, err := a.resourceURL(resource)
+	if err != nil {
+		return nil, err
 	}
+	req = newRequest(method, u)
+	setBytesBody(req, data)
 	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	if err = a.shape(req, opts); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// RequestJSON creates an http request with v encoded as JSON in its body.
+// The body is encoded once; resending it, e.g. when retrying, replays the same bytes,
+// so v may be modified once RequestJSON returns.
+func (a *Api) RequestJSON(method Method, resource string, v interface{}, opts ...Option) (req *http.Request, err error) {
+	return a.requestJSON(method, resource, nil, v, opts)
+}
+
+// RequestJSONWithQuery is like RequestJSON, but also encodes args in the query of the URL,
+// whatever the method: unlike Request, POST doesn't move them into the body.
+//
+//	req, err := a.RequestJSONWithQuery(api.POST, "/items", url.Values{"source": {"import"}}, items)
+func (a *Api) RequestJSONWithQuery(method Method, resource string, args url.Values, v interface{}, opts ...Option) (req *http.Request, err error) {
+	return a.requestJSON(method, resource, args, v, opts)
+}
+
+func (a *Api) requestJSON(method Method, resource string, args url.Values, v interface{}, opts []Option) (req *http.Request, err error) {
+	if err := a.checkParamsFor(resource, args, opts); err != nil {
+		return nil, err
+	}
+	if args, err = a.limitPageSize(resource, args); err != nil {
+		return nil, err
+	}
+	if err := a.validateRequestBody(method, resource, v, opts); err != nil {
+		return nil, err
+	}
+	a.lintArgs(args)
+	u, err := a.resourceURL(resource)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = args.Encode()
+	req = newRequest(method, u)
+	if err = setLazyBody(req, func(buf *bytes.Buffer) error {
+		return encodeJSON(buf, v)
+	}); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	if err = a.shape(req, opts); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// RequestReader creates an http request that streams its body from r without buffering it.
+// If r is an io.Seeker, the request body keeps being seekable, allowing options like
+// UploadChecksum to make two passes over it, and it's replayable from the current offset of r
+// for redirects and retries, see IsReplayable.
+func (a *Api) RequestReader(method Method, resource string, contentType string, r io.Reader, opts ...Option) (req *http.Request, err error) {
+	if err := a.checkContentType(contentType); err != nil {
+		return nil, err
+	}
+	u, err := a.resourceURL(resource)
+	if err != nil {
+		return nil, err
+	}
+	req = newRequest(method, u)
+	setReaderBody(req, r)
+	req.Header.Set("Content-Type", contentType)
+	if err = a.shape(req, opts); err != nil {
+		return nil, err
+	}
 	return
 }
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -66,3 +67,71 @@ func TestRequestErrors(t *testing.T) {
 	_, err = a.Request(Method(10), "", nil)
 	assert.Error(t, err)
 }
+
+func TestRequestJSON(t *testing.T) {
+	a := MustNew("http://example.com")
+	req, err := a.RequestJSON(POST, "/categories", map[string]string{"name": "<b>"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, "24", req.Header.Get("Content-Length"))
+	assert.EqualValues(t, 24, req.ContentLength)
+	buf, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, `{"name":"\u003cb\u003e"}`, string(buf))
+
+	_, err = a.RequestJSON(POST, "/categories", func() {})
+	assert.Error(t, err)
+}
+
+func TestRequestJSONWithQuery(t *testing.T) {
+	a := MustNew("http://example.com/api")
+	args := url.Values{"source": {"import"}, "dry run": {"1"}}
+	for _, method := range []Method{POST, PUT} {
+		req, err := a.RequestJSONWithQuery(method, "/items", args, map[string]string{"name": "a"}, WithQuery("trace", "1"))
+		if !assert.NoError(t, err, method) {
+			continue
+		}
+		assert.Equal(t, "http://example.com/api/items?dry+run=1&source=import&trace=1", req.URL.String(), method)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"), method)
+		assert.EqualValues(t, 12, req.ContentLength, method)
+		buf, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, `{"name":"a"}`, string(buf), method)
+	}
+
+	req, err := a.RequestJSONWithQuery(POST, "/items", nil, map[string]string{"source": "body"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "http://example.com/api/items", req.URL.String())
+	}
+}
+
+func BenchmarkRequestGET(b *testing.B) {
+	a := MustNew("http://example.com/api/v2")
+	args := url.Values{"filter": {"1"}, "price": {"200"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		a.Request(GET, "/categories/1", args)
+	}
+}
+
+func BenchmarkRequestPOST(b *testing.B) {
+	a := MustNew("http://example.com")
+	args := url.Values{"filter": {"1"}, "price": {"200"}, "name": {"some long value with spaces & symbols"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req, _ := a.Request(POST, "/categories/1", args)
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+}
+
+func BenchmarkRequestJSON(b *testing.B) {
+	a := MustNew("http://example.com")
+	v := map[string]interface{}{"filter": 1, "price": 200, "name": "some long value"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req, _ := a.RequestJSON(POST, "/categories/1", v)
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,257 @@
+// Package apiauth implements the OAuth2 authorization code flow with PKCE (RFC 7636) for command
+// line tools built on top of the api package: it opens the authorization page in a browser,
+// catches the redirect on a localhost listener, exchanges the code and keeps the tokens in a
+// TokenStore, refreshing them as they expire:
+//
+//	cfg := &apiauth.Config{
+//		AuthURL:  "https://auth.example.com/authorize",
+//		TokenURL: "https://auth.example.com/oauth/token",
+//		ClientID: "my-cli",
+//		Scopes:   []string{"orders:read", "offline_access"},
+//		Store:    &apiauth.FileStore{Path: filepath.Join(dir, "token.json")},
+//	}
+//	ts, err := cfg.TokenSource(ctx) // runs the flow unless a token is stored
+//	svc.SetTokenSource(ts)
+package apiauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xlab/api"
+)
+
+// ErrStateMismatch is returned by Login when the redirect doesn't carry the state of the
+// authorization request, as it would if it wasn't caused by it.
+var ErrStateMismatch = errors.New("apiauth: state mismatch")
+
+// AuthorizeError is returned by Login when the authorization server redirects with an error,
+// e.g. the user denied the access.
+type AuthorizeError struct {
+	// Code, Description and URI are the error, error_description and error_uri of the redirect.
+	Code        string
+	Description string
+	URI         string
+}
+
+func (e *AuthorizeError) Error() string {
+	if e.Description == "" {
+		return "apiauth: authorize: " + e.Code
+	}
+	return fmt.Sprintf("apiauth: authorize: %s: %s", e.Code, e.Description)
+}
+
+// DefaultSuccessPage is the page shown in the browser once the redirect is caught, unless
+// Config.SuccessPage is set.
+const DefaultSuccessPage = `<!DOCTYPE html>
+<html><head><title>Signed in</title></head>
+<body><p>You're signed in. You can close this window and go back to the terminal.</p></body></html>
+`
+
+// Config configures the authorization code flow against a server. Use it by pointer.
+type Config struct {
+	// AuthURL and TokenURL are the authorization and token endpoints of the server.
+	AuthURL  string
+	TokenURL string
+	ClientID string
+	// ClientSecret is sent to the token endpoint if set; the public clients PKCE is meant for
+	// have none.
+	ClientSecret string
+	Scopes       []string
+	// MinPort and MaxPort are the range of the ports tried in order for the localhost listener
+	// catching the redirect, which must be registered with the server. A port picked by the
+	// system is used if both are zero.
+	MinPort, MaxPort int
+	// CallbackPath is the path of the redirect URI, "/callback" if empty.
+	CallbackPath string
+	// SuccessPage is the HTML shown in the browser once the redirect is caught, DefaultSuccessPage if empty.
+	SuccessPage string
+	// OpenURL opens the authorization URL. If nil, it's opened with the default browser of the
+	// system, through open, xdg-open or rundll32.
+	OpenURL func(u string) error
+	// Store keeps the tokens between runs. If nil, they're kept in memory only.
+	Store TokenStore
+	// ExpirySkew is how long before its expiry an access token is refreshed, 30s if zero.
+	ExpirySkew time.Duration
+	// Client is the client of the token requests, http.DefaultClient if nil.
+	Client *http.Client
+	// Clock is the source of time used for expiries, the real time if nil.
+	Clock api.Clock
+}
+
+// TokenSource returns a TokenSource refreshing the token of the Store, running Login first if
+// there's none.
+func (c *Config) TokenSource(ctx context.Context) (*TokenSource, error) {
+	if c.Store != nil {
+		t, err := c.Store.Load()
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			return &TokenSource{c: c, token: t}, nil
+		}
+	}
+	return c.Login(ctx)
+}
+
+// Login runs the authorization code flow: it listens for the redirect on localhost, opens the
+// authorization URL, waits for the redirect until ctx is done, and exchanges the code for
+// tokens with the PKCE verifier. The tokens are saved to the Store.
+func (c *Config) Login(ctx context.Context) (*TokenSource, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	l, err := c.listen()
+	if err != nil {
+		return nil, err
+	}
+	path := c.CallbackPath
+	if path == "" {
+		path = "/callback"
+	}
+	redirectURI := "http://" + l.Addr().String() + path
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	page := c.SuccessPage
+	if page == "" {
+		page = DefaultSuccessPage
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var res result
+		switch {
+		case q.Get("state") != state:
+			res.err = ErrStateMismatch
+		case q.Get("error") != "":
+			res.err = &AuthorizeError{Code: q.Get("error"), Description: q.Get("error_description"), URI: q.Get("error_uri")}
+		case q.Get("code") == "":
+			res.err = errors.New("apiauth: no code in the redirect")
+		default:
+			res.code = q.Get("code")
+		}
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(page))
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(l)
+	defer srv.Shutdown(context.Background())
+
+	sum := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.ClientID},
+		"redirect_uri":          {redirectURI},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if len(c.Scopes) > 0 {
+		q.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	authURL := c.AuthURL
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + q.Encode()
+	} else {
+		authURL += "?" + q.Encode()
+	}
+	open := c.OpenURL
+	if open == nil {
+		open = openBrowser
+	}
+	if err := open(authURL); err != nil {
+		return nil, fmt.Errorf("apiauth: open %s: %w", authURL, err)
+	}
+	var res result
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	t, err := c.exchange(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {res.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.Store != nil {
+		if err := c.Store.Save(t); err != nil {
+			return nil, err
+		}
+	}
+	return &TokenSource{c: c, token: t}, nil
+}
+
+// listen listens on the first free port of the range on the loopback interface.
+func (c *Config) listen() (net.Listener, error) {
+	if c.MinPort == 0 && c.MaxPort == 0 {
+		return net.Listen("tcp", "127.0.0.1:0")
+	}
+	var err error
+	for port := c.MinPort; port <= c.MaxPort; port++ {
+		var l net.Listener
+		if l, err = net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port)); err == nil {
+			return l, nil
+		}
+	}
+	if err == nil {
+		return nil, fmt.Errorf("apiauth: empty port range %d-%d", c.MinPort, c.MaxPort)
+	}
+	return nil, fmt.Errorf("apiauth: no free port in %d-%d: %w", c.MinPort, c.MaxPort, err)
+}
+
+// randomString returns n random bytes encoded in unpadded base64url, as RFC 7636 requires of
+// the code verifier.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func openBrowser(u string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", u).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", u).Start()
+	default:
+		return exec.Command("xdg-open", u).Start()
+	}
+}
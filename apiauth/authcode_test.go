@@ -0,0 +1,214 @@
+package apiauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api"
+	"github.com/xlab/api/internal/clock"
+)
+
+// fakeIdP is an authorization server issuing the code "c0de" to the browser it's given, and
+// tokens for it with the PKCE verifier of its challenge.
+type fakeIdP struct {
+	srv       *httptest.Server
+	mu        sync.Mutex
+	challenge string
+	redirect  string
+	refreshes int
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	idp := &fakeIdP{}
+	idp.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		assert.Equal(t, "cli", r.PostForm.Get("client_id"))
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+			if r.PostForm.Get("code") != "c0de" || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge || r.PostForm.Get("redirect_uri") != idp.redirect {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"error":"invalid_grant"}`)
+				return
+			}
+			io.WriteString(w, `{"access_token":"at0","token_type":"bearer","refresh_token":"rt0","expires_in":3600}`)
+		case "refresh_token":
+			if r.PostForm.Get("refresh_token") != "rt"+strconv.Itoa(idp.refreshes) {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"error":"invalid_grant"}`)
+				return
+			}
+			idp.refreshes++
+			n := strconv.Itoa(idp.refreshes)
+			io.WriteString(w, `{"access_token":"at`+n+`","refresh_token":"rt`+n+`","expires_in":3600}`)
+		}
+	}))
+	return idp
+}
+
+// browser returns an OpenURL checking the authorization request and following the redirect
+// of the server, with the query of the redirect changed by edit if not nil.
+func (idp *fakeIdP) browser(t *testing.T, edit func(q url.Values)) func(string) error {
+	return func(u string) error {
+		au, err := url.Parse(u)
+		if err != nil {
+			return err
+		}
+		q := au.Query()
+		assert.Equal(t, idp.srv.URL+"/authorize", au.Scheme+"://"+au.Host+au.Path)
+		assert.Equal(t, "code", q.Get("response_type"))
+		assert.Equal(t, "S256", q.Get("code_challenge_method"))
+		assert.Equal(t, "orders offline_access", q.Get("scope"))
+		idp.mu.Lock()
+		idp.challenge, idp.redirect = q.Get("code_challenge"), q.Get("redirect_uri")
+		idp.mu.Unlock()
+		back := url.Values{"code": {"c0de"}, "state": {q.Get("state")}}
+		if edit != nil {
+			edit(back)
+		}
+		resp, err := http.Get(q.Get("redirect_uri") + "?" + back.Encode())
+		if err != nil {
+			return err
+		}
+		page, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			assert.Equal(t, "<p>done</p>", string(page))
+		}
+		return nil
+	}
+}
+
+func (idp *fakeIdP) config() *Config {
+	return &Config{
+		AuthURL:     idp.srv.URL + "/authorize",
+		TokenURL:    idp.srv.URL + "/token",
+		ClientID:    "cli",
+		Scopes:      []string{"orders", "offline_access"},
+		SuccessPage: "<p>done</p>",
+	}
+}
+
+func TestLogin(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.srv.Close()
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "token.json")
+	cfg := idp.config()
+	cfg.OpenURL, cfg.Clock, cfg.Store = idp.browser(t, nil), clk, &FileStore{Path: path}
+	ctx := context.Background()
+
+	ts, err := cfg.TokenSource(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a := api.MustNew(srv.URL)
+	a.SetTokenSource(ts)
+	assert.NoError(t, a.Get(ctx, "/", nil, nil))
+	assert.Equal(t, "Bearer at0", auth)
+	fi, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	}
+
+	// The access token is refreshed before it expires, and the new tokens saved.
+	clk.Advance(time.Hour - 30*time.Second)
+	assert.NoError(t, a.Get(ctx, "/", nil, nil))
+	assert.Equal(t, "Bearer at1", auth)
+	stored, err := cfg.Store.Load()
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Token{AccessToken: "at1", RefreshToken: "rt1", Expiry: clk.Now().Add(time.Hour)}, stored)
+	}
+
+	// The next run starts from the stored tokens.
+	cfg.OpenURL = func(string) error { return errors.New("no browser") }
+	ts, err = cfg.TokenSource(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	tok, err := ts.Token(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "at1", tok.AccessToken)
+	}
+
+	// Without a refresh token, an expired token needs a new login.
+	cfg.Store = nil
+	ts = &TokenSource{c: cfg, token: &Token{AccessToken: "old", Expiry: clk.Now()}}
+	_, err = ts.Token(ctx)
+	assert.Equal(t, ErrLoginRequired, err)
+}
+
+func TestLoginErrors(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.srv.Close()
+	ctx := context.Background()
+	cfg := idp.config()
+
+	cfg.OpenURL = idp.browser(t, func(q url.Values) { q.Set("state", "forged") })
+	_, err := cfg.Login(ctx)
+	assert.Equal(t, ErrStateMismatch, err)
+
+	cfg.OpenURL = idp.browser(t, func(q url.Values) {
+		q.Del("code")
+		q.Set("error", "access_denied")
+		q.Set("error_description", "The user said no")
+	})
+	_, err = cfg.Login(ctx)
+	var ae *AuthorizeError
+	if assert.True(t, errors.As(err, &ae), "%v", err) {
+		assert.Equal(t, "access_denied", ae.Code)
+		assert.Equal(t, "apiauth: authorize: access_denied: The user said no", ae.Error())
+	}
+
+	cfg.OpenURL = idp.browser(t, func(q url.Values) { q.Set("code", "stolen") })
+	_, err = cfg.Login(ctx)
+	var te *api.TokenError
+	if assert.True(t, errors.As(err, &te), "%v", err) {
+		assert.Equal(t, "invalid_grant", te.Code)
+	}
+
+	// The redirect is caught on a port of the range.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	cfg.MinPort, cfg.MaxPort = port, port
+	cfg.OpenURL = idp.browser(t, nil)
+	_, err = cfg.Login(ctx)
+	assert.Error(t, err)
+	l.Close()
+	_, err = cfg.Login(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:"+strconv.Itoa(port)+"/callback", idp.redirect)
+
+	// The wait for the redirect is bounded by ctx.
+	cfg.MinPort, cfg.MaxPort = 0, 0
+	cfg.OpenURL = func(string) error { return nil }
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = cfg.Login(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
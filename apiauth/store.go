@@ -0,0 +1,64 @@
+package apiauth
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// TokenStore keeps the tokens between the runs of a tool, see FileStore.
+type TokenStore interface {
+	// Load returns the stored tokens, nil if there are none.
+	Load() (*Token, error)
+	Save(t *Token) error
+}
+
+// FileStore is a TokenStore keeping the tokens in a JSON file readable by its owner only.
+// The file is replaced atomically, so a crash never leaves it half written.
+type FileStore struct {
+	Path string
+}
+
+// Load implements TokenStore.
+func (s *FileStore) Load() (*Token, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t Token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Save implements TokenStore.
+func (s *FileStore) Save(t *Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	// CreateTemp creates the file with mode 0600 already; Chmod makes it explicit.
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.Path)
+}
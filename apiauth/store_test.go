@@ -0,0 +1,36 @@
+package apiauth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileStore{Path: filepath.Join(dir, "token.json")}
+	got, err := s.Load()
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	tok := &Token{AccessToken: "at", RefreshToken: "rt", Expiry: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	assert.NoError(t, s.Save(tok))
+	got, err = s.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, tok, got)
+	fi, err := os.Stat(s.Path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	}
+
+	// The file is replaced, without temporary files left over.
+	assert.NoError(t, s.Save(&Token{AccessToken: "at2"}))
+	got, err = s.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "at2", got.AccessToken)
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,149 @@
+package apiauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xlab/api"
+	"github.com/xlab/api/internal/clock"
+)
+
+// ErrLoginRequired is returned by TokenSource.Token when the access token expired and can't be
+// refreshed, having no refresh token: Login has to be run again.
+var ErrLoginRequired = errors.New("apiauth: login required")
+
+// Token is the tokens issued by the server, as kept in a TokenStore.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// TokenSource is an api.TokenSource providing the access token obtained by Login, refreshing
+// it with the refresh token ExpirySkew before it expires and saving the new tokens to the
+// Store. Concurrent callers needing a refresh share a single token request.
+type TokenSource struct {
+	c     *Config
+	mu    sync.Mutex
+	token *Token
+}
+
+// Token implements api.TokenSource.
+func (s *TokenSource) Token(ctx context.Context) (*api.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.token
+	if !t.Expiry.IsZero() && !s.c.now().Before(t.Expiry.Add(-s.c.skew())) {
+		if t.RefreshToken == "" {
+			return nil, ErrLoginRequired
+		}
+		nt, err := s.c.exchange(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {t.RefreshToken}})
+		if err != nil {
+			return nil, err
+		}
+		// The server may keep the refresh token.
+		if nt.RefreshToken == "" {
+			nt.RefreshToken = t.RefreshToken
+		}
+		if s.c.Store != nil {
+			if err := s.c.Store.Save(nt); err != nil {
+				return nil, err
+			}
+		}
+		s.token, t = nt, nt
+	}
+	return &api.Token{AccessToken: t.AccessToken, TokenType: t.TokenType, Expiry: t.Expiry}, nil
+}
+
+func (c *Config) now() time.Time {
+	if c.Clock == nil {
+		return clock.Real{}.Now()
+	}
+	return c.Clock.Now()
+}
+
+func (c *Config) skew() time.Duration {
+	if c.ExpirySkew <= 0 {
+		return 30 * time.Second
+	}
+	return c.ExpirySkew
+}
+
+// exchange requests tokens from the token endpoint with the grant of form. The errors of the
+// endpoint are returned as *api.TokenError.
+func (c *Config) exchange(ctx context.Context, form url.Values) (*Token, error) {
+	form.Set("client_id", c.ClientID)
+	if c.ClientSecret != "" {
+		form.Set("client_secret", c.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	issued := c.now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var v struct {
+		AccessToken      string          `json:"access_token"`
+		TokenType        string          `json:"token_type"`
+		RefreshToken     string          `json:"refresh_token"`
+		ExpiresIn        json.RawMessage `json:"expires_in"`
+		Error            string          `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+		ErrorURI         string          `json:"error_uri"`
+	}
+	jerr := json.Unmarshal(body, &v)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || v.Error != "" {
+		return nil, &api.TokenError{
+			Code:        v.Error,
+			Description: v.ErrorDescription,
+			URI:         v.ErrorURI,
+			Status: &api.StatusError{
+				Code:   resp.StatusCode,
+				Status: resp.Status,
+				Header: resp.Header,
+				Body:   body,
+				Size:   resp.ContentLength,
+			},
+		}
+	}
+	if jerr != nil {
+		return nil, fmt.Errorf("apiauth: token endpoint: %w", jerr)
+	}
+	if v.AccessToken == "" {
+		return nil, errors.New("apiauth: token endpoint: no access_token in the response")
+	}
+	t := &Token{AccessToken: v.AccessToken, TokenType: v.TokenType, RefreshToken: v.RefreshToken}
+	// Some servers send expires_in as a string.
+	if s := strings.Trim(string(v.ExpiresIn), `"`); s != "" && s != "null" {
+		secs, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("apiauth: token endpoint: invalid expires_in: %s", v.ExpiresIn)
+		}
+		t.Expiry = issued.Add(time.Duration(secs) * time.Second)
+	}
+	return t, nil
+}
@@ -0,0 +1,33 @@
+package apitest
+
+import (
+	"time"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+// FakeClock is an api.Clock whose time only moves when advanced, for testing retries
+// and other timing without sleeping:
+//
+//	clk := apitest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+//	svc.SetClock(clk)
+//	go svc.DoJSON(ctx, api.GET, "/items", nil, &items) // retries after a 503
+//	clk.BlockUntil(1)                                    // the call is waiting to retry
+//	clk.Advance(time.Minute)
+//
+// Its methods are:
+//
+//	Now() time.Time
+//	Sleep(ctx context.Context, d time.Duration) error
+//	NewTimer(d time.Duration) api.Timer
+//	Advance(d time.Duration)          // moves the time, firing due timers and waking sleepers
+//	AutoAdvance(on bool)              // advances to each new timer's deadline right away
+//	Sleepers() int                    // the number of pending timers and blocked sleepers
+//	Deadlines() []time.Time           // when they fire, in order
+//	BlockUntil(n int)                 // waits until at least n are pending
+type FakeClock = clock.Fake
+
+// NewFakeClock creates a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return clock.NewFake(now)
+}
@@ -0,0 +1,33 @@
+package apitest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api"
+)
+
+func TestFakeClockRetry(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFakeClock(start)
+	sc := NewScenario(clk)
+	sc.On("GET", "/items").
+		Respond(503, "").RetryAfter(time.Minute).Times(1).
+		Then().RespondJSON(200, `[]`)
+	srv := sc.Server(t)
+
+	a := api.MustNew(srv.URL)
+	a.Retry = &api.RetryPolicy{MaxRetries: 1}
+	a.SetClock(clk)
+
+	done := make(chan error)
+	go func() { done <- a.DoJSON(context.Background(), api.GET, "/items", nil, nil) }()
+	clk.BlockUntil(1)
+	assert.Equal(t, []time.Time{start.Add(time.Minute)}, clk.Deadlines())
+	clk.Advance(time.Minute)
+	assert.NoError(t, <-done)
+	sc.AssertSequence(t, "GET /items", "GET /items")
+	sc.AssertOffsets(t, time.Second, 0, time.Minute)
+}
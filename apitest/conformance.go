@@ -0,0 +1,246 @@
+package apitest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xlab/api"
+)
+
+// conformanceEpoch is the time the fake clocks of the conformance tests start at.
+var conformanceEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// TokenFunc issues the tokens of the upstream of a TokenSource under test, see TestTokenSource.
+type TokenFunc func(ctx context.Context) (*api.Token, error)
+
+// TestTokenSource runs the conformance tests of api.TokenSource against the sources created
+// by newSource, e.g. for a third-party implementation; the built-in ones pass them. Every source
+// must get its tokens from upstream, however it's called, and its time from clk:
+//
+//	func TestMySource(t *testing.T) {
+//		apitest.TestTokenSource(t, func(clk *apitest.FakeClock, upstream apitest.TokenFunc) api.TokenSource {
+//			return mysource.New(upstream, mysource.WithClock(clk))
+//		})
+//	}
+//
+// The tests check that the tokens are cached until their expiry, that the source is safe for
+// concurrent use, and that the failures of upstream are returned without being kept.
+func TestTokenSource(t *testing.T, newSource func(clk *FakeClock, upstream TokenFunc) api.TokenSource) {
+	t.Run("Caching", func(t *testing.T) {
+		clk := NewFakeClock(conformanceEpoch)
+		up := &tokenUpstream{clk: clk, lifetime: time.Hour}
+		ts := newSource(clk, up.issue)
+		first, ok := tokenOf(t, ts)
+		if !ok {
+			return
+		}
+		for i := 0; i < 10; i++ {
+			if tok, ok := tokenOf(t, ts); ok && tok.AccessToken != first.AccessToken {
+				t.Errorf("apitest: Token returned %q, then %q before the expiry", first.AccessToken, tok.AccessToken)
+			}
+		}
+		if n := up.calls.Load(); n != 1 {
+			t.Errorf("apitest: %d tokens issued for 11 calls, want 1", n)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		clk := NewFakeClock(conformanceEpoch)
+		up := &tokenUpstream{clk: clk, lifetime: time.Hour}
+		ts := newSource(clk, up.issue)
+		first, ok := tokenOf(t, ts)
+		if !ok {
+			return
+		}
+		clk.Advance(2 * time.Hour)
+		tok, ok := tokenOf(t, ts)
+		if !ok {
+			return
+		}
+		if tok.AccessToken == first.AccessToken {
+			t.Errorf("apitest: Token returned %q after its expiry", tok.AccessToken)
+		}
+		if n := up.calls.Load(); n != 2 {
+			t.Errorf("apitest: %d tokens issued across an expiry, want 2", n)
+		}
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		clk := NewFakeClock(conformanceEpoch)
+		up := &tokenUpstream{clk: clk, lifetime: time.Hour}
+		ts := newSource(clk, up.issue)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tokenOf(t, ts)
+			}()
+		}
+		wg.Wait()
+		if n := up.calls.Load(); n < 1 || n > 20 {
+			t.Errorf("apitest: %d tokens issued for 20 concurrent calls", n)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		clk := NewFakeClock(conformanceEpoch)
+		up := &tokenUpstream{clk: clk, lifetime: time.Hour}
+		up.fail.Store(true)
+		ts := newSource(clk, up.issue)
+		if tok, err := ts.Token(context.Background()); err == nil {
+			t.Errorf("apitest: Token returned %v while upstream fails, want an error", tok)
+		}
+		up.fail.Store(false)
+		tokenOf(t, ts)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		clk := NewFakeClock(conformanceEpoch)
+		up := &tokenUpstream{clk: clk, lifetime: time.Hour}
+		ts := newSource(clk, up.issue)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if tok, err := ts.Token(ctx); err == nil {
+			t.Errorf("apitest: Token returned %v for a canceled context, want an error", tok)
+		}
+		tokenOf(t, ts)
+	})
+}
+
+// errUpstream is the failure of a tokenUpstream set to fail.
+var errUpstream = errors.New("apitest: upstream failure")
+
+// tokenUpstream issues numbered tokens expiring after lifetime.
+type tokenUpstream struct {
+	clk      *FakeClock
+	lifetime time.Duration
+	calls    atomic.Int64
+	fail     atomic.Bool
+}
+
+func (u *tokenUpstream) issue(ctx context.Context) (*api.Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if u.fail.Load() {
+		return nil, errUpstream
+	}
+	n := u.calls.Add(1)
+	return &api.Token{AccessToken: fmt.Sprintf("token-%d", n), Expiry: u.clk.Now().Add(u.lifetime)}, nil
+}
+
+// tokenOf gets a token of ts, failing the test unless it's a usable one.
+func tokenOf(t *testing.T, ts api.TokenSource) (*api.Token, bool) {
+	t.Helper()
+	tok, err := ts.Token(context.Background())
+	switch {
+	case err != nil:
+		t.Errorf("apitest: Token: %v", err)
+		return nil, false
+	case tok == nil || tok.AccessToken == "":
+		t.Errorf("apitest: Token returned an empty token")
+		return nil, false
+	}
+	return tok, true
+}
+
+// TestJournal runs the conformance tests of api.Journal against the journals created by
+// newJournal, reading back what they recorded with entries, e.g. for a third-party
+// implementation; the built-in FileJournal passes them. The tests check that the entries
+// recorded concurrently are all kept, in full.
+func TestJournal(t *testing.T, newJournal func() api.Journal, entries func(j api.Journal) []api.JournalEntry) {
+	t.Run("Concurrency", func(t *testing.T) {
+		j := newJournal()
+		want := make(map[string]api.JournalEntry)
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			e := api.JournalEntry{
+				Time:          conformanceEpoch.Add(time.Duration(i) * time.Second),
+				Method:        "POST",
+				URL:           fmt.Sprintf("https://example.com/items/%d", i),
+				RequestSHA256: fmt.Sprintf("%064x", i),
+				RequestSize:   int64(i),
+				Status:        201,
+				ResponseSize:  int64(2 * i),
+			}
+			want[e.URL] = e
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := j.Record(e); err != nil {
+					t.Errorf("apitest: Record: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		got := entries(j)
+		if len(got) != len(want) {
+			t.Errorf("apitest: %d entries recorded, want %d", len(got), len(want))
+		}
+		for _, e := range got {
+			w, ok := want[e.URL]
+			if !ok {
+				t.Errorf("apitest: unexpected entry %+v", e)
+				continue
+			}
+			if !e.Time.Equal(w.Time) {
+				t.Errorf("apitest: entry of %s recorded at %v, want %v", e.URL, e.Time, w.Time)
+			}
+			e.Time = w.Time
+			if e != w {
+				t.Errorf("apitest: entry recorded as %+v, want %+v", e, w)
+			}
+			delete(want, e.URL)
+		}
+	})
+}
+
+// TestTimeoutEstimator runs the conformance tests of api.TimeoutEstimator against the estimators
+// created by newEstimator, e.g. for a third-party implementation; the built-in EWMATimeout passes
+// them. The tests check that the timeouts aren't negative, that they leave room for the latencies
+// usually observed, that the resources are estimated separately, and that the estimator is safe
+// for concurrent use.
+func TestTimeoutEstimator(t *testing.T, newEstimator func() api.TimeoutEstimator) {
+	t.Run("Latencies", func(t *testing.T) {
+		e := newEstimator()
+		cold := e.Timeout("/b")
+		if cold < 0 {
+			t.Errorf("apitest: Timeout returned %v for a new resource", cold)
+		}
+		for i := 0; i < 100; i++ {
+			e.Observe("/a", 200*time.Millisecond)
+		}
+		if d := e.Timeout("/a"); d != 0 && d < 200*time.Millisecond {
+			t.Errorf("apitest: Timeout returned %v after latencies of 200ms", d)
+		}
+		if d := e.Timeout("/b"); d != cold {
+			t.Errorf("apitest: Timeout of another resource changed from %v to %v", cold, d)
+		}
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		e := newEstimator()
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				resource := fmt.Sprintf("/items/%d", i%3)
+				for j := 0; j < 50; j++ {
+					e.Observe(resource, time.Duration(10+j)*time.Millisecond)
+					if d := e.Timeout(resource); d < 0 {
+						t.Errorf("apitest: Timeout returned %v", d)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
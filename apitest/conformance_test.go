@@ -0,0 +1,70 @@
+package apitest
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api"
+)
+
+func TestClientCredentialsConformance(t *testing.T) {
+	TestTokenSource(t, func(clk *FakeClock, upstream TokenFunc) api.TokenSource {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			tok, err := upstream(r.Context())
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client", "error_description": err.Error()})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": tok.AccessToken,
+				"token_type":   "Bearer",
+				"expires_in":   int(tok.Expiry.Sub(clk.Now()) / time.Second),
+			})
+		}))
+		t.Cleanup(srv.Close)
+		return &api.ClientCredentials{TokenURL: srv.URL, ClientID: "id", ClientSecret: "secret", Clock: clk}
+	})
+}
+
+func TestFileJournalConformance(t *testing.T) {
+	var path string
+	TestJournal(t, func() api.Journal {
+		path = filepath.Join(t.TempDir(), "calls.jsonl")
+		j, err := api.NewFileJournal(path, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { j.Close() })
+		return j
+	}, func(j api.Journal) []api.JournalEntry {
+		var entries []api.JournalEntry
+		f, err := os.Open(path)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var e api.JournalEntry
+			if assert.NoError(t, json.Unmarshal(sc.Bytes(), &e)) {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	})
+}
+
+func TestEWMATimeoutConformance(t *testing.T) {
+	TestTimeoutEstimator(t, func() api.TimeoutEstimator {
+		return &api.EWMATimeout{Default: time.Second, Max: time.Minute}
+	})
+}
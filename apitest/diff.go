@@ -0,0 +1,21 @@
+package apitest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/xlab/api"
+)
+
+// AssertRequest reports whether got is the same request as want, failing the test with
+// the differences rendered by api.DiffRequests otherwise, e.g. to compare a request built
+// in code with one known to work in curl.
+func AssertRequest(t testing.TB, want, got *http.Request) bool {
+	t.Helper()
+	d := api.DiffRequests(want, got)
+	if !d.Equal() {
+		t.Errorf("apitest: requests differ (want != got):\n%s", d)
+		return false
+	}
+	return true
+}
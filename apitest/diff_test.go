@@ -0,0 +1,32 @@
+package apitest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordT) Helper() {}
+
+func (t *recordT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertRequest(t *testing.T) {
+	want, _ := http.NewRequest("GET", "http://example.com/items?page=1", nil)
+	got, _ := http.NewRequest("GET", "http://example.com/items?page=2", nil)
+	rt := &recordT{TB: t}
+	assert.False(t, AssertRequest(rt, want, got))
+	assert.Equal(t, []string{"apitest: requests differ (want != got):\nquery[\"page\"]: \"1\" != \"2\""}, rt.errors)
+
+	rt = &recordT{TB: t}
+	assert.True(t, AssertRequest(rt, want, want))
+	assert.Empty(t, rt.errors)
+}
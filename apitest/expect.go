@@ -0,0 +1,215 @@
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/xlab/api"
+)
+
+// Expectation holds the outcome of a call for the assertions of Expect and ExpectResult. Every
+// assertion reports its failure on the test and returns the Expectation, so they can be chained:
+//
+//	resp, err := svc.Do(ctx, req)
+//	apitest.Expect(t, resp, err).
+//		Status(200).
+//		HeaderMatches("Content-Type", "json").
+//		JSONPath("$.items[0].id", 42).
+//		BodyContains(`"ok"`)
+//
+// A call failing with an *api.StatusError is asserted on the status, header and body it holds.
+type Expectation struct {
+	t testing.TB
+	// what names the call in the failure messages, e.g. "GET /items".
+	what   string
+	err    error
+	status int
+	header http.Header
+	body   []byte
+	// hasResponse is false when the call failed without a response.
+	hasResponse bool
+	failed      bool
+}
+
+// Expect returns the Expectation of a call returning resp and err, e.g. those of Api.Do. The
+// body of resp is read and replaced by a copy, so it can still be read afterwards.
+func Expect(t testing.TB, resp *http.Response, err error) *Expectation {
+	e := &Expectation{t: t, err: err, what: "call"}
+	if resp != nil {
+		if resp.Request != nil {
+			e.what = resp.Request.Method + " " + resp.Request.URL.RequestURI()
+		}
+		e.status, e.header, e.hasResponse = resp.StatusCode, resp.Header, true
+		if resp.Body != nil {
+			e.body, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader(e.body))
+		}
+	}
+	e.fromStatusError()
+	return e
+}
+
+// ExpectResult returns the Expectation of a decoding call, e.g. one of Api.DoJSON made with
+// api.WithMeta(meta): the status and header are those of meta, and the body is out encoded back
+// to JSON. A meta left nil only allows the assertions on the body.
+func ExpectResult(t testing.TB, meta *api.ResponseMeta, out interface{}, err error) *Expectation {
+	e := &Expectation{t: t, err: err, what: "call"}
+	if meta != nil && meta.StatusCode != 0 {
+		if meta.URL != nil {
+			e.what = meta.URL.RequestURI()
+		}
+		e.status, e.header, e.hasResponse = meta.StatusCode, meta.Header, true
+	}
+	if err == nil {
+		body, merr := json.Marshal(out)
+		if merr != nil {
+			e.err = merr
+		}
+		e.body, e.hasResponse = body, true
+	}
+	e.fromStatusError()
+	return e
+}
+
+// fromStatusError takes the response of the *api.StatusError the call failed with.
+func (e *Expectation) fromStatusError() {
+	var se *api.StatusError
+	if errors.As(e.err, &se) {
+		e.status, e.header, e.body, e.hasResponse = se.Code, se.Header, se.Body, true
+		e.err = nil
+	}
+}
+
+// Failed reports whether an assertion failed.
+func (e *Expectation) Failed() bool {
+	return e.failed
+}
+
+func (e *Expectation) fail(format string, args ...interface{}) {
+	e.t.Helper()
+	e.failed = true
+	e.t.Errorf("apitest: %s: %s", e.what, fmt.Sprintf(format, args...))
+}
+
+// ok reports whether the call has a response to assert on, failing the test once otherwise.
+func (e *Expectation) ok() bool {
+	e.t.Helper()
+	if e.err != nil {
+		if !e.failed {
+			e.fail("call failed: %v", e.err)
+		}
+		return false
+	}
+	return e.hasResponse
+}
+
+// Status asserts that the response has the status code.
+func (e *Expectation) Status(code int) *Expectation {
+	e.t.Helper()
+	if e.ok() && e.status != code {
+		e.fail("status %d %s, want %d\nbody: %s", e.status, http.StatusText(e.status), code, excerpt(e.body))
+	}
+	return e
+}
+
+// HeaderMatches asserts that the header name of the response matches the regular expression
+// pattern, e.g. HeaderMatches("Content-Type", "json").
+func (e *Expectation) HeaderMatches(name, pattern string) *Expectation {
+	e.t.Helper()
+	if !e.ok() {
+		return e
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		e.fail("header %q: %v", name, err)
+		return e
+	}
+	values, found := e.header[http.CanonicalHeaderKey(name)]
+	switch {
+	case !found:
+		e.fail("header %q is missing, want a match of %q", name, pattern)
+	case !re.MatchString(strings.Join(values, ", ")):
+		e.fail("header %q is %q, want a match of %q", name, strings.Join(values, ", "), pattern)
+	}
+	return e
+}
+
+// BodyContains asserts that the body of the response contains s.
+func (e *Expectation) BodyContains(s string) *Expectation {
+	e.t.Helper()
+	if e.ok() && !bytes.Contains(e.body, []byte(s)) {
+		e.fail("body doesn't contain %q\nbody: %s", s, excerpt(e.body))
+	}
+	return e
+}
+
+// JSONPath asserts that the value at path in the JSON body of the response is want, compared
+// structurally as JSON, so 42 matches 42.0 and the keys of objects aren't ordered. The path is
+// one of api.JSONLookup, like "$.items[0].id" or "/items/0/id". The differences are rendered like
+// those of api.DiffRequests.
+func (e *Expectation) JSONPath(path string, want interface{}) *Expectation {
+	e.t.Helper()
+	if !e.ok() {
+		return e
+	}
+	got, err := api.JSONLookup(e.body, path)
+	if err != nil {
+		e.fail("%v\nbody: %s", err, excerpt(e.body))
+		return e
+	}
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		e.fail("%s: encoding the expected value: %v", path, err)
+		return e
+	}
+	if d := diffJSON(path, wantJSON, got.Raw); !d.Equal() {
+		e.fail("JSON differs at %s (want != got):\n%s", path, d)
+	}
+	return e
+}
+
+// DecodedAs asserts that the body of the response decodes as JSON into out.
+func (e *Expectation) DecodedAs(out interface{}) *Expectation {
+	e.t.Helper()
+	if !e.ok() {
+		return e
+	}
+	if err := json.Unmarshal(e.body, out); err != nil {
+		e.fail("decoding the body as %T: %v\nbody: %s", out, err, excerpt(e.body))
+	}
+	return e
+}
+
+// diffJSON compares the JSON values want and got with api.DiffRequests, renaming the body of
+// the paths of the entries to path.
+func diffJSON(path string, want, got []byte) api.RequestDiff {
+	a, _ := http.NewRequest(http.MethodPost, "http://apitest", bytes.NewReader(want))
+	b, _ := http.NewRequest(http.MethodPost, "http://apitest", bytes.NewReader(got))
+	d := api.DiffRequests(a, b)
+	for i := range d.Entries {
+		if rest, ok := strings.CutPrefix(d.Entries[i].Path, "body"); ok && !strings.HasPrefix(rest, " (") {
+			d.Entries[i].Path = path + rest
+		}
+	}
+	return d
+}
+
+// excerpt quotes up to 256 bytes of body for the failure messages.
+func excerpt(body []byte) string {
+	if len(body) == 0 {
+		return "(empty)"
+	}
+	if len(body) > 256 {
+		return strconv.Quote(string(body[:256])) + fmt.Sprintf("... (%d bytes)", len(body))
+	}
+	return strconv.Quote(string(body))
+}
@@ -0,0 +1,124 @@
+package apitest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api"
+)
+
+func itemsServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/items" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":"not found"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		io.WriteString(w, `{"status":"ok","items":[{"id":42,"tags":["a","b"]},{"id":43,"name":"x y"}],"meta":{"total":2}}`)
+	}))
+}
+
+func TestExpect(t *testing.T) {
+	srv := itemsServer()
+	defer srv.Close()
+	a := api.MustNew(srv.URL)
+	req, _ := a.Request(api.GET, "/items", nil)
+	resp, err := a.Do(context.Background(), req)
+	var out struct {
+		Items []struct{ ID int }
+	}
+	rt := &recordT{TB: t}
+	e := Expect(rt, resp, err).
+		Status(200).
+		HeaderMatches("content-type", "^application/json").
+		JSONPath("$.items[0].id", 42).
+		JSONPath("$.items[0].tags", []string{"a", "b"}).
+		JSONPath(`$.items[1]["name"]`, "x y").
+		JSONPath("$.meta", map[string]float64{"total": 2.0}).
+		BodyContains(`"ok"`).
+		DecodedAs(&out)
+	assert.Empty(t, rt.errors)
+	assert.False(t, e.Failed())
+	assert.Equal(t, []struct{ ID int }{{42}, {43}}, out.Items)
+	// The body can still be read.
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `"status":"ok"`)
+}
+
+func TestExpectFailures(t *testing.T) {
+	srv := itemsServer()
+	defer srv.Close()
+	a := api.MustNew(srv.URL)
+	req, _ := a.Request(api.GET, "/items", nil)
+	resp, err := a.Do(context.Background(), req)
+	rt := &recordT{TB: t}
+	var out []int
+	e := Expect(rt, resp, err).
+		Status(201).
+		HeaderMatches("Content-Type", "xml").
+		HeaderMatches("ETag", ".").
+		JSONPath("$.items[0]", map[string]interface{}{"id": 41, "tags": []string{"a"}}).
+		JSONPath("$.items[5].id", 1).
+		JSONPath("$.status.code", 1).
+		JSONPath("$.items[x]", 1).
+		BodyContains("fail").
+		DecodedAs(&out)
+	assert.True(t, e.Failed())
+	const body = `"{\"status\":\"ok\",\"items\":[{\"id\":42,\"tags\":[\"a\",\"b\"]},{\"id\":43,\"name\":\"x y\"}],\"meta\":{\"total\":2}}"`
+	assert.Equal(t, []string{
+		"apitest: GET /items: status 200 OK, want 201\nbody: " + body,
+		`apitest: GET /items: header "Content-Type" is "application/json; charset=utf-8", want a match of "xml"`,
+		`apitest: GET /items: header "ETag" is missing, want a match of "."`,
+		"apitest: GET /items: JSON differs at $.items[0] (want != got):\n$.items[0].id: 41 != 42\n$.items[0].tags[1]: (missing) != \"b\"",
+		`apitest: GET /items: api: json path "$.items[5].id": index 5 out of range of 2 elements` + "\nbody: " + body,
+		`apitest: GET /items: api: json path "$.status.code": no key "code" in a string` + "\nbody: " + body,
+		`apitest: GET /items: api: json path "$.items[x]": invalid path: bad index [x]` + "\nbody: " + body,
+		"apitest: GET /items: body doesn't contain \"fail\"\nbody: " + body,
+		"apitest: GET /items: decoding the body as *[]int: json: cannot unmarshal object into Go value of type []int\nbody: " + body,
+	}, rt.errors)
+}
+
+func TestExpectStatusError(t *testing.T) {
+	srv := itemsServer()
+	defer srv.Close()
+	a := api.MustNew(srv.URL)
+	var meta api.ResponseMeta
+	err := a.DoJSON(context.Background(), api.GET, "/missing", nil, nil, api.WithMeta(&meta))
+	rt := &recordT{TB: t}
+	ExpectResult(rt, &meta, nil, err).Status(404).JSONPath("$.error", "not found")
+	assert.Empty(t, rt.errors)
+	ExpectResult(rt, &meta, nil, err).Status(200)
+	assert.Equal(t, []string{"apitest: /missing: status 404 Not Found, want 200\nbody: \"{\\\"error\\\":\\\"not found\\\"}\""}, rt.errors)
+}
+
+func TestExpectResult(t *testing.T) {
+	srv := itemsServer()
+	defer srv.Close()
+	a := api.MustNew(srv.URL)
+	var meta api.ResponseMeta
+	var out struct {
+		Items []struct {
+			ID int `json:"id"`
+		} `json:"items"`
+	}
+	err := a.DoJSON(context.Background(), api.GET, "/items", nil, &out, api.WithMeta(&meta))
+	rt := &recordT{TB: t}
+	ExpectResult(rt, &meta, &out, err).Status(200).HeaderMatches("Content-Type", "json").JSONPath("$.items[1].id", 43)
+	assert.Empty(t, rt.errors)
+
+	// The calls failing without a response fail every assertion once.
+	srv.Close()
+	req, _ := a.Request(api.GET, "/items", nil)
+	resp, err := a.Do(context.Background(), req)
+	e := Expect(rt, resp, err).Status(200).BodyContains("ok")
+	assert.True(t, e.Failed())
+	if assert.Len(t, rt.errors, 1) {
+		assert.Contains(t, rt.errors[0], "apitest: call: call failed: ")
+	}
+}
@@ -0,0 +1,179 @@
+// Package apitest provides helpers for testing code built on top of the api package.
+package apitest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xlab/api"
+)
+
+// HAROption configures the matching of ServeHAR.
+type HAROption func(*harServer)
+
+// IgnoreQuery makes ServeHAR ignore the named query parameters when matching requests,
+// e.g. timestamps or nonces that differ between the recording and the replay.
+func IgnoreQuery(names ...string) HAROption {
+	return func(s *harServer) {
+		for _, name := range names {
+			s.ignore[name] = true
+		}
+	}
+}
+
+// ServeHAR returns a handler replaying the responses recorded in a HAR document (see api.HARRecorder).
+// Requests are matched to entries by method, path and query, regardless of the query parameters order.
+// If several entries match, they are served in the recorded order, the last one repeating.
+// Unmatched requests get a 501 response listing the closest recorded candidates.
+func ServeHAR(harBytes []byte, opts ...HAROption) (http.Handler, error) {
+	var har api.HAR
+	if err := json.Unmarshal(harBytes, &har); err != nil {
+		return nil, fmt.Errorf("apitest: invalid HAR: %w", err)
+	}
+	s := &harServer{ignore: make(map[string]bool), served: make(map[int]bool)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	for i, e := range har.Log.Entries {
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("apitest: HAR entry %d: %w", i, err)
+		}
+		body := []byte(e.Response.Content.Text)
+		if e.Response.Content.Encoding == "base64" {
+			if body, err = base64.StdEncoding.DecodeString(e.Response.Content.Text); err != nil {
+				return nil, fmt.Errorf("apitest: HAR entry %d: %w", i, err)
+			}
+		}
+		s.entries = append(s.entries, harEntry{
+			method: strings.ToUpper(e.Request.Method),
+			path:   u.Path,
+			query:  u.Query(),
+			resp:   e.Response,
+			body:   body,
+		})
+	}
+	return s, nil
+}
+
+type harEntry struct {
+	method string
+	path   string
+	query  url.Values
+	resp   api.HARResponse
+	body   []byte
+}
+
+type harServer struct {
+	ignore  map[string]bool
+	entries []harEntry
+
+	mu     sync.Mutex
+	served map[int]bool
+}
+
+func (s *harServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.mu.Lock()
+	match := -1
+	for i, e := range s.entries {
+		if e.method != r.Method || e.path != r.URL.Path || !s.sameQuery(e.query, query) {
+			continue
+		}
+		match = i
+		if !s.served[i] {
+			break
+		}
+	}
+	if match >= 0 {
+		s.served[match] = true
+	}
+	s.mu.Unlock()
+
+	if match < 0 {
+		s.notFound(w, r, query)
+		return
+	}
+	e := s.entries[match]
+	for _, h := range e.resp.Headers {
+		switch http.CanonicalHeaderKey(h.Name) {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		w.Header().Add(h.Name, h.Value)
+	}
+	if e.resp.Content.MimeType != "" && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", e.resp.Content.MimeType)
+	}
+	w.WriteHeader(e.resp.Status)
+	w.Write(e.body)
+}
+
+func (s *harServer) sameQuery(a, b url.Values) bool {
+	return s.encodeQuery(a) == s.encodeQuery(b)
+}
+
+// encodeQuery encodes the query without the ignored parameters and with sorted values.
+func (s *harServer) encodeQuery(q url.Values) string {
+	filtered := url.Values{}
+	for k, vs := range q {
+		if s.ignore[k] {
+			continue
+		}
+		vs = append([]string(nil), vs...)
+		sort.Strings(vs)
+		filtered[k] = vs
+	}
+	return filtered.Encode()
+}
+
+// notFound responds with 501 and up to 3 candidates sorted by similarity with the request.
+func (s *harServer) notFound(w http.ResponseWriter, r *http.Request, query url.Values) {
+	type candidate struct {
+		score int
+		desc  string
+	}
+	var candidates []candidate
+	for _, e := range s.entries {
+		score := 0
+		if e.method == r.Method {
+			score += 4
+		}
+		if e.path == r.URL.Path {
+			score += 8
+		} else if strings.HasPrefix(r.URL.Path, e.path) || strings.HasPrefix(e.path, r.URL.Path) {
+			score += 2
+		}
+		for k := range query {
+			if _, ok := e.query[k]; ok {
+				score++
+			}
+		}
+		desc := e.method + " " + e.path
+		if q := s.encodeQuery(e.query); q != "" {
+			desc += "?" + q
+		}
+		candidates = append(candidates, candidate{score, desc})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotImplemented)
+	fmt.Fprintf(w, "apitest: no HAR entry for %s %s\n", r.Method, r.URL.RequestURI())
+	if len(candidates) > 0 {
+		fmt.Fprintln(w, "closest candidates:")
+	}
+	for i, c := range candidates {
+		if i == 3 {
+			break
+		}
+		fmt.Fprintf(w, "  %s\n", c.desc)
+	}
+}
@@ -0,0 +1,74 @@
+package apitest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api"
+)
+
+// recordHAR records a couple of calls against a live server.
+func recordHAR(t *testing.T) []byte {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Served-By", "origin")
+		switch r.URL.Path {
+		case "/items":
+			w.Write([]byte(`[{"id": 1}, {"id": 2}]`))
+		case "/items/1":
+			w.Write([]byte(`{"id": 1}`))
+		}
+	}))
+	defer srv.Close()
+
+	rec := api.NewHARRecorder(nil)
+	a := api.MustNew(srv.URL)
+	a.Client = &http.Client{Transport: rec}
+	var out interface{}
+	args := url.Values{"sort": {"name"}, "limit": {"2"}, "ts": {"1"}}
+	assert.NoError(t, a.DoJSON(context.Background(), api.GET, "/items", args, &out))
+	assert.NoError(t, a.DoJSON(context.Background(), api.GET, "/items/1", nil, &out))
+	var buf bytes.Buffer
+	assert.NoError(t, rec.WriteHAR(&buf))
+	return buf.Bytes()
+}
+
+func TestServeHAR(t *testing.T) {
+	h, err := ServeHAR(recordHAR(t), IgnoreQuery("ts"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/items?ts=99&limit=2&sort=name")
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "origin", resp.Header.Get("X-Served-By"))
+	assert.Equal(t, `[{"id": 1}, {"id": 2}]`, string(body))
+
+	resp, err = http.Get(srv.URL + "/items/2")
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	assert.Contains(t, string(body), "no HAR entry for GET /items/2")
+	assert.Contains(t, string(body), "GET /items/1")
+}
+
+func TestServeHARInvalid(t *testing.T) {
+	_, err := ServeHAR([]byte("{"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,365 @@
+package apitest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xlab/api"
+	"github.com/xlab/api/internal/clock"
+)
+
+// ReplayEntry is a recorded call of a ReplaySource.
+type ReplayEntry struct {
+	// Time is when the request was sent.
+	Time   time.Time
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+	// Status is the status code of the recorded response, 0 if none was received.
+	Status int
+	// Latency is the recorded duration of the call, 0 if unknown.
+	Latency time.Duration
+}
+
+// ReplaySource provides the recorded calls of Replay.
+type ReplaySource interface {
+	Entries() ([]ReplayEntry, error)
+}
+
+// ReplaySourceFunc is a ReplaySource function.
+type ReplaySourceFunc func() ([]ReplayEntry, error)
+
+func (f ReplaySourceFunc) Entries() ([]ReplayEntry, error) { return f() }
+
+// HARSource returns the ReplaySource of the entries of a HAR document, e.g. written by
+// api.HARRecorder.WriteHAR.
+func HARSource(harBytes []byte) ReplaySource {
+	return ReplaySourceFunc(func() ([]ReplayEntry, error) {
+		var har api.HAR
+		if err := json.Unmarshal(harBytes, &har); err != nil {
+			return nil, fmt.Errorf("apitest: invalid HAR: %w", err)
+		}
+		entries := make([]ReplayEntry, 0, len(har.Log.Entries))
+		for i, e := range har.Log.Entries {
+			started, err := time.Parse(time.RFC3339Nano, e.StartedDateTime)
+			if err != nil {
+				return nil, fmt.Errorf("apitest: HAR entry %d: %w", i, err)
+			}
+			entry := ReplayEntry{
+				Time:    started,
+				Method:  strings.ToUpper(e.Request.Method),
+				URL:     e.Request.URL,
+				Header:  http.Header{},
+				Status:  e.Response.Status,
+				Latency: time.Duration(e.Time * float64(time.Millisecond)),
+			}
+			for _, h := range e.Request.Headers {
+				entry.Header.Add(h.Name, h.Value)
+			}
+			if p := e.Request.PostData; p != nil {
+				entry.Body = []byte(p.Text)
+				if p.Comment == "text is base64 encoded" {
+					if entry.Body, err = base64.StdEncoding.DecodeString(p.Text); err != nil {
+						return nil, fmt.Errorf("apitest: HAR entry %d: %w", i, err)
+					}
+				}
+			}
+			entries = append(entries, entry)
+		}
+		return entries, nil
+	})
+}
+
+// JournalSource returns the ReplaySource of the api.JournalEntry values, one JSON object per line,
+// of a file written by api.FileJournal. A journal keeps no payloads nor headers: the calls are
+// replayed without a body, and their recorded latency is unknown.
+func JournalSource(r io.Reader) ReplaySource {
+	return ReplaySourceFunc(func() ([]ReplayEntry, error) {
+		var entries []ReplayEntry
+		sc := bufio.NewScanner(r)
+		sc.Buffer(nil, 1<<20)
+		for n := 1; sc.Scan(); n++ {
+			if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+				continue
+			}
+			var e api.JournalEntry
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				return nil, fmt.Errorf("apitest: journal line %d: %w", n, err)
+			}
+			entries = append(entries, ReplayEntry{Time: e.Time, Method: e.Method, URL: e.URL, Header: http.Header{}, Status: e.Status})
+		}
+		return entries, sc.Err()
+	})
+}
+
+// ReplayWrites is what Replay does with the calls of other methods than GET, HEAD and OPTIONS.
+type ReplayWrites int
+
+const (
+	// SkipWrites leaves them out.
+	SkipWrites ReplayWrites = iota
+	// SendWrites replays them like the reads.
+	SendWrites
+	// SandboxWrites replays them against ReplayOptions.Sandbox.
+	SandboxWrites
+)
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// Hosts maps the recorded hosts, e.g. "api.example.com", to the base URLs their calls are
+	// replayed against, e.g. "http://localhost:8080"; the calls to the other hosts are replayed
+	// against the BaseURI of the Api. Only the scheme and host of the base URLs are taken.
+	Hosts map[string]string
+	// Header replaces the recorded headers of the same names, e.g. the credentials of the
+	// environment replayed against; the recorded ones are redacted anyway.
+	Header http.Header
+	// Speed is the time compression factor: 10 sends the calls ten times as fast as recorded,
+	// keeping the intervals between them in proportion. 1 if zero; a negative Speed sends them
+	// as fast as Concurrency allows.
+	Speed float64
+	// Concurrency is the maximum number of calls in flight, 8 if zero. A call due while the
+	// maximum is reached waits for a slot, and is sent late.
+	Concurrency int
+	Writes      ReplayWrites
+	// Sandbox is the base URL the writes are replayed against with SandboxWrites.
+	Sandbox string
+	// Clock is the source of time of the pacing and the latencies, the real time if nil.
+	Clock api.Clock
+}
+
+// ReplayResult is the outcome of the replay of an entry.
+type ReplayResult struct {
+	Entry ReplayEntry
+	// Offset is when the call was sent since the start of the replay, Late how much later than
+	// its scaled recorded time.
+	Offset, Late time.Duration
+	// Status is the status code of the response, 0 for the calls failed without one.
+	Status  int
+	Latency time.Duration
+	Err     error
+}
+
+// LatencySummary summarizes latencies.
+type LatencySummary struct {
+	Count              int
+	P50, P90, P99, Max time.Duration
+}
+
+func summarize(latencies []time.Duration) LatencySummary {
+	s := LatencySummary{Count: len(latencies)}
+	if len(latencies) == 0 {
+		return s
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	at := func(q float64) time.Duration {
+		i := int(q*float64(len(latencies))+0.5) - 1
+		if i < 0 {
+			i = 0
+		}
+		return latencies[i]
+	}
+	s.P50, s.P90, s.P99, s.Max = at(0.5), at(0.9), at(0.99), latencies[len(latencies)-1]
+	return s
+}
+
+func (s LatencySummary) String() string {
+	return fmt.Sprintf("p50 %v, p90 %v, p99 %v, max %v (%d calls)", s.P50, s.P90, s.P99, s.Max, s.Count)
+}
+
+// ReplayReport is the outcome of Replay, compared with the recorded calls.
+type ReplayReport struct {
+	// Results are those of the replayed entries, in the recorded order.
+	Results []ReplayResult
+	// Skipped is the number of writes left out by SkipWrites.
+	Skipped int
+	// Failed is the number of calls failed without a response.
+	Failed int
+	// Statuses and Recorded count the status codes of the replayed calls and of their recordings.
+	Statuses, Recorded map[int]int
+	// Mismatches is the number of calls whose status code differs from the recorded one.
+	Mismatches int
+	// Latency summarizes the latencies of the replayed calls answered, RecordedLatency the
+	// recorded ones of the same calls, when known.
+	Latency, RecordedLatency LatencySummary
+	// Duration is how long the replay took.
+	Duration time.Duration
+}
+
+func (r *ReplayReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "replayed %d calls in %v, %d skipped, %d failed, %d status mismatches\n", len(r.Results), r.Duration, r.Skipped, r.Failed, r.Mismatches)
+	codes := make([]int, 0, len(r.Statuses)+len(r.Recorded))
+	for code := range r.Statuses {
+		codes = append(codes, code)
+	}
+	for code := range r.Recorded {
+		if _, ok := r.Statuses[code]; !ok {
+			codes = append(codes, code)
+		}
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(&b, "  status %d: %d (recorded %d)\n", code, r.Statuses[code], r.Recorded[code])
+	}
+	fmt.Fprintf(&b, "  latency: %v\n  recorded: %v", r.Latency, r.RecordedLatency)
+	return b.String()
+}
+
+// Replay sends the calls recorded in source through a, e.g. to run a load test with the mix and
+// timing of the production traffic of a journal or a HAR capture. The calls are sent in the
+// recorded order at their recorded times, scaled by opts.Speed, with at most opts.Concurrency
+// of them in flight, and their status codes and latencies are reported next to the recorded
+// ones. The responses are read in full. The replay stops early, with the error of ctx, when ctx
+// is done; the calls failing don't stop it.
+func Replay(ctx context.Context, a *api.Api, source ReplaySource, opts ReplayOptions) (*ReplayReport, error) {
+	entries, err := source.Entries()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	clk := opts.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+	speed := opts.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+	base := a.BaseURI
+	report := &ReplayReport{Statuses: map[int]int{}, Recorded: map[int]int{}}
+	results := make([]*ReplayResult, 0, len(entries))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	start := clk.Now()
+	var stopped error
+	for _, e := range entries {
+		if !replayRead(e.Method) && opts.Writes == SkipWrites {
+			report.Skipped++
+			continue
+		}
+		req, err := replayRequest(ctx, e, base, opts)
+		if err != nil {
+			return nil, err
+		}
+		due := time.Duration(0)
+		if speed > 0 {
+			due = time.Duration(float64(e.Time.Sub(entries[0].Time)) / speed)
+		}
+		if wait := due - clk.Now().Sub(start); wait > 0 {
+			if stopped = clk.Sleep(ctx, wait); stopped != nil {
+				break
+			}
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			stopped = ctx.Err()
+		}
+		if stopped != nil {
+			break
+		}
+		r := &ReplayResult{Entry: e, Offset: clk.Now().Sub(start)}
+		if r.Late = r.Offset - due; r.Late < 0 {
+			r.Late = 0
+		}
+		results = append(results, r)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			sent := clk.Now()
+			resp, err := a.Do(ctx, req)
+			if err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				r.Status = resp.StatusCode
+			}
+			r.Latency, r.Err = clk.Now().Sub(sent), err
+		}()
+	}
+	wg.Wait()
+	report.Duration = clk.Now().Sub(start)
+	var latencies, recorded []time.Duration
+	for _, r := range results {
+		report.Results = append(report.Results, *r)
+		report.Recorded[r.Entry.Status]++
+		if r.Err != nil {
+			report.Failed++
+			continue
+		}
+		report.Statuses[r.Status]++
+		if r.Status != r.Entry.Status {
+			report.Mismatches++
+		}
+		latencies = append(latencies, r.Latency)
+		if r.Entry.Latency > 0 {
+			recorded = append(recorded, r.Entry.Latency)
+		}
+	}
+	report.Latency, report.RecordedLatency = summarize(latencies), summarize(recorded)
+	return report, stopped
+}
+
+// replayRead reports whether method doesn't change the resources.
+func replayRead(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// replayRequest returns the request replaying e against the sandbox of opts for a write, or
+// else the base URL of opts.Hosts for its host, or else base.
+func replayRequest(ctx context.Context, e ReplayEntry, base *url.URL, opts ReplayOptions) (*http.Request, error) {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return nil, fmt.Errorf("apitest: replayed URL %q: %w", e.URL, err)
+	}
+	target := base
+	if mapped, ok := opts.Hosts[u.Host]; ok {
+		if target, err = url.Parse(mapped); err != nil {
+			return nil, fmt.Errorf("apitest: base URL of %s: %w", u.Host, err)
+		}
+	}
+	if !replayRead(e.Method) && opts.Writes == SandboxWrites {
+		if target, err = url.Parse(opts.Sandbox); err != nil {
+			return nil, fmt.Errorf("apitest: invalid sandbox: %w", err)
+		}
+	}
+	u.Scheme, u.Host = target.Scheme, target.Host
+	req, err := http.NewRequestWithContext(ctx, e.Method, u.String(), bytes.NewReader(e.Body))
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Body) == 0 {
+		req.Body, req.GetBody, req.ContentLength = http.NoBody, nil, 0
+	}
+	for k, vs := range e.Header {
+		switch http.CanonicalHeaderKey(k) {
+		case "Host", "Content-Length", "Transfer-Encoding", "Connection", "Accept-Encoding":
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(k)] = vs
+	}
+	for k, vs := range opts.Header {
+		req.Header[http.CanonicalHeaderKey(k)] = vs
+	}
+	return req, nil
+}
@@ -0,0 +1,146 @@
+package apitest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api"
+)
+
+const replayHAR = `{"log": {"version": "1.2", "entries": [
+	{"startedDateTime": "2020-01-01T10:00:00Z", "time": 120,
+	 "request": {"method": "GET", "url": "https://api.example.com/items?page=1", "headers": [{"name": "Authorization", "value": "***"}, {"name": "Accept", "value": "application/json"}]},
+	 "response": {"status": 200}},
+	{"startedDateTime": "2020-01-01T10:00:01Z", "time": 80,
+	 "request": {"method": "GET", "url": "https://api.example.com/items/1", "headers": []},
+	 "response": {"status": 200}},
+	{"startedDateTime": "2020-01-01T10:00:02Z", "time": 200,
+	 "request": {"method": "POST", "url": "https://api.example.com/items", "headers": [{"name": "Content-Type", "value": "application/json"}], "postData": {"mimeType": "application/json", "text": "{\"name\": \"x\"}"}},
+	 "response": {"status": 201}},
+	{"startedDateTime": "2020-01-01T10:00:05Z", "time": 40,
+	 "request": {"method": "GET", "url": "https://api.example.com/missing", "headers": []},
+	 "response": {"status": 404}}
+]}}`
+
+// replayServer records the calls it gets, answering the POSTs with 201 and the others with 200.
+type replayServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	calls []string
+	auth  []string
+}
+
+func newReplayServer() *replayServer {
+	s := &replayServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.calls = append(s.calls, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+string(body)))
+		s.auth = append(s.auth, r.Header.Get("Authorization"))
+		s.mu.Unlock()
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(`{}`))
+	}))
+	return s
+}
+
+func TestReplay(t *testing.T) {
+	s := newReplayServer()
+	defer s.Close()
+	clk := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	clk.AutoAdvance(true)
+	a := api.MustNew("http://unused.invalid")
+	report, err := Replay(context.Background(), a, HARSource([]byte(replayHAR)), ReplayOptions{
+		Hosts:       map[string]string{"api.example.com": s.URL},
+		Header:      http.Header{"Authorization": {"Bearer test"}},
+		Speed:       10,
+		Concurrency: 1,
+		Clock:       clk,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The writes are left out.
+	assert.Equal(t, []string{"GET /items?page=1", "GET /items/1", "GET /missing"}, s.calls)
+	assert.Equal(t, []string{"Bearer test", "Bearer test", "Bearer test"}, s.auth)
+	var offsets []time.Duration
+	for _, r := range report.Results {
+		offsets = append(offsets, r.Offset)
+	}
+	assert.Equal(t, []time.Duration{0, 100 * time.Millisecond, 500 * time.Millisecond}, offsets)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 0, report.Failed)
+	assert.Equal(t, map[int]int{200: 3}, report.Statuses)
+	assert.Equal(t, map[int]int{200: 2, 404: 1}, report.Recorded)
+	assert.Equal(t, 1, report.Mismatches)
+	assert.Equal(t, 3, report.Latency.Count)
+	assert.Equal(t, LatencySummary{Count: 3, P50: 80 * time.Millisecond, P90: 120 * time.Millisecond, P99: 120 * time.Millisecond, Max: 120 * time.Millisecond}, report.RecordedLatency)
+	assert.Contains(t, report.String(), "replayed 3 calls in 500ms, 1 skipped, 0 failed, 1 status mismatches\n  status 200: 3 (recorded 2)\n  status 404: 0 (recorded 1)\n")
+}
+
+func TestReplayWrites(t *testing.T) {
+	s, sandbox := newReplayServer(), newReplayServer()
+	defer s.Close()
+	defer sandbox.Close()
+	a := api.MustNew(s.URL)
+	report, err := Replay(context.Background(), a, HARSource([]byte(replayHAR)), ReplayOptions{Speed: -1, Concurrency: 1, Writes: SandboxWrites, Sandbox: sandbox.URL})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"GET /items?page=1", "GET /items/1", "GET /missing"}, s.calls)
+	assert.Equal(t, []string{`POST /items {"name": "x"}`}, sandbox.calls)
+	assert.Equal(t, map[int]int{200: 3, 201: 1}, report.Statuses)
+	assert.Equal(t, 0, report.Skipped)
+	assert.Less(t, report.Duration, time.Second)
+
+	// SendWrites sends them along the reads.
+	s.calls = nil
+	_, err = Replay(context.Background(), a, HARSource([]byte(replayHAR)), ReplayOptions{Speed: -1, Concurrency: 1, Writes: SendWrites})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /items?page=1", "GET /items/1", `POST /items {"name": "x"}`, "GET /missing"}, s.calls)
+}
+
+func TestReplayConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if inFlight++; inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	var journal strings.Builder
+	for i := 0; i < 12; i++ {
+		journal.WriteString(`{"time": "2020-01-01T10:00:00Z", "method": "GET", "url": "https://api.example.com/ping", "status": 200}` + "\n")
+	}
+	report, err := Replay(context.Background(), api.MustNew(srv.URL), JournalSource(strings.NewReader(journal.String())), ReplayOptions{Concurrency: 3})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[int]int{200: 12}, report.Statuses)
+	assert.Equal(t, 0, report.RecordedLatency.Count)
+	assert.LessOrEqual(t, peak, 3)
+	assert.Greater(t, peak, 1)
+
+	// A canceled replay stops.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = Replay(ctx, api.MustNew(srv.URL), JournalSource(strings.NewReader(journal.String())), ReplayOptions{Speed: -1, Concurrency: 1})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.LessOrEqual(t, len(report.Results), 1)
+}
@@ -0,0 +1,283 @@
+package apitest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Scenario is a test server scripting the responses of its routes over the time of a FakeClock,
+// the one the client-side features are given, so the tests advancing it have the client and the
+// server agree on the time, e.g. on when a token expires or when a Retry-After is over:
+//
+//	clk := apitest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+//	sc := apitest.NewScenario(clk)
+//	sc.On("GET", "/items").
+//		Respond(503, "").RetryAfter(30 * time.Second).Times(1).
+//		Then().RespondJSON(200, `[]`)
+//	srv := sc.Server(t)
+//	...
+//	sc.AssertSequence(t, "GET /items", "GET /items")
+//	sc.AssertOffsets(t, time.Second, 0, 30*time.Second)
+//
+// The responses carry the Date of the clock. The requests are recorded for the assertions.
+type Scenario struct {
+	clk   *FakeClock
+	start time.Time
+
+	mu       sync.Mutex
+	routes   []*Route
+	requests []ScenarioRequest
+}
+
+// NewScenario creates a Scenario over clk, the offsets of its requests being from clk.Now().
+func NewScenario(clk *FakeClock) *Scenario {
+	return &Scenario{clk: clk, start: clk.Now()}
+}
+
+// Route is the script of the responses to the requests with a method and path, see Scenario.On.
+type Route struct {
+	s            *Scenario
+	method, path string
+	steps        []*Step
+	// cur is the index of the step serving the requests, served the number of requests served.
+	cur, served int
+}
+
+// Step is a response of a Route, served until its Times or For are over, the next step serving
+// the next requests. The last step of a route keeps serving them, unless it has Times or For:
+// the requests past them get a 501.
+type Step struct {
+	r      *Route
+	status int
+	header http.Header
+	// dates are the headers set to the time they're served plus their offsets.
+	dates map[string]time.Duration
+	body  string
+	times int
+	dur   time.Duration
+	// start is when the step started serving, served the number of requests it served.
+	start  time.Time
+	served int
+}
+
+// ScenarioRequest is a request received by a Scenario.
+type ScenarioRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+	// At is the time of the clock when the request was received, Offset the time since the
+	// Scenario was created.
+	At     time.Time
+	Offset time.Duration
+	// Step is the 0-based index of the step of the route serving the request, -1 if none did.
+	Step   int
+	Status int
+}
+
+// String returns the method and path of the request, e.g. "GET /items".
+func (r ScenarioRequest) String() string {
+	return r.Method + " " + r.Path
+}
+
+// On returns the route of the requests with the method and path, the query being ignored. The
+// routes are matched in the order they were declared; the unmatched requests get a 501.
+func (s *Scenario) On(method, path string) *Route {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &Route{s: s, method: strings.ToUpper(method), path: path}
+	s.routes = append(s.routes, r)
+	return r
+}
+
+// Respond appends a step responding with the status and body. In the body, "{{n}}" is replaced by
+// the 1-based number of the request of the route, e.g. to issue distinct tokens.
+func (r *Route) Respond(status int, body string) *Step {
+	st := &Step{r: r, status: status, header: make(http.Header), body: body}
+	r.s.mu.Lock()
+	r.steps = append(r.steps, st)
+	r.s.mu.Unlock()
+	return st
+}
+
+// RespondJSON is Respond with the application/json content type.
+func (r *Route) RespondJSON(status int, body string) *Step {
+	return r.Respond(status, body).Header("Content-Type", "application/json")
+}
+
+// Header sets a header of the response.
+func (st *Step) Header(name, value string) *Step {
+	st.header.Set(name, value)
+	return st
+}
+
+// DateHeader sets a header of the response to the HTTP date d after the time it's served, e.g.
+// the Expires header, d being negative for the dates in the past.
+func (st *Step) DateHeader(name string, d time.Duration) *Step {
+	if st.dates == nil {
+		st.dates = make(map[string]time.Duration)
+	}
+	st.dates[http.CanonicalHeaderKey(name)] = d
+	return st
+}
+
+// RetryAfter sets the Retry-After header of the response to d, in seconds.
+func (st *Step) RetryAfter(d time.Duration) *Step {
+	return st.Header("Retry-After", strconv.Itoa(int(d/time.Second)))
+}
+
+// Times makes the step serve n requests.
+func (st *Step) Times(n int) *Step {
+	st.times = n
+	return st
+}
+
+// For makes the step serve the requests for d from the first one it serves, e.g. a token being
+// accepted for 60s. The next step starts d after it, whenever its first request comes.
+func (st *Step) For(d time.Duration) *Step {
+	st.dur = d
+	return st
+}
+
+// Then returns the route, to append the next step.
+func (st *Step) Then() *Route {
+	return st.r
+}
+
+// Server starts an httptest server serving the Scenario, closed when the test ends.
+func (s *Scenario) Server(t testing.TB) *httptest.Server {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (s *Scenario) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	now := s.clk.Now()
+	req := ScenarioRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body, At: now, Offset: now.Sub(s.start), Step: -1}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var route *Route
+	for _, rt := range s.routes {
+		if rt.method == r.Method && rt.path == r.URL.Path {
+			route = rt
+			break
+		}
+	}
+	var st *Step
+	if route != nil {
+		st, req.Step = route.next(now)
+	}
+	w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+	switch {
+	case route == nil:
+		req.Status = http.StatusNotImplemented
+		http.Error(w, fmt.Sprintf("apitest: no route for %s %s", r.Method, r.URL.Path), req.Status)
+	case st == nil:
+		req.Status = http.StatusNotImplemented
+		http.Error(w, fmt.Sprintf("apitest: the script of %s %s is over", r.Method, r.URL.Path), req.Status)
+	default:
+		for name, vs := range st.header {
+			w.Header()[name] = vs
+		}
+		for name, d := range st.dates {
+			w.Header().Set(name, now.Add(d).UTC().Format(http.TimeFormat))
+		}
+		req.Status = st.status
+		w.WriteHeader(st.status)
+		io.WriteString(w, strings.ReplaceAll(st.body, "{{n}}", strconv.Itoa(route.served)))
+	}
+	s.requests = append(s.requests, req)
+}
+
+// next returns the step serving a request at now and its index, nil if the script is over.
+func (r *Route) next(now time.Time) (*Step, int) {
+	for r.cur < len(r.steps) {
+		st := r.steps[r.cur]
+		if st.start.IsZero() {
+			st.start = now
+		}
+		timesOver := st.times > 0 && st.served >= st.times
+		durOver := st.dur > 0 && !now.Before(st.start.Add(st.dur))
+		if !timesOver && !durOver {
+			st.served++
+			r.served++
+			return st, r.cur
+		}
+		if r.cur++; durOver && !timesOver && r.cur < len(r.steps) {
+			r.steps[r.cur].start = st.start.Add(st.dur)
+		}
+	}
+	return nil, -1
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Scenario) Requests() []ScenarioRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ScenarioRequest(nil), s.requests...)
+}
+
+// AssertSequence reports whether the requests received are want, e.g. "POST /token", in order,
+// failing the test with the requests otherwise.
+func (s *Scenario) AssertSequence(t testing.TB, want ...string) bool {
+	t.Helper()
+	reqs := s.Requests()
+	got := make([]string, len(reqs))
+	for i, r := range reqs {
+		got[i] = r.String()
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("apitest: requests\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+		return false
+	}
+	return true
+}
+
+// AssertOffsets reports whether the requests were received at the want offsets from the start of
+// the Scenario, in order, both rounded down to a multiple of bucket, failing the test with the
+// offsets otherwise.
+func (s *Scenario) AssertOffsets(t testing.TB, bucket time.Duration, want ...time.Duration) bool {
+	t.Helper()
+	reqs := s.Requests()
+	ok := len(reqs) == len(want)
+	got := make([]string, len(reqs))
+	for i, r := range reqs {
+		got[i] = r.Offset.Truncate(bucket).String()
+		if ok && r.Offset.Truncate(bucket) != want[i].Truncate(bucket) {
+			ok = false
+		}
+	}
+	if !ok {
+		ws := make([]string, len(want))
+		for i, d := range want {
+			ws[i] = d.Truncate(bucket).String()
+		}
+		t.Errorf("apitest: requests at %s, want %s", strings.Join(got, ", "), strings.Join(ws, ", "))
+	}
+	return ok
+}
+
+// AssertHeader reports whether the i-th request received, 0-based, has the header name set to
+// want, failing the test otherwise.
+func (s *Scenario) AssertHeader(t testing.TB, i int, name, want string) bool {
+	t.Helper()
+	reqs := s.Requests()
+	if i >= len(reqs) {
+		t.Errorf("apitest: request %d of %d", i, len(reqs))
+		return false
+	}
+	if got := reqs[i].Header.Get(name); got != want {
+		t.Errorf("apitest: request %d, %s: %s = %q, want %q", i, reqs[i], name, got, want)
+		return false
+	}
+	return true
+}
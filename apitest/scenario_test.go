@@ -0,0 +1,149 @@
+package apitest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api"
+)
+
+func TestScenarioRetryAfter(t *testing.T) {
+	clk := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	clk.AutoAdvance(true)
+	sc := NewScenario(clk)
+	sc.On("GET", "/").
+		Respond(429, "").RetryAfter(2*time.Minute).Times(1).
+		Then().Respond(200, "")
+	srv := sc.Server(t)
+
+	a := api.MustNew(srv.URL)
+	a.Retry = &api.RetryPolicy{MaxRetries: 1, MaxBackoff: time.Second}
+	a.SetClock(clk)
+	assert.NoError(t, a.DoJSON(context.Background(), api.GET, "/", nil, nil))
+	// The Retry-After of the server wins over the MaxBackoff.
+	sc.AssertSequence(t, "GET /", "GET /")
+	sc.AssertOffsets(t, time.Second, 0, 2*time.Minute)
+	if reqs := sc.Requests(); assert.Len(t, reqs, 2) {
+		assert.Equal(t, []int{429, 200}, []int{reqs[0].Status, reqs[1].Status})
+		assert.Equal(t, []int{0, 1}, []int{reqs[0].Step, reqs[1].Step})
+	}
+}
+
+func TestScenarioTokenRefresh(t *testing.T) {
+	clk := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	sc := NewScenario(clk)
+	sc.On("POST", "/token").
+		RespondJSON(200, `{"access_token": "token-{{n}}", "token_type": "bearer", "expires_in": 3600}`)
+	sc.On("GET", "/items").RespondJSON(200, `[]`)
+	srv := sc.Server(t)
+
+	ts := &api.ClientCredentials{
+		TokenURL:     srv.URL + "/token",
+		ClientID:     "client id",
+		ClientSecret: "s3cret",
+		ExpirySkew:   time.Minute,
+		Clock:        clk,
+	}
+	a := api.MustNew(srv.URL)
+	a.SetTokenSource(ts)
+	get := func() {
+		assert.NoError(t, a.DoJSON(context.Background(), api.GET, "/items", nil, nil))
+	}
+	get()
+	clk.Advance(58 * time.Minute)
+	get()
+	// The token is refreshed ExpirySkew before it expires.
+	clk.Advance(time.Minute)
+	get()
+
+	sc.AssertSequence(t, "POST /token", "GET /items", "GET /items", "POST /token", "GET /items")
+	sc.AssertOffsets(t, time.Minute, 0, 0, 58*time.Minute, 59*time.Minute, 59*time.Minute)
+	sc.AssertHeader(t, 1, "Authorization", "Bearer token-1")
+	sc.AssertHeader(t, 2, "Authorization", "Bearer token-1")
+	sc.AssertHeader(t, 4, "Authorization", "Bearer token-2")
+}
+
+func TestScenarioTokenExpiry(t *testing.T) {
+	clk := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	sc := NewScenario(clk)
+	// The server accepts the token for 60s, then rejects it once.
+	sc.On("GET", "/items").
+		RespondJSON(200, `[]`).For(time.Minute).
+		Then().RespondJSON(401, `{"error": "token expired"}`).Times(1).
+		Then().RespondJSON(200, `[]`)
+	srv := sc.Server(t)
+	a := api.MustNew(srv.URL)
+	ctx := context.Background()
+
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil))
+	clk.Advance(59 * time.Second)
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil))
+	clk.Advance(time.Second)
+	err := a.Get(ctx, "/items", nil, nil)
+	var se *api.StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, 401, se.Code)
+	}
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil))
+	sc.AssertOffsets(t, time.Second, 0, 59*time.Second, time.Minute, time.Minute)
+}
+
+func TestScenarioScript(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFakeClock(start)
+	sc := NewScenario(clk)
+	sc.On("GET", "/feed").
+		Respond(200, "first").For(time.Minute).
+		Then().Respond(200, "second").For(time.Minute).DateHeader("Expires", 30*time.Second).
+		Then().Respond(200, "third {{n}}").Times(1)
+	srv := sc.Server(t)
+
+	get := func(path string) (int, http.Header, string) {
+		resp, err := http.Get(srv.URL + path)
+		if !assert.NoError(t, err) {
+			return 0, nil, ""
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header, string(body)
+	}
+	_, h, body := get("/feed")
+	assert.Equal(t, "first", body)
+	assert.Equal(t, start.Format(http.TimeFormat), h.Get("Date"))
+	// The next step starts when the previous one ends, even without requests in between.
+	clk.Advance(90 * time.Second)
+	_, h, body = get("/feed")
+	assert.Equal(t, "second", body)
+	assert.Equal(t, start.Add(2*time.Minute).Format(http.TimeFormat), h.Get("Expires"))
+	clk.Advance(30 * time.Second)
+	_, _, body = get("/feed")
+	assert.Equal(t, "third 3", body)
+
+	// Past the script and without a route, the requests get a 501.
+	status, _, body := get("/feed")
+	assert.Equal(t, 501, status)
+	assert.Contains(t, body, "the script of GET /feed is over")
+	status, _, body = get("/other?x=1")
+	assert.Equal(t, 501, status)
+	assert.Contains(t, body, "no route for GET /other")
+	reqs := sc.Requests()
+	if assert.Len(t, reqs, 5) {
+		assert.Equal(t, []int{0, 1, 2, -1, -1}, []int{reqs[0].Step, reqs[1].Step, reqs[2].Step, reqs[3].Step, reqs[4].Step})
+	}
+
+	// The failed assertions report the requests.
+	rt := &recordT{TB: t}
+	assert.False(t, sc.AssertSequence(rt, "GET /feed"))
+	assert.False(t, sc.AssertOffsets(rt, time.Minute, 0))
+	assert.False(t, sc.AssertHeader(rt, 9, "Date", ""))
+	assert.Equal(t, []string{
+		"apitest: requests\n\tGET /feed\n\tGET /feed\n\tGET /feed\n\tGET /feed\n\tGET /other\nwant\n\tGET /feed",
+		"apitest: requests at 0s, 1m0s, 2m0s, 2m0s, 2m0s, want 0s",
+		"apitest: request 9 of 5",
+	}, rt.errors)
+}
@@ -0,0 +1,322 @@
+package apitest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// updateWire makes TestWire write the golden files rather than check them against.
+var updateWire = flag.Bool("update-wire", false, "write the golden files of apitest.TestWire with the requests rendered")
+
+// WireCase is a call whose requests, as sent on the wire, are pinned by a golden file, see
+// TestWire.
+type WireCase struct {
+	// Name is the name of the golden file of the case, Name+".golden" in the directory of the
+	// suite. It may contain slashes to group the cases in subdirectories, and must be unique.
+	Name string
+	// Call makes the call with client, the one of the suite, e.g. by setting it as the Client of
+	// an *api.Api. Every request it sends gets a 204 No Content response.
+	Call func(ctx context.Context, client *http.Client) error
+}
+
+// TestWire checks the requests sent by the calls of the cases against the golden files of dir,
+// to pin the exact bytes the api package puts on the wire across its upgrades and the
+// refactorings of the code built on it, e.g. for the requests signed, cached by key or matched by
+// the allowlist of a vendor:
+//
+//	func TestWire(t *testing.T) {
+//		apitest.TestWire(t, "testdata/wire", []apitest.WireCase{{
+//			Name: "users/get",
+//			Call: func(ctx context.Context, client *http.Client) error {
+//				a := api.MustNew("https://api.example.com/v1")
+//				a.Client = client
+//				return a.Get(ctx, "/users/1", url.Values{"fields": {"name,email"}}, nil)
+//			},
+//		}})
+//	}
+//
+// The requests of a case are rendered with RenderRequest, one after the other, and each case is
+// a subtest failing with the difference between its golden file and the rendering.
+//
+// The workflow of approving a change of the wire is:
+//
+//  1. Make the change, and run the tests: the cases whose requests changed fail with the diff.
+//  2. Regenerate the golden files with the -update-wire flag, like a make target would, e.g.
+//     go test ./... -run TestWire -update-wire. It writes the golden file of every case,
+//     and removes the ones of dir left without a case.
+//  3. Review the changes of the golden files with the version control, e.g. git diff dir,
+//     and commit them with the change: committing them is the approval.
+//
+// The CI runs the tests without the flag, so any diff not approved this way fails it; the flag
+// is refused when the CI environment variable is set, so a pipeline can't approve its own diffs.
+// The golden files missing fail the tests too, and so do the ones of dir without a case: dir
+// belongs to the suite.
+func TestWire(t *testing.T, dir string, cases []WireCase) {
+	t.Helper()
+	if *updateWire && os.Getenv("CI") != "" {
+		t.Fatal("apitest: -update-wire is refused in CI, regenerate the golden files locally and commit them")
+	}
+	names := make(map[string]bool, len(cases))
+	for _, c := range cases {
+		if c.Name == "" || names[c.Name] {
+			t.Fatalf("apitest: wire case name %q empty or duplicate", c.Name)
+		}
+		names[c.Name] = true
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			if err := checkWire(dir, c, *updateWire); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	stale, err := staleWire(dir, names, *updateWire)
+	if err != nil {
+		t.Errorf("apitest: %v", err)
+	}
+	for _, path := range stale {
+		t.Errorf("apitest: golden file %s has no wire case; run the tests with -update-wire to remove it", path)
+	}
+}
+
+// checkWire checks the requests of c against its golden file in dir, or writes it if update.
+func checkWire(dir string, c WireCase, update bool) error {
+	got, err := recordWire(c)
+	if err != nil {
+		return fmt.Errorf("apitest: wire case %s: %w", c.Name, err)
+	}
+	path := filepath.Join(dir, filepath.FromSlash(c.Name)+".golden")
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("apitest: %w", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			return fmt.Errorf("apitest: %w", err)
+		}
+		return nil
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("apitest: %w; run the tests with -update-wire to create it", err)
+	}
+	if !bytes.Equal(want, got) {
+		return fmt.Errorf("apitest: the requests of %s differ from %s (-want +got):\n%s\nrun the tests with -update-wire to approve them",
+			c.Name, path, lineDiff(string(want), string(got)))
+	}
+	return nil
+}
+
+// staleWire returns the golden files of dir without a case, removing them if update.
+func staleWire(dir string, names map[string]bool, update bool) ([]string, error) {
+	var stale []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".golden") {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || names[strings.TrimSuffix(filepath.ToSlash(rel), ".golden")] {
+			return err
+		}
+		if update {
+			return os.Remove(path)
+		}
+		stale = append(stale, path)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return stale, err
+}
+
+// recordWire makes the call of c, returning the rendering of its requests.
+func recordWire(c WireCase) ([]byte, error) {
+	rec := &wireRecorder{}
+	err := c.Call(context.Background(), &http.Client{Transport: rec})
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.err != nil {
+		return nil, rec.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rec.requests) == 0 {
+		return nil, fmt.Errorf("no request sent")
+	}
+	return bytes.Join(rec.requests, []byte("----\n")), nil
+}
+
+// wireRecorder is the transport of TestWire, rendering the requests it gets.
+type wireRecorder struct {
+	mu       sync.Mutex
+	requests [][]byte
+	err      error
+}
+
+func (r *wireRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	data, err := RenderRequest(req)
+	r.mu.Lock()
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	r.requests = append(r.requests, data)
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:     "204 No Content",
+		StatusCode: http.StatusNoContent,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+// RenderRequest renders req like net/http puts it on the wire, for the golden files of TestWire,
+// reading and closing its body:
+//
+//	POST https://api.example.com/v1/users?notify=true
+//	Content-Length: 14
+//	Content-Type: application/json
+//	Host: api.example.com
+//
+//	{"name":"Ann"}
+//
+// The first line is the method and the URL, with the escaping of its path and query kept, and
+// without its user info or fragment. The headers follow sorted by key, their values in order:
+// the Host, Content-Length and Transfer-Encoding ones are those of the request fields, as sent
+// by net/http, while the headers the transport adds itself, like its User-Agent and
+// Accept-Encoding, aren't rendered. The body follows a blank line, and a newline; a body that
+// isn't valid UTF-8 is rendered as a "base64:" line followed by its base64 encoding.
+func RenderRequest(req *http.Request) ([]byte, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading the body of %s %s: %w", req.Method, req.URL.Redacted(), err)
+		}
+	}
+	u := *req.URL
+	u.User, u.Fragment, u.RawFragment = nil, "", ""
+	var buf bytes.Buffer
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	fmt.Fprintf(&buf, "%s %s\n", method, u.String())
+
+	header := req.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	for _, key := range []string{"Host", "Content-Length", "Transfer-Encoding", "Trailer"} {
+		header.Del(key)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	header.Set("Host", host)
+	switch {
+	case req.ContentLength > 0:
+		header.Set("Content-Length", fmt.Sprint(req.ContentLength))
+	case req.ContentLength < 0 || req.ContentLength == 0 && len(body) > 0:
+		header.Set("Transfer-Encoding", "chunked")
+	case method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch:
+		header.Set("Content-Length", "0")
+	}
+	if len(req.Trailer) > 0 {
+		keys := make([]string, 0, len(req.Trailer))
+		for k := range req.Trailer {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		header.Set("Trailer", strings.Join(keys, ","))
+	}
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintf(&buf, "%s: %s\n", k, v)
+		}
+	}
+
+	buf.WriteByte('\n')
+	if len(body) > 0 {
+		if utf8.Valid(body) {
+			buf.Write(body)
+		} else {
+			buf.WriteString("base64:\n")
+			enc := base64.StdEncoding.EncodeToString(body)
+			for len(enc) > 76 {
+				buf.WriteString(enc[:76] + "\n")
+				enc = enc[76:]
+			}
+			buf.WriteString(enc)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// lineDiff returns the lines of want and got, prefixed with "-" for the ones only in want, "+"
+// for the ones only in got, and " " for the ones in both.
+func lineDiff(want, got string) string {
+	a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString(" " + a[i] + "\n")
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("-" + a[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+" + b[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
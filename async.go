@@ -0,0 +1,339 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrAsyncQueueFull is returned by DoAsync when the queue is full with the RejectWhenFull policy.
+	ErrAsyncQueueFull = errors.New("api: async queue full")
+	// ErrAsyncDropped is the error of the calls of DoAsync dropped from a full queue with the
+	// DropOldest policy.
+	ErrAsyncDropped = errors.New("api: async call dropped")
+)
+
+// QueueFull is what DoAsync does when the queue is full, see AsyncPolicy.
+type QueueFull int
+
+const (
+	// BlockWhenFull makes DoAsync wait for room in the queue, or for the context of the request
+	// to be done.
+	BlockWhenFull QueueFull = iota
+	// DropOldest drops the oldest call of the queue to make room, its Done being called with
+	// ErrAsyncDropped.
+	DropOldest
+	// RejectWhenFull makes DoAsync fail with ErrAsyncQueueFull.
+	RejectWhenFull
+)
+
+// AsyncPolicy configures the queue of DoAsync, see SetAsyncPolicy.
+type AsyncPolicy struct {
+	// Size is the number of calls the queue holds, 100 if zero.
+	Size int
+	// Workers is the number of calls made at the same time, 4 if zero.
+	Workers int
+	Full    QueueFull
+}
+
+// AsyncRequest is a call of DoAsync.
+type AsyncRequest struct {
+	Request *http.Request
+	Options []Option
+	// Done is called with the id of the call and its outcome once it's over: nil if it succeeded,
+	// the error of its last attempt otherwise. It's called from the goroutine of a worker, or
+	// from the one of DoAsync for the calls dropped by DropOldest, and may be nil.
+	Done func(id string, err error)
+}
+
+// AsyncStats are the counters of DoAsync, see AsyncStats.
+type AsyncStats struct {
+	// Depth is the number of calls in the queue, not counting those being made.
+	Depth int
+	// Queued counts the calls accepted by DoAsync, Succeeded and Failed those over.
+	Queued    int64
+	Succeeded int64
+	Failed    int64
+	// Dropped counts the calls dropped by DropOldest, Rejected those refused by RejectWhenFull.
+	Dropped  int64
+	Rejected int64
+}
+
+// asyncCounters are the counters of AsyncStats, kept across the queues of SetAsyncPolicy.
+type asyncCounters struct {
+	queued, succeeded, failed, dropped, rejected atomic.Int64
+}
+
+func newAsyncCounters() *asyncCounters { return &asyncCounters{} }
+
+// SetAsyncPolicy sets the policy of the queue of DoAsync. The calls of the current queue are still
+// made, by its own workers, while the new calls go to a new queue.
+func (a *Api) SetAsyncPolicy(p AsyncPolicy) {
+	a.asyncPolicy.Store(&p)
+	if old := a.asyncQueue.Swap(nil); old != nil {
+		old.close()
+	}
+}
+
+// DoAsync queues the call of r and returns its id right away, for the notifications the caller
+// doesn't wait for, like analytics pings or webhooks. The workers of the queue make the calls in
+// the order they were queued, through the pipeline of Do including the retries, with the values
+// of the context of the request but not its cancellation, and report their outcome to r.Done;
+// their response bodies are discarded. When the queue is full, DoAsync waits, drops the oldest
+// call or fails with ErrAsyncQueueFull as configured by SetAsyncPolicy. Shutdown makes the
+// queued calls before returning, or fails the ones left when its context is done with
+// ErrShutdown, and Close fails them with ErrClientClosed. See AsyncStats for the counters.
+func (a *Api) DoAsync(r AsyncRequest) (string, error) {
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	q, err := a.asyncQueueOf()
+	if err != nil {
+		return "", err
+	}
+	if err := q.push(&asyncItem{id: id, r: r}); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// AsyncStats returns the counters of DoAsync.
+func (a *Api) AsyncStats() AsyncStats {
+	c := a.asyncCounters.get(newAsyncCounters)
+	s := AsyncStats{Queued: c.queued.Load(), Succeeded: c.succeeded.Load(), Failed: c.failed.Load(),
+		Dropped: c.dropped.Load(), Rejected: c.rejected.Load()}
+	if q := a.asyncQueue.Load(); q != nil {
+		q.mu.Lock()
+		s.Depth = len(q.items)
+		q.mu.Unlock()
+	}
+	return s
+}
+
+// asyncQueueOf returns the queue of DoAsync, starting it on first use.
+func (a *Api) asyncQueueOf() (*asyncQueue, error) {
+	for {
+		if q := a.asyncQueue.Load(); q != nil {
+			return q, nil
+		}
+		a.mu.Lock()
+		closed := a.closed
+		a.mu.Unlock()
+		if closed {
+			return nil, ErrClientClosed
+		}
+		p := AsyncPolicy{}
+		if pp := a.asyncPolicy.Load(); pp != nil {
+			p = *pp
+		}
+		q := newAsyncQueue(a, p)
+		if a.asyncQueue.CompareAndSwap(nil, q) {
+			q.start()
+			return q, nil
+		}
+	}
+}
+
+type asyncItem struct {
+	id string
+	r  AsyncRequest
+}
+
+func (it *asyncItem) done(err error) {
+	if it.r.Done != nil {
+		it.r.Done(it.id, err)
+	}
+}
+
+// asyncQueue is a queue of DoAsync and its workers.
+type asyncQueue struct {
+	a        *Api
+	policy   AsyncPolicy
+	counters *asyncCounters
+	// ctx is canceled by abort, ending the calls of the workers.
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	workers sync.WaitGroup
+
+	mu    sync.Mutex
+	items []*asyncItem
+	// changed is closed and replaced whenever items or closed change.
+	changed chan struct{}
+	// closed is set once the queue takes no more calls, its workers leaving once it's empty.
+	closed bool
+	// blocked is the number of calls of DoAsync waiting for room with BlockWhenFull.
+	blocked int
+}
+
+func newAsyncQueue(a *Api, p AsyncPolicy) *asyncQueue {
+	if p.Size <= 0 {
+		p.Size = 100
+	}
+	if p.Workers <= 0 {
+		p.Workers = 4
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return &asyncQueue{a: a, policy: p, counters: a.asyncCounters.get(newAsyncCounters),
+		ctx: ctx, cancel: cancel, changed: make(chan struct{})}
+}
+
+func (q *asyncQueue) start() {
+	q.workers.Add(q.policy.Workers)
+	for i := 0; i < q.policy.Workers; i++ {
+		q.a.goBackground(q.ctx, q.work)
+	}
+}
+
+// signal wakes the goroutines waiting for the queue to change, with q.mu held.
+func (q *asyncQueue) signal() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *asyncQueue) push(it *asyncItem) error {
+	q.mu.Lock()
+	for {
+		if q.closed {
+			q.mu.Unlock()
+			return ErrClientClosed
+		}
+		if len(q.items) < q.policy.Size {
+			q.items = append(q.items, it)
+			q.counters.queued.Add(1)
+			q.signal()
+			q.mu.Unlock()
+			return nil
+		}
+		switch q.policy.Full {
+		case RejectWhenFull:
+			q.mu.Unlock()
+			q.counters.rejected.Add(1)
+			return ErrAsyncQueueFull
+		case DropOldest:
+			old := q.items[0]
+			q.items = append(q.items[1:], it)
+			q.counters.queued.Add(1)
+			q.signal()
+			q.mu.Unlock()
+			q.counters.dropped.Add(1)
+			old.done(ErrAsyncDropped)
+			return nil
+		}
+		changed := q.changed
+		q.blocked++
+		q.mu.Unlock()
+		ctx := it.r.Request.Context()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.blocked--
+			q.mu.Unlock()
+			return withCause(ctx, ctx.Err())
+		}
+		q.mu.Lock()
+		q.blocked--
+	}
+}
+
+// pop returns the next call, waiting for one until the queue is closed and empty or ctx is done.
+func (q *asyncQueue) pop(ctx context.Context) (*asyncItem, bool) {
+	q.mu.Lock()
+	for {
+		if len(q.items) > 0 {
+			it := q.items[0]
+			q.items[0] = nil
+			q.items = q.items[1:]
+			q.signal()
+			q.mu.Unlock()
+			return it, true
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return nil, false
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, false
+		}
+		q.mu.Lock()
+	}
+}
+
+// work makes the calls of the queue until it's closed and empty, or ctx is done, failing the
+// calls left then with ErrClientClosed or the cause of ctx.
+func (q *asyncQueue) work(ctx context.Context) {
+	defer q.workers.Done()
+	for {
+		it, ok := q.pop(ctx)
+		if !ok {
+			break
+		}
+		if err := q.call(ctx, it); err != nil {
+			q.counters.failed.Add(1)
+			it.done(err)
+		} else {
+			q.counters.succeeded.Add(1)
+			it.done(nil)
+		}
+	}
+	if ctx.Err() != nil {
+		err := context.Cause(q.ctx)
+		if err == nil {
+			err = ErrClientClosed
+		}
+		q.abort(err)
+	}
+}
+
+// call makes the call of it with the values of the context of its request, until ctx is done.
+func (q *asyncQueue) call(ctx context.Context, it *asyncItem) error {
+	req := it.r.Request
+	callCtx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	c := q.a.newCall(it.r.Options)
+	c.async = true
+	resp, err := q.a.send(callCtx, c, req.WithContext(callCtx))
+	if err != nil {
+		if ctx.Err() != nil {
+			if cause := context.Cause(q.ctx); cause != nil {
+				return cause
+			}
+		}
+		return err
+	}
+	return drainClose(resp.Body)
+}
+
+// close makes the queue take no more calls, its workers leaving once they made the queued ones.
+func (q *asyncQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.signal()
+	}
+}
+
+// abort closes the queue and fails the calls left in it with err, canceling those being made.
+func (q *asyncQueue) abort(err error) {
+	q.mu.Lock()
+	items := q.items
+	q.items, q.closed = nil, true
+	q.signal()
+	q.mu.Unlock()
+	q.cancel(err)
+	for _, it := range items {
+		q.counters.failed.Add(1)
+		it.done(err)
+	}
+}
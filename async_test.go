@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// asyncResults collects the outcomes of the calls of DoAsync by id.
+type asyncResults struct {
+	mu   sync.Mutex
+	errs map[string]error
+	ch   chan string
+}
+
+func newAsyncResults() *asyncResults {
+	return &asyncResults{errs: make(map[string]error), ch: make(chan string, 100)}
+}
+
+func (r *asyncResults) done(id string, err error) {
+	r.mu.Lock()
+	r.errs[id] = err
+	r.mu.Unlock()
+	r.ch <- id
+}
+
+func (r *asyncResults) err(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.errs[id]
+}
+
+func TestDoAsync(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/bad":
+			http.Error(w, "no", http.StatusBadRequest)
+			return
+		case "/flaky":
+			if len(paths) == 5 {
+				http.Error(w, "later", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Write([]byte("ignored"))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	defer a.Close()
+	a.SetAsyncPolicy(AsyncPolicy{Workers: 2})
+	results := newAsyncResults()
+
+	var ids []string
+	for _, path := range []string{"/ping/1", "/ping/2", "/bad", "/ping/3"} {
+		req, _ := a.Request(POST, path, nil)
+		id, err := a.DoAsync(AsyncRequest{Request: req, Done: results.done})
+		if !assert.NoError(t, err) {
+			return
+		}
+		ids = append(ids, id)
+	}
+	var done []string
+	for range ids {
+		done = append(done, <-results.ch)
+	}
+	sort.Strings(ids)
+	sort.Strings(done)
+	assert.Equal(t, ids, done)
+	var se *StatusError
+	failed := 0
+	for _, id := range ids {
+		if err := results.err(id); err != nil {
+			failed++
+			if assert.True(t, errors.As(err, &se)) {
+				assert.Equal(t, http.StatusBadRequest, se.Code)
+			}
+		}
+	}
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, AsyncStats{Queued: 4, Succeeded: 3, Failed: 1}, a.AsyncStats())
+	mu.Lock()
+	assert.Len(t, paths, 4)
+	mu.Unlock()
+
+	// The calls are retried as usual, and the ones without Done are made alike.
+	a.Retry = &RetryPolicy{MaxRetries: 1, MinBackoff: time.Millisecond}
+	req, _ := a.Request(POST, "/flaky", nil)
+	_, err := a.DoAsync(AsyncRequest{Request: req})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return a.AsyncStats().Succeeded == 4 }, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"/flaky", "/flaky"}, paths[4:])
+	mu.Unlock()
+}
+
+func TestDoAsyncFull(t *testing.T) {
+	block := make(chan struct{})
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Path
+		if r.URL.Path == "/slow" {
+			<-block
+		}
+	}))
+	defer srv.Close()
+
+	// fill makes the single worker busy with a slow call, then fills the queue of two.
+	fill := func(full QueueFull) (*Api, *asyncResults, []string) {
+		a := MustNew(srv.URL)
+		a.SetAsyncPolicy(AsyncPolicy{Size: 2, Workers: 1, Full: full})
+		results := newAsyncResults()
+		var ids []string
+		for _, path := range []string{"/slow", "/1", "/2"} {
+			req, _ := a.Request(POST, path, nil)
+			id, err := a.DoAsync(AsyncRequest{Request: req, Done: results.done})
+			assert.NoError(t, err)
+			ids = append(ids, id)
+			if path == "/slow" {
+				assert.Equal(t, "/slow", <-received)
+			}
+		}
+		assert.Equal(t, 2, a.AsyncStats().Depth)
+		return a, results, ids
+	}
+	req := func(a *Api, path string) AsyncRequest {
+		r, _ := a.Request(POST, path, nil)
+		return AsyncRequest{Request: r}
+	}
+
+	a, _, _ := fill(RejectWhenFull)
+	_, err := a.DoAsync(req(a, "/3"))
+	assert.ErrorIs(t, err, ErrAsyncQueueFull)
+	assert.Equal(t, int64(1), a.AsyncStats().Rejected)
+	a.Close()
+
+	a, results, ids := fill(DropOldest)
+	_, err = a.DoAsync(req(a, "/3"))
+	assert.NoError(t, err)
+	assert.Equal(t, ids[1], <-results.ch)
+	assert.ErrorIs(t, results.err(ids[1]), ErrAsyncDropped)
+	stats := a.AsyncStats()
+	assert.Equal(t, int64(1), stats.Dropped)
+	assert.Equal(t, 2, stats.Depth)
+	a.Close()
+
+	a, _, _ = fill(BlockWhenFull)
+	blocked := make(chan error)
+	go func() {
+		_, err := a.DoAsync(req(a, "/3"))
+		blocked <- err
+	}()
+	// A request whose context is done stops waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := a.Request(POST, "/4", nil)
+	waiting := make(chan error)
+	go func() {
+		_, err := a.DoAsync(AsyncRequest{Request: r.WithContext(ctx)})
+		waiting <- err
+	}()
+	// Both calls wait for room.
+	assert.Eventually(t, func() bool {
+		q := a.asyncQueue.Load()
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.blocked == 2
+	}, 5*time.Second, time.Millisecond)
+	assert.Len(t, blocked, 0)
+	cancel()
+	assert.ErrorIs(t, <-waiting, context.Canceled)
+	close(block)
+	assert.NoError(t, <-blocked)
+	assert.NoError(t, a.Shutdown(context.Background()))
+	assert.Equal(t, AsyncStats{Queued: 4, Succeeded: 4}, a.AsyncStats())
+}
+
+func TestDoAsyncShutdown(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-block:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+	before := runtime.NumGoroutine()
+
+	// Shutdown makes the queued calls.
+	a := MustNew(srv.URL)
+	results := newAsyncResults()
+	for i := 0; i < 20; i++ {
+		req, _ := a.Request(POST, "/ping", nil)
+		_, err := a.DoAsync(AsyncRequest{Request: req, Done: results.done})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, a.Shutdown(context.Background()))
+	assert.Len(t, results.ch, 20)
+	assert.Equal(t, AsyncStats{Queued: 20, Succeeded: 20}, a.AsyncStats())
+	req, _ := a.Request(POST, "/ping", nil)
+	_, err := a.DoAsync(AsyncRequest{Request: req})
+	assert.Equal(t, ErrClientClosed, err)
+	assertGoroutines(t, before)
+
+	// Past its deadline, the calls left fail with ErrShutdown, the ones being made included.
+	a = MustNew(srv.URL)
+	a.SetAsyncPolicy(AsyncPolicy{Workers: 1})
+	results = newAsyncResults()
+	var ids []string
+	for _, path := range []string{"/slow", "/ping"} {
+		req, _ := a.Request(POST, path, nil)
+		id, _ := a.DoAsync(AsyncRequest{Request: req, Done: results.done})
+		ids = append(ids, id)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, a.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Len(t, results.ch, 2)
+	for _, id := range ids {
+		assert.ErrorIs(t, results.err(id), ErrShutdown, id)
+	}
+	assert.Equal(t, AsyncStats{Queued: 2, Failed: 2}, a.AsyncStats())
+	assertGoroutines(t, before)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrUnattributed is returned in strict mode for the calls made without an Attribution, see
+// SetAttribution.
+var ErrUnattributed = errors.New("api: unattributed call")
+
+// DefaultAttributionTeam is the team of the calls made without an Attribution, unless
+// AttributionPolicy.Default sets another one.
+const DefaultAttributionTeam = "unattributed"
+
+// Attribution tells who a call is made for, e.g. to attribute the costs of a vendor API to the
+// teams and features using it, see WithAttribution.
+type Attribution struct {
+	Team    string
+	Feature string
+}
+
+type attributionKey struct{}
+
+// WithAttribution returns a copy of ctx attributing the calls made with it to at, so a middleware
+// or a job can set it once:
+//
+//	ctx = api.WithAttribution(ctx, api.Attribution{Team: "payments", Feature: "payouts"})
+//
+// The attribution is passed to the CallLogger in CallLog.Team and CallLog.Feature, and recorded
+// in the JournalEntry of the call, so the counts and the bytes of the calls can be aggregated per
+// team even when the vendor can't report them; SetAttribution sends it in headers too.
+func WithAttribution(ctx context.Context, at Attribution) context.Context {
+	return context.WithValue(ctx, attributionKey{}, at)
+}
+
+// AttributionOf returns the attribution carried by ctx, and whether it has one.
+func AttributionOf(ctx context.Context) (Attribution, bool) {
+	at, ok := ctx.Value(attributionKey{}).(Attribution)
+	return at, ok
+}
+
+// AttributionPolicy configures SetAttribution.
+type AttributionPolicy struct {
+	// TeamHeader and FeatureHeader are the headers the attribution is sent in, e.g.
+	// "X-Client-Team" and "X-Client-Feature", for the vendors reporting the usage per client.
+	// An empty one isn't sent.
+	TeamHeader    string
+	FeatureHeader string
+	// Default is the attribution of the calls made without one, so they're counted too. Its Team
+	// is DefaultAttributionTeam if empty.
+	Default Attribution
+	// Strict makes the calls made without an attribution fail with ErrUnattributed, before any
+	// request is sent.
+	Strict bool
+}
+
+// SetAttribution makes the Api send the attribution of every call set by WithAttribution in the
+// headers of p, and attribute the calls made without one to p.Default, or fail them in strict
+// mode. A nil p disables it: the calls are then logged and journaled with their attribution, if
+// any, and nothing is sent.
+func (a *Api) SetAttribution(p *AttributionPolicy) {
+	if p == nil {
+		a.attribution.Store(nil)
+		return
+	}
+	policy := *p
+	if policy.Default.Team == "" {
+		policy.Default.Team = DefaultAttributionTeam
+	}
+	a.attribution.Store(&policy)
+}
+
+// attributionOf returns the attribution of the calls made with ctx, the default one of the Api's
+// policy if ctx has none.
+func (a *Api) attributionOf(ctx context.Context) Attribution {
+	if at, ok := AttributionOf(ctx); ok {
+		return at
+	}
+	if p := a.attribution.Load(); p != nil {
+		return p.Default
+	}
+	return Attribution{}
+}
+
+// applyAttribution sets the attribution headers of req, failing the unattributed calls in strict mode.
+func (a *Api) applyAttribution(ctx context.Context, req *http.Request) error {
+	p := a.attribution.Load()
+	if p == nil {
+		return nil
+	}
+	at, ok := AttributionOf(ctx)
+	if !ok {
+		if p.Strict {
+			return ErrUnattributed
+		}
+		at = p.Default
+	}
+	if p.TeamHeader != "" && at.Team != "" {
+		req.Header.Set(p.TeamHeader, at.Team)
+	}
+	if p.FeatureHeader != "" && at.Feature != "" {
+		req.Header.Set(p.FeatureHeader, at.Feature)
+	}
+	return nil
+}
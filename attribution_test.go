@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// teamUsage aggregates the calls and the response bytes per team and feature.
+type teamUsage struct {
+	mu    sync.Mutex
+	calls map[Attribution]int
+	bytes map[Attribution]int64
+}
+
+func (u *teamUsage) LogCall(_ context.Context, l CallLog) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	at := Attribution{Team: l.Team, Feature: l.Feature}
+	u.calls[at]++
+	u.bytes[at] += l.ResponseSize
+}
+
+func TestAttribution(t *testing.T) {
+	var headers []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	usage := &teamUsage{calls: make(map[Attribution]int), bytes: make(map[Attribution]int64)}
+	a.SetCallLogger(usage)
+	j := &memJournal{}
+	a.SetJournal(j, nil)
+	ctx := context.Background()
+	payouts := WithAttribution(ctx, Attribution{Team: "payments", Feature: "payouts"})
+
+	// Without a policy, the calls are only tagged.
+	assert.NoError(t, a.Post(payouts, "/payouts", nil, nil))
+	assert.Empty(t, headers[0].Get("X-Client-Team"))
+
+	a.SetAttribution(&AttributionPolicy{TeamHeader: "X-Client-Team", FeatureHeader: "X-Client-Feature"})
+	assert.NoError(t, a.Post(payouts, "/payouts", nil, nil))
+	assert.NoError(t, a.DoJSON(WithAttribution(ctx, Attribution{Team: "search"}), GET, "/items", nil, nil))
+	assert.NoError(t, a.DoJSON(ctx, GET, "/items", nil, nil))
+	if assert.Len(t, headers, 4) {
+		assert.Equal(t, "payments", headers[1].Get("X-Client-Team"))
+		assert.Equal(t, "payouts", headers[1].Get("X-Client-Feature"))
+		assert.Equal(t, "search", headers[2].Get("X-Client-Team"))
+		assert.Empty(t, headers[2].Values("X-Client-Feature"))
+		assert.Equal(t, DefaultAttributionTeam, headers[3].Get("X-Client-Team"))
+	}
+	assert.Equal(t, map[Attribution]int{
+		{Team: "payments", Feature: "payouts"}: 2,
+		{Team: "search"}:                       1,
+		{Team: DefaultAttributionTeam}:         1,
+	}, usage.calls)
+	assert.Equal(t, int64(len(`{"ok": true}`)), usage.bytes[Attribution{Team: "search"}])
+	if assert.Len(t, j.entries, 2) {
+		assert.Equal(t, "payments", j.entries[1].Team)
+		assert.Equal(t, "payouts", j.entries[1].Feature)
+	}
+
+	// In strict mode, the unattributed calls fail before being sent.
+	a.SetAttribution(&AttributionPolicy{TeamHeader: "X-Client-Team", Default: Attribution{Team: "platform"}, Strict: true})
+	err := a.DoJSON(ctx, GET, "/items", nil, nil)
+	assert.True(t, errors.Is(err, ErrUnattributed), "%v", err)
+	assert.False(t, IsRetryable(err))
+	assert.Len(t, headers, 4)
+	assert.NoError(t, a.ForTenant("acme").DoJSON(payouts, GET, "/items", nil, nil))
+	assert.Len(t, headers, 5)
+
+	a.SetAttribution(nil)
+	assert.NoError(t, a.DoJSON(ctx, GET, "/items", nil, nil))
+	assert.Equal(t, 1, usage.calls[Attribution{}])
+}
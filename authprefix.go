@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+type authRule struct {
+	prefix string
+	ts     TokenSource
+}
+
+// AuthForPrefix makes the requests for the resources under prefix, e.g. "/admin", carry a token
+// of ts instead of the one of the TokenSource of the Api, see SetTokenSource. The prefix matches
+// whole path segments of the path relative to the base uri and the path version, the path
+// templates being expanded first, so "/admin" matches "/admin/{id}" but not "/administrators".
+// The longest matching prefix wins. A nil ts sends the requests under prefix without a token.
+// Registering the same prefix twice is an error.
+func (a *Api) AuthForPrefix(prefix string, ts TokenSource) error {
+	prefix = path.Clean("/" + prefix)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, r := range a.authRules {
+		if r.prefix == prefix {
+			return fmt.Errorf("api: auth rule for %s already set", prefix)
+		}
+	}
+	// The rules are copied on write since derived Apis share them.
+	rules := append(append([]authRule(nil), a.authRules...), authRule{prefix: prefix, ts: ts})
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].prefix) > len(rules[j].prefix) })
+	a.authRules = rules
+	return nil
+}
+
+// tokenSourceFor returns the TokenSource of the request, the one of the longest auth rule
+// matching its path or the default one. It must be called with a.mu held.
+func (a *Api) tokenSourceFor(req *http.Request) TokenSource {
+	if len(a.authRules) == 0 {
+		return a.tokens
+	}
+	p := req.URL.Path
+	if base := strings.TrimSuffix(a.baseURI().Path, "/"); strings.HasPrefix(p, base+"/") {
+		p = p[len(base):]
+	}
+	if seg := a.version.segment; seg != "" && (p == "/"+seg || strings.HasPrefix(p, "/"+seg+"/")) {
+		p = p[len(seg)+1:]
+	}
+	if p == "" {
+		p = "/"
+	}
+	for _, r := range a.authRules {
+		if r.prefix == "/" || p == r.prefix || strings.HasPrefix(p, r.prefix+"/") {
+			return r.ts
+		}
+	}
+	return a.tokens
+}
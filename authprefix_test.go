@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthForPrefix(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"` + r.Header.Get("Authorization") + `"`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL + "/api/")
+	a.SetVersion(VersionInPath("v2"))
+	a.SetTokenSource(&staticToken{token: "default"})
+	assert.NoError(t, a.AuthForPrefix("/admin", &staticToken{token: "admin"}))
+	assert.NoError(t, a.AuthForPrefix("admin/billing/", &staticToken{token: "billing"}))
+	assert.NoError(t, a.AuthForPrefix("/public", nil))
+	ctx := context.Background()
+
+	for resource, auth := range map[string]string{
+		"/admin":              "Bearer admin",
+		"/admin/users":        "Bearer admin",
+		"/v2/admin/users":     "Bearer admin",
+		"/admin/billing/2020": "Bearer billing",
+		"/public/status":      "",
+		"/users":              "Bearer default",
+		"/administrators":     "Bearer default",
+		"/billing":            "Bearer default",
+	} {
+		var got string
+		assert.NoError(t, a.Get(ctx, resource, nil, &got), resource)
+		assert.Equal(t, auth, got, resource)
+	}
+
+	// The rules match the expanded path of a template.
+	var got string
+	assert.NoError(t, a.Template(GET, "/{scope}/{id}").Do(ctx, map[string]string{"scope": "admin", "id": "1"}, nil, &got))
+	assert.Equal(t, "Bearer admin", got)
+
+	// The rules are shared by the derived Apis.
+	tenant := a.ForTenant("acme")
+	assert.NoError(t, tenant.Get(ctx, "/admin/billing", nil, &got))
+	assert.Equal(t, "Bearer billing", got)
+
+	assert.EqualError(t, a.AuthForPrefix("admin/", &staticToken{}), "api: auth rule for /admin already set")
+}
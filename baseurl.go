@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrBaseURLNotAllowed is returned for the calls whose context overrides the base URL with
+// a host that isn't allowed by AllowBaseURLs.
+var ErrBaseURLNotAllowed = errors.New("api: base URL override not allowed")
+
+type baseURLKey struct{}
+
+// baseOverride is the base URL set by WithBaseURL.
+type baseOverride struct {
+	u   *url.URL
+	err error
+}
+
+// WithBaseURL returns a copy of ctx making the Do-style helpers and the templates of every Api
+// given it send their requests to base rather than to the base URI of the Api, e.g. to route
+// a share of the calls through a canary gateway without another Api:
+//
+//	ctx = api.WithBaseURL(ctx, "https://gw-canary.internal")
+//
+// The resources are joined with base by the same rules, and everything else, like the headers,
+// the credentials and the policies, comes from the Api. Only the requests for the base URI of
+// the Api are moved, so the absolute URLs, like those of the next pages of List, are left as they
+// are. The host of base is reported in CallLog.Host. An invalid base fails the calls.
+func WithBaseURL(ctx context.Context, base string) context.Context {
+	o := &baseOverride{}
+	o.u, o.err = parseBase(base)
+	if o.err == nil && o.u.Host == "" {
+		o.err = fmt.Errorf("api: base URL %s: not an absolute URL", base)
+	}
+	return context.WithValue(ctx, baseURLKey{}, o)
+}
+
+// AllowBaseURLs restricts the bases set by WithBaseURL to those of the given hosts, e.g.
+// "gw-canary.internal" or "gw-canary.internal:8443" for a single port, failing the calls to
+// the other ones with ErrBaseURLNotAllowed. Without hosts, any base is allowed.
+func (a *Api) AllowBaseURLs(hosts ...string) {
+	if len(hosts) == 0 {
+		a.baseAllow.Store(nil)
+		return
+	}
+	allowed := make([]string, len(hosts))
+	for i, h := range hosts {
+		allowed[i] = strings.ToLower(h)
+	}
+	a.baseAllow.Store(&allowed)
+}
+
+// overrideBase returns u, a URL of the base URI from, moved to the base URL of ctx if it has one.
+func (a *Api) overrideBase(ctx context.Context, u, from *url.URL) (*url.URL, error) {
+	o, _ := ctx.Value(baseURLKey{}).(*baseOverride)
+	if o == nil {
+		return u, nil
+	}
+	if o.err != nil {
+		return nil, o.err
+	}
+	if allowed := a.baseAllow.Load(); allowed != nil {
+		host, hostname := strings.ToLower(o.u.Host), strings.ToLower(o.u.Hostname())
+		ok := false
+		for _, h := range *allowed {
+			if h == host || h == hostname {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrBaseURLNotAllowed, o.u.Host)
+		}
+	}
+	if r, ok := rebase(u, from, o.u); ok {
+		return r, nil
+	}
+	return u, nil
+}
+
+// overrideRequestBase moves req to the base URL of ctx, see overrideBase.
+func (a *Api) overrideRequestBase(ctx context.Context, req *http.Request) error {
+	u, err := a.overrideBase(ctx, req.URL, a.baseURI())
+	if err != nil || u == req.URL {
+		return err
+	}
+	if req.Host == req.URL.Host {
+		req.Host = u.Host
+	}
+	req.URL = u
+	return nil
+}
+
+// rebase returns u moved from the base URL from to the base URL to, reporting whether u is
+// a URL of from. The query is kept.
+func rebase(u, from, to *url.URL) (*url.URL, bool) {
+	if origin(u) != origin(from) {
+		return nil, false
+	}
+	prefix := strings.TrimSuffix(from.Path, "/")
+	rel := strings.TrimPrefix(u.Path, prefix)
+	if len(rel) == len(u.Path) && prefix != "" || rel != "" && rel[0] != '/' {
+		return nil, false
+	}
+	r := *u
+	r.Scheme, r.Host, r.User = to.Scheme, to.Host, to.User
+	r.Path, r.RawPath = strings.TrimSuffix(to.Path, "/")+rel, ""
+	if u.RawPath != "" {
+		rawPrefix := strings.TrimSuffix(from.EscapedPath(), "/")
+		r.RawPath = strings.TrimSuffix(to.EscapedPath(), "/") + strings.TrimPrefix(u.EscapedPath(), rawPrefix)
+	}
+	return &r, true
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithBaseURL(t *testing.T) {
+	var got []string
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = append(got, name+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization"))
+		})
+	}
+	stable := httptest.NewServer(handler("stable"))
+	defer stable.Close()
+	canary := httptest.NewServer(handler("canary"))
+	defer canary.Close()
+	a := MustNew(stable.URL + "/v1")
+	a.Header = http.Header{"Authorization": {"Bearer t"}}
+	h := &recordHandler{}
+	a.SetLogger(slog.New(h))
+	base := a.BaseURI.String()
+	ctx := WithBaseURL(context.Background(), canary.URL+"/gw/")
+
+	assert.NoError(t, a.DoJSON(ctx, GET, "/users", url.Values{"page": {"2"}}, nil))
+	assert.NoError(t, a.Post(ctx, "/users", map[string]string{"name": "bob"}, nil))
+	assert.NoError(t, a.Template(GET, "/users/{id}").Do(ctx, map[string]string{"id": "7"}, nil, nil))
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/users", nil, nil))
+	assert.Equal(t, []string{
+		"canary /gw/users?page=2 Bearer t",
+		"canary /gw/users Bearer t",
+		"canary /gw/users/7 Bearer t",
+		"stable /v1/users Bearer t",
+	}, got)
+	assert.Equal(t, base, a.BaseURI.String())
+
+	if assert.Len(t, h.records, 4) {
+		canaryURL, _ := url.Parse(canary.URL)
+		stableURL, _ := url.Parse(stable.URL)
+		assert.Equal(t, canaryURL.Host, recordAttrs(h.records[0])["host"])
+		assert.Equal(t, stableURL.Host, recordAttrs(h.records[3])["host"])
+	}
+
+	// The requests for another base aren't moved.
+	req, err := a.Request(GET, "/users", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	other, _ := url.Parse(stable.URL + "/v2/users")
+	req.URL = other
+	assert.NoError(t, a.shape(req, []Option{buildContext(ctx)}))
+	assert.Equal(t, stable.URL+"/v2/users", req.URL.String())
+
+	err = a.DoJSON(WithBaseURL(context.Background(), "gw-canary"), GET, "/users", nil, nil)
+	assert.Error(t, err)
+	assert.Len(t, got, 4)
+}
+
+func TestAllowBaseURLs(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+	a := MustNew("https://api.example.com")
+	u, _ := url.Parse(srv.URL)
+	a.AllowBaseURLs(u.Hostname())
+
+	assert.NoError(t, a.DoJSON(WithBaseURL(context.Background(), srv.URL), GET, "/", nil, nil))
+	assert.Equal(t, 1, calls)
+
+	err := a.DoJSON(WithBaseURL(context.Background(), "https://evil.example.com"), GET, "/", nil, nil)
+	assert.True(t, errors.Is(err, ErrBaseURLNotAllowed), "%v", err)
+	err = a.Template(GET, "/").Do(WithBaseURL(context.Background(), "https://evil.example.com"), nil, nil, nil)
+	assert.True(t, errors.Is(err, ErrBaseURLNotAllowed), "%v", err)
+
+	// The port narrows the allowed host.
+	a.AllowBaseURLs(u.Hostname() + ":1")
+	err = a.DoJSON(WithBaseURL(context.Background(), srv.URL), GET, "/", nil, nil)
+	assert.True(t, errors.Is(err, ErrBaseURLNotAllowed), "%v", err)
+	assert.True(t, errors.Is(a.ForTenant("acme").DoJSON(WithBaseURL(context.Background(), srv.URL), GET, "/", nil, nil), ErrBaseURLNotAllowed))
+	assert.Equal(t, 1, calls)
+
+	a.AllowBaseURLs()
+	assert.NoError(t, a.DoJSON(WithBaseURL(context.Background(), srv.URL), GET, "/", nil, nil))
+	assert.Equal(t, 2, calls)
+}
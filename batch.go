@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// PartResponse is a response embedded in a part of a multipart/mixed batch response, see
+// ParseBatchResponse.
+type PartResponse struct {
+	// Index is the 0-based position of the part in the batch response.
+	Index int
+	// ContentID is the Content-ID header of the part, e.g. "<response-item1>", which batch
+	// endpoints derive from that of the matching request part.
+	ContentID string
+	// StatusCode is the status code of the embedded response, e.g. 404.
+	StatusCode int
+	// Status is its status line, e.g. "404 Not Found".
+	Status string
+	Header http.Header
+	Body   []byte
+	// Err is set, as a *BatchPartError, if the part doesn't hold a valid HTTP response; the
+	// other fields but Index and ContentID are then unset.
+	Err error
+}
+
+// Decode decodes the body of the part into out, as JSON or XML depending on its Content-Type,
+// JSON if it has none. An empty body leaves out untouched. A part with a non-2xx status code
+// fails with a *StatusError, and a malformed part with its Err.
+func (p *PartResponse) Decode(out interface{}) error {
+	if p.Err != nil {
+		return p.Err
+	}
+	if p.StatusCode < 200 || p.StatusCode > 299 {
+		return &StatusError{Code: p.StatusCode, Status: p.Status, Header: p.Header, Body: p.Body, Size: int64(len(p.Body))}
+	}
+	if out == nil || len(bytes.TrimSpace(p.Body)) == 0 {
+		return nil
+	}
+	mediatype, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+	switch {
+	case mediatype == "", mediatype == "application/json", strings.HasSuffix(mediatype, "+json"):
+		return json.Unmarshal(p.Body, out)
+	case mediatype == "application/xml", mediatype == "text/xml", strings.HasSuffix(mediatype, "+xml"):
+		return xml.Unmarshal(p.Body, out)
+	default:
+		return &UnexpectedContentError{Code: p.StatusCode, ContentType: mediatype, Preview: preview(p.Body)}
+	}
+}
+
+// BatchPartError is the error of a malformed part of a batch response.
+type BatchPartError struct {
+	// Index is the 0-based position of the part in the batch response.
+	Index int
+	Err   error
+}
+
+func (e *BatchPartError) Error() string {
+	return fmt.Sprintf("api: malformed batch part %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchPartError) Unwrap() error { return e.Err }
+
+// ParseBatchResponse reads the multipart/mixed body of a batch response, like those of the
+// Google APIs, and returns the response embedded in each of its parts, in order. A part that
+// doesn't hold a valid HTTP response is returned with its Err set, and the first of them is
+// returned as the error, but the later parts are still parsed. The body is read to the end;
+// the caller closes it.
+func ParseBatchResponse(resp *http.Response) ([]PartResponse, error) {
+	var parts []PartResponse
+	var malformed error
+	err := ReadBatchResponse(resp, func(part *PartResponse) error {
+		if part.Err != nil && malformed == nil {
+			malformed = part.Err
+		}
+		parts = append(parts, *part)
+		return nil
+	})
+	if err != nil {
+		return parts, err
+	}
+	return parts, malformed
+}
+
+// ReadBatchResponse is the streaming variant of ParseBatchResponse: it calls fn with every part
+// of the batch response as soon as it's read, stopping at the first error returned by fn.
+// The malformed parts are passed to fn with their Err set.
+func ReadBatchResponse(resp *http.Response, fn func(part *PartResponse) error) error {
+	mediatype, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediatype, "multipart/") || params["boundary"] == "" {
+		return fmt.Errorf("api: not a multipart batch response: %q", resp.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for i := 0; ; i++ {
+		p, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("api: batch part %d: %w", i, err)
+		}
+		part, err := readPart(i, p)
+		if err != nil {
+			return fmt.Errorf("api: batch part %d: %w", i, err)
+		}
+		if err := fn(part); err != nil {
+			return err
+		}
+	}
+}
+
+// readPart parses the HTTP response embedded in the part p, failing only if p can't be read.
+func readPart(index int, p *multipart.Part) (*PartResponse, error) {
+	data, err := io.ReadAll(p)
+	if err != nil {
+		return nil, err
+	}
+	part := &PartResponse{Index: index, ContentID: p.Header.Get("Content-ID")}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(bytes.TrimLeft(data, "\r\n"))), nil)
+	if err == nil {
+		part.Body, err = io.ReadAll(resp.Body)
+	}
+	if err != nil {
+		part.Err = &BatchPartError{Index: index, Err: err}
+		return part, nil
+	}
+	part.StatusCode, part.Status, part.Header = resp.StatusCode, resp.Status, resp.Header
+	return part, nil
+}
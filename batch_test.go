@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// batchBody encodes the embedded responses as the parts of a multipart/mixed body.
+func batchBody(responses ...string) (string, []byte) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i, r := range responses {
+		pw, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-Id":   {"<response-item" + string(rune('1'+i)) + ">"},
+		})
+		pw.Write([]byte(r))
+	}
+	mw.Close()
+	return "multipart/mixed; boundary=" + mw.Boundary(), buf.Bytes()
+}
+
+func TestParseBatchResponse(t *testing.T) {
+	contentType, body := batchBody(
+		"HTTP/1.1 200 OK\r\nContent-Type: application/json; charset=UTF-8\r\nETag: \"1\"\r\n\r\n{\"id\": 1, \"name\": \"bob\"}",
+		"HTTP/1.1 200 OK\r\nContent-Type: application/xml\r\n\r\n<user><id>2</id><name>al</name></user>",
+		"HTTP/1.1 404 Not Found\r\nContent-Type: application/json\r\n\r\n{\"error\": \"not found\"}",
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write(body)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	req, _ := a.Request(POST, "/batch", nil)
+	resp, err := a.Do(context.Background(), req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	parts, err := ParseBatchResponse(resp)
+	if !assert.NoError(t, err) || !assert.Len(t, parts, 3) {
+		return
+	}
+
+	type user struct {
+		ID   int    `json:"id" xml:"id"`
+		Name string `json:"name" xml:"name"`
+	}
+	var u user
+	assert.Equal(t, "<response-item1>", parts[0].ContentID)
+	assert.Equal(t, `"1"`, parts[0].Header.Get("ETag"))
+	assert.NoError(t, parts[0].Decode(&u))
+	assert.Equal(t, user{1, "bob"}, u)
+	assert.NoError(t, parts[1].Decode(&u))
+	assert.Equal(t, user{2, "al"}, u)
+
+	assert.Equal(t, 2, parts[2].Index)
+	assert.Equal(t, http.StatusNotFound, parts[2].StatusCode)
+	var se *StatusError
+	if assert.True(t, errors.As(parts[2].Decode(&u), &se)) {
+		assert.Equal(t, "404 Not Found", se.Status)
+		assert.Equal(t, `{"error": "not found"}`, string(se.Body))
+	}
+}
+
+func TestParseBatchResponseMalformed(t *testing.T) {
+	contentType, body := batchBody(
+		"HTTP/1.1 201 Created\r\n\r\n{\"id\": 1}",
+		"garbage",
+		"HTTP/1.1 204 No Content\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<html>oops</html>",
+	)
+	resp := &http.Response{Header: http.Header{"Content-Type": {contentType}}, Body: io.NopCloser(bytes.NewReader(body))}
+	parts, err := ParseBatchResponse(resp)
+	var pe *BatchPartError
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, 1, pe.Index)
+	}
+	if !assert.Len(t, parts, 4) {
+		return
+	}
+	var v struct{ ID int }
+	assert.NoError(t, parts[0].Decode(&v))
+	assert.Equal(t, 1, v.ID)
+	assert.Equal(t, err, parts[1].Decode(&v))
+	assert.NoError(t, parts[2].Decode(&v))
+	var ce *UnexpectedContentError
+	assert.True(t, errors.As(parts[3].Decode(&v), &ce))
+
+	// The streaming variant stops at the first error of fn.
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	var seen []int
+	stop := errors.New("stop")
+	err = ReadBatchResponse(resp, func(part *PartResponse) error {
+		seen = append(seen, part.Index)
+		if part.Err != nil {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, []int{0, 1}, seen)
+
+	resp.Header.Set("Content-Type", "application/json")
+	_, err = ParseBatchResponse(resp)
+	assert.Error(t, err)
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// maxPooledBuffer limits the capacity of buffers kept in bufferPool, so that a single
+// large body doesn't pin its memory for the lifetime of the pool.
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// lazyBody is an encoded request body. It's encoded once, the first time its bytes are needed,
+// into a pooled scratch buffer, and the bytes are kept for every body of the request, so GetBody,
+// retries, hedges and checksums replay them rather than running the encoder again.
+type lazyBody struct {
+	once   sync.Once
+	encode func(*bytes.Buffer) error
+	data   []byte
+	err    error
+	// first is the body the request is created with, allocated along with the lazyBody.
+	first lazyReader
+}
+
+func (l *lazyBody) bytes() ([]byte, error) {
+	l.once.Do(func() {
+		buf := bufferPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		if l.err = l.encode(buf); l.err == nil {
+			l.data = append([]byte(nil), buf.Bytes()...)
+		}
+		putBuffer(buf)
+		l.encode = nil
+	})
+	return l.data, l.err
+}
+
+// body returns a new body reading the encoded bytes.
+func (l *lazyBody) body() *lazyReader {
+	b := &lazyReader{l: l}
+	b.r.Reset(l.data)
+	return b
+}
+
+// lazyReader is a body of a lazyBody. Reads fail once it's closed, like those of the transport's bodies.
+// The transport may close the body concurrently with reading it, hence the mutex.
+type lazyReader struct {
+	mu     sync.Mutex
+	l      *lazyBody
+	r      bytes.Reader
+	closed bool
+}
+
+func (b *lazyReader) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, http.ErrBodyReadAfterClose
+	}
+	return b.r.Read(p)
+}
+
+func (b *lazyReader) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.r.Reset(nil)
+	return nil
+}
+
+// encodedBody returns the encoded bytes of the body of req if it was created by setLazyBody.
+func encodedBody(req *http.Request) ([]byte, bool) {
+	b, ok := req.Body.(*lazyReader)
+	if !ok {
+		return nil, false
+	}
+	data, err := b.l.bytes()
+	return data, err == nil
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// setLazyBody sets the body of req to the output of encode. The length of the body is set
+// along with it, so the body is encoded right away; GetBody replays the same bytes.
+func setLazyBody(req *http.Request, encode func(*bytes.Buffer) error) error {
+	l := &lazyBody{encode: encode}
+	data, err := l.bytes()
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	if req.ContentLength == 0 {
+		req.Body = http.NoBody
+		req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return nil
+	}
+	l.first.l = l
+	l.first.r.Reset(data)
+	req.Body = &l.first
+	req.GetBody = func() (io.ReadCloser, error) {
+		return l.body(), nil
+	}
+	return nil
+}
+
+// setBytesBody sets the body of req to data, just like http.NewRequest does for a *bytes.Reader.
+func setBytesBody(req *http.Request, data []byte) {
+	req.ContentLength = int64(len(data))
+	if len(data) == 0 {
+		req.Body = http.NoBody
+		req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// IsReplayable reports whether the body of req can be sent again, as following a 307 or 308
+// redirect and retrying require. Requests without a body and those having GetBody are replayable;
+// all bodies created by this package are, except for RequestReader given a reader that can't seek.
+func IsReplayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// setReaderBody sets the body of req to stream from r. A seekable r is replayed from its current
+// offset by GetBody: through an io.SectionReader if r is an io.ReaderAt, so that the bodies are
+// independent of each other, or else by seeking r back, so that only the latest body may be read.
+func setReaderBody(req *http.Request, r io.Reader) {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		req.Body = io.NopCloser(r)
+		return
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// E.g. a pipe, which is an *os.File but can't seek.
+		req.Body = io.NopCloser(r)
+		return
+	}
+	req.Body = &seekBody{rs}
+	if ra, ok := rs.(io.ReaderAt); ok {
+		end, err := rs.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = rs.Seek(start, io.SeekStart)
+		}
+		if err == nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				return &seekBody{io.NewSectionReader(ra, start, end-start)}, nil
+			}
+			return
+		}
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return &seekBody{rs}, nil
+	}
+}
+
+// seekBody is a request body that keeps the io.Seeker of the underlying reader.
+type seekBody struct {
+	io.ReadSeeker
+}
+
+func (seekBody) Close() error { return nil }
+
+// encodeForm writes args to buf in the same form url.Values.Encode produces.
+func encodeForm(buf *bytes.Buffer, args url.Values) {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key := url.QueryEscape(k)
+		for _, v := range args[k] {
+			if buf.Len() > 0 {
+				buf.WriteByte('&')
+			}
+			buf.WriteString(key)
+			buf.WriteByte('=')
+			buf.WriteString(url.QueryEscape(v))
+		}
+	}
+}
+
+// encodeJSON writes v to buf just like json.Marshal would, without the encoder's trailing newline.
+func encodeJSON(buf *bytes.Buffer, v interface{}) error {
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPooledBodyClose(t *testing.T) {
+	a := MustNew("http://example.com")
+	req, err := a.Request(POST, "/items", url.Values{"a": {"1"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, req.Body.Close())
+	assert.NoError(t, req.Body.Close())
+	_, err = req.Body.Read(make([]byte, 1))
+	assert.Equal(t, http.ErrBodyReadAfterClose, err)
+
+	// The released buffer is reused by other bodies without affecting the copies from GetBody.
+	for i := 0; i < 10; i++ {
+		other, _ := a.Request(POST, "/items", url.Values{"b": {"22222222"}})
+		other.Body.Close()
+	}
+	body, err := req.GetBody()
+	if !assert.NoError(t, err) {
+		return
+	}
+	data, _ := io.ReadAll(body)
+	assert.Equal(t, "a=1", string(data))
+	body.Close()
+}
+
+func TestPooledBodyEmpty(t *testing.T) {
+	a := MustNew("http://example.com")
+	req, err := a.Request(POST, "/items", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.NoBody, req.Body)
+	assert.EqualValues(t, 0, req.ContentLength)
+	assert.Equal(t, "0", req.Header.Get("Content-Length"))
+}
+
+func TestPooledBodyConcurrent(t *testing.T) {
+	a := MustNew("http://example.com")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := url.Values{"n": {string(rune('a' + i%26))}}.Encode()
+			req, _ := a.Request(POST, "/items", url.Values{"n": {string(rune('a' + i%26))}})
+
+			// The transport may close a body while it's still being read.
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				p := make([]byte, 1)
+				for {
+					if _, err := req.Body.Read(p); err != nil {
+						return
+					}
+				}
+			}()
+			req.Body.Close()
+			<-done
+
+			body, _ := req.GetBody()
+			data, _ := io.ReadAll(body)
+			body.Close()
+			assert.Equal(t, want, string(data))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestPooledBodyRetry(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(data))
+		n := len(bodies)
+		mu.Unlock()
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	a.SetClock(fakeClock())
+	assert.NoError(t, a.DoJSON(context.Background(), POST, "/items", url.Values{"name": {"x y"}}, nil))
+	assert.Equal(t, []string{"name=x+y", "name=x+y", "name=x+y"}, bodies)
+}
+
+// countingJSON counts its encodings.
+type countingJSON struct {
+	n *atomic.Int32
+}
+
+func (v countingJSON) MarshalJSON() ([]byte, error) {
+	v.n.Add(1)
+	return []byte(`{"name":"x"}`), nil
+}
+
+func TestLazyBodyEncodedOnce(t *testing.T) {
+	var mu sync.Mutex
+	var bodies, sums []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(data))
+		sums = append(sums, r.Header.Get("Content-MD5"))
+		n := len(bodies)
+		mu.Unlock()
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	a.SetClock(fakeClock())
+	var n atomic.Int32
+	req, err := a.RequestJSON(POST, "/items", countingJSON{&n})
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := a.send(context.Background(), a.newCallFor("/items", []Option{UploadChecksum(MD5, "")}), req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, []string{`{"name":"x"}`, `{"name":"x"}`, `{"name":"x"}`}, bodies)
+	assert.Len(t, sums, 3)
+	assert.Equal(t, sums[0], sums[2])
+	assert.EqualValues(t, 1, n.Load())
+
+	// Reusing the request as a template doesn't encode it again either.
+	req.Body, _ = req.GetBody()
+	resp, err = a.Do(context.Background(), req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.EqualValues(t, 1, n.Load())
+}
+
+func BenchmarkDoRequestJSON(b *testing.B) {
+	a := MustNew("http://example.com")
+	a.Client = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+	})}
+	v := map[string]interface{}{"filter": 1, "price": 200, "name": "some long value"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req, _ := a.RequestJSON(POST, "/categories/1", v)
+		resp, err := a.Do(context.Background(), req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+}
+
+// seeker hides the io.ReaderAt of the underlying reader.
+type seeker struct {
+	io.ReadSeeker
+}
+
+func TestRedirectReplaysBody(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			io.Copy(io.Discard, r.Body)
+			w.Header().Set("Location", "/new")
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		got = append(got, r.Method+" "+r.URL.Path+" "+string(b))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	skipped := strings.NewReader("skip:payload")
+	skipped.Seek(5, io.SeekStart)
+	for name, tc := range map[string]struct {
+		build func() (*http.Request, error)
+		want  string
+	}{
+		"form":  {func() (*http.Request, error) { return a.Request(POST, "/old", url.Values{"a": {"1"}}) }, "a=1"},
+		"bytes": {func() (*http.Request, error) { return a.RequestBytes(POST, "/old", "text/plain", []byte("payload")) }, "payload"},
+		"json":  {func() (*http.Request, error) { return a.RequestJSON(POST, "/old", []int{1, 2}) }, "[1,2]"},
+		"reader": {func() (*http.Request, error) {
+			return a.RequestReader(POST, "/old", "text/plain", strings.NewReader("payload"))
+		}, "payload"},
+		"offset": {func() (*http.Request, error) { return a.RequestReader(POST, "/old", "text/plain", skipped) }, "payload"},
+		"seeker": {func() (*http.Request, error) {
+			return a.RequestReader(POST, "/old", "text/plain", seeker{strings.NewReader("payload")})
+		}, "payload"},
+		"no body": {func() (*http.Request, error) { return a.Request(DELETE, "/old", nil) }, ""},
+	} {
+		got = nil
+		req, err := tc.build()
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		assert.True(t, IsReplayable(req), name)
+		resp, err := a.Do(context.Background(), req)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, name)
+		assert.Equal(t, []string{req.Method + " /new " + tc.want}, got, name)
+	}
+
+	// A stream that can't be replayed isn't redirected rather than resent empty.
+	got = nil
+	req, err := a.RequestReader(POST, "/old", "text/plain", io.MultiReader(strings.NewReader("payload")))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.False(t, IsReplayable(req))
+	resp, err := a.Do(context.Background(), req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	}
+	assert.Empty(t, got)
+}
+
+func TestReaderBodyReplayOffset(t *testing.T) {
+	a := MustNew("http://example.com")
+	for _, r := range []io.ReadSeeker{strings.NewReader("skip:payload"), seeker{strings.NewReader("skip:payload")}} {
+		r.Seek(5, io.SeekStart)
+		req, err := a.RequestReader(PUT, "/x", "text/plain", r)
+		if !assert.NoError(t, err) {
+			return
+		}
+		b, _ := io.ReadAll(req.Body)
+		assert.Equal(t, "payload", string(b))
+		for i := 0; i < 2; i++ {
+			body, err := req.GetBody()
+			if assert.NoError(t, err) {
+				b, _ = io.ReadAll(body)
+				assert.Equal(t, "payload", string(b))
+			}
+		}
+	}
+}
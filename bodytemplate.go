@@ -0,0 +1,223 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// BodyTemplate is a request body rendered from a text/template, parsed once and rendered for every
+// request, see ParseBodyTemplate and ParseJSONBodyTemplate. Besides the built-in functions, the
+// templates can call:
+//
+//	base64 .Data // the standard base64 encoding of a string or a []byte
+//	now          // the current time of the Api's clock in RFC 3339, in UTC
+//	uuid         // a random version 4 UUID
+//
+// A BodyTemplate is safe for concurrent use.
+type BodyTemplate struct {
+	tmpl        *template.Template
+	contentType string
+	json        bool
+}
+
+// BodyTemplateError is returned when the body rendered by a JSON BodyTemplate isn't valid JSON.
+type BodyTemplateError struct {
+	// Name is the name of the template.
+	Name string
+	// Line is the line of the template the rendered body is invalid at, as far as it can be told
+	// from the actions interpolated before; the actions of a range repeat theirs.
+	Line int
+	Err  error
+}
+
+func (e *BodyTemplateError) Error() string {
+	return fmt.Sprintf("api: body template %s:%d: %v", e.Name, e.Line, e.Err)
+}
+
+func (e *BodyTemplateError) Unwrap() error { return e.Err }
+
+// ParseBodyTemplate parses the template text of bodies sent with the contentType, rendered as is.
+func ParseBodyTemplate(name, contentType, text string) (*BodyTemplate, error) {
+	tmpl, err := template.New(name).Funcs(bodyFuncs(nil, nil)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &BodyTemplate{tmpl: tmpl, contentType: contentType}, nil
+}
+
+// ParseJSONBodyTemplate parses the template text of JSON bodies. The value of every action is
+// interpolated JSON encoded, so strings are quoted and escaped whatever they hold:
+//
+//	{"name": {{.Name}}, "tags": {{.Tags}}, "note": {{printf "%s, by %s" .Note .User}}}
+//
+// The actions are whole JSON values: a string can't be interpolated within the quotes of
+// another one, and a json.RawMessage is interpolated as it is. The rendered body is checked to be
+// valid JSON before it's sent, failing with a *BodyTemplateError otherwise.
+func ParseJSONBodyTemplate(name, text string) (*BodyTemplate, error) {
+	tmpl, err := template.New(name).Funcs(bodyFuncs(nil, nil)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			encodeActions(t.Tree, t.Tree.Root, text)
+		}
+	}
+	return &BodyTemplate{tmpl: tmpl, contentType: "application/json", json: true}, nil
+}
+
+// encodeActions makes the actions of the node pass their value to the jsonAt function, with the
+// line of the action in text.
+func encodeActions(tree *parse.Tree, node parse.Node, text string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			encodeActions(tree, child, text)
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 {
+			// A variable declaration doesn't print anything.
+			return
+		}
+		line := 1 + strings.Count(text[:min(int(n.Pos), len(text))], "\n")
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{NodeType: parse.NodeCommand, Pos: n.Pos, Args: []parse.Node{
+			parse.NewIdentifier("jsonAt").SetTree(tree).SetPos(n.Pos),
+			&parse.NumberNode{NodeType: parse.NodeNumber, Pos: n.Pos, IsInt: true, Int64: int64(line), Text: strconv.Itoa(line)},
+		}})
+	case *parse.IfNode:
+		encodeActions(tree, n.List, text)
+		encodeActions(tree, n.ElseList, text)
+	case *parse.RangeNode:
+		encodeActions(tree, n.List, text)
+		encodeActions(tree, n.ElseList, text)
+	case *parse.WithNode:
+		encodeActions(tree, n.List, text)
+		encodeActions(tree, n.ElseList, text)
+	}
+}
+
+// bodyRender is the state of a rendering: the body and the output offsets of the actions.
+type bodyRender struct {
+	buf   bytes.Buffer
+	marks []bodyMark
+}
+
+type bodyMark struct {
+	offset, line int
+}
+
+// bodyFuncs returns the functions of the templates, bound to the rendering r and the clock clk,
+// which may be nil when parsing.
+func bodyFuncs(r *bodyRender, clk Clock) template.FuncMap {
+	return template.FuncMap{
+		"base64": func(v interface{}) (string, error) {
+			switch v := v.(type) {
+			case string:
+				return base64.StdEncoding.EncodeToString([]byte(v)), nil
+			case []byte:
+				return base64.StdEncoding.EncodeToString(v), nil
+			default:
+				return "", fmt.Errorf("base64 of %T", v)
+			}
+		},
+		"now": func() string {
+			return clk.Now().UTC().Format(time.RFC3339)
+		},
+		"uuid": newUUID,
+		"jsonAt": func(line int, v interface{}) (string, error) {
+			r.marks = append(r.marks, bodyMark{offset: r.buf.Len(), line: line})
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(v); err != nil {
+				return "", err
+			}
+			return strings.TrimSuffix(buf.String(), "\n"), nil
+		},
+	}
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// Render renders the body for data with the clock of a.
+func (t *BodyTemplate) Render(a *Api, data interface{}) ([]byte, error) {
+	r := &bodyRender{}
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Funcs(bodyFuncs(r, a.clock())).Execute(&r.buf, data); err != nil {
+		return nil, err
+	}
+	body := r.buf.Bytes()
+	if !t.json {
+		return body, nil
+	}
+	var v interface{}
+	err = json.Unmarshal(body, &v)
+	var se *json.SyntaxError
+	if errors.As(err, &se) {
+		return nil, &BodyTemplateError{Name: t.tmpl.Name(), Line: r.line(int(se.Offset)), Err: err}
+	}
+	if err != nil {
+		return nil, &BodyTemplateError{Name: t.tmpl.Name(), Line: 1, Err: err}
+	}
+	return body, nil
+}
+
+// line returns the line of the template the body rendered at offset comes from: the one of the
+// last action interpolated before, plus the lines of the text copied since.
+func (r *bodyRender) line(offset int) int {
+	body := r.buf.Bytes()
+	offset = min(max(offset-1, 0), len(body))
+	mark := bodyMark{line: 1}
+	for _, m := range r.marks {
+		if m.offset > offset {
+			break
+		}
+		mark = m
+	}
+	return mark.line + bytes.Count(body[mark.offset:offset], []byte("\n"))
+}
+
+// Request renders the body for data and creates a request sending it with the Content-Type of
+// the template, see RequestBytes.
+func (t *BodyTemplate) Request(a *Api, method Method, resource string, data interface{}, opts ...Option) (*http.Request, error) {
+	body, err := t.Render(a, data)
+	if err != nil {
+		return nil, err
+	}
+	return a.RequestBytes(method, resource, t.contentType, body, opts...)
+}
+
+// Do renders the body for data, sends it like Post and decodes the JSON response into out.
+func (t *BodyTemplate) Do(ctx context.Context, a *Api, method Method, resource string, data, out interface{}, opts ...Option) error {
+	req, err := t.Request(a, method, resource, data, buildContext(ctx))
+	if err != nil {
+		return err
+	}
+	return a.sendJSON(ctx, req, resource, out, opts)
+}
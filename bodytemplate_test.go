@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestJSONBodyTemplate(t *testing.T) {
+	var contentType string
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &got), string(body))
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(clock.NewFake(time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))))
+
+	tpl, err := ParseJSONBodyTemplate("ticket", `{
+	"title": {{.Title}},
+	"count": {{len .Tags}},
+	"tags": {{.Tags}},
+	"note": {{printf "%s <%s>" .Note .User}},
+	{{- if .Urgent}}
+	"priority": "high",
+	{{- end}}
+	"token": {{base64 "user:secret"}},
+	"at": {{now}},
+	"id": {{uuid}}
+}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	data := map[string]interface{}{
+		"Title":  `disk "full" on db-1`,
+		"Tags":   []string{"ops", "a\tb"},
+		"Note":   "line 1\nline 2 \\ \"quoted\"",
+		"User":   "bob",
+		"Urgent": true,
+	}
+	var out struct{ OK bool }
+	if !assert.NoError(t, tpl.Do(context.Background(), a, POST, "/tickets", data, &out)) {
+		return
+	}
+	assert.True(t, out.OK)
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, `disk "full" on db-1`, got["title"])
+	assert.Equal(t, 2.0, got["count"])
+	assert.Equal(t, []interface{}{"ops", "a\tb"}, got["tags"])
+	assert.Equal(t, "line 1\nline 2 \\ \"quoted\" <bob>", got["note"])
+	assert.Equal(t, "high", got["priority"])
+	assert.Equal(t, "dXNlcjpzZWNyZXQ=", got["token"])
+	assert.Equal(t, "2020-01-02T02:04:05Z", got["at"])
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), got["id"])
+
+	// Without the if block, the body is still valid.
+	data["Urgent"] = false
+	req, err := tpl.Request(a, POST, "/tickets", data)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(req.Body)
+		assert.True(t, json.Valid(body), string(body))
+		assert.Equal(t, int64(len(body)), req.ContentLength)
+	}
+}
+
+func TestJSONBodyTemplateInvalid(t *testing.T) {
+	tpl, err := ParseJSONBodyTemplate("bad", `{
+	"name": {{.Name}},
+	"id": "job-{{.ID}}",
+	"ok": true
+}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a := MustNew("https://api.example.com")
+	_, err = tpl.Request(a, POST, "/jobs", map[string]interface{}{"Name": "x", "ID": "a1"})
+	var te *BodyTemplateError
+	if assert.True(t, errors.As(err, &te), "%v", err) {
+		assert.Equal(t, "bad", te.Name)
+		assert.Equal(t, 3, te.Line)
+	}
+
+	_, err = ParseJSONBodyTemplate("bad", `{"name": {{.Name}`)
+	assert.Error(t, err)
+}
+
+func TestBodyTemplate(t *testing.T) {
+	tpl, err := ParseBodyTemplate("csv", "text/csv", "id,name\n{{range .}}{{.ID}},{{.Name}}\n{{end}}")
+	if !assert.NoError(t, err) {
+		return
+	}
+	a := MustNew("https://api.example.com")
+	req, err := tpl.Request(a, PUT, "/import", []struct {
+		ID   int
+		Name string
+	}{{1, "a"}, {2, "b"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, "id,name\n1,a\n2,b\n", string(body))
+	assert.Equal(t, "text/csv", req.Header.Get("Content-Type"))
+}
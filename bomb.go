@@ -0,0 +1,178 @@
+package api
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrDecompressionBomb is matched by every *DecompressionBombError.
+var ErrDecompressionBomb = errors.New("api: response body exceeds the size limits")
+
+// ratioFloor is the expanded size under which ResponseLimits.MaxRatio isn't enforced, so that
+// small, very repetitive bodies like a page of empty objects don't trip it.
+const ratioFloor = 64 << 10
+
+// ResponseLimits bounds the size of the response bodies of a call, see WithResponseLimits.
+// A zero field means no limit.
+type ResponseLimits struct {
+	// MaxBytes limits the body as the caller reads it, after decompression.
+	MaxBytes int64
+	// MaxCompressedBytes limits the body as received, before decompression.
+	MaxCompressedBytes int64
+	// MaxRatio limits the expanded size over the compressed one, once the body expanded past 64KiB.
+	MaxRatio float64
+}
+
+// DecompressionBombError is returned by reads of a response body going past a limit of
+// WithResponseLimits. It's matched by ErrDecompressionBomb.
+type DecompressionBombError struct {
+	// Limit is the exceeded limit: "bytes", "compressed bytes" or "ratio".
+	Limit string
+	// Compressed is the number of bytes received when the limit was hit, Expanded the number
+	// of bytes they decompressed to; both are the same for uncompressed bodies.
+	Compressed, Expanded int64
+}
+
+func (e *DecompressionBombError) Error() string {
+	return fmt.Sprintf("api: response body exceeds the %s limit: %d bytes received, %d expanded", e.Limit, e.Compressed, e.Expanded)
+}
+
+// Is makes errors.Is(err, ErrDecompressionBomb) report true.
+func (e *DecompressionBombError) Is(target error) bool {
+	return target == ErrDecompressionBomb
+}
+
+// Class makes the error a Permanent failure, since the server would send the same body again.
+func (e *DecompressionBombError) Class() Class { return Permanent }
+
+// WithResponseLimits bounds the size of the response body. The transport decompresses gzip
+// bodies out of sight, so with limits the call asks for gzip itself and decompresses the gzip
+// and deflate bodies as they're read, counting the bytes on both sides; the response then looks
+// as if the transport had decompressed it. The calls setting their own Accept-Encoding get the
+// body as sent, only limited by MaxBytes and MaxCompressedBytes. Reads going past a limit fail
+// with a *DecompressionBombError. Use SetDefaults for the limits of every call.
+func WithResponseLimits(l ResponseLimits) Option {
+	return func(c *call) {
+		c.limits = &l
+	}
+}
+
+// accept asks for gzip on behalf of the transport, returning the request to send and whether
+// the body is decompressed by wrap. req itself is left alone, since it's sent again on retries.
+func (l *ResponseLimits) accept(req *http.Request, client *http.Client) (*http.Request, bool) {
+	if req.Method == http.MethodHead || req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return req, false
+	}
+	if t, ok := client.Transport.(*http.Transport); ok && t.DisableCompression {
+		return req, false
+	}
+	r := *req
+	r.Header = req.Header.Clone()
+	r.Header.Set("Accept-Encoding", "gzip")
+	return &r, true
+}
+
+// wrap limits the body of resp, decompressing it if decode is set.
+func (l *ResponseLimits) wrap(resp *http.Response, decode bool) io.ReadCloser {
+	b := &limitBody{limits: l, raw: resp.Body}
+	b.wire = &countReader{r: resp.Body}
+	b.r = b.wire
+	if !decode {
+		return b
+	}
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip":
+		b.open = func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+	case "deflate":
+		b.open = func(r io.Reader) (io.Reader, error) { return flate.NewReader(r), nil }
+	default:
+		return b
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return b
+}
+
+// countReader counts the bytes read from r.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// limitBody enforces the ResponseLimits on a body, opening the decompressor on the first read.
+type limitBody struct {
+	limits *ResponseLimits
+	raw    io.ReadCloser
+	wire   *countReader
+	r      io.Reader
+	open   func(io.Reader) (io.Reader, error)
+	n      int64
+	err    error
+	once   sync.Once
+}
+
+func (b *limitBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.open != nil {
+		open := b.open
+		b.open = nil
+		r, err := open(b.wire)
+		if err != nil {
+			b.err = fmt.Errorf("api: invalid compressed body: %w", err)
+			return 0, b.err
+		}
+		b.r = r
+	}
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if limit := b.exceeded(); limit != "" {
+		b.err = &DecompressionBombError{Limit: limit, Compressed: b.wire.n, Expanded: b.n}
+		// What was read past the limit is withheld.
+		if limit != "bytes" {
+			return 0, b.err
+		}
+		return max(n-int(b.n-b.limits.MaxBytes), 0), b.err
+	}
+	return n, err
+}
+
+// exceeded returns the limit the body went past, "" if none.
+func (b *limitBody) exceeded() string {
+	l := b.limits
+	switch {
+	case l.MaxBytes > 0 && b.n > l.MaxBytes:
+		return "bytes"
+	case l.MaxCompressedBytes > 0 && b.wire.n > l.MaxCompressedBytes:
+		return "compressed bytes"
+	case l.MaxRatio > 0 && b.n > ratioFloor && float64(b.n) > l.MaxRatio*float64(b.wire.n):
+		return "ratio"
+	}
+	return ""
+}
+
+func (b *limitBody) Close() error {
+	var err error
+	b.once.Do(func() {
+		if c, ok := b.r.(io.Closer); ok {
+			c.Close()
+		}
+		err = b.raw.Close()
+	})
+	return err
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseLimits(t *testing.T) {
+	gzipped := func(b []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(b)
+		zw.Close()
+		return buf.Bytes()
+	}
+	// Whitespace, so that the JSON decoder reads on until a limit is hit.
+	bomb := gzipped(bytes.Repeat([]byte(" "), 10<<20))
+	doc := gzipped([]byte(`{"name":"a"}`))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.Write([]byte(`{"name":"plain"}`))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		if r.URL.Path == "/doc" {
+			w.Write(doc)
+			return
+		}
+		w.Write(bomb)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		limits ResponseLimits
+		limit  string
+	}{
+		{ResponseLimits{MaxBytes: 1 << 20}, "bytes"},
+		{ResponseLimits{MaxCompressedBytes: 4 << 10}, "compressed bytes"},
+		{ResponseLimits{MaxRatio: 100}, "ratio"},
+	} {
+		req, _ := a.Request(GET, "/bomb", nil)
+		resp, err := a.Do(ctx, req, WithResponseLimits(tc.limits))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.True(t, resp.Uncompressed)
+		n, err := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		assert.ErrorIs(t, err, ErrDecompressionBomb)
+		var be *DecompressionBombError
+		if assert.ErrorAs(t, err, &be) {
+			assert.Equal(t, tc.limit, be.Limit)
+			assert.Less(t, be.Compressed, int64(len(bomb))+1)
+			assert.Greater(t, be.Expanded, 100*be.Compressed)
+			assert.Equal(t, Permanent, Classify(err))
+		}
+		if tc.limit == "bytes" {
+			assert.Equal(t, int64(1<<20), n)
+		}
+	}
+
+	var v struct{ Name string }
+	limits := WithResponseLimits(ResponseLimits{MaxBytes: 1 << 10, MaxRatio: 10})
+	assert.NoError(t, a.Get(ctx, "/doc", nil, &v, limits))
+	assert.Equal(t, "a", v.Name)
+	err := a.Get(ctx, "/bomb", nil, &v, limits)
+	assert.ErrorIs(t, err, ErrDecompressionBomb)
+
+	// Asking for an encoding leaves the body alone, still counted.
+	req, _ := a.Request(GET, "/doc", nil, WithHeader("Accept-Encoding", "gzip"))
+	resp, err := a.Do(ctx, req, limits)
+	if assert.NoError(t, err) {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, doc, body)
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	}
+	req, _ = a.Request(GET, "/bomb", nil, WithHeader("Accept-Encoding", "gzip"))
+	resp, err = a.Do(ctx, req, limits)
+	if assert.NoError(t, err) {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.ErrorIs(t, err, ErrDecompressionBomb)
+	}
+
+	// The limits don't get in the way of uncompressed bodies.
+	a.SetDefaults(WithResponseLimits(ResponseLimits{MaxBytes: 1 << 10}))
+	req, _ = a.Request(GET, "/plain", nil, WithHeader("Accept-Encoding", "identity"))
+	resp, err = a.Do(ctx, req)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, `{"name":"plain"}`, string(body))
+	}
+}
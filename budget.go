@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExhausted is matched by every *BudgetError.
+var ErrBudgetExhausted = errors.New("api: call budget exhausted")
+
+// Budget limits the calls made on behalf of a context, e.g. the fan-out of an inbound request,
+// see WithBudget. A zero limit is no limit.
+type Budget struct {
+	// MaxCalls limits the number of calls, retries included.
+	MaxCalls int
+	// MaxDuration limits the time spent in the calls, added up across the concurrent ones:
+	// from sending the request until the response headers arrive or the call fails.
+	MaxDuration time.Duration
+	// ExcludeRetries stops the retries of the Do-style helpers from counting against MaxCalls.
+	// The time they take still counts against MaxDuration.
+	ExcludeRetries bool
+}
+
+// BudgetError is returned by the calls refused because the budget of their context is exhausted.
+// It's matched by ErrBudgetExhausted and is never retried.
+type BudgetError struct {
+	Budget Budget
+	// Calls and Spent are the number of calls made and the time spent in them.
+	Calls int
+	Spent time.Duration
+	// Resources counts the calls made per resource, keyed by the resource of the Template
+	// they were made from, or by their resource.
+	Resources map[string]int
+}
+
+func (e *BudgetError) Error() string {
+	var b strings.Builder
+	b.WriteString("api: call budget exhausted: ")
+	if e.Budget.MaxCalls > 0 && e.Calls >= e.Budget.MaxCalls {
+		fmt.Fprintf(&b, "%d calls of %d", e.Calls, e.Budget.MaxCalls)
+	} else {
+		fmt.Fprintf(&b, "%s spent of %s", e.Spent, e.Budget.MaxDuration)
+	}
+	resources := make([]string, 0, len(e.Resources))
+	for r := range e.Resources {
+		resources = append(resources, r)
+	}
+	sort.Strings(resources)
+	for i, r := range resources {
+		if i == 0 {
+			b.WriteString(" (")
+		} else {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s: %d", r, e.Resources[r])
+	}
+	if len(resources) > 0 {
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// Is makes errors.Is(err, ErrBudgetExhausted) report true.
+func (e *BudgetError) Is(target error) bool {
+	return target == ErrBudgetExhausted
+}
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *BudgetError) Class() Class { return Permanent }
+
+type budgetKey struct{}
+
+// budget is the state of a Budget shared by the calls of its context.
+type budget struct {
+	Budget
+	parent    *budget
+	mu        sync.Mutex
+	calls     int
+	spent     time.Duration
+	resources map[string]int
+}
+
+// WithBudget returns a copy of ctx limiting the calls of every Api made with it or a context
+// derived from it to b, failing the calls beyond it with a *BudgetError. Concurrent calls share
+// the budget. A budget set on a context already having one is spent along with the outer one.
+func WithBudget(ctx context.Context, b Budget) context.Context {
+	parent, _ := ctx.Value(budgetKey{}).(*budget)
+	return context.WithValue(ctx, budgetKey{}, &budget{Budget: b, parent: parent, resources: make(map[string]int)})
+}
+
+// spendBudget charges a call of resource to the budgets of ctx, returning a func reporting
+// the time spent in it. It fails if a budget is exhausted, without charging any.
+func spendBudget(ctx context.Context, resource string, retry bool) (func(time.Duration), error) {
+	b, _ := ctx.Value(budgetKey{}).(*budget)
+	if b == nil {
+		return func(time.Duration) {}, nil
+	}
+	var chain []*budget
+	for ; b != nil; b = b.parent {
+		b.mu.Lock()
+		chain = append(chain, b)
+	}
+	defer func() {
+		for _, b := range chain {
+			b.mu.Unlock()
+		}
+	}()
+	for _, b := range chain {
+		counted := !retry || !b.ExcludeRetries
+		if counted && b.MaxCalls > 0 && b.calls >= b.MaxCalls || b.MaxDuration > 0 && b.spent >= b.MaxDuration {
+			return nil, b.exhausted()
+		}
+	}
+	for _, b := range chain {
+		if !retry || !b.ExcludeRetries {
+			b.calls++
+		}
+		b.resources[resource]++
+	}
+	return func(d time.Duration) {
+		for _, b := range chain {
+			b.mu.Lock()
+			b.spent += d
+			b.mu.Unlock()
+		}
+	}, nil
+}
+
+// exhausted returns the error reporting the spending of b. b must be locked.
+func (b *budget) exhausted() *BudgetError {
+	resources := make(map[string]int, len(b.resources))
+	for r, n := range b.resources {
+		resources[r] = n
+	}
+	return &BudgetError{Budget: b.Budget, Calls: b.calls, Spent: b.spent, Resources: resources}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+// budgetApi returns an Api whose transport answers every request with status, counting them.
+func budgetApi(status int, hits *atomic.Int32, before func()) *Api {
+	a := MustNew("http://example.com")
+	a.Client = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		hits.Add(1)
+		if before != nil {
+			before()
+		}
+		return &http.Response{StatusCode: status, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: http.NoBody, Request: req}, nil
+	})}
+	return a
+}
+
+func TestBudgetCalls(t *testing.T) {
+	var hits atomic.Int32
+	a := budgetApi(http.StatusNoContent, &hits, nil)
+	users := a.Template(GET, "/users/{id}")
+	ctx := WithBudget(context.Background(), Budget{MaxCalls: 5})
+
+	for _, id := range []string{"1", "2", "3"} {
+		assert.NoError(t, users.Do(ctx, map[string]string{"id": id}, nil, nil))
+	}
+	assert.NoError(t, a.Get(ctx, "/orders", nil, nil))
+	req, _ := a.Request(GET, "/orders", nil)
+	resp, err := a.Do(ctx, req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+
+	err = users.Do(ctx, map[string]string{"id": "4"}, nil, nil)
+	var be *BudgetError
+	if assert.ErrorAs(t, err, &be) {
+		assert.ErrorIs(t, err, ErrBudgetExhausted)
+		assert.Equal(t, 5, be.Calls)
+		assert.Equal(t, map[string]int{"/users/{id}": 3, "/orders": 2}, be.Resources)
+		assert.EqualError(t, err, "api: call budget exhausted: 5 calls of 5 (/orders: 2, /users/{id}: 3)")
+	}
+	assert.Equal(t, int32(5), hits.Load())
+
+	// Other contexts aren't limited.
+	assert.NoError(t, a.Get(context.Background(), "/orders", nil, nil))
+}
+
+func TestBudgetConcurrent(t *testing.T) {
+	var hits atomic.Int32
+	a := budgetApi(http.StatusNoContent, &hits, nil)
+	ctx := WithBudget(context.Background(), Budget{MaxCalls: 20})
+	var wg sync.WaitGroup
+	var ok, exhausted atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch err := a.Get(ctx, "/items", nil, nil); {
+			case err == nil:
+				ok.Add(1)
+			case errors.Is(err, ErrBudgetExhausted):
+				exhausted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(20), ok.Load())
+	assert.Equal(t, int32(30), exhausted.Load())
+	assert.Equal(t, int32(20), hits.Load())
+}
+
+func TestBudgetRetries(t *testing.T) {
+	var hits atomic.Int32
+	a := budgetApi(http.StatusServiceUnavailable, &hits, nil)
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	a.SetClock(fakeClock())
+
+	// Retries count by default, and the exhausted budget isn't retried.
+	err := a.Get(WithBudget(context.Background(), Budget{MaxCalls: 2}), "/items", nil, nil)
+	var be *BudgetError
+	if assert.ErrorAs(t, err, &be) {
+		assert.Equal(t, map[string]int{"/items": 2}, be.Resources)
+	}
+	assert.Equal(t, int32(2), hits.Load())
+
+	ctx := WithBudget(context.Background(), Budget{MaxCalls: 2, ExcludeRetries: true})
+	var se *StatusError
+	assert.ErrorAs(t, a.Get(ctx, "/items", nil, nil), &se)
+	assert.Equal(t, int32(6), hits.Load())
+	err = a.Get(ctx, "/items", nil, nil)
+	assert.ErrorAs(t, err, &se)
+	err = a.Get(ctx, "/items", nil, nil)
+	if assert.ErrorAs(t, err, &be) {
+		assert.Equal(t, 2, be.Calls)
+		assert.Equal(t, map[string]int{"/items": 8}, be.Resources)
+	}
+}
+
+func TestBudgetDuration(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var hits atomic.Int32
+	a := budgetApi(http.StatusNoContent, &hits, func() { clk.Advance(time.Second) })
+	a.SetClock(clk)
+	outer := WithBudget(context.Background(), Budget{MaxDuration: 3 * time.Second})
+	ctx := WithBudget(outer, Budget{MaxDuration: 2 * time.Second})
+
+	assert.NoError(t, a.Get(ctx, "/a", nil, nil))
+	assert.NoError(t, a.Get(ctx, "/b", nil, nil))
+	err := a.Get(ctx, "/a", nil, nil)
+	var be *BudgetError
+	if assert.ErrorAs(t, err, &be) {
+		assert.Equal(t, 2*time.Second, be.Spent)
+		assert.Equal(t, map[string]int{"/a": 1, "/b": 1}, be.Resources)
+		assert.EqualError(t, err, "api: call budget exhausted: 2s spent of 2s (/a: 1, /b: 1)")
+	}
+
+	// The inner budget spends the outer one too.
+	assert.NoError(t, a.Get(outer, "/c", nil, nil))
+	err = a.Get(outer, "/c", nil, nil)
+	if assert.ErrorAs(t, err, &be) {
+		assert.Equal(t, 3*time.Second, be.Spent)
+		assert.True(t, strings.HasPrefix(err.Error(), "api: call budget exhausted: 3s spent of 3s"), err.Error())
+	}
+	assert.Equal(t, int32(3), hits.Load())
+}
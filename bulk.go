@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// BulkOptions configures Bulk. The zero value posts the items in batches of 100 and expects the
+// response body to be an array of results in the order of the items, each telling its outcome
+// in a "status" field and the reason of its failure in an "error" field.
+type BulkOptions struct {
+	// BatchSize is the number of items posted per request, 100 if zero.
+	BatchSize int
+	// ResultsPath is the dotted path to the array of results within the response body, e.g. "items".
+	// If empty, the response body itself must be the array.
+	ResultsPath string
+	// StatusPath is the dotted path to the outcome within a result, "status" by default.
+	StatusPath string
+	// Success are the outcomes of the successful results, compared with the string or the
+	// JSON text of the value at StatusPath, e.g. "created" or "true". By default, the results
+	// succeed if their outcome is "ok", "success" or a 2xx status code.
+	Success []string
+	// MessagePath is the dotted path to the reason of the failure within a result, "error" by default.
+	MessagePath string
+	// Options are applied to every batch request.
+	Options []Option
+}
+
+// BulkResult reports the outcome of every item passed to Bulk.
+type BulkResult struct {
+	// Succeeded are the indices of the items that succeeded, in items, in ascending order.
+	Succeeded []int
+	// Failed are the items that failed, by ascending index.
+	Failed []BulkFailure
+}
+
+// BulkFailure is an item that failed in Bulk.
+type BulkFailure struct {
+	// Index is the index of the item in items.
+	Index int
+	// Batch is the 0-based number of the batch the item was posted with, and Position its index
+	// within the batch.
+	Batch, Position int
+	// Message is the reason of the failure: the value at MessagePath, or the message of Err.
+	Message string
+	// Result is the result of the item, nil if the batch failed as a whole.
+	Result json.RawMessage
+	// Err is the error of the batch request if it failed as a whole, e.g. a *StatusError.
+	Err error
+}
+
+// Bulk posts items as JSON arrays to the bulk endpoint at resource, in batches of opts.BatchSize,
+// lowered to the MaxBatchSize of the capabilities of Discover with the BatchSizes of its policy,
+// and reports the outcome of every item from the per-item results of the responses, in the
+// order of the items. A batch whose request fails, whose response can't be decoded or whose
+// results are fewer than its items fails as a whole: all of its items, or those without a
+// result, fail with the error. The batches are posted one after the other; once ctx is done,
+// the rest of them fail with its error, which Bulk returns along with the result.
+func Bulk[T any](ctx context.Context, a *Api, resource string, items []T, opts *BulkOptions) (*BulkResult, error) {
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+	size := opts.BatchSize
+	if size <= 0 {
+		size = 100
+	}
+	if caps := a.discovered(func(p *DiscoveryPolicy) bool { return p.BatchSizes }); caps.MaxBatchSize() > 0 {
+		size = min(size, caps.MaxBatchSize())
+	}
+	result := &BulkResult{}
+	for start, batch := 0, 0; start < len(items); start, batch = start+size, batch+1 {
+		end := min(start+size, len(items))
+		results, err := a.postBatch(ctx, resource, items[start:end], opts)
+		for i := start; i < end; i++ {
+			pos := i - start
+			if pos >= len(results) {
+				if err == nil {
+					err = &BulkResultsError{Batch: batch, Items: end - start, Results: len(results)}
+				}
+				result.Failed = append(result.Failed, BulkFailure{Index: i, Batch: batch, Position: pos, Message: err.Error(), Err: err})
+				continue
+			}
+			if ok, msg := opts.outcome(results[pos]); !ok {
+				result.Failed = append(result.Failed, BulkFailure{Index: i, Batch: batch, Position: pos, Message: msg, Result: results[pos]})
+				continue
+			}
+			result.Succeeded = append(result.Succeeded, i)
+		}
+	}
+	return result, ctx.Err()
+}
+
+// BulkResultsError fails the items of a batch of Bulk which the response has no result for.
+type BulkResultsError struct {
+	Batch int
+	// Items and Results are the number of items in the batch and of results in the response.
+	Items, Results int
+}
+
+func (e *BulkResultsError) Error() string {
+	return fmt.Sprintf("api: bulk batch %d: %d results for %d items", e.Batch, e.Results, e.Items)
+}
+
+// postBatch posts a batch and returns its results.
+func (a *Api) postBatch(ctx context.Context, resource string, batch interface{}, opts *BulkOptions) ([]json.RawMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	req, err := a.RequestJSON(POST, resource, batch, buildContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.send(ctx, a.newCallFor(resource, opts.Options), req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkJSONContent(req, resp); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	v, err := JSONLookup(body, opts.ResultsPath)
+	if err != nil {
+		return nil, err
+	}
+	var results []json.RawMessage
+	if err := json.Unmarshal(v.Raw, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// outcome tells whether result is a success, and the reason of its failure otherwise.
+func (o *BulkOptions) outcome(result json.RawMessage) (bool, string) {
+	path := o.StatusPath
+	if path == "" {
+		path = "status"
+	}
+	var status string
+	if v, err := JSONLookup(result, path); err == nil {
+		status = v.String()
+	}
+	success := false
+	if o.Success == nil {
+		code, err := strconv.Atoi(status)
+		success = strings.EqualFold(status, "ok") || strings.EqualFold(status, "success") ||
+			err == nil && code >= 200 && code <= 299
+	}
+	for _, s := range o.Success {
+		if s == status {
+			success = true
+			break
+		}
+	}
+	if success {
+		return true, ""
+	}
+	path = o.MessagePath
+	if path == "" {
+		path = "error"
+	}
+	v, err := JSONLookup(result, path)
+	if err != nil {
+		return false, ""
+	}
+	return false, v.String()
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulk(t *testing.T) {
+	type op struct {
+		ID  int    `json:"id"`
+		Op  string `json:"op"`
+		Bad bool   `json:"bad,omitempty"`
+	}
+	var batches [][]op
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []op
+		json.NewDecoder(r.Body).Decode(&batch)
+		batches = append(batches, batch)
+		if r.URL.Path == "/down" && len(batches) == 2 {
+			http.Error(w, "overloaded", http.StatusBadRequest)
+			return
+		}
+		results := []map[string]interface{}{}
+		for _, o := range batch {
+			if o.Bad {
+				results = append(results, map[string]interface{}{"status": 422, "error": map[string]string{"message": "invalid op " + o.Op}})
+			} else {
+				results = append(results, map[string]interface{}{"status": 201, "id": o.ID})
+			}
+		}
+		if r.URL.Path == "/short" {
+			results = results[:1]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+	ops := []op{{1, "add", false}, {2, "del", true}, {3, "add", false}, {4, "mv", true}, {5, "add", false}}
+	opts := &BulkOptions{BatchSize: 3, ResultsPath: "results", MessagePath: "error.message"}
+
+	res, err := Bulk(ctx, a, "/bulk", ops, opts)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, [][]op{ops[:3], ops[3:]}, batches)
+	assert.Equal(t, []int{0, 2, 4}, res.Succeeded)
+	if assert.Len(t, res.Failed, 2) {
+		assert.Equal(t, BulkFailure{Index: 1, Batch: 0, Position: 1, Message: "invalid op del",
+			Result: json.RawMessage(`{"error":{"message":"invalid op del"},"status":422}`)}, res.Failed[0])
+		f := res.Failed[1]
+		assert.Equal(t, []interface{}{3, 1, 0, "invalid op mv"}, []interface{}{f.Index, f.Batch, f.Position, f.Message})
+		assert.NoError(t, f.Err)
+	}
+
+	// A batch failing as a whole fails all its items.
+	batches = nil
+	res, err = Bulk(ctx, a, "/down", ops, &BulkOptions{BatchSize: 2, ResultsPath: "results", Success: []string{"201"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, batches, 3)
+	assert.Equal(t, []int{0, 4}, res.Succeeded)
+	var indices []int
+	for _, f := range res.Failed {
+		indices = append(indices, f.Index)
+	}
+	assert.Equal(t, []int{1, 2, 3}, indices)
+	assert.Equal(t, `{"message":"invalid op del"}`, res.Failed[0].Message)
+	var se *StatusError
+	if assert.ErrorAs(t, res.Failed[1].Err, &se) {
+		assert.Equal(t, http.StatusBadRequest, se.Code)
+		assert.Equal(t, se.Error(), res.Failed[1].Message)
+		assert.Nil(t, res.Failed[1].Result)
+	}
+	assert.Equal(t, res.Failed[1].Err, res.Failed[2].Err)
+	assert.Equal(t, 1, res.Failed[2].Batch)
+
+	// Missing results fail their items.
+	res, err = Bulk(ctx, a, "/short", ops[2:4], &BulkOptions{ResultsPath: "results"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, res.Succeeded)
+	if assert.Len(t, res.Failed, 1) {
+		assert.Equal(t, "api: bulk batch 0: 1 results for 2 items", res.Failed[0].Message)
+		var re *BulkResultsError
+		assert.ErrorAs(t, res.Failed[0].Err, &re)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	res, err = Bulk(canceled, a, "/bulk", ops, opts)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, res.Failed, len(ops))
+}
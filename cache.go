@@ -0,0 +1,563 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachePolicy configures the response cache of SetCache.
+type CachePolicy struct {
+	// MaxEntries limits the number of responses kept, 1024 if zero.
+	MaxEntries int
+	// MaxBodySize limits the size of a body kept, 1MB if zero; larger ones aren't kept.
+	MaxBodySize int
+	// Heuristic enables the heuristic freshness of RFC 9111, section 4.2.2: a response with
+	// a Last-Modified header but no explicit freshness is considered fresh for a tenth of the
+	// time elapsed since its last modification.
+	Heuristic bool
+	// NegativeTTL enables the negative caching: the 404 Not Found responses of GET calls, and
+	// those of NegativeStatuses, are kept for NegativeTTL whatever their Cache-Control headers,
+	// unless they're no-store, and answer the calls with the same *StatusError in the meantime,
+	// setting ResponseMeta.Cached. The successful writes through the Api drop the ones they touch,
+	// see NegativeInvalidation.
+	NegativeTTL time.Duration
+	// NegativeStatuses are the statuses kept by the negative caching, only 404 if empty,
+	// e.g. 404 and 410.
+	NegativeStatuses []int
+	// NegativeInvalidation is how the writes are matched with the kept negative responses,
+	// InvalidateExact by default.
+	NegativeInvalidation Invalidation
+	// VaryHeaders are the request headers named by the Vary headers of the responses which
+	// keep a response per variant of a URL, e.g. per X-Device; Accept, Accept-Encoding and
+	// Accept-Language if nil. The responses varying on other headers are kept one per URL, the
+	// last one, answering only the requests with its values.
+	VaryHeaders []string
+}
+
+// Invalidation is how CachePolicy.NegativeInvalidation matches the successful writes, the calls
+// of other methods than GET, HEAD and OPTIONS, with the kept negative responses. The query
+// strings are ignored either way.
+type Invalidation int
+
+const (
+	// InvalidateExact drops the negative responses of the URL written to.
+	InvalidateExact Invalidation = iota
+	// InvalidatePrefix drops those of the URL written to and of the URLs under its path, e.g. a
+	// POST to /users dropping the miss of /users/42.
+	InvalidatePrefix
+)
+
+// respCache keeps the responses of GET calls for SetCache.
+type respCache struct {
+	policy  CachePolicy
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	// varies are the VaryHeaders named by the last response kept for a key, sorted.
+	varies map[string][]string
+}
+
+// cacheEntry is a kept response. It's replaced rather than changed once stored, except for refreshing.
+type cacheEntry struct {
+	status int
+	header http.Header
+	body   []byte
+	// vary holds the values of the request headers named by the Vary header of the response.
+	vary map[string]string
+	// requested and received are when the request was sent and the response received.
+	requested, received time.Time
+	directives          map[string]string
+	refreshing          bool
+	// expires is when a negative entry expires, zero for the others; target is the host and
+	// path of its URL.
+	expires time.Time
+	target  string
+	// key is the key of the entry, that of its variant.
+	key string
+}
+
+// cacheCall is the cache state of a call of a Do-style helper.
+type cacheCall struct {
+	cache *respCache
+	// looked is set once the first attempt has looked the request up.
+	looked bool
+	// key is the key the response is stored under, empty if it must not be stored.
+	key string
+	// entry is the entry being revalidated by a conditional request.
+	entry *cacheEntry
+	// refresh is set for the background revalidations of stale-while-revalidate.
+	refresh bool
+	// hit is set when the answer comes from the cache.
+	hit                 bool
+	requested, received time.Time
+}
+
+// SetCache makes the Do-style helpers cache the responses of GET calls following their
+// Cache-Control headers (RFC 9111), as a private cache would:
+//   - a response is fresh for its max-age, or until its Expires date; the Age of a response is
+//     computed from its Date and Age headers and the time spent in the cache. Fresh responses
+//     are answered without a network call and set ResponseMeta.Cached;
+//   - a response with no-cache, or one no longer fresh, is revalidated with a conditional
+//     request using its ETag and Last-Modified validators; a 304 Not Modified answers the call
+//     with the kept response;
+//   - a stale response within its stale-while-revalidate window is answered right away with
+//     a Warning 110 "Response is Stale", setting ResponseMeta.Stale, while it's revalidated
+//     in the background;
+//   - no-store responses are never kept, and private ones are;
+//   - a request with no-store bypasses the cache, one with no-cache or max-age=0 is revalidated.
+//
+// Responses are told apart by their canonical key, including the Accept, Accept-Language and
+// Authorization headers, and by the request headers named by their Vary header, a response
+// being kept per variant for those of CachePolicy.VaryHeaders.
+// Requests carrying their own If-None-Match or If-Modified-Since bypass the cache. The misses
+// are kept too with CachePolicy.NegativeTTL.
+//
+// The cache is disabled by default; a nil p disables it again, dropping the kept responses.
+func (a *Api) SetCache(p *CachePolicy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if p == nil {
+		a.cache = nil
+		return
+	}
+	policy := *p
+	if policy.MaxEntries <= 0 {
+		policy.MaxEntries = 1024
+	}
+	if policy.MaxBodySize <= 0 {
+		policy.MaxBodySize = 1 << 20
+	}
+	if policy.VaryHeaders == nil {
+		policy.VaryHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language"}
+	}
+	vary := make([]string, len(policy.VaryHeaders))
+	for i, name := range policy.VaryHeaders {
+		vary[i] = http.CanonicalHeaderKey(name)
+	}
+	policy.VaryHeaders = vary
+	a.cache = &respCache{policy: policy, entries: make(map[string]*cacheEntry), varies: make(map[string][]string)}
+}
+
+// cacheFor returns the cache state of a call sending req, nil if the cache doesn't apply.
+func (a *Api) cacheFor(req *http.Request) *cacheCall {
+	if req.Method != http.MethodGet {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cache == nil {
+		return nil
+	}
+	return &cacheCall{cache: a.cache}
+}
+
+// lookup answers the call from the cache if it has a usable response to req, or makes req
+// revalidate the kept one. It's only done on the first attempt of the call.
+func (a *Api) lookup(ctx context.Context, c *call, req *http.Request, now time.Time) (*http.Response, bool) {
+	cc := c.cache
+	if cc.looked {
+		return nil, false
+	}
+	cc.looked = true
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return nil, false
+	}
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return nil, false
+	}
+	key, err := CanonicalKey(req, staleHeaders)
+	if err != nil {
+		return nil, false
+	}
+	cc.key = key
+	rc := cc.cache
+	rc.mu.Lock()
+	if names := rc.varies[key]; names != nil {
+		key = variantKey(key, names, req.Header)
+	}
+	e, ok := rc.entries[key]
+	if ok && !e.matches(req) {
+		e, ok = nil, false
+	}
+	rc.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() {
+		if _, reqNoCache := reqCC["no-cache"]; cc.refresh || reqNoCache || !now.Before(e.expires) {
+			return nil, false
+		}
+		cc.hit, cc.key = true, ""
+		return a.cachedResponse(c, req, e, now.Sub(e.received), now, ""), true
+	}
+
+	age := e.age(now)
+	lifetime := e.lifetime(rc.policy.Heuristic)
+	_, noCache := e.directives["no-cache"]
+	_, reqNoCache := reqCC["no-cache"]
+	if v, ok := reqCC["max-age"]; ok {
+		if maxAge, err := strconv.ParseInt(v, 10, 64); err == nil {
+			lifetime = min(lifetime, time.Duration(maxAge)*time.Second)
+		}
+	}
+	if !cc.refresh && !noCache && !reqNoCache {
+		switch {
+		case age < lifetime:
+			cc.hit, cc.key = true, ""
+			return a.cachedResponse(c, req, e, age, now, ""), true
+		case age < lifetime+e.seconds("stale-while-revalidate"):
+			cc.key = ""
+			resp := a.cachedResponse(c, req, e, age, now, `110 - "Response is Stale"`)
+			rc.mu.Lock()
+			refresh := !e.refreshing
+			e.refreshing = true
+			rc.mu.Unlock()
+			if refresh {
+				resource := c.resource
+				a.goBackground(context.WithoutCancel(ctx), func(ctx context.Context) {
+					a.refresh(rc, resource, req.Clone(ctx), e)
+				})
+			}
+			return resp, true
+		}
+	}
+	if etag := e.header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+		cc.entry = e
+	}
+	if lm := e.header.Get("Last-Modified"); lm != "" {
+		req.Header.Set("If-Modified-Since", lm)
+		cc.entry = e
+	}
+	return nil, false
+}
+
+// cachedResponse creates the answer to req from e, with a warning if it's stale.
+func (a *Api) cachedResponse(c *call, req *http.Request, e *cacheEntry, age time.Duration, now time.Time, warning string) *http.Response {
+	header := e.header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	if warning != "" {
+		header.Add("Warning", warning)
+	}
+	resp := storedResponse(req, e.status, header, e.body)
+	if c.meta != nil {
+		c.meta.fill(resp, now, now)
+		p := ProvenanceCache
+		if warning != "" {
+			p = ProvenanceCacheStale
+		}
+		c.meta.stored(p, e.received, age)
+	}
+	resp.Body = c.wrapBody(resp)
+	return resp
+}
+
+// refresh revalidates e of rc in the background for stale-while-revalidate.
+func (a *Api) refresh(rc *respCache, resource string, req *http.Request, e *cacheEntry) {
+	defer func() {
+		rc.mu.Lock()
+		e.refreshing = false
+		rc.mu.Unlock()
+	}()
+	c := a.newCallFor(resource, nil)
+	c.cache = &cacheCall{cache: rc, refresh: true}
+	resp, err := a.send(req.Context(), c, req)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// revalidated answers a revalidating call with the kept response if resp is a 304 Not Modified,
+// updating the kept response with the header of resp.
+func (cc *cacheCall) revalidated(req *http.Request, resp *http.Response) *http.Response {
+	if cc.entry == nil || resp.StatusCode != http.StatusNotModified {
+		return resp
+	}
+	drainClose(resp.Body)
+	old := cc.entry
+	e := &cacheEntry{status: old.status, header: old.header.Clone(), body: old.body, vary: old.vary,
+		requested: cc.requested, received: cc.received, key: old.key}
+	for name, values := range resp.Header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		e.header[name] = values
+	}
+	e.directives = parseCacheControl(e.header)
+	rc := cc.cache
+	rc.mu.Lock()
+	if rc.entries[old.key] == old {
+		rc.entries[old.key] = e
+	}
+	rc.mu.Unlock()
+	cc.hit, cc.key = true, ""
+	return storedResponse(req, e.status, e.header.Clone(), e.body)
+}
+
+// keep makes resp be kept by the cache once its body has been read to the end, if it may be stored.
+func (cc *cacheCall) keep(req *http.Request, resp *http.Response) {
+	if cc.key == "" {
+		return
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent:
+	default:
+		return
+	}
+	if _, ok := parseCacheControl(req.Header)["no-store"]; ok {
+		return
+	}
+	e := &cacheEntry{status: resp.StatusCode, header: resp.Header.Clone(), requested: cc.requested, received: cc.received}
+	e.directives = parseCacheControl(e.header)
+	if _, ok := e.directives["no-store"]; ok {
+		return
+	}
+	_, maxAge := e.directives["max-age"]
+	explicit := maxAge || e.header.Get("Expires") != ""
+	validators := e.header.Get("ETag") != "" || e.header.Get("Last-Modified") != ""
+	if !explicit && !validators {
+		return
+	}
+	var ok bool
+	if e.vary, ok = varyOf(req, e.header); !ok {
+		return
+	}
+	rc, key := cc.cache, cc.key
+	resp.Body = &keptBody{ReadCloser: resp.Body, limit: rc.policy.MaxBodySize, done: func(body []byte) {
+		e.body = body
+		rc.store(key, e)
+	}}
+}
+
+// varyOf returns the values of the request headers of req named by the Vary header, false if
+// the response can't be kept.
+func varyOf(req *http.Request, header http.Header) (map[string]string, bool) {
+	var vary map[string]string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			}
+			if name == "" {
+				continue
+			}
+			if vary == nil {
+				vary = make(map[string]string)
+			}
+			vary[name] = strings.Join(req.Header.Values(name), ",")
+		}
+	}
+	return vary, true
+}
+
+// keepNegative keeps the response of err failing the call of req if it's a negative one the
+// policy caches.
+func (cc *cacheCall) keepNegative(req *http.Request, err error) {
+	rc := cc.cache
+	if cc.key == "" || cc.hit || rc.policy.NegativeTTL <= 0 {
+		return
+	}
+	var se *StatusError
+	if !errors.As(err, &se) || se.Header == nil {
+		return
+	}
+	statuses := rc.policy.NegativeStatuses
+	if len(statuses) == 0 {
+		statuses = []int{http.StatusNotFound}
+	}
+	kept := false
+	for _, status := range statuses {
+		kept = kept || status == se.Code
+	}
+	if !kept {
+		return
+	}
+	if _, ok := parseCacheControl(req.Header)["no-store"]; ok {
+		return
+	}
+	e := &cacheEntry{status: se.Code, header: se.Header.Clone(), body: se.Body, requested: cc.requested,
+		received: cc.received, expires: cc.received.Add(rc.policy.NegativeTTL), target: req.URL.Host + req.URL.Path}
+	e.directives = parseCacheControl(e.header)
+	if _, ok := e.directives["no-store"]; ok {
+		return
+	}
+	var ok bool
+	if e.vary, ok = varyOf(req, e.header); ok {
+		rc.store(cc.key, e)
+	}
+}
+
+// invalidateNegative drops the negative responses kept by the cache that the successful write
+// req touches.
+func (a *Api) invalidateNegative(req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	a.mu.Lock()
+	rc := a.cache
+	a.mu.Unlock()
+	if rc == nil || rc.policy.NegativeTTL <= 0 {
+		return
+	}
+	target := req.URL.Host + req.URL.Path
+	prefix := strings.TrimSuffix(target, "/") + "/"
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for key, e := range rc.entries {
+		if e.expires.IsZero() {
+			continue
+		}
+		if e.target == target || rc.policy.NegativeInvalidation == InvalidatePrefix && strings.HasPrefix(e.target, prefix) {
+			delete(rc.entries, key)
+		}
+	}
+}
+
+// store keeps e for the requests of key, under the key of its variant if it varies on the
+// VaryHeaders of the policy.
+func (rc *respCache) store(key string, e *cacheEntry) {
+	var names []string
+	for _, name := range rc.policy.VaryHeaders {
+		if _, ok := e.vary[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if names == nil {
+		delete(rc.varies, key)
+	} else {
+		if _, ok := rc.varies[key]; !ok && len(rc.varies) >= rc.policy.MaxEntries {
+			for k := range rc.varies {
+				delete(rc.varies, k)
+				break
+			}
+		}
+		rc.varies[key] = names
+		key = variantKey(key, names, e.varyHeader())
+	}
+	e.key = key
+	if _, ok := rc.entries[key]; !ok {
+		for k := range rc.entries {
+			if len(rc.entries) < rc.policy.MaxEntries {
+				break
+			}
+			delete(rc.entries, k)
+		}
+	}
+	rc.entries[key] = e
+}
+
+// variantKey returns the key of the variant of key with the values of the headers names of h.
+func variantKey(key string, names []string, h http.Header) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range names {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strings.Join(h.Values(name), ","))
+	}
+	return b.String()
+}
+
+// varyHeader returns the values of the request headers selected by the Vary header of e.
+func (e *cacheEntry) varyHeader() http.Header {
+	h := make(http.Header, len(e.vary))
+	for name, v := range e.vary {
+		if v != "" {
+			h[name] = []string{v}
+		}
+	}
+	return h
+}
+
+// matches reports whether req has the values of the request headers selected by the Vary header of e.
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for name, v := range e.vary {
+		if strings.Join(req.Header.Values(name), ",") != v {
+			return false
+		}
+	}
+	return true
+}
+
+// age returns the current age of e, see RFC 9111, section 4.2.3.
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	date, err := ParseHTTPDate(e.header.Get("Date"))
+	if err != nil {
+		date = e.received
+	}
+	apparent := max(0, e.received.Sub(date))
+	corrected := e.seconds("") + e.received.Sub(e.requested)
+	return max(apparent, corrected) + now.Sub(e.received)
+}
+
+// lifetime returns the freshness lifetime of e, see RFC 9111, section 4.2.1.
+func (e *cacheEntry) lifetime(heuristic bool) time.Duration {
+	if v, ok := e.directives["max-age"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+		return 0
+	}
+	date, err := ParseHTTPDate(e.header.Get("Date"))
+	if err != nil {
+		date = e.received
+	}
+	if v := e.header.Get("Expires"); v != "" {
+		// An invalid date, like "0", means the response is already expired.
+		if expires, err := ParseHTTPDate(v); err == nil {
+			return max(0, expires.Sub(date))
+		}
+		return 0
+	}
+	if heuristic {
+		if lm, err := ParseHTTPDate(e.header.Get("Last-Modified")); err == nil {
+			return max(0, date.Sub(lm)/10)
+		}
+	}
+	return 0
+}
+
+// seconds returns the duration of the directive of e given in seconds, or of the Age header
+// if directive is empty; it's zero if missing or malformed.
+func (e *cacheEntry) seconds(directive string) time.Duration {
+	v := e.header.Get("Age")
+	if directive != "" {
+		v = e.directives[directive]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// parseCacheControl parses the Cache-Control header of h into its lower-cased directives
+// and their unquoted values.
+func parseCacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				directives[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return directives
+}
@@ -0,0 +1,427 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+// cacheServer serves {"n":<hits>} with the header set by header, dated by clk.
+func cacheServer(clk *clock.Fake, hits *atomic.Int32, header func(w http.ResponseWriter, r *http.Request) bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		w.Header().Set("Date", clk.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/json")
+		if !header(w, r) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`{"n":` + strconv.Itoa(int(n)) + `}`))
+	}))
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var hits atomic.Int32
+	srv := cacheServer(clk, &hits, func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		return true
+	})
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(clk)
+	var out struct{ N int }
+
+	// Disabled by default.
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.Equal(t, int32(2), hits.Load())
+
+	a.SetCache(&CachePolicy{})
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.Equal(t, 3, out.N)
+	clk.Advance(10 * time.Second)
+	var meta ResponseMeta
+	out.N = 0
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.Equal(t, 3, out.N)
+	assert.Equal(t, int32(3), hits.Load())
+	assert.True(t, meta.Cached)
+	assert.False(t, meta.Stale)
+	assert.Equal(t, 10*time.Second, meta.Age)
+	assert.Equal(t, "10", meta.Header.Get("Age"))
+
+	// Other requests and methods aren't answered by the cache.
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithHeader("Authorization", "Bearer other")))
+	assert.NoError(t, a.Post(context.Background(), "/items", nil, nil))
+	assert.Equal(t, int32(5), hits.Load())
+
+	// A request with no-cache is revalidated; without validators, that's a new request.
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithHeader("Cache-Control", "no-cache"), WithMeta(&meta)))
+	assert.Equal(t, 6, out.N)
+	assert.False(t, meta.Cached)
+
+	clk.Advance(61 * time.Second)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.Equal(t, 7, out.N)
+	assert.False(t, meta.Cached)
+
+	a.SetCache(nil)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.Equal(t, int32(8), hits.Load())
+}
+
+func TestCacheNoStore(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var hits atomic.Int32
+	var cc atomic.Value
+	cc.Store("no-store, max-age=60")
+	srv := cacheServer(clk, &hits, func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", cc.Load().(string))
+		w.Header().Set("ETag", `"v1"`)
+		return true
+	})
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(clk)
+	a.SetCache(&CachePolicy{})
+	var out struct{ N int }
+
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.Equal(t, 2, out.N)
+
+	// A request with no-store neither uses nor fills the cache.
+	cc.Store("max-age=60")
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithHeader("Cache-Control", "no-store")))
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.Equal(t, 4, out.N)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithHeader("Cache-Control", "no-store")))
+	assert.Equal(t, 5, out.N)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.Equal(t, 4, out.N)
+}
+
+func TestCacheNoCache(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var hits atomic.Int32
+	var mu sync.Mutex
+	etag, conditional := `"v1"`, ""
+	srv := cacheServer(clk, &hits, func(w http.ResponseWriter, r *http.Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		conditional = r.Header.Get("If-None-Match")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		return conditional != etag
+	})
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(clk)
+	a.SetCache(&CachePolicy{})
+	var out struct{ N int }
+
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.Equal(t, 1, out.N)
+	var meta ResponseMeta
+	out.N = 0
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.Equal(t, 1, out.N)
+	assert.Equal(t, int32(2), hits.Load())
+	assert.True(t, meta.Cached)
+	assert.Equal(t, http.StatusOK, meta.StatusCode)
+	mu.Lock()
+	assert.Equal(t, `"v1"`, conditional)
+	etag = `"v2"`
+	mu.Unlock()
+
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.Equal(t, 3, out.N)
+	assert.False(t, meta.Cached)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.Equal(t, 3, out.N)
+	mu.Lock()
+	assert.Equal(t, `"v2"`, conditional)
+	mu.Unlock()
+
+	// Requests with their own validators bypass the cache.
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithHeader("If-None-Match", `"v0"`)))
+	assert.Equal(t, 5, out.N)
+}
+
+func TestCacheAge(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := &cacheEntry{
+		header:    http.Header{"Date": {now.Add(-30 * time.Second).Format(http.TimeFormat)}, "Age": {"10"}},
+		requested: now.Add(-2 * time.Second),
+		received:  now,
+	}
+	// The apparent age wins over the Age header corrected by the response delay.
+	assert.Equal(t, 30*time.Second, e.age(now))
+	assert.Equal(t, 35*time.Second, e.age(now.Add(5*time.Second)))
+	e.header.Set("Age", "40")
+	assert.Equal(t, 42*time.Second, e.age(now))
+	// A Date in the future doesn't make the age negative.
+	e.header = http.Header{"Date": {now.Add(time.Hour).Format(http.TimeFormat)}}
+	assert.Equal(t, 2*time.Second, e.age(now))
+
+	e.header = http.Header{"Date": {now.Format(http.TimeFormat)}, "Expires": {now.Add(time.Minute).Format(http.TimeFormat)}}
+	e.directives = parseCacheControl(e.header)
+	assert.Equal(t, time.Minute, e.lifetime(false))
+	e.header.Set("Expires", "0")
+	assert.Equal(t, time.Duration(0), e.lifetime(false))
+	e.header.Set("Cache-Control", `max-age="90", must-revalidate`)
+	e.directives = parseCacheControl(e.header)
+	assert.Equal(t, 90*time.Second, e.lifetime(false))
+
+	// An upstream cache already held the response for 20s.
+	clk := clock.NewFake(now)
+	var hits atomic.Int32
+	srv := cacheServer(clk, &hits, func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Date", clk.Now().Add(-20*time.Second).UTC().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "max-age=60")
+		return true
+	})
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(clk)
+	a.SetCache(&CachePolicy{})
+	var out struct{ N int }
+	var meta ResponseMeta
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	clk.Advance(39 * time.Second)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.True(t, meta.Cached)
+	assert.Equal(t, "59", meta.Header.Get("Age"))
+	clk.Advance(time.Second)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.False(t, meta.Cached)
+	assert.Equal(t, int32(2), hits.Load())
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var hits atomic.Int32
+	srv := cacheServer(clk, &hits, func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=30")
+		return true
+	})
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(clk)
+	a.SetCache(&CachePolicy{})
+	var out struct{ N int }
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+
+	clk.Advance(15 * time.Second)
+	var meta ResponseMeta
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.Equal(t, 1, out.N)
+	assert.True(t, meta.Stale)
+	assert.False(t, meta.Cached)
+	assert.Equal(t, 15*time.Second, meta.Age)
+	assert.Equal(t, []Warning{{Code: 110, Agent: "-", Text: "Response is Stale"}}, meta.Warnings)
+
+	// The response is refreshed in the background.
+	assert.Eventually(t, func() bool {
+		var out struct{ N int }
+		var meta ResponseMeta
+		return a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)) == nil && meta.Cached && out.N == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(2), hits.Load())
+
+	// Past the window, the call waits for a new response.
+	clk.Advance(41 * time.Second)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.Equal(t, 3, out.N)
+	assert.False(t, meta.Stale)
+}
+
+func TestCacheHeuristic(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 1, 11, 0, 0, 0, 0, time.UTC))
+	var hits atomic.Int32
+	srv := cacheServer(clk, &hits, func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("Last-Modified", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+		return r.Header.Get("If-Modified-Since") == ""
+	})
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(clk)
+	var out struct{ N int }
+
+	// Without the heuristic, the response is revalidated.
+	a.SetCache(&CachePolicy{})
+	var meta ResponseMeta
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.Equal(t, 1, out.N)
+	assert.True(t, meta.Cached)
+	assert.Equal(t, int32(2), hits.Load())
+
+	// With it, it's fresh for a day.
+	a.SetCache(&CachePolicy{Heuristic: true})
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	clk.Advance(23 * time.Hour)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.True(t, meta.Cached)
+	assert.Equal(t, int32(3), hits.Load())
+	clk.Advance(time.Hour)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.Equal(t, int32(4), hits.Load())
+}
+
+func TestCacheNegative(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	exists := map[string]bool{"/users/1": true}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodGet {
+			exists[r.URL.Path] = true
+			return
+		}
+		hits[r.URL.Path]++
+		switch {
+		case r.URL.Path == "/gone":
+			w.WriteHeader(http.StatusGone)
+		case r.URL.Path == "/volatile":
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusNotFound)
+		case !exists[r.URL.Path]:
+			http.Error(w, `{"error":"no such user"}`, http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(clk)
+	a.SetCache(&CachePolicy{NegativeTTL: time.Minute})
+	ctx := context.Background()
+	count := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[path]
+	}
+
+	// The miss is answered from the cache with the same error.
+	first := a.Get(ctx, "/users/2", nil, nil)
+	assert.ErrorIs(t, first, ErrNotFound)
+	var meta ResponseMeta
+	err := a.Get(ctx, "/users/2", nil, nil, WithMeta(&meta))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, first.Error(), err.Error())
+	assert.True(t, meta.Cached)
+	assert.Equal(t, http.StatusNotFound, meta.StatusCode)
+	assert.Equal(t, 1, count("/users/2"))
+	assert.NoError(t, a.Get(ctx, "/users/1", nil, nil))
+
+	// Only the 404s are kept by default, and never the no-store ones.
+	for i := 0; i < 2; i++ {
+		a.Get(ctx, "/gone", nil, nil)
+		a.Get(ctx, "/volatile", nil, nil)
+	}
+	assert.Equal(t, 2, count("/gone"))
+	assert.Equal(t, 2, count("/volatile"))
+
+	// They expire after the TTL.
+	clk.Advance(59 * time.Second)
+	a.Get(ctx, "/users/2", nil, nil)
+	assert.Equal(t, 1, count("/users/2"))
+	clk.Advance(time.Second)
+	a.Get(ctx, "/users/2", nil, nil)
+	assert.Equal(t, 2, count("/users/2"))
+
+	// A successful write of the URL drops its miss at once.
+	a.Get(ctx, "/users/3", nil, nil)
+	assert.NoError(t, a.Put(ctx, "/users/3", map[string]string{"name": "c"}, nil))
+	assert.NoError(t, a.Get(ctx, "/users/3", nil, nil))
+	assert.Equal(t, 2, count("/users/3"))
+
+	// Writing above the URL drops it with InvalidatePrefix only.
+	a.Get(ctx, "/users/4", nil, nil)
+	assert.NoError(t, a.Post(ctx, "/users", map[string]string{"id": "4"}, nil))
+	assert.ErrorIs(t, a.Get(ctx, "/users/4", nil, nil), ErrNotFound)
+	assert.Equal(t, 1, count("/users/4"))
+
+	a.SetCache(&CachePolicy{NegativeTTL: time.Minute, NegativeStatuses: []int{404, 410}, NegativeInvalidation: InvalidatePrefix})
+	for i := 0; i < 2; i++ {
+		var se *StatusError
+		if assert.ErrorAs(t, a.Get(ctx, "/gone", nil, nil), &se) {
+			assert.Equal(t, http.StatusGone, se.Code)
+		}
+	}
+	assert.Equal(t, 3, count("/gone"))
+	a.Get(ctx, "/users/5", nil, nil)
+	a.Get(ctx, "/users/5/orders", nil, nil)
+	a.Get(ctx, "/users5", nil, nil)
+	assert.NoError(t, a.Post(ctx, "/users/", map[string]string{"id": "5"}, nil))
+	a.Get(ctx, "/users/5", nil, nil)
+	a.Get(ctx, "/users/5/orders", nil, nil)
+	a.Get(ctx, "/users5", nil, nil)
+	assert.Equal(t, 2, count("/users/5"))
+	assert.Equal(t, 2, count("/users/5/orders"))
+	assert.Equal(t, 1, count("/users5"))
+}
+
+func TestCacheVariants(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		lang := r.Header.Get("Accept-Language")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language, X-Device")
+		w.Header().Set("Content-Language", lang)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"n": %d, "lang": %q, "device": %q}`, n, lang, r.Header.Get("X-Device"))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetCache(&CachePolicy{VaryHeaders: []string{"accept-language", "X-Device"}})
+	ctx := context.Background()
+	type page struct {
+		N            int
+		Lang, Device string
+	}
+	get := func(lang, device string, meta *ResponseMeta) page {
+		var p page
+		assert.NoError(t, a.Get(ctx, "/pages/1", nil, &p, Variant("application/json", lang), WithHeader("X-Device", device), WithMeta(meta)))
+		return p
+	}
+
+	var meta ResponseMeta
+	assert.Equal(t, page{1, "fr", "mobile"}, get("fr", "mobile", &meta))
+	assert.Equal(t, "fr", meta.ContentLanguage)
+	assert.Equal(t, map[string]string{"Accept-Language": "fr", "X-Device": "mobile"}, meta.Variant)
+	assert.Equal(t, page{2, "de", "mobile"}, get("de", "mobile", &meta))
+	assert.Equal(t, page{3, "fr", "desktop"}, get("fr", "desktop", &meta))
+	assert.False(t, meta.Cached)
+
+	// The variants coexist, each one answering its own requests.
+	assert.Equal(t, page{1, "fr", "mobile"}, get("fr", "mobile", &meta))
+	assert.True(t, meta.Cached)
+	assert.Equal(t, "fr", meta.ContentLanguage)
+	assert.Equal(t, map[string]string{"Accept-Language": "fr", "X-Device": "mobile"}, meta.Variant)
+	assert.Equal(t, page{2, "de", "mobile"}, get("de", "mobile", &meta))
+	assert.Equal(t, page{3, "fr", "desktop"}, get("fr", "desktop", &meta))
+	assert.True(t, meta.Cached)
+	assert.Equal(t, int32(3), hits.Load())
+
+	// The headers outside of VaryHeaders keep one variant, never answering the others.
+	a.SetCache(&CachePolicy{})
+	assert.Equal(t, page{4, "fr", "mobile"}, get("fr", "mobile", &meta))
+	assert.Equal(t, page{5, "fr", "desktop"}, get("fr", "desktop", &meta))
+	assert.Equal(t, page{6, "fr", "mobile"}, get("fr", "mobile", &meta))
+	assert.Equal(t, page{6, "fr", "mobile"}, get("fr", "mobile", &meta))
+	assert.True(t, meta.Cached)
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CanonicalKey returns a deterministic identity of the request, suitable as a cache key:
+//
+//	GET https://example.com/items?a=1&a=2&b=x
+//	accept:application/json
+//	x-tenant:7
+//
+// The normalization rules are:
+//   - the method is upper-cased;
+//   - scheme and host are lower-cased, default ports (80 for http, 443 for https) are dropped;
+//   - an empty path becomes "/", dot segments are removed, percent-encoding is normalized
+//     to upper-case hex and unreserved characters are decoded, the rest of escaping is kept
+//     (so "/a%2Fb" and "/a/b" stay different);
+//   - the query is decoded and re-encoded sorted by key, then by value, so that
+//     "b=2&a=1&a=0" and "a=0&b=2&a=1" produce the same key; the fragment is dropped;
+//   - included headers are sorted by lower-cased name and listed even if missing,
+//     multiple values are joined with ",".
+//
+// An error is returned if the query can't be parsed.
+func CanonicalKey(req *http.Request, includeHeaders []string) (string, error) {
+	u := req.URL
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(req.Method))
+	b.WriteByte(' ')
+	scheme := strings.ToLower(u.Scheme)
+	b.WriteString(scheme)
+	b.WriteString("://")
+	b.WriteString(canonicalHost(scheme, u.Host))
+	b.WriteString(canonicalPath(u.EscapedPath()))
+	query, err := canonicalQuery(u.RawQuery)
+	if err != nil {
+		return "", err
+	}
+	if query != "" {
+		b.WriteByte('?')
+		b.WriteString(query)
+	}
+
+	names := make([]string, 0, len(includeHeaders))
+	for _, name := range includeHeaders {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	return b.String(), nil
+}
+
+// CanonicalHash is like CanonicalKey, but returns the hex-encoded SHA-256 of the key.
+func CanonicalHash(req *http.Request, includeHeaders []string) (string, error) {
+	key, err := CanonicalKey(req, includeHeaders)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func canonicalHost(scheme, host string) string {
+	host = strings.ToLower(host)
+	switch {
+	case scheme == "http" && strings.HasSuffix(host, ":80"):
+		host = strings.TrimSuffix(host, ":80")
+	case scheme == "https" && strings.HasSuffix(host, ":443"):
+		host = strings.TrimSuffix(host, ":443")
+	}
+	return host
+}
+
+func canonicalPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	p = normalizePercent(p)
+	segments := strings.Split(p, "/")
+	out := make([]string, 0, len(segments))
+	for i, seg := range segments {
+		switch seg {
+		case ".":
+			if i == len(segments)-1 {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if i == len(segments)-1 {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+	if p = strings.Join(out, "/"); !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+// normalizePercent upper-cases percent-encoded triplets and decodes the unreserved characters.
+func normalizePercent(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func canonicalQuery(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		vs := append([]string(nil), values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(url.QueryEscape(k))
+			b.WriteByte('=')
+			b.WriteString(url.QueryEscape(v))
+		}
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalKey(t *testing.T) {
+	for _, tc := range []struct {
+		method, url string
+		exp         string
+	}{
+		{"get", "HTTP://Example.COM:80", "GET http://example.com/"},
+		{"GET", "https://example.com:443/items", "GET https://example.com/items"},
+		{"GET", "https://example.com:8443/items/", "GET https://example.com:8443/items/"},
+		{"GET", "http://example.com/a/./b/../c", "GET http://example.com/a/c"},
+		{"GET", "http://example.com/a/b/..", "GET http://example.com/a/"},
+		{"GET", "http://example.com/%7euser/a%2fb", "GET http://example.com/~user/a%2Fb"},
+		{"GET", "http://example.com/x?b=2&a=1&a=0", "GET http://example.com/x?a=0&a=1&b=2"},
+		{"GET", "http://example.com/x?a=1&b=2&a=0#frag", "GET http://example.com/x?a=0&a=1&b=2"},
+		{"GET", "http://example.com/x?q=a+b&r=a%20b", "GET http://example.com/x?q=a+b&r=a+b"},
+		{"POST", "http://example.com/x?", "POST http://example.com/x"},
+	} {
+		req, err := http.NewRequest(tc.method, tc.url, nil)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		key, err := CanonicalKey(req, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.exp, key, tc.url)
+	}
+}
+
+func TestCanonicalKeyHeaders(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/x?ids=2&ids=1", nil)
+	req.Header.Set("X-Tenant", "7")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Accept", "text/plain")
+	key, err := CanonicalKey(req, []string{"x-tenant", "Accept", "X-Missing", "accept"})
+	assert.NoError(t, err)
+	assert.Equal(t, "GET http://example.com/x?ids=1&ids=2\naccept:application/json,text/plain\nx-missing:\nx-tenant:7", key)
+
+	req2, _ := http.NewRequest("GET", "http://EXAMPLE.com:80/x?ids=1&ids=2", nil)
+	req2.Header.Set("X-Tenant", "7")
+	req2.Header.Add("Accept", "application/json")
+	req2.Header.Add("Accept", "text/plain")
+	h1, err := CanonicalHash(req, []string{"Accept", "X-Tenant", "X-Missing"})
+	assert.NoError(t, err)
+	h2, err := CanonicalHash(req2, []string{"X-Missing", "x-tenant", "accept"})
+	assert.NoError(t, err)
+	assert.Equal(t, h1, h2)
+	assert.Len(t, h1, 64)
+
+	bad, _ := http.NewRequest("GET", "http://example.com/x", nil)
+	bad.URL.RawQuery = "a=%zz"
+	_, err = CanonicalKey(bad, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CaptureRule selects the calls captured in full by Capture, e.g. those of a misbehaving endpoint
+// for the next ten minutes:
+//
+//	stop := svc.Capture(api.CaptureRule{ResourceTemplate: "/payments/{id}", Methods: []api.Method{api.POST},
+//		Until: time.Now().Add(10 * time.Minute), MaxBodyBytes: 8192})
+type CaptureRule struct {
+	// Name identifies the rule in CaptureRules and CapturedCall, optional.
+	Name string
+	// ResourceTemplate is the template of the captured calls, every call if empty. It's compared
+	// with the template of the calls of a RequestTemplate, and matched against the resource of
+	// the others, its "{...}" segments matching any segment.
+	ResourceTemplate string
+	// Methods are the methods of the captured calls, all of them if empty.
+	Methods []Method
+	// Until is when the rule expires by the clock of the Api, never if zero.
+	Until time.Time
+	// MaxBodyBytes caps the captured size of each request and response body, 64KB if zero.
+	// Negative values disable body capture.
+	MaxBodyBytes int
+}
+
+// CapturedCall is an attempt of a call matched by a CaptureRule, as a HAR entry with its secrets
+// masked by the Redactor of the Api.
+type CapturedCall struct {
+	Rule  CaptureRule
+	Entry HAREntry
+}
+
+// CaptureSink receives the attempts captured by the rules of Capture, once their response body
+// is closed or they failed.
+type CaptureSink interface {
+	CaptureCall(ctx context.Context, c CapturedCall)
+}
+
+// captureSet is the state of Capture, shared with the Apis derived by ForTenant.
+type captureSet struct {
+	mu    sync.Mutex
+	state atomic.Pointer[captureState]
+}
+
+// captureState is a snapshot of the sink and the rules, replaced as a whole on every change.
+type captureState struct {
+	sink  CaptureSink
+	rules []*captureRule
+}
+
+type captureRule struct {
+	CaptureRule
+	// segs are the segments of the ResourceTemplate.
+	segs []string
+}
+
+func newCaptureSet() *captureSet { return new(captureSet) }
+
+// SetCaptureSink sets the sink receiving the calls captured by the rules of Capture. Passing nil
+// stops the capture, the rules staying in place.
+func (a *Api) SetCaptureSink(s CaptureSink) {
+	cs := a.captures.get(newCaptureSet)
+	cs.update(func(st *captureState) { st.sink = s })
+}
+
+// Capture makes the Api capture the calls matching r in full, their headers, URLs and bodies
+// masked by its Redactor, and send every attempt to the sink of SetCaptureSink, until r expires
+// or stop is called. Rules may be added and removed while calls are made; the calls matching
+// none of them pay only for the check of their template and method.
+func (a *Api) Capture(r CaptureRule) (stop func()) {
+	rule := &captureRule{CaptureRule: r}
+	rule.Methods = append([]Method(nil), r.Methods...)
+	rule.segs = strings.Split(strings.Trim(r.ResourceTemplate, "/"), "/")
+	cs := a.captures.get(newCaptureSet)
+	now := a.clock().Now()
+	cs.update(func(st *captureState) {
+		st.rules = append(st.active(now), rule)
+	})
+	return func() {
+		cs.update(func(st *captureState) {
+			var rules []*captureRule
+			for _, r := range st.rules {
+				if r != rule {
+					rules = append(rules, r)
+				}
+			}
+			st.rules = rules
+		})
+	}
+}
+
+// CaptureRules returns the rules of Capture that haven't expired or been stopped, e.g. for an
+// operational endpoint.
+func (a *Api) CaptureRules() []CaptureRule {
+	cs := a.captures.Load()
+	if cs == nil {
+		return nil
+	}
+	st := cs.state.Load()
+	if st == nil {
+		return nil
+	}
+	var rules []CaptureRule
+	for _, r := range st.active(a.clock().Now()) {
+		rule := r.CaptureRule
+		rule.Methods = append([]Method(nil), r.Methods...)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// update replaces the state of cs by a copy changed by fn.
+func (cs *captureSet) update(fn func(st *captureState)) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	var st captureState
+	if old := cs.state.Load(); old != nil {
+		st = *old
+	}
+	fn(&st)
+	cs.state.Store(&st)
+}
+
+// active returns the rules of st that haven't expired at now.
+func (st *captureState) active(now time.Time) []*captureRule {
+	var rules []*captureRule
+	for _, r := range st.rules {
+		if r.Until.IsZero() || now.Before(r.Until) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// matches reports whether the rule applies to a call with the template or, if it has none, the
+// resource, like matchTemplate but without allocating.
+func (r *captureRule) matches(method, template, resource string, now time.Time) bool {
+	if !r.Until.IsZero() && !now.Before(r.Until) {
+		return false
+	}
+	if len(r.Methods) > 0 {
+		found := false
+		for _, m := range r.Methods {
+			if m.String() == method {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	switch {
+	case r.ResourceTemplate == "":
+		return true
+	case template != "":
+		return template == r.ResourceTemplate
+	}
+	if i := strings.IndexAny(resource, "?#"); i >= 0 {
+		resource = resource[:i]
+	}
+	rest, more := strings.Trim(resource, "/"), true
+	for _, seg := range r.segs {
+		if !more {
+			return false
+		}
+		var part string
+		part, rest, more = strings.Cut(rest, "/")
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if part == "" {
+				return false
+			}
+			continue
+		}
+		if seg != part {
+			return false
+		}
+	}
+	return !more
+}
+
+// captureCall is an attempt being captured.
+type captureCall struct {
+	a     *Api
+	ctx   context.Context
+	rule  CaptureRule
+	sink  CaptureSink
+	entry harEntry
+	limit int
+	once  sync.Once
+}
+
+// captureFor returns the capture of an attempt sending req, nil if no rule matches it. The body
+// of req is replaced by a capturing one if it can only be read once.
+func (a *Api) captureFor(ctx context.Context, c *call, req *http.Request) (*captureCall, error) {
+	cs := a.captures.Load()
+	if cs == nil {
+		return nil, nil
+	}
+	st := cs.state.Load()
+	if st == nil || st.sink == nil || len(st.rules) == 0 {
+		return nil, nil
+	}
+	resource := c.resource
+	if resource == "" {
+		resource = req.URL.Path
+	}
+	now := a.clock().Now()
+	var rule *captureRule
+	for _, r := range st.rules {
+		if r.matches(req.Method, c.template, resource, now) {
+			rule = r
+			break
+		}
+	}
+	if rule == nil {
+		return nil, nil
+	}
+	cc := &captureCall{a: a, ctx: ctx, rule: rule.CaptureRule, sink: st.sink, limit: rule.MaxBodyBytes}
+	if cc.limit == 0 {
+		cc.limit = 64 << 10
+	}
+	cc.entry.started = time.Now()
+	cc.entry.req = req
+	if req.Body == nil || req.Body == http.NoBody || cc.limit < 0 {
+		return cc, nil
+	}
+	data, encoded := encodedBody(req)
+	switch {
+	case encoded:
+		if len(data) > cc.limit {
+			data = data[:cc.limit]
+		}
+		cc.entry.reqBody = append([]byte(nil), data...)
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		cc.entry.reqBody, err = io.ReadAll(io.LimitReader(body, int64(cc.limit)))
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		req.Body = &captureBody{ReadCloser: req.Body, limit: cc.limit, add: func(p []byte) {
+			cc.entry.mu.Lock()
+			cc.entry.reqBody = append(cc.entry.reqBody, p...)
+			cc.entry.mu.Unlock()
+		}}
+	}
+	return cc, nil
+}
+
+// fail sends the capture of an attempt failed without a response.
+func (cc *captureCall) fail(err error) {
+	cc.entry.mu.Lock()
+	cc.entry.err = err
+	cc.entry.mu.Unlock()
+	cc.entry.mark(&cc.entry.done)
+	cc.send()
+}
+
+// wrap returns the body of resp capturing what's read from it, sending the capture once it's
+// read or closed.
+func (cc *captureCall) wrap(resp *http.Response) io.ReadCloser {
+	e := &cc.entry
+	e.mu.Lock()
+	e.resp = resp
+	e.mu.Unlock()
+	limit := cc.limit
+	if limit < 0 {
+		limit = 0
+	}
+	return &captureBody{ReadCloser: resp.Body, limit: limit, add: func(p []byte) {
+		e.mu.Lock()
+		e.respBody = append(e.respBody, p...)
+		e.mu.Unlock()
+	}, count: func(n int) {
+		e.mu.Lock()
+		e.respSize += int64(n)
+		e.mu.Unlock()
+	}, finish: func() {
+		e.mark(&e.done)
+		cc.send()
+	}}
+}
+
+func (cc *captureCall) send() {
+	cc.once.Do(func() {
+		cc.sink.CaptureCall(cc.ctx, CapturedCall{Rule: cc.rule, Entry: cc.entry.export(cc.a.Redactor())})
+	})
+}
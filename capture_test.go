@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+// captureLog is a CaptureSink keeping the captured calls.
+type captureLog struct {
+	mu    sync.Mutex
+	calls []CapturedCall
+}
+
+func (l *captureLog) CaptureCall(ctx context.Context, c CapturedCall) {
+	l.mu.Lock()
+	l.calls = append(l.calls, c)
+	l.mu.Unlock()
+}
+
+func (l *captureLog) take() []CapturedCall {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	calls := l.calls
+	l.calls = nil
+	return calls
+}
+
+func TestCapture(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Header = http.Header{"Authorization": {"Bearer s3cret"}}
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a.SetClock(clk)
+	sink := &captureLog{}
+	a.SetCaptureSink(sink)
+	ctx := context.Background()
+	rule := CaptureRule{Name: "payments", ResourceTemplate: "/payments/{id}", Methods: []Method{POST}, Until: clk.Now().Add(10 * time.Minute), MaxBodyBytes: 16}
+	stop := a.Capture(rule)
+	assert.Equal(t, []CaptureRule{rule}, a.CaptureRules())
+
+	body := map[string]string{"note": strings.Repeat("x", 100)}
+	assert.NoError(t, a.Post(ctx, "/payments/42", body, nil))
+	calls := sink.take()
+	if assert.Len(t, calls, 1) {
+		e := calls[0].Entry
+		assert.Equal(t, "payments", calls[0].Rule.Name)
+		assert.Equal(t, "POST", e.Request.Method)
+		assert.Equal(t, srv.URL+"/payments/42", e.Request.URL)
+		assert.Contains(t, e.Request.Headers, HARNameValue{"Authorization", redacted("Bearer s3cret")})
+		if assert.NotNil(t, e.Request.PostData) {
+			assert.Equal(t, `{"note":"xxxxxxx`, e.Request.PostData.Text)
+		}
+		assert.Equal(t, 200, e.Response.Status)
+		assert.Equal(t, `{"note":"xxxxxxx`, e.Response.Content.Text)
+		assert.Equal(t, int64(111), e.Response.Content.Size)
+	}
+
+	// Neither the other methods nor the other resources are captured.
+	assert.NoError(t, a.Get(ctx, "/payments/42", nil, nil))
+	assert.NoError(t, a.Post(ctx, "/payments/42/refunds", body, nil))
+	assert.NoError(t, a.Post(ctx, "/orders/42", body, nil))
+	assert.Empty(t, sink.take())
+
+	// The calls of a RequestTemplate are matched by their template, not their path.
+	tmpl := a.Template(POST, "/payments/{id}")
+	assert.NoError(t, tmpl.DoBody(ctx, map[string]string{"id": "7"}, nil, body, nil))
+	assert.Len(t, sink.take(), 1)
+	stop7 := a.Capture(CaptureRule{ResourceTemplate: "/payments/7", MaxBodyBytes: -1})
+	stop()
+	assert.NoError(t, tmpl.DoBody(ctx, map[string]string{"id": "7"}, nil, body, nil))
+	assert.Empty(t, sink.take())
+	assert.NoError(t, a.Post(ctx, "/payments/7", body, nil))
+	calls = sink.take()
+	if assert.Len(t, calls, 1) {
+		assert.Nil(t, calls[0].Entry.Request.PostData)
+		assert.Empty(t, calls[0].Entry.Response.Content.Text)
+	}
+	stop7()
+	assert.Empty(t, a.CaptureRules())
+
+	// The rules expire.
+	a.Capture(rule)
+	clk.Advance(10 * time.Minute)
+	assert.NoError(t, a.Post(ctx, "/payments/42", body, nil))
+	assert.Empty(t, sink.take())
+	assert.Empty(t, a.CaptureRules())
+}
+
+func TestCaptureMatches(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		tmpl, resource string
+		want           bool
+	}{
+		{"/payments/{id}", "/payments/1", true},
+		{"/payments/{id}", "payments/1/", true},
+		{"/payments/{id}", "/payments/1?expand=all", true},
+		{"/payments/{id}", "/payments/", false},
+		{"/payments/{id}", "/payments", false},
+		{"/payments/{id}", "/payments/1/refunds", false},
+		{"/payments/{id}/refunds", "/payments/1/refunds", true},
+		{"/", "/", true},
+		{"/", "/payments", false},
+	} {
+		r := &captureRule{CaptureRule: CaptureRule{ResourceTemplate: tc.tmpl}, segs: strings.Split(strings.Trim(tc.tmpl, "/"), "/")}
+		assert.Equal(t, tc.want, r.matches("GET", "", tc.resource, now), "%s %s", tc.tmpl, tc.resource)
+		assert.Equal(t, matchTemplate(tc.tmpl, tc.resource), r.matches("GET", "", tc.resource, now), "%s %s", tc.tmpl, tc.resource)
+	}
+}
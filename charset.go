@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// CharsetReader returns a reader converting input from the named charset to UTF-8.
+// Its signature matches xml.Decoder.CharsetReader and charset.NewReaderLabel of
+// golang.org/x/net/html/charset, so converters of golang.org/x/text/encoding can be plugged in directly.
+type CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+// CharsetError is returned when reading a response body in a charset that can't be converted to UTF-8.
+type CharsetError struct {
+	Charset string
+}
+
+func (e *CharsetError) Error() string {
+	return fmt.Sprintf("api: unsupported charset: %q", e.Charset)
+}
+
+// ConvertCharset makes the call convert the response body to UTF-8 before it reaches decoders.
+// The charset is taken from the Content-Type parameter; if it's missing, a byte order mark
+// is looked for and, for text/html, a <meta> charset declaration within the first 1024 bytes.
+// UTF-8, US-ASCII and ISO-8859-1 are supported natively; other charsets are handed to cr,
+// and reading the body fails with *CharsetError if cr is nil or can't convert them.
+//
+// The Content-Type header is left as is, so an XML decoder reading the converted body
+// needs a CharsetReader that passes the input through.
+func ConvertCharset(cr CharsetReader) Option {
+	return func(c *call) {
+		c.wrappers = append(c.wrappers, func(resp *http.Response, body io.ReadCloser) io.ReadCloser {
+			return convertBody(resp, body, cr)
+		})
+	}
+}
+
+func convertBody(resp *http.Response, body io.ReadCloser, cr CharsetReader) io.ReadCloser {
+	mediatype, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return body
+	}
+	br := bufio.NewReader(body)
+	charset := strings.ToLower(strings.TrimSpace(params["charset"]))
+	if bom := sniffBOM(br); bom != "" {
+		br.Discard(bomSize(bom))
+		if charset == "" {
+			charset = bom
+		}
+	} else if charset == "" && mediatype == "text/html" {
+		charset = sniffMetaCharset(br)
+	}
+	r, err := charsetReader(charset, br, cr)
+	if err != nil {
+		return &errBody{body: body, err: err}
+	}
+	return &convertedBody{Reader: r, body: body}
+}
+
+func charsetReader(charset string, r io.Reader, cr CharsetReader) (io.Reader, error) {
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return r, nil
+	case "iso-8859-1", "iso8859-1", "iso_8859-1", "latin1", "l1":
+		return &latin1Reader{r: r}, nil
+	}
+	if cr == nil {
+		return nil, &CharsetError{Charset: charset}
+	}
+	conv, err := cr(charset, r)
+	if err != nil || conv == nil {
+		return nil, &CharsetError{Charset: charset}
+	}
+	return conv, nil
+}
+
+// sniffBOM returns the charset of the byte order mark at the start of r, if any.
+func sniffBOM(r *bufio.Reader) string {
+	b, _ := r.Peek(3)
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8"
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	}
+	return ""
+}
+
+func bomSize(charset string) int {
+	if charset == "utf-8" {
+		return 3
+	}
+	return 2
+}
+
+// sniffMetaCharset loosely looks for <meta charset="..."> or <meta content="...; charset=...">
+// within the first 1024 bytes of r.
+func sniffMetaCharset(r *bufio.Reader) string {
+	b, _ := r.Peek(1024)
+	s := strings.ToLower(string(b))
+	for {
+		i := strings.Index(s, "<meta")
+		if i < 0 {
+			return ""
+		}
+		s = s[i+len("<meta"):]
+		tag := s
+		if end := strings.IndexByte(tag, '>'); end >= 0 {
+			tag = tag[:end]
+		}
+		j := strings.Index(tag, "charset=")
+		if j < 0 {
+			continue
+		}
+		v := strings.TrimLeft(tag[j+len("charset="):], `"' `)
+		if end := strings.IndexAny(v, `"';/ `); end >= 0 {
+			v = v[:end]
+		}
+		return v
+	}
+}
+
+// latin1Reader converts ISO-8859-1 to UTF-8: each byte is the code point of the same value.
+type latin1Reader struct {
+	r   io.Reader
+	in  [512]byte
+	buf []byte
+	out []byte
+	err error
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	for len(l.out) == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		var n int
+		n, l.err = l.r.Read(l.in[:])
+		l.buf = l.buf[:0]
+		for _, b := range l.in[:n] {
+			l.buf = utf8.AppendRune(l.buf, rune(b))
+		}
+		l.out = l.buf
+	}
+	n := copy(p, l.out)
+	l.out = l.out[n:]
+	return n, nil
+}
+
+type convertedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *convertedBody) Close() error {
+	return b.body.Close()
+}
+
+// errBody is a response body failing every read with err.
+type errBody struct {
+	body io.ReadCloser
+	err  error
+}
+
+func (b *errBody) Read([]byte) (int, error) {
+	return 0, b.err
+}
+
+func (b *errBody) Close() error {
+	return b.body.Close()
+}
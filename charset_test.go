@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertCharsetLatin1(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=ISO-8859-1")
+		w.Write([]byte("{\"name\":\"Caf\xe9 na\xefve \xc0 la cr\xe8me\"}"))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var out struct{ Name string }
+	if !assert.NoError(t, a.DoJSON(context.Background(), GET, "/menu", nil, &out, ConvertCharset(nil))) {
+		return
+	}
+	assert.Equal(t, "Café naïve À la crème", out.Name)
+}
+
+func TestConvertCharsetLatin1Reader(t *testing.T) {
+	var latin1 []byte
+	var want strings.Builder
+	for i := 0; i < 4096; i++ {
+		latin1 = append(latin1, byte(i))
+		want.WriteRune(rune(byte(i)))
+	}
+	r := &latin1Reader{r: bytes.NewReader(latin1)}
+	var got bytes.Buffer
+	p := make([]byte, 1)
+	for {
+		n, err := r.Read(p)
+		got.Write(p[:n])
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+	assert.Equal(t, want.String(), got.String())
+}
+
+func TestConvertCharsetUnknown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml; charset=Shift_JIS")
+		io.WriteString(w, "<a>b</a>")
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	req, _ := a.Request(GET, "/doc", nil)
+	resp, err := a.Do(context.Background(), req, ConvertCharset(nil))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	var ce *CharsetError
+	if assert.True(t, errors.As(err, &ce)) {
+		assert.Equal(t, "shift_jis", ce.Charset)
+		assert.Contains(t, err.Error(), "shift_jis")
+	}
+
+	var seen string
+	upper := func(charset string, r io.Reader) (io.Reader, error) {
+		seen = charset
+		b, err := io.ReadAll(r)
+		return strings.NewReader(strings.ToUpper(string(b))), err
+	}
+	req, _ = a.Request(GET, "/doc", nil)
+	resp, err = a.Do(context.Background(), req, ConvertCharset(upper))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "<A>B</A>", string(b))
+	assert.Equal(t, "shift_jis", seen)
+}
+
+func TestConvertCharsetSniff(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		want        string
+	}{
+		{"text/html", "<html><head><meta charset=\"iso-8859-1\"></head>caf\xe9</html>", "<html><head><meta charset=\"iso-8859-1\"></head>café</html>"},
+		{"text/html", "<meta http-equiv=\"Content-Type\" content=\"text/html; charset=latin1\">\xe9", "<meta http-equiv=\"Content-Type\" content=\"text/html; charset=latin1\">é"},
+		{"text/plain", "\xef\xbb\xbfcaf\xc3\xa9", "café"},
+		{"text/plain; charset=utf-8", "plain", "plain"},
+		{"text/plain", "caf\xc3\xa9", "café"},
+	} {
+		resp := &http.Response{Header: http.Header{"Content-Type": {tc.contentType}}}
+		r := convertBody(resp, io.NopCloser(strings.NewReader(tc.body)), nil)
+		b, err := io.ReadAll(r)
+		assert.NoError(t, err, tc.contentType)
+		assert.Equal(t, tc.want, string(b), tc.contentType)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ErrCursorExpired is matched by every *CursorExpiredError.
+var ErrCursorExpired = errors.New("api: list cursor expired")
+
+// CursorExpiredError is returned by List when the server rejects the cursor of a page as expired,
+// see ListOptions.CursorExpired. A walk resumed from an expired cursor can only start over.
+type CursorExpiredError struct {
+	// Cursor is the URL of the page.
+	Cursor string
+	Err    *StatusError
+}
+
+func (e *CursorExpiredError) Error() string {
+	return fmt.Sprintf("api: list cursor %s expired: %v", e.Cursor, e.Err)
+}
+
+// Is makes errors.Is(err, ErrCursorExpired) report true.
+func (e *CursorExpiredError) Is(target error) bool { return target == ErrCursorExpired }
+
+func (e *CursorExpiredError) Unwrap() error { return e.Err }
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *CursorExpiredError) Class() Class { return Permanent }
+
+// cursorError returns the error of a page fetched from the cursor of req, a *CursorExpiredError
+// if it's the cursor which expired.
+func cursorError(req *http.Request, err error, opts *ListOptions) error {
+	var se *StatusError
+	if !errors.As(err, &se) {
+		return err
+	}
+	expired := se.Code == http.StatusGone
+	if opts.CursorExpired != nil {
+		expired = opts.CursorExpired(se)
+	}
+	if !expired {
+		return err
+	}
+	return &CursorExpiredError{Cursor: req.URL.String(), Err: se}
+}
+
+// Checkpoint stores the position of a List walk, see ListOptions.Checkpoint and ResumeFrom.
+// The cursor is opaque: it's the URL of the next page, whatever the Paginator.
+type Checkpoint interface {
+	// Load returns the cursor stored and the number of items processed before it, an empty
+	// cursor if none was stored.
+	Load() (cursor string, processed int64, err error)
+	Store(cursor string, processed int64) error
+}
+
+// FileCheckpoint is a Checkpoint kept as JSON in the file at Path, e.g.
+//
+//	{"cursor": "https://api.example.com/items?after=a1f3", "processed": 1200}
+//
+// A missing file holds no cursor. The file is replaced atomically on every Store, so a crash
+// leaves either the previous checkpoint or the new one.
+type FileCheckpoint struct {
+	Path string
+}
+
+// NewFileCheckpoint returns the FileCheckpoint kept in the file at path.
+func NewFileCheckpoint(path string) *FileCheckpoint {
+	return &FileCheckpoint{Path: path}
+}
+
+type fileCheckpoint struct {
+	Cursor    string `json:"cursor"`
+	Processed int64  `json:"processed"`
+}
+
+// Load implements Checkpoint.
+func (f *FileCheckpoint) Load() (string, int64, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	var cp fileCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return "", 0, fmt.Errorf("api: checkpoint %s: %w", f.Path, err)
+	}
+	return cp.Cursor, cp.Processed, nil
+}
+
+// Store implements Checkpoint.
+func (f *FileCheckpoint) Store(cursor string, processed int64) error {
+	data, err := json.Marshal(fileCheckpoint{Cursor: cursor, Processed: processed})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
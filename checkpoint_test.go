@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListCheckpoint(t *testing.T) {
+	// 5 pages of 2 items linked by cursors, which expire once expired is set.
+	var expired atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		if after := r.URL.Query().Get("after"); after != "" {
+			if expired.Load() {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			n, _ := strconv.Atoi(after)
+			page = n/2 + 1
+		}
+		if page < 5 {
+			w.Header().Set("Link", fmt.Sprintf(`</items?after=%d>; rel="next"`, page*2))
+		}
+		fmt.Fprintf(w, `[{"id": %d}, {"id": %d}]`, page*2-1, page*2)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+	cp := NewFileCheckpoint(filepath.Join(t.TempDir(), "items.json"))
+
+	// The walk is interrupted by the first item of page 3.
+	errStop := errors.New("stop")
+	var ids []int
+	err := List(ctx, a, "/items", &ListOptions{Checkpoint: cp, ResumeFrom: cp}, func(it testItem) error {
+		if it.ID == 5 {
+			return errStop
+		}
+		ids = append(ids, it.ID)
+		return nil
+	})
+	assert.Equal(t, errStop, err)
+	cursor, processed, err := cp.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, srv.URL+"/items?after=4", cursor)
+	assert.Equal(t, int64(4), processed)
+
+	// Resumed, the walk goes on from page 3.
+	var progress []int64
+	err = List(ctx, a, "/items", &ListOptions{Checkpoint: cp, ResumeFrom: cp, Progress: func(p PageProgress) {
+		progress = append(progress, p.Processed)
+	}}, func(it testItem) error {
+		ids = append(ids, it.ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, ids)
+	assert.Equal(t, []int64{6, 8, 10}, progress)
+	cursor, processed, _ = cp.Load()
+	assert.Equal(t, "", cursor)
+	assert.Equal(t, int64(10), processed)
+
+	// An expired cursor fails the resumed walk.
+	assert.NoError(t, cp.Store(srv.URL+"/items?after=6", 6))
+	expired.Store(true)
+	err = List(ctx, a, "/items", &ListOptions{ResumeFrom: cp}, func(it testItem) error { return nil })
+	var ce *CursorExpiredError
+	if assert.True(t, errors.As(err, &ce), "%v", err) {
+		assert.Equal(t, srv.URL+"/items?after=6", ce.Cursor)
+		assert.Equal(t, http.StatusGone, ce.Err.Code)
+	}
+	assert.True(t, errors.Is(err, ErrCursorExpired))
+
+	// So does a cursor expiring within the walk, the first page aside.
+	err = List(ctx, a, "/items", nil, func(it testItem) error { return nil })
+	assert.True(t, errors.Is(err, ErrCursorExpired), "%v", err)
+	err = List(ctx, a, "/items", &ListOptions{CursorExpired: func(*StatusError) bool { return false }}, func(it testItem) error { return nil })
+	assert.False(t, errors.Is(err, ErrCursorExpired))
+	assert.Error(t, err)
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Checksum represents a checksum algorithm used to protect request and response bodies.
+type Checksum int
+
+const (
+	MD5 Checksum = iota
+	SHA256
+	CRC32C
+)
+
+func (c Checksum) String() string {
+	switch c {
+	case MD5:
+		return "MD5"
+	case SHA256:
+		return "SHA256"
+	case CRC32C:
+		return "CRC32C"
+	default:
+		return "MD5"
+	}
+}
+
+// New returns a new hash.Hash computing the checksum.
+func (c Checksum) New() hash.Hash {
+	switch c {
+	case SHA256:
+		return sha256.New()
+	case CRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	default:
+		return md5.New()
+	}
+}
+
+// Header returns the default header name carrying the checksum:
+// Content-MD5 for MD5 and the x-amz-checksum-* style names for the others.
+func (c Checksum) Header() string {
+	switch c {
+	case SHA256:
+		return "X-Amz-Checksum-Sha256"
+	case CRC32C:
+		return "X-Amz-Checksum-Crc32c"
+	default:
+		return "Content-MD5"
+	}
+}
+
+var (
+	// ErrChecksumMismatch is returned when a response body doesn't match its advertised checksum.
+	ErrChecksumMismatch = errors.New("api: checksum mismatch")
+	// ErrChecksumUnverified is returned when a response body advertising a checksum is closed
+	// with too much of it left unread to drain it and verify it.
+	ErrChecksumUnverified = errors.New("api: checksum not verified")
+	// ErrBodyNotSeekable is returned when a checksum must be computed over a streaming body that can't be rewound.
+	ErrBodyNotSeekable = errors.New("api: request body is not seekable")
+)
+
+// encodeSum formats a digest the way checksum headers carry it, i.e. base64 of the raw bytes.
+func encodeSum(h hash.Hash) string {
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// UploadChecksum computes the checksum over the request body and sets it into the given header,
+// or into the algorithm's default header if name is empty. Buffered bodies (those having GetBody)
+// are hashed from a copy; seekable streaming bodies created by RequestReader are read twice,
+// once for hashing and once for sending. Other bodies fail with ErrBodyNotSeekable.
+func UploadChecksum(alg Checksum, name string) Option {
+	if name == "" {
+		name = alg.Header()
+	}
+	return func(c *call) {
+		c.prepare = append(c.prepare, func(req *http.Request) error {
+			h := alg.New()
+			rs, seekable := req.Body.(io.Seeker)
+			data, encoded := encodedBody(req)
+			switch {
+			case req.Body == nil || req.Body == http.NoBody:
+			case encoded:
+				h.Write(data)
+			case req.GetBody != nil && !seekable:
+				body, err := req.GetBody()
+				if err != nil {
+					return err
+				}
+				_, err = io.Copy(h, body)
+				body.Close()
+				if err != nil {
+					return err
+				}
+			default:
+				// A seekable body is hashed in place, since its GetBody may share the reader.
+				if !seekable {
+					return ErrBodyNotSeekable
+				}
+				pos, err := rs.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return err
+				}
+				if _, err := io.Copy(h, req.Body); err != nil {
+					return err
+				}
+				if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+					return err
+				}
+			}
+			req.Header.Set(name, encodeSum(h))
+			return nil
+		})
+	}
+}
+
+// TrailerChecksum sends the checksum of the request body in the trailer name (the algorithm's
+// default header if empty), hashing the body while it's sent, for the streamed uploads whose
+// checksum isn't known until they end, e.g. those of RequestReader. The trailer is declared up
+// front and its value set once the body has been read to its end; since only chunked bodies carry
+// trailers, the body is sent without a Content-Length. Over HTTP/2 the trailer goes in the last
+// HEADERS frame, with no chunked encoding. The proxies dropping trailers drop the checksum.
+//
+// If ack isn't empty, a 2xx response must echo the checksum in its header ack, or the call fails
+// with an error wrapping ErrChecksumMismatch, e.g. when the server didn't get the trailer or
+// computed another checksum. A request without a body carries the checksum as a header instead.
+// Every attempt is hashed on its own; the duplicates of WithHedging are sent without it. Like
+// WithRetry, it applies to the calls, the request builders ignoring it.
+func TrailerChecksum(alg Checksum, name, ack string) Option {
+	if name == "" {
+		name = alg.Header()
+	}
+	return func(c *call) {
+		c.trailer = &trailerChecksum{alg: alg, name: http.CanonicalHeaderKey(name), ack: ack}
+	}
+}
+
+// trailerChecksum is the state of TrailerChecksum for a call.
+type trailerChecksum struct {
+	alg       Checksum
+	name, ack string
+	// body hashes the body of the last attempt.
+	body *trailerBody
+}
+
+// wrap returns a copy of req sending the checksum of its body in the trailer.
+func (t *trailerChecksum) wrap(req *http.Request) *http.Request {
+	r := *req
+	r.Header = req.Header.Clone()
+	if req.Body == nil || req.Body == http.NoBody {
+		sum := encodeSum(t.alg.New())
+		r.Header.Set(t.name, sum)
+		t.body = &trailerBody{}
+		t.body.sum.Store(&sum)
+		return &r
+	}
+	r.Header.Del("Content-Length")
+	r.ContentLength, r.TransferEncoding = -1, []string{"chunked"}
+	r.Trailer = http.Header{t.name: nil}
+	t.body = &trailerBody{ReadCloser: req.Body, h: t.alg.New(), trailer: r.Trailer, name: t.name}
+	r.Body = t.body
+	return &r
+}
+
+// acknowledged checks that resp echoes the checksum of the body sent, if it's required.
+func (t *trailerChecksum) acknowledged(resp *http.Response) error {
+	if t.ack == "" || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil
+	}
+	sum := t.body.sum.Load()
+	if sum == nil {
+		return fmt.Errorf("%w: the request body wasn't sent to its end, so %s wasn't", ErrChecksumMismatch, t.name)
+	}
+	if got := resp.Header.Get(t.ack); got != *sum {
+		return fmt.Errorf("%w: %s acknowledges %q, %s is %s", ErrChecksumMismatch, t.ack, got, t.name, *sum)
+	}
+	return nil
+}
+
+// trailerBody hashes the body it reads, setting the checksum in the trailer at its end.
+type trailerBody struct {
+	io.ReadCloser
+	h       hash.Hash
+	trailer http.Header
+	name    string
+	// sum is set at the end of the body, read by the call once the response arrived.
+	sum atomic.Pointer[string]
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.h.Write(p[:n])
+	if err == io.EOF && b.sum.Load() == nil {
+		sum := encodeSum(b.h)
+		b.trailer[b.name] = []string{sum}
+		b.sum.Store(&sum)
+	}
+	return n, err
+}
+
+// VerifyChecksum checks the response body against the checksum advertised in the named
+// header or trailer (the algorithm's default header if name is empty). The check happens once
+// the body has been read to the end; a body that wasn't fully consumed is drained on Close, up to
+// 16KB like the other bodies closed unread, and Close fails with an error wrapping
+// ErrChecksumUnverified if more was left. On mismatch the read or Close fails with an error
+// wrapping ErrChecksumMismatch. Responses that don't advertise the checksum are passed through
+// unverified.
+func VerifyChecksum(alg Checksum, name string) Option {
+	if name == "" {
+		name = alg.Header()
+	}
+	return func(c *call) {
+		c.wrappers = append(c.wrappers, func(resp *http.Response, body io.ReadCloser) io.ReadCloser {
+			return &verifyBody{body: body, resp: resp, h: alg.New(), name: name}
+		})
+	}
+}
+
+type verifyBody struct {
+	body io.ReadCloser
+	resp *http.Response
+	h    hash.Hash
+	name string
+
+	once sync.Once
+	err  error
+}
+
+func (v *verifyBody) Read(p []byte) (int, error) {
+	n, err := v.body.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF {
+		if verr := v.verify(); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+// verify compares the hash against the header, falling back to the trailer which is only
+// populated once the body has been read to EOF.
+func (v *verifyBody) verify() error {
+	v.once.Do(func() {
+		want := v.resp.Header.Get(v.name)
+		if want == "" && v.resp.Trailer != nil {
+			want = v.resp.Trailer.Get(v.name)
+		}
+		if want == "" {
+			return
+		}
+		if got := encodeSum(v.h); got != want {
+			v.err = fmt.Errorf("%w: %s is %s, body has %s", ErrChecksumMismatch, v.name, want, got)
+		}
+	})
+	return v.err
+}
+
+// advertised reports whether resp carries the checksum in its header, or declares it in its
+// trailer.
+func (v *verifyBody) advertised() bool {
+	if v.resp.Header.Get(v.name) != "" {
+		return true
+	}
+	_, ok := v.resp.Trailer[http.CanonicalHeaderKey(v.name)]
+	return ok
+}
+
+func (v *verifyBody) Close() error {
+	// One byte past maxDrain tells a body left longer than it from one ending there.
+	_, err := io.CopyN(v.h, v.body, maxDrain+1)
+	switch {
+	case err == io.EOF:
+		err = v.verify()
+	case err == nil && v.advertised():
+		err = fmt.Errorf("%w: more than %d bytes of the body were left unread, %s wasn't checked", ErrChecksumUnverified, maxDrain, v.name)
+	}
+	if cerr := v.body.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
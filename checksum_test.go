@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sum64(h []byte) string {
+	return base64.StdEncoding.EncodeToString(h)
+}
+
+func TestUploadChecksum(t *testing.T) {
+	const payload = `{"name": "object"}`
+	var gotHeader, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		gotHeader = r.Header.Get("Content-MD5")
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	req, err := a.RequestBytes(PUT, "/objects/1", "application/json", []byte(payload))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := a.Do(context.Background(), req, UploadChecksum(MD5, ""))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	md := md5.Sum([]byte(payload))
+	assert.Equal(t, sum64(md[:]), gotHeader)
+	assert.Equal(t, payload, gotBody)
+}
+
+func TestUploadChecksumSeekable(t *testing.T) {
+	payload := strings.Repeat("streamed data ", 1000)
+	var gotHeader, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		gotHeader = r.Header.Get("X-Checksum")
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	req, err := a.RequestReader(PUT, "/objects/1", "text/plain", strings.NewReader(payload))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := a.Do(context.Background(), req, UploadChecksum(SHA256, "X-Checksum"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	sh := sha256.Sum256([]byte(payload))
+	assert.Equal(t, sum64(sh[:]), gotHeader)
+	assert.Equal(t, payload, gotBody)
+}
+
+func TestUploadChecksumNotSeekable(t *testing.T) {
+	a := MustNew("http://example.com")
+	req, err := a.RequestReader(PUT, "/objects/1", "text/plain", io.MultiReader(strings.NewReader("data")))
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = a.Do(context.Background(), req, UploadChecksum(CRC32C, ""))
+	assert.Equal(t, ErrBodyNotSeekable, err)
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	const served = `{"id": 1}`
+	sh := sha256.Sum256([]byte(served))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/good":
+			w.Header().Set("X-Amz-Checksum-Sha256", sum64(sh[:]))
+			w.Write([]byte(served))
+		case "/corrupted":
+			w.Header().Set("X-Amz-Checksum-Sha256", sum64(sh[:]))
+			w.Write([]byte(`{"id": 2}`))
+		case "/trailer":
+			w.Header().Set("Trailer", "X-Amz-Checksum-Sha256")
+			w.Write([]byte(`{"id": 2}`))
+			w.Header().Set("X-Amz-Checksum-Sha256", sum64(sh[:]))
+		}
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var out struct{ ID int }
+	err := a.DoJSON(context.Background(), GET, "/good", nil, &out, VerifyChecksum(SHA256, ""))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, out.ID)
+
+	err = a.DoJSON(context.Background(), GET, "/corrupted", nil, &out, VerifyChecksum(SHA256, ""))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	err = a.DoJSON(context.Background(), GET, "/trailer", nil, &out, VerifyChecksum(SHA256, ""))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestVerifyChecksumDrain(t *testing.T) {
+	small, large := `{"id": 1}`, strings.Repeat("x", 4*maxDrain)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := small
+		if r.URL.Path != "/small" {
+			body = large
+		}
+		sum := sha256.Sum256([]byte(body))
+		if r.URL.Path != "/plain" {
+			w.Header().Set("X-Amz-Checksum-Sha256", sum64(sum[:]))
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	closeUnread := func(resource string) error {
+		req, _ := a.Request(GET, resource, nil)
+		resp, err := a.Do(context.Background(), req, VerifyChecksum(SHA256, ""))
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		return resp.Body.Close()
+	}
+
+	// A body left unread is drained and verified on Close, up to maxDrain bytes.
+	assert.NoError(t, closeUnread("/small"))
+	err := closeUnread("/large")
+	assert.ErrorIs(t, err, ErrChecksumUnverified)
+	assert.False(t, errors.Is(err, ErrChecksumMismatch))
+	assert.NoError(t, closeUnread("/plain"))
+}
+
+// trailerServer checks the trailer X-Checksum-Sha256 against the body it reads, acknowledging
+// it in the header of the same name, or with a wrong value if lie is set.
+func trailerServer(t *testing.T, lie bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, int64(-1), r.ContentLength)
+		assert.Equal(t, []string{"chunked"}, r.TransferEncoding)
+		assert.Contains(t, r.Trailer, "X-Checksum-Sha256")
+		h := sha256.New()
+		io.Copy(h, r.Body)
+		got := r.Trailer.Get("X-Checksum-Sha256")
+		if !assert.Equal(t, sum64(h.Sum(nil)), got) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if lie {
+			got = sum64(make([]byte, 32))
+		}
+		w.Header().Set("X-Checksum-Sha256", got)
+	}))
+}
+
+func TestTrailerChecksum(t *testing.T) {
+	srv := trailerServer(t, false)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+	opt := TrailerChecksum(SHA256, "x-checksum-sha256", "X-Checksum-Sha256")
+
+	// A stream of unknown length.
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 100; i++ {
+			io.WriteString(pw, strings.Repeat("chunk", 100))
+		}
+		pw.Close()
+	}()
+	req, err := a.RequestReader(PUT, "/objects/1", "application/octet-stream", pr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := a.Do(ctx, req, opt)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+
+	// A buffered body is streamed too, and every attempt hashed again.
+	var attempts int
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("X-Checksum-Sha256", r.Trailer.Get("X-Checksum-Sha256"))
+	}))
+	defer flaky.Close()
+	b := MustNew(flaky.URL)
+	assert.NoError(t, b.Post(ctx, "/objects", map[string]string{"name": "a"}, nil, opt, WithRetry(&RetryPolicy{MaxRetries: 1})))
+	assert.Equal(t, 2, attempts)
+
+	// Without a body, it's a header.
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ack", r.Header.Get("X-Checksum-Sha256"))
+	}))
+	defer empty.Close()
+	req, _ = http.NewRequest("DELETE", empty.URL+"/objects/1", nil)
+	resp, err = a.Do(ctx, req, TrailerChecksum(SHA256, "X-Checksum-Sha256", "X-Ack"))
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
+
+func TestTrailerChecksumNotAcknowledged(t *testing.T) {
+	srv := trailerServer(t, true)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	body := strings.Repeat("x", 1000)
+	req, _ := a.RequestReader(PUT, "/objects/1", "text/plain", io.NopCloser(strings.NewReader(body)))
+	_, err := a.Do(context.Background(), req, TrailerChecksum(SHA256, "X-Checksum-Sha256", "X-Checksum-Sha256"))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	sum := sha256.Sum256([]byte(body))
+	assert.Contains(t, err.Error(), "X-Checksum-Sha256 is "+sum64(sum[:]))
+
+	// It's only checked if asked.
+	req, _ = a.RequestReader(PUT, "/objects/1", "text/plain", io.NopCloser(strings.NewReader(body)))
+	resp, err := a.Do(context.Background(), req, TrailerChecksum(SHA256, "X-Checksum-Sha256", ""))
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
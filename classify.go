@@ -0,0 +1,200 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Class is a classification of a failed call, telling whether it's worth retrying.
+type Class int
+
+const (
+	// Unclassified leaves the decision to the default rules based on the status code.
+	Unclassified Class = iota
+	// Permanent failures won't succeed if retried as is.
+	Permanent
+	// Temporary failures may succeed if retried, e.g. 503 or a connection reset.
+	Temporary
+	// Throttled failures may succeed if retried after a while, e.g. 429.
+	Throttled
+)
+
+func (c Class) String() string {
+	switch c {
+	case Permanent:
+		return "permanent"
+	case Temporary:
+		return "temporary"
+	case Throttled:
+		return "throttled"
+	default:
+		return "unclassified"
+	}
+}
+
+// Classifier classifies a response by its status, header and up to 64KB of its body,
+// allowing vendor-specific error codes to be recognized, even in 2xx responses.
+// Returning a class other than Unclassified for a 2xx response makes the call fail with a *StatusError.
+type Classifier func(status int, header http.Header, body []byte) Class
+
+// SetClassifier sets the classifier consulted by the Do-style helpers for every response.
+func (a *Api) SetClassifier(fn Classifier) {
+	a.mu.Lock()
+	a.classifier = fn
+	a.mu.Unlock()
+}
+
+// IsRetryable reports whether err is a temporary or throttling failure, i.e. worth retrying.
+func IsRetryable(err error) bool {
+	switch classify(err) {
+	case Temporary, Throttled:
+		return true
+	}
+	return false
+}
+
+// IsThrottled reports whether err was caused by the API throttling the client, e.g. a 429 status.
+func IsThrottled(err error) bool {
+	return classify(err) == Throttled
+}
+
+// IsTemporary reports whether err is a temporary failure: a 408 or 5xx gateway-like status,
+// a timeout, a refused or reset connection, or a connection closed prematurely.
+func IsTemporary(err error) bool {
+	return classify(err) == Temporary
+}
+
+// Classify returns the class of the failure; it's Unclassified for a nil error.
+func Classify(err error) Class {
+	return classify(err)
+}
+
+func classify(err error) Class {
+	if err == nil {
+		return Unclassified
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.class()
+	}
+	var ce interface{ Class() Class }
+	if errors.As(err, &ce) {
+		return ce.Class()
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTemporary || dnsErr.IsTimeout {
+			return Temporary
+		}
+		return Permanent
+	}
+	switch transportKind(err) {
+	case ConnectionRefused, ConnectionReset, Timeout:
+		return Temporary
+	}
+	return Permanent
+}
+
+// class returns the explicit class of the error, or derives it from the status code.
+func (e *StatusError) class() Class {
+	if e.Class != Unclassified {
+		return e.Class
+	}
+	switch e.Code {
+	case http.StatusTooManyRequests:
+		return Throttled
+	case http.StatusRequestTimeout,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return Temporary
+	}
+	return Permanent
+}
+
+// check turns non-2xx responses, and responses the classifier considers failed or carrying a vendor
+// error code when SetErrorCodePath asks for it, into *StatusError, *RedirectionError or *VendorError.
+// The body of a failed response is consumed and closed.
+func (a *Api) check(c *call, resp *http.Response) error {
+	a.mu.Lock()
+	classifier, codes, tunnel := a.classifier, a.codes, a.tunnel
+	a.mu.Unlock()
+
+	ok := resp.StatusCode >= 200 && resp.StatusCode <= 299
+	tunneled := ok && tunnel != nil && tunnel.applies(c)
+	if ok && classifier == nil && (codes == nil || !codes.success) && !tunneled {
+		return nil
+	}
+	limit := int64(maxErrorBody)
+	if c.errorBody > 0 {
+		limit = int64(c.errorBody)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, limit))
+	code, status := resp.StatusCode, resp.Status
+	if tunneled {
+		tc := tunnel.extract(body)
+		if tc != 0 && c.meta != nil {
+			c.meta.TunneledStatus = tc
+		}
+		if tunneled = tc != 0 && (tc < 200 || tc > 299); tunneled {
+			ok, code, status = false, tc, strconv.Itoa(tc)+" "+http.StatusText(tc)
+		}
+	}
+	class := Unclassified
+	if classifier != nil {
+		class = classifier(code, resp.Header, body)
+	}
+	var errorCode string
+	if codes != nil && (!ok || codes.success) {
+		errorCode = codes.extract(body)
+	}
+	if ok && class == Unclassified && errorCode == "" {
+		resp.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return nil
+	}
+	size := resp.ContentLength
+	if n, _ := io.CopyN(io.Discard, resp.Body, maxDrain); n < maxDrain && size < 0 {
+		size = int64(len(body)) + n
+	}
+	resp.Body.Close()
+	se := &StatusError{
+		Code:      code,
+		Status:    status,
+		Header:    resp.Header,
+		Body:      body,
+		Size:      size,
+		Class:     class,
+		ErrorCode: errorCode,
+		Tunneled:  tunneled,
+	}
+	if !ok && c.errorType != nil {
+		se.Detail = decodeDetail(c.errorType, body)
+	}
+	if !ok && c.conditions != nil {
+		if err := a.conditionError(c, se); err != nil {
+			return err
+		}
+	}
+	if codes != nil {
+		if err := codes.wrap(se); err != error(se) {
+			return err
+		}
+	}
+	if isRedirection(se.Code) {
+		re := &RedirectionError{StatusError: se}
+		re.Location, _ = Location(resp)
+		return re
+	}
+	return se
+}
+
+// prefixedBody is a body whose beginning has been read ahead and put back.
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
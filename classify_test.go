@@ -0,0 +1,268 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+// fakeClock returns a clock that advances through every sleep right away.
+func fakeClock() *clock.Fake {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	clk.AutoAdvance(true)
+	return clk
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://example.com", Err: err}
+	}
+	opErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+	}
+	for i, tc := range []struct {
+		err       error
+		class     Class
+		retryable bool
+	}{
+		{nil, Unclassified, false},
+		{&StatusError{Code: 429}, Throttled, true},
+		{&StatusError{Code: 503}, Temporary, true},
+		{&StatusError{Code: 500}, Temporary, true},
+		{&StatusError{Code: 408}, Temporary, true},
+		{&StatusError{Code: 404}, Permanent, false},
+		{&StatusError{Code: 501}, Permanent, false},
+		{&StatusError{Code: 200, Class: Throttled}, Throttled, true},
+		{&StatusError{Code: 503, Class: Permanent}, Permanent, false},
+		{fmt.Errorf("wrapped: %w", &StatusError{Code: 502}), Temporary, true},
+		{urlErr(opErr(syscall.ECONNREFUSED)), Temporary, true},
+		{urlErr(opErr(syscall.ECONNRESET)), Temporary, true},
+		{urlErr(timeoutError{}), Temporary, true},
+		{urlErr(io.ErrUnexpectedEOF), Temporary, true},
+		{urlErr(&net.DNSError{Err: "no such host", Name: "x", IsNotFound: true}), Permanent, false},
+		{urlErr(&net.DNSError{Err: "server misbehaving", Name: "x", IsTemporary: true}), Temporary, true},
+		{urlErr(context.DeadlineExceeded), Temporary, true},
+		{urlErr(context.Canceled), Permanent, false},
+		{errors.New("api: unknown method: 10"), Permanent, false},
+	} {
+		assert.Equal(t, tc.class, Classify(tc.err), "case %d: %v", i, tc.err)
+		assert.Equal(t, tc.retryable, IsRetryable(tc.err), "case %d: %v", i, tc.err)
+		assert.Equal(t, tc.class == Throttled, IsThrottled(tc.err), "case %d: %v", i, tc.err)
+		assert.Equal(t, tc.class == Temporary, IsTemporary(tc.err), "case %d: %v", i, tc.err)
+	}
+}
+
+func TestClassifyConnRefused(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	a := MustNew(srv.URL)
+	srv.Close()
+	err := a.DoJSON(context.Background(), GET, "/", nil, nil)
+	assert.True(t, IsTemporary(err), "%v", err)
+}
+
+func TestRetry(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "a=1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	a.Retry = &RetryPolicy{MaxRetries: 3, Rand: rand.New(rand.NewSource(1))}
+	clk := fakeClock()
+	a.SetClock(clk)
+	start := clk.Now()
+	var meta ResponseMeta
+	var out struct{ OK bool }
+	err := a.DoJSON(context.Background(), POST, "/", url.Values{"a": {"1"}}, &out, WithMeta(&meta))
+	assert.NoError(t, err)
+	assert.True(t, out.OK)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 2, meta.Retries)
+	// Full jitter backoffs of at most 100ms and 200ms, drawn from the seeded source.
+	want := rand.New(rand.NewSource(1))
+	backoffs := time.Duration(want.Int63n(int64(100*time.Millisecond)+1)) + time.Duration(want.Int63n(int64(200*time.Millisecond)+1))
+	assert.Equal(t, backoffs, clk.Now().Sub(start))
+
+	calls = -10
+	err = a.DoJSON(context.Background(), POST, "/", url.Values{"a": {"1"}}, &out, WithMeta(&meta))
+	assert.True(t, IsTemporary(err))
+	assert.Equal(t, -6, calls)
+	assert.Equal(t, 3, meta.Retries)
+}
+
+func TestRetryClassifier(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Write([]byte(`{"code": "rate_limited"}`))
+			return
+		}
+		w.Write([]byte(`{"code": "ok", "value": 42}`))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	a.SetClassifier(func(status int, header http.Header, body []byte) Class {
+		if bytes.Contains(body, []byte(`"rate_limited"`)) {
+			return Throttled
+		}
+		return Unclassified
+	})
+	var out struct{ Value int }
+	err := a.DoJSON(context.Background(), GET, "/", nil, &out)
+	assert.True(t, IsThrottled(err))
+	assert.Equal(t, 1, calls)
+
+	a.Retry = &RetryPolicy{MaxRetries: 1}
+	a.SetClock(fakeClock())
+	calls = 0
+	err = a.DoJSON(context.Background(), GET, "/", nil, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, out.Value)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRetryPermanent(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	a.SetClock(fakeClock())
+	err := a.DoJSON(context.Background(), GET, "/", nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryAfter(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls++; calls == 1 {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	a.Retry = &RetryPolicy{MaxRetries: 1, MaxBackoff: time.Second}
+	clk := fakeClock()
+	a.SetClock(clk)
+	start := clk.Now()
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/", nil, nil))
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 2*time.Minute, clk.Now().Sub(start))
+}
+
+func TestRetryCanceledWhileWaiting(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	clk := clock.NewFake(time.Now())
+	a.SetClock(clk)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.DoJSON(ctx, GET, "/", nil, nil) }()
+	clk.BlockUntil(1)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestRetryResponse(t *testing.T) {
+	statuses := []string{"PENDING", "PENDING", "ACTIVE"}
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[min(calls, len(statuses)-1)]
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"db-1","status":%q}`, status)
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	pending := func(status int, header http.Header, body []byte) (bool, time.Duration) {
+		return bytes.Contains(body, []byte(`"PENDING"`)), 5 * time.Second
+	}
+	a.Retry = &RetryPolicy{MaxRetries: 5, RetryResponse: pending}
+	clk := fakeClock()
+	a.SetClock(clk)
+	start := clk.Now()
+	var out struct{ ID, Status string }
+	var meta ResponseMeta
+	if !assert.NoError(t, a.DoJSON(context.Background(), GET, "/databases/db-1", nil, &out, WithMeta(&meta))) {
+		return
+	}
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "ACTIVE", out.Status)
+	assert.Equal(t, 2, meta.Retries)
+	assert.Equal(t, 10*time.Second, clk.Now().Sub(start))
+
+	// Once the retries are exhausted, the last response reaches the caller.
+	calls = 0
+	statuses = []string{"PENDING"}
+	a.Retry.MaxRetries = 1
+	out.Status = ""
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/databases/db-1", nil, &out))
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "PENDING", out.Status)
+
+	// The bodies larger than MaxResponseBody aren't retried.
+	calls = 0
+	a.Retry = &RetryPolicy{MaxRetries: 5, RetryResponse: pending, MaxResponseBody: 8}
+	out.Status = ""
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/databases/db-1", nil, &out))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "PENDING", out.Status)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	d, ok := ParseRetryAfter(http.Header{"Retry-After": {"120"}}, now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, d)
+	d, ok = ParseRetryAfter(http.Header{"Retry-After": {"Wed, 01 Jan 2020 00:00:30 GMT"}}, now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+	_, ok = ParseRetryAfter(http.Header{"Retry-After": {"soon"}}, now)
+	assert.False(t, ok)
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// clientCert is the function set by SetClientCertificateFunc along with the client derived from
+// base to present its certificates.
+type clientCert struct {
+	get    func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+	base   *http.Client
+	client *http.Client
+}
+
+// SetClientCertificateFunc makes the TLS connections of the Api present the client certificate
+// returned by get when the server asks for one, instead of the Certificates of the TLS config of
+// the transport, e.g. for mTLS certificates rotated on disk, see CertificateFiles. get is called
+// on every handshake, so the new connections pick up a rotated certificate while the open ones,
+// and the requests in flight on them, keep the one they were set up with. Nil removes it.
+//
+// The function is set by a copy of the Api's client that is derived again whenever Client
+// is replaced, and requires the Transport of the client to be an *http.Transport or nil.
+func (a *Api) SetClientCertificateFunc(get func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) {
+	if get == nil {
+		a.cert.Store(nil)
+		return
+	}
+	a.cert.Store(newClientCert(get, a.baseClient()))
+}
+
+// certClient returns the client presenting the client certificates for base, deriving it if needed.
+func (a *Api) certClient(base *http.Client) *http.Client {
+	c := a.cert.Load()
+	if c == nil {
+		return base
+	}
+	if c.base != base {
+		fresh := newClientCert(c.get, base)
+		if !a.cert.CompareAndSwap(c, fresh) {
+			return a.certClient(base)
+		}
+		c = fresh
+	}
+	return c.client
+}
+
+func newClientCert(get func(*tls.CertificateRequestInfo) (*tls.Certificate, error), base *http.Client) *clientCert {
+	c := &clientCert{get: get, base: base}
+	client := *base
+	rt := base.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if t, ok := rt.(*http.Transport); ok {
+		t = t.Clone()
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.GetClientCertificate = get
+		client.Transport = t
+	} else {
+		client.Transport = errTransport{fmt.Errorf("api: the client certificate requires an *http.Transport, got %T", rt)}
+	}
+	c.client = &client
+	return c
+}
+
+// CertificateFiles is a client certificate loaded from a pair of PEM files and reloaded when they
+// change on disk, for SetClientCertificateFunc:
+//
+//	files, err := api.LoadCertificateFiles("/run/certs/tls.crt", "/run/certs/tls.key", time.Minute)
+//	if err != nil {
+//		return err
+//	}
+//	a.SetClientCertificateFunc(files.GetClientCertificate)
+//
+// The files are checked for changes of their modification time or size on the handshakes at most
+// once per interval, without a background goroutine. If the new files can't be loaded, e.g. while
+// they're being replaced one after the other, the previous certificate is kept and they're checked
+// again on the next handshake.
+type CertificateFiles struct {
+	certFile, keyFile string
+	interval          time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	stamp   [2]fileStamp
+	checked time.Time
+}
+
+// fileStamp is what tells that a file changed.
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+// LoadCertificateFiles loads the certificate of the PEM files certFile and keyFile, checked for
+// changes at most once per interval, or on every handshake if interval isn't positive.
+func LoadCertificateFiles(certFile, keyFile string, interval time.Duration) (*CertificateFiles, error) {
+	f := &CertificateFiles{certFile: certFile, keyFile: keyFile, interval: interval}
+	stamp, err := f.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(stamp); err != nil {
+		return nil, err
+	}
+	f.checked = time.Now()
+	return f, nil
+}
+
+// GetClientCertificate returns the current certificate, reloading the files if they changed.
+func (f *CertificateFiles) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if now := time.Now(); now.Sub(f.checked) >= f.interval {
+		f.checked = now
+		if stamp, err := f.stat(); err == nil && stamp != f.stamp {
+			f.load(stamp)
+		}
+	}
+	return f.cert, nil
+}
+
+func (f *CertificateFiles) stat() ([2]fileStamp, error) {
+	var stamp [2]fileStamp
+	for i, name := range []string{f.certFile, f.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return stamp, err
+		}
+		stamp[i] = fileStamp{fi.ModTime(), fi.Size()}
+	}
+	return stamp, nil
+}
+
+func (f *CertificateFiles) load(stamp [2]fileStamp) error {
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return err
+	}
+	f.cert, f.stamp = &cert, stamp
+	return nil
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeClientCert writes a self-signed client certificate for name to the PEM files certFile and keyFile.
+func writeClientCert(t *testing.T, certFile, keyFile, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func TestClientCertificateRotation(t *testing.T) {
+	var mu sync.Mutex
+	var peers []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		peers = append(peers, r.TLS.PeerCertificates[0].Subject.CommonName)
+		mu.Unlock()
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeClientCert(t, certFile, keyFile, "first")
+	files, err := LoadCertificateFiles(certFile, keyFile, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a := MustNew(srv.URL)
+	a.Client = srv.Client()
+	a.SetClientCertificateFunc(files.GetClientCertificate)
+	ctx := context.Background()
+
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil))
+	writeClientCert(t, certFile, keyFile, "second")
+	// Backdate the files, so the change is seen even if the clock is too coarse.
+	old := time.Now().Add(-time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, old, old))
+	assert.NoError(t, os.Chtimes(keyFile, old, old))
+	// The open connection keeps its certificate, the new ones present the new one.
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil))
+	srv.CloseClientConnections()
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil))
+	mu.Lock()
+	assert.Equal(t, []string{"first", "first", "second"}, peers)
+	mu.Unlock()
+
+	// A broken pair keeps the last certificate.
+	assert.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0o600))
+	srv.CloseClientConnections()
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil))
+	mu.Lock()
+	assert.Equal(t, "second", peers[len(peers)-1])
+	mu.Unlock()
+
+	// Without the function, the server refuses the handshake.
+	a.SetClientCertificateFunc(nil)
+	srv.CloseClientConnections()
+	srv.Client().CloseIdleConnections()
+	assert.Error(t, a.DoJSON(ctx, GET, "/", nil, nil))
+
+	_, err = LoadCertificateFiles(certFile, filepath.Join(dir, "missing.key"), time.Minute)
+	assert.Error(t, err)
+}
+
+func TestClientCertificateTransport(t *testing.T) {
+	a := MustNew("https://api.example.com")
+	a.Client = &http.Client{Transport: &FixtureTransport{Dir: t.TempDir()}}
+	a.SetClientCertificateFunc(func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &tls.Certificate{}, nil
+	})
+	err := a.DoJSON(context.Background(), GET, "/", nil, nil)
+	assert.ErrorContains(t, err, "requires an *http.Transport")
+}
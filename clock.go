@@ -0,0 +1,26 @@
+package api
+
+import "github.com/xlab/api/internal/clock"
+
+// Clock is the source of time of an Api, used for retry backoffs and the timing of calls.
+// See apitest.FakeClock for one that can be advanced manually in tests.
+type Clock = clock.Clock
+
+// Timer is a timer created by a Clock, the equivalent of *time.Timer.
+type Timer = clock.Timer
+
+// SetClock sets the source of time of the Api. Passing nil restores the real time.
+func (a *Api) SetClock(c Clock) {
+	a.mu.Lock()
+	a.clk = c
+	a.mu.Unlock()
+}
+
+func (a *Api) clock() Clock {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.clk == nil {
+		return clock.Real{}
+	}
+	return a.clk
+}
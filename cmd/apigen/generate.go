@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// methods are the constants of api.Method the Method of an endpoint may name.
+var methods = map[string]bool{"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true, "PATCH": true}
+
+var placeholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// endpoint is an entry of the table.
+type endpoint struct {
+	Name, Method, Path string
+	// Field is the name of the field of the client holding the template.
+	Field string
+	// Params, Body and Response are the types of the entry, empty if it has none.
+	Params, Body, Response string
+	// Path and Query are the fields of Params.
+	PathParams []param
+	Query      []param
+}
+
+// param is a field of a Params struct.
+type param struct {
+	Key string
+	// Value is the expression of the value, Cond the condition for setting it, if any.
+	Value, Cond string
+	// Each is set for a slice, whose elements are added one by one.
+	Each bool
+}
+
+// file is the generated file.
+type file struct {
+	Package, Table, Client string
+	Fmt, URL               bool
+	Endpoints              []endpoint
+}
+
+// generate returns the source of the client for the table of the package in dir, ignoring
+// its tests and the output file.
+func generate(dir, table, client, output string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != output
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("%s: expected a single package, found %d", dir, len(pkgs))
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+	f := &file{Package: pkg.Name, Table: table, Client: client}
+	structs := make(map[string]*ast.StructType)
+	var lit *ast.CompositeLit
+	for _, af := range pkg.Files {
+		for _, decl := range af.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if st, ok := spec.Type.(*ast.StructType); ok {
+						structs[spec.Name.Name] = st
+					}
+				case *ast.ValueSpec:
+					for i, name := range spec.Names {
+						if name.Name == table && i < len(spec.Values) {
+							lit, _ = spec.Values[i].(*ast.CompositeLit)
+						}
+					}
+				}
+			}
+		}
+	}
+	if lit == nil {
+		return nil, fmt.Errorf("%s: no table %s declared as a composite literal", dir, table)
+	}
+	names := make(map[string]bool)
+	for _, elt := range lit.Elts {
+		e, err := parseEndpoint(fset, elt, structs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fset.Position(elt.Pos()), err)
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("%s: duplicate endpoint %s", fset.Position(elt.Pos()), e.Name)
+		}
+		names[e.Name] = true
+		for _, p := range append(e.PathParams, e.Query...) {
+			f.Fmt = f.Fmt || strings.HasPrefix(p.Value, "fmt.")
+		}
+		f.URL = f.URL || len(e.Query) > 0
+		f.Endpoints = append(f.Endpoints, e)
+	}
+	var buf bytes.Buffer
+	if err := clientTemplate.Execute(&buf, f); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+func parseEndpoint(fset *token.FileSet, elt ast.Expr, structs map[string]*ast.StructType) (endpoint, error) {
+	var e endpoint
+	cl, ok := elt.(*ast.CompositeLit)
+	if !ok {
+		return e, fmt.Errorf("endpoint isn't a composite literal")
+	}
+	for _, kv := range cl.Elts {
+		kv, ok := kv.(*ast.KeyValueExpr)
+		if !ok {
+			return e, fmt.Errorf("endpoint fields must be keyed")
+		}
+		key, _ := kv.Key.(*ast.Ident)
+		if key == nil {
+			return e, fmt.Errorf("endpoint fields must be keyed")
+		}
+		var err error
+		switch key.Name {
+		case "Name":
+			e.Name, err = stringLit(kv.Value)
+		case "Path":
+			e.Path, err = stringLit(kv.Value)
+		case "Method":
+			switch v := kv.Value.(type) {
+			case *ast.SelectorExpr:
+				e.Method = v.Sel.Name
+			case *ast.Ident:
+				e.Method = v.Name
+			}
+			if !methods[e.Method] {
+				err = fmt.Errorf("Method must be an api.Method constant")
+			}
+		case "Params":
+			e.Params, err = typeOf(fset, kv.Value)
+		case "Body":
+			e.Body, err = typeOf(fset, kv.Value)
+		case "Response":
+			e.Response, err = typeOf(fset, kv.Value)
+		default:
+			err = fmt.Errorf("unknown endpoint field %s", key.Name)
+		}
+		if err != nil {
+			return e, fmt.Errorf("%s: %w", key.Name, err)
+		}
+	}
+	if !token.IsIdentifier(e.Name) || !token.IsExported(e.Name) {
+		return e, fmt.Errorf("Name %q isn't an exported identifier", e.Name)
+	}
+	if e.Method == "" || e.Path == "" {
+		return e, fmt.Errorf("endpoint %s: Method and Path are required", e.Name)
+	}
+	e.Field = strings.ToLower(e.Name[:1]) + e.Name[1:]
+	if token.IsKeyword(e.Field) {
+		e.Field += "Endpoint"
+	}
+	if err := e.parseParams(structs); err != nil {
+		return e, fmt.Errorf("endpoint %s: %w", e.Name, err)
+	}
+	return e, nil
+}
+
+func stringLit(expr ast.Expr) (string, error) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", fmt.Errorf("must be a string literal")
+	}
+	return strconv.Unquote(lit.Value)
+}
+
+// typeOf returns the type of a composite literal like User{}, &User{} or []User{}, empty for nil.
+func typeOf(fset *token.FileSet, expr ast.Expr) (string, error) {
+	if id, ok := expr.(*ast.Ident); ok && id.Name == "nil" {
+		return "", nil
+	}
+	ptr := ""
+	if u, ok := expr.(*ast.UnaryExpr); ok && u.Op == token.AND {
+		ptr, expr = "*", u.X
+	}
+	cl, ok := expr.(*ast.CompositeLit)
+	if !ok || cl.Type == nil {
+		return "", fmt.Errorf("must be a composite literal of the type, e.g. User{}")
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, cl.Type); err != nil {
+		return "", err
+	}
+	return ptr + buf.String(), nil
+}
+
+// parseParams fills in the path and query parameters from the fields of the Params struct.
+func (e *endpoint) parseParams(structs map[string]*ast.StructType) error {
+	want := make(map[string]bool)
+	for _, m := range placeholder.FindAllStringSubmatch(e.Path, -1) {
+		want[m[1]] = true
+	}
+	if e.Params == "" {
+		if len(want) > 0 {
+			return fmt.Errorf("Path has parameters but there are no Params")
+		}
+		return nil
+	}
+	st, ok := structs[e.Params]
+	if !ok {
+		return fmt.Errorf("Params must be a struct type declared in the package, got %s", e.Params)
+	}
+	for _, field := range st.Fields.List {
+		if len(field.Names) == 0 {
+			return fmt.Errorf("%s: embedded fields aren't supported", e.Params)
+		}
+		if field.Tag == nil {
+			continue
+		}
+		tagValue, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			return err
+		}
+		tag := reflect.StructTag(tagValue)
+		for _, name := range field.Names {
+			expr := "p." + name.Name
+			if key, ok := tag.Lookup("path"); ok {
+				if !want[key] {
+					return fmt.Errorf("%s.%s: Path has no parameter {%s}", e.Params, name.Name, key)
+				}
+				delete(want, key)
+				switch field.Type.(type) {
+				case *ast.ArrayType, *ast.StarExpr:
+					return fmt.Errorf("%s.%s: a path parameter can't be a slice or a pointer", e.Params, name.Name)
+				}
+				e.PathParams = append(e.PathParams, param{Key: key, Value: formatValue(expr, field.Type)})
+			}
+			if value, ok := tag.Lookup("query"); ok {
+				key, opt, _ := strings.Cut(value, ",")
+				p, err := queryParam(key, expr, field.Type, opt == "omitempty")
+				if err != nil {
+					return fmt.Errorf("%s.%s: %w", e.Params, name.Name, err)
+				}
+				e.Query = append(e.Query, p)
+			}
+		}
+	}
+	if len(want) > 0 {
+		missing := make([]string, 0, len(want))
+		for key := range want {
+			missing = append(missing, "{"+key+"}")
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("%s has no fields for %s", e.Params, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// formatValue returns the expression formatting the value expr of type typ as a string.
+func formatValue(expr string, typ ast.Expr) string {
+	if id, ok := typ.(*ast.Ident); ok && id.Name == "string" {
+		return expr
+	}
+	return "fmt.Sprint(" + expr + ")"
+}
+
+func queryParam(key, expr string, typ ast.Expr, omitEmpty bool) (param, error) {
+	switch t := typ.(type) {
+	case *ast.ArrayType:
+		return param{Key: key, Value: formatValue("v", t.Elt), Cond: expr, Each: true}, nil
+	case *ast.StarExpr:
+		return param{Key: key, Value: formatValue("*"+expr, t.X), Cond: expr + " != nil"}, nil
+	}
+	p := param{Key: key, Value: formatValue(expr, typ)}
+	if !omitEmpty {
+		return p, nil
+	}
+	id, _ := typ.(*ast.Ident)
+	switch {
+	case id == nil:
+		return p, fmt.Errorf("omitempty isn't supported for its type")
+	case id.Name == "string":
+		p.Cond = expr + ` != ""`
+	case id.Name == "bool":
+		p.Cond = expr
+	case strings.HasPrefix(id.Name, "int"), strings.HasPrefix(id.Name, "uint"), strings.HasPrefix(id.Name, "float"):
+		p.Cond = expr + " != 0"
+	default:
+		return p, fmt.Errorf("omitempty isn't supported for %s", id.Name)
+	}
+	return p, nil
+}
+
+var clientTemplate = template.Must(template.New("client").Parse(`// Code generated by apigen from the {{.Table}} table; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+{{- if .Fmt}}
+	"fmt"
+{{- end}}
+{{- if .URL}}
+	"net/url"
+{{- end}}
+
+	"github.com/xlab/api"
+)
+
+// {{.Client}} calls the endpoints of the {{.Table}} table.
+type {{.Client}} struct {
+{{- range .Endpoints}}
+	{{.Field}} *api.RequestTemplate
+{{- end}}
+}
+
+// New{{.Client}} returns a {{.Client}} calling the endpoints through a, applying opts to every call.
+func New{{.Client}}(a *api.Api, opts ...api.Option) *{{.Client}} {
+	return &{{.Client}}{
+{{- range .Endpoints}}
+		{{.Field}}: a.Template(api.{{.Method}}, {{printf "%q" .Path}}, opts...),
+{{- end}}
+	}
+}
+{{range .Endpoints}}
+// {{.Name}} calls {{.Method}} {{.Path}}.
+func (c *{{$.Client}}) {{.Name}}(ctx context.Context{{if .Params}}, p {{.Params}}{{end}}{{if .Body}}, body {{.Body}}{{end}}) {{if .Response}}({{.Response}}, error){{else}}error{{end}} {
+{{- if .PathParams}}
+	params := map[string]string{
+{{- range .PathParams}}
+		{{printf "%q" .Key}}: {{.Value}},
+{{- end}}
+	}
+{{- end}}
+{{- if .Query}}
+	args := url.Values{}
+{{- range .Query}}
+{{- if .Each}}
+	for _, v := range {{.Cond}} {
+		args.Add({{printf "%q" .Key}}, {{.Value}})
+	}
+{{- else if .Cond}}
+	if {{.Cond}} {
+		args.Set({{printf "%q" .Key}}, {{.Value}})
+	}
+{{- else}}
+	args.Set({{printf "%q" .Key}}, {{.Value}})
+{{- end}}
+{{- end}}
+{{- end}}
+{{- $params := "nil"}}{{if .PathParams}}{{$params = "params"}}{{end}}
+{{- $args := "nil"}}{{if .Query}}{{$args = "args"}}{{end}}
+{{- $call := "Do"}}{{$body := ""}}{{if .Body}}{{$call = "DoBody"}}{{$body = ", body"}}{{end}}
+{{- if .Response}}
+	var out {{.Response}}
+	if err := c.{{.Field}}.{{$call}}(ctx, {{$params}}, {{$args}}{{$body}}, &out); err != nil {
+		var zero {{.Response}}
+		return zero, err
+	}
+	return out, nil
+{{- else}}
+	return c.{{.Field}}.{{$call}}(ctx, {{$params}}, {{$args}}{{$body}}, nil)
+{{- end}}
+}
+{{end}}`))
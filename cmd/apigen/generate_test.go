@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the generated example")
+
+func TestGenerateGolden(t *testing.T) {
+	dir := filepath.Join("internal", "example")
+	src, err := generate(dir, "Endpoints", "Client", "endpoints_gen.go")
+	if !assert.NoError(t, err) {
+		return
+	}
+	golden := filepath.Join(dir, "endpoints_gen.go")
+	if *update {
+		if !assert.NoError(t, os.WriteFile(golden, src, 0o644)) {
+			return
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, string(want), string(src), "run go test -update to regenerate")
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		src, err string
+	}{
+		"missing table": {
+			src: `var Other = []api.Endpoint{}`,
+			err: "no table Endpoints",
+		},
+		"unknown method": {
+			src: `var Endpoints = []api.Endpoint{{Name: "Get", Method: api.TRACE, Path: "/"}}`,
+			err: "Method must be an api.Method constant",
+		},
+		"unexported name": {
+			src: `var Endpoints = []api.Endpoint{{Name: "get", Method: api.GET, Path: "/"}}`,
+			err: `Name "get" isn't an exported identifier`,
+		},
+		"duplicate": {
+			src: `var Endpoints = []api.Endpoint{{Name: "Get", Method: api.GET, Path: "/"}, {Name: "Get", Method: api.GET, Path: "/"}}`,
+			err: "duplicate endpoint Get",
+		},
+		"missing params": {
+			src: `var Endpoints = []api.Endpoint{{Name: "Get", Method: api.GET, Path: "/users/{id}"}}`,
+			err: "Path has parameters but there are no Params",
+		},
+		"missing field": {
+			src: `type P struct{ Org string ` + "`path:\"org\"`" + ` }
+var Endpoints = []api.Endpoint{{Name: "Get", Method: api.GET, Path: "/orgs/{org}/users/{id}", Params: P{}}}`,
+			err: "P has no fields for {id}",
+		},
+		"unknown parameter": {
+			src: `type P struct{ ID int ` + "`path:\"uid\"`" + ` }
+var Endpoints = []api.Endpoint{{Name: "Get", Method: api.GET, Path: "/users/{id}", Params: P{}}}`,
+			err: "P.ID: Path has no parameter {uid}",
+		},
+		"omitempty": {
+			src: `type P struct{ At time.Time ` + "`query:\"at,omitempty\"`" + ` }
+var Endpoints = []api.Endpoint{{Name: "Get", Method: api.GET, Path: "/", Params: P{}}}`,
+			err: "P.At: omitempty isn't supported for its type",
+		},
+		"not a literal": {
+			src: `var Endpoints = []api.Endpoint{{Name: "Get", Method: api.GET, Path: "/", Response: user}}`,
+			err: "Response: must be a composite literal",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := "package p\n\n" + tc.src + "\n"
+			if !assert.NoError(t, os.WriteFile(filepath.Join(dir, "endpoints.go"), []byte(src), 0o644)) {
+				return
+			}
+			_, err := generate(dir, "Endpoints", "Client", "endpoints_gen.go")
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
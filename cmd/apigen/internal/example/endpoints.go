@@ -0,0 +1,42 @@
+// Package example is a client generated by apigen, tested against its output.
+package example
+
+import "github.com/xlab/api"
+
+//go:generate go run github.com/xlab/api/cmd/apigen -table Endpoints -client Client
+
+// Endpoints are the endpoints of Client.
+var Endpoints = []api.Endpoint{
+	{Name: "GetUser", Method: api.GET, Path: "/users/{id}", Params: GetUserParams{}, Response: User{}},
+	{Name: "ListUsers", Method: api.GET, Path: "/users", Params: ListUsersParams{}, Response: []User{}},
+	{Name: "CreateUser", Method: api.POST, Path: "/users", Body: NewUser{}, Response: &User{}},
+	{Name: "UpdateUser", Method: api.PATCH, Path: "/users/{id}", Params: GetUserParams{}, Body: NewUser{}},
+	{Name: "DeleteUser", Method: api.DELETE, Path: "/orgs/{org}/users/{id}", Params: DeleteUserParams{}},
+}
+
+type User struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type NewUser struct {
+	Name string `json:"name"`
+}
+
+type GetUserParams struct {
+	ID int `path:"id"`
+}
+
+type ListUsersParams struct {
+	Page   int      `query:"page,omitempty"`
+	Status string   `query:"status"`
+	Tags   []string `query:"tag"`
+	Active *bool    `query:"active"`
+}
+
+type DeleteUserParams struct {
+	Org string `path:"org"`
+	ID  int    `path:"id"`
+	// Hard isn't sent when false.
+	Hard bool `query:"hard,omitempty"`
+}
@@ -0,0 +1,96 @@
+// Code generated by apigen from the Endpoints table; DO NOT EDIT.
+
+package example
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/xlab/api"
+)
+
+// Client calls the endpoints of the Endpoints table.
+type Client struct {
+	getUser    *api.RequestTemplate
+	listUsers  *api.RequestTemplate
+	createUser *api.RequestTemplate
+	updateUser *api.RequestTemplate
+	deleteUser *api.RequestTemplate
+}
+
+// NewClient returns a Client calling the endpoints through a, applying opts to every call.
+func NewClient(a *api.Api, opts ...api.Option) *Client {
+	return &Client{
+		getUser:    a.Template(api.GET, "/users/{id}", opts...),
+		listUsers:  a.Template(api.GET, "/users", opts...),
+		createUser: a.Template(api.POST, "/users", opts...),
+		updateUser: a.Template(api.PATCH, "/users/{id}", opts...),
+		deleteUser: a.Template(api.DELETE, "/orgs/{org}/users/{id}", opts...),
+	}
+}
+
+// GetUser calls GET /users/{id}.
+func (c *Client) GetUser(ctx context.Context, p GetUserParams) (User, error) {
+	params := map[string]string{
+		"id": fmt.Sprint(p.ID),
+	}
+	var out User
+	if err := c.getUser.Do(ctx, params, nil, &out); err != nil {
+		var zero User
+		return zero, err
+	}
+	return out, nil
+}
+
+// ListUsers calls GET /users.
+func (c *Client) ListUsers(ctx context.Context, p ListUsersParams) ([]User, error) {
+	args := url.Values{}
+	if p.Page != 0 {
+		args.Set("page", fmt.Sprint(p.Page))
+	}
+	args.Set("status", p.Status)
+	for _, v := range p.Tags {
+		args.Add("tag", v)
+	}
+	if p.Active != nil {
+		args.Set("active", fmt.Sprint(*p.Active))
+	}
+	var out []User
+	if err := c.listUsers.Do(ctx, nil, args, &out); err != nil {
+		var zero []User
+		return zero, err
+	}
+	return out, nil
+}
+
+// CreateUser calls POST /users.
+func (c *Client) CreateUser(ctx context.Context, body NewUser) (*User, error) {
+	var out *User
+	if err := c.createUser.DoBody(ctx, nil, nil, body, &out); err != nil {
+		var zero *User
+		return zero, err
+	}
+	return out, nil
+}
+
+// UpdateUser calls PATCH /users/{id}.
+func (c *Client) UpdateUser(ctx context.Context, p GetUserParams, body NewUser) error {
+	params := map[string]string{
+		"id": fmt.Sprint(p.ID),
+	}
+	return c.updateUser.DoBody(ctx, params, nil, body, nil)
+}
+
+// DeleteUser calls DELETE /orgs/{org}/users/{id}.
+func (c *Client) DeleteUser(ctx context.Context, p DeleteUserParams) error {
+	params := map[string]string{
+		"org": p.Org,
+		"id":  fmt.Sprint(p.ID),
+	}
+	args := url.Values{}
+	if p.Hard {
+		args.Set("hard", fmt.Sprint(p.Hard))
+	}
+	return c.deleteUser.Do(ctx, params, args, nil)
+}
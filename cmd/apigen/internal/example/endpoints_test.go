@@ -0,0 +1,74 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api"
+)
+
+func TestClient(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		switch r.URL.Path {
+		case "/users/7":
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`{"id":7,"name":"alice"}`))
+			}
+		case "/users/8":
+			w.WriteHeader(http.StatusNotFound)
+		case "/users":
+			if r.Method == http.MethodPost {
+				var u NewUser
+				json.Unmarshal(body, &u)
+				json.NewEncoder(w).Encode(User{ID: 9, Name: u.Name})
+				return
+			}
+			w.Write([]byte(`[{"id":1,"name":"a"},{"id":2,"name":"b"}]`))
+		}
+	}))
+	defer srv.Close()
+	c := NewClient(api.MustNew(srv.URL))
+	ctx := context.Background()
+
+	u, err := c.GetUser(ctx, GetUserParams{ID: 7})
+	assert.NoError(t, err)
+	assert.Equal(t, User{ID: 7, Name: "alice"}, u)
+
+	_, err = c.GetUser(ctx, GetUserParams{ID: 8})
+	var se *api.StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, http.StatusNotFound, se.Code)
+	}
+
+	active := true
+	users, err := c.ListUsers(ctx, ListUsersParams{Status: "open", Tags: []string{"x", "y"}, Active: &active})
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+
+	created, err := c.CreateUser(ctx, NewUser{Name: "bob"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, &User{ID: 9, Name: "bob"}, created)
+	}
+	assert.NoError(t, c.UpdateUser(ctx, GetUserParams{ID: 7}, NewUser{Name: "carol"}))
+	assert.NoError(t, c.DeleteUser(ctx, DeleteUserParams{Org: "acme corp", ID: 7}))
+	assert.NoError(t, c.DeleteUser(ctx, DeleteUserParams{Org: "acme", ID: 7, Hard: true}))
+
+	assert.Equal(t, []string{
+		"GET /users/7 ",
+		"GET /users/8 ",
+		"GET /users?active=true&status=open&tag=x&tag=y ",
+		`POST /users {"name":"bob"}`,
+		`PATCH /users/7 {"name":"carol"}`,
+		"DELETE /orgs/acme%20corp/users/7 ",
+		"DELETE /orgs/acme/users/7?hard=true ",
+	}, got)
+}
@@ -0,0 +1,38 @@
+// Command apigen generates a typed client from a table of api.Endpoint declarations, see
+// api.Endpoint. It's meant to be run by go generate from the package declaring the table:
+//
+//	//go:generate go run github.com/xlab/api/cmd/apigen -table Endpoints -client Client
+//
+// For every endpoint, the client gets a method taking the context, the params and the body,
+// if the endpoint has them, and returning the response. The methods call the RequestTemplate
+// of their Path, so their calls are logged and measured under it, and return the errors of
+// the api package as they are.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	table := flag.String("table", "Endpoints", "name of the `variable` holding the []api.Endpoint table")
+	client := flag.String("client", "Client", "name of the generated client `type`")
+	dir := flag.String("dir", ".", "`directory` of the package declaring the table")
+	output := flag.String("o", "", "output `file` within dir, the lowercase table name with _gen.go if empty")
+	flag.Parse()
+	if *output == "" {
+		*output = strings.ToLower(*table) + "_gen.go"
+	}
+	src, err := generate(*dir, *table, *client, *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "apigen:", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(filepath.Join(*dir, *output), src, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "apigen:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// AESGCMContentType is the media type of the bodies encrypted by AESGCMCodec.
+const AESGCMContentType = "application/aes-gcm"
+
+// ErrBodyCodec is the error the failures of a BodyCodec match with errors.Is.
+var ErrBodyCodec = errors.New("api: body codec failed")
+
+// BodyCodec transforms the bodies on the wire, e.g. to encrypt the payloads for a partner
+// while the transport stays ordinary HTTPS, see SetBodyCodec. Its methods are called concurrently.
+type BodyCodec interface {
+	// EncodeBody returns the body to send in place of an encoded request body, and its
+	// content type.
+	EncodeBody(contentType string, body []byte) (newContentType string, newBody []byte, err error)
+	// DecodeBody returns the body to decode in place of a response body, and its content type.
+	// Bodies it doesn't recognize, e.g. the plain errors of a proxy, are returned as they are.
+	DecodeBody(contentType string, body []byte) (newContentType string, newBody []byte, err error)
+}
+
+// BodyCodecError is returned when a BodyCodec fails to encode a request body or to decode
+// a response body.
+type BodyCodecError struct {
+	// Op is "encode" or "decode".
+	Op  string
+	Err error
+}
+
+func (e *BodyCodecError) Error() string {
+	return fmt.Sprintf("api: body codec: %s: %v", e.Op, e.Err)
+}
+
+func (e *BodyCodecError) Unwrap() error { return e.Err }
+
+// Is makes the error match ErrBodyCodec.
+func (e *BodyCodecError) Is(target error) bool { return target == ErrBodyCodec }
+
+// Class makes the error permanent: another attempt would fail the same way.
+func (e *BodyCodecError) Class() Class { return Permanent }
+
+// SetBodyCodec makes the Api pass the request bodies through c once they're encoded, and the
+// response bodies before they're decoded, so the JSON helpers keep working on the plain
+// payloads. The request bodies are encoded once, by the request constructors and templates:
+// the retries and hedges replay the same bytes, and the preparers, like UploadChecksum, see
+// the bytes sent. The bodies streamed by RequestReader and Upload are sent as they are. The
+// response bodies are read in full on arrival, before the wrappers of the options, like
+// ConvertCharset, read them. A nil c disables the codec.
+func (a *Api) SetBodyCodec(c BodyCodec) {
+	a.mu.Lock()
+	a.codec = c
+	a.mu.Unlock()
+}
+
+func (a *Api) bodyCodec() BodyCodec {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.codec
+}
+
+// encodeBody passes the buffered body of req through the request transforms and the codec of the Api.
+func (a *Api) encodeBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody == nil {
+		return nil
+	}
+	codec := a.bodyCodec()
+	var transforms []JSONTransform
+	if t := a.transforms.Load(); t != nil && isJSONType(req.Header.Get("Content-Type")) {
+		transforms = t.request
+	}
+	if codec == nil && transforms == nil {
+		return nil
+	}
+	if _, ok := req.Body.(*seekBody); ok {
+		return nil
+	}
+	data, ok := encodedBody(req)
+	if !ok {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		data, err = io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	if transforms != nil {
+		if data, err = transformJSON(data, transforms); err != nil {
+			return &TransformError{Op: "request", Err: err}
+		}
+	}
+	contentType := req.Header.Get("Content-Type")
+	if codec != nil {
+		if contentType, data, err = codec.EncodeBody(contentType, data); err != nil {
+			return &BodyCodecError{Op: "encode", Err: err}
+		}
+	}
+	setBytesBody(req, data)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
+
+// decodeBody replaces the body of resp by its decoding by codec.
+func decodeBody(codec BodyCodec, resp *http.Response) error {
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	contentType, data, err := codec.DecodeBody(resp.Header.Get("Content-Type"), data)
+	if err != nil {
+		return &BodyCodecError{Op: "decode", Err: err}
+	}
+	if contentType != "" {
+		resp.Header.Set("Content-Type", contentType)
+	}
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = int64(len(data))
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return nil
+}
+
+// AESGCMCodec is a BodyCodec encrypting the bodies with AES-GCM under a key shared with the
+// server. An encrypted body is a random 12-byte nonce followed by the sealed payload, sent as
+// AESGCMContentType with the content type of the payload in its cty parameter, which is
+// authenticated along with it. The responses of another content type are passed through.
+type AESGCMCodec struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCMCodec returns an AESGCMCodec for a 16, 24 or 32-byte key, selecting AES-128,
+// AES-192 or AES-256.
+func NewAESGCMCodec(key []byte) (*AESGCMCodec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCMCodec{aead: aead}, nil
+}
+
+// EncodeBody encrypts body.
+func (c *AESGCMCodec) EncodeBody(contentType string, body []byte) (string, []byte, error) {
+	out := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(body)+c.aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return "", nil, err
+	}
+	out = c.aead.Seal(out, out, body, []byte(contentType))
+	return mime.FormatMediaType(AESGCMContentType, map[string]string{"cty": contentType}), out, nil
+}
+
+// DecodeBody decrypts body if it's of AESGCMContentType.
+func (c *AESGCMCodec) DecodeBody(contentType string, body []byte) (string, []byte, error) {
+	mediatype, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediatype != AESGCMContentType {
+		return contentType, body, nil
+	}
+	if len(body) < c.aead.NonceSize() {
+		return "", nil, errors.New("encrypted body too short")
+	}
+	nonce, sealed := body[:c.aead.NonceSize()], body[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, sealed, []byte(params["cty"]))
+	if err != nil {
+		return "", nil, err
+	}
+	return params["cty"], plain, nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyCodec(t *testing.T) {
+	codec, err := NewAESGCMCodec(bytes.Repeat([]byte{7}, 32))
+	if !assert.NoError(t, err) {
+		return
+	}
+	var sealed [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/plain" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name":"plain"}`))
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		sealed = append(sealed, data)
+		contentType, plain, err := codec.DecodeBody(r.Header.Get("Content-Type"), data)
+		if err != nil || r.ContentLength != int64(len(data)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		echo, _ := json.Marshal(map[string]string{"type": contentType, "echo": string(plain)})
+		contentType, data, _ = codec.EncodeBody("application/json", echo)
+		if r.URL.Path == "/forged" {
+			data[len(data)-1] ^= 1
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(data)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetBodyCodec(codec)
+	a.Retry = &RetryPolicy{MaxRetries: 2}
+	a.SetClock(fakeClock())
+	ctx := context.Background()
+
+	req, err := a.RequestJSON(POST, "/users", map[string]string{"name": "alice"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	var out struct {
+		Type string
+		Echo string
+	}
+	resp, err := a.Do(ctx, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	assert.Equal(t, "application/json", out.Type)
+	assert.Equal(t, `{"name":"alice"}`, out.Echo)
+	assert.NotContains(t, string(sealed[0]), "alice")
+
+	// The forms of the templates are encoded too.
+	assert.NoError(t, a.Template(POST, "/users/{id}").Do(ctx, map[string]string{"id": "1"}, url.Values{"a": {"1"}}, &out))
+	assert.Equal(t, "application/x-www-form-urlencoded", out.Type)
+	assert.Equal(t, "a=1", out.Echo)
+
+	// The bodies of other content types are passed through.
+	var plain struct{ Name string }
+	assert.NoError(t, a.DoJSON(ctx, GET, "/plain", nil, &plain))
+	assert.Equal(t, "plain", plain.Name)
+
+	n := len(sealed)
+	err = a.DoJSON(ctx, POST, "/forged", url.Values{"a": {"1"}}, nil)
+	var ce *BodyCodecError
+	if assert.True(t, errors.As(err, &ce), "%v", err) {
+		assert.Equal(t, "decode", ce.Op)
+	}
+	assert.True(t, errors.Is(err, ErrBodyCodec))
+	assert.Len(t, sealed, n+1, "a codec error isn't retried")
+}
+
+func TestNewAESGCMCodec(t *testing.T) {
+	_, err := NewAESGCMCodec([]byte("short"))
+	assert.Error(t, err)
+
+	codec, err := NewAESGCMCodec(bytes.Repeat([]byte{1}, 16))
+	if !assert.NoError(t, err) {
+		return
+	}
+	contentType, sealed, err := codec.EncodeBody("text/plain; charset=utf-8", []byte("hello"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, `application/aes-gcm; cty="text/plain; charset=utf-8"`, contentType)
+	_, again, _ := codec.EncodeBody("text/plain; charset=utf-8", []byte("hello"))
+	assert.NotEqual(t, sealed, again, "the nonces are random")
+	contentType, plain, err := codec.DecodeBody(contentType, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", contentType)
+	assert.Equal(t, "hello", string(plain))
+
+	// The content type is authenticated.
+	_, _, err = codec.DecodeBody(`application/aes-gcm; cty="application/json"`, sealed)
+	assert.Error(t, err)
+	_, _, err = codec.DecodeBody(AESGCMContentType, []byte("x"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidID is returned by the methods of a Collection for the ids that can't be a path
+// segment: the empty one, "." and "..".
+var ErrInvalidID = errors.New("api: invalid collection item id")
+
+// Collection is a typed client of a CRUD collection of T at a resource, e.g. "/widgets", whose
+// items are at "/widgets/<id>":
+//
+//	widgets := api.NewCollection[Widget](a, "/widgets")
+//	w, err := widgets.Create(ctx, Widget{Name: "gear"})
+//	w, err = widgets.Patch(ctx, w.ID, map[string]interface{}{"stock": 12})
+//	all, err := widgets.List(ctx, nil)
+//
+// The calls are made like DoJSON makes them, failing with a *StatusError for non-2xx responses.
+// The ids are escaped as a single segment of the path, slashes included.
+type Collection[T any] struct {
+	api      *Api
+	resource string
+	// ItemsPath is the dotted path to the items array within the pages of List, e.g. "items"
+	// for {"items": [...]}, unless ListOptions.ItemsPath is set. If empty, the pages are arrays.
+	ItemsPath string
+}
+
+// NewCollection returns the Collection of T at resource.
+func NewCollection[T any](a *Api, resource string) *Collection[T] {
+	return &Collection[T]{api: a, resource: resource}
+}
+
+// Get fetches the item with the id.
+func (c *Collection[T]) Get(ctx context.Context, id string, opts ...Option) (T, error) {
+	return c.itemCall(ctx, GET, id, nil, opts)
+}
+
+// List fetches all the items, walking the pages like List does. The items fetched before an
+// error are returned with it.
+func (c *Collection[T]) List(ctx context.Context, opts *ListOptions) ([]T, error) {
+	var o ListOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.ItemsPath == "" {
+		o.ItemsPath = c.ItemsPath
+	}
+	var items []T
+	err := List(ctx, c.api, c.resource, &o, func(item T) error {
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// Create posts the item to the collection and returns the created item of the response.
+func (c *Collection[T]) Create(ctx context.Context, item T, opts ...Option) (T, error) {
+	var out T
+	req, err := c.api.RequestJSON(POST, c.resource, item, buildContext(ctx))
+	if err != nil {
+		return out, err
+	}
+	err = c.api.sendJSON(ctx, req, c.resource, &out, opts)
+	return out, err
+}
+
+// Update replaces the item with the id by item with a PUT and returns the updated item of the response.
+func (c *Collection[T]) Update(ctx context.Context, id string, item T, opts ...Option) (T, error) {
+	return c.itemCall(ctx, PUT, id, item, opts)
+}
+
+// Patch sends the partial item encoded as JSON, e.g. a map of the fields to change, with a
+// PATCH and returns the updated item of the response.
+func (c *Collection[T]) Patch(ctx context.Context, id string, partial interface{}, opts ...Option) (T, error) {
+	return c.itemCall(ctx, PATCH, id, partial, opts)
+}
+
+// Delete deletes the item with the id. The response body, if any, is discarded.
+func (c *Collection[T]) Delete(ctx context.Context, id string, opts ...Option) error {
+	req, resource, err := c.itemRequest(ctx, DELETE, id, nil)
+	if err != nil {
+		return err
+	}
+	return c.api.sendJSON(ctx, req, resource, nil, opts)
+}
+
+// itemCall sends the call to the item with the id, with body encoded as JSON unless it's nil, and
+// decodes the item of the response.
+func (c *Collection[T]) itemCall(ctx context.Context, method Method, id string, body interface{}, opts []Option) (T, error) {
+	var out T
+	req, resource, err := c.itemRequest(ctx, method, id, body)
+	if err != nil {
+		return out, err
+	}
+	err = c.api.sendJSON(ctx, req, resource, &out, opts)
+	return out, err
+}
+
+// itemRequest creates the request for the item with the id, and returns it with its resource.
+func (c *Collection[T]) itemRequest(ctx context.Context, method Method, id string, body interface{}) (*http.Request, string, error) {
+	if id == "" || id == "." || id == ".." {
+		return nil, "", ErrInvalidID
+	}
+	u, err := c.api.resourceURL(c.resource)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix := strings.TrimSuffix(u.EscapedPath(), "/")
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + id
+	u.RawPath = prefix + "/" + url.PathEscape(id)
+	req := newRequest(method, u)
+	if body != nil {
+		if err := setLazyBody(req, func(buf *bytes.Buffer) error {
+			return encodeJSON(buf, body)
+		}); err != nil {
+			return nil, "", err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	}
+	if err := c.api.shape(req, []Option{buildContext(ctx)}); err != nil {
+		return nil, "", err
+	}
+	return req, strings.TrimSuffix(c.resource, "/") + "/" + id, nil
+}
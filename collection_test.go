@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type widget struct {
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name"`
+	Stock int    `json:"stock"`
+}
+
+// widgetStore serves an in-memory collection of widgets at /widgets, listed in pages of
+// {"items": [...]} linked by Link headers.
+type widgetStore struct {
+	mu      sync.Mutex
+	widgets map[string]widget
+	next    int
+}
+
+func (s *widgetStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := strings.TrimPrefix(r.URL.EscapedPath(), "/widgets/")
+	id = strings.ReplaceAll(id, "%2F", "/")
+	reply := func(v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+	switch {
+	case r.URL.Path == "/widgets" && r.Method == http.MethodGet:
+		ids := make([]string, 0, len(s.widgets))
+		for id := range s.widgets {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		var items []widget
+		for _, id := range ids[min(page*2, len(ids)):min(page*2+2, len(ids))] {
+			items = append(items, s.widgets[id])
+		}
+		if page*2+2 < len(ids) {
+			w.Header().Set("Link", fmt.Sprintf(`</widgets?page=%d>; rel="next"`, page+1))
+		}
+		reply(map[string]interface{}{"items": items})
+	case r.URL.Path == "/widgets" && r.Method == http.MethodPost:
+		var in widget
+		json.NewDecoder(r.Body).Decode(&in)
+		s.next++
+		in.ID = fmt.Sprintf("w%d", s.next)
+		if in.Name == "slashed" {
+			in.ID = "w/" + in.ID
+		}
+		s.widgets[in.ID] = in
+		w.WriteHeader(http.StatusCreated)
+		reply(in)
+	default:
+		cur, ok := s.widgets[id]
+		if !ok {
+			http.Error(w, `{"error": "not found"}`, http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			reply(cur)
+		case http.MethodPut:
+			var in widget
+			json.NewDecoder(r.Body).Decode(&in)
+			in.ID = id
+			s.widgets[id] = in
+			reply(in)
+		case http.MethodPatch:
+			json.NewDecoder(r.Body).Decode(&cur)
+			cur.ID = id
+			s.widgets[id] = cur
+			reply(cur)
+		case http.MethodDelete:
+			delete(s.widgets, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func TestCollection(t *testing.T) {
+	srv := httptest.NewServer(&widgetStore{widgets: make(map[string]widget)})
+	defer srv.Close()
+	widgets := NewCollection[widget](MustNew(srv.URL), "/widgets")
+	widgets.ItemsPath = "items"
+	ctx := context.Background()
+
+	gear, err := widgets.Create(ctx, widget{Name: "gear", Stock: 3})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, widget{ID: "w1", Name: "gear", Stock: 3}, gear)
+	for _, name := range []string{"bolt", "nut", "slashed"} {
+		_, err := widgets.Create(ctx, widget{Name: name})
+		assert.NoError(t, err)
+	}
+
+	got, err := widgets.Get(ctx, "w1")
+	assert.NoError(t, err)
+	assert.Equal(t, gear, got)
+	// The ids are a single segment, slashes included.
+	got, err = widgets.Get(ctx, "w/w4")
+	assert.NoError(t, err)
+	assert.Equal(t, "slashed", got.Name)
+
+	got, err = widgets.Update(ctx, "w1", widget{Name: "big gear", Stock: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, widget{ID: "w1", Name: "big gear", Stock: 5}, got)
+	got, err = widgets.Patch(ctx, "w1", map[string]interface{}{"stock": 12})
+	assert.NoError(t, err)
+	assert.Equal(t, widget{ID: "w1", Name: "big gear", Stock: 12}, got)
+
+	// The list walks the pages.
+	all, err := widgets.List(ctx, nil)
+	if assert.NoError(t, err) && assert.Len(t, all, 4) {
+		assert.Equal(t, []string{"w/w4", "w1", "w2", "w3"}, []string{all[0].ID, all[1].ID, all[2].ID, all[3].ID})
+	}
+
+	assert.NoError(t, widgets.Delete(ctx, "w1"))
+	_, err = widgets.Get(ctx, "w1")
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se), "%v", err) {
+		assert.Equal(t, http.StatusNotFound, se.Code)
+	}
+	assert.Error(t, widgets.Delete(ctx, "w1"))
+	all, err = widgets.List(ctx, nil)
+	assert.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	_, err = widgets.Get(ctx, "..")
+	assert.ErrorIs(t, err, ErrInvalidID)
+	assert.ErrorIs(t, widgets.Delete(ctx, ""), ErrInvalidID)
+}
+
+func TestCollectionBareList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": "a", "name": "x"}, {"id": "b", "name": "y"}]`))
+	}))
+	defer srv.Close()
+	all, err := NewCollection[widget](MustNew(srv.URL), "/widgets").List(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []widget{{ID: "a", Name: "x"}, {ID: "b", Name: "y"}}, all)
+}
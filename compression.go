@@ -0,0 +1,275 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CompressionState is what the Api knows of the support of a host for gzip request bodies, see
+// SetRequestCompression.
+type CompressionState int
+
+const (
+	// CompressionUnknown is the state of the hosts not probed yet, or whose cooldown is over.
+	CompressionUnknown CompressionState = iota
+	// CompressionProbing is the state of the hosts whose probe is in flight.
+	CompressionProbing
+	// CompressionSupported is the state of the hosts the request bodies are compressed for.
+	CompressionSupported
+	// CompressionUnsupported is the state of the hosts that failed the probe or refused a
+	// compressed body, until the cooldown is over.
+	CompressionUnsupported
+)
+
+func (s CompressionState) String() string {
+	switch s {
+	case CompressionUnknown:
+		return "unknown"
+	case CompressionProbing:
+		return "probing"
+	case CompressionSupported:
+		return "supported"
+	case CompressionUnsupported:
+		return "unsupported"
+	default:
+		return fmt.Sprintf("CompressionState(%d)", int(s))
+	}
+}
+
+// CompressionPolicy configures SetRequestCompression.
+type CompressionPolicy struct {
+	// MinSize is the size of the smallest bodies compressed, 1KB if zero.
+	MinSize int64
+	// ProbeMethod and ProbePath are the request probing a host, OPTIONS of the URL of the request
+	// that triggered it if empty. ProbePath is a path on the host, e.g. "/capabilities".
+	ProbeMethod string
+	ProbePath   string
+	// Supported reports whether the response to the probe tells the host accepts gzip request
+	// bodies. If nil, it's a 2xx response whose Accept-Encoding header lists gzip (RFC 7694).
+	Supported func(resp *http.Response) bool
+	// RejectBody is found in the body of the 400 responses of the hosts refusing a compressed
+	// body, e.g. "unsupported content encoding". A 415 is always taken for a refusal; a 400 is
+	// only if RejectBody is set.
+	RejectBody string
+	// Cooldown is how long a host stays CompressionUnsupported before it's probed again, 10m if zero.
+	Cooldown time.Duration
+}
+
+// compression is the state of SetRequestCompression, shared with the Apis derived by ForTenant.
+type compression struct {
+	policy CompressionPolicy
+	mu     sync.Mutex
+	hosts  map[string]*hostCompression
+}
+
+type hostCompression struct {
+	state CompressionState
+	// until is the end of the cooldown of an unsupported host.
+	until time.Time
+}
+
+// SetRequestCompression makes the Api compress the request bodies of at least p.MinSize bytes
+// with gzip, for the hosts found to support it. The first eligible request to a host is sent as
+// it is, while a probe asks the host in the background; once it's confirmed, the next requests
+// are compressed. A host refusing a compressed body, with a 415 or a 400 matching p.RejectBody,
+// gets the request again uncompressed, and isn't sent compressed bodies until p.Cooldown is over
+// and it's probed again. Only the bodies that can be read again are compressed, and never those
+// already having a Content-Encoding. A nil p disables the compression and forgets the hosts.
+func (a *Api) SetRequestCompression(p *CompressionPolicy) {
+	if p == nil {
+		a.compression.Store(nil)
+		return
+	}
+	policy := *p
+	if policy.MinSize <= 0 {
+		policy.MinSize = 1 << 10
+	}
+	if policy.ProbeMethod == "" {
+		policy.ProbeMethod = http.MethodOptions
+	}
+	if policy.Supported == nil {
+		policy.Supported = acceptsGzip
+	}
+	if policy.Cooldown <= 0 {
+		policy.Cooldown = 10 * time.Minute
+	}
+	a.compression.Store(&compression{policy: policy, hosts: make(map[string]*hostCompression)})
+}
+
+// RequestCompression returns the state of the request compression for host, e.g. "api.example.com".
+func (a *Api) RequestCompression(host string) CompressionState {
+	cz := a.compression.Load()
+	if cz == nil {
+		return CompressionUnknown
+	}
+	cz.mu.Lock()
+	defer cz.mu.Unlock()
+	h := cz.hosts[host]
+	if h == nil || h.state == CompressionUnsupported && !a.clock().Now().Before(h.until) {
+		return CompressionUnknown
+	}
+	return h.state
+}
+
+// acceptsGzip reports whether resp is a 2xx listing gzip in its Accept-Encoding header.
+func acceptsGzip(resp *http.Response) bool {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false
+	}
+	for _, v := range resp.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			if name, _, _ := strings.Cut(coding, ";"); strings.EqualFold(strings.TrimSpace(name), "gzip") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compress returns a copy of req with its body compressed against the dictionary of the Api, or
+// with gzip if the host of req supports it, nil if it isn't eligible or the host isn't known to
+// support it. It starts the probe of the hosts not probed yet.
+func (a *Api) compress(req *http.Request) (*http.Request, error) {
+	if d := a.dictionary.Load(); d != nil {
+		if req.ContentLength < d.MinSize || req.GetBody == nil || req.Header.Get("Content-Encoding") != "" {
+			return nil, nil
+		}
+		return compressedCopy(req, d.Codec.Encoding(), func(w io.Writer) (io.WriteCloser, error) { return d.Codec.Compress(w, d.Data) })
+	}
+	cz := a.compression.Load()
+	if cz == nil || req.ContentLength < cz.policy.MinSize || req.GetBody == nil || req.Header.Get("Content-Encoding") != "" {
+		return nil, nil
+	}
+	host := req.URL.Host
+	now := a.clock().Now()
+	cz.mu.Lock()
+	h := cz.hosts[host]
+	if h == nil {
+		h = &hostCompression{}
+		cz.hosts[host] = h
+	}
+	if h.state == CompressionUnsupported && !now.Before(h.until) {
+		h.state = CompressionUnknown
+	}
+	state := h.state
+	if state == CompressionUnknown {
+		h.state = CompressionProbing
+	}
+	cz.mu.Unlock()
+	if state == CompressionUnknown {
+		probe := a.probeRequest(cz, req)
+		a.goBackground(context.Background(), func(ctx context.Context) {
+			cz.set(host, a.probeCompression(ctx, cz, probe), a.clock().Now())
+		})
+	}
+	if state != CompressionSupported {
+		return nil, nil
+	}
+	return compressedCopy(req, "gzip", func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil })
+}
+
+// compressedCopy returns a copy of req with its body compressed by the writer of newWriter and
+// the Content-Encoding encoding.
+func compressedCopy(req *http.Request, encoding string, newWriter func(io.Writer) (io.WriteCloser, error)) (*http.Request, error) {
+	var buf bytes.Buffer
+	zw, err := newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	data, encoded := encodedBody(req)
+	if encoded {
+		zw.Write(data)
+	} else {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(zw, body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	setBytesBody(r, buf.Bytes())
+	r.Header.Set("Content-Encoding", encoding)
+	r.Header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	return r, nil
+}
+
+// probeRequest returns the probe of the host of req, carrying its headers but those of its body.
+func (a *Api) probeRequest(cz *compression, req *http.Request) *http.Request {
+	u := *req.URL
+	if cz.policy.ProbePath != "" {
+		u.Path, u.RawPath, u.RawQuery = cz.policy.ProbePath, "", ""
+	}
+	probe := &http.Request{Method: cz.policy.ProbeMethod, URL: &u, Host: req.Host, Header: req.Header.Clone()}
+	for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+		probe.Header.Del(k)
+	}
+	return probe
+}
+
+// probeCompression sends the probe, returning the state of the host it tells.
+func (a *Api) probeCompression(ctx context.Context, cz *compression, probe *http.Request) CompressionState {
+	resp, err := a.client().Do(probe.WithContext(ctx))
+	if err != nil {
+		return CompressionUnsupported
+	}
+	defer drainClose(resp.Body)
+	if cz.policy.Supported(resp) {
+		return CompressionSupported
+	}
+	return CompressionUnsupported
+}
+
+// set sets the state of host, starting its cooldown if it's unsupported.
+func (cz *compression) set(host string, state CompressionState, now time.Time) {
+	cz.mu.Lock()
+	defer cz.mu.Unlock()
+	h := cz.hosts[host]
+	if h == nil {
+		return
+	}
+	h.state = state
+	if state == CompressionUnsupported {
+		h.until = now.Add(cz.policy.Cooldown)
+	}
+}
+
+// rejected reports whether resp refuses the compressed body of its request, in which case it's
+// closed and the host marked unsupported. The body of a 400 is read to be matched, and replaced
+// by a copy otherwise.
+func (a *Api) rejected(host string, resp *http.Response) bool {
+	cz := a.compression.Load()
+	if cz == nil || resp.Request == nil || resp.Request.Header.Get("Content-Encoding") != "gzip" {
+		return false
+	}
+	refused := resp.StatusCode == http.StatusUnsupportedMediaType
+	if resp.StatusCode == http.StatusBadRequest && cz.policy.RejectBody != "" {
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		refused = err == nil && bytes.Contains(data, []byte(cz.policy.RejectBody))
+		if !refused {
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		}
+	}
+	if refused {
+		drainClose(resp.Body)
+		cz.set(host, CompressionUnsupported, a.clock().Now())
+	}
+	return refused
+}
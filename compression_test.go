@@ -0,0 +1,174 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// compressionServer serves the probes with the Accept-Encoding of accept, and refuses the gzip
+// bodies with refusal if it's set. It records the encodings and the decoded bodies received.
+type compressionServer struct {
+	accept  string
+	refusal int
+
+	mu        sync.Mutex
+	probes    int
+	encodings []string
+	bodies    []string
+}
+
+func (s *compressionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Method == http.MethodOptions {
+		s.probes++
+		if s.accept != "" {
+			w.Header().Set("Accept-Encoding", s.accept)
+		}
+		return
+	}
+	enc := r.Header.Get("Content-Encoding")
+	s.encodings = append(s.encodings, enc)
+	if enc == "gzip" && s.refusal != 0 {
+		w.WriteHeader(s.refusal)
+		io.WriteString(w, `{"error":"unsupported content encoding"}`)
+		return
+	}
+	var body io.Reader = r.Body
+	if enc == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	data, _ := io.ReadAll(body)
+	s.bodies = append(s.bodies, string(data))
+}
+
+func (s *compressionServer) last() (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encodings[len(s.encodings)-1], s.bodies[len(s.bodies)-1]
+}
+
+// awaitProbe waits for the probe of host to be over.
+func awaitProbe(t *testing.T, a *Api, host string) CompressionState {
+	for i := 0; i < 200; i++ {
+		if s := a.RequestCompression(host); s != CompressionProbing {
+			return s
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("the probe of %s isn't over", host)
+	return CompressionProbing
+}
+
+func TestRequestCompression(t *testing.T) {
+	s := &compressionServer{accept: "gzip, br"}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+	a := MustNew(srv.URL)
+	defer a.Close()
+	a.SetRequestCompression(&CompressionPolicy{})
+	ctx := context.Background()
+	payload := map[string]string{"note": strings.Repeat("x", 2<<10)}
+	want := `{"note":"` + payload["note"] + `"}`
+
+	// The first request is sent as it is while the host is probed.
+	assert.NoError(t, a.Post(ctx, "/notes", payload, nil))
+	enc, body := s.last()
+	assert.Empty(t, enc)
+	assert.Equal(t, want, body)
+	assert.Equal(t, CompressionSupported, awaitProbe(t, a, host))
+
+	assert.NoError(t, a.Post(ctx, "/notes", payload, nil))
+	enc, body = s.last()
+	assert.Equal(t, "gzip", enc)
+	assert.Equal(t, want, body)
+
+	// The small bodies aren't compressed.
+	assert.NoError(t, a.Post(ctx, "/notes", map[string]string{"note": "x"}, nil))
+	enc, _ = s.last()
+	assert.Empty(t, enc)
+	assert.Equal(t, 1, s.probes)
+
+	// Nor those of the hosts not listing gzip.
+	s2 := &compressionServer{accept: "br"}
+	srv2 := httptest.NewServer(s2)
+	defer srv2.Close()
+	b := MustNew(srv2.URL)
+	defer b.Close()
+	b.SetRequestCompression(&CompressionPolicy{})
+	assert.NoError(t, b.Post(ctx, "/notes", payload, nil))
+	assert.Equal(t, CompressionUnsupported, awaitProbe(t, b, srv2.Listener.Addr().String()))
+	assert.NoError(t, b.Post(ctx, "/notes", payload, nil))
+	enc, _ = s2.last()
+	assert.Empty(t, enc)
+}
+
+func TestRequestCompressionRefused(t *testing.T) {
+	for _, refusal := range []int{http.StatusUnsupportedMediaType, http.StatusBadRequest} {
+		// The probe is wrong: the host claims gzip but refuses it.
+		s := &compressionServer{accept: "gzip", refusal: refusal}
+		srv := httptest.NewServer(s)
+		host := srv.Listener.Addr().String()
+		a := MustNew(srv.URL)
+		clk := fakeClock()
+		a.SetClock(clk)
+		a.SetRequestCompression(&CompressionPolicy{RejectBody: "unsupported content encoding", Cooldown: time.Hour})
+		ctx := context.Background()
+		payload := map[string]string{"note": strings.Repeat("x", 2<<10)}
+
+		assert.NoError(t, a.Post(ctx, "/notes", payload, nil))
+		assert.Equal(t, CompressionSupported, awaitProbe(t, a, host))
+		// The refused request is sent again uncompressed.
+		assert.NoError(t, a.Post(ctx, "/notes", payload, nil), "%d", refusal)
+		assert.Equal(t, []string{"", "gzip", ""}, s.encodings)
+		assert.Equal(t, CompressionUnsupported, a.RequestCompression(host))
+		assert.NoError(t, a.Post(ctx, "/notes", payload, nil))
+		assert.Equal(t, 4, len(s.encodings))
+		assert.Equal(t, 1, s.probes)
+
+		// The host is probed again after the cooldown.
+		clk.Advance(time.Hour)
+		assert.Equal(t, CompressionUnknown, a.RequestCompression(host))
+		assert.NoError(t, a.Post(ctx, "/notes", payload, nil))
+		assert.Equal(t, CompressionSupported, awaitProbe(t, a, host))
+		assert.Equal(t, 2, s.probes)
+		a.Close()
+		srv.Close()
+	}
+}
+
+func TestRequestCompressionBadRequest(t *testing.T) {
+	// A 400 not matching RejectBody is the response of the call.
+	s := &compressionServer{accept: "gzip", refusal: http.StatusBadRequest}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	defer a.Close()
+	a.SetRequestCompression(&CompressionPolicy{RejectBody: "gzip is not supported"})
+	ctx := context.Background()
+	payload := map[string]string{"note": strings.Repeat("x", 2<<10)}
+	assert.NoError(t, a.Post(ctx, "/notes", payload, nil))
+	awaitProbe(t, a, srv.Listener.Addr().String())
+	err := a.Post(ctx, "/notes", payload, nil)
+	var se *StatusError
+	if assert.ErrorAs(t, err, &se) {
+		assert.Equal(t, http.StatusBadRequest, se.Code)
+		assert.Contains(t, string(se.Body), "unsupported content encoding")
+	}
+	assert.Equal(t, CompressionSupported, a.RequestCompression(srv.Listener.Addr().String()))
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveConcurrency makes the MaxInFlight of a ShedPolicy the starting point of an in-flight
+// limit adjusted by an AIMD controller from the latencies the calls observe, from sending the
+// request until the response headers arrived. At the end of every Interval with calls over, the
+// limit is cut by Backoff if a call was answered with a 429 or a 503 or failed with a transport
+// error, or if the average latency of the interval rose over Tolerance times the baseline;
+// otherwise it grows by one if the calls in flight reached half of it. The baseline is the lowest
+// average latency of the intervals, drifting toward the healthy ones so it follows the latency
+// of the service. The decisions are made on the clock of the Api, so they're deterministic for
+// the same calls and clock.
+type AdaptiveConcurrency struct {
+	// Min and Max bound the limit, 1 and 1000 if zero.
+	Min int
+	Max int
+	// Interval is the period of the decisions, a second if zero.
+	Interval time.Duration
+	// Tolerance is the factor of the baseline over which the latency is degraded, 2 if zero.
+	Tolerance float64
+	// Backoff is the factor the limit is cut by, 0.5 if zero.
+	Backoff float64
+	// History is the number of the last decisions kept for ConcurrencyStats, 32 if zero.
+	History int
+}
+
+// ConcurrencyDecision is a decision of the AdaptiveConcurrency controller.
+type ConcurrencyDecision struct {
+	Time time.Time
+	// Limit is the limit decided.
+	Limit int
+	// Latency is the average latency of the interval, Baseline the baseline it was compared to.
+	Latency  time.Duration
+	Baseline time.Duration
+	// Reason is what the limit did, "increase", "hold", "latency" or "overload".
+	Reason string
+}
+
+// ConcurrencyStats is the state of the AdaptiveConcurrency of an Api, see ConcurrencyStats.
+type ConcurrencyStats struct {
+	Limit    int
+	InFlight int
+	Baseline time.Duration
+	// Decisions are the last decisions, the oldest first.
+	Decisions []ConcurrencyDecision
+}
+
+// ConcurrencyStats returns the state of the AdaptiveConcurrency of the ShedPolicy of the Api,
+// the zero value without one.
+func (a *Api) ConcurrencyStats() ConcurrencyStats {
+	s := a.shed.Load()
+	if s == nil || s.aimd == nil {
+		return ConcurrencyStats{}
+	}
+	stats := s.aimd.stats()
+	stats.InFlight = int(s.inFlight.Load())
+	return stats
+}
+
+// aimd is the controller of an AdaptiveConcurrency.
+type aimd struct {
+	policy AdaptiveConcurrency
+	limit  atomic.Int64
+	// peak is the most calls in flight during the interval.
+	peak atomic.Int64
+
+	mu        sync.Mutex
+	start     time.Time
+	calls     int64
+	total     time.Duration
+	overloads int64
+	baseline  time.Duration
+	decisions []ConcurrencyDecision
+	next      int
+}
+
+func newAIMD(p AdaptiveConcurrency, initial int) *aimd {
+	if p.Min <= 0 {
+		p.Min = 1
+	}
+	if p.Max <= 0 {
+		p.Max = 1000
+	}
+	p.Max = max(p.Max, p.Min)
+	if p.Interval <= 0 {
+		p.Interval = time.Second
+	}
+	if p.Tolerance <= 1 {
+		p.Tolerance = 2
+	}
+	if p.Backoff <= 0 || p.Backoff >= 1 {
+		p.Backoff = 0.5
+	}
+	if p.History <= 0 {
+		p.History = 32
+	}
+	c := &aimd{policy: p, decisions: make([]ConcurrencyDecision, 0, p.History)}
+	c.limit.Store(int64(min(max(initial, p.Min), p.Max)))
+	return c
+}
+
+// entered records n calls in flight.
+func (c *aimd) entered(n int64) {
+	for {
+		peak := c.peak.Load()
+		if n <= peak || c.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+// observe records a call over after latency, with the status of its response, 0 for a transport
+// error, deciding the limit at the end of the interval with inFlight calls in flight. It reports
+// whether the limit grew.
+func (c *aimd) observe(latency time.Duration, status int, now time.Time, inFlight int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.start.IsZero() {
+		c.start = now
+	}
+	c.calls++
+	c.total += latency
+	if status == 0 || status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		c.overloads++
+	}
+	if now.Sub(c.start) < c.policy.Interval {
+		return false
+	}
+	return c.decide(now, inFlight)
+}
+
+// decide decides the limit from the calls of the interval, and starts the next one.
+func (c *aimd) decide(now time.Time, inFlight int64) bool {
+	p := &c.policy
+	prev := int(c.limit.Load())
+	limit := prev
+	avg := c.total / time.Duration(c.calls)
+	if c.baseline == 0 {
+		c.baseline = avg
+	}
+	d := ConcurrencyDecision{Time: now, Latency: avg, Baseline: c.baseline, Reason: "hold"}
+	degraded := float64(avg) > float64(c.baseline)*p.Tolerance
+	switch {
+	case c.overloads > 0:
+		d.Reason = "overload"
+	case degraded:
+		d.Reason = "latency"
+	case c.peak.Load()*2 >= int64(limit):
+		d.Reason = "increase"
+	}
+	switch d.Reason {
+	case "overload", "latency":
+		limit = max(int(float64(limit)*p.Backoff), p.Min)
+	case "increase":
+		limit = min(limit+1, p.Max)
+	}
+	// The baseline drops to the lower latencies at once and rises by an eighth of the way; a
+	// latency degraded at the floor isn't caused by the calls, so it's followed too.
+	if c.overloads == 0 && (!degraded || prev == p.Min) {
+		c.baseline = min(avg, c.baseline+(avg-c.baseline)/8)
+	}
+	d.Limit = limit
+	c.limit.Store(int64(limit))
+	c.record(d)
+	c.start, c.calls, c.total, c.overloads = now, 0, 0, 0
+	c.peak.Store(inFlight)
+	return limit > prev
+}
+
+func (c *aimd) record(d ConcurrencyDecision) {
+	if len(c.decisions) < cap(c.decisions) {
+		c.decisions = append(c.decisions, d)
+		return
+	}
+	c.decisions[c.next] = d
+	c.next = (c.next + 1) % len(c.decisions)
+}
+
+func (c *aimd) stats() ConcurrencyStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ds := make([]ConcurrencyDecision, 0, len(c.decisions))
+	ds = append(ds, c.decisions[c.next:]...)
+	ds = append(ds, c.decisions[:c.next]...)
+	return ConcurrencyStats{Limit: int(c.limit.Load()), Baseline: c.baseline, Decisions: ds}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestAIMD(t *testing.T) {
+	c := newAIMD(AdaptiveConcurrency{Min: 2, Max: 24, Interval: time.Second, History: 4}, 10)
+	rng := rand.New(rand.NewSource(1))
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.start = now
+	// interval runs the calls of an interval saturating the limit, around latency ±10%, and
+	// returns the limit decided.
+	interval := func(latency time.Duration, status int) int {
+		for i := 0; i < 10; i++ {
+			now = now.Add(100 * time.Millisecond)
+			limit := c.limit.Load()
+			c.entered(limit)
+			jitter := time.Duration(rng.Int63n(int64(latency/5))) - latency/10
+			c.observe(latency+jitter, status, now, limit)
+		}
+		return int(c.limit.Load())
+	}
+	var limits []int
+	phase := func(n int, latency time.Duration, status int) {
+		for i := 0; i < n; i++ {
+			limits = append(limits, interval(latency, status))
+		}
+	}
+
+	// The limit rises while the latency stays near the baseline, up to the ceiling.
+	phase(16, 100*time.Millisecond, http.StatusOK)
+	assert.Equal(t, []int{11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 24, 24}, limits)
+	baseline := c.stats().Baseline
+	assert.InDelta(t, float64(100*time.Millisecond), float64(baseline), float64(10*time.Millisecond))
+
+	// It collapses under the 503s and the degraded latency, down to the floor.
+	limits = nil
+	phase(2, 100*time.Millisecond, http.StatusServiceUnavailable)
+	phase(3, 400*time.Millisecond, http.StatusOK)
+	assert.Equal(t, []int{12, 6, 3, 2, 2}, limits)
+	// The latencies of the overload don't move the baseline away from the floor of the latency.
+	assert.Less(t, c.stats().Baseline, 2*baseline+100*time.Millisecond)
+
+	// And recovers once the service does.
+	limits = nil
+	phase(4, 100*time.Millisecond, http.StatusOK)
+	assert.Equal(t, []int{3, 4, 5, 6}, limits)
+
+	stats := c.stats()
+	assert.Equal(t, 6, stats.Limit)
+	if assert.Len(t, stats.Decisions, 4) {
+		assert.Equal(t, "increase", stats.Decisions[3].Reason)
+		assert.Equal(t, 6, stats.Decisions[3].Limit)
+		assert.Equal(t, now, stats.Decisions[3].Time)
+		assert.Equal(t, 3, stats.Decisions[0].Limit)
+	}
+
+	// Without the calls reaching half of the limit, it holds, those in flight when the interval
+	// started included.
+	for _, want := range []int{7, 7} {
+		for i := 0; i < 10; i++ {
+			now = now.Add(100 * time.Millisecond)
+			c.observe(100*time.Millisecond, http.StatusOK, now, 1)
+		}
+		assert.Equal(t, want, int(c.limit.Load()))
+	}
+	assert.Equal(t, "hold", c.stats().Decisions[3].Reason)
+}
+
+func TestShedAdaptive(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-block
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a.SetClock(clk)
+	assert.Zero(t, a.ConcurrencyStats())
+	a.SetShedding(&ShedPolicy{MaxInFlight: 2, Adaptive: &AdaptiveConcurrency{Max: 3}})
+	ctx := context.Background()
+
+	assert.Equal(t, 2, a.ConcurrencyStats().Limit)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Get(ctx, "/items", nil, nil))
+		clk.Advance(time.Second)
+	}
+	status.Store(http.StatusServiceUnavailable)
+	assert.Error(t, a.Get(ctx, "/items", nil, nil))
+	clk.Advance(time.Second)
+	assert.Error(t, a.Get(ctx, "/items", nil, nil))
+	stats := a.ConcurrencyStats()
+	assert.Equal(t, 1, stats.Limit)
+	var reasons []string
+	for _, d := range stats.Decisions {
+		reasons = append(reasons, d.Reason)
+	}
+	assert.Equal(t, []string{"increase", "hold", "overload", "overload"}, reasons)
+
+	// The adapted limit is the one the calls are admitted by.
+	status.Store(http.StatusOK)
+	done := make(chan error)
+	go func() { done <- a.Get(ctx, "/slow", nil, nil) }()
+	assert.Eventually(t, func() bool { return a.ConcurrencyStats().InFlight == 1 }, time.Second, time.Millisecond)
+	err := a.Get(ctx, "/items", nil, nil, WithPriority(Low))
+	assert.EqualError(t, err, "api: low priority call shedded: in-flight threshold crossed")
+	close(block)
+	assert.NoError(t, <-done)
+}
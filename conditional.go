@@ -0,0 +1,287 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrConditionFailed is matched by every *ConditionError.
+var ErrConditionFailed = errors.New("api: condition failed")
+
+// ConditionStyle is how the preconditions of ExpectVersion and ExpectField are expressed, see
+// SetConditionalWrites.
+type ConditionStyle int
+
+const (
+	// ConditionHeader sends the version of ExpectVersion as a strong ETag in If-Match, e.g.
+	// If-Match: "7". ExpectField can't be expressed in a header and fails the call.
+	ConditionHeader ConditionStyle = iota
+	// ConditionPatchTest prepends a JSON Patch "test" operation per precondition to the JSON
+	// Patch array of the body, e.g. {"op": "test", "path": "/version", "value": 7}.
+	ConditionPatchTest
+	// ConditionBodyField sets the version in a field of the JSON object of the body, e.g.
+	// {"expected_version": 7, ...}, and the fields in an object, e.g. {"expected": {"/status": "draft"}, ...}.
+	ConditionBodyField
+)
+
+// ConditionPolicy configures the conditional writes, see SetConditionalWrites.
+type ConditionPolicy struct {
+	Style ConditionStyle
+	// VersionHeader is the header of the version in the ConditionHeader style, If-Match if empty.
+	VersionHeader string
+	// VersionPath is the JSON Pointer tested against the version in the ConditionPatchTest style,
+	// "/version" if empty.
+	VersionPath string
+	// VersionField is the field of the version in the ConditionBodyField style, "expected_version"
+	// if empty, and FieldsField the one of the object of the fields, "expected" if empty.
+	VersionField string
+	FieldsField  string
+	// Statuses are the status codes of the failed preconditions, 412 and 409 if nil.
+	Statuses []int
+	// Codes are the vendor error codes of the failed preconditions, found at the path set by
+	// SetErrorCodePath, whatever the status code.
+	Codes []string
+	// CurrentPath is the dotted path to the current version in the body of a failed precondition,
+	// e.g. "error.current_version". If empty, or if the body has none, the ETag of the response is
+	// taken.
+	CurrentPath string
+}
+
+// ConditionError is returned for the calls made with ExpectVersion or ExpectField whose
+// precondition failed, whatever the way the server reported it. It's matched by
+// ErrConditionFailed, and by the *StatusError of the response with errors.As.
+type ConditionError struct {
+	// Current is the current version of the resource reported by the server, unquoted, e.g. "8",
+	// empty if it didn't report it.
+	Current string
+	Err     *StatusError
+}
+
+func (e *ConditionError) Error() string {
+	if e.Current != "" {
+		return fmt.Sprintf("api: condition failed (status %d, current version %s)", e.Err.Code, e.Current)
+	}
+	return fmt.Sprintf("api: condition failed (status %d)", e.Err.Code)
+}
+
+// Is makes errors.Is(err, ErrConditionFailed) report true.
+func (e *ConditionError) Is(target error) bool {
+	return target == ErrConditionFailed
+}
+
+// Unwrap returns the *StatusError of the response.
+func (e *ConditionError) Unwrap() error { return e.Err }
+
+// Class makes the error a Permanent failure, since retrying can't satisfy the precondition.
+func (e *ConditionError) Class() Class { return Permanent }
+
+// condition is a precondition of ExpectVersion, or of ExpectField if path is set.
+type condition struct {
+	path  string
+	value interface{}
+}
+
+// ExpectVersion makes the write conditional on the resource being at version n, expressed in the
+// style of the Api's ConditionPolicy, If-Match by default. A failed precondition fails the call
+// with a *ConditionError.
+func ExpectVersion(n int64) Option {
+	return func(c *call) {
+		c.conditions = append(c.conditions, condition{value: n})
+	}
+}
+
+// ExpectField makes the write conditional on the field at the JSON Pointer path, e.g. "/status",
+// having the value, like ExpectVersion. It needs the ConditionPatchTest or the ConditionBodyField
+// style.
+func ExpectField(path string, value interface{}) Option {
+	return func(c *call) {
+		if path == "" {
+			c.fail(errors.New("api: ExpectField needs a path"))
+			return
+		}
+		c.conditions = append(c.conditions, condition{path: path, value: value})
+	}
+}
+
+// SetConditionalWrites sets how the preconditions of ExpectVersion and ExpectField are expressed
+// and how their failures are recognized. A nil p restores the defaults of ConditionPolicy.
+func (a *Api) SetConditionalWrites(p *ConditionPolicy) {
+	if p == nil {
+		a.conditions.Store(nil)
+		return
+	}
+	policy := *p
+	a.conditions.Store(&policy)
+}
+
+func (a *Api) conditionPolicy() *ConditionPolicy {
+	if p := a.conditions.Load(); p != nil {
+		return p
+	}
+	return &ConditionPolicy{}
+}
+
+// applyConditions expresses the preconditions of c in req. Since it runs for every attempt, it
+// leaves a request already carrying them as it is.
+func (a *Api) applyConditions(c *call, req *http.Request) error {
+	p := a.conditionPolicy()
+	switch p.Style {
+	case ConditionPatchTest:
+		var ops []json.RawMessage
+		if err := conditionBody(req, &ops); err != nil {
+			return err
+		}
+		tests := make([]json.RawMessage, len(c.conditions))
+		for i, cond := range c.conditions {
+			path := cond.path
+			if path == "" {
+				path = p.VersionPath
+				if path == "" {
+					path = "/version"
+				}
+			}
+			op, err := json.Marshal(map[string]interface{}{"op": "test", "path": path, "value": cond.value})
+			if err != nil {
+				return err
+			}
+			tests[i] = op
+		}
+		if prepended(ops, tests) {
+			return nil
+		}
+		return setConditionBody(req, append(tests, ops...))
+	case ConditionBodyField:
+		var obj map[string]json.RawMessage
+		if err := conditionBody(req, &obj); err != nil {
+			return err
+		}
+		if obj == nil {
+			obj = make(map[string]json.RawMessage)
+		}
+		fields := make(map[string]interface{})
+		for _, cond := range c.conditions {
+			if cond.path != "" {
+				fields[cond.path] = cond.value
+				continue
+			}
+			name := p.VersionField
+			if name == "" {
+				name = "expected_version"
+			}
+			v, _ := json.Marshal(cond.value)
+			obj[name] = v
+		}
+		if len(fields) > 0 {
+			name := p.FieldsField
+			if name == "" {
+				name = "expected"
+			}
+			v, err := json.Marshal(fields)
+			if err != nil {
+				return err
+			}
+			obj[name] = v
+		}
+		return setConditionBody(req, obj)
+	default:
+		for _, cond := range c.conditions {
+			if cond.path != "" {
+				return errors.New("api: ExpectField needs the ConditionPatchTest or ConditionBodyField style")
+			}
+			name := p.VersionHeader
+			if name == "" {
+				name = "If-Match"
+			}
+			req.Header.Set(name, `"`+strconv.FormatInt(cond.value.(int64), 10)+`"`)
+		}
+		return nil
+	}
+}
+
+// conditionBody decodes the JSON body of req into v.
+func conditionBody(req *http.Request, v interface{}) error {
+	data, ok := encodedBody(req)
+	if !ok && req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return errors.New("api: a conditional write needs a replayable body")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		data, err = io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("api: conditional write: %w", err)
+	}
+	return nil
+}
+
+// setConditionBody sets the body of req to the JSON encoding of v.
+func setConditionBody(req *http.Request, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	setBytesBody(req, data)
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if req.Header.Get("Content-Length") != "" {
+		req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	}
+	return nil
+}
+
+// prepended reports whether ops starts with the tests.
+func prepended(ops, tests []json.RawMessage) bool {
+	if len(ops) < len(tests) {
+		return false
+	}
+	for i, test := range tests {
+		var buf bytes.Buffer
+		if json.Compact(&buf, ops[i]) != nil || !bytes.Equal(buf.Bytes(), test) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionError returns the *ConditionError of se if it reports a failed precondition of c.
+func (a *Api) conditionError(c *call, se *StatusError) error {
+	p := a.conditionPolicy()
+	failed := false
+	if p.Statuses == nil {
+		failed = se.Code == http.StatusPreconditionFailed || se.Code == http.StatusConflict
+	}
+	for _, code := range p.Statuses {
+		failed = failed || se.Code == code
+	}
+	failed = failed || se.ErrorCode != "" && containsString(p.Codes, se.ErrorCode)
+	if !failed {
+		return nil
+	}
+	ce := &ConditionError{Err: se}
+	if p.CurrentPath != "" {
+		if v, err := JSONLookup(se.Body, p.CurrentPath); err == nil && v.Kind() != JSONNull {
+			ce.Current = v.String()
+		}
+	}
+	if ce.Current == "" {
+		ce.Current = strings.Trim(strings.TrimPrefix(se.Header.Get("ETag"), "W/"), `"`)
+	}
+	return ce
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConditionHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Match") != `"3"` {
+			w.Header().Set("ETag", `"4"`)
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	assert.NoError(t, a.Put(ctx, "/docs/1", map[string]string{"title": "a"}, nil, ExpectVersion(3)))
+	err := a.Put(ctx, "/docs/1", map[string]string{"title": "a"}, nil, ExpectVersion(2))
+	var ce *ConditionError
+	if assert.True(t, errors.As(err, &ce), "%v", err) {
+		assert.Equal(t, "4", ce.Current)
+		assert.Equal(t, http.StatusPreconditionFailed, ce.Err.Code)
+	}
+	assert.True(t, errors.Is(err, ErrConditionFailed))
+	assert.False(t, IsRetryable(err))
+	var se *StatusError
+	assert.True(t, errors.As(err, &se))
+
+	err = a.Put(ctx, "/docs/1", nil, nil, ExpectField("/status", "draft"))
+	assert.Error(t, err)
+	// A 412 of a call without a precondition stays a plain *StatusError.
+	err = a.Put(ctx, "/docs/1", nil, nil)
+	assert.False(t, errors.Is(err, ErrConditionFailed))
+	assert.True(t, errors.As(err, &se))
+}
+
+func TestConditionPatchTest(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var ops []struct {
+			Op    string
+			Path  string
+			Value interface{}
+		}
+		json.Unmarshal(body, &ops)
+		for _, op := range ops {
+			if op.Op == "test" && (op.Path == "/rev" && op.Value != 3.0 || op.Path == "/status" && op.Value != "draft") {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(`{"current_version": 4}`))
+				return
+			}
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Retry = &RetryPolicy{MaxRetries: 1, MinBackoff: 1}
+	a.SetConditionalWrites(&ConditionPolicy{Style: ConditionPatchTest, VersionPath: "/rev", CurrentPath: "current_version"})
+	ctx := context.Background()
+	patch := []map[string]string{{"op": "replace", "path": "/status", "value": "published"}}
+
+	assert.NoError(t, a.Patch(ctx, "/docs/1", patch, nil, ExpectVersion(3), ExpectField("/status", "draft")))
+	// The tests are prepended once, whatever the attempt.
+	want := `[{"op":"test","path":"/rev","value":3},{"op":"test","path":"/status","value":"draft"},{"op":"replace","path":"/status","value":"published"}]`
+	assert.Equal(t, []string{want, want}, bodies)
+
+	err := a.Patch(ctx, "/docs/1", patch, nil, ExpectVersion(2))
+	var ce *ConditionError
+	if assert.True(t, errors.As(err, &ce), "%v", err) {
+		assert.Equal(t, "4", ce.Current)
+		assert.Equal(t, http.StatusConflict, ce.Err.Code)
+	}
+	assert.Len(t, bodies, 3)
+
+	// The body must be a JSON Patch.
+	assert.Error(t, a.Patch(ctx, "/docs/1", map[string]string{"status": "published"}, nil, ExpectVersion(3)))
+}
+
+func TestConditionBodyField(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		if got["expected_version"] != 3.0 {
+			// The vendor reports the failure with its own error code.
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": "VERSION_MISMATCH", "current": "4"}}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetErrorCodePath("error.code", false)
+	a.SetConditionalWrites(&ConditionPolicy{Style: ConditionBodyField, Codes: []string{"VERSION_MISMATCH"}, CurrentPath: "error.current"})
+	ctx := context.Background()
+
+	assert.NoError(t, a.Put(ctx, "/docs/1", map[string]string{"title": "a"}, nil, ExpectVersion(3), ExpectField("/status", "draft")))
+	assert.Equal(t, map[string]interface{}{"title": "a", "expected_version": 3.0, "expected": map[string]interface{}{"/status": "draft"}}, got)
+
+	err := a.Put(ctx, "/docs/1", map[string]string{"title": "a"}, nil, ExpectVersion(2))
+	var ce *ConditionError
+	if assert.True(t, errors.As(err, &ce), "%v", err) {
+		assert.Equal(t, "4", ce.Current)
+		assert.Equal(t, "VERSION_MISMATCH", ce.Err.ErrorCode)
+	}
+	assert.True(t, errors.Is(err, ErrConditionFailed))
+
+	// Without a body, the fields make one.
+	assert.NoError(t, a.Post(ctx, "/docs/1/publish", nil, nil, ExpectVersion(3)))
+	assert.Equal(t, map[string]interface{}{"expected_version": 3.0}, got)
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsistencyScope is what the tokens of SetConsistency are tracked for.
+type ConsistencyScope int
+
+const (
+	// ConsistencyGlobal tracks a single token for all the calls of the Api.
+	ConsistencyGlobal ConsistencyScope = iota
+	// ConsistencyByPrefix tracks a token per resource prefix, the longest of
+	// ConsistencyPolicy.Prefixes the resource starts with, or else its first segment, e.g.
+	// "/orders" for "/orders/7/items".
+	ConsistencyByPrefix
+	// ConsistencyBySession tracks a token per session key of the context, see
+	// ConsistencySession. The calls made without one aren't tracked.
+	ConsistencyBySession
+)
+
+// ConsistencyPolicy configures SetConsistency.
+type ConsistencyPolicy struct {
+	// Headers are the response headers carrying the token after a write, the first one present
+	// being taken, X-Consistency-Token if nil.
+	Headers []string
+	// RequestHeader is the header the token is sent in, the first of Headers if empty.
+	RequestHeader string
+	Scope         ConsistencyScope
+	// Prefixes are the resource prefixes of the ConsistencyByPrefix scope, e.g. "/orders".
+	Prefixes []string
+	// TTL is how long a token is sent after the write it came from, 1m if zero.
+	TTL time.Duration
+	// Newer reports whether the token a is newer than b, e.g. for tokens that are counters, so
+	// that a write completing after a later one doesn't replace its token. If nil, the token
+	// received last is kept.
+	Newer func(a, b string) bool
+}
+
+// consistency is the state of SetConsistency.
+type consistency struct {
+	policy ConsistencyPolicy
+	mu     sync.Mutex
+	tokens map[string]consistencyToken
+}
+
+type consistencyToken struct {
+	value string
+	until time.Time
+}
+
+type consistencySessionKey struct{}
+
+// ConsistencySession returns a copy of ctx carrying the session key of the
+// ConsistencyBySession scope, e.g. the id of the user whose requests must read their own writes.
+func ConsistencySession(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, consistencySessionKey{}, key)
+}
+
+// SetConsistency makes the Api track the consistency tokens of an eventually-consistent API for
+// read-your-writes: the token a response to a write (a call of another method than GET, HEAD,
+// OPTIONS and TRACE) carries in one of p.Headers is stored for the scope of the call, and the
+// reads in that scope send it in p.RequestHeader for p.TTL, so the server answers them with data
+// at least as fresh as the write. The token is taken from the 2xx responses only. A nil p
+// disables it and forgets the tokens. See PinConsistencyToken for handing a token to another
+// process.
+func (a *Api) SetConsistency(p *ConsistencyPolicy) {
+	if p == nil {
+		a.consistency.Store(nil)
+		return
+	}
+	policy := *p
+	if len(policy.Headers) == 0 {
+		policy.Headers = []string{"X-Consistency-Token"}
+	}
+	if policy.RequestHeader == "" {
+		policy.RequestHeader = policy.Headers[0]
+	}
+	if policy.TTL <= 0 {
+		policy.TTL = time.Minute
+	}
+	a.consistency.Store(&consistency{policy: policy, tokens: make(map[string]consistencyToken)})
+}
+
+// ConsistencyToken returns the token sent with the reads of resource made with ctx, empty if
+// there's none or it expired.
+func (a *Api) ConsistencyToken(ctx context.Context, resource string) string {
+	cs := a.consistency.Load()
+	if cs == nil {
+		return ""
+	}
+	scope, ok := cs.scope(ctx, resource)
+	if !ok {
+		return ""
+	}
+	return cs.token(scope, a.clock().Now())
+}
+
+// PinConsistencyToken sets the token of the scope of resource and ctx, as if a write had returned
+// it, e.g. one handed over by the process that made the write. Unlike the tokens of the
+// responses, it replaces the current one whatever ConsistencyPolicy.Newer says. It does nothing
+// if SetConsistency wasn't called.
+func (a *Api) PinConsistencyToken(ctx context.Context, resource, token string) {
+	cs := a.consistency.Load()
+	if cs == nil {
+		return
+	}
+	if scope, ok := cs.scope(ctx, resource); ok {
+		cs.store(scope, token, a.clock().Now(), true)
+	}
+}
+
+// fork returns a consistency of the same policy without the tokens, nil for a nil cs.
+func (cs *consistency) fork() *consistency {
+	if cs == nil {
+		return nil
+	}
+	return &consistency{policy: cs.policy, tokens: make(map[string]consistencyToken)}
+}
+
+// scope returns the key the token of resource is tracked under, false if it isn't tracked.
+func (cs *consistency) scope(ctx context.Context, resource string) (string, bool) {
+	switch cs.policy.Scope {
+	case ConsistencyByPrefix:
+		best := ""
+		for _, p := range cs.policy.Prefixes {
+			if strings.HasPrefix(resource, p) && len(p) > len(best) {
+				best = p
+			}
+		}
+		if best != "" {
+			return best, true
+		}
+		rest := strings.TrimPrefix(resource, "/")
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			rest = rest[:i]
+		}
+		return "/" + rest, true
+	case ConsistencyBySession:
+		key, ok := ctx.Value(consistencySessionKey{}).(string)
+		return key, ok
+	default:
+		return "", true
+	}
+}
+
+func (cs *consistency) token(scope string, now time.Time) string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	t, ok := cs.tokens[scope]
+	if !ok || !now.Before(t.until) {
+		return ""
+	}
+	return t.value
+}
+
+// store sets the token of scope, unless the current one is newer and force is false.
+func (cs *consistency) store(scope, token string, now time.Time, force bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cur, ok := cs.tokens[scope]; ok && !force && cs.policy.Newer != nil && now.Before(cur.until) && !cs.policy.Newer(token, cur.value) {
+		return
+	}
+	cs.tokens[scope] = consistencyToken{value: token, until: now.Add(cs.policy.TTL)}
+}
+
+// readMethod reports whether method doesn't change the resources.
+func readMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// applyConsistency sends the token of the scope of the read req, unless it has one already.
+func (a *Api) applyConsistency(ctx context.Context, req *http.Request, resource string) {
+	cs := a.consistency.Load()
+	if cs == nil || !readMethod(req.Method) || req.Header.Get(cs.policy.RequestHeader) != "" {
+		return
+	}
+	if token := a.ConsistencyToken(ctx, resource); token != "" {
+		req.Header.Set(cs.policy.RequestHeader, token)
+	}
+}
+
+// captureConsistency stores the token of the response to the write req.
+func (a *Api) captureConsistency(ctx context.Context, req *http.Request, resource string, resp *http.Response) {
+	cs := a.consistency.Load()
+	if cs == nil || readMethod(req.Method) || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return
+	}
+	for _, h := range cs.policy.Headers {
+		if token := resp.Header.Get(h); token != "" {
+			if scope, ok := cs.scope(ctx, resource); ok {
+				cs.store(scope, token, a.clock().Now(), false)
+			}
+			return
+		}
+	}
+}
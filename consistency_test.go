@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// consistentServer is an eventually-consistent store of values by path: a write returns the
+// token of its version, and a read only returns the latest value if it's sent a token at least
+// as recent, the value before the write otherwise.
+type consistentServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	version int
+	values  map[string][]string
+	tokens  []string
+}
+
+func newConsistentServer() *consistentServer {
+	s := &consistentServer{values: map[string][]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Method != http.MethodGet {
+			s.version++
+			s.values[r.URL.Path] = append(s.values[r.URL.Path], r.URL.Query().Get("v"))
+			w.Header().Set("X-Consistency-Token", strconv.Itoa(s.version))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		token := r.Header.Get("X-Consistency-Token")
+		s.tokens = append(s.tokens, token)
+		values := s.values[r.URL.Path]
+		if n, _ := strconv.Atoi(token); n < s.version && len(values) > 0 {
+			values = values[:len(values)-1]
+		}
+		if len(values) == 0 {
+			w.Write([]byte(`""`))
+			return
+		}
+		w.Write([]byte(strconv.Quote(values[len(values)-1])))
+	}))
+	return s
+}
+
+func (s *consistentServer) write(t *testing.T, ctx context.Context, a *Api, resource, v string) {
+	req, _ := a.Request(PUT, resource, url.Values{"v": {v}})
+	resp, err := a.Do(ctx, req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
+
+func read(ctx context.Context, a *Api, resource string) string {
+	var v string
+	a.DoJSON(ctx, GET, resource, nil, &v)
+	return v
+}
+
+func TestConsistency(t *testing.T) {
+	s := newConsistentServer()
+	defer s.Close()
+	ctx := context.Background()
+	clk := fakeClock()
+	a := MustNew(s.URL)
+	a.SetClock(clk)
+
+	// Without the tokens, the reads are stale.
+	s.write(t, ctx, a, "/orders/1", "a")
+	s.write(t, ctx, a, "/orders/1", "b")
+	assert.Equal(t, "a", read(ctx, a, "/orders/1"))
+
+	a.SetConsistency(&ConsistencyPolicy{TTL: time.Minute})
+	s.write(t, ctx, a, "/orders/1", "c")
+	assert.Equal(t, "3", a.ConsistencyToken(ctx, "/orders/1"))
+	assert.Equal(t, "c", read(ctx, a, "/orders/1"))
+	assert.Equal(t, "c", read(ctx, a, "/orders/1"))
+
+	// The token is dropped after the TTL.
+	clk.Advance(time.Minute)
+	assert.Equal(t, "", a.ConsistencyToken(ctx, "/orders/1"))
+	read(ctx, a, "/orders/1")
+	assert.Equal(t, []string{"", "3", "3", ""}, s.tokens)
+
+	// A token can be pinned, e.g. by another process.
+	b := MustNew(s.URL)
+	b.SetConsistency(&ConsistencyPolicy{})
+	s.write(t, ctx, a, "/orders/1", "d")
+	assert.Equal(t, "c", read(ctx, b, "/orders/1"))
+	b.PinConsistencyToken(ctx, "/orders/1", "4")
+	assert.Equal(t, "d", read(ctx, b, "/orders/1"))
+}
+
+func TestConsistencyScopes(t *testing.T) {
+	s := newConsistentServer()
+	defer s.Close()
+	ctx := context.Background()
+	a := MustNew(s.URL)
+	a.SetConsistency(&ConsistencyPolicy{Scope: ConsistencyByPrefix, Prefixes: []string{"/orders/archive"}})
+	s.write(t, ctx, a, "/orders/1", "a")
+	s.write(t, ctx, a, "/users/1", "b")
+	s.write(t, ctx, a, "/orders/archive/1", "c")
+	assert.Equal(t, "1", a.ConsistencyToken(ctx, "/orders/2"))
+	assert.Equal(t, "2", a.ConsistencyToken(ctx, "/users"))
+	assert.Equal(t, "3", a.ConsistencyToken(ctx, "/orders/archive/9"))
+	assert.Equal(t, "", a.ConsistencyToken(ctx, "/items/1"))
+
+	// Per session, the reads of another session or of none don't get the token.
+	alice, bob := ConsistencySession(ctx, "alice"), ConsistencySession(ctx, "bob")
+	a.SetConsistency(&ConsistencyPolicy{Scope: ConsistencyBySession})
+	s.write(t, alice, a, "/notes/1", "x")
+	s.write(t, alice, a, "/notes/1", "y")
+	assert.Equal(t, "y", read(alice, a, "/notes/1"))
+	assert.Equal(t, "x", read(bob, a, "/notes/1"))
+	assert.Equal(t, "x", read(ctx, a, "/notes/1"))
+	s.write(t, bob, a, "/notes/1", "z")
+	assert.Equal(t, "z", read(bob, a, "/notes/1"))
+	assert.Equal(t, "y", read(alice, a, "/notes/1"))
+
+	// The tenants have their own tokens.
+	assert.Equal(t, "", a.ForTenant("t1").ConsistencyToken(alice, "/notes/1"))
+}
+
+func TestConsistencyConcurrentWrites(t *testing.T) {
+	s := newConsistentServer()
+	defer s.Close()
+	a := MustNew(s.URL)
+	a.SetConsistency(&ConsistencyPolicy{Newer: func(x, y string) bool {
+		n, _ := strconv.Atoi(x)
+		m, _ := strconv.Atoi(y)
+		return n > m
+	}})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.write(t, context.Background(), a, "/counter", strings.Repeat("x", i))
+		}(i)
+	}
+	wg.Wait()
+	// The newest token is kept, whatever the order the responses were handled in.
+	assert.Equal(t, "20", a.ConsistencyToken(context.Background(), "/counter"))
+	last := s.values["/counter"][19]
+	assert.Equal(t, last, read(context.Background(), a, "/counter"))
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidContentType is matched by every *ContentTypeError.
+var ErrInvalidContentType = errors.New("api: invalid content type")
+
+// ContentTypeError is returned for a malformed content type, see ContentType.Validate.
+type ContentTypeError struct {
+	Value  string
+	Reason string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("api: invalid content type %q: %s", e.Value, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrInvalidContentType) report true.
+func (e *ContentTypeError) Is(target error) bool { return target == ErrInvalidContentType }
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *ContentTypeError) Class() Class { return Permanent }
+
+// ContentType is a media type with its parameters, e.g. "application/json; charset=utf-8",
+// built from the usual types or MediaType so it's formatted per RFC 6838 and RFC 2045:
+//
+//	api.JSON.WithCharset("utf-8")                                          // application/json; charset=utf-8
+//	api.MediaType("application", "vnd.foo+json").WithParam("version", "2") // application/vnd.foo+json; version=2
+//
+// It's a string, so it's given as string(ct) where a content type string is taken, like
+// RequestBytes, and to WithAccept for the Accept header. The strings given as they are can be
+// checked with SetStrictContentTypes. A ContentType built from malformed parts doesn't pass
+// Validate.
+type ContentType string
+
+// The usual content types.
+const (
+	JSON           ContentType = "application/json"
+	XML            ContentType = "application/xml"
+	FormURLEncoded ContentType = "application/x-www-form-urlencoded"
+	OctetStream    ContentType = "application/octet-stream"
+	PlainText      ContentType = "text/plain"
+)
+
+// MediaType returns the ContentType of the type and subtype, e.g. "application" and
+// "vnd.foo+json", lowercased.
+func MediaType(typ, subtype string) ContentType {
+	return ContentType(strings.ToLower(typ) + "/" + strings.ToLower(subtype))
+}
+
+// WithParam returns ct with the parameter name set to value, the parameters being sorted by name
+// and their values quoted if needed. It returns ct with the parameter appended as it is, which
+// doesn't pass Validate, if ct or name is malformed.
+func (ct ContentType) WithParam(name, value string) ContentType {
+	mediatype, params, err := parseContentType(string(ct))
+	if err == nil {
+		params[strings.ToLower(name)] = value
+		if s := mime.FormatMediaType(mediatype, params); s != "" {
+			return ContentType(s)
+		}
+	}
+	return ct + "; " + ContentType(name) + "=" + ContentType(value)
+}
+
+// WithCharset returns ct with its charset parameter set to charset.
+func (ct ContentType) WithCharset(charset string) ContentType {
+	return ct.WithParam("charset", charset)
+}
+
+// MediaType returns the media type of ct without its parameters, e.g. "application/json".
+func (ct ContentType) MediaType() string {
+	mediatype, _, _ := mime.ParseMediaType(string(ct))
+	return mediatype
+}
+
+// Validate returns a *ContentTypeError if ct is malformed: its type and subtype must be
+// restricted names of RFC 6838, and its parameters well-formed.
+func (ct ContentType) Validate() error {
+	_, _, err := parseContentType(string(ct))
+	return err
+}
+
+// ParseContentType returns the ContentType of s in its canonical form, or a *ContentTypeError if
+// s is malformed, e.g. "application/json;;charset=utf-8".
+func ParseContentType(s string) (ContentType, error) {
+	mediatype, params, err := parseContentType(s)
+	if err != nil {
+		return "", err
+	}
+	return ContentType(mime.FormatMediaType(mediatype, params)), nil
+}
+
+func parseContentType(s string) (string, map[string]string, error) {
+	mediatype, params, err := mime.ParseMediaType(s)
+	if err != nil {
+		return "", nil, &ContentTypeError{Value: s, Reason: strings.TrimPrefix(err.Error(), "mime: ")}
+	}
+	typ, subtype, ok := strings.Cut(mediatype, "/")
+	if !ok {
+		return "", nil, &ContentTypeError{Value: s, Reason: "no subtype"}
+	}
+	for _, name := range []string{typ, subtype} {
+		if !restrictedName(name) {
+			return "", nil, &ContentTypeError{Value: s, Reason: fmt.Sprintf("invalid name %q", name)}
+		}
+	}
+	return mediatype, params, nil
+}
+
+// restrictedName reports whether s is a restricted-name of RFC 6838, section 4.2.
+func restrictedName(s string) bool {
+	if s == "" || len(s) > 127 {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case i > 0 && strings.ContainsRune("!#$&-^_.+", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithAccept sets the Accept header of the call to types, in order, so the response
+// expectations match the content types the requests are built with. The call fails with
+// a *ContentTypeError if a type is malformed.
+func WithAccept(types ...ContentType) Option {
+	var err error
+	values := make([]string, len(types))
+	for i, ct := range types {
+		if err == nil {
+			err = ct.Validate()
+		}
+		values[i] = string(ct)
+	}
+	accept := strings.Join(values, ", ")
+	return func(c *call) {
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.prepare = append(c.prepare, func(req *http.Request) error {
+			req.Header.Set("Accept", accept)
+			return nil
+		})
+	}
+}
+
+// SetStrictContentTypes makes RequestBytes, RequestReader, Upload and the body templates fail
+// with a *ContentTypeError when given a malformed content type, instead of sending it as it is.
+func (a *Api) SetStrictContentTypes(strict bool) {
+	a.mu.Lock()
+	a.strictTypes = strict
+	a.mu.Unlock()
+}
+
+// checkContentType checks the content type given to a request constructor in strict mode.
+func (a *Api) checkContentType(contentType string) error {
+	a.mu.Lock()
+	strict := a.strictTypes
+	a.mu.Unlock()
+	if !strict {
+		return nil
+	}
+	_, _, err := parseContentType(contentType)
+	return err
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentType(t *testing.T) {
+	for ct, want := range map[ContentType]string{
+		JSON.WithCharset("utf-8"): "application/json; charset=utf-8",
+		MediaType("Application", "vnd.foo+json").WithParam("version", "2").WithParam("Format", "full"): "application/vnd.foo+json; format=full; version=2",
+		PlainText.WithParam("title", `a "quoted"; value`):                                              `text/plain; title="a \"quoted\"; value"`,
+		PlainText.WithParam("title", "a b"):                                                            `text/plain; title="a b"`,
+		JSON.WithCharset("utf-8").WithCharset("latin1"):                                                "application/json; charset=latin1",
+	} {
+		assert.Equal(t, want, string(ct))
+		assert.NoError(t, ct.Validate(), want)
+	}
+	assert.Equal(t, "application/vnd.foo+json", MediaType("application", "vnd.foo+json").WithParam("version", "2").MediaType())
+
+	for _, ct := range []ContentType{
+		MediaType("appli cation", "json"),
+		MediaType("application", "json/x"),
+		MediaType("application", ".json"),
+		"application",
+		"application/json;;charset=utf8",
+		JSON.WithParam("bad name", "1"),
+		MediaType("application", "vnd foo").WithCharset("utf-8"),
+	} {
+		err := ct.Validate()
+		assert.True(t, errors.Is(err, ErrInvalidContentType), "%q: %v", ct, err)
+	}
+
+	ct, err := ParseContentType("Application/JSON;Charset=UTF-8")
+	assert.NoError(t, err)
+	assert.Equal(t, JSON.WithCharset("UTF-8"), ct)
+}
+
+func TestStrictContentTypes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.foo+json; version=2")
+		w.Write([]byte(`{"accept": "` + r.Header.Get("Accept") + `"}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	_, err := a.RequestBytes(POST, "/items", "application/json;;charset=utf8", nil)
+	assert.NoError(t, err)
+
+	a.SetStrictContentTypes(true)
+	_, err = a.RequestBytes(POST, "/items", "application/json;;charset=utf8", nil)
+	var ce *ContentTypeError
+	if assert.True(t, errors.As(err, &ce), "%v", err) {
+		assert.Equal(t, "application/json;;charset=utf8", ce.Value)
+	}
+	_, err = a.RequestReader(POST, "/items", "json", nil)
+	assert.True(t, errors.Is(err, ErrInvalidContentType))
+	req, err := a.RequestBytes(POST, "/items", string(JSON.WithCharset("utf-8")), []byte("{}"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "application/json; charset=utf-8", req.Header.Get("Content-Type"))
+	}
+
+	// The Accept header is built from the same types.
+	var out struct{ Accept string }
+	v2 := MediaType("application", "vnd.foo+json").WithParam("version", "2")
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithAccept(v2, JSON)))
+	assert.Equal(t, "application/vnd.foo+json; version=2, application/json", out.Accept)
+	err = a.Get(context.Background(), "/items", nil, &out, WithAccept(MediaType("application", "vnd foo")))
+	assert.True(t, errors.Is(err, ErrInvalidContentType))
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// PriorityContext is the priority of the preparer adding the headers and query parameters of
+// ContextHeaders and ContextQuery, which runs before the one of the Api's Header so they take
+// precedence over it.
+const PriorityContext = -300
+
+// The keys are unexported types, so only the values set by ContextHeaders and ContextQuery are
+// added to the requests, never those other packages store in the context.
+type (
+	headersKey struct{}
+	queryKey   struct{}
+)
+
+// ContextHeaders returns a copy of ctx that carries the header h for the requests created with it,
+// so a middleware can set the acting user or the feature flags once. The headers of the parent
+// contexts are kept, unless h replaces them. The request constructors add the headers the request
+// doesn't have yet, so the precedence is the per-call options > the context > the Api's Header;
+// the headers set by the constructor itself, like Content-Type, are kept. See AddPreparer for
+// the context of the constructors.
+func ContextHeaders(ctx context.Context, h http.Header) context.Context {
+	merged := http.Header{}
+	if parent, ok := ctx.Value(headersKey{}).(http.Header); ok {
+		for k, vs := range parent {
+			merged[k] = vs
+		}
+	}
+	for k, vs := range h {
+		merged[http.CanonicalHeaderKey(k)] = append([]string(nil), vs...)
+	}
+	return context.WithValue(ctx, headersKey{}, merged)
+}
+
+// ContextQuery is like ContextHeaders for the query parameters of the request URL, added unless
+// the URL has them already, e.g. from the args of the constructor.
+func ContextQuery(ctx context.Context, q url.Values) context.Context {
+	merged := url.Values{}
+	if parent, ok := ctx.Value(queryKey{}).(url.Values); ok {
+		for k, vs := range parent {
+			merged[k] = vs
+		}
+	}
+	for k, vs := range q {
+		merged[k] = append([]string(nil), vs...)
+	}
+	return context.WithValue(ctx, queryKey{}, merged)
+}
+
+func (a *Api) prepareContext(ctx context.Context, req *http.Request) error {
+	applyContext(ctx, req, nil)
+	return nil
+}
+
+// applyContext adds the headers and query parameters of ctx to req, replacing the headers of
+// base, e.g. the Api's Header frozen by a Template.
+func applyContext(ctx context.Context, req *http.Request, base http.Header) {
+	if h, ok := ctx.Value(headersKey{}).(http.Header); ok {
+		for k, vs := range h {
+			if _, ok := req.Header[k]; !ok || base[k] != nil {
+				req.Header[k] = append([]string(nil), vs...)
+			}
+		}
+	}
+	if q, ok := ctx.Value(queryKey{}).(url.Values); ok && len(q) > 0 {
+		query := QueryOf(req)
+		keys := make([]string, 0, len(q))
+		for k := range q {
+			if _, ok := query[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range q[k] {
+				AddQuery(req, k, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextHeaders(t *testing.T) {
+	type seen struct{ user, tenant, flags, query string }
+	var got []seen
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, seen{r.Header.Get("X-User"), r.Header.Get("X-Tenant"), r.Header.Get("X-Flags"), r.URL.RawQuery})
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Header = http.Header{"X-Tenant": {"default"}, "X-Flags": {"none"}}
+
+	type otherKey string
+	ctx := context.WithValue(context.Background(), otherKey("X-Flags"), "leaked")
+	ctx = ContextHeaders(ctx, http.Header{"x-user": {"alice"}, "X-Tenant": {"acme"}})
+	ctx = ContextQuery(ctx, url.Values{"trace": {"1"}, "page": {"9"}})
+	assert.NoError(t, a.Get(ctx, "/items", url.Values{"page": {"2"}}, nil))
+
+	// A nested context overrides the header of its parent and keeps the others.
+	inner := ContextHeaders(ctx, http.Header{"X-User": {"bob"}})
+	assert.NoError(t, a.Get(inner, "/items", nil, nil))
+	// The per-call options override the context.
+	assert.NoError(t, a.Get(inner, "/items", nil, nil, WithHeader("X-User", "carol"), WithQuery("trace", "0")))
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil))
+	// Without the values in the context, the Api's Header applies.
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, nil))
+
+	assert.Equal(t, []seen{
+		{"alice", "acme", "none", "page=2&trace=1"},
+		{"bob", "acme", "none", "page=9&trace=1"},
+		{"carol", "acme", "none", "page=9&trace=0"},
+		{"alice", "acme", "none", "page=9&trace=1"},
+		{"", "default", "none", ""},
+	}, got)
+
+	// Templates apply the context over the Header they froze.
+	got = nil
+	tpl := a.Template(GET, "/items/{id}")
+	assert.NoError(t, tpl.Do(inner, map[string]string{"id": "1"}, nil, nil))
+	assert.Equal(t, []seen{{"bob", "acme", "none", "page=9&trace=1"}}, got)
+
+	// The headers set by the constructor are kept.
+	ctx = ContextHeaders(context.Background(), http.Header{"Content-Type": {"text/plain"}})
+	assert.NoError(t, a.Post(ctx, "/items", map[string]int{"a": 1}, nil, WithHeader("X-User", "dave"),
+		func(c *call) {
+			c.prepare = append(c.prepare, func(req *http.Request) error {
+				assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+				return nil
+			})
+		}))
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ContinueStatus is the outcome of the Expect: 100-continue of a request, see ExpectContinue.
+type ContinueStatus int
+
+const (
+	// ContinueNone is the status of the requests without the expectation.
+	ContinueNone ContinueStatus = iota
+	// ContinueReceived reports that the server sent the interim 100 Continue before the body.
+	ContinueReceived
+	// ContinueRejected reports that the server answered before the body was sent, which wasn't.
+	ContinueRejected
+	// ContinueSkipped reports that the body was sent without a 100 Continue, e.g. once the
+	// timeout of SetExpectContinueTimeout expired.
+	ContinueSkipped
+)
+
+func (s ContinueStatus) String() string {
+	switch s {
+	case ContinueReceived:
+		return "received"
+	case ContinueRejected:
+		return "rejected"
+	case ContinueSkipped:
+		return "skipped"
+	default:
+		return "none"
+	}
+}
+
+// ExpectContinue sets the Expect: 100-continue header of the request, so that the transport
+// waits for the server to accept the request before sending the body, e.g. for large uploads
+// made with RequestReader to a server checking the credentials first. A server answering
+// before the body was sent, like with a 401, doesn't get it at all; since the body wasn't
+// read, the call can be retried even if it isn't replayable. The outcome is reported by
+// ResponseMeta.Continue. The transport waits for up to the timeout of SetExpectContinueTimeout,
+// then sends the body anyway.
+func ExpectContinue() Option {
+	return func(c *call) {
+		c.prepare = append(c.prepare, func(req *http.Request) error {
+			req.Header.Set("Expect", "100-continue")
+			return nil
+		})
+	}
+}
+
+// expectsContinue reports whether req has a body and the Expect: 100-continue header.
+func expectsContinue(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody && strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+}
+
+// continueBody is the body of a request expecting a 100 Continue, which tells whether it was
+// read. The transport closes the bodies it didn't send, so a body that wasn't read is kept open
+// until the call is over, for it to be sent again.
+type continueBody struct {
+	io.ReadCloser
+	read    atomic.Bool
+	got     atomic.Bool
+	pending atomic.Bool
+	once    sync.Once
+}
+
+// continueFor returns the continueBody of req, wrapping its body unless a previous attempt did.
+func continueFor(req *http.Request) *continueBody {
+	if b, ok := req.Body.(*continueBody); ok {
+		b.read.Store(false)
+		b.got.Store(false)
+		b.pending.Store(false)
+		return b
+	}
+	b := &continueBody{ReadCloser: req.Body}
+	req.Body = b
+	return b
+}
+
+// trace makes ctx report the 100 Continue to b.
+func (b *continueBody) trace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		Got100Continue: func() { b.got.Store(true) },
+	})
+}
+
+func (b *continueBody) Read(p []byte) (int, error) {
+	b.read.Store(true)
+	return b.ReadCloser.Read(p)
+}
+
+func (b *continueBody) Close() error {
+	if !b.read.Load() {
+		b.pending.Store(true)
+		return nil
+	}
+	return b.release()
+}
+
+// release closes the underlying body, once.
+func (b *continueBody) release() error {
+	var err error
+	b.once.Do(func() {
+		err = b.ReadCloser.Close()
+	})
+	return err
+}
+
+// rewind makes the body ready for another attempt, reporting whether it wasn't read.
+func (b *continueBody) rewind() bool {
+	return !b.read.Load()
+}
+
+// status returns the outcome of the expectation once the response arrived.
+func (b *continueBody) status() ContinueStatus {
+	switch {
+	case b.got.Load():
+		return ContinueReceived
+	case b.read.Load():
+		return ContinueSkipped
+	default:
+		return ContinueRejected
+	}
+}
+
+// releaseBody closes the body of req kept open for a retry by its continueBody, if any.
+func releaseBody(req *http.Request) {
+	if b, ok := req.Body.(*continueBody); ok && b.pending.Load() {
+		b.release()
+	}
+}
+
+// expectContinue is the timeout set by SetExpectContinueTimeout along with the client derived
+// from base to apply it.
+type expectContinue struct {
+	timeout time.Duration
+	base    *http.Client
+	client  *http.Client
+}
+
+// SetExpectContinueTimeout sets how long the transport waits for the 100 Continue of requests
+// made with ExpectContinue before sending the body anyway; the http.DefaultTransport waits for
+// 1s, while a zero http.Transport doesn't wait at all. A zero d restores the timeout of the
+// transport.
+//
+// The timeout is applied by a copy of the Api's client that is derived again whenever Client
+// is replaced, and requires the Transport of the client to be an *http.Transport or nil.
+func (a *Api) SetExpectContinueTimeout(d time.Duration) {
+	if d <= 0 {
+		a.expect.Store(nil)
+		return
+	}
+	a.expect.Store(newExpectContinue(d, a.orderClient(a.targetClient(a.certClient(a.baseClient())))))
+}
+
+// continueClient returns the client applying the 100 Continue timeout for base, deriving it if needed.
+func (a *Api) continueClient(base *http.Client) *http.Client {
+	e := a.expect.Load()
+	if e == nil {
+		return base
+	}
+	if e.base != base {
+		fresh := newExpectContinue(e.timeout, base)
+		if !a.expect.CompareAndSwap(e, fresh) {
+			return a.continueClient(base)
+		}
+		e = fresh
+	}
+	return e.client
+}
+
+func newExpectContinue(timeout time.Duration, base *http.Client) *expectContinue {
+	e := &expectContinue{timeout: timeout, base: base}
+	client := *base
+	rt := base.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if t, ok := rt.(*http.Transport); ok {
+		t = t.Clone()
+		t.ExpectContinueTimeout = timeout
+		client.Transport = t
+	} else {
+		client.Transport = errTransport{fmt.Errorf("api: the expect continue timeout requires an *http.Transport, got %T", rt)}
+	}
+	e.client = &client
+	return e
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// onceReader is a reader that can't be replayed, counting the bytes read from it.
+type onceReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (r *onceReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+func TestExpectContinue(t *testing.T) {
+	var attempts, received atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := attempts.Add(1)
+		switch {
+		case r.Header.Get("Authorization") != "Bearer ok":
+			// Answering without reading the body rejects it before it's sent.
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case r.URL.Path == "/flaky" && n == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received.Add(int64(len(body)))
+		w.Write([]byte(`"` + r.Header.Get("Expect") + `"`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Client = &http.Client{Transport: &http.Transport{}}
+	a.SetExpectContinueTimeout(time.Minute)
+	ctx := context.Background()
+	payload := strings.Repeat("x", 1<<20)
+
+	// Rejected at header time: nothing of the body is read.
+	r := &onceReader{r: strings.NewReader(payload)}
+	var meta ResponseMeta
+	err := a.Upload(ctx, PUT, "/files/a", "application/octet-stream", r, nil, ExpectContinue(), WithMeta(&meta))
+	var se *StatusError
+	if assert.ErrorAs(t, err, &se) {
+		assert.Equal(t, http.StatusUnauthorized, se.Code)
+	}
+	assert.Equal(t, ContinueRejected, meta.Continue)
+	assert.Equal(t, int64(0), r.n.Load())
+	assert.Equal(t, int64(0), received.Load())
+
+	// A rejected body can be sent again, though it can't be replayed.
+	attempts.Store(0)
+	a.Header = http.Header{"Authorization": {"Bearer ok"}}
+	retry := WithRetry(&RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond})
+	var expect string
+	err = a.Upload(ctx, PUT, "/flaky", "application/octet-stream", r, &expect, ExpectContinue(), WithMeta(&meta), retry)
+	assert.NoError(t, err)
+	assert.Equal(t, "100-continue", expect)
+	assert.Equal(t, ContinueReceived, meta.Continue)
+	assert.Equal(t, 1, meta.Retries)
+	assert.Equal(t, int64(2), attempts.Load())
+	assert.Equal(t, int64(len(payload)), r.n.Load())
+	assert.Equal(t, int64(len(payload)), received.Load())
+
+	// Without the expectation, the body is sent right away and isn't replayable.
+	attempts.Store(0)
+	r = &onceReader{r: strings.NewReader(payload)}
+	err = a.Upload(ctx, PUT, "/flaky", "application/octet-stream", r, nil, WithMeta(&meta), retry)
+	assert.ErrorAs(t, err, &se)
+	assert.Equal(t, ContinueNone, meta.Continue)
+	assert.Equal(t, int64(1), attempts.Load())
+
+	// Do reports the outcome too.
+	req, _ := a.RequestReader(PUT, "/files/b", "text/plain", strings.NewReader("hello"), ExpectContinue())
+	resp, err := a.Do(ctx, req, WithMeta(&meta))
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, ContinueReceived, meta.Continue)
+	}
+}
+
+func TestExpectContinueTimeout(t *testing.T) {
+	// The raw server never sends a 100 Continue.
+	srv := newRawServer(t)
+	defer srv.ln.Close()
+	a := MustNew("http://" + srv.ln.Addr().String())
+	a.Client = &http.Client{Transport: &http.Transport{}}
+	a.SetExpectContinueTimeout(10 * time.Millisecond)
+	var meta ResponseMeta
+	var n int
+	err := a.Upload(context.Background(), POST, "/", "text/plain", strings.NewReader("hello"), &n, ExpectContinue(), WithMeta(&meta))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, ContinueSkipped, meta.Continue)
+
+	a.Client = &http.Client{Transport: roundTripper(func(*http.Request) (*http.Response, error) { return nil, nil })}
+	err = a.Get(context.Background(), "/", nil, nil)
+	assert.ErrorContains(t, err, "api: the expect continue timeout requires an *http.Transport")
+}
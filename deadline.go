@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrInsufficientBudget is matched by every *InsufficientBudgetError.
+var ErrInsufficientBudget = errors.New("api: insufficient time budget")
+
+// DeadlineFormat is the format of the header of a DeadlinePolicy.
+type DeadlineFormat int
+
+const (
+	// DeadlineRFC3339 writes the deadline as an absolute RFC 3339 timestamp in UTC with
+	// milliseconds, e.g. "2020-01-01T00:00:01.500Z".
+	DeadlineRFC3339 DeadlineFormat = iota
+	// DeadlineMillis writes the milliseconds remaining until the deadline, e.g. "1500".
+	DeadlineMillis
+)
+
+// DeadlinePolicy configures the propagation of the deadlines of the calls, see SetDeadlineHeader.
+type DeadlinePolicy struct {
+	// Header is the name of the header, X-Request-Deadline if empty.
+	Header string
+	Format DeadlineFormat
+	// Margin is subtracted from the remaining time, e.g. for the network latency, so that the
+	// server gives up before the client does.
+	Margin time.Duration
+	// Floor is the remaining time under which the calls fail fast without being sent.
+	Floor time.Duration
+}
+
+// InsufficientBudgetError is returned for the calls whose remaining time is under the Floor of the
+// DeadlinePolicy, which aren't sent since they couldn't complete in time. It's matched by
+// ErrInsufficientBudget and is never retried.
+type InsufficientBudgetError struct {
+	// Remaining is the time left until the deadline, the margin subtracted.
+	Remaining time.Duration
+	Floor     time.Duration
+}
+
+func (e *InsufficientBudgetError) Error() string {
+	return fmt.Sprintf("api: insufficient time budget: %s left, %s needed", e.Remaining, e.Floor)
+}
+
+// Is makes errors.Is(err, ErrInsufficientBudget) report true.
+func (e *InsufficientBudgetError) Is(target error) bool {
+	return target == ErrInsufficientBudget
+}
+
+// Class makes the error a Permanent failure, since the budget only shrinks.
+func (e *InsufficientBudgetError) Class() Class { return Permanent }
+
+// SetDeadlineHeader makes every attempt of the calls with a deadline, the one of their context or
+// of WithTimeout or SetAdaptiveTimeout, carry the time remaining until it in the header of p, for
+// the server to shed the work it couldn't complete in time. The attempts with less time left
+// than p.Floor, or none at all, fail with an *InsufficientBudgetError instead. A nil p removes the
+// header.
+func (a *Api) SetDeadlineHeader(p *DeadlinePolicy) {
+	if p == nil {
+		a.deadline.Store(nil)
+		return
+	}
+	policy := *p
+	if policy.Header == "" {
+		policy.Header = "X-Request-Deadline"
+	}
+	a.deadline.Store(&policy)
+}
+
+// annotateDeadline sets the deadline header of req for an attempt with ctx, given its timeout.
+func (a *Api) annotateDeadline(ctx context.Context, req *http.Request, timeout time.Duration, now time.Time) error {
+	p := a.deadline.Load()
+	if p == nil {
+		return nil
+	}
+	deadline, ok := ctx.Deadline()
+	if timeout > 0 && (!ok || now.Add(timeout).Before(deadline)) {
+		deadline, ok = now.Add(timeout), true
+	}
+	if !ok {
+		return nil
+	}
+	deadline = deadline.Add(-p.Margin)
+	remaining := deadline.Sub(now)
+	if remaining <= 0 || remaining < p.Floor {
+		return &InsufficientBudgetError{Remaining: remaining, Floor: p.Floor}
+	}
+	if p.Format == DeadlineMillis {
+		req.Header.Set(p.Header, strconv.FormatInt(remaining.Milliseconds(), 10))
+	} else {
+		req.Header.Set(p.Header, deadline.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestSetDeadlineHeader(t *testing.T) {
+	var mu sync.Mutex
+	var headers []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	now := time.Now().Truncate(time.Second).Add(time.Second)
+	a.SetClock(clock.NewFake(now))
+	a.SetDeadlineHeader(&DeadlinePolicy{Margin: 100 * time.Millisecond, Floor: 50 * time.Millisecond})
+	last := func() http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		return headers[len(headers)-1]
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(2*time.Second))
+	defer cancel()
+	if !assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil)) {
+		return
+	}
+	assert.Equal(t, now.Add(1900*time.Millisecond).UTC().Format("2006-01-02T15:04:05.000Z07:00"), last().Get("X-Request-Deadline"))
+
+	// A shorter timeout of the call wins over the deadline of the context.
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil, WithTimeout(500*time.Millisecond)))
+	assert.Equal(t, now.Add(400*time.Millisecond).UTC().Format("2006-01-02T15:04:05.000Z07:00"), last().Get("X-Request-Deadline"))
+
+	// Without a deadline, there's no header.
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/", nil, nil))
+	assert.Empty(t, last().Get("X-Request-Deadline"))
+
+	a.SetDeadlineHeader(&DeadlinePolicy{Header: "Grpc-Timeout-Ms", Format: DeadlineMillis, Margin: 250 * time.Millisecond})
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil))
+	assert.Equal(t, "1750", last().Get("Grpc-Timeout-Ms"))
+	assert.Empty(t, last().Get("X-Request-Deadline"))
+
+	a.SetDeadlineHeader(nil)
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil))
+	assert.Empty(t, last().Get("Grpc-Timeout-Ms"))
+}
+
+func TestDeadlineFloor(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	now := time.Now().Add(time.Second)
+	a.SetClock(clock.NewFake(now))
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	a.SetDeadlineHeader(&DeadlinePolicy{Format: DeadlineMillis, Margin: 100 * time.Millisecond, Floor: 200 * time.Millisecond})
+
+	// 300ms left minus the margin is exactly the floor.
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(300*time.Millisecond))
+	defer cancel()
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil))
+	assert.Equal(t, 1, calls)
+
+	ctx, cancel = context.WithDeadline(context.Background(), now.Add(299*time.Millisecond))
+	defer cancel()
+	err := a.DoJSON(ctx, GET, "/", nil, nil)
+	var be *InsufficientBudgetError
+	if !assert.True(t, errors.As(err, &be), "%v", err) {
+		return
+	}
+	assert.True(t, errors.Is(err, ErrInsufficientBudget))
+	assert.Equal(t, 199*time.Millisecond, be.Remaining)
+	assert.Equal(t, 200*time.Millisecond, be.Floor)
+	assert.Equal(t, "api: insufficient time budget: 199ms left, 200ms needed", err.Error())
+	assert.Equal(t, 1, calls)
+
+	// The margin alone exhausts the budget even without a floor.
+	a.SetDeadlineHeader(&DeadlinePolicy{Margin: time.Second})
+	assert.True(t, errors.Is(a.DoJSON(ctx, GET, "/", nil, nil), ErrInsufficientBudget))
+	assert.Equal(t, 1, calls)
+}
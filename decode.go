@@ -0,0 +1,319 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// send executes req via Do and checks the status code, retrying according to the Api's RetryPolicy.
+// Non-2xx responses are returned as *StatusError with the body already consumed and closed.
+func (a *Api) send(ctx context.Context, c *call, req *http.Request) (resp *http.Response, err error) {
+	if c.resource == "" {
+		c.resource = req.URL.Path
+	}
+	defer releaseBody(req)
+	defer func() { err = a.wrapError(c, req, err) }()
+	if wq := a.queue.Load(); wq != nil && !c.queued {
+		return wq.send(ctx, a, c, req)
+	}
+	clk := a.clock()
+	start := clk.Now()
+	if m, _ := a.mirrorFor(req); m != nil {
+		defer func() { resp = a.startMirror(m, req, start, resp, err) }()
+	}
+	stale := a.staleCacheFor(req)
+	if c.cache == nil {
+		c.cache = a.cacheFor(req)
+	}
+	for attempt := 0; ; attempt++ {
+		if c.meta != nil {
+			c.meta.Retries = attempt
+		}
+		c.attempt = attempt
+		resp, err := a.retryCredential(ctx, c, req)
+		if err == nil && c.seeOther && resp.StatusCode == http.StatusSeeOther {
+			if next, ok := seeOther(req, resp); ok {
+				drainClose(resp.Body)
+				req = next
+				resp, err = a.do(ctx, c, req)
+			}
+		}
+		if err == nil {
+			if err = a.check(c, resp); err == nil {
+				err = a.validate(c, req, resp)
+			} else if c.cache != nil {
+				c.cache.keepNegative(req, err)
+			}
+			if err == nil {
+				var retry bool
+				var after time.Duration
+				if retry, after, err = c.retryPolicy(a).retryResponse(attempt, resp); retry && !a.hints.Load().noRetry(resp.Header) && ctx.Err() == nil && rewind(req) == nil {
+					drainClose(resp.Body)
+					if err = clk.Sleep(ctx, after); err == nil {
+						continue
+					}
+					err = withCause(ctx, err)
+					a.logCall(ctx, c, req, nil, err, start, attempt)
+					return nil, err
+				}
+			}
+			if err == nil {
+				if c.cache != nil {
+					c.cache.keep(req, resp)
+				} else {
+					a.invalidateNegative(req)
+				}
+				if stale != nil {
+					stale.keep(req, resp, clk)
+				}
+				a.logCall(ctx, c, req, resp, nil, start, attempt)
+				return resp, nil
+			}
+		}
+		wait, ok := c.retryPolicy(a).backoff(attempt, err, clk.Now())
+		if ok && !a.hints.Load().noRetryErr(err) && ctx.Err() == nil && rewind(req) == nil {
+			if err = clk.Sleep(ctx, wait); err == nil {
+				continue
+			}
+			err = withCause(ctx, err)
+		}
+		a.logCall(ctx, c, req, nil, err, start, attempt)
+		if stale != nil && ctx.Err() == nil {
+			now := clk.Now()
+			if resp, age, ok := stale.serve(req, err, now); ok {
+				if c.meta != nil {
+					c.meta.fill(resp, start, now)
+					c.meta.stored(ProvenanceStaleIfError, now.Add(-age), age)
+				}
+				return resp, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// newCallFor creates a call of a Do-style helper for the given resource.
+func (a *Api) newCallFor(resource string, opts []Option) *call {
+	c := a.newCall(opts)
+	c.resource = resource
+	return c
+}
+
+// DoJSON creates a request just like Request does, executes it and decodes the JSON response into out.
+// If out is nil, the body is discarded but the status code is still checked.
+// A 204 No Content response, or an empty or whitespace-only body, leaves out untouched and sets
+// ResponseMeta.NoContent. Other bodies must be JSON: an HTML body, like a captive portal's login page,
+// fails with an *UnexpectedContentError rather than a JSON syntax error.
+func (a *Api) DoJSON(ctx context.Context, method Method, resource string, args url.Values, out interface{}, opts ...Option) error {
+	req, err := a.callRequest(ctx, method, resource, args, opts)
+	if err != nil {
+		return err
+	}
+	return a.sendJSON(ctx, req, resource, out, opts)
+}
+
+// sendJSON sends req via send and decodes the JSON response into out, see DoJSON.
+func (a *Api) sendJSON(ctx context.Context, req *http.Request, resource string, out interface{}, opts []Option) error {
+	return a.decodeJSON(ctx, a.newCallFor(resource, opts), req, out)
+}
+
+// decodeJSON sends req via send as the call c and decodes the JSON response into out.
+func (a *Api) decodeJSON(ctx context.Context, c *call, req *http.Request, out interface{}) error {
+	if c.memo > 0 && req.Method == http.MethodGet {
+		return a.wrapError(c, req, a.decodeMemo(ctx, c, req, out))
+	}
+	resp, err := a.send(ctx, c, req)
+	if err != nil {
+		return err
+	}
+	dr := a.dualReadFor(c, req, resp, out)
+	if dr == nil {
+		return a.wrapError(c, req, decodeResponse(c, req, resp, out))
+	}
+	var body bytes.Buffer
+	dr.tee(resp, &body)
+	if err := decodeResponse(c, req, resp, out); err != nil {
+		return a.wrapError(c, req, err)
+	}
+	a.enqueueDualRead(dr, c, req, body.Bytes(), out)
+	return nil
+}
+
+// decodeResponse decodes the JSON body of the successful response into out and closes it.
+func decodeResponse(c *call, req *http.Request, resp *http.Response, out interface{}) error {
+	if resp.StatusCode == http.StatusNoContent {
+		c.noContent()
+	} else if out != nil {
+		if err := checkJSONContent(req, resp); err != nil {
+			drainClose(resp.Body)
+			return err
+		}
+		dec := c.decoder(resp.Body)
+		if err := c.decodeValue(dec, out); err == io.EOF {
+			// The body is empty or only whitespace.
+			c.noContent()
+		} else if err != nil {
+			drainClose(resp.Body)
+			return notJSON(resp, dec, err)
+		}
+	}
+	return drainClose(resp.Body)
+}
+
+// DoNDJSON creates a request just like Request does, executes it and reads the response
+// as a stream of newline-delimited JSON values, invoking fn for each of them.
+// The stream stops at the first error returned by fn. The rest of the stream is then
+// closed without being drained, since it may be arbitrarily long.
+func (a *Api) DoNDJSON(ctx context.Context, method Method, resource string, args url.Values, fn func(v json.RawMessage) error, opts ...Option) error {
+	req, err := a.callRequest(ctx, method, resource, args, opts)
+	if err != nil {
+		return err
+	}
+	resp, err := a.send(ctx, a.newCallFor(resource, opts), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkJSONContent(req, resp); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err == io.EOF {
+			return resp.Body.Close()
+		} else if err != nil {
+			drainClose(resp.Body)
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+}
+
+// JSONArrayError reports a failure to decode the element at Index of a streamed JSON array.
+type JSONArrayError struct {
+	Index int
+	Err   error
+}
+
+func (e *JSONArrayError) Error() string {
+	return fmt.Sprintf("api: json array element %d: %v", e.Index, e.Err)
+}
+
+func (e *JSONArrayError) Unwrap() error { return e.Err }
+
+// DoJSONArray creates a request just like Request does, executes it and streams the response,
+// which must be a JSON array, invoking fn for each element. fn must decode exactly one value from dec,
+// so memory stays proportional to a single element rather than the whole array:
+//
+//	err := svc.DoJSONArray(ctx, api.GET, "/events", nil, func(dec *json.Decoder) error {
+//		var e Event
+//		if err := dec.Decode(&e); err != nil {
+//			return err
+//		}
+//		return process(e)
+//	})
+//
+// A 204 No Content response, or an empty or whitespace-only body, is taken for an empty array.
+// Decode errors, including a truncated stream, are returned as *JSONArrayError. The stream stops
+// at the first error returned by fn or once ctx is done.
+func (a *Api) DoJSONArray(ctx context.Context, method Method, resource string, args url.Values, fn func(dec *json.Decoder) error, opts ...Option) error {
+	req, err := a.callRequest(ctx, method, resource, args, opts)
+	if err != nil {
+		return err
+	}
+	c := a.newCallFor(resource, opts)
+	resp, err := a.send(ctx, c, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		c.noContent()
+		return drainClose(resp.Body)
+	}
+	if err := checkJSONContent(req, resp); err != nil {
+		return err
+	}
+	dec := c.decoder(resp.Body)
+	tok, err := dec.Token()
+	if err == io.EOF {
+		c.noContent()
+		return drainClose(resp.Body)
+	} else if err != nil {
+		if e := notJSON(resp, dec, err); e != err {
+			return e
+		}
+		return &JSONArrayError{Index: 0, Err: err}
+	}
+	if err := decodeElements(ctx, dec, tok, fn); err != nil {
+		return err
+	}
+	return drainClose(resp.Body)
+}
+
+// noContent records that the response of the call has nothing to decode, see ResponseMeta.NoContent.
+func (c *call) noContent() {
+	if c.meta != nil {
+		c.meta.NoContent = true
+	}
+}
+
+// decodeArray reads a JSON array from dec, invoking fn to decode each of its elements.
+func decodeArray(ctx context.Context, dec *json.Decoder, fn func(dec *json.Decoder) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return &JSONArrayError{Index: 0, Err: unexpectedEOF(err)}
+	}
+	return decodeElements(ctx, dec, tok, fn)
+}
+
+// decodeElements reads the elements of the JSON array opened by tok from dec, see decodeArray.
+func decodeElements(ctx context.Context, dec *json.Decoder, tok json.Token, fn func(dec *json.Decoder) error) error {
+	if tok != json.Delim('[') {
+		return errors.New("api: not a json array")
+	}
+	i := 0
+	for ; dec.More(); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		offset := dec.InputOffset()
+		if err := fn(dec); err != nil {
+			if isDecodeError(err) {
+				return &JSONArrayError{Index: i, Err: unexpectedEOF(err)}
+			}
+			return err
+		}
+		if dec.InputOffset() == offset {
+			return &JSONArrayError{Index: i, Err: errors.New("element not decoded")}
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return &JSONArrayError{Index: i, Err: unexpectedEOF(err)}
+	}
+	return nil
+}
+
+func isDecodeError(err error) bool {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	return errors.As(err, &syntax) || errors.As(err, &typ) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, since it means the array was truncated.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
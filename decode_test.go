@@ -0,0 +1,248 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// arrayServer streams a JSON array of n elements, cut after the element at truncate if it's non-negative.
+func arrayServer(n, truncate int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bw := bufio.NewWriter(w)
+		defer bw.Flush()
+		bw.WriteString("[")
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				bw.WriteString(",")
+			}
+			if i == truncate {
+				bw.WriteString(`{"id":`)
+				return
+			}
+			fmt.Fprintf(bw, `{"id":%d,"name":"item"}`, i)
+		}
+		bw.WriteString("]")
+	}))
+}
+
+func TestDoJSONArray(t *testing.T) {
+	const n = 100000
+	srv := arrayServer(n, -1)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	runtime.GC()
+	var before, during runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var maxHeap uint64
+	var count, sum int
+	err := a.DoJSONArray(context.Background(), GET, "/items", nil, func(dec *json.Decoder) error {
+		var v struct {
+			ID   int
+			Name string
+		}
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		if count%10000 == 0 {
+			runtime.GC()
+			runtime.ReadMemStats(&during)
+			if during.HeapAlloc > maxHeap {
+				maxHeap = during.HeapAlloc
+			}
+		}
+		count++
+		sum += v.ID
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, n, count)
+	assert.Equal(t, n*(n-1)/2, sum)
+	// The whole array is ~2.5MB of JSON; only a single element should be live at a time.
+	if !raceEnabled {
+		assert.Less(t, int64(maxHeap)-int64(before.HeapAlloc), int64(512<<10))
+	}
+}
+
+func TestDoJSONArrayTruncated(t *testing.T) {
+	srv := arrayServer(10, 7)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	var count int
+	err := a.DoJSONArray(context.Background(), GET, "/items", nil, func(dec *json.Decoder) error {
+		var v struct{ ID int }
+		count++
+		return dec.Decode(&v)
+	})
+	var ae *JSONArrayError
+	if assert.ErrorAs(t, err, &ae) {
+		assert.Equal(t, 7, ae.Index)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Contains(t, err.Error(), "element 7")
+	}
+	assert.Equal(t, 8, count)
+}
+
+func TestDoJSONArrayErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/object":
+			io.WriteString(w, `{"items": []}`)
+		case "/unclosed":
+			io.WriteString(w, `[1, 2`)
+		case "/mixed":
+			io.WriteString(w, `[1, 2, "three", 4]`)
+		default:
+			io.WriteString(w, `[1, 2, 3]`)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	decodeInt := func(dec *json.Decoder) error {
+		var v int
+		return dec.Decode(&v)
+	}
+
+	assert.EqualError(t, a.DoJSONArray(context.Background(), GET, "/object", nil, decodeInt), "api: not a json array")
+
+	err := a.DoJSONArray(context.Background(), GET, "/unclosed", nil, decodeInt)
+	var ae *JSONArrayError
+	if assert.ErrorAs(t, err, &ae) {
+		assert.Equal(t, 2, ae.Index)
+	}
+
+	err = a.DoJSONArray(context.Background(), GET, "/mixed", nil, decodeInt)
+	var te *json.UnmarshalTypeError
+	if assert.ErrorAs(t, err, &ae) && assert.ErrorAs(t, err, &te) {
+		assert.Equal(t, 2, ae.Index)
+	}
+
+	stop := errors.New("stop")
+	assert.Equal(t, stop, a.DoJSONArray(context.Background(), GET, "/", nil, func(dec *json.Decoder) error {
+		return stop
+	}))
+	err = a.DoJSONArray(context.Background(), GET, "/", nil, func(dec *json.Decoder) error { return nil })
+	if assert.ErrorAs(t, err, &ae) {
+		assert.Equal(t, 0, ae.Index)
+	}
+}
+
+func TestDoJSONArrayCanceled(t *testing.T) {
+	srv := arrayServer(100000, -1)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var count int
+	err := a.DoJSONArray(ctx, GET, "/items", nil, func(dec *json.Decoder) error {
+		var v struct{ ID int }
+		if count++; count == 100 {
+			cancel()
+		}
+		return dec.Decode(&v)
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 100, count)
+}
+
+func TestDecodeNoContent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status int
+		fmt.Sscan(r.URL.Query().Get("status"), &status)
+		body := r.URL.Query().Get("body")
+		if body == "json" {
+			body = map[string]string{
+				"/json":  `{"id": 1}`,
+				"/array": ` [{"id": 1}]`,
+				"/soap":  `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><Item><ID>1</ID></Item></s:Body></s:Envelope>`,
+			}[r.URL.Path]
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	type item struct{ ID int }
+	helpers := map[string]func(args url.Values, meta *ResponseMeta) (int, error){
+		"DoJSON": func(args url.Values, meta *ResponseMeta) (int, error) {
+			out := item{ID: -1}
+			err := a.DoJSON(context.Background(), GET, "/json", args, &out, WithMeta(meta))
+			return out.ID, err
+		},
+		"Template": func(args url.Values, meta *ResponseMeta) (int, error) {
+			out := item{ID: -1}
+			err := a.Template(GET, "/json", WithMeta(meta)).Do(context.Background(), nil, args, &out)
+			return out.ID, err
+		},
+		"DoJSONArray": func(args url.Values, meta *ResponseMeta) (int, error) {
+			id := -1
+			err := a.DoJSONArray(context.Background(), GET, "/array", args, func(dec *json.Decoder) error {
+				var it item
+				err := dec.Decode(&it)
+				id = it.ID
+				return err
+			}, WithMeta(meta))
+			return id, err
+		},
+		"DoSOAP": func(args url.Values, meta *ResponseMeta) (int, error) {
+			out := item{ID: -1}
+			err := a.DoSOAP(context.Background(), "/soap", "Get", nil, &out, WithMeta(meta),
+				WithQuery("status", args.Get("status")), WithQuery("body", args.Get("body")))
+			return out.ID, err
+		},
+	}
+	for _, tc := range []struct {
+		status int
+		body   string
+		want   int
+		err    bool
+	}{
+		{200, "", -1, false},
+		{200, "\n", -1, false},
+		{201, " \r\n\t ", -1, false},
+		{202, "", -1, false},
+		{204, "", -1, false},
+		{200, "json", 1, false},
+		{201, "json", 1, false},
+		{200, "OK", -1, true},
+		{202, "accepted\n", -1, true},
+	} {
+		for name, helper := range helpers {
+			args := url.Values{"status": {fmt.Sprint(tc.status)}, "body": {tc.body}}
+			meta := &ResponseMeta{NoContent: tc.body == "json"}
+			got, err := helper(args, meta)
+			desc := fmt.Sprintf("%s %d %q", name, tc.status, tc.body)
+			if tc.err {
+				if assert.Error(t, err, desc) && name != "DoSOAP" {
+					var ue *UnexpectedContentError
+					if assert.ErrorAs(t, err, &ue, desc) {
+						assert.Equal(t, "text/plain", ue.ContentType, desc)
+						assert.Equal(t, strings.TrimSpace(tc.body), ue.Preview, desc)
+					}
+				}
+				continue
+			}
+			if assert.NoError(t, err, desc) {
+				assert.Equal(t, tc.want, got, desc)
+				assert.Equal(t, tc.want == -1, meta.NoContent, desc)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrDictionaryMismatch is matched by the *DictionaryMismatchErrors of the responses compressed
+// against another dictionary than the one of the Api.
+var ErrDictionaryMismatch = errors.New("api: compression dictionary mismatch")
+
+// DictionaryMismatchError is returned for a response compressed against a dictionary whose ID
+// isn't the one of SetDictionary: its body can't be read.
+type DictionaryMismatchError struct {
+	// Want is the ID of the dictionary of the Api, Got the one of the response, empty if it has none.
+	Want, Got string
+}
+
+func (e *DictionaryMismatchError) Error() string {
+	if e.Got == "" {
+		return fmt.Sprintf("api: response compressed against an unnamed dictionary, have %q", e.Want)
+	}
+	return fmt.Sprintf("api: response compressed against dictionary %q, have %q", e.Got, e.Want)
+}
+
+// Is makes errors.Is(err, ErrDictionaryMismatch) report true.
+func (e *DictionaryMismatchError) Is(target error) bool { return target == ErrDictionaryMismatch }
+
+// Class classifies the mismatches as permanent: the dictionaries must be deployed again.
+func (e *DictionaryMismatchError) Class() Class { return Permanent }
+
+// DictionaryCodec compresses and decompresses bodies against a preset dictionary, for
+// SetDictionary.
+type DictionaryCodec interface {
+	// Encoding is the Content-Encoding of the compressed bodies, e.g. "dict-deflate".
+	Encoding() string
+	// Compress returns a writer compressing to dst against dict; closing it flushes the body.
+	Compress(dst io.Writer, dict []byte) (io.WriteCloser, error)
+	// Decompress returns a reader of the body compressed in src against dict.
+	Decompress(src io.Reader, dict []byte) (io.ReadCloser, error)
+}
+
+// FlateDictionary is the DictionaryCodec of compress/flate with a preset dictionary, sent as
+// "dict-deflate". The dictionary is best made of the strings the bodies share, the most common
+// last, within the 32KB window of flate. Gzip has no preset dictionaries, hence raw deflate.
+type FlateDictionary struct {
+	// Level is the compression level of compress/flate, flate.DefaultCompression if zero.
+	Level int
+}
+
+func (FlateDictionary) Encoding() string { return "dict-deflate" }
+
+func (f FlateDictionary) Compress(dst io.Writer, dict []byte) (io.WriteCloser, error) {
+	level := f.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	return flate.NewWriterDict(dst, level, dict)
+}
+
+func (FlateDictionary) Decompress(src io.Reader, dict []byte) (io.ReadCloser, error) {
+	return flate.NewReaderDict(src, dict), nil
+}
+
+// Dictionary configures SetDictionary.
+type Dictionary struct {
+	// ID names the dictionary for the server, which must have the same Data.
+	ID   string
+	Data []byte
+	// Codec compresses the bodies, FlateDictionary{} if nil.
+	Codec DictionaryCodec
+	// Header carries the ID in the requests and the responses, "X-Dictionary-ID" if empty.
+	Header string
+	// MinSize is the size of the smallest request bodies compressed, 256 bytes if zero.
+	MinSize int64
+}
+
+// SetDictionary makes the Api compress the request bodies of at least d.MinSize bytes against the
+// dictionary d shares with the server, which is worth it for the many small bodies alike, e.g. the
+// events of a telemetry API, that gzip alone hardly shrinks. Every request carries the ID of the
+// dictionary in d.Header, telling the server it can compress its responses against it too; the
+// responses of the encoding of d.Codec are decompressed, and fail with a *DictionaryMismatchError
+// if they name another dictionary. Like with SetRequestCompression, only the bodies that can be
+// read again are compressed, and never those already having a Content-Encoding; the dictionary
+// takes precedence over SetRequestCompression. A nil d disables it.
+func (a *Api) SetDictionary(d *Dictionary) {
+	if d == nil {
+		a.dictionary.Store(nil)
+		return
+	}
+	dict := *d
+	if dict.Codec == nil {
+		dict.Codec = FlateDictionary{}
+	}
+	if dict.Header == "" {
+		dict.Header = "X-Dictionary-ID"
+	}
+	dict.Header = http.CanonicalHeaderKey(dict.Header)
+	if dict.MinSize <= 0 {
+		dict.MinSize = 256
+	}
+	a.dictionary.Store(&dict)
+}
+
+// applyDictionary sets the ID of the dictionary of the Api on req.
+func (a *Api) applyDictionary(req *http.Request) {
+	if d := a.dictionary.Load(); d != nil && req.Header.Get(d.Header) == "" {
+		req.Header.Set(d.Header, d.ID)
+	}
+}
+
+// decompressDict replaces the body of resp compressed against the dictionary of the Api by the
+// decompressed one.
+func (a *Api) decompressDict(resp *http.Response) error {
+	d := a.dictionary.Load()
+	if d == nil || resp.Header.Get("Content-Encoding") != d.Codec.Encoding() {
+		return nil
+	}
+	if id := resp.Header.Get(d.Header); id != d.ID {
+		drainClose(resp.Body)
+		return &DictionaryMismatchError{Want: d.ID, Got: id}
+	}
+	zr, err := d.Codec.Decompress(resp.Body, d.Data)
+	if err != nil {
+		drainClose(resp.Body)
+		return err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{zr, closers{zr, resp.Body}}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// closers closes all of them, returning the first error.
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var first error
+	for _, c := range cs {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDict = []byte(`{"event": "page_view", "user_agent": "Mozilla/5.0", "properties": {"path": "/", "referrer": ""}}`)
+
+// dictServer echoes the request bodies compressed against dict, decompressing them with it, and
+// compresses its responses against dict too, naming it id.
+type dictServer struct {
+	*httptest.Server
+	encoding, received string
+	size               int
+}
+
+func newDictServer(id string, dict []byte) *dictServer {
+	s := &dictServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.encoding = r.Header.Get("Content-Encoding")
+		raw, _ := io.ReadAll(r.Body)
+		s.size = len(raw)
+		body := raw
+		if s.encoding == "dict-deflate" {
+			zr, _ := FlateDictionary{}.Decompress(bytes.NewReader(raw), dict)
+			body, _ = io.ReadAll(zr)
+		}
+		s.received = string(body)
+		if r.Header.Get("X-Dictionary-Id") == "" {
+			w.Write(body)
+			return
+		}
+		w.Header().Set("Content-Encoding", "dict-deflate")
+		w.Header().Set("X-Dictionary-Id", id)
+		zw, _ := FlateDictionary{}.Compress(w, dict)
+		zw.Write(body)
+		zw.Close()
+	}))
+	return s
+}
+
+func TestDictionary(t *testing.T) {
+	s := newDictServer("events-v1", testDict)
+	defer s.Close()
+	a := MustNew(s.URL)
+	a.SetDictionary(&Dictionary{ID: "events-v1", Data: testDict})
+	event := map[string]interface{}{"event": "page_view", "user_agent": "Mozilla/5.0", "properties": map[string]string{"path": "/pricing", "referrer": "https://example.com/" + strings.Repeat("x", 200)}}
+	var out map[string]interface{}
+	if !assert.NoError(t, a.Post(context.Background(), "/events", event, &out)) {
+		return
+	}
+	assert.Equal(t, "dict-deflate", s.encoding)
+	assert.Contains(t, s.received, `"path":"/pricing"`)
+	assert.Less(t, s.size, len(s.received)/2)
+	assert.Equal(t, "/pricing", out["properties"].(map[string]interface{})["path"])
+
+	// The small bodies are sent as they are, the responses still decompressed.
+	out = nil
+	assert.NoError(t, a.Post(context.Background(), "/events", map[string]string{"event": "ping"}, &out))
+	assert.Equal(t, "", s.encoding)
+	assert.Equal(t, map[string]interface{}{"event": "ping"}, out)
+
+	// Without a dictionary, the server doesn't compress.
+	a.SetDictionary(nil)
+	out = nil
+	assert.NoError(t, a.Post(context.Background(), "/events", event, &out))
+	assert.Equal(t, "", s.encoding)
+	assert.Equal(t, "page_view", out["event"])
+}
+
+func TestDictionaryMismatch(t *testing.T) {
+	s := newDictServer("events-v2", testDict)
+	defer s.Close()
+	a := MustNew(s.URL)
+	a.SetDictionary(&Dictionary{ID: "events-v1", Data: testDict, Header: "x-dictionary-id"})
+	err := a.Post(context.Background(), "/events", map[string]string{"event": "ping"}, nil)
+	var de *DictionaryMismatchError
+	if assert.True(t, errors.As(err, &de)) {
+		assert.Equal(t, DictionaryMismatchError{Want: "events-v1", Got: "events-v2"}, *de)
+	}
+	assert.ErrorIs(t, err, ErrDictionaryMismatch)
+	assert.Equal(t, `api: response compressed against dictionary "events-v2", have "events-v1"`, de.Error())
+	assert.Equal(t, Permanent, Classify(err))
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RequestDiff is the structured difference between two requests, see DiffRequests.
+type RequestDiff struct {
+	Entries []DiffEntry
+}
+
+// DiffEntry is a single difference between two requests.
+type DiffEntry struct {
+	// Path locates the difference: "method", "url.scheme", "url.host", "url.path",
+	// `query["page"]`, `header["Accept"]`, "body" or a location within a JSON body
+	// like "body.items[0].id".
+	Path string
+	// A and B render the values in the first and the second request.
+	A, B string
+	// MissingA and MissingB report that the part is absent from the first or the second request.
+	MissingA, MissingB bool
+}
+
+// Equal reports whether the requests are the same.
+func (d RequestDiff) Equal() bool {
+	return len(d.Entries) == 0
+}
+
+// String renders one difference per line, suitable for test failure messages:
+//
+//	query["page"]: "1" != "2"
+//	header["Accept"]: "application/json" != (missing)
+//	body.user.name: "bob" != "alice"
+func (d RequestDiff) String() string {
+	if d.Equal() {
+		return "requests are equal"
+	}
+	var b strings.Builder
+	for i, e := range d.Entries {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		a, v := e.A, e.B
+		if e.MissingA {
+			a = "(missing)"
+		}
+		if e.MissingB {
+			v = "(missing)"
+		}
+		fmt.Fprintf(&b, "%s: %s != %s", e.Path, a, v)
+	}
+	return b.String()
+}
+
+// DiffRequests compares the method, URL components, individual query parameters, headers and
+// bodies of a and b. Bodies are compared structurally if both are JSON, byte by byte otherwise.
+// The bodies are read via GetBody if it's set; otherwise they are read and replaced by
+// in-memory copies, so the requests can still be sent.
+func DiffRequests(a, b *http.Request) RequestDiff {
+	var d RequestDiff
+	d.value("method", a.Method, b.Method)
+	d.value("url.scheme", a.URL.Scheme, b.URL.Scheme)
+	d.value("url.host", requestHost(a), requestHost(b))
+	d.value("url.path", a.URL.EscapedPath(), b.URL.EscapedPath())
+	d.values("query", a.URL.Query(), b.URL.Query())
+	d.values("header", a.Header, b.Header)
+	d.body(readRequestBody(a), readRequestBody(b))
+	return d
+}
+
+func (d *RequestDiff) value(path, a, b string) {
+	if a != b {
+		d.Entries = append(d.Entries, DiffEntry{Path: path, A: strconv.Quote(a), B: strconv.Quote(b)})
+	}
+}
+
+// values compares multi-valued maps key by key, in the sorted order of keys.
+func (d *RequestDiff) values(kind string, a, b map[string][]string) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		va, okA := a[k]
+		vb, okB := b[k]
+		if okA && okB && equalStrings(va, vb) {
+			continue
+		}
+		d.Entries = append(d.Entries, DiffEntry{
+			Path:     fmt.Sprintf("%s[%q]", kind, k),
+			A:        quoteValues(va),
+			B:        quoteValues(vb),
+			MissingA: !okA,
+			MissingB: !okB,
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func quoteValues(vs []string) string {
+	if len(vs) == 1 {
+		return strconv.Quote(vs[0])
+	}
+	quoted := make([]string, len(vs))
+	for i, v := range vs {
+		quoted[i] = strconv.Quote(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func (d *RequestDiff) body(a, b []byte) {
+	if bytes.Equal(a, b) {
+		return
+	}
+	va, errA := decodeJSONValue(a)
+	vb, errB := decodeJSONValue(b)
+	if errA == nil && errB == nil {
+		d.jsonValue("body", va, vb)
+		return
+	}
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	d.Entries = append(d.Entries, DiffEntry{
+		Path: fmt.Sprintf("body (%d vs %d bytes, first difference at byte %d)", len(a), len(b), i),
+		A:    bytesAround(a, i),
+		B:    bytesAround(b, i),
+	})
+}
+
+// bytesAround quotes up to 32 bytes of b around the offset i.
+func bytesAround(b []byte, i int) string {
+	start, end := max(i-16, 0), min(i+16, len(b))
+	s := strconv.Quote(string(b[start:end]))
+	if start > 0 {
+		s = "..." + s
+	}
+	if end < len(b) {
+		s += "..."
+	}
+	return s
+}
+
+func decodeJSONValue(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("api: trailing data after json value")
+	}
+	return v, nil
+}
+
+var jsonIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// jsonValue compares decoded JSON values recursively, descending into objects and arrays.
+func (d *RequestDiff) jsonValue(path string, a, b interface{}) {
+	switch va := a.(type) {
+	case map[string]interface{}:
+		if vb, ok := b.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(va)+len(vb))
+			for k := range va {
+				keys = append(keys, k)
+			}
+			for k := range vb {
+				if _, ok := va[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				sub := path + "." + k
+				if !jsonIdent.MatchString(k) {
+					sub = fmt.Sprintf("%s[%q]", path, k)
+				}
+				ea, okA := va[k]
+				eb, okB := vb[k]
+				if okA && okB {
+					d.jsonValue(sub, ea, eb)
+					continue
+				}
+				d.Entries = append(d.Entries, DiffEntry{Path: sub, A: renderJSON(ea), B: renderJSON(eb), MissingA: !okA, MissingB: !okB})
+			}
+			return
+		}
+	case []interface{}:
+		if vb, ok := b.([]interface{}); ok {
+			for i := 0; i < len(va) || i < len(vb); i++ {
+				sub := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(va):
+					d.Entries = append(d.Entries, DiffEntry{Path: sub, B: renderJSON(vb[i]), MissingA: true})
+				case i >= len(vb):
+					d.Entries = append(d.Entries, DiffEntry{Path: sub, A: renderJSON(va[i]), MissingB: true})
+				default:
+					d.jsonValue(sub, va[i], vb[i])
+				}
+			}
+			return
+		}
+	case json.Number:
+		if vb, ok := b.(json.Number); ok {
+			fa, errA := va.Float64()
+			fb, errB := vb.Float64()
+			if va == vb || errA == nil && errB == nil && fa == fb {
+				return
+			}
+		}
+	default:
+		if a == b {
+			return
+		}
+	}
+	d.Entries = append(d.Entries, DiffEntry{Path: path, A: renderJSON(a), B: renderJSON(b)})
+}
+
+func renderJSON(v interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// requestHost returns the lowercased host the request is sent to, Host taking precedence over the URL.
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return strings.ToLower(req.Host)
+	}
+	return strings.ToLower(req.URL.Host)
+}
+
+// readRequestBody reads the body of req without consuming it.
+func readRequestBody(req *http.Request) []byte {
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			defer body.Close()
+			b, _ := io.ReadAll(body)
+			return b
+		}
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	b, _ := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	return b
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffRequestsQuery(t *testing.T) {
+	a := MustNew("http://example.com/v1")
+	built, err := a.Request(GET, "/items", url.Values{"page": {"1"}, "sort": {"name"}, "tag": {"a", "b"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	curl, _ := http.NewRequest("GET", "http://example.com/v1/items?tag=a&tag=c&page=2&limit=10", nil)
+	curl.Header.Set("Accept", "application/json")
+
+	d := DiffRequests(built, curl)
+	assert.False(t, d.Equal())
+	assert.Equal(t, `query["limit"]: (missing) != "10"
+query["page"]: "1" != "2"
+query["sort"]: "name" != (missing)
+query["tag"]: ["a", "b"] != ["a", "c"]
+header["Accept"]: (missing) != "application/json"`, d.String())
+
+	same, _ := http.NewRequest("GET", "http://example.com/v1/items?sort=name&tag=a&tag=b&page=1", nil)
+	assert.True(t, DiffRequests(built, same).Equal())
+	assert.Equal(t, "requests are equal", DiffRequests(built, same).String())
+}
+
+func TestDiffRequestsJSONBody(t *testing.T) {
+	a := MustNew("http://example.com")
+	built, err := a.RequestJSON(POST, "/users", map[string]interface{}{
+		"name":  "bob",
+		"age":   30,
+		"tags":  []string{"x", "y"},
+		"extra": map[string]interface{}{"a.b": true},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	body := `{"name": "alice", "age": 30.0, "tags": ["x"], "extra": {"a.b": "true"}, "admin": false}`
+	curl, _ := http.NewRequest("PUT", "http://example.com/users", strings.NewReader(body))
+	curl.Header.Set("Content-Type", "application/json")
+	curl.Header.Set("Content-Length", "30")
+
+	d := DiffRequests(built, curl)
+	assert.Equal(t, `method: "POST" != "PUT"
+header["Content-Length"]: "61" != "30"
+body.admin: (missing) != false
+body.extra["a.b"]: true != "true"
+body.name: "bob" != "alice"
+body.tags[1]: "y" != (missing)`, d.String())
+
+	// The bodies are left intact.
+	b, _ := io.ReadAll(curl.Body)
+	assert.Equal(t, body, string(b))
+	b, _ = io.ReadAll(built.Body)
+	assert.Contains(t, string(b), `"bob"`)
+}
+
+func TestDiffRequestsBytes(t *testing.T) {
+	a, _ := http.NewRequest("POST", "http://example.com/x", bytes.NewReader([]byte("name=bob&role=admin&team=platform")))
+	b, _ := http.NewRequest("POST", "http://Example.com:8080/x", bytes.NewReader([]byte("name=bob&role=user&team=platform")))
+	assert.Equal(t, `url.host: "example.com" != "example.com:8080"
+body (33 vs 32 bytes, first difference at byte 14): "name=bob&role=admin&team=platf"... != "name=bob&role=user&team=platfo"...`, DiffRequests(a, b).String())
+}
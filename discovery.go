@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// The well-known keys of Capabilities read by its helpers.
+const (
+	CapMaxPageSize  = "max_page_size"
+	CapMaxBatchSize = "max_batch_size"
+	// CapFeatures is the list of the names of the features supported, see Capabilities.Supports.
+	CapFeatures = "features"
+)
+
+// Capabilities are the optional features of an API described by its discovery endpoint, see
+// Discover, by name, holding the values of encoding/json: bool, float64, string, []interface{}
+// and map[string]interface{}.
+type Capabilities map[string]interface{}
+
+// Supports reports whether the feature is supported: its value is true, or it's among the
+// strings of the CapFeatures list.
+func (c Capabilities) Supports(feature string) bool {
+	if v, ok := c[feature].(bool); ok {
+		return v
+	}
+	for _, f := range c.Strings(CapFeatures) {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Int returns the value of name if it's a whole number.
+func (c Capabilities) Int(name string) (int, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), true
+		}
+	case int:
+		return v, true
+	}
+	return 0, false
+}
+
+// Strings returns the strings of the value of name if it's a list, e.g. the supported filters.
+func (c Capabilities) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		var ss []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	return nil
+}
+
+// MaxPageSize returns the CapMaxPageSize, 0 if there's none.
+func (c Capabilities) MaxPageSize() int {
+	n, _ := c.Int(CapMaxPageSize)
+	return max(n, 0)
+}
+
+// MaxBatchSize returns the CapMaxBatchSize, 0 if there's none.
+func (c Capabilities) MaxBatchSize() int {
+	n, _ := c.Int(CapMaxBatchSize)
+	return max(n, 0)
+}
+
+// DiscoveryPolicy configures Discover, see SetDiscoveryPolicy.
+type DiscoveryPolicy struct {
+	// TTL is how long the capabilities are kept, an hour if zero.
+	TTL time.Duration
+	// RefreshBefore is how long before they expire they're refreshed in the background, a tenth
+	// of the TTL if zero. A failed refresh is tried again after as long.
+	RefreshBefore time.Duration
+	// PageSizes makes the MaxPageSize of the capabilities lower the limits of DeclarePageLimit.
+	PageSizes bool
+	// BatchSizes makes the MaxBatchSize of the capabilities cap the batches of Bulk.
+	BatchSizes bool
+	// Flags makes the flags bound by BindFlag apply only to the calls of the features the
+	// capabilities support; they're all on without a provider of SetFlagProvider, whose flags
+	// are gated otherwise.
+	Flags bool
+}
+
+func (p *DiscoveryPolicy) ttl() time.Duration {
+	return durationOr(p.TTL, time.Hour)
+}
+
+func (p *DiscoveryPolicy) refreshBefore() time.Duration {
+	return durationOr(p.RefreshBefore, p.ttl()/10)
+}
+
+// discovery is the state of the capabilities of an Api.
+type discovery struct {
+	resource string
+	decode   func([]byte) (Capabilities, error)
+	// pinned is set for the capabilities of SetCapabilities, which are never fetched.
+	pinned bool
+
+	mu   sync.Mutex
+	caps Capabilities
+	// expires is when caps expire, retryAt when a failed refresh may be tried again.
+	expires, retryAt time.Time
+	refreshing       bool
+}
+
+// forTenant returns the discovery of a tenant of d: the same capabilities if they're pinned,
+// its own ones of the same resource otherwise.
+func (d *discovery) forTenant() *discovery {
+	if d == nil || d.pinned {
+		return d
+	}
+	return &discovery{resource: d.resource, decode: d.decode}
+}
+
+// SetDiscoveryPolicy sets the policy of the capabilities of Discover.
+func (a *Api) SetDiscoveryPolicy(p DiscoveryPolicy) {
+	a.discoveryPolicy.Store(&p)
+}
+
+func (a *Api) discoveryPolicyOf() *DiscoveryPolicy {
+	if p := a.discoveryPolicy.Load(); p != nil {
+		return p
+	}
+	return &DiscoveryPolicy{}
+}
+
+// Discover returns the capabilities of the API described by its discovery endpoint at resource,
+// e.g. "/capabilities", decoded from the response body by decode, or as a JSON object if it's
+// nil. They're fetched by the first call and kept for the TTL of the DiscoveryPolicy; the calls
+// near their expiry get the kept ones while they're refreshed in the background, so only the
+// first call waits for them, and they're kept when a refresh fails. The capabilities are then
+// the ones of the Api, consulted by the page limits, Bulk and the flags as configured by
+// SetDiscoveryPolicy, and refreshed when they do. Discovering another resource replaces them.
+// See SetCapabilities for setting them by hand.
+func (a *Api) Discover(ctx context.Context, resource string, decode func([]byte) (Capabilities, error)) (Capabilities, error) {
+	d := a.discovery.Load()
+	if d == nil || !d.pinned && d.resource != resource {
+		next := &discovery{resource: resource, decode: decode}
+		if a.discovery.CompareAndSwap(d, next) {
+			d = next
+		} else {
+			d = a.discovery.Load()
+		}
+	}
+	return a.capabilitiesOf(ctx, d, true)
+}
+
+// SetCapabilities sets the capabilities of the Api, never fetched by Discover, e.g. for the
+// tests without the discovery endpoint. A nil c removes them, for Discover to fetch them again.
+func (a *Api) SetCapabilities(c Capabilities) {
+	if c == nil {
+		a.discovery.Store(nil)
+		return
+	}
+	a.discovery.Store(&discovery{pinned: true, caps: c})
+}
+
+// Capabilities returns the capabilities of the Api kept by Discover, or set by SetCapabilities,
+// without waiting for them: nil if they weren't fetched yet.
+func (a *Api) Capabilities() Capabilities {
+	d := a.discovery.Load()
+	if d == nil {
+		return nil
+	}
+	caps, _ := a.capabilitiesOf(context.Background(), d, false)
+	return caps
+}
+
+// capabilitiesOf returns the capabilities of d, refreshing them in the background near their
+// expiry. Without any, they're fetched right away with wait set, in the background otherwise.
+func (a *Api) capabilitiesOf(ctx context.Context, d *discovery, wait bool) (Capabilities, error) {
+	if d.pinned {
+		return d.caps, nil
+	}
+	p := a.discoveryPolicyOf()
+	now := a.clock().Now()
+	d.mu.Lock()
+	caps := d.caps
+	if caps != nil && now.Before(d.expires.Add(-p.refreshBefore())) {
+		d.mu.Unlock()
+		return caps, nil
+	}
+	start := !d.refreshing && !now.Before(d.retryAt)
+	if caps == nil && wait {
+		d.refreshing = true
+		d.mu.Unlock()
+		return a.fetchCapabilities(ctx, d)
+	}
+	d.refreshing = d.refreshing || start
+	d.mu.Unlock()
+	if start {
+		a.goBackground(context.WithoutCancel(ctx), func(ctx context.Context) {
+			a.fetchCapabilities(ctx, d)
+		})
+	}
+	return caps, nil
+}
+
+// fetchCapabilities fetches the capabilities of d and keeps them.
+func (a *Api) fetchCapabilities(ctx context.Context, d *discovery) (Capabilities, error) {
+	caps, err := a.getCapabilities(ctx, d)
+	p := a.discoveryPolicyOf()
+	now := a.clock().Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refreshing = false
+	if err != nil {
+		d.retryAt = now.Add(p.refreshBefore())
+		return nil, err
+	}
+	d.caps, d.expires = caps, now.Add(p.ttl())
+	return caps, nil
+}
+
+func (a *Api) getCapabilities(ctx context.Context, d *discovery) (Capabilities, error) {
+	req, err := a.Request(GET, d.resource, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.send(ctx, a.newCallFor(d.resource, nil), req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if d.decode != nil {
+		return d.decode(body)
+	}
+	var caps Capabilities
+	if err := json.Unmarshal(body, &caps); err != nil {
+		return nil, err
+	}
+	if caps == nil {
+		caps = Capabilities{}
+	}
+	return caps, nil
+}
+
+// discovered returns the capabilities of the Api if the policy has them consulted for the
+// feature of use, nil otherwise.
+func (a *Api) discovered(use func(p *DiscoveryPolicy) bool) Capabilities {
+	p := a.discoveryPolicy.Load()
+	if p == nil || !use(p) {
+		return nil
+	}
+	return a.Capabilities()
+}
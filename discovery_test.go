@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestCapabilities(t *testing.T) {
+	var caps Capabilities
+	assert.NoError(t, json.Unmarshal([]byte(`{"batch": true, "export": false, "features": ["search", 3], "max_page_size": 50, "ratio": 0.5}`), &caps))
+	assert.True(t, caps.Supports("batch"))
+	assert.True(t, caps.Supports("search"))
+	assert.False(t, caps.Supports("export"))
+	assert.False(t, caps.Supports("webhooks"))
+	assert.Equal(t, []string{"search"}, caps.Strings(CapFeatures))
+	assert.Equal(t, 50, caps.MaxPageSize())
+	assert.Zero(t, caps.MaxBatchSize())
+	_, ok := caps.Int("ratio")
+	assert.False(t, ok)
+	assert.Zero(t, Capabilities(nil).MaxPageSize())
+}
+
+func TestDiscover(t *testing.T) {
+	var fetches atomic.Int32
+	var maxPage atomic.Int32
+	maxPage.Store(50)
+	var failing atomic.Bool
+	var lastQuery atomic.Pointer[string]
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/capabilities" {
+			q := r.URL.RawQuery
+			lastQuery.Store(&q)
+			return
+		}
+		fetches.Add(1)
+		if failing.Load() {
+			http.Error(w, "down", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"max_page_size": maxPage.Load()})
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	defer a.Close()
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a.SetClock(clk)
+	a.SetDiscoveryPolicy(DiscoveryPolicy{TTL: time.Hour, RefreshBefore: 10 * time.Minute, PageSizes: true})
+	a.DeclarePageLimit("/items", "per_page", 100)
+	ctx := context.Background()
+	query := func() string {
+		assert.NoError(t, a.Get(ctx, "/items", url.Values{"per_page": {"80"}}, nil))
+		return *lastQuery.Load()
+	}
+
+	// Nothing is fetched until Discover is called.
+	assert.Equal(t, "per_page=80", query())
+	assert.Nil(t, a.Capabilities())
+	assert.Zero(t, fetches.Load())
+
+	caps, err := a.Discover(ctx, "/capabilities", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 50, caps.MaxPageSize())
+	assert.Equal(t, "per_page=50", query())
+	caps, _ = a.Discover(ctx, "/capabilities", nil)
+	assert.Equal(t, 50, caps.MaxPageSize())
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// Near their expiry, they're refreshed in the background while the kept ones are used.
+	maxPage.Store(20)
+	clk.Advance(49 * time.Minute)
+	assert.Equal(t, "per_page=50", query())
+	assert.Equal(t, int32(1), fetches.Load())
+	clk.Advance(2 * time.Minute)
+	caps, err = a.Discover(ctx, "/capabilities", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, caps.MaxPageSize())
+	assert.Eventually(t, func() bool { return a.Capabilities().MaxPageSize() == 20 }, time.Second, time.Millisecond)
+	assert.Equal(t, "per_page=20", query())
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// A failed refresh keeps them, and is tried again after RefreshBefore.
+	failing.Store(true)
+	clk.Advance(55 * time.Minute)
+	a.Capabilities()
+	assert.Eventually(t, func() bool { return fetches.Load() == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, "per_page=20", query())
+	assert.Equal(t, int32(3), fetches.Load())
+	failing.Store(false)
+	maxPage.Store(30)
+	clk.Advance(10 * time.Minute)
+	assert.Equal(t, "per_page=20", query())
+	assert.Eventually(t, func() bool { return a.Capabilities().MaxPageSize() == 30 }, time.Second, time.Millisecond)
+	assert.Equal(t, "per_page=30", query())
+
+	// The declared limit still applies when it's lower.
+	maxPage.Store(500)
+	clk.Advance(time.Hour)
+	a.Capabilities()
+	assert.Eventually(t, func() bool { return a.Capabilities().MaxPageSize() == 500 }, time.Second, time.Millisecond)
+	assert.NoError(t, a.Get(ctx, "/items", url.Values{"per_page": {"800"}}, nil))
+	assert.Equal(t, "per_page=100", *lastQuery.Load())
+
+	// The first fetch failing fails Discover.
+	b := MustNew(srv.URL)
+	failing.Store(true)
+	_, err = b.Discover(ctx, "/capabilities", nil)
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, http.StatusBadRequest, se.Code)
+	}
+}
+
+func TestDiscoverOverride(t *testing.T) {
+	var batches []int
+	var last atomic.Pointer[http.Request]
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last.Store(r)
+		if r.URL.Path == "/capabilities" {
+			t.Error("fetched the pinned capabilities")
+		}
+		if r.URL.Path == "/bulk" {
+			var batch []int
+			json.NewDecoder(r.Body).Decode(&batch)
+			batches = append(batches, len(batch))
+			results := make([]map[string]int, len(batch))
+			for i := range results {
+				results[i] = map[string]int{"status": 200}
+			}
+			json.NewEncoder(w).Encode(results)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetCapabilities(Capabilities{"max_batch_size": 2, "features": []string{"beta"}})
+	a.SetDiscoveryPolicy(DiscoveryPolicy{BatchSizes: true, Flags: true})
+	a.BindFlag("beta", FlagHeader("x-beta", "1"))
+	a.BindFlag("gamma", FlagHeader("x-gamma", "1"))
+	ctx := context.Background()
+
+	caps, err := a.Discover(ctx, "/capabilities", func([]byte) (Capabilities, error) { return nil, errors.New("unused") })
+	assert.NoError(t, err)
+	assert.True(t, caps.Supports("beta"))
+
+	res, err := Bulk(ctx, a, "/bulk", []int{1, 2, 3, 4, 5}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, res.Failed)
+	assert.Equal(t, []int{2, 2, 1}, batches)
+
+	// Without a provider, the supported flags are on, and with one they're gated by it too.
+	var meta ResponseMeta
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil, WithMeta(&meta)))
+	assert.Equal(t, "1", last.Load().Header.Get("X-Beta"))
+	assert.Empty(t, last.Load().Header.Get("X-Gamma"))
+	assert.Equal(t, []string{"beta"}, meta.Flags)
+	a.SetFlagProvider(func(ctx context.Context) map[string]bool { return map[string]bool{"gamma": true} })
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil, WithMeta(&meta)))
+	assert.Empty(t, last.Load().Header.Get("X-Beta"))
+	assert.Empty(t, last.Load().Header.Get("X-Gamma"))
+
+	// The tenants share the pinned capabilities.
+	assert.Equal(t, 2, a.ForTenant("acme").Capabilities().MaxBatchSize())
+}
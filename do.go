@@ -0,0 +1,506 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+var (
+	// ErrClientClosed is returned by Do after Shutdown has been called.
+	ErrClientClosed = errors.New("api: client closed")
+	// ErrShutdown is the cause of the cancellation of the calls outstanding when Shutdown gives up
+	// waiting for them, see TimeoutError.Cause.
+	ErrShutdown = errors.New("api: shut down")
+)
+
+// flight is a single request executed by Do that hasn't finished yet.
+// A request is in flight until its response body has been closed.
+type flight struct {
+	cancel context.CancelCauseFunc
+}
+
+func (a *Api) client() *http.Client {
+	return a.continueClient(a.orderClient(a.targetClient(a.certClient(a.baseClient()))))
+}
+
+func (a *Api) baseClient() *http.Client {
+	if a.Client != nil {
+		return a.Client
+	}
+	return http.DefaultClient
+}
+
+// Do sends the request using the Api's client, bound to the given context and configured by opts.
+// The call is tracked until the response body is closed, so the caller must always close it.
+// Once Shutdown has been called, Do fails fast with ErrClientClosed. See DetectLeaks
+// for finding the calls whose bodies are never closed.
+//
+// A request sent over a reused connection just as the server closes it fails before any byte
+// of the response arrives. The transport sends the idempotent requests again itself; Do does
+// the same once for the others, like POST, whatever the RetryPolicy, if their body is
+// replayable. These retries are counted by PoolStats.StaleRetries.
+func (a *Api) Do(ctx context.Context, req *http.Request, opts ...Option) (*http.Response, error) {
+	m, start := a.mirrorFor(req)
+	c := a.newCall(opts)
+	resp, err := a.retryCredential(ctx, c, req)
+	if m != nil {
+		resp = a.startMirror(m, req, start, resp, err)
+	}
+	releaseBody(req)
+	if err != nil {
+		return nil, a.wrapError(c, req, err)
+	}
+	return trackLeak(resp), nil
+}
+
+func (a *Api) do(ctx context.Context, c *call, req *http.Request) (*http.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if err := c.checkHedging(req); err != nil {
+		return nil, err
+	}
+	if err := a.prepareCall(ctx, c, req); err != nil {
+		return nil, err
+	}
+	clk := a.clock()
+	resource := c.resourceOf(req)
+	if u := a.usage.Load(); u != nil && c.attempt == 0 {
+		a.trackUsage(ctx, u, resource)
+	}
+	if c.cache != nil {
+		if resp, ok := a.lookup(ctx, c, req, clk.Now()); ok {
+			return resp, nil
+		}
+	}
+	if err := a.checkRequestSize(c, req, resource); err != nil {
+		return nil, err
+	}
+	h := a.hints.Load()
+	if h != nil {
+		if err := h.wait(ctx, resource, clk); err != nil {
+			return nil, err
+		}
+	}
+	if sc := a.schedule.Load(); sc != nil {
+		if err := sc.wait(ctx, clk); err != nil {
+			return nil, err
+		}
+	}
+	if p := a.politeness.Load(); p != nil {
+		if err := a.polite(ctx, p, req, clk); err != nil {
+			return nil, err
+		}
+	}
+	var leave func()
+	if c.serializeKey != "" {
+		l, err := a.fences().enter(ctx, c.serializeKey)
+		if err != nil {
+			return nil, err
+		}
+		leave = l
+	}
+	var finish func(failed bool)
+	shed := a.shed.Load()
+	if shed != nil {
+		done, err := shed.admit(ctx, c.priority, clk)
+		if err != nil {
+			if leave != nil {
+				leave()
+			}
+			return nil, err
+		}
+		finish = done
+	}
+	req, host := a.pickHost(req, c.avoid, clk.Now())
+	if h != nil {
+		h.echo(req, clk.Now())
+	}
+	// release ends the call for the host pool, the shedding and the serialization, failed being
+	// its outcome.
+	release := func(failed bool) {}
+	if host != nil || finish != nil || leave != nil {
+		release = func(failed bool) {
+			if host != nil {
+				host.pool.release(host)
+			}
+			if finish != nil {
+				finish(failed)
+			}
+			if leave != nil {
+				leave()
+			}
+		}
+	}
+	if err := a.checkTarget(req.URL); err != nil {
+		release(false)
+		return nil, err
+	}
+	jc, err := a.journalFor(ctx, req)
+	if err != nil {
+		release(false)
+		return nil, err
+	}
+	cc, err := a.captureFor(ctx, c, req)
+	if err != nil {
+		release(false)
+		return nil, err
+	}
+	timeout, est := c.timeout, TimeoutEstimator(nil)
+	if timeout == 0 {
+		if est = a.timeoutEstimator(); est != nil {
+			timeout = est.Timeout(resource)
+		}
+	}
+	if err := a.annotateDeadline(ctx, req, timeout, clk.Now()); err != nil {
+		release(false)
+		return nil, err
+	}
+	spent, err := spendBudget(ctx, resource, c.attempt > 0)
+	if err != nil {
+		release(false)
+		return nil, err
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancelCause(a.withRedactor(ctx))
+	rr := a.redactionFor(resource)
+	if rr != nil {
+		ctx = withRedaction(ctx, rr)
+	}
+	if timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, timeout)
+		cancelCause := cancel
+		cancel = func(cause error) {
+			cancelCause(cause)
+			stop()
+		}
+	}
+	f := &flight{cancel: cancel}
+	if !a.track(f, c.async) {
+		cancel(nil)
+		release(false)
+		return nil, ErrClientClosed
+	}
+	client := a.client()
+	if c.trace != nil {
+		ctx = c.trace.attempt(ctx, clk)
+	}
+	if cc != nil {
+		ctx = httptrace.WithClientTrace(ctx, cc.entry.trace())
+	}
+	var cb *continueBody
+	if expectsContinue(req) {
+		cb = continueFor(req)
+		ctx = cb.trace(ctx)
+	}
+	plain := req
+	if zipped, err := a.compress(req); err != nil {
+		a.untrack(f)
+		cancel(nil)
+		release(false)
+		return nil, err
+	} else if zipped != nil {
+		req = zipped
+	}
+	timer := newCallTimer(ctx, client, req, c.resource, clk)
+	var wt *watch
+	if c.watchdog != nil {
+		wt = c.watchdog.start(req, timer, cancel)
+	}
+	var decode bool
+	exchange := func() (*http.Response, bool, context.CancelFunc, error) {
+		sent := req
+		decode = false
+		if c.limits != nil {
+			sent, decode = c.limits.accept(req, client)
+		}
+		sent, c.sent = a.sizeFor(c, sent, resource)
+		if c.trailer != nil {
+			sent = c.trailer.wrap(sent)
+		}
+		return c.exchange(ctx, client, sent, timer, a.poolStats(), clk)
+	}
+	resp, reused, done, err := exchange()
+	if err == nil && req != plain && a.rejected(req.URL.Host, resp) {
+		// The host refused the compressed body: it's sent again as it is.
+		if done != nil {
+			done()
+		}
+		req = plain
+		resp, reused, done, err = exchange()
+	}
+	spent(clk.Now().Sub(timer.sent))
+	if err == nil && c.trailer != nil {
+		if terr := c.trailer.acknowledged(resp); terr != nil {
+			drainClose(resp.Body)
+			if done != nil {
+				done()
+			}
+			resp, err = nil, terr
+		}
+	}
+	if err == nil && resp.TLS != nil {
+		if audit := a.tlsAudit.Load(); audit != nil {
+			if aerr := audit.check(req.URL.Host, resp.TLS, reused, clk.Now()); aerr != nil {
+				drainClose(resp.Body)
+				if done != nil {
+					done()
+				}
+				resp, err = nil, aerr
+			}
+		}
+	}
+	if jc != nil {
+		jc.entry.Time = timer.sent
+	}
+	if err != nil {
+		a.untrack(f)
+		if wt != nil {
+			wt.stop()
+		}
+		if c.trace != nil {
+			c.trace.finish()
+		}
+		if jc != nil {
+			// The transport error is what the caller needs, even in strict mode.
+			jc.record(nil, err)
+		}
+		if cc != nil {
+			cc.fail(err)
+		}
+		// A call canceled by the caller says nothing about the host.
+		failed := parent.Err() == nil
+		if shed != nil && failed {
+			shed.observe(clk.Now().Sub(timer.sent), 0, clk.Now())
+		}
+		if host != nil && failed {
+			host.pool.report(host, true, clk.Now())
+			c.avoid = host
+		}
+		release(failed)
+		return nil, timer.wrap(err)
+	}
+	if est != nil && c.attempt == 0 {
+		est.Observe(resource, clk.Now().Sub(timer.sent))
+	}
+	if shed != nil {
+		shed.observe(clk.Now().Sub(timer.sent), resp.StatusCode, clk.Now())
+	}
+	if h != nil {
+		h.capture(resource, req, resp, clk.Now())
+	}
+	a.captureConsistency(ctx, req, resource, resp)
+	failed := resp.StatusCode >= 500
+	if host != nil {
+		host.pool.report(host, failed, clk.Now())
+		if c.avoid = nil; failed {
+			c.avoid = host
+		}
+	}
+	if c.cache != nil {
+		c.cache.requested, c.cache.received = timer.sent, clk.Now()
+		resp = c.cache.revalidated(req, resp)
+	}
+	timer.enter(PhaseReadingBody)
+	a.checkDeprecation(resp)
+	a.learnMigration(req, resp)
+	if c.meta != nil {
+		c.meta.fill(resp, timer.sent, clk.Now())
+		c.meta.ConnReused = reused
+		c.meta.Hedges = c.hedges
+		c.meta.RequestSize = requestSize(req, c.sent)
+		if c.cache != nil && c.cache.hit {
+			c.meta.Provenance, c.meta.Cached = ProvenanceRevalidated, true
+		}
+		if cb != nil {
+			c.meta.Continue = cb.status()
+		}
+	}
+	resp.Body = &timeoutBody{ReadCloser: newLengthBody(req, resp), t: timer}
+	if wt != nil {
+		resp.Body = &watchBody{ReadCloser: resp.Body, wt: wt}
+	}
+	if c.limits != nil {
+		resp.Body = c.limits.wrap(resp, decode)
+	}
+	if jc != nil {
+		// The journal hashes the bytes received, before any redaction.
+		resp.Body = jc.wrap(resp)
+	}
+	if rr != nil {
+		resp.Body = rr.wrap(resp)
+	}
+	if cc != nil {
+		resp.Body = cc.wrap(resp)
+	}
+	resp.Body = &flightBody{ReadCloser: resp.Body, done: func() {
+		a.untrack(f)
+		if wt != nil {
+			wt.stop()
+		}
+		if done != nil {
+			done()
+		}
+		release(failed)
+	}}
+	if err := a.decodeResponseBody(resp); err != nil {
+		return nil, timer.wrap(err)
+	}
+	resp.Body = c.wrapBody(resp)
+	if c.spool != nil {
+		body, n, err := c.spool.spoolBody(resp)
+		if err != nil {
+			return nil, timer.wrap(err)
+		}
+		resp.Body, resp.ContentLength = body, n
+	}
+	return resp, nil
+}
+
+// resourceOf returns the resource of req the per-resource state is kept for: the one of the
+// Template of the call, or else the one the call was made for.
+func (c *call) resourceOf(req *http.Request) string {
+	if c.template != "" {
+		return c.template
+	}
+	if c.resource != "" {
+		return c.resource
+	}
+	return req.URL.Path
+}
+
+// prepareCall applies the per-call settings of c to req before it's sent.
+func (a *Api) prepareCall(ctx context.Context, c *call, req *http.Request) error {
+	if err := a.applyLocale(ctx, c, req); err != nil {
+		return err
+	}
+	if err := a.applyAttribution(ctx, req); err != nil {
+		return err
+	}
+	a.applyFlags(ctx, c, req)
+	a.applyDictionary(req)
+	a.applyConsistency(ctx, req, c.resourceOf(req))
+	for _, prepare := range c.prepare {
+		if err := prepare(req); err != nil {
+			return err
+		}
+	}
+	if err := a.applyIdempotency(ctx, c, req); err != nil {
+		return err
+	}
+	if c.conditions != nil {
+		if err := a.applyConditions(c, req); err != nil {
+			return err
+		}
+	}
+	if c.fields != nil {
+		a.applyFields(c, req)
+	}
+	a.applyRawHeaders(req)
+	a.normalizeQuery(req)
+	return nil
+}
+
+// decodeResponseBody passes the body of resp through the dictionary, the codec and the response transforms of the Api.
+func (a *Api) decodeResponseBody(resp *http.Response) error {
+	if err := a.decompressDict(resp); err != nil {
+		return err
+	}
+	if codec := a.bodyCodec(); codec != nil {
+		if err := decodeBody(codec, resp); err != nil {
+			return err
+		}
+	}
+	if t := a.transforms.Load(); t != nil && t.response != nil {
+		return transformResponse(t.response, resp)
+	}
+	return nil
+}
+
+// track tracks the flight f, unless the Api is closed; the calls of DoAsync are still tracked
+// while Shutdown makes the queued ones.
+func (a *Api) track(f *flight, async bool) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed && !async {
+		return false
+	}
+	if a.flights == nil {
+		a.flights = make(map[*flight]struct{})
+	}
+	a.flights[f] = struct{}{}
+	a.wg.Add(1)
+	return true
+}
+
+func (a *Api) untrack(f *flight) {
+	a.mu.Lock()
+	_, ok := a.flights[f]
+	delete(a.flights, f)
+	a.mu.Unlock()
+	if ok {
+		f.cancel(nil)
+		a.wg.Done()
+	}
+}
+
+// Shutdown stops the Api from issuing new calls via Do and waits for the in-flight ones to finish,
+// and for the calls queued by DoAsync to be made. If ctx expires first, the outstanding requests
+// are canceled with the ErrShutdown cause, the calls left in the queue of DoAsync fail with it,
+// and ctx's error is returned once its workers are gone.
+// Idle connections of the client are closed in both cases. Request and RequestBytes
+// keep working after Shutdown, since they don't execute anything.
+func (a *Api) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+	defer a.client().CloseIdleConnections()
+	q := a.asyncQueue.Load()
+	if q != nil {
+		q.close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		if q != nil {
+			q.workers.Wait()
+		}
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if q != nil {
+			q.abort(ErrShutdown)
+		}
+		a.mu.Lock()
+		for f := range a.flights {
+			f.cancel(ErrShutdown)
+		}
+		a.mu.Unlock()
+		if q != nil {
+			q.workers.Wait()
+		}
+		return ctx.Err()
+	}
+}
+
+// flightBody releases the flight once the body is closed.
+type flightBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *flightBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	req, err := a.Request(GET, "/", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := a.Do(context.Background(), req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, a.Shutdown(context.Background()))
+}
+
+func TestShutdown(t *testing.T) {
+	arrived := make(chan struct{}, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			req, _ := a.Request(GET, "/slow", nil)
+			resp, err := a.Do(context.Background(), req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		<-arrived
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := a.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	for i := 0; i < 3; i++ {
+		err := <-errs
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, ErrShutdown)
+	}
+
+	req, err := a.Request(GET, "/fast", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = a.Do(context.Background(), req)
+	assert.Equal(t, ErrClientClosed, err)
+}
+
+func TestShutdownWaits(t *testing.T) {
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	errs := make(chan error, 1)
+	go func() {
+		req, _ := a.Request(GET, "/", nil)
+		resp, err := a.Do(context.Background(), req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		errs <- err
+	}()
+	<-arrived
+	time.AfterFunc(10*time.Millisecond, func() { close(release) })
+	assert.NoError(t, a.Shutdown(context.Background()))
+	assert.NoError(t, <-errs)
+}
@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrRangeMismatch is matched by every *RangeError.
+var ErrRangeMismatch = errors.New("api: range mismatch")
+
+// RangeError is returned by DownloadParallel when the server answers the request of a chunk with
+// another range, e.g. because the resource changed meanwhile.
+type RangeError struct {
+	Resource string
+	// Start and End are the offsets of the chunk requested, the end being inclusive.
+	Start, End int64
+	// ContentRange is the Content-Range of the response, empty if it had none.
+	ContentRange string
+}
+
+func (e *RangeError) Error() string {
+	got := e.ContentRange
+	if got == "" {
+		got = "no Content-Range"
+	}
+	return fmt.Sprintf("api: range mismatch downloading %s: requested bytes %d-%d, got %s", e.Resource, e.Start, e.End, got)
+}
+
+// Is makes errors.Is(err, ErrRangeMismatch) report true.
+func (e *RangeError) Is(target error) bool { return target == ErrRangeMismatch }
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *RangeError) Class() Class { return Permanent }
+
+// DownloadOptions configures DownloadParallel.
+type DownloadOptions struct {
+	// Concurrency is the number of chunks downloaded at once, 4 if zero.
+	Concurrency int
+	// ChunkSize is the size of the chunks, the size of the download divided by Concurrency if zero.
+	ChunkSize int64
+	// Retry is the retry policy of each chunk, 3 retries if nil. A chunk failing while its body is
+	// read is resumed from the last byte written.
+	Retry *RetryPolicy
+	// Progress, if set, is called with the number of bytes written so far and the size of the
+	// download, -1 if unknown, across all the chunks. It's called by one goroutine at a time.
+	Progress func(written, total int64)
+}
+
+// DownloadParallel downloads the resource with args in the query to dst, in chunks fetched
+// concurrently by ranged GET requests, and returns its size:
+//
+//	f, _ := os.Create("export.csv")
+//	n, err := a.DownloadParallel(ctx, "/exports/42", nil, f, &api.DownloadOptions{Concurrency: 8})
+//
+// The size and the support of ranges are learned by a HEAD request, or by a GET of the first byte
+// if the HEAD doesn't say. Each chunk is written at its offset once its Content-Range is checked,
+// and retried by DownloadOptions.Retry: the chunks aren't retried by the Retry policy of the Api.
+// If the resource has an ETag, the chunks are requested with If-Range so a resource changing
+// meanwhile fails with a *RangeError instead of mixing two versions. If the server doesn't
+// support ranges, the resource is streamed to dst by a single GET.
+// The first failing chunk cancels the others. The backoffs are timed by the clock of the Api.
+func (a *Api) DownloadParallel(ctx context.Context, resource string, args url.Values, dst io.WriterAt, opts *DownloadOptions) (int64, error) {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	d := &download{a: a, resource: resource, args: args, dst: dst, opts: opts, total: -1}
+	size, etag, resp, err := d.probe(ctx)
+	if err != nil {
+		return 0, err
+	}
+	d.total = size
+	if resp != nil {
+		// The server sent the whole resource to the probe.
+		defer resp.Body.Close()
+		return d.write(resp.Body, 0, -1)
+	}
+	if size < 0 {
+		return d.single(ctx)
+	}
+	d.etag = etag
+	return size, d.chunks(ctx)
+}
+
+type download struct {
+	a        *Api
+	resource string
+	args     url.Values
+	dst      io.WriterAt
+	opts     *DownloadOptions
+	total    int64
+	etag     string
+
+	mu      sync.Mutex
+	written int64
+}
+
+// probe returns the size of the resource and its ETag, -1 if it can't be downloaded by ranges,
+// or the response of the whole resource if the server sent it to the probe.
+func (d *download) probe(ctx context.Context) (int64, string, *http.Response, error) {
+	head, err := d.a.Request(HEAD, d.resource, d.args, buildContext(ctx))
+	if err != nil {
+		return 0, "", nil, err
+	}
+	resp, err := d.a.send(ctx, d.a.newCallFor(d.resource, nil), head)
+	if err == nil {
+		resp.Body.Close()
+		ranges := strings.ToLower(resp.Header.Get("Accept-Ranges"))
+		switch {
+		case ranges == "none":
+			return -1, "", nil, nil
+		case ranges == "bytes" && resp.ContentLength >= 0:
+			return resp.ContentLength, strongETag(resp.Header), nil, nil
+		}
+	}
+	req, err := d.request(ctx, 0, 0)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	resp, err = d.a.send(ctx, d.a.newCallFor(d.resource, nil), req)
+	var se *StatusError
+	if errors.As(err, &se) && se.Code == http.StatusRequestedRangeNotSatisfiable {
+		// An empty resource has no first byte.
+		if _, _, total, err := ParseContentRange(se.Header.Get("Content-Range")); err == nil && total == 0 {
+			return 0, "", nil, nil
+		}
+	}
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return -1, "", resp, nil
+	}
+	defer resp.Body.Close()
+	_, _, total, err := ParseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil || total < 0 {
+		return -1, "", nil, nil
+	}
+	return total, strongETag(resp.Header), nil, nil
+}
+
+// strongETag returns the ETag of h usable with If-Range, empty if it's missing or weak.
+func strongETag(h http.Header) string {
+	if etag := h.Get("ETag"); !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return ""
+}
+
+// single streams the resource to dst by a GET.
+func (d *download) single(ctx context.Context) (int64, error) {
+	req, err := d.a.Request(GET, d.resource, d.args, buildContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	resp, err := d.a.send(ctx, d.a.newCallFor(d.resource, nil), req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return d.write(resp.Body, 0, -1)
+}
+
+// chunks downloads the chunks of the resource concurrently.
+func (d *download) chunks(ctx context.Context) error {
+	size := d.opts.ChunkSize
+	workers := d.opts.Concurrency
+	if workers <= 0 {
+		workers = 4
+	}
+	if size <= 0 {
+		size = (d.total + int64(workers) - 1) / int64(workers)
+	}
+	size = max(size, 1)
+	starts := make(chan int64)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var once sync.Once
+	var failure error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range starts {
+				if err := d.chunk(ctx, start, min(start+size, d.total)-1); err != nil {
+					once.Do(func() {
+						failure = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+send:
+	for start := int64(0); start < d.total; start += size {
+		select {
+		case starts <- start:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(starts)
+	wg.Wait()
+	if failure == nil {
+		failure = ctx.Err()
+	}
+	return failure
+}
+
+// chunk downloads the bytes from start to end, inclusive, retrying from the last byte written.
+func (d *download) chunk(ctx context.Context, start, end int64) error {
+	policy := d.opts.Retry
+	if policy == nil {
+		policy = &RetryPolicy{MaxRetries: 3}
+	}
+	for attempt := 0; ; attempt++ {
+		n, err := d.fetch(ctx, start, end)
+		start += n
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		wait, ok := policy.backoff(attempt, err, d.a.clock().Now())
+		if !ok {
+			return err
+		}
+		if err := d.a.clock().Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// fetch makes a request for the bytes from start to end, returning the number of bytes written.
+func (d *download) fetch(ctx context.Context, start, end int64) (int64, error) {
+	req, err := d.request(ctx, start, end)
+	if err != nil {
+		return 0, err
+	}
+	if d.etag != "" {
+		req.Header.Set("If-Range", d.etag)
+	}
+	resp, err := d.a.send(ctx, d.a.newCallFor(d.resource, []Option{WithRetry(nil)}), req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	cr := resp.Header.Get("Content-Range")
+	first, last, total, err := ParseContentRange(cr)
+	if resp.StatusCode != http.StatusPartialContent || err != nil || first != start || last != end || total >= 0 && total != d.total {
+		return 0, &RangeError{Resource: d.resource, Start: start, End: end, ContentRange: cr}
+	}
+	return d.write(resp.Body, start, end-start+1)
+}
+
+// request creates the GET request of the bytes from start to end.
+func (d *download) request(ctx context.Context, start, end int64) (*http.Request, error) {
+	req, err := d.a.Request(GET, d.resource, d.args, buildContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10))
+	return req, nil
+}
+
+// writeError is a failure to write to the destination.
+type writeError struct {
+	err error
+}
+
+func (e *writeError) Error() string { return e.err.Error() }
+
+func (e *writeError) Unwrap() error { return e.err }
+
+// Class makes the error a Permanent failure: the chunk isn't retried.
+func (e *writeError) Class() Class { return Permanent }
+
+// write copies up to n bytes of r at offset off of the destination, all of r if n is negative,
+// returning the number of bytes written.
+func (d *download) write(r io.Reader, off, n int64) (int64, error) {
+	if n >= 0 {
+		r = io.LimitReader(r, n)
+	}
+	buf := make([]byte, 32<<10)
+	var written int64
+	for {
+		m, err := r.Read(buf)
+		if m > 0 {
+			if _, werr := d.dst.WriteAt(buf[:m], off+written); werr != nil {
+				return written, &writeError{err: werr}
+			}
+			written += int64(m)
+			d.progress(int64(m))
+		}
+		if err == io.EOF {
+			if n >= 0 && written < n {
+				return written, io.ErrUnexpectedEOF
+			}
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+func (d *download) progress(n int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.written += n
+	if d.opts.Progress != nil {
+		d.opts.Progress(d.written, d.total)
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadParallel(t *testing.T) {
+	data := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(data)
+	var mu sync.Mutex
+	var ranges []string
+	failed := map[string]bool{}
+	etag := `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		mu.Lock()
+		ranges = append(ranges, r.Method+" "+rng)
+		first := !failed[rng]
+		failed[rng] = true
+		tag := etag
+		mu.Unlock()
+		switch {
+		case first && rng == "bytes=32768-49151":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case first && rng == "bytes=65536-81919":
+			// The connection is cut in the middle of the chunk.
+			w.Header().Set("Content-Range", "bytes 65536-81919/100000")
+			w.Header().Set("Content-Length", "16384")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[65536:70000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set("ETag", tag)
+		http.ServeContent(w, r, "export.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "export.bin")
+	f, err := os.Create(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	var progress []int64
+	n, err := a.DownloadParallel(ctx, "/export", nil, f, &DownloadOptions{Concurrency: 3, ChunkSize: 16384,
+		Retry: &RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond}, Progress: func(written, total int64) {
+			assert.Equal(t, int64(len(data)), total)
+			progress = append(progress, written)
+		}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(len(data)), n)
+	got, _ := os.ReadFile(path)
+	assert.True(t, bytes.Equal(data, got), "the download differs")
+	assert.Equal(t, int64(len(data)), progress[len(progress)-1])
+	mu.Lock()
+	assert.Equal(t, "HEAD ", ranges[0])
+	assert.Len(t, ranges, 1+7+2)
+	assert.Contains(t, ranges, "GET bytes=70000-81919")
+	mu.Unlock()
+
+	// The resource changing meanwhile fails the download.
+	mu.Lock()
+	etag = `"v2"`
+	mu.Unlock()
+	d := &download{a: a, resource: "/export", dst: f, opts: &DownloadOptions{}, total: int64(len(data)), etag: `"v1"`}
+	err = d.chunk(ctx, 0, 99)
+	var re *RangeError
+	if assert.True(t, errors.As(err, &re), "%v", err) {
+		assert.Equal(t, "", re.ContentRange)
+	}
+	assert.True(t, errors.Is(err, ErrRangeMismatch))
+}
+
+func TestDownloadParallelNoRanges(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method)
+		w.Write([]byte(strings.Repeat("x", 5000)))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	var buf writerAt
+	n, err := a.DownloadParallel(context.Background(), "/export", nil, &buf, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5000), n)
+	assert.Equal(t, strings.Repeat("x", 5000), string(buf.data))
+	assert.Equal(t, []string{"HEAD", "GET"}, requests)
+}
+
+// writerAt is an io.WriterAt growing a buffer.
+type writerAt struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if end := int(off) + len(p); end > len(w.data) {
+		w.data = append(w.data, make([]byte, end-len(w.data))...)
+	}
+	return copy(w.data[off:], p), nil
+}
@@ -0,0 +1,330 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DualRead compares the reads of a resource with the ones of a secondary resource, e.g. the same
+// resource of the next version of an API during a migration, see SetDualRead.
+type DualRead struct {
+	// Resource is the template of the secondary resource, e.g. "/v2/users/{id}", its parameters
+	// taking the values of the ones of the same name of the read; the resource of the read if
+	// empty.
+	Resource string
+	// Api sends the secondary requests, e.g. one for the base URI of the next version; the Api of
+	// the reads if nil.
+	Api *Api
+	// Rate is the share of the reads compared, from 0 to 1.
+	Rate float64
+	// Rand is the source of the sampling, the global source of math/rand if nil. Set it to a
+	// seeded source to get a deterministic sampling in tests.
+	Rand *rand.Rand
+	// Timeout bounds each secondary request, 10s if zero.
+	Timeout time.Duration
+	// New returns the value the secondary body is decoded into, a pointer to a new value of the
+	// type of the one of the read if nil.
+	New func() interface{}
+	// Compare normalizes and compares the decoded values of the read and of the secondary
+	// request, returning their differences, empty if they match. If nil, they match when
+	// reflect.DeepEqual reports them equal.
+	Compare func(primary, secondary interface{}) string
+	// Report receives the mismatches and the failures of the comparisons, from the goroutine
+	// of the comparisons.
+	Report func(DualReadResult)
+	// QueueSize bounds the comparisons waiting for their secondary request, 64 if zero. The
+	// reads sampled while it's full aren't compared, and are counted as Dropped.
+	QueueSize int
+}
+
+// DualReadResult is a mismatch or a failure reported by DualRead.Report.
+type DualReadResult struct {
+	// Resource is the resource of the read, and SecondaryResource the one of the secondary request.
+	Resource, SecondaryResource string
+	// Primary and Secondary are the decoded values compared, Secondary being nil for failures.
+	Primary, Secondary interface{}
+	// Diff is the difference returned by Compare, empty for failures.
+	Diff string
+	// Err is the error of the secondary request, or of decoding the body of the read again, nil
+	// for mismatches.
+	Err error
+	// Latency is the time the secondary request took.
+	Latency time.Duration
+}
+
+// DualReadStats counts the reads of a resource compared by SetDualRead.
+type DualReadStats struct {
+	// Sampled counts the reads sampled, Dropped the ones of them not compared for a full queue.
+	Sampled, Dropped int64
+	// Matched and Mismatched count the comparisons made, and Failed the ones which couldn't be
+	// made, for the failure of the secondary request or of decoding the read again.
+	Matched, Mismatched, Failed int64
+}
+
+// SetDualRead makes a share of the GET reads of the resource, a template like DeclareParams ones,
+// made by DoJSON, the Do-style helpers decoding JSON and the Templates, be read again from the
+// secondary resource of d, and the two decoded values compared, e.g. to validate a migration from
+// v1 to v2 before switching:
+//
+//	err := a.SetDualRead("/v1/users/{id}", &api.DualRead{Resource: "/v2/users/{id}", Rate: 0.05,
+//		Report: func(r api.DualReadResult) { log.Printf("%s: %s %v", r.Resource, r.Diff, r.Err) }})
+//
+// The caller always gets the value of its read, decoded as usual, and never waits for the
+// comparison: the body of the read is decoded again into a value of its own, and the secondary
+// request is sent from a goroutine of the Api once the read succeeded, with the query of the
+// read, through the Do-style machinery of the secondary Api, retries included. Its failures and
+// timeouts are only reported to d.Report and counted, see DualReadStats. A nil d removes the
+// dual read of the resource.
+func (a *Api) SetDualRead(resource string, d *DualRead) error {
+	var dr *dualRead
+	if d != nil {
+		if d.Rate < 0 || d.Rate > 1 {
+			return fmt.Errorf("api: dual read rate must be within 0 and 1, got %v", d.Rate)
+		}
+		dr = &dualRead{policy: *d, template: resource}
+		if dr.policy.Resource == "" {
+			dr.policy.Resource = resource
+		}
+		if dr.policy.Timeout <= 0 {
+			dr.policy.Timeout = 10 * time.Second
+		}
+		if dr.policy.QueueSize <= 0 {
+			dr.policy.QueueSize = 64
+		}
+		dr.queue = make(chan *dualJob, dr.policy.QueueSize)
+		dr.ctx, dr.stop = context.WithCancel(context.Background())
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	reads := make(map[string]*dualRead, len(a.dualReads)+1)
+	for tmpl, r := range a.dualReads {
+		reads[tmpl] = r
+	}
+	if old := reads[resource]; old != nil {
+		// Stops the goroutine of the comparisons replaced, if started.
+		old.stop()
+	}
+	if dr == nil {
+		delete(reads, resource)
+	} else {
+		reads[resource] = dr
+	}
+	a.dualReads = reads
+	return nil
+}
+
+// DualReadStats returns the counters of the dual read of the resource set by SetDualRead, zero
+// if it has none.
+func (a *Api) DualReadStats(resource string) DualReadStats {
+	a.mu.Lock()
+	dr := a.dualReads[resource]
+	a.mu.Unlock()
+	if dr == nil {
+		return DualReadStats{}
+	}
+	return DualReadStats{Sampled: dr.sampled.Load(), Dropped: dr.dropped.Load(), Matched: dr.matched.Load(),
+		Mismatched: dr.mismatched.Load(), Failed: dr.failed.Load()}
+}
+
+// dualRead is the state of the DualRead of a resource template.
+type dualRead struct {
+	policy   DualRead
+	template string
+	queue    chan *dualJob
+	start    sync.Once
+	// ctx is the context of the goroutine of the comparisons, canceled by stop once the dual read
+	// is replaced or removed.
+	ctx  context.Context
+	stop context.CancelFunc
+
+	mu sync.Mutex
+
+	sampled, dropped, matched, mismatched, failed atomic.Int64
+}
+
+// dualJob is a read waiting for its comparison.
+type dualJob struct {
+	resource string
+	query    string
+	// body is the body of the read, decoded again into a new value of typ.
+	body   []byte
+	typ    reflect.Type
+	strict bool
+}
+
+// dualReadFor returns the dual read of the call c of req decoding resp into out if it's
+// sampled, nil otherwise.
+func (a *Api) dualReadFor(c *call, req *http.Request, resp *http.Response, out interface{}) *dualRead {
+	if req.Method != http.MethodGet || out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if _, ok := out.(*Targets); ok {
+		return nil
+	}
+	a.mu.Lock()
+	reads := a.dualReads
+	a.mu.Unlock()
+	if len(reads) == 0 {
+		return nil
+	}
+	dr := reads[c.template]
+	if dr == nil {
+		for tmpl, r := range reads {
+			if matchTemplate(tmpl, c.resource) {
+				dr = r
+				break
+			}
+		}
+	}
+	if dr == nil || !dr.sample() {
+		return nil
+	}
+	dr.sampled.Add(1)
+	return dr
+}
+
+// sample reports whether a read is compared.
+func (dr *dualRead) sample() bool {
+	if dr.policy.Rate >= 1 {
+		return true
+	}
+	if dr.policy.Rand == nil {
+		return rand.Float64() < dr.policy.Rate
+	}
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return dr.policy.Rand.Float64() < dr.policy.Rate
+}
+
+// tee keeps the bytes of the body of resp read by the decoding in buf.
+func (dr *dualRead) tee(resp *http.Response, buf *bytes.Buffer) {
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(resp.Body, buf), resp.Body}
+}
+
+// enqueueDualRead queues the comparison of the read of the call c decoded from body into out,
+// without waiting for room in the queue.
+func (a *Api) enqueueDualRead(dr *dualRead, c *call, req *http.Request, body []byte, out interface{}) {
+	typ := reflect.TypeOf(out)
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	job := &dualJob{resource: c.resource, query: req.URL.RawQuery, body: body, typ: typ, strict: c.strict}
+	dr.start.Do(func() { a.goBackground(dr.ctx, dr.work(a)) })
+	select {
+	case dr.queue <- job:
+	default:
+		dr.dropped.Add(1)
+	}
+}
+
+// work returns the loop of the goroutine making the comparisons of dr.
+func (dr *dualRead) work(a *Api) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		for {
+			select {
+			case job := <-dr.queue:
+				dr.compare(ctx, a, job)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// compare sends the secondary request of job and compares its value with the one of the read.
+func (dr *dualRead) compare(ctx context.Context, a *Api, job *dualJob) {
+	p := &dr.policy
+	primary := reflect.New(job.typ).Interface()
+	dec := json.NewDecoder(bytes.NewReader(job.body))
+	if job.strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(primary); err != nil {
+		dr.failed.Add(1)
+		dr.report(DualReadResult{Resource: job.resource, Err: fmt.Errorf("api: decoding the read again: %w", err)})
+		return
+	}
+	secondary := reflect.New(job.typ).Interface()
+	if p.New != nil {
+		secondary = p.New()
+	}
+	res := DualReadResult{Resource: job.resource, Primary: primary,
+		SecondaryResource: expandParams(p.Resource, templateParams(dr.template, job.resource))}
+	sec := p.Api
+	if sec == nil {
+		sec = a
+	}
+	clk := sec.clock()
+	start := clk.Now()
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	err := dr.read(ctx, sec, res.SecondaryResource, job.query, secondary)
+	res.Latency = clk.Now().Sub(start)
+	if err != nil {
+		dr.failed.Add(1)
+		res.Err = err
+	} else {
+		res.Secondary = secondary
+		if p.Compare != nil {
+			res.Diff = p.Compare(primary, secondary)
+		} else if !reflect.DeepEqual(primary, secondary) {
+			res.Diff = "values differ"
+		}
+		if res.Diff == "" {
+			dr.matched.Add(1)
+			return
+		}
+		dr.mismatched.Add(1)
+	}
+	dr.report(res)
+}
+
+func (dr *dualRead) report(res DualReadResult) {
+	if dr.policy.Report != nil {
+		dr.policy.Report(res)
+	}
+}
+
+// read reads the secondary resource with the query into out.
+func (dr *dualRead) read(ctx context.Context, a *Api, resource, query string, out interface{}) error {
+	req, err := a.Request(GET, resource, nil)
+	if err != nil {
+		return err
+	}
+	req.URL.RawQuery = query
+	c := a.newCallFor(resource, nil)
+	resp, err := a.send(ctx, c, req)
+	if err != nil {
+		return err
+	}
+	return a.wrapError(c, req, decodeResponse(c, req, resp, out))
+}
+
+// templateParams returns the values of the parameters of tmpl in resource, whose segments they are.
+func templateParams(tmpl, resource string) map[string]string {
+	if i := strings.IndexAny(resource, "?#"); i >= 0 {
+		resource = resource[:i]
+	}
+	ts := strings.Split(strings.Trim(tmpl, "/"), "/")
+	rs := strings.Split(strings.Trim(resource, "/"), "/")
+	params := make(map[string]string)
+	for i, seg := range ts {
+		if i < len(rs) && strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			params[seg[1:len(seg)-1]] = rs[i]
+		}
+	}
+	return params
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type dualUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// dualReadServer serves the users of v1 and v2, v2 returning the name of user 2 in upper case and
+// failing for user 3.
+func dualReadServer(t *testing.T) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var v2 []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		var id int
+		if _, err := fmt.Sscanf(r.URL.Path, "/v2/users/%d", &id); err == nil {
+			mu.Lock()
+			v2 = append(v2, r.URL.RequestURI())
+			mu.Unlock()
+			switch id {
+			case 2:
+				w.Write([]byte(`{"id": 2, "name": "BOB"}`))
+			case 3:
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				fmt.Fprintf(w, `{"id": %d, "name": "Ann", "extra": true}`, id)
+			}
+			return
+		}
+		fmt.Sscanf(r.URL.Path, "/v1/users/%d", &id)
+		names := map[int]string{2: "Bob", 3: "Cid"}
+		name := names[id]
+		if name == "" {
+			name = "Ann"
+		}
+		fmt.Fprintf(w, `{"id": %d, "name": %q}`, id, name)
+	}))
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), v2...)
+	}
+}
+
+// dualReports collects the results reported by a DualRead.
+type dualReports struct {
+	ch chan DualReadResult
+}
+
+func (r *dualReports) report(res DualReadResult) { r.ch <- res }
+
+func (r *dualReports) next(t *testing.T) DualReadResult {
+	t.Helper()
+	select {
+	case res := <-r.ch:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("no report")
+		return DualReadResult{}
+	}
+}
+
+// waitDualRead waits for the dual read of resource to have settled n comparisons.
+func waitDualRead(t *testing.T, a *Api, resource string, n int64) DualReadStats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := a.DualReadStats(resource)
+		if s.Matched+s.Mismatched+s.Failed >= n || time.Now().After(deadline) {
+			return s
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDualReadMatch(t *testing.T) {
+	srv, v2 := dualReadServer(t)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	defer a.Close()
+	reports := &dualReports{ch: make(chan DualReadResult, 10)}
+	assert.NoError(t, a.SetDualRead("/v1/users/{id}", &DualRead{Resource: "/v2/users/{id}", Rate: 1, Report: reports.report}))
+
+	var u dualUser
+	assert.NoError(t, a.Get(context.Background(), "/v1/users/1", url.Values{"fields": {"name"}}, &u))
+	assert.Equal(t, dualUser{ID: 1, Name: "Ann"}, u)
+	s := waitDualRead(t, a, "/v1/users/{id}", 1)
+	assert.Equal(t, DualReadStats{Sampled: 1, Matched: 1}, s)
+	assert.Equal(t, []string{"/v2/users/1?fields=name"}, v2())
+
+	// The reads made from a Template are compared too, the others aren't.
+	tmpl := a.Template(GET, "/v1/users/{id}")
+	assert.NoError(t, tmpl.Do(context.Background(), map[string]string{"id": "4"}, nil, &u))
+	assert.NoError(t, a.Get(context.Background(), "/v1/other/1", nil, &u))
+	s = waitDualRead(t, a, "/v1/users/{id}", 2)
+	assert.Equal(t, DualReadStats{Sampled: 2, Matched: 2}, s)
+	assert.Len(t, reports.ch, 0)
+
+	assert.Error(t, a.SetDualRead("/v1/users/{id}", &DualRead{Rate: 2}))
+	assert.NoError(t, a.SetDualRead("/v1/users/{id}", nil))
+	assert.Equal(t, DualReadStats{}, a.DualReadStats("/v1/users/{id}"))
+}
+
+func TestDualReadMismatch(t *testing.T) {
+	srv, _ := dualReadServer(t)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	defer a.Close()
+	reports := &dualReports{ch: make(chan DualReadResult, 10)}
+	assert.NoError(t, a.SetDualRead("/v1/users/{id}", &DualRead{Resource: "/v2/users/{id}", Rate: 1, Report: reports.report,
+		Compare: func(primary, secondary interface{}) string {
+			p, s := primary.(*dualUser), secondary.(*dualUser)
+			if p.Name != s.Name {
+				return fmt.Sprintf("name: %q != %q", p.Name, s.Name)
+			}
+			return ""
+		}}))
+
+	var u dualUser
+	assert.NoError(t, a.Get(context.Background(), "/v1/users/2", nil, &u))
+	// The caller's value is its own.
+	u.Name = "changed"
+	res := reports.next(t)
+	assert.Equal(t, "/v1/users/2", res.Resource)
+	assert.Equal(t, "/v2/users/2", res.SecondaryResource)
+	assert.Equal(t, `name: "Bob" != "BOB"`, res.Diff)
+	assert.Equal(t, &dualUser{ID: 2, Name: "Bob"}, res.Primary)
+	assert.Equal(t, &dualUser{ID: 2, Name: "BOB"}, res.Secondary)
+	assert.NoError(t, res.Err)
+	assert.Equal(t, DualReadStats{Sampled: 1, Mismatched: 1}, a.DualReadStats("/v1/users/{id}"))
+
+	// Without Compare, the values are compared with reflect.DeepEqual, into the values of New.
+	assert.NoError(t, a.SetDualRead("/v1/users/{id}", &DualRead{Resource: "/v2/users/{id}", Rate: 1, Report: reports.report,
+		New: func() interface{} { return new(map[string]interface{}) }}))
+	var m map[string]interface{}
+	assert.NoError(t, a.Get(context.Background(), "/v1/users/1", nil, &m))
+	res = reports.next(t)
+	assert.Equal(t, "values differ", res.Diff)
+	assert.Equal(t, &map[string]interface{}{"id": float64(1), "name": "Ann", "extra": true}, res.Secondary)
+}
+
+func TestDualReadSecondaryFailure(t *testing.T) {
+	srv, _ := dualReadServer(t)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	defer a.Close()
+	reports := &dualReports{ch: make(chan DualReadResult, 10)}
+	hang := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(hang)
+	secondary := MustNew(slow.URL)
+	defer secondary.Close()
+	assert.NoError(t, a.SetDualRead("/v1/users/{id}", &DualRead{Resource: "/v2/users/{id}", Rate: 1, Report: reports.report}))
+
+	// The failure of the secondary request is only reported.
+	var u dualUser
+	assert.NoError(t, a.Get(context.Background(), "/v1/users/3", nil, &u))
+	assert.Equal(t, dualUser{ID: 3, Name: "Cid"}, u)
+	res := reports.next(t)
+	var se *StatusError
+	assert.ErrorAs(t, res.Err, &se)
+	assert.Nil(t, res.Secondary)
+	assert.Equal(t, DualReadStats{Sampled: 1, Failed: 1}, a.DualReadStats("/v1/users/{id}"))
+
+	// Neither does a secondary Api that hangs hold the reads, nor do the reads sampled while the
+	// queue is full wait for room.
+	assert.NoError(t, a.SetDualRead("/v1/users/{id}", &DualRead{Api: secondary, Rate: 1, Report: reports.report,
+		Timeout: 50 * time.Millisecond, QueueSize: 1}))
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, a.Get(context.Background(), "/v1/users/1", nil, &u))
+	}
+	res = reports.next(t)
+	assert.ErrorIs(t, res.Err, context.DeadlineExceeded)
+	assert.Equal(t, "/v1/users/1", res.SecondaryResource)
+	s := a.DualReadStats("/v1/users/{id}")
+	assert.Equal(t, int64(5), s.Sampled)
+	assert.GreaterOrEqual(t, s.Dropped, int64(3))
+}
+
+func TestDualReadSampling(t *testing.T) {
+	srv, v2 := dualReadServer(t)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	defer a.Close()
+	assert.NoError(t, a.SetDualRead("/v1/users/{id}", &DualRead{Resource: "/v2/users/{id}", Rate: 0.3, Rand: rand.New(rand.NewSource(1))}))
+
+	// The same seed samples the same reads.
+	want := rand.New(rand.NewSource(1))
+	var sampled []string
+	for i := 0; i < 20; i++ {
+		if want.Float64() < 0.3 {
+			sampled = append(sampled, fmt.Sprintf("/v2/users/%d", i))
+		}
+		var u dualUser
+		assert.NoError(t, a.Get(context.Background(), fmt.Sprintf("/v1/users/%d", i), nil, &u))
+		// One read at a time, so the comparisons come in order.
+		waitDualRead(t, a, "/v1/users/{id}", int64(len(sampled)))
+	}
+	assert.NotEmpty(t, sampled)
+	assert.Less(t, len(sampled), 20)
+	assert.Equal(t, sampled, v2())
+	assert.Equal(t, int64(len(sampled)), a.DualReadStats("/v1/users/{id}").Sampled)
+}
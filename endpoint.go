@@ -0,0 +1,28 @@
+package api
+
+// Endpoint declares a call of an API in the table read by cmd/apigen, which generates a typed
+// client calling it through a RequestTemplate. The table is a package-level variable:
+//
+//	//go:generate go run github.com/xlab/api/cmd/apigen -table Endpoints -client Client
+//
+//	var Endpoints = []api.Endpoint{
+//		{Name: "GetUser", Method: api.GET, Path: "/users/{id}", Params: GetUserParams{}, Response: User{}},
+//		{Name: "CreateUser", Method: api.POST, Path: "/users", Body: NewUser{}, Response: User{}},
+//	}
+//
+// The generator reads the table from the source, so Params, Body and Response must be composite
+// literals of their types, e.g. User{}, &User{} or []User{}.
+type Endpoint struct {
+	// Name is the name of the generated method.
+	Name string
+	// Method and Path are those of the template, e.g. "/users/{id}".
+	Method Method
+	Path   string
+	// Params is a struct whose fields tagged `path:"id"` fill the parameters of Path, and those
+	// tagged `query:"name"` or `query:"name,omitempty"` the query. Nil if there are none.
+	Params interface{}
+	// Body is sent encoded as JSON, nil for none.
+	Body interface{}
+	// Response is decoded from the JSON response, nil if the body is discarded.
+	Response interface{}
+}
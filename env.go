@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// env is the active environment of an Api created by NewMulti.
+type env struct {
+	name string
+	base *url.URL
+}
+
+// NewMulti creates a new api instance that knows several environments (e.g. "prod" and "sandbox")
+// by their base uris, starting with the given one. Use UseEnv to switch between them.
+func NewMulti(envs map[string]string, current string) (a *Api, err error) {
+	a = &Api{envs: make(map[string]*url.URL, len(envs))}
+	for name, uri := range envs {
+		if name == "" {
+			return nil, fmt.Errorf("api: empty environment name for %s", uri)
+		}
+		if a.envs[name], err = parseBase(uri); err != nil {
+			return nil, fmt.Errorf("api: environment %s: %w", name, err)
+		}
+	}
+	base, ok := a.envs[current]
+	if !ok {
+		return nil, fmt.Errorf("api: unknown environment: %s", current)
+	}
+	a.BaseURI = base
+	a.env.Store(&env{name: current, base: base})
+	return a, nil
+}
+
+// UseEnv atomically switches the base uri to the one of the named environment.
+// Requests built before the switch keep their original base. If a guard is set via GuardEnv,
+// it is consulted first and its error aborts the switch.
+func (a *Api) UseEnv(name string) error {
+	base, ok := a.envs[name]
+	if !ok {
+		return fmt.Errorf("api: unknown environment: %s (known: %v)", name, a.Envs())
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	from := a.Env()
+	if a.guard != nil {
+		if err := a.guard(from, name); err != nil {
+			return fmt.Errorf("api: switching environment %s -> %s: %w", from, name, err)
+		}
+	}
+	a.env.Store(&env{name: name, base: base})
+	return nil
+}
+
+// GuardEnv sets a callback that approves every environment switch made by UseEnv,
+// e.g. refusing to switch to production unless explicitly enabled.
+func (a *Api) GuardEnv(guard func(from, to string) error) {
+	a.mu.Lock()
+	a.guard = guard
+	a.mu.Unlock()
+}
+
+// Env returns the name of the current environment, or an empty string if the Api
+// wasn't created by NewMulti.
+func (a *Api) Env() string {
+	if e := a.env.Load(); e != nil {
+		return e.name
+	}
+	return ""
+}
+
+// Envs returns the sorted names of the known environments.
+func (a *Api) Envs() []string {
+	names := make([]string, 0, len(a.envs))
+	for name := range a.envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// baseURI returns the base uri of the current environment, or BaseURI for single-base Apis.
+func (a *Api) baseURI() *url.URL {
+	if e := a.env.Load(); e != nil {
+		return e.base
+	}
+	return a.BaseURI
+}
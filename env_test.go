@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMulti(t *testing.T) {
+	a, err := NewMulti(map[string]string{
+		"prod":    "https://api.example.com/v1",
+		"sandbox": "https://sandbox.example.com/v1",
+	}, "sandbox")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "sandbox", a.Env())
+	assert.Equal(t, []string{"prod", "sandbox"}, a.Envs())
+	req, err := a.Request(GET, "/items", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://sandbox.example.com/v1/items", req.URL.String())
+
+	assert.NoError(t, a.UseEnv("prod"))
+	assert.Equal(t, "prod", a.Env())
+	req2, err := a.Request(GET, "/items", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.example.com/v1/items", req2.URL.String())
+	assert.Equal(t, "https://sandbox.example.com/v1/items", req.URL.String())
+
+	assert.Error(t, a.UseEnv("staging"))
+
+	_, err = NewMulti(map[string]string{"prod": "https://api.example.com"}, "sandbox")
+	assert.Error(t, err)
+	_, err = NewMulti(map[string]string{"prod": "api.example.com"}, "prod")
+	assert.Error(t, err)
+}
+
+func TestUseEnvGuard(t *testing.T) {
+	a, err := NewMulti(map[string]string{
+		"prod":    "https://api.example.com",
+		"sandbox": "https://sandbox.example.com",
+	}, "sandbox")
+	if !assert.NoError(t, err) {
+		return
+	}
+	errProd := errors.New("set API_ALLOW_PROD to use prod")
+	a.GuardEnv(func(from, to string) error {
+		if to == "prod" && os.Getenv("API_ALLOW_PROD_TEST") == "" {
+			return errProd
+		}
+		return nil
+	})
+	err = a.UseEnv("prod")
+	assert.ErrorIs(t, err, errProd)
+	assert.Equal(t, "sandbox", a.Env())
+}
+
+func TestUseEnvConcurrent(t *testing.T) {
+	a, err := NewMulti(map[string]string{
+		"a": "https://a.example.com",
+		"b": "https://b.example.com",
+	}, "a")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				a.UseEnv([]string{"a", "b"}[(i+j)%2])
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				req, err := a.Request(GET, "/x", nil)
+				if assert.NoError(t, err) {
+					host := req.URL.Host
+					assert.True(t, host == "a.example.com" || host == "b.example.com", host)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
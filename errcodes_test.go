@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errDuplicate = errors.New("duplicate")
+
+func vendorServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/duplicate":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": "ERR_DUPLICATE", "message": "already exists"}}`))
+		case "/unknown":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": "ERR_WHATEVER", "message": "?"}}`))
+		case "/numeric":
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"status": "fail", "errors": [{"code": 1062}]}`))
+		case "/ok-with-error":
+			w.Write([]byte(`{"error": {"code": "ERR_DUPLICATE"}}`))
+		case "/ok":
+			w.Write([]byte(`{"id": 1, "error": null}`))
+		}
+	}))
+}
+
+func TestMapError(t *testing.T) {
+	srv := vendorServer()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.MapError("ERR_DUPLICATE", errDuplicate)
+
+	err := a.Post(context.Background(), "/duplicate", nil, nil)
+	assert.True(t, errors.Is(err, errDuplicate))
+	var ve *VendorError
+	var se *StatusError
+	if assert.True(t, errors.As(err, &ve)) && assert.True(t, errors.As(err, &se)) {
+		assert.Same(t, se, ve.Status)
+		assert.Equal(t, http.StatusBadRequest, se.Code)
+		assert.Equal(t, "ERR_DUPLICATE", se.ErrorCode)
+		assert.Contains(t, string(se.Body), "already exists")
+	}
+	assert.EqualError(t, err, "api: ERR_DUPLICATE: duplicate (status 400 Bad Request)")
+	assert.Equal(t, Permanent, Classify(err))
+
+	err = a.Post(context.Background(), "/unknown", nil, nil)
+	assert.False(t, errors.Is(err, errDuplicate))
+	assert.False(t, errors.As(err, &ve))
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, "ERR_WHATEVER", se.ErrorCode)
+	}
+
+	// 2xx bodies aren't looked at unless asked to.
+	var out struct{ Error interface{} }
+	assert.NoError(t, a.Get(context.Background(), "/ok-with-error", nil, &out))
+	assert.NotNil(t, out.Error)
+}
+
+func TestMapErrorSuccess(t *testing.T) {
+	srv := vendorServer()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetErrorCodePath("error.code", true)
+	a.MapError("ERR_DUPLICATE", errDuplicate)
+
+	var out struct{ ID int }
+	err := a.Get(context.Background(), "/ok-with-error", nil, &out)
+	assert.True(t, errors.Is(err, errDuplicate))
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, http.StatusOK, se.Code)
+	}
+
+	assert.NoError(t, a.Get(context.Background(), "/ok", nil, &out))
+	assert.Equal(t, 1, out.ID)
+
+	a.SetErrorCodePath("errors.0.code", false)
+	a.MapError("1062", errDuplicate)
+	err = a.Post(context.Background(), "/numeric", nil, nil)
+	assert.True(t, errors.Is(err, errDuplicate))
+	assert.NoError(t, a.Get(context.Background(), "/ok-with-error", nil, &out))
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// ErrorInto makes the call decode the JSON body of a failed response into a new value of the type
+// of prototype, kept as the Detail of the *StatusError, so that errors.As finds it:
+//
+//	type ValidationError struct {
+//		Fields []struct{ Name, Code string } `json:"fields"`
+//	}
+//	func (e ValidationError) Error() string { ... }
+//
+//	err := a.Post(ctx, "/users", user, nil, api.ErrorInto(ValidationError{}))
+//	var ve ValidationError
+//	if errors.As(err, &ve) { ... }
+//
+// The type must implement error; a pointer prototype like &ValidationError{} makes the Detail a
+// pointer. A body that isn't valid JSON for the type, or was cut at the limit of WithErrorBody,
+// leaves the Detail nil, the *StatusError still holding the raw body. Use SetDefaults for the
+// error type of every call.
+func ErrorInto(prototype interface{}) Option {
+	return func(c *call) {
+		t := reflect.TypeOf(prototype)
+		if t == nil || !t.Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+			c.fail(fmt.Errorf("api: ErrorInto: %T doesn't implement error", prototype))
+			return
+		}
+		c.errorType = t
+	}
+}
+
+// decodeDetail decodes body into a new value of t, nil if it isn't valid JSON for t.
+func decodeDetail(t reflect.Type, body []byte) error {
+	v := reflect.New(t)
+	if err := json.Unmarshal(body, v.Interface()); err != nil {
+		return nil
+	}
+	if t.Kind() == reflect.Pointer && v.Elem().IsNil() {
+		// A null body.
+		return nil
+	}
+	return v.Elem().Interface().(error)
+}
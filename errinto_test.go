@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testValidationError struct {
+	Message string `json:"message"`
+	Fields  []struct {
+		Name string `json:"name"`
+		Code string `json:"code"`
+	} `json:"fields"`
+}
+
+func (e testValidationError) Error() string { return e.Message }
+
+func TestErrorInto(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"message":"invalid user","fields":[{"name":"email","code":"taken"}]}`))
+		case "/html":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`<html><title>Unprocessable</title></html>`))
+		default:
+			w.Write([]byte(`{"message":"fine"}`))
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	err := a.Post(ctx, "/users", nil, nil, ErrorInto(testValidationError{}))
+	var ve testValidationError
+	if assert.True(t, errors.As(err, &ve)) {
+		assert.Equal(t, "invalid user", ve.Message)
+		if assert.Len(t, ve.Fields, 1) {
+			assert.Equal(t, "email", ve.Fields[0].Name)
+			assert.Equal(t, "taken", ve.Fields[0].Code)
+		}
+	}
+	var se *StatusError
+	if assert.ErrorAs(t, err, &se) {
+		assert.Equal(t, http.StatusUnprocessableEntity, se.Code)
+		assert.Equal(t, ve, se.Detail)
+	}
+
+	a.SetDefaults(ErrorInto(&testValidationError{}))
+	err = a.Post(ctx, "/users", nil, nil)
+	var pve *testValidationError
+	if assert.True(t, errors.As(err, &pve)) {
+		assert.Equal(t, "invalid user", pve.Message)
+	}
+
+	// Bodies that don't decode leave the plain StatusError.
+	err = a.Post(ctx, "/html", nil, nil)
+	assert.False(t, errors.As(err, &pve))
+	if assert.ErrorAs(t, err, &se) {
+		assert.Nil(t, se.Detail)
+		assert.Equal(t, `<html><title>Unprocessable</title></html>`, string(se.RawBody()))
+	}
+
+	var out testValidationError
+	assert.NoError(t, a.Get(ctx, "/ok", nil, &out))
+	assert.Equal(t, "fine", out.Message)
+
+	err = a.Get(ctx, "/ok", nil, nil, ErrorInto(struct{}{}))
+	assert.EqualError(t, err, "api: ErrorInto: struct {} doesn't implement error")
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxErrorBody limits how much of a non-2xx response body is kept in StatusError, see WithErrorBody.
+const maxErrorBody = 64 << 10
+
+// maxSummary limits the length of the body summary in StatusError's message.
+const maxSummary = 512
+
+// StatusError is returned by the Do-style helpers when the API responds with a non-2xx status code.
+type StatusError struct {
+	// Code is the HTTP status code, e.g. 404.
+	Code int
+	// Status is the HTTP status line, e.g. "404 Not Found".
+	Status string
+	// Header is the response header.
+	Header http.Header
+	// Body holds up to 64KB of the response body, or the limit set by WithErrorBody.
+	Body []byte
+	// Size is the length of the whole response body, or -1 if it's unknown.
+	// A Size below len(Body) is treated as unknown.
+	Size int64
+	// Class is set when the Api's Classifier has classified the response explicitly.
+	Class Class
+	// ErrorCode is the vendor error code found in the body, see MapError and SetErrorCodePath.
+	ErrorCode string
+	// Detail is the error decoded from the body for ErrorInto, nil if it wasn't decoded.
+	Detail error
+	// Tunneled is set when Code is the status tunneled in the body of a 2xx response, see
+	// SetStatusTunnel.
+	Tunneled bool
+}
+
+// ErrNotFound is matched by the *StatusErrors of a 404 Not Found, tunneled ones included.
+var ErrNotFound = errors.New("api: not found")
+
+// Is makes errors.Is(err, ErrNotFound) report true for a 404.
+func (e *StatusError) Is(target error) bool {
+	return target == ErrNotFound && e.Code == http.StatusNotFound
+}
+
+func (e *StatusError) Error() string {
+	if s := e.Summary(); s != "" {
+		return fmt.Sprintf("api: unexpected status %s: %s", e.Status, s)
+	}
+	return fmt.Sprintf("api: unexpected status %s", e.Status)
+}
+
+// Unwrap returns the Detail decoded for ErrorInto, so that errors.As finds it.
+func (e *StatusError) Unwrap() error {
+	return e.Detail
+}
+
+// RawBody returns the captured response body as is.
+func (e *StatusError) RawBody() []byte {
+	return e.Body
+}
+
+// Summary describes the captured body for humans, depending on its content type:
+// JSON is compacted, HTML is reduced to its title and first heading, text is kept as is
+// and binary data is shown as a hex preview. Long summaries and truncated bodies are marked:
+//
+//	{"error":"not_found","id":42}
+//	html: title "502 Bad Gateway", heading "Bad Gateway"
+//	binary: 89504e470d0a1a0a0000000d49484452... (1024 bytes)
+//	upstream timed out... (truncated, 1048576 bytes)
+func (e *StatusError) Summary() string {
+	if len(e.Body) == 0 {
+		return ""
+	}
+	truncated := e.Size < 0 || e.Size > int64(len(e.Body))
+	mediatype, _, _ := mime.ParseMediaType(e.Header.Get("Content-Type"))
+	if mediatype == "" {
+		mediatype, _, _ = mime.ParseMediaType(http.DetectContentType(e.Body))
+	}
+	var s string
+	switch {
+	case mediatype == "application/json" || strings.HasSuffix(mediatype, "+json"):
+		var buf bytes.Buffer
+		if json.Compact(&buf, e.Body) == nil {
+			s = buf.String()
+		} else {
+			s = string(bytes.TrimSpace(e.Body))
+		}
+	case mediatype == "text/html" || mediatype == "application/xhtml+xml":
+		s = htmlSummary(e.Body)
+	case strings.HasPrefix(mediatype, "text/") || utf8.Valid(e.Body) && !bytes.ContainsRune(e.Body, 0):
+		s = string(bytes.TrimSpace(e.Body))
+	default:
+		preview := e.Body
+		if len(preview) > 32 {
+			preview = preview[:32]
+		}
+		s = "binary: " + hex.EncodeToString(preview)
+		if len(preview) < len(e.Body) || truncated {
+			s += "..."
+		}
+		size := e.Size
+		if size < int64(len(e.Body)) {
+			size = int64(len(e.Body))
+		}
+		return fmt.Sprintf("%s (%d bytes)", s, size)
+	}
+	if len(s) > maxSummary {
+		s, truncated = truncateUTF8(s, maxSummary), true
+	}
+	if truncated {
+		if e.Size >= int64(len(e.Body)) {
+			return fmt.Sprintf("%s... (truncated, %d bytes)", s, e.Size)
+		}
+		return s + "... (truncated)"
+	}
+	return s
+}
+
+var (
+	htmlTitle   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	htmlHeading = regexp.MustCompile(`(?is)<h[1-6][^>]*>(.*?)</h[1-6]>`)
+	htmlTag     = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// htmlSummary extracts the title and the first heading of an HTML page.
+func htmlSummary(body []byte) string {
+	var parts []string
+	if m := htmlTitle.FindSubmatch(body); m != nil {
+		parts = append(parts, fmt.Sprintf("title %q", htmlText(m[1])))
+	}
+	if m := htmlHeading.FindSubmatch(body); m != nil {
+		parts = append(parts, fmt.Sprintf("heading %q", htmlText(m[1])))
+	}
+	if len(parts) == 0 {
+		return "html page"
+	}
+	return "html: " + strings.Join(parts, ", ")
+}
+
+func htmlText(b []byte) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTag.ReplaceAllString(string(b), ""))), " ")
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncateUTF8(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
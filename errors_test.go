@@ -0,0 +1,68 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusErrorSummary(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), bytes.Repeat([]byte{0xff}, 1000)...)
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		size        int64
+		want        string
+	}{
+		{"empty", "text/plain", "", 0, ""},
+		{"json", "application/json; charset=utf-8", "{\n  \"error\": \"not_found\",\n  \"id\": 42\n}\n", 36, `{"error":"not_found","id":42}`},
+		{"problem json", "application/problem+json", `{ "title": "Bad" }`, 18, `{"title":"Bad"}`},
+		{"truncated json", "application/json", `{"items": [1, 2`, 1000, `{"items": [1, 2... (truncated, 1000 bytes)`},
+		{"html", "text/html", "<!DOCTYPE html><html><head><title>502 Bad Gateway</title></head>" +
+			"<body><center><h1 class=\"x\">Bad <b>Gateway</b></h1></center><hr><center>nginx</center></body></html>", 0,
+			`html: title "502 Bad Gateway", heading "Bad Gateway"`},
+		{"html entities", "text/html", "<h2>Fish &amp; Chips\n  closed</h2>", 0, `html: heading "Fish & Chips closed"`},
+		{"html without title", "text/html", "<p>oops</p>", 0, "html page"},
+		{"sniffed html", "", "<html><title>Error</title></html>", 0, `html: title "Error"`},
+		{"text", "text/plain", "upstream timed out\n", 0, "upstream timed out"},
+		{"unknown size", "text/plain", "upstream timed out", -1, "upstream timed out... (truncated)"},
+		{"long text", "text/plain", strings.Repeat("é", 300), 0, strings.Repeat("é", 256) + "... (truncated)"},
+		{"binary", "image/png", string(png), 1 << 20, "binary: 89504e470d0a1a0a0000000d49484452ffffffffffffffffffffffffffffffff... (1048576 bytes)"},
+		{"short binary", "application/octet-stream", "\x00\x01\x02", 3, "binary: 000102 (3 bytes)"},
+	} {
+		e := &StatusError{
+			Status: "500 Internal Server Error",
+			Header: http.Header{"Content-Type": {tc.contentType}},
+			Body:   []byte(tc.body),
+			Size:   tc.size,
+		}
+		assert.Equal(t, tc.want, e.Summary(), tc.name)
+		assert.Equal(t, tc.body, string(e.RawBody()), tc.name)
+	}
+}
+
+func TestStatusErrorBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<title>502 Bad Gateway</title>" + strings.Repeat("<p>padding</p>", 1000)))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	err := a.Get(context.Background(), "/", nil, nil, WithErrorBody(100))
+	var se *StatusError
+	if !assert.True(t, errors.As(err, &se)) {
+		return
+	}
+	assert.Len(t, se.Body, 100)
+	assert.EqualValues(t, 14030, se.Size)
+	assert.Equal(t, `api: unexpected status 502 Bad Gateway: html: title "502 Bad Gateway"... (truncated, 14030 bytes)`, err.Error())
+}
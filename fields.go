@@ -0,0 +1,241 @@
+package api
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FieldsStyle is the format of the sparse fieldset parameters of FieldsOf.
+type FieldsStyle int32
+
+const (
+	// FieldsComma lists the fields in a fields parameter, the nested ones with dotted paths:
+	// fields=id,name,owner.email.
+	FieldsComma FieldsStyle = iota
+	// FieldsJSONAPI lists the fields of every type in its own fields[type] parameter, following
+	// JSON:API: fields[articles]=title,author&fields[people]=name. The type of a struct is
+	// the one returned by its JSONAPIType method, or else its lowercase Go type name.
+	FieldsJSONAPI
+	// FieldsGoogle lists the fields in a fields parameter, the nested ones in parentheses, following
+	// the partial responses of Google APIs: fields=id,name,owner(email).
+	FieldsGoogle
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// fieldsCache holds the parameters of FieldsOf by type and style.
+var fieldsCache sync.Map
+
+type fieldsKey struct {
+	t     reflect.Type
+	style FieldsStyle
+}
+
+// field is a field selected by FieldsOf, with the fields of its struct if it's one.
+type field struct {
+	name string
+	// typ is the JSON:API type of the struct, empty for a leaf.
+	typ    string
+	fields []field
+}
+
+// FieldsOf returns the sparse fieldset parameters selecting the fields that v, a struct or a pointer
+// to one, or a slice of them, decodes: the JSON names of its exported fields, recursing into the
+// nested structs, except those tagged json:"-" or api:"-". The structs decoding themselves, like
+// time.Time, are fields of their own. It returns nil if v isn't a struct.
+//
+//	type User struct {
+//		ID    int    `json:"id"`
+//		Owner struct {
+//			Email string `json:"email"`
+//		} `json:"owner"`
+//		Cache []byte `json:"-"`
+//	}
+//	api.FieldsOf(&User{}, api.FieldsComma) // fields=id,owner.email
+func FieldsOf(v interface{}, style FieldsStyle) url.Values {
+	t := reflect.TypeOf(v)
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	key := fieldsKey{t, style}
+	if q, ok := fieldsCache.Load(key); ok {
+		return cloneValues(q.(url.Values))
+	}
+	fields := structFields(t, nil)
+	q := make(url.Values)
+	switch style {
+	case FieldsJSONAPI:
+		jsonAPIFields(q, jsonAPIType(t), fields)
+	case FieldsGoogle:
+		q.Set("fields", googleFields(fields))
+	default:
+		q.Set("fields", strings.Join(dottedFields(nil, "", fields), ","))
+	}
+	fieldsCache.Store(key, q)
+	return cloneValues(q)
+}
+
+func cloneValues(q url.Values) url.Values {
+	c := make(url.Values, len(q))
+	for k, vs := range q {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
+
+// structFields returns the fields of the struct type t, seen while visiting the types in stack.
+func structFields(t reflect.Type, stack []reflect.Type) []field {
+	for _, s := range stack {
+		if s == t {
+			return nil
+		}
+	}
+	stack = append(stack, t)
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Tag.Get("api") == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		ft := sf.Type
+		for ft.Kind() == reflect.Pointer || ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array {
+			ft = ft.Elem()
+		}
+		nested := ft.Kind() == reflect.Struct && !decodesItself(ft)
+		if sf.Anonymous && name == "" && nested {
+			// The fields of an embedded struct are promoted, as encoding/json does.
+			fields = append(fields, structFields(ft, stack)...)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		f := field{name: name}
+		if nested {
+			if f.fields = structFields(ft, stack); len(f.fields) > 0 {
+				f.typ = jsonAPIType(ft)
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// decodesItself reports whether the values of t decode themselves from JSON.
+func decodesItself(t reflect.Type) bool {
+	p := reflect.PointerTo(t)
+	return p.Implements(jsonUnmarshalerType) || p.Implements(textUnmarshalerType)
+}
+
+func jsonAPIType(t reflect.Type) string {
+	if m, ok := reflect.Zero(t).Interface().(interface{ JSONAPIType() string }); ok {
+		return m.JSONAPIType()
+	}
+	if m, ok := reflect.New(t).Interface().(interface{ JSONAPIType() string }); ok {
+		return m.JSONAPIType()
+	}
+	return strings.ToLower(t.Name())
+}
+
+func dottedFields(paths []string, prefix string, fields []field) []string {
+	for _, f := range fields {
+		if f.fields == nil {
+			paths = append(paths, prefix+f.name)
+		} else {
+			paths = dottedFields(paths, prefix+f.name+".", f.fields)
+		}
+	}
+	return paths
+}
+
+func googleFields(fields []field) string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+		if f.fields != nil {
+			names[i] += "(" + googleFields(f.fields) + ")"
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// jsonAPIFields adds the fields of the type typ to q, the nested structs being relationships
+// listed under their own types.
+func jsonAPIFields(q url.Values, typ string, fields []field) {
+	key := "fields[" + typ + "]"
+	names := strings.Split(q.Get(key), ",")
+	if names[0] == "" {
+		names = names[:0]
+	}
+	for _, f := range fields {
+		if !containsString(names, f.name) {
+			names = append(names, f.name)
+		}
+	}
+	q.Set(key, strings.Join(names, ","))
+	for _, f := range fields {
+		if f.fields != nil {
+			jsonAPIFields(q, f.typ, f.fields)
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// WithFields requests the sparse fieldset of the fields that v decodes, in the style set by
+// SetFieldsStyle, see FieldsOf. It replaces the fields parameters set otherwise.
+//
+//	var out User
+//	err := a.DoJSON(ctx, api.GET, "/users/7", nil, &out, api.WithFields(&out))
+func WithFields(v interface{}) Option {
+	return func(c *call) {
+		c.fields = v
+	}
+}
+
+// SetFieldsStyle sets the style of the parameters of WithFields, FieldsComma by default.
+func (a *Api) SetFieldsStyle(style FieldsStyle) {
+	a.fieldsStyle.Store(int32(style))
+}
+
+// applyFields sets the parameters of WithFields.
+func (a *Api) applyFields(c *call, req *http.Request) {
+	fields := FieldsOf(c.fields, FieldsStyle(a.fieldsStyle.Load()))
+	if len(fields) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		setQuery(req, k, fields[k])
+	}
+}
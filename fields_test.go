@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fieldsOwner struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+func (fieldsOwner) JSONAPIType() string { return "people" }
+
+type fieldsAudit struct {
+	Created time.Time `json:"created"`
+}
+
+type fieldsProject struct {
+	fieldsAudit
+	ID       int          `json:"id"`
+	Title    string       `json:"title"`
+	Owner    *fieldsOwner `json:"owner"`
+	Members  []fieldsOwner
+	Local    string `json:"-"`
+	Internal string `json:"internal" api:"-"`
+	secret   string
+}
+
+func TestFieldsOf(t *testing.T) {
+	for style, want := range map[FieldsStyle]url.Values{
+		FieldsComma: {"fields": {"created,id,title,owner.email,owner.name,Members.email,Members.name"}},
+		FieldsJSONAPI: {
+			"fields[fieldsproject]": {"created,id,title,owner,Members"},
+			"fields[people]":        {"email,name"},
+		},
+		FieldsGoogle: {"fields": {"created,id,title,owner(email,name),Members(email,name)"}},
+	} {
+		assert.Equal(t, want, FieldsOf(&fieldsProject{}, style), "style %d", style)
+		// The parameters are cached, but not shared.
+		FieldsOf([]fieldsProject{}, style).Set("fields", "x")
+		assert.Equal(t, want, FieldsOf([]fieldsProject{}, style), "style %d", style)
+	}
+	assert.Nil(t, FieldsOf(map[string]int{}, FieldsComma))
+	assert.Nil(t, FieldsOf(nil, FieldsComma))
+}
+
+func TestWithFields(t *testing.T) {
+	var got url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		w.Write([]byte(`{"email":"a@example.com"}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	var out fieldsOwner
+	if !assert.NoError(t, a.DoJSON(ctx, GET, "/owner", url.Values{"id": {"7"}, "fields": {"all"}}, &out, WithFields(&out))) {
+		return
+	}
+	assert.Equal(t, "a@example.com", out.Email)
+	assert.Equal(t, url.Values{"id": {"7"}, "fields": {"email,name"}}, got)
+
+	a.SetFieldsStyle(FieldsJSONAPI)
+	assert.NoError(t, a.DoJSON(ctx, GET, "/owner", nil, &out, WithFields(&out)))
+	assert.Equal(t, url.Values{"fields[people]": {"email,name"}}, got)
+}
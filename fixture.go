@@ -0,0 +1,254 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FixtureBaseURI is the base URI of the Api returned by NewFixture.
+const FixtureBaseURI = "http://fixtures.invalid"
+
+// NewFixture returns an Api answering its calls from the fixtures of dir rather than a server,
+// e.g. for offline development, see FixtureTransport for how the files are named.
+func NewFixture(dir string) *Api {
+	a := MustNew(FixtureBaseURI)
+	a.Client = &http.Client{Transport: &FixtureTransport{Dir: dir}}
+	return a
+}
+
+// FixtureTransport is an http.RoundTripper serving the responses from the files of Dir, one per
+// endpoint, named after the method and the path of the request with its slashes replaced by
+// double underscores: GET /users/42 is answered with GET__users__42.json, or with the template
+// GET__users__{id}.json whose "{id}" placeholders in the body and the headers are replaced by "42".
+// The exact name wins, then the templates with the fewest parameters. The query isn't considered.
+//
+// The status and the headers of a response are read from an optional sidecar, GET__users__{id}.meta.json:
+//
+//	{"status": 201, "headers": {"Location": "/users/{id}"}}
+//
+// The status is 200 and the Content-Type application/json by default. The requests without
+// a fixture get a 404 whose text names the expected file. The files are read on every request,
+// so they can be edited while the program runs.
+//
+// With Record set, the requests are sent via Record instead and their responses written to Dir
+// along with their sidecar, over the existing files, for them to be edited and replayed:
+//
+//	a.Client = &http.Client{Transport: &api.FixtureTransport{Dir: "fixtures", Record: http.DefaultTransport,
+//		Templates: []string{"/users/{id}"}}}
+type FixtureTransport struct {
+	Dir string
+	// Record is the transport of the recorded requests.
+	Record http.RoundTripper
+	// Templates are the resource templates naming the recorded fixtures, e.g. "/users/{id}".
+	// The paths matching neither a template nor an existing fixture are recorded as they are.
+	Templates []string
+}
+
+// fixtureMeta is the sidecar of a fixture.
+type fixtureMeta struct {
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// fixtureName returns the file name of the fixture of method and path, without its extension.
+func fixtureName(method, path string) string {
+	return method + "__" + strings.Join(strings.Split(strings.Trim(path, "/"), "/"), "__")
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *FixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Record != nil {
+		return t.record(req)
+	}
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	name, params, err := t.lookup(req.Method, req.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		expected := fixtureName(req.Method, req.URL.Path) + ".json"
+		msg := fmt.Sprintf("api: no fixture for %s %s: expected %s in %s", req.Method, req.URL.Path, expected, t.Dir)
+		return fixtureResponse(req, http.StatusNotFound, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, []byte(msg)), nil
+	}
+	body, err := os.ReadFile(filepath.Join(t.Dir, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var meta fixtureMeta
+	if data, err := os.ReadFile(filepath.Join(t.Dir, name+".meta.json")); err == nil {
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("api: fixture %s.meta.json: %w", name, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	for k, v := range meta.Headers {
+		header.Set(k, substituteFixture(v, params, false))
+	}
+	if meta.Status == 0 {
+		meta.Status = http.StatusOK
+	}
+	return fixtureResponse(req, meta.Status, header, []byte(substituteFixture(string(body), params, true))), nil
+}
+
+// lookup returns the name of the fixture of method and path along with the values of its
+// parameters, or an empty name if there's none.
+func (t *FixtureTransport) lookup(method, path string) (string, map[string]string, error) {
+	exact := fixtureName(method, path)
+	if _, err := os.Stat(filepath.Join(t.Dir, exact+".json")); err == nil {
+		return exact, nil, nil
+	} else if !os.IsNotExist(err) {
+		return "", nil, err
+	}
+	names, err := t.templates(method)
+	if err != nil {
+		return "", nil, err
+	}
+	best, bestParams := "", map[string]string(nil)
+	for _, name := range names {
+		params, ok := matchFixture(name, method, path)
+		if ok && (best == "" || len(params) < len(bestParams)) {
+			best, bestParams = name, params
+		}
+	}
+	return best, bestParams, nil
+}
+
+// templates returns the names of the fixtures of method with parameters, sorted.
+func (t *FixtureTransport) templates(method string) ([]string, error) {
+	entries, err := os.ReadDir(t.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, method+"__") || !strings.HasSuffix(name, ".json") ||
+			strings.HasSuffix(name, ".meta.json") || !strings.Contains(name, "{") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, ".json"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// matchFixture matches the path against the fixture name, returning the values of its parameters.
+func matchFixture(name, method, path string) (map[string]string, bool) {
+	ts := strings.Split(strings.TrimPrefix(name, method+"__"), "__")
+	ps := strings.Split(strings.Trim(path, "/"), "/")
+	if len(ts) != len(ps) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, seg := range ts {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") && ps[i] != "" {
+			params[seg[1:len(seg)-1]] = ps[i]
+		} else if seg != ps[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// substituteFixture replaces the placeholders of params in s, escaping the values for a JSON
+// string if escape is set.
+func substituteFixture(s string, params map[string]string, escape bool) string {
+	for name, v := range params {
+		if escape {
+			quoted, _ := json.Marshal(v)
+			v = string(quoted[1 : len(quoted)-1])
+		}
+		s = strings.ReplaceAll(s, "{"+name+"}", v)
+	}
+	return s
+}
+
+func fixtureResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// record sends req via Record and writes its response as the fixture of req.
+func (t *FixtureTransport) record(req *http.Request) (*http.Response, error) {
+	resp, err := t.Record.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	name, err := t.recordName(req.Method, req.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	data := body
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "", "  ") == nil {
+		data = append(indented.Bytes(), '\n')
+	}
+	meta := fixtureMeta{Status: resp.StatusCode, Headers: make(map[string]string)}
+	for k, vs := range resp.Header {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Date", "Connection":
+			continue
+		}
+		meta.Headers[k] = strings.Join(vs, ", ")
+	}
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(t.Dir, name+".json"), data, 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(t.Dir, name+".meta.json"), append(metaData, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// recordName returns the name of the fixture recorded for method and path: the first matching
+// template of Templates, then the existing fixture, then the path itself.
+func (t *FixtureTransport) recordName(method, path string) (string, error) {
+	for _, tmpl := range t.Templates {
+		if matchTemplate(tmpl, path) {
+			return fixtureName(method, tmpl), nil
+		}
+	}
+	name, _, err := t.lookup(method, path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if name == "" {
+		name = fixtureName(method, path)
+	}
+	return name, nil
+}
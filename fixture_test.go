@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFixture(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFixture(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "GET__users__{id}.json", `{"id":"{id}","name":"User {id}"}`)
+	writeFixture(t, dir, "GET__users__me.json", `{"id":"me","name":"Me"}`)
+	writeFixture(t, dir, "GET__users__{id}__orders__{order}.json", `{"user":"{id}","order":"{order}"}`)
+	writeFixture(t, dir, "POST__users.json", `{"id":"7"}`)
+	writeFixture(t, dir, "POST__users.meta.json", `{"status":201,"headers":{"Location":"/users/7"}}`)
+	writeFixture(t, dir, "GET__items__{id}.meta.json", `{"headers":{"X-Item":"{id}"}}`)
+	writeFixture(t, dir, "GET__items__{id}.json", `[]`)
+	a := NewFixture(dir)
+	ctx := context.Background()
+	type user struct {
+		ID    string
+		Name  string
+		User  string
+		Order string
+	}
+
+	var u user
+	if !assert.NoError(t, a.DoJSON(ctx, GET, "/users/42", nil, &u)) {
+		return
+	}
+	assert.Equal(t, user{ID: "42", Name: "User 42"}, u)
+
+	// The exact name wins over the template.
+	u = user{}
+	assert.NoError(t, a.DoJSON(ctx, GET, "/users/me", nil, &u))
+	assert.Equal(t, user{ID: "me", Name: "Me"}, u)
+
+	u = user{}
+	assert.NoError(t, a.DoJSON(ctx, GET, "/users/1/orders/2", url.Values{"page": {"3"}}, &u))
+	assert.Equal(t, user{User: "1", Order: "2"}, u)
+
+	// The values are escaped in the JSON body.
+	u = user{}
+	assert.NoError(t, a.DoJSON(ctx, GET, `/users/a"b`, nil, &u))
+	assert.Equal(t, `a"b`, u.ID)
+
+	var meta ResponseMeta
+	u = user{}
+	assert.NoError(t, a.DoJSON(ctx, POST, "/users", nil, &u, WithMeta(&meta)))
+	assert.Equal(t, "7", u.ID)
+	assert.Equal(t, http.StatusCreated, meta.StatusCode)
+	assert.Equal(t, "/users/7", meta.Header.Get("Location"))
+
+	var items []string
+	assert.NoError(t, a.DoJSON(ctx, GET, "/items/9", nil, &items, WithMeta(&meta)))
+	assert.Equal(t, "9", meta.Header.Get("X-Item"))
+}
+
+func TestFixtureMissing(t *testing.T) {
+	dir := t.TempDir()
+	a := NewFixture(dir)
+	err := a.DoJSON(context.Background(), GET, "/users/42/avatar", nil, nil)
+	var se *StatusError
+	if !assert.True(t, errors.As(err, &se), "%v", err) {
+		return
+	}
+	assert.Equal(t, http.StatusNotFound, se.Code)
+	assert.Contains(t, string(se.Body), "expected GET__users__42__avatar.json in "+dir)
+}
+
+func TestFixtureRecord(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Path", r.URL.Path)
+		w.Write([]byte(`{"path":"` + r.URL.Path + `"}`))
+	}))
+	defer srv.Close()
+	dir := filepath.Join(t.TempDir(), "fixtures")
+	a := MustNew(srv.URL)
+	a.Client = &http.Client{Transport: &FixtureTransport{Dir: dir, Record: http.DefaultTransport, Templates: []string{"/users/{id}"}}}
+	ctx := context.Background()
+	var out struct{ Path string }
+	if !assert.NoError(t, a.DoJSON(ctx, GET, "/users/42", nil, &out)) {
+		return
+	}
+	assert.Equal(t, "/users/42", out.Path)
+	assert.NoError(t, a.DoJSON(ctx, GET, "/health", nil, &out))
+
+	body, err := os.ReadFile(filepath.Join(dir, "GET__users__{id}.json"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "{\n  \"path\": \"/users/42\"\n}\n", string(body))
+	_, err = os.Stat(filepath.Join(dir, "GET__health.json"))
+	assert.NoError(t, err)
+
+	// The recorded fixtures are replayed.
+	replay := NewFixture(dir)
+	var meta ResponseMeta
+	assert.NoError(t, replay.DoJSON(ctx, GET, "/users/7", nil, &out, WithMeta(&meta)))
+	assert.Equal(t, "/users/42", out.Path)
+	assert.Equal(t, "/users/42", meta.Header.Get("X-Request-Path"))
+	assert.Equal(t, http.StatusOK, meta.StatusCode)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+)
+
+// FlagProvider returns the feature flags on for the calls made with ctx, e.g. those of the
+// tenant it carries, see SetFlagProvider.
+type FlagProvider func(ctx context.Context) map[string]bool
+
+// FlagBinding is what a feature flag does to the requests of the calls it's on for, see BindFlag.
+type FlagBinding struct {
+	query       bool
+	name, value string
+}
+
+// FlagHeader returns the binding setting the header name to value.
+func FlagHeader(name, value string) FlagBinding {
+	return FlagBinding{name: http.CanonicalHeaderKey(name), value: value}
+}
+
+// FlagQuery returns the binding setting the query parameter key to value.
+func FlagQuery(key, value string) FlagBinding {
+	return FlagBinding{query: true, name: key, value: value}
+}
+
+// flagSet is the state of SetFlagProvider and BindFlag, replaced as a whole on every change.
+type flagSet struct {
+	provider FlagProvider
+	// names are the bound flags, sorted.
+	names    []string
+	bindings map[string][]FlagBinding
+}
+
+// SetFlagProvider sets the provider of the feature flags of the bindings of BindFlag. It's called
+// with the context of every call, every attempt included, so the flags turned on or off apply
+// from the next request on. A nil p removes the provider, the bindings staying in place.
+func (a *Api) SetFlagProvider(p FlagProvider) {
+	a.updateFlags(func(fs *flagSet) { fs.provider = p })
+}
+
+// BindFlag makes the requests of the calls the feature flag name is on for carry the headers
+// and query parameters of bindings, e.g. to opt in to the beta features of a vendor per tenant:
+//
+//	a.BindFlag("new-pricing", api.FlagHeader("X-Pricing-V2", "true"), api.FlagQuery("pricing", "v2"))
+//
+// The flags on for a call are evaluated by the provider of SetFlagProvider, and the bound ones
+// reported in ResponseMeta.Flags. With the Flags of the DiscoveryPolicy, they're also gated by
+// the capabilities of Discover. Binding a flag again replaces its bindings; binding none
+// removes them.
+func (a *Api) BindFlag(name string, bindings ...FlagBinding) {
+	a.updateFlags(func(fs *flagSet) {
+		m := make(map[string][]FlagBinding, len(fs.bindings)+1)
+		for k, v := range fs.bindings {
+			m[k] = v
+		}
+		if len(bindings) == 0 {
+			delete(m, name)
+		} else {
+			m[name] = append([]FlagBinding(nil), bindings...)
+		}
+		fs.bindings, fs.names = m, make([]string, 0, len(m))
+		for k := range m {
+			fs.names = append(fs.names, k)
+		}
+		sort.Strings(fs.names)
+	})
+}
+
+func (a *Api) updateFlags(fn func(fs *flagSet)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var fs flagSet
+	if old := a.flags.Load(); old != nil {
+		fs = *old
+	}
+	fn(&fs)
+	a.flags.Store(&fs)
+}
+
+// applyFlags applies the bindings of the flags on for the call to req, recording them in the meta
+// of the call.
+func (a *Api) applyFlags(ctx context.Context, c *call, req *http.Request) {
+	fs := a.flags.Load()
+	if fs == nil || len(fs.names) == 0 {
+		return
+	}
+	caps := a.discovered(func(p *DiscoveryPolicy) bool { return p.Flags })
+	if fs.provider == nil && caps == nil {
+		return
+	}
+	var on map[string]bool
+	if fs.provider != nil {
+		on = fs.provider(ctx)
+	}
+	var active []string
+	for _, name := range fs.names {
+		if fs.provider != nil && !on[name] || caps != nil && !caps.Supports(name) {
+			continue
+		}
+		active = append(active, name)
+		for _, b := range fs.bindings[name] {
+			if !b.query {
+				req.Header.Set(b.name, b.value)
+				continue
+			}
+			SetQuery(req, b.name, b.value)
+		}
+	}
+	if c.meta != nil {
+		c.meta.Flags = active
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlags(t *testing.T) {
+	var last atomic.Pointer[http.Request]
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last.Store(r)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	// The flags are per tenant, and the beta of acme is turned on while the calls are made.
+	var acmeBeta atomic.Bool
+	a.SetFlagProvider(func(ctx context.Context) map[string]bool {
+		if ctx.Value(tenantKey{}) != "acme" {
+			return nil
+		}
+		return map[string]bool{"beta": acmeBeta.Load(), "unbound": true}
+	})
+	a.BindFlag("beta", FlagHeader("x-feature-beta", "true"), FlagQuery("beta", "1"))
+	acme := context.WithValue(context.Background(), tenantKey{}, "acme")
+
+	var meta ResponseMeta
+	assert.NoError(t, a.Get(acme, "/items", url.Values{"page": {"2"}}, nil, WithMeta(&meta)))
+	r := last.Load()
+	assert.Empty(t, r.Header.Get("X-Feature-Beta"))
+	assert.Equal(t, "page=2", r.URL.RawQuery)
+	assert.Empty(t, meta.Flags)
+
+	acmeBeta.Store(true)
+	assert.NoError(t, a.Get(acme, "/items", url.Values{"page": {"2"}}, nil, WithMeta(&meta)))
+	r = last.Load()
+	assert.Equal(t, "true", r.Header.Get("X-Feature-Beta"))
+	assert.Equal(t, "page=2&beta=1", r.URL.RawQuery)
+	assert.Equal(t, []string{"beta"}, meta.Flags)
+
+	// Other tenants don't have it.
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, nil, WithMeta(&meta)))
+	r = last.Load()
+	assert.Empty(t, r.Header.Get("X-Feature-Beta"))
+	assert.Empty(t, r.URL.RawQuery)
+	assert.Empty(t, meta.Flags)
+
+	// Unbinding the flag removes its parameters.
+	a.BindFlag("beta")
+	assert.NoError(t, a.Get(acme, "/items", nil, nil, WithMeta(&meta)))
+	assert.Empty(t, last.Load().Header.Get("X-Feature-Beta"))
+	assert.Empty(t, meta.Flags)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+)
+
+// Form is a form encoded with its fields in the order they were appended, duplicate keys
+// included, for the endpoints rejecting the sorted and grouped encoding of url.Values:
+//
+//	form := new(api.Form).Append("item", "a").Append("qty", "1").Append("item", "b").Append("qty", "2")
+//	form.Encode() // item=a&qty=1&item=b&qty=2
+//
+// It's sent by RequestForm, and by Post, Put, Patch and RequestTemplate.DoBody when given as body.
+// The zero value is an empty form.
+type Form struct {
+	fields []formField
+}
+
+type formField struct {
+	key, value string
+}
+
+// Append adds the field key with the value after the fields appended before, returning f.
+func (f *Form) Append(key, value string) *Form {
+	f.fields = append(f.fields, formField{key, value})
+	return f
+}
+
+// Len returns the number of fields of the form.
+func (f *Form) Len() int {
+	if f == nil {
+		return 0
+	}
+	return len(f.fields)
+}
+
+// Values returns the fields of the form as url.Values, losing their order.
+func (f *Form) Values() url.Values {
+	if f.Len() == 0 {
+		return nil
+	}
+	v := make(url.Values)
+	for _, field := range f.fields {
+		v[field.key] = append(v[field.key], field.value)
+	}
+	return v
+}
+
+// Encode encodes the form in the URL encoding, in order.
+func (f *Form) Encode() string {
+	if f.Len() == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	encodeOrderedForm(&buf, f.fields)
+	return buf.String()
+}
+
+// snapshot returns a copy of the fields, so that appending to f doesn't change a request made from it.
+func (f *Form) snapshot() []formField {
+	if f.Len() == 0 {
+		return nil
+	}
+	return append([]formField(nil), f.fields...)
+}
+
+func encodeOrderedForm(buf *bytes.Buffer, fields []formField) {
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(url.QueryEscape(field.key))
+		buf.WriteByte('=')
+		buf.WriteString(url.QueryEscape(field.value))
+	}
+}
+
+// RequestForm creates an http request with the form encoded in its body in order, whatever the
+// method. The body is encoded once, so the Content-Length and the preparers, like a signing one,
+// see the same bytes as the server, and form may be modified once RequestForm returns. A body
+// encoding to more than 64KB is encoded as it's sent instead, its Content-Length computed
+// beforehand, so it's not held in memory at once.
+// The fields are checked like the args of Request, see DeclareParams.
+func (a *Api) RequestForm(method Method, resource string, form *Form, opts ...Option) (req *http.Request, err error) {
+	args := form.Values()
+	if err := a.checkParamsFor(resource, args, opts); err != nil {
+		return nil, err
+	}
+	a.lintArgs(args)
+	u, err := a.resourceURL(resource)
+	if err != nil {
+		return nil, err
+	}
+	req = newRequest(method, u)
+	setFormBody(req, form)
+	if err = a.shape(req, opts); err != nil {
+		return nil, err
+	}
+	return
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormEncode(t *testing.T) {
+	form := new(Form).Append("item", "a b").Append("qty", "1").Append("item", "c&d").Append("qty", "2").Append("zz", "").Append("a", "é")
+	assert.Equal(t, "item=a+b&qty=1&item=c%26d&qty=2&zz=&a=%C3%A9", form.Encode())
+	assert.Equal(t, url.Values{"item": {"a b", "c&d"}, "qty": {"1", "2"}, "zz": {""}, "a": {"é"}}, form.Values())
+	assert.Equal(t, 6, form.Len())
+
+	var empty Form
+	assert.Equal(t, "", empty.Encode())
+	assert.Nil(t, empty.Values())
+
+	a := MustNew("https://api.example.com")
+	req, err := a.RequestForm(PUT, "/legacy", form)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The request keeps the fields it was made with.
+	form.Append("late", "1")
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, "item=a+b&qty=1&item=c%26d&qty=2&zz=&a=%C3%A9", string(body))
+	assert.Equal(t, int64(len(body)), req.ContentLength)
+	assert.Equal(t, "application/x-www-form-urlencoded", req.Header.Get("Content-Type"))
+	assert.Equal(t, "PUT", req.Method)
+}
+
+func TestFormLegacyOrder(t *testing.T) {
+	const secret = "s3cr3t"
+	sign := func(body []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		// The legacy endpoint wants each item followed by its quantity, and a valid signature.
+		if string(body) != "op=order&item=a&qty=1&item=b&qty=2" || r.Header.Get("X-Signature") != sign(body) || r.ContentLength != int64(len(body)) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.AddPreparer(PreparerFunc(func(_ context.Context, req *http.Request) error {
+		if req.GetBody == nil {
+			return nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Signature", sign(data))
+		return nil
+	}), 0)
+	ctx := context.Background()
+
+	form := new(Form).Append("op", "order").Append("item", "a").Append("qty", "1").Append("item", "b").Append("qty", "2")
+	assert.NoError(t, a.Post(ctx, "/orders", form, nil))
+	assert.NoError(t, a.Put(ctx, "/orders/7", form, nil))
+	assert.NoError(t, a.Template(POST, "/orders/{id}").DoBody(ctx, map[string]string{"id": "8"}, url.Values{"v": {"2"}}, form, nil))
+	// The sorted encoding of url.Values is rejected.
+	assert.Error(t, a.DoJSON(ctx, POST, "/orders", form.Values(), nil))
+	assert.Equal(t, []string{
+		"POST /orders op=order&item=a&qty=1&item=b&qty=2",
+		"PUT /orders/7 op=order&item=a&qty=1&item=b&qty=2",
+		"POST /orders/8?v=2 op=order&item=a&qty=1&item=b&qty=2",
+		"POST /orders item=a&item=b&op=order&qty=1&qty=2",
+	}, got)
+}
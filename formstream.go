@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// streamFormAbove is the size of the encoding over which a form body is encoded as it's sent
+// rather than at once, so a form of many fields isn't held twice in memory, as url.Values and
+// as its encoding.
+const streamFormAbove = maxPooledBuffer
+
+// formSource is a snapshot of the fields of a form body: the sorted keys and values of
+// url.Values, or the ordered fields of a Form.
+type formSource struct {
+	keys   []string
+	values [][]string
+	fields []formField
+}
+
+// setFormValuesBody sets the body of req to the encoding of args, in the same form
+// url.Values.Encode produces, streamed if it's over streamFormAbove.
+func setFormValuesBody(req *http.Request, args url.Values) {
+	size := formValuesSize(args)
+	if size <= streamFormAbove {
+		setLazyBody(req, func(buf *bytes.Buffer) error {
+			encodeForm(buf, args)
+			return nil
+		})
+	} else {
+		src := &formSource{keys: make([]string, 0, len(args)), values: make([][]string, 0, len(args))}
+		for k := range args {
+			src.keys = append(src.keys, k)
+		}
+		sort.Strings(src.keys)
+		for _, k := range src.keys {
+			src.values = append(src.values, append([]string(nil), args[k]...))
+		}
+		setStreamedForm(req, src, size)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+}
+
+// setFormBody sets the body of req to the ordered encoding of form, streamed if it's over
+// streamFormAbove.
+func setFormBody(req *http.Request, form *Form) {
+	fields := form.snapshot()
+	if size := formFieldsSize(fields); size <= streamFormAbove {
+		setLazyBody(req, func(buf *bytes.Buffer) error {
+			encodeOrderedForm(buf, fields)
+			return nil
+		})
+	} else {
+		setStreamedForm(req, &formSource{fields: fields}, size)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+}
+
+func setStreamedForm(req *http.Request, src *formSource, size int64) {
+	req.ContentLength = size
+	req.Body = &formReader{src: src}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return &formReader{src: src}, nil
+	}
+}
+
+// formValuesSize returns the length of the encoding of args, without encoding them.
+func formValuesSize(args url.Values) int64 {
+	var n, pairs int64
+	for k, vs := range args {
+		key := int64(queryEscapedLen(k))
+		for _, v := range vs {
+			n += key + 1 + int64(queryEscapedLen(v))
+			pairs++
+		}
+	}
+	if pairs > 0 {
+		n += pairs - 1
+	}
+	return n
+}
+
+func formFieldsSize(fields []formField) int64 {
+	var n int64
+	for i, f := range fields {
+		if i > 0 {
+			n++
+		}
+		n += int64(queryEscapedLen(f.key)) + 1 + int64(queryEscapedLen(f.value))
+	}
+	return n
+}
+
+// formReader encodes a form source a field at a time as it's read.
+type formReader struct {
+	src    *formSource
+	k, v   int // the next value of src.values, or the next field of src.fields in k
+	buf    []byte
+	off    int
+	mu     sync.Mutex
+	closed bool
+}
+
+func (r *formReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, http.ErrBodyReadAfterClose
+	}
+	n := 0
+	for n < len(p) {
+		if r.off == len(r.buf) && !r.next() {
+			if n == 0 {
+				return 0, io.EOF
+			}
+			break
+		}
+		c := copy(p[n:], r.buf[r.off:])
+		r.off += c
+		n += c
+	}
+	return n, nil
+}
+
+// next encodes the next field into buf, reporting false once there's none left.
+func (r *formReader) next() bool {
+	var key, value string
+	first := r.k == 0 && r.v == 0
+	switch {
+	case r.src.fields != nil:
+		if r.k == len(r.src.fields) {
+			return false
+		}
+		key, value = r.src.fields[r.k].key, r.src.fields[r.k].value
+		r.k++
+	default:
+		for r.k < len(r.src.keys) && r.v == len(r.src.values[r.k]) {
+			r.k, r.v = r.k+1, 0
+		}
+		if r.k == len(r.src.keys) {
+			return false
+		}
+		key, value = r.src.keys[r.k], r.src.values[r.k][r.v]
+		r.v++
+	}
+	r.buf, r.off = r.buf[:0], 0
+	if !first {
+		r.buf = append(r.buf, '&')
+	}
+	r.buf = appendQueryEscape(r.buf, key)
+	r.buf = append(r.buf, '=')
+	r.buf = appendQueryEscape(r.buf, value)
+	return true
+}
+
+func (r *formReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+// queryEscapedLen returns the length of url.QueryEscape(s).
+func queryEscapedLen(s string) int {
+	n := len(s)
+	for i := 0; i < len(s); i++ {
+		if !unescapedInQuery(s[i]) {
+			n += 2
+		}
+	}
+	return n
+}
+
+// appendQueryEscape appends url.QueryEscape(s) to dst.
+func appendQueryEscape(dst []byte, s string) []byte {
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ' ':
+			dst = append(dst, '+')
+		case unescapedInQuery(c):
+			dst = append(dst, c)
+		default:
+			dst = append(dst, '%', hex[c>>4], hex[c&15])
+		}
+	}
+	return dst
+}
+
+// unescapedInQuery reports whether url.QueryEscape leaves c as is, or as a '+' for a space.
+func unescapedInQuery(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '_' || c == '.' || c == '~' || c == ' '
+}
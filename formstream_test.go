@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryEscape(t *testing.T) {
+	var all []byte
+	for c := 0; c < 256; c++ {
+		all = append(all, byte(c))
+	}
+	for _, s := range []string{"", "plain", "a b&c=d+e/f?g#h%", "é", string(all)} {
+		assert.Equal(t, url.QueryEscape(s), string(appendQueryEscape(nil, s)))
+		assert.Equal(t, len(url.QueryEscape(s)), queryEscapedLen(s))
+	}
+}
+
+// largeForm returns url.Values of n values over a few keys, encoding to more than streamFormAbove.
+func largeForm(n int) url.Values {
+	args := url.Values{"empty": {""}, "z key": {"last & least"}}
+	for i := 0; i < n; i++ {
+		k := "item[" + strconv.Itoa(i%7) + "]"
+		args[k] = append(args[k], "value "+strconv.Itoa(i)+"/é=&")
+	}
+	return args
+}
+
+func TestStreamedForm(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, int64(len(body)), r.ContentLength)
+		got = append(got, string(body))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	args := largeForm(50000)
+	want := args.Encode()
+
+	req, err := a.Request(POST, "/items", args)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, buffered := req.Body.(*lazyReader)
+	assert.False(t, buffered)
+	assert.Equal(t, int64(len(want)), req.ContentLength)
+	assert.Equal(t, strconv.Itoa(len(want)), req.Header.Get("Content-Length"))
+	// The body is encoded from a snapshot of args.
+	args.Set("empty", "changed")
+	assert.NoError(t, iotest.TestReader(req.Body, []byte(want)))
+	body, err := req.GetBody()
+	if assert.NoError(t, err) {
+		data, _ := io.ReadAll(body)
+		assert.Equal(t, want, string(data))
+	}
+	req, _ = a.Request(POST, "/items", largeForm(50000))
+	_, err = a.Do(context.Background(), req)
+	assert.NoError(t, err)
+
+	// In order for a Form.
+	form := new(Form)
+	for i := 0; i < 20000; i++ {
+		form.Append("z", "a b").Append("a", strconv.Itoa(i))
+	}
+	req, err = a.RequestForm(POST, "/items", form)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(len(form.Encode())), req.ContentLength)
+	assert.NoError(t, iotest.TestReader(req.Body, []byte(form.Encode())))
+	assert.NoError(t, a.Post(context.Background(), "/items", form, nil))
+
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, want, got[0])
+		assert.Equal(t, form.Encode(), got[1])
+	}
+
+	// The small forms are still encoded at once.
+	req, _ = a.Request(POST, "/items", url.Values{"a": {"1"}})
+	_, buffered = req.Body.(*lazyReader)
+	assert.True(t, buffered)
+}
+
+// BenchmarkLargeForm compares the allocations of encoding a form of 50k values at once, as
+// url.Values.Encode does, with those of the streamed body of Request.
+func BenchmarkLargeForm(b *testing.B) {
+	a := MustNew("http://example.com")
+	args := largeForm(50000)
+	b.Run("encoded", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			io.Copy(io.Discard, strings.NewReader(args.Encode()))
+		}
+	})
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			req, _ := a.Request(POST, "/items", args)
+			io.Copy(io.Discard, req.Body)
+			req.Body.Close()
+		}
+	})
+}
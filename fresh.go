@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// FreshState is the state Fresh keeps between the checks of a resource. The validators are
+// marshaled to JSON, so the state can be stored along with the mirrored copy of the resource.
+type FreshState struct {
+	// ETag and LastModified are the validators of the last version seen, sent back verbatim
+	// in If-None-Match and If-Modified-Since.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// Unvalidated reports that the server sent neither validator with the last version,
+	// so every check reports it as changed.
+	Unvalidated bool `json:"unvalidated,omitempty"`
+
+	// Head makes the checks use HEAD rather than GET, so the body is never downloaded.
+	Head bool `json:"-"`
+	// Body, if set, is passed the body of a changed resource fetched with GET.
+	// If it fails, the state is left as it was so the next check reports the change again.
+	Body func(r io.Reader) error `json:"-"`
+}
+
+// Fresh reports whether the resource has changed since the version state describes, sending
+// a conditional GET, or HEAD if state.Head is set. A 304 Not Modified reports it unchanged
+// without downloading anything, and a response carrying the validators already in state too,
+// for the servers ignoring the conditional headers. Otherwise the validators of the response
+// are kept in state, its body is passed to state.Body, and the resource is reported changed.
+// A zero state always reports a change. The response goes through the Api like that of Get,
+// so it's retried, classified and subject to the opts; other failures are returned as errors.
+func (a *Api) Fresh(ctx context.Context, resource string, state *FreshState, opts ...Option) (changed bool, err error) {
+	method := GET
+	if state.Head {
+		method = HEAD
+	}
+	req, err := a.emptyRequest(method, resource, buildContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	if state.ETag != "" {
+		req.Header.Set("If-None-Match", state.ETag)
+	}
+	if state.LastModified != "" {
+		req.Header.Set("If-Modified-Since", state.LastModified)
+	}
+	resp, err := a.send(ctx, a.newCallFor(resource, opts), req)
+	var se *StatusError
+	if errors.As(err, &se) && se.Code == http.StatusNotModified {
+		// A 304 may update the validators of the version.
+		if etag := se.Header.Get("ETag"); etag != "" {
+			state.ETag = etag
+		}
+		if lm := se.Header.Get("Last-Modified"); lm != "" {
+			state.LastModified = lm
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer drainClose(resp.Body)
+	etag, lm := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag != "" && etag == state.ETag || etag == "" && lm != "" && lm == state.LastModified {
+		return false, nil
+	}
+	if state.Body != nil && method == GET {
+		if err := state.Body(resp.Body); err != nil {
+			return false, err
+		}
+	}
+	state.ETag, state.LastModified, state.Unvalidated = etag, lm, etag == "" && lm == ""
+	return true, nil
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFresh(t *testing.T) {
+	var version atomic.Value
+	version.Store("v1")
+	var bodies atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + version.Load().(string) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.Method == http.MethodGet {
+			bodies.Add(1)
+		}
+		w.Write([]byte(version.Load().(string)))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	var got []string
+	state := &FreshState{Body: func(r io.Reader) error {
+		b, err := io.ReadAll(r)
+		got = append(got, string(b))
+		return err
+	}}
+	changed, err := a.Fresh(context.Background(), "/doc", state)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, changed)
+	assert.Equal(t, `"v1"`, state.ETag)
+	assert.False(t, state.Unvalidated)
+
+	// The state survives a round trip through JSON.
+	b, err := json.Marshal(state)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.JSONEq(t, `{"etag":"\"v1\"","lastModified":"Wed, 21 Oct 2015 07:28:00 GMT"}`, string(b))
+	restored := &FreshState{Body: state.Body}
+	assert.NoError(t, json.Unmarshal(b, restored))
+
+	changed, err = a.Fresh(context.Background(), "/doc", restored)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, int32(1), bodies.Load())
+
+	version.Store("v2")
+	changed, err = a.Fresh(context.Background(), "/doc", restored)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, `"v2"`, restored.ETag)
+	assert.Equal(t, []string{"v1", "v2"}, got)
+
+	// HEAD doesn't download the body.
+	head := &FreshState{Head: true}
+	changed, err = a.Fresh(context.Background(), "/doc", head)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, `"v2"`, head.ETag)
+	changed, err = a.Fresh(context.Background(), "/doc", head)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, int32(2), bodies.Load())
+}
+
+func TestFreshIgnoredConditional(t *testing.T) {
+	// The validators tell the resource is unchanged even though the server ignores them.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("v1"))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	state := &FreshState{ETag: `"v1"`}
+	changed, err := a.Fresh(context.Background(), "/doc", state)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestFreshNoValidators(t *testing.T) {
+	var conditional atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			conditional.Add(1)
+		}
+		w.Write([]byte("data"))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	state := &FreshState{}
+	for i := 0; i < 2; i++ {
+		changed, err := a.Fresh(context.Background(), "/doc", state)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		assert.True(t, state.Unvalidated)
+	}
+	assert.Equal(t, int32(0), conditional.Load())
+
+	// A failed Body leaves the state untouched.
+	state = &FreshState{ETag: `"old"`, Body: func(io.Reader) error { return io.ErrShortWrite }}
+	_, err := a.Fresh(context.Background(), "/doc", state)
+	assert.Equal(t, io.ErrShortWrite, err)
+	assert.Equal(t, `"old"`, state.ETag)
+	assert.False(t, state.Unvalidated)
+}
@@ -0,0 +1,451 @@
+package api
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HARRecorder is an http.RoundTripper that records every request/response pair passing through it
+// and exports them as a HAR 1.2 log. Use it as the Transport of the Api's Client:
+//
+//	rec := api.NewHARRecorder(nil)
+//	svc.Client = &http.Client{Transport: rec}
+//	// ... make calls
+//	rec.WriteHAR(f)
+//
+// It's safe for concurrent use.
+type HARRecorder struct {
+	// MaxBodySize caps the recorded size of each request and response body, 64KB if zero.
+	// Negative values disable body recording.
+	MaxBodySize int
+	// Redactor masks the secrets of the recorded headers, URLs and bodies. If nil, the Redactor
+	// of the Api sending the request is used, see SetRedactor, or DefaultRedactor.
+	Redactor *Redactor
+	// RedactHeaders, if set, replaces the Headers of the Redactor.
+	RedactHeaders []string
+
+	next    http.RoundTripper
+	mu      sync.Mutex
+	entries []*harEntry
+}
+
+// NewHARRecorder creates a recorder sending requests via next, http.DefaultTransport if nil.
+func NewHARRecorder(next http.RoundTripper) *HARRecorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &HARRecorder{next: next}
+}
+
+type harEntry struct {
+	mu       sync.Mutex
+	started  time.Time
+	req      *http.Request
+	reqBody  []byte
+	resp     *http.Response
+	respBody []byte
+	respSize int64
+	err      error
+	// redactor is the Redactor of the Api sending req.
+	redactor *Redactor
+	// redaction is the redaction of the response body declared by DeclareRedaction.
+	redaction *responseRedaction
+
+	getConn, gotConn, dnsStart, dnsDone, connStart, connDone, tlsStart, tlsDone time.Time
+	wrote, firstByte, done                                                      time.Time
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *HARRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := &harEntry{started: time.Now()}
+	e.redactor, _ = req.Context().Value(redactorKey{}).(*Redactor)
+	e.redaction, _ = req.Context().Value(redactionKey{}).(*responseRedaction)
+	limit := r.bodyLimit()
+	req = req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody && limit >= 0 {
+		req.Body = &captureBody{ReadCloser: req.Body, limit: limit, add: func(p []byte) {
+			e.mu.Lock()
+			e.reqBody = append(e.reqBody, p...)
+			e.mu.Unlock()
+		}}
+	}
+	e.req = req
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), e.trace()))
+
+	r.mu.Lock()
+	r.entries = append(r.entries, e)
+	r.mu.Unlock()
+
+	resp, err := r.next.RoundTrip(req)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.err = err
+		e.done = time.Now()
+		return nil, err
+	}
+	e.resp = resp
+	resp.Body = &captureBody{ReadCloser: resp.Body, limit: limit, add: func(p []byte) {
+		e.mu.Lock()
+		e.respBody = append(e.respBody, p...)
+		e.mu.Unlock()
+	}, count: func(n int) {
+		e.mu.Lock()
+		e.respSize += int64(n)
+		e.mu.Unlock()
+	}, finish: func() {
+		e.mark(&e.done)
+	}}
+	return resp, nil
+}
+
+func (r *HARRecorder) bodyLimit() int {
+	switch {
+	case r.MaxBodySize == 0:
+		return 64 << 10
+	case r.MaxBodySize < 0:
+		return -1
+	}
+	return r.MaxBodySize
+}
+
+// trace returns the hooks marking the timings of e.
+func (e *harEntry) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn:              func(string) { e.mark(&e.getConn) },
+		GotConn:              func(httptrace.GotConnInfo) { e.mark(&e.gotConn) },
+		DNSStart:             func(httptrace.DNSStartInfo) { e.mark(&e.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { e.mark(&e.dnsDone) },
+		ConnectStart:         func(string, string) { e.mark(&e.connStart) },
+		ConnectDone:          func(string, string, error) { e.mark(&e.connDone) },
+		TLSHandshakeStart:    func() { e.mark(&e.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { e.mark(&e.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { e.mark(&e.wrote) },
+		GotFirstResponseByte: func() { e.mark(&e.firstByte) },
+	}
+}
+
+func (e *harEntry) mark(t *time.Time) {
+	now := time.Now()
+	e.mu.Lock()
+	if t.IsZero() {
+		*t = now
+	}
+	e.mu.Unlock()
+}
+
+// captureBody passes reads through, recording up to limit bytes and counting all of them.
+type captureBody struct {
+	io.ReadCloser
+	limit    int
+	captured int
+	add      func(p []byte)
+	count    func(n int)
+	finish   func()
+	once     sync.Once
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if room := b.limit - b.captured; room > 0 {
+			chunk := p[:n]
+			if len(chunk) > room {
+				chunk = chunk[:room]
+			}
+			b.captured += len(chunk)
+			b.add(chunk)
+		}
+		if b.count != nil {
+			b.count(n)
+		}
+	}
+	if err != nil && b.finish != nil {
+		b.once.Do(b.finish)
+	}
+	return n, err
+}
+
+func (b *captureBody) Close() error {
+	if b.finish != nil {
+		b.once.Do(b.finish)
+	}
+	return b.ReadCloser.Close()
+}
+
+// WriteHAR writes the recorded traffic as a HAR 1.2 log. Calls that failed without a response
+// are included with status 0 and the error in the response comment.
+func (r *HARRecorder) WriteHAR(w io.Writer) error {
+	r.mu.Lock()
+	entries := append([]*harEntry(nil), r.entries...)
+	r.mu.Unlock()
+
+	log := HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "github.com/xlab/api", Version: "1"},
+		Entries: make([]HAREntry, 0, len(entries)),
+	}
+	for _, e := range entries {
+		log.Entries = append(log.Entries, e.export(r.redactorFor(e)))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(HAR{Log: log})
+}
+
+// redactorFor returns the Redactor masking the secrets of e.
+func (r *HARRecorder) redactorFor(e *harEntry) *Redactor {
+	red := r.Redactor
+	if red == nil {
+		red = e.redactor
+	}
+	if red == nil {
+		red = defaultRedactor
+	}
+	if r.RedactHeaders != nil {
+		red = &Redactor{Headers: r.RedactHeaders, Query: red.Query, Fields: red.Fields}
+	}
+	return red
+}
+
+// HAR is a HAR 1.2 document, as written by HARRecorder.WriteHAR.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog is the log object of a HAR document.
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+// HARCreator describes the application that created the HAR log.
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is a single recorded request/response pair, times are in milliseconds.
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+// HARNameValue is a header or query parameter.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARRequest is the request of a HAR entry.
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// HARPostData is the body of a HAR request.
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARResponse is the response of a HAR entry.
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+// HARContent is the body of a HAR response. Binary bodies have Encoding set to "base64".
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// HARTimings breaks the time of a HAR entry down into phases, -1 for phases that didn't happen.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func (e *harEntry) export(red *Redactor) HAREntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	out := HAREntry{StartedDateTime: e.started.UTC().Format(time.RFC3339Nano)}
+	req := e.req
+	out.Request = HARRequest{
+		Method:      req.Method,
+		URL:         red.RedactURL(req.URL),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(red.RedactHeader(req.Header)),
+		QueryString: []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    int64(len(e.reqBody)),
+	}
+	if req.ContentLength > 0 {
+		out.Request.BodySize = req.ContentLength
+	}
+	query, _ := url.ParseQuery(red.redactPairs(req.URL.RawQuery))
+	for _, k := range sortedKeys(query) {
+		for _, v := range query[k] {
+			out.Request.QueryString = append(out.Request.QueryString, HARNameValue{k, v})
+		}
+	}
+	if len(e.reqBody) > 0 {
+		mimeType := req.Header.Get("Content-Type")
+		text, encoding := harText(mimeType, red.RedactBody(mimeType, e.reqBody))
+		out.Request.PostData = &HARPostData{MimeType: mimeType, Text: text}
+		if encoding != "" {
+			out.Request.PostData.Comment = "text is " + encoding + " encoded"
+		}
+	}
+
+	out.Response = HARResponse{
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []HARNameValue{},
+		Headers:     []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if e.resp == nil {
+		if e.err != nil {
+			out.Response.Comment = e.err.Error()
+		}
+	} else {
+		resp := e.resp
+		mimeType := resp.Header.Get("Content-Type")
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		body := e.respBody
+		if e.redaction != nil {
+			body = e.redaction.recorded(mimeType, body)
+		}
+		text, encoding := harText(mimeType, red.RedactBody(mimeType, body))
+		out.Response.Status = resp.StatusCode
+		out.Response.StatusText = strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)))
+		out.Response.HTTPVersion = resp.Proto
+		out.Response.Headers = harHeaders(red.RedactHeader(resp.Header))
+		out.Response.RedirectURL = resp.Header.Get("Location")
+		out.Response.BodySize = e.respSize
+		out.Response.Content = HARContent{Size: e.respSize, MimeType: mimeType, Text: text, Encoding: encoding}
+	}
+	if req.ProtoMajor > 0 {
+		out.Request.HTTPVersion = req.Proto
+	}
+
+	ms := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() || to.Before(from) {
+			return -1
+		}
+		return float64(to.Sub(from)) / float64(time.Millisecond)
+	}
+	end := e.done
+	if end.IsZero() {
+		end = time.Now()
+	}
+	var t HARTimings
+	t.Blocked = ms(e.getConn, e.gotConn)
+	t.DNS = ms(e.dnsStart, e.dnsDone)
+	t.Connect = ms(e.connStart, e.connDone)
+	t.SSL = ms(e.tlsStart, e.tlsDone)
+	t.Send = ms(e.gotConn, e.wrote)
+	t.Wait = ms(e.wrote, e.firstByte)
+	t.Receive = ms(e.firstByte, end)
+	for _, p := range []*float64{&t.Send, &t.Wait, &t.Receive} {
+		if *p < 0 {
+			*p = 0
+		}
+	}
+	out.Timings = t
+	out.Time = ms(e.started, end)
+	return out
+}
+
+func harHeaders(h http.Header) []HARNameValue {
+	out := []HARNameValue{}
+	for _, k := range sortedKeys(h) {
+		for _, v := range h[k] {
+			out = append(out, HARNameValue{k, v})
+		}
+	}
+	return out
+}
+
+// harText returns the body as text, base64-encoding it unless it's valid UTF-8 of a textual type.
+func harText(mimeType string, body []byte) (text, encoding string) {
+	if len(body) == 0 {
+		return "", ""
+	}
+	if isTextual(mimeType) && utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func isTextual(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "json"),
+		strings.HasSuffix(mediaType, "xml"),
+		mediaType == "application/x-www-form-urlencoded",
+		mediaType == "application/javascript":
+		return true
+	}
+	return false
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
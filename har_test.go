@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHARRecorder(t *testing.T) {
+	binary := []byte{0x89, 'P', 'N', 'G', 0, 1, 2, 0xff}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/items":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Set-Cookie", "session=secret")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": 1}`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(binary)
+		}
+	}))
+	defer srv.Close()
+
+	rec := NewHARRecorder(nil)
+	a := MustNew(srv.URL)
+	a.Client = &http.Client{Transport: rec}
+	a.Header = http.Header{"Authorization": {"Bearer token"}}
+
+	req, _ := a.RequestBytes(POST, "/items", "application/json", []byte(`{"name": "foo"}`))
+	resp, err := a.Do(context.Background(), req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	req, _ = a.Request(GET, "/image", nil)
+	resp, err = a.Do(context.Background(), req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	var buf bytes.Buffer
+	assert.NoError(t, rec.WriteHAR(&buf))
+	var har HAR
+	if !assert.NoError(t, json.Unmarshal(buf.Bytes(), &har)) {
+		return
+	}
+	assert.Equal(t, "1.2", har.Log.Version)
+	assert.NotEmpty(t, har.Log.Creator.Name)
+	if !assert.Len(t, har.Log.Entries, 2) {
+		return
+	}
+
+	post := har.Log.Entries[0]
+	assert.NotEmpty(t, post.StartedDateTime)
+	assert.True(t, post.Time >= 0)
+	assert.Equal(t, "POST", post.Request.Method)
+	assert.Equal(t, srv.URL+"/items", post.Request.URL)
+	assert.Contains(t, post.Request.Headers, HARNameValue{"Authorization", "<redacted:12 chars>"})
+	if assert.NotNil(t, post.Request.PostData) {
+		assert.Equal(t, `{"name": "foo"}`, post.Request.PostData.Text)
+		assert.Equal(t, "application/json", post.Request.PostData.MimeType)
+	}
+	assert.Equal(t, 201, post.Response.Status)
+	assert.Equal(t, "Created", post.Response.StatusText)
+	assert.Contains(t, post.Response.Headers, HARNameValue{"Set-Cookie", "<redacted:14 chars>"})
+	assert.Equal(t, `{"id": 1}`, post.Response.Content.Text)
+	assert.Equal(t, "", post.Response.Content.Encoding)
+	assert.True(t, post.Timings.Wait >= 0)
+
+	image := har.Log.Entries[1]
+	assert.Equal(t, "GET", image.Request.Method)
+	assert.Nil(t, image.Request.PostData)
+	assert.Equal(t, "image/png", image.Response.Content.MimeType)
+	assert.Equal(t, "base64", image.Response.Content.Encoding)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(binary), image.Response.Content.Text)
+	assert.Equal(t, int64(len(binary)), image.Response.Content.Size)
+
+	// required fields are present on the wire, not only decodable
+	var raw struct {
+		Log struct {
+			Entries []map[string]json.RawMessage
+		}
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &raw))
+	for _, e := range raw.Log.Entries {
+		for _, field := range []string{"startedDateTime", "time", "request", "response", "cache", "timings"} {
+			assert.Contains(t, e, field)
+		}
+	}
+}
+
+func TestHARRecorderConcurrent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	defer srv.Close()
+
+	rec := NewHARRecorder(nil)
+	rec.MaxBodySize = 10
+	a := MustNew(srv.URL)
+	a.Client = &http.Client{Transport: rec}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := a.Request(GET, "/", nil)
+			resp, err := a.Do(context.Background(), req)
+			if assert.NoError(t, err) {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var buf bytes.Buffer
+	assert.NoError(t, rec.WriteHAR(&buf))
+	var har HAR
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &har))
+	assert.Len(t, har.Log.Entries, 10)
+	for _, e := range har.Log.Entries {
+		assert.Equal(t, "xxxxxxxxxx", e.Response.Content.Text)
+	}
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SetRawHeaders makes the requests sent via Do carry the headers named by names with the exact case
+// of the names, e.g. "x-device-token" rather than the canonical "X-Device-Token", for servers
+// comparing header names case-sensitively. The values are moved from the canonical key of the
+// request header to the raw one just before sending, so they're set as usual, with WithHeader or
+// the Api's Header. Only HTTP/1.1 keeps the case: HTTP/2 lowercases every name. No names
+// removes the raw headers.
+func (a *Api) SetRawHeaders(names ...string) {
+	a.mu.Lock()
+	a.rawHeaders = append([]string(nil), names...)
+	a.mu.Unlock()
+}
+
+// applyRawHeaders moves the values of the raw headers of SetRawHeaders to their raw keys.
+func (a *Api) applyRawHeaders(req *http.Request) {
+	a.mu.Lock()
+	names := a.rawHeaders
+	a.mu.Unlock()
+	for _, name := range names {
+		key := http.CanonicalHeaderKey(name)
+		if vs, ok := req.Header[key]; ok && key != name {
+			delete(req.Header, key)
+			req.Header[name] = append(req.Header[name], vs...)
+		}
+	}
+}
+
+// headerOrder is the order set by SetHeaderOrder along with the client derived from base to enforce it.
+type headerOrder struct {
+	names  []string
+	base   *http.Client
+	client *http.Client
+}
+
+// SetHeaderOrder makes the requests sent via Do write the headers named by names first, in that
+// order, the other ones following as usual, for servers depending on the order of the headers,
+// e.g. a proprietary header required before Authorization. The names are compared case-insensitively.
+//
+// The net/http transport writes the headers in the order of their names, so the order is enforced
+// by a copy of the Api's client rewriting the request heads as they're written to the connection,
+// derived again whenever Client is replaced. It requires the Transport of the client to be an
+// *http.Transport or nil, and limits the connections it makes to HTTP/1.1; the order is kept on a
+// best-effort basis since proxies may reorder the headers, and it isn't meaningful for HTTP/2.
+// No names removes the order.
+func (a *Api) SetHeaderOrder(names ...string) {
+	if len(names) == 0 {
+		a.order.Store(nil)
+		return
+	}
+	a.order.Store(newHeaderOrder(append([]string(nil), names...), a.targetClient(a.certClient(a.baseClient()))))
+}
+
+// orderClient returns the client enforcing the header order for base, deriving it if needed.
+func (a *Api) orderClient(base *http.Client) *http.Client {
+	o := a.order.Load()
+	if o == nil {
+		return base
+	}
+	if o.base != base {
+		fresh := newHeaderOrder(o.names, base)
+		if !a.order.CompareAndSwap(o, fresh) {
+			return a.orderClient(base)
+		}
+		o = fresh
+	}
+	return o.client
+}
+
+func newHeaderOrder(names []string, base *http.Client) *headerOrder {
+	o := &headerOrder{names: names, base: base}
+	client := *base
+	client.Transport = orderTransport(base.Transport, names)
+	o.client = &client
+	return o
+}
+
+// orderTransport returns a copy of rt whose connections write the request headers in order.
+// Transports other than *http.Transport can't be rewritten, so they fail every request.
+func orderTransport(rt http.RoundTripper, names []string) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return errTransport{fmt.Errorf("api: the header order requires an *http.Transport, got %T", rt)}
+	}
+	t = t.Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &orderConn{Conn: conn, names: names}, nil
+	}
+	// TLS is set up here rather than by the transport, so that the heads are rewritten before
+	// encryption; the connection is kept to HTTP/1.1.
+	dialTLS := t.DialTLSContext
+	if dialTLS == nil {
+		config := t.TLSClientConfig
+		dialTLS = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			cfg := &tls.Config{}
+			if config != nil {
+				cfg = config.Clone()
+			}
+			if cfg.ServerName == "" {
+				cfg.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+			cfg.NextProtos = []string{"http/1.1"}
+			tc := tls.Client(conn, cfg)
+			if err := tc.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tc, nil
+		}
+	}
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialTLS(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &orderConn{Conn: conn, names: names}, nil
+	}
+	t.ForceAttemptHTTP2 = false
+	return t
+}
+
+// orderConn rewrites the heads of the requests written to the connection, passing their bodies
+// through. A connection whose bytes stop looking like HTTP/1.1 requests, e.g. the TLS tunneled
+// through a proxy after CONNECT, is passed through from then on.
+type orderConn struct {
+	net.Conn
+	names []string
+	// head is the part of the head written so far.
+	head []byte
+	// remaining is the length of the body left to be written, -1 for a chunked body.
+	remaining int64
+	chunks    chunkScanner
+	passed    bool
+}
+
+func (c *orderConn) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		switch {
+		case c.passed:
+			if _, err := c.Conn.Write(p); err != nil {
+				return 0, err
+			}
+			return total, nil
+		case c.remaining > 0:
+			n := int(min(c.remaining, int64(len(p))))
+			if _, err := c.Conn.Write(p[:n]); err != nil {
+				return 0, err
+			}
+			c.remaining -= int64(n)
+			p = p[n:]
+		case c.remaining < 0:
+			n, done := c.chunks.scan(p)
+			if _, err := c.Conn.Write(p[:n]); err != nil {
+				return 0, err
+			}
+			if done {
+				c.remaining, c.chunks = 0, chunkScanner{}
+			}
+			p = p[n:]
+		default:
+			if len(c.head) == 0 && (p[0] < 'A' || p[0] > 'Z') {
+				c.passed = true
+				continue
+			}
+			start := max(len(c.head)-3, 0)
+			c.head = append(c.head, p...)
+			end := bytes.Index(c.head[start:], []byte("\r\n\r\n"))
+			if end < 0 {
+				return total, nil
+			}
+			end += start + 4
+			rest := c.head[end:]
+			head, remaining, connect := reorderHead(c.head[:end], c.names)
+			if _, err := c.Conn.Write(head); err != nil {
+				return 0, err
+			}
+			c.head, c.remaining, c.passed = nil, remaining, connect
+			p = append([]byte(nil), rest...)
+		}
+	}
+	return total, nil
+}
+
+// reorderHead puts the headers named by names first in the request head, in order, returning the
+// length of its body, -1 if chunked, and whether it's a CONNECT.
+func reorderHead(head []byte, names []string) ([]byte, int64, bool) {
+	lines := strings.Split(strings.TrimSuffix(string(head), "\r\n\r\n"), "\r\n")
+	var first, rest []string
+	var remaining int64
+	for _, line := range lines[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.EqualFold(name, "Content-Length"):
+			remaining, _ = strconv.ParseInt(value, 10, 64)
+		case strings.EqualFold(name, "Transfer-Encoding") && strings.EqualFold(value, "chunked"):
+			remaining = -1
+		}
+	}
+	for _, name := range names {
+		for _, line := range lines[1:] {
+			if n, _, _ := strings.Cut(line, ":"); strings.EqualFold(n, name) {
+				first = append(first, line)
+			}
+		}
+	}
+	for _, line := range lines[1:] {
+		if !containsFold(names, line) {
+			rest = append(rest, line)
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString(lines[0])
+	b.WriteString("\r\n")
+	for _, line := range append(first, rest...) {
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.Bytes(), remaining, strings.HasPrefix(lines[0], "CONNECT ")
+}
+
+// containsFold reports whether the name of the header line is one of names.
+func containsFold(names []string, line string) bool {
+	n, _, _ := strings.Cut(line, ":")
+	for _, name := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// chunkScanner finds the end of a chunked body, trailers included, as it's written.
+type chunkScanner struct {
+	// line is the part of the current size or trailer line written so far.
+	line []byte
+	// data is the length of the chunk data left, its CRLF included.
+	data int64
+	// trailers is set once the last chunk is reached.
+	trailers bool
+}
+
+// scan consumes p up to the end of the body, returning the number of bytes of p belonging to it
+// and whether the end was reached.
+func (s *chunkScanner) scan(p []byte) (int, bool) {
+	i := 0
+	for i < len(p) {
+		if s.data > 0 {
+			n := int(min(s.data, int64(len(p)-i)))
+			s.data -= int64(n)
+			i += n
+			continue
+		}
+		b := p[i]
+		i++
+		if b != '\n' {
+			s.line = append(s.line, b)
+			continue
+		}
+		line := strings.TrimSpace(string(s.line))
+		s.line = s.line[:0]
+		if s.trailers {
+			if line == "" {
+				return i, true
+			}
+			continue
+		}
+		size, _, _ := strings.Cut(line, ";")
+		n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+		if err != nil {
+			continue
+		}
+		if n == 0 {
+			s.trailers = true
+		} else {
+			s.data = n + 2
+		}
+	}
+	return i, false
+}
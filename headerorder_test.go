@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rawServer accepts HTTP/1.1 connections and records the header lines of the requests as written.
+type rawServer struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	heads [][]string
+	conns int
+}
+
+func newRawServer(t *testing.T) *rawServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &rawServer{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *rawServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		var head []string
+		var length int64
+		var chunked bool
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\r\n")
+			if line == "" {
+				break
+			}
+			head = append(head, line)
+			name, value, _ := strings.Cut(line, ": ")
+			switch strings.ToLower(name) {
+			case "content-length":
+				length, _ = strconv.ParseInt(value, 10, 64)
+			case "transfer-encoding":
+				chunked = value == "chunked"
+			}
+		}
+		body := io.LimitReader(r, length)
+		if chunked {
+			body = httputil.NewChunkedReader(r)
+		}
+		n, _ := io.Copy(io.Discard, body)
+		if chunked {
+			// The empty trailer.
+			r.ReadString('\n')
+		}
+		s.mu.Lock()
+		s.heads = append(s.heads, head)
+		s.mu.Unlock()
+		resp := strconv.FormatInt(n, 10)
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: "+strconv.Itoa(len(resp))+"\r\n\r\n"+resp)
+	}
+}
+
+func (s *rawServer) names() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out [][]string
+	for _, head := range s.heads {
+		var names []string
+		for _, line := range head[1:] {
+			name, _, _ := strings.Cut(line, ":")
+			names = append(names, name)
+		}
+		out = append(out, names)
+	}
+	return out
+}
+
+func TestHeaderOrder(t *testing.T) {
+	srv := newRawServer(t)
+	defer srv.ln.Close()
+	a := MustNew("http://" + srv.ln.Addr().String())
+	a.Client = &http.Client{Transport: &http.Transport{}}
+	a.Header = http.Header{"Authorization": {"Bearer t"}, "Accept": {"*/*"}}
+	a.SetRawHeaders("x-device-token")
+	a.SetHeaderOrder("Host", "x-device-token", "authorization")
+	ctx := context.Background()
+
+	var n int
+	assert.NoError(t, a.Get(ctx, "/a", nil, &n, WithHeader("X-Device-Token", "d1")))
+	assert.NoError(t, a.Post(ctx, "/b", map[string]string{"k": strings.Repeat("v", 10000)}, &n, WithHeader("X-Device-Token", "d2")))
+	assert.Equal(t, 10008, n)
+	req, _ := a.Request(PUT, "/c", nil, WithHeader("X-Device-Token", "d3"))
+	req.Body = io.NopCloser(strings.NewReader(strings.Repeat("x", 5000)))
+	req.ContentLength = -1
+	resp, err := a.Do(ctx, req)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "5000", string(body))
+	}
+	assert.NoError(t, a.Get(ctx, "/d", nil, &n))
+
+	names := srv.names()
+	if assert.Len(t, names, 4) {
+		assert.Equal(t, []string{"Host", "x-device-token", "Authorization", "User-Agent", "Accept", "Accept-Encoding"}, names[0])
+		assert.Equal(t, []string{"Host", "x-device-token", "Authorization"}, names[1][:3])
+		assert.Equal(t, []string{"Host", "x-device-token", "Authorization"}, names[2][:3])
+		assert.Contains(t, names[2], "Transfer-Encoding")
+		assert.Equal(t, []string{"Host", "Authorization", "User-Agent", "Accept", "Accept-Encoding"}, names[3])
+	}
+	// The bodies were framed right, so the connection was kept alive.
+	srv.mu.Lock()
+	assert.Equal(t, 1, srv.conns)
+	srv.mu.Unlock()
+
+	// Without an order, the transport sorts the headers.
+	a.SetHeaderOrder()
+	assert.NoError(t, a.Get(ctx, "/e", nil, &n, WithHeader("X-Device-Token", "d4")))
+	names = srv.names()
+	assert.Equal(t, []string{"Host", "User-Agent", "Accept", "Authorization", "x-device-token", "Accept-Encoding"}, names[4])
+}
+
+func TestHeaderOrderTLS(t *testing.T) {
+	var proto string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto = r.Proto
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Client = srv.Client()
+	a.SetHeaderOrder("authorization")
+	assert.NoError(t, a.Get(context.Background(), "/", nil, nil))
+	assert.Equal(t, "HTTP/1.1", proto)
+
+	a.Client = &http.Client{Transport: roundTripper(func(*http.Request) (*http.Response, error) { return nil, nil })}
+	err := a.Get(context.Background(), "/", nil, nil)
+	assert.ErrorContains(t, err, "api: the header order requires an *http.Transport")
+}
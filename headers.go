@@ -0,0 +1,120 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpDateLayouts are the layouts accepted by ParseHTTPDate: the three formats of RFC 9110
+// followed by the RFC 5322 date-time with and without the day of week and seconds.
+var httpDateLayouts = []string{
+	http.TimeFormat,
+	time.RFC850,
+	time.ANSIC,
+	"Mon, _2 Jan 2006 15:04:05 -0700",
+	"Mon, _2 Jan 2006 15:04:05 MST",
+	"Mon, _2 Jan 2006 15:04 -0700",
+	"Mon, _2 Jan 2006 15:04 MST",
+	"_2 Jan 2006 15:04:05 -0700",
+	"_2 Jan 2006 15:04:05 MST",
+	"_2 Jan 2006 15:04 -0700",
+	"_2 Jan 2006 15:04 MST",
+}
+
+// ParseHTTPDate parses an HTTP date (RFC 9110), also accepting the RFC 5322 dates some servers send,
+// e.g. with a numeric zone, a single-digit day or a trailing comment. The result is in UTC.
+func ParseHTTPDate(s string) (time.Time, error) {
+	v := strings.TrimSpace(s)
+	if i := strings.IndexByte(v, '('); i > 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	v = strings.Join(strings.Fields(v), " ")
+	for _, layout := range httpDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("api: invalid HTTP date: %q", s)
+}
+
+// ParseRetryAfter parses the Retry-After header of h given either in seconds or as an HTTP date,
+// returning how long to wait from now. A date in the past means no wait at all.
+func ParseRetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		if secs > math.MaxInt64/int64(time.Second) {
+			return math.MaxInt64, true
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := ParseHTTPDate(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// ParseContentRange parses a Content-Range header value of the bytes unit, like "bytes 0-499/1234".
+// The total is -1 if it's unknown ("bytes 0-499/*"); start and end are -1 for an unsatisfied
+// range ("bytes */1234"). The end is inclusive.
+func ParseContentRange(s string) (start, end, total int64, err error) {
+	fail := func() (int64, int64, int64, error) {
+		return 0, 0, 0, fmt.Errorf("api: invalid Content-Range: %q", s)
+	}
+	v := strings.TrimSpace(s)
+	unit, spec, ok := strings.Cut(v, " ")
+	if !ok || !strings.EqualFold(unit, "bytes") {
+		return fail()
+	}
+	rng, size, ok := strings.Cut(strings.TrimSpace(spec), "/")
+	if !ok {
+		return fail()
+	}
+	rng, size = strings.TrimSpace(rng), strings.TrimSpace(size)
+	total = -1
+	if size != "*" {
+		if total, err = parseLength(size); err != nil {
+			return fail()
+		}
+	}
+	if rng == "*" {
+		if total < 0 {
+			return fail()
+		}
+		return -1, -1, total, nil
+	}
+	first, last, ok := strings.Cut(rng, "-")
+	if !ok {
+		return fail()
+	}
+	if start, err = parseLength(strings.TrimSpace(first)); err != nil {
+		return fail()
+	}
+	if end, err = parseLength(strings.TrimSpace(last)); err != nil {
+		return fail()
+	}
+	if end < start || total >= 0 && end >= total {
+		return fail()
+	}
+	return start, end, total, nil
+}
+
+// parseLength parses a non-negative decimal without a sign.
+func parseLength(s string) (int64, error) {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHTTPDate(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+	for _, v := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+		"  Sun, 06 Nov 1994 08:49:37 GMT  ",
+		"Sun, 6 Nov 1994 08:49:37 GMT",
+		"Sun,  6 Nov 1994 08:49:37 GMT",
+		"Sun, 06 Nov 1994 08:49:37 +0000",
+		"Sun, 06 Nov 1994 09:49:37 +0100",
+		"Sun, 06 Nov 1994 03:49:37 -0500 (EST)",
+		"06 Nov 1994 08:49:37 GMT",
+		"6 Nov 1994 08:49:37 +0000",
+		"Sun, 06 Nov 1994 08:49:37 UTC",
+	} {
+		got, err := ParseHTTPDate(v)
+		if assert.NoError(t, err, v) {
+			assert.Equal(t, want, got, v)
+		}
+	}
+	got, err := ParseHTTPDate("Sun, 06 Nov 1994 08:49 GMT")
+	if assert.NoError(t, err) {
+		assert.Equal(t, want.Truncate(time.Minute), got)
+	}
+	for _, v := range []string{"", "yesterday", "1994-11-06T08:49:37Z", "Sun, 06 Nov 1994", "Sun, 32 Nov 1994 08:49:37 GMT"} {
+		_, err := ParseHTTPDate(v)
+		assert.EqualError(t, err, `api: invalid HTTP date: "`+v+`"`)
+	}
+}
+
+func TestParseRetryAfterValues(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{" 120 ", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"+5", 5 * time.Second, true},
+		{"1.5", 0, false},
+		{"99999999999999999", math.MaxInt64, true},
+		{"Wed, 01 Jan 2020 00:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 1 Jan 2020 01:00:30 +0100", 30 * time.Second, true},
+		{"Tue, 31 Dec 2019 23:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	} {
+		d, ok := ParseRetryAfter(http.Header{"Retry-After": {tc.value}}, now)
+		assert.Equal(t, tc.ok, ok, tc.value)
+		assert.Equal(t, tc.want, d, tc.value)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, tc := range []struct {
+		value             string
+		start, end, total int64
+	}{
+		{"bytes 0-499/1234", 0, 499, 1234},
+		{"bytes 500-1233/1234", 500, 1233, 1234},
+		{"bytes 0-0/1", 0, 0, 1},
+		{"bytes 42-1233/*", 42, 1233, -1},
+		{"bytes */1234", -1, -1, 1234},
+		{"  Bytes   0-499 / 1234 ", 0, 499, 1234},
+		{"bytes 0 - 499/1234", 0, 499, 1234},
+	} {
+		start, end, total, err := ParseContentRange(tc.value)
+		if assert.NoError(t, err, tc.value) {
+			assert.Equal(t, []int64{tc.start, tc.end, tc.total}, []int64{start, end, total}, tc.value)
+		}
+	}
+	for _, v := range []string{
+		"", "bytes", "0-499/1234", "items 0-9/100", "bytes 0-499", "bytes 500-499/1234",
+		"bytes 0-1234/1234", "bytes */*", "bytes -1-5/10", "bytes +1-5/10", "bytes 0-5/-10", "bytes a-b/c", "bytes 0-/10",
+	} {
+		_, _, _, err := ParseContentRange(v)
+		assert.EqualError(t, err, `api: invalid Content-Range: "`+v+`"`)
+	}
+}
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// ErrUnhealthy is matched by every *HealthError.
+var ErrUnhealthy = errors.New("api: upstream unhealthy")
+
+// DefaultHealthBudget is the time a HealthCheck waits for the response unless HealthBudget is set.
+const DefaultHealthBudget = 2 * time.Second
+
+// HealthError is returned by HealthCheck when the upstream isn't healthy. It's matched by
+// ErrUnhealthy.
+type HealthError struct {
+	Resource string
+	// Reason says what failed, e.g. "status 503 Service Unavailable".
+	Reason string
+	// Err is the error of the call, nil if the response came but wasn't the expected one.
+	Err error
+}
+
+func (e *HealthError) Error() string {
+	return fmt.Sprintf("api: %s unhealthy: %s", e.Resource, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrUnhealthy) report true.
+func (e *HealthError) Is(target error) bool { return target == ErrUnhealthy }
+
+func (e *HealthError) Unwrap() error { return e.Err }
+
+// HealthOption configures HealthCheck and WatchHealth.
+type HealthOption func(*healthCheck)
+
+type healthCheck struct {
+	statuses []int
+	fields   []healthField
+	budget   time.Duration
+	noAuth   bool
+	down, up int
+}
+
+type healthField struct {
+	path  string
+	value interface{}
+}
+
+// HealthStatus makes the statuses codes the healthy ones, instead of any 2xx status, e.g. to
+// accept the 429 of a rate limited but running upstream.
+func HealthStatus(codes ...int) HealthOption {
+	return func(h *healthCheck) {
+		h.statuses = append(h.statuses, codes...)
+	}
+}
+
+// HealthField makes the upstream healthy only if the JSON body of the response holds value at the
+// dotted path, e.g. HealthField("status", "ok") or HealthField("db.connected", true). Values are
+// compared as JSON, so 1 and 1.0 are equal.
+func HealthField(path string, value interface{}) HealthOption {
+	return func(h *healthCheck) {
+		h.fields = append(h.fields, healthField{path: path, value: value})
+	}
+}
+
+// HealthBudget is the time the response must come within, DefaultHealthBudget if d is zero.
+// It replaces the timeout of the Api for the check, shorter or longer, and the check isn't retried.
+func HealthBudget(d time.Duration) HealthOption {
+	return func(h *healthCheck) {
+		h.budget = d
+	}
+}
+
+// HealthNoAuth sends the check without credentials, for the status endpoints open to all: the
+// TokenSource and the other preparers aren't run, and the headers of the Api matched by its
+// Redactor, like Authorization, aren't sent. The check then keeps working while the credentials
+// can't be had.
+func HealthNoAuth() HealthOption {
+	return func(h *healthCheck) {
+		h.noAuth = true
+	}
+}
+
+// HealthThresholds makes WatchHealth report the upstream unhealthy after down consecutive failed
+// checks and healthy again after up consecutive successful ones, 3 and 1 if zero.
+func HealthThresholds(down, up int) HealthOption {
+	return func(h *healthCheck) {
+		h.down, h.up = down, up
+	}
+}
+
+func newHealthCheck(opts []HealthOption) *healthCheck {
+	h := &healthCheck{budget: DefaultHealthBudget, down: 3, up: 1}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.budget <= 0 {
+		h.budget = DefaultHealthBudget
+	}
+	if h.down <= 0 {
+		h.down = 3
+	}
+	if h.up <= 0 {
+		h.up = 1
+	}
+	return h
+}
+
+// HealthCheck reports whether the upstream is healthy, sending a GET to its status resource:
+//
+//	err := a.HealthCheck(ctx, "/status", api.HealthField("status", "ok"), api.HealthBudget(time.Second))
+//
+// The upstream is healthy if the response comes within the budget, with a 2xx status or one of
+// HealthStatus, and holds the values of HealthField. Otherwise a *HealthError says why. The
+// check bypasses the cache of SetCache.
+func (a *Api) HealthCheck(ctx context.Context, resource string, opts ...HealthOption) error {
+	return a.checkHealth(ctx, resource, newHealthCheck(opts))
+}
+
+func (a *Api) checkHealth(ctx context.Context, resource string, h *healthCheck) error {
+	req, err := a.healthRequest(resource, h)
+	if err != nil {
+		return &HealthError{Resource: resource, Reason: err.Error(), Err: err}
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, h.budget)
+	defer cancel()
+	code, status, body, err := a.healthResponse(checkCtx, req.WithContext(checkCtx), resource, []Option{WithTimeout(h.budget), WithRetry(nil)})
+	if err != nil {
+		reason := err.Error()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			reason = fmt.Sprintf("no response within %s", h.budget)
+		}
+		return &HealthError{Resource: resource, Reason: reason, Err: err}
+	}
+	if !h.healthyStatus(code) {
+		return &HealthError{Resource: resource, Reason: "status " + status}
+	}
+	for _, f := range h.fields {
+		if reason := f.check(body); reason != "" {
+			return &HealthError{Resource: resource, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// healthRequest creates the GET request of the check, without the credentials for HealthNoAuth.
+func (a *Api) healthRequest(resource string, h *healthCheck) (*http.Request, error) {
+	var req *http.Request
+	if h.noAuth {
+		u, err := a.resourceURL(resource)
+		if err != nil {
+			return nil, err
+		}
+		req = newRequest(GET, u)
+		red := a.Redactor()
+		for k := range a.Header {
+			if !matchAny(red.Headers, k) {
+				req.Header.Set(k, a.Header.Get(k))
+			}
+		}
+	} else {
+		var err error
+		if req, err = a.Request(GET, resource, nil); err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Set("Cache-Control", "no-store")
+	return req, nil
+}
+
+// healthResponse sends req and returns the status and the body of the response, the ones kept in
+// the StatusError for the non-2xx statuses.
+func (a *Api) healthResponse(ctx context.Context, req *http.Request, resource string, opts []Option) (int, string, []byte, error) {
+	resp, err := a.send(ctx, a.newCallFor(resource, opts), req)
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code, se.Status, se.Body, nil
+	}
+	if err != nil {
+		return 0, "", nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	return resp.StatusCode, resp.Status, body, nil
+}
+
+func (h *healthCheck) healthyStatus(code int) bool {
+	if len(h.statuses) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, s := range h.statuses {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns why body doesn't hold the value of the field, empty if it does.
+func (f healthField) check(body []byte) string {
+	v, err := JSONLookup(body, f.path)
+	if err != nil {
+		return fmt.Sprintf("no %s in the response", f.path)
+	}
+	raw := v.Raw
+	var got, want interface{}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		return fmt.Sprintf("invalid %s in the response", f.path)
+	}
+	data, err := json.Marshal(f.value)
+	if err != nil {
+		return fmt.Sprintf("invalid value of %s: %v", f.path, err)
+	}
+	json.Unmarshal(data, &want)
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Sprintf("%s is %s, want %s", f.path, raw, data)
+	}
+	return ""
+}
+
+// HealthTransition is a change of the health of the upstream reported by WatchHealth.
+type HealthTransition struct {
+	Healthy bool
+	// At is the time of the check that made the change, by the clock of the Api.
+	At time.Time
+	// Err is the error of the last check, for the upstreams that became unhealthy.
+	Err error
+	// Checks is the number of consecutive checks that made the change.
+	Checks int
+}
+
+// WatchHealth runs HealthCheck every interval in a goroutine until ctx is done or the Api is
+// closed, calling fn with the transitions of the upstream between healthy and unhealthy. The
+// changes are debounced by HealthThresholds: a single failed check doesn't make a healthy
+// upstream unhealthy. The first state reached is reported too, so fn learns when the upstream is
+// first ready. fn is called from the watching goroutine, one transition at a time. The checks
+// are timed by the clock of the Api.
+func (a *Api) WatchHealth(ctx context.Context, interval time.Duration, resource string, fn func(HealthTransition), opts ...HealthOption) {
+	h := newHealthCheck(opts)
+	if interval <= 0 {
+		interval = time.Second
+	}
+	a.goBackground(ctx, func(ctx context.Context) {
+		var known, healthy bool
+		var ups, downs int
+		for {
+			err := a.checkHealth(ctx, resource, h)
+			if ctx.Err() != nil {
+				return
+			}
+			at := a.clock().Now()
+			if err == nil {
+				ups, downs = ups+1, 0
+				if (!known || !healthy) && ups >= h.up {
+					known, healthy = true, true
+					fn(HealthTransition{Healthy: true, At: at, Checks: ups})
+				}
+			} else {
+				ups, downs = 0, downs+1
+				if (!known || healthy) && downs >= h.down {
+					known, healthy = true, false
+					fn(HealthTransition{Healthy: false, At: at, Err: err, Checks: downs})
+				}
+			}
+			if err := a.clock().Sleep(ctx, interval); err != nil {
+				return
+			}
+		}
+	})
+}
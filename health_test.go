@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var auth atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/status":
+			w.Write([]byte(`{"status": "ok", "db": {"connected": true, "replicas": 2}}`))
+		case "/degraded":
+			w.Write([]byte(`{"status": "degraded"}`))
+		case "/limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Header = http.Header{"Authorization": {"Bearer t"}}
+	a.SetDefaults(WithTimeout(time.Millisecond))
+	ctx := context.Background()
+
+	// The budget replaces the Api's timeout.
+	assert.NoError(t, a.HealthCheck(ctx, "/status", HealthField("status", "ok"), HealthField("db.connected", true), HealthField("db.replicas", 2)))
+	assert.Equal(t, "Bearer t", auth.Load())
+	assert.NoError(t, a.HealthCheck(ctx, "/status", HealthNoAuth()))
+	assert.Equal(t, "", auth.Load())
+
+	err := a.HealthCheck(ctx, "/degraded", HealthField("status", "ok"))
+	assert.EqualError(t, err, `api: /degraded unhealthy: status is "degraded", want "ok"`)
+	assert.True(t, errors.Is(err, ErrUnhealthy))
+	assert.EqualError(t, a.HealthCheck(ctx, "/degraded", HealthField("db.connected", true)), "api: /degraded unhealthy: no db.connected in the response")
+	assert.EqualError(t, a.HealthCheck(ctx, "/down"), "api: /down unhealthy: status 503 Service Unavailable")
+
+	assert.Error(t, a.HealthCheck(ctx, "/limited"))
+	assert.NoError(t, a.HealthCheck(ctx, "/limited", HealthStatus(http.StatusOK, http.StatusTooManyRequests)))
+
+	err = a.HealthCheck(ctx, "/slow", HealthBudget(50*time.Millisecond))
+	assert.EqualError(t, err, "api: /slow unhealthy: no response within 50ms")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestWatchHealth(t *testing.T) {
+	// The upstream flaps: up, a single failure, down for three checks, then up again.
+	script := []int{200, 200, 503, 200, 503, 503, 503, 200, 200}
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&n, 1)) - 1
+		w.WriteHeader(script[min(i, len(script)-1)])
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a.SetClock(clk)
+
+	var mu sync.Mutex
+	var transitions []HealthTransition
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.WatchHealth(ctx, 10*time.Second, "/healthz", func(tr HealthTransition) {
+		mu.Lock()
+		transitions = append(transitions, tr)
+		mu.Unlock()
+	}, HealthThresholds(3, 2))
+
+	for range script {
+		clk.BlockUntil(1)
+		clk.Advance(10 * time.Second)
+	}
+	clk.BlockUntil(1)
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !assert.Len(t, transitions, 3) {
+		return
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.True(t, transitions[0].Healthy)
+	assert.Equal(t, start.Add(10*time.Second), transitions[0].At)
+	assert.False(t, transitions[1].Healthy)
+	assert.Equal(t, start.Add(60*time.Second), transitions[1].At)
+	assert.Equal(t, 3, transitions[1].Checks)
+	assert.True(t, errors.Is(transitions[1].Err, ErrUnhealthy))
+	assert.True(t, transitions[2].Healthy)
+	assert.Equal(t, start.Add(80*time.Second), transitions[2].At)
+	assert.Nil(t, transitions[2].Err)
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// ErrHedgeLost is the cause of the cancellation of the requests of a hedged call that lost
+// to another one, see context.Cause.
+var ErrHedgeLost = errors.New("api: hedged request lost to another one")
+
+// WithHedging makes the call send a duplicate of the request if no response arrived within delay,
+// up to maxExtra times, and use whichever response arrives first, canceling the other requests.
+// An attempt failing with a transport error doesn't win while others are outstanding. It trades
+// upstream load for tail latency, so it's restricted to GET and HEAD requests: other methods fail
+// the call. The duplicates sent are counted in ResponseMeta.Hedges and CallLog.Hedges.
+// A zero maxExtra removes the hedging set by the defaults.
+func WithHedging(delay time.Duration, maxExtra int) Option {
+	return func(c *call) {
+		if maxExtra != 0 && (delay <= 0 || maxExtra < 0) {
+			c.fail(fmt.Errorf("api: invalid hedging: delay %s, max extra %d", delay, maxExtra))
+			return
+		}
+		c.hedgeDelay, c.hedgeMax = delay, maxExtra
+	}
+}
+
+// checkHedging fails the hedged calls whose request may not be duplicated.
+func (c *call) checkHedging(req *http.Request) error {
+	if c.hedgeMax > 0 && req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return fmt.Errorf("api: hedging requires a GET or HEAD request, got %s", req.Method)
+	}
+	return nil
+}
+
+// hedgeResult is the outcome of one of the requests of a hedged exchange.
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	reused bool
+	// gotByte is set once the first byte of the response arrived, for the requests retried on
+	// a stale connection.
+	gotByte bool
+	// i is the index of the request, 0 for the original one.
+	i      int
+	cancel context.CancelFunc
+}
+
+// exchange sends req via client, hedging it if the call asks for it. It returns the response along
+// with whether its connection was reused, and the cancel func of the winning request of a hedged exchange.
+func (c *call) exchange(ctx context.Context, client *http.Client, req *http.Request, timer *callTimer, pool *poolStats, clk Clock) (*http.Response, bool, context.CancelFunc, error) {
+	if c.hedgeMax <= 0 {
+		res := roundTrip(ctx, client, req, timer, pool)
+		return res.resp, res.reused, nil, res.err
+	}
+	results := make(chan hedgeResult, c.hedgeMax+1)
+	var cancels []context.CancelCauseFunc
+	launch := func() {
+		actx, cancelCause := context.WithCancelCause(ctx)
+		cancel := func() { cancelCause(nil) }
+		i := len(cancels)
+		cancels = append(cancels, cancelCause)
+		r := req
+		if i > 0 {
+			r = req.Clone(ctx)
+			if req.GetBody != nil {
+				var err error
+				if r.Body, err = req.GetBody(); err != nil {
+					results <- hedgeResult{err: err, i: i, cancel: cancel}
+					return
+				}
+			}
+		}
+		go func() {
+			res := roundTrip(actx, client, r, timer, pool)
+			res.i, res.cancel = i, cancel
+			results <- res
+		}()
+	}
+	max := c.hedgeMax
+	if !IsReplayable(req) {
+		max = 0
+	}
+	launch()
+	pending := 1
+	t := clk.NewTimer(c.hedgeDelay)
+	defer t.Stop()
+	var first error
+	for {
+		var fire <-chan time.Time
+		if len(cancels) <= max {
+			fire = t.C()
+		}
+		select {
+		case <-fire:
+			launch()
+			pending++
+			c.hedges++
+			t.Reset(c.hedgeDelay)
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				for i, cancel := range cancels {
+					if i != res.i {
+						cancel(ErrHedgeLost)
+					}
+				}
+				go discardHedges(results, pending)
+				return res.resp, res.reused, res.cancel, nil
+			}
+			res.cancel()
+			if first == nil {
+				first = res.err
+			}
+			if pending > 0 {
+				continue
+			}
+			if len(cancels) > max || ctx.Err() != nil {
+				return nil, false, nil, first
+			}
+			// Nothing is outstanding anymore, so the next duplicate goes out right away.
+			launch()
+			pending++
+			c.hedges++
+			t.Reset(c.hedgeDelay)
+		}
+	}
+}
+
+// roundTrip sends req via client bound to ctx, tracing whether its connection was reused and
+// counting its connection in pool. A request the transport doesn't retry itself is sent again
+// once if its reused connection turns out to be closed by the server, see retriesStaleConn.
+func roundTrip(ctx context.Context, client *http.Client, req *http.Request, timer *callTimer, pool *poolStats) hedgeResult {
+	var res hedgeResult
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			res.reused = info.Reused
+			timer.enter(PhaseWaitingHeaders)
+			pool.gotConn(info.Reused, info.WasIdle)
+		},
+		PutIdleConn: pool.putIdle,
+	}
+	if req.URL.Scheme == "https" {
+		var start time.Time
+		trace.TLSHandshakeStart = func() { start = timer.clk.Now() }
+		trace.TLSHandshakeDone = func(_ tls.ConnectionState, err error) { pool.handshakeDone(timer.clk.Now().Sub(start), err) }
+	}
+	stale := retriesStaleConn(req)
+	if stale {
+		trace.GotFirstResponseByte = func() { res.gotByte = true }
+	}
+	ctx = httptrace.WithClientTrace(ctx, trace)
+	res.resp, res.err = client.Do(req.WithContext(ctx))
+	if stale && res.err != nil && res.reused && !res.gotByte && isStaleConn(res.err) && ctx.Err() == nil {
+		if retry, err := replay(req); err == nil {
+			pool.staleRetries.Add(1)
+			res.resp, res.err = client.Do(retry.WithContext(ctx))
+		}
+	}
+	return res
+}
+
+// discardHedges closes the responses of the n requests still outstanding once a hedged exchange is won.
+func discardHedges(results <-chan hedgeResult, n int) {
+	for ; n > 0; n-- {
+		if res := <-results; res.resp != nil {
+			res.resp.Body.Close()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedging(t *testing.T) {
+	var hits atomic.Int32
+	canceled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+				close(canceled)
+			case <-time.After(5 * time.Second):
+				w.Write([]byte(`"slow"`))
+			}
+			return
+		}
+		w.Write([]byte(`"fast"`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	h := &recordHandler{}
+	a.SetLogger(slog.New(h))
+
+	var out string
+	var meta ResponseMeta
+	err := a.Get(context.Background(), "/", nil, &out, WithHedging(20*time.Millisecond, 2), WithMeta(&meta))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "fast", out)
+	assert.Equal(t, 1, meta.Hedges)
+	if assert.Len(t, h.records, 1) {
+		assert.Equal(t, "1", recordAttrs(h.records[0])["hedges"])
+	}
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the slow request wasn't canceled")
+	}
+	assert.Equal(t, int32(2), hits.Load())
+
+	// A response arriving within the delay isn't hedged.
+	err = a.Get(context.Background(), "/", nil, &out, WithHedging(time.Second, 2), WithMeta(&meta))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, meta.Hedges)
+	assert.Equal(t, int32(3), hits.Load())
+}
+
+func TestHedgingLostCause(t *testing.T) {
+	a := MustNew("http://example.com")
+	var hits atomic.Int32
+	cause := make(chan error, 1)
+	a.Client = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		if hits.Add(1) == 1 {
+			<-req.Context().Done()
+			cause <- context.Cause(req.Context())
+			return nil, req.Context().Err()
+		}
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: req}, nil
+	})}
+	assert.NoError(t, a.Get(context.Background(), "/", nil, nil, WithHedging(10*time.Millisecond, 1)))
+	select {
+	case err := <-cause:
+		assert.ErrorIs(t, err, ErrHedgeLost)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the losing request wasn't canceled")
+	}
+}
+
+func TestHedgingFailover(t *testing.T) {
+	a := MustNew("http://example.com")
+	var hits atomic.Int32
+	a.Client = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		if hits.Add(1) == 1 {
+			return nil, context.DeadlineExceeded
+		}
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: req}, nil
+	})}
+	var meta ResponseMeta
+	// The failed original request is followed by a duplicate right away.
+	assert.NoError(t, a.Get(context.Background(), "/", nil, nil, WithHedging(time.Hour, 1), WithMeta(&meta)))
+	assert.Equal(t, 1, meta.Hedges)
+	assert.Equal(t, int32(2), hits.Load())
+
+	// Once every request failed, the error of the first one is returned.
+	a.Client.Transport = roundTripper(func(req *http.Request) (*http.Response, error) {
+		if hits.Add(1) == 3 {
+			return nil, context.DeadlineExceeded
+		}
+		return nil, context.Canceled
+	})
+	err := a.Get(context.Background(), "/", nil, nil, WithHedging(time.Hour, 1))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(4), hits.Load())
+}
+
+func TestHedgingInvalid(t *testing.T) {
+	a := MustNew("http://example.com")
+	a.Client = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		t.Error("unexpected request")
+		return nil, context.Canceled
+	})}
+	err := a.Post(context.Background(), "/", nil, nil, WithHedging(time.Millisecond, 1))
+	assert.EqualError(t, err, "api: hedging requires a GET or HEAD request, got POST")
+	err = a.Get(context.Background(), "/", nil, nil, WithHedging(0, 1))
+	assert.EqualError(t, err, "api: invalid hedging: delay 0s, max extra 1")
+	err = a.Get(context.Background(), "/", nil, nil, WithHedging(time.Millisecond, -1))
+	assert.Error(t, err)
+
+	// A zero maxExtra removes the hedging of the defaults.
+	a.SetDefaults(WithHedging(time.Millisecond, 1))
+	req, _ := a.Request(POST, "/", nil)
+	_, err = a.Do(context.Background(), req)
+	assert.Error(t, err)
+	a.Client.Transport = roundTripper(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: req}, nil
+	})
+	assert.NoError(t, a.Post(context.Background(), "/", nil, nil, WithHedging(0, 0)))
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Get fetches the resource with args in the query and decodes the JSON response into out, see DoJSON.
+func (a *Api) Get(ctx context.Context, resource string, args url.Values, out interface{}, opts ...Option) error {
+	return a.DoJSON(ctx, GET, resource, args, out, opts...)
+}
+
+// Post sends body encoded as JSON to the resource and decodes the JSON response into out, see DoJSON.
+// If body is nil, the request has no body; if it's a *Form, it's sent form-encoded, see RequestForm.
+func (a *Api) Post(ctx context.Context, resource string, body, out interface{}, opts ...Option) error {
+	return a.doJSONBody(ctx, POST, resource, body, out, opts)
+}
+
+// Put is like Post, but uses the PUT method.
+func (a *Api) Put(ctx context.Context, resource string, body, out interface{}, opts ...Option) error {
+	return a.doJSONBody(ctx, PUT, resource, body, out, opts)
+}
+
+// Patch is like Post, but uses the PATCH method.
+func (a *Api) Patch(ctx context.Context, resource string, body, out interface{}, opts ...Option) error {
+	return a.doJSONBody(ctx, PATCH, resource, body, out, opts)
+}
+
+// Delete deletes the resource with args in the query. The response body, if any, is discarded.
+func (a *Api) Delete(ctx context.Context, resource string, args url.Values, opts ...Option) error {
+	return a.DoJSON(ctx, DELETE, resource, args, nil, opts...)
+}
+
+// Upload streams the body read from r with the content type to the resource and decodes the JSON
+// response into out, see DoJSON and RequestReader. Unless r is an io.Seeker, the call is retried
+// only when the server answered before the body was sent, see ExpectContinue.
+func (a *Api) Upload(ctx context.Context, method Method, resource, contentType string, r io.Reader, out interface{}, opts ...Option) error {
+	req, err := a.RequestReader(method, resource, contentType, r, buildContext(ctx))
+	if err != nil {
+		return err
+	}
+	return a.sendJSON(ctx, req, resource, out, opts)
+}
+
+func (a *Api) doJSONBody(ctx context.Context, method Method, resource string, body, out interface{}, opts []Option) error {
+	var req *http.Request
+	var err error
+	if form, ok := body.(*Form); ok {
+		req, err = a.RequestForm(method, resource, form, buildContext(ctx))
+	} else if body != nil {
+		build := []Option{buildContext(ctx)}
+		if a.newCall(opts).anyBody {
+			build = append(build, SkipRequestValidation())
+		}
+		req, err = a.RequestJSON(method, resource, body, build...)
+	} else {
+		req, err = a.emptyRequest(method, resource, buildContext(ctx))
+	}
+	if err != nil {
+		return err
+	}
+	return a.sendJSON(ctx, req, resource, out, opts)
+}
+
+// emptyRequest creates a request without a body or query.
+func (a *Api) emptyRequest(method Method, resource string, opts ...Option) (*http.Request, error) {
+	u, err := a.resourceURL(resource)
+	if err != nil {
+		return nil, err
+	}
+	req := newRequest(method, u)
+	if err := a.shape(req, opts); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
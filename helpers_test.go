@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// itemServer echoes the method, query and JSON body of the requests it gets.
+func itemServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/items/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case r.URL.Path == "/items/gone":
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"method":       r.Method,
+			"query":        r.URL.RawQuery,
+			"body":         string(body),
+			"content_type": r.Header.Get("Content-Type"),
+			"auth":         r.Header.Get("Authorization"),
+		})
+	}))
+}
+
+type echo struct {
+	Method      string `json:"method"`
+	Query       string `json:"query"`
+	Body        string `json:"body"`
+	ContentType string `json:"content_type"`
+	Auth        string `json:"auth"`
+}
+
+func TestGet(t *testing.T) {
+	srv := itemServer()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Header = http.Header{"Authorization": {"Bearer token"}}
+
+	var out echo
+	if !assert.NoError(t, a.Get(context.Background(), "/items", url.Values{"page": {"2"}}, &out)) {
+		return
+	}
+	assert.Equal(t, echo{Method: "GET", Query: "page=2", Auth: "Bearer token"}, out)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, nil))
+
+	err := a.Get(context.Background(), "/items/missing", nil, &out)
+	var se *StatusError
+	if assert.ErrorAs(t, err, &se) {
+		assert.Equal(t, http.StatusNotFound, se.Code)
+	}
+}
+
+func TestPostPutPatch(t *testing.T) {
+	srv := itemServer()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	for method, do := range map[string]func(ctx context.Context, resource string, body, out interface{}, opts ...Option) error{
+		"POST":  a.Post,
+		"PUT":   a.Put,
+		"PATCH": a.Patch,
+	} {
+		var out echo
+		if !assert.NoError(t, do(context.Background(), "/items/1", item{ID: 1, Name: "one"}, &out), method) {
+			return
+		}
+		assert.Equal(t, echo{Method: method, Body: `{"id":1,"name":"one"}`, ContentType: "application/json"}, out, method)
+
+		out = echo{}
+		assert.NoError(t, do(context.Background(), "/items/1", nil, &out), method)
+		assert.Equal(t, echo{Method: method}, out, method)
+		assert.NoError(t, do(context.Background(), "/items/1", item{}, nil), method)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	srv := itemServer()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	assert.NoError(t, a.Delete(context.Background(), "/items/1", url.Values{"force": {"1"}}))
+	assert.NoError(t, a.Delete(context.Background(), "/items/gone", nil))
+	assert.Error(t, a.Delete(context.Background(), "/items/missing", nil))
+
+	// A 204 response leaves out untouched.
+	out := echo{Method: "unchanged"}
+	assert.NoError(t, a.Patch(context.Background(), "/items/gone", item{}, &out))
+	assert.Equal(t, "unchanged", out.Method)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaxSticky is the number of sticky values kept unless HintPolicy.MaxSticky is set.
+const defaultMaxSticky = 1000
+
+// HintPolicy maps the headers of the responses carrying operational hints of the server to the
+// behaviors of the client, see SetHints. An empty header name disables its hint.
+type HintPolicy struct {
+	// NoRetry names the header whose "true" value stops the call from being retried, whatever
+	// the RetryPolicy, e.g. X-Client-No-Retry.
+	NoRetry string
+	// Backoff names the header carrying a number of milliseconds to wait before the next call
+	// to the same resource, e.g. X-Client-Backoff-Ms.
+	Backoff string
+	// Sticky names the headers whose values are echoed on the following requests to the same
+	// host for StickyTTL, e.g. X-Route-Shard for session affinity, unless the request sets them.
+	Sticky []string
+	// StickyTTL is how long a sticky value is echoed after it was last received, 10m if zero.
+	StickyTTL time.Duration
+	// MaxSticky is the number of sticky values kept, 1000 if zero: once it's reached, the expired
+	// values are dropped, then those expiring first.
+	MaxSticky int
+}
+
+// SetHints makes the Api follow the hints of the response headers named by p: a NoRetry hint
+// stops the retries of the call, a Backoff hint delays the next call to the resource, the template
+// of a Template call, until its context is done, and the Sticky headers are captured from every
+// response and echoed on the requests to the same host. A nil p disables the hints and forgets
+// their state.
+func (a *Api) SetHints(p *HintPolicy) {
+	if p == nil {
+		a.hints.Store(nil)
+		return
+	}
+	h := &hints{policy: *p, sticky: make(map[stickyKey]stickyValue), backoff: make(map[string]time.Time)}
+	if h.policy.StickyTTL <= 0 {
+		h.policy.StickyTTL = 10 * time.Minute
+	}
+	if h.policy.MaxSticky <= 0 {
+		h.policy.MaxSticky = defaultMaxSticky
+	}
+	h.policy.Sticky = make([]string, len(p.Sticky))
+	for i, name := range p.Sticky {
+		h.policy.Sticky[i] = http.CanonicalHeaderKey(name)
+	}
+	a.hints.Store(h)
+}
+
+// hints is the state of a HintPolicy.
+type hints struct {
+	policy  HintPolicy
+	mu      sync.Mutex
+	sticky  map[stickyKey]stickyValue
+	backoff map[string]time.Time
+}
+
+type stickyKey struct {
+	host, name string
+}
+
+type stickyValue struct {
+	value   string
+	expires time.Time
+}
+
+// wait waits until the backoff hinted for resource is over.
+func (h *hints) wait(ctx context.Context, resource string, clk Clock) error {
+	h.mu.Lock()
+	until, ok := h.backoff[resource]
+	h.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if d := until.Sub(clk.Now()); d > 0 {
+		if err := clk.Sleep(ctx, d); err != nil {
+			return withCause(ctx, err)
+		}
+	}
+	return nil
+}
+
+// echo sets the sticky headers of the host of req that it doesn't set itself.
+func (h *hints) echo(req *http.Request, now time.Time) {
+	if len(h.policy.Sticky) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, name := range h.policy.Sticky {
+		if _, ok := req.Header[name]; ok {
+			continue
+		}
+		if v, ok := h.sticky[stickyKey{req.URL.Host, name}]; ok && now.Before(v.expires) {
+			req.Header.Set(name, v.value)
+		}
+	}
+}
+
+// capture keeps the hints of resp, received for req to resource.
+func (h *hints) capture(resource string, req *http.Request, resp *http.Response, now time.Time) {
+	var until time.Time
+	if h.policy.Backoff != "" {
+		if ms, err := strconv.ParseInt(strings.TrimSpace(resp.Header.Get(h.policy.Backoff)), 10, 64); err == nil && ms > 0 {
+			until = now.Add(time.Duration(ms) * time.Millisecond)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !until.IsZero() {
+		h.backoff[resource] = until
+	}
+	for resource, until := range h.backoff {
+		if !now.Before(until) {
+			delete(h.backoff, resource)
+		}
+	}
+	for _, name := range h.policy.Sticky {
+		if v := resp.Header.Get(name); v != "" {
+			h.sticky[stickyKey{req.URL.Host, name}] = stickyValue{value: v, expires: now.Add(h.policy.StickyTTL)}
+		}
+	}
+	h.evict(now)
+}
+
+// evict drops the expired sticky values once there are more than MaxSticky, then those expiring first.
+func (h *hints) evict(now time.Time) {
+	if len(h.sticky) <= h.policy.MaxSticky {
+		return
+	}
+	for key, v := range h.sticky {
+		if !now.Before(v.expires) {
+			delete(h.sticky, key)
+		}
+	}
+	for len(h.sticky) > h.policy.MaxSticky {
+		var first stickyKey
+		found := false
+		for key, v := range h.sticky {
+			if !found || v.expires.Before(h.sticky[first].expires) {
+				first, found = key, true
+			}
+		}
+		delete(h.sticky, first)
+	}
+}
+
+// noRetry reports whether header carries the NoRetry hint.
+func (h *hints) noRetry(header http.Header) bool {
+	return h != nil && h.policy.NoRetry != "" && strings.EqualFold(strings.TrimSpace(header.Get(h.policy.NoRetry)), "true")
+}
+
+// noRetryErr reports whether the response err was returned for carries the NoRetry hint.
+func (h *hints) noRetryErr(err error) bool {
+	var se *StatusError
+	return h != nil && errors.As(err, &se) && h.noRetry(se.Header)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHintsSticky(t *testing.T) {
+	var mu sync.Mutex
+	var shards []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		shards = append(shards, r.Header.Get("X-Route-Shard"))
+		mu.Unlock()
+		if r.URL.Path == "/login" {
+			w.Header().Set("X-Route-Shard", "shard-7")
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := fakeClock()
+	a.SetClock(clk)
+	a.SetHints(&HintPolicy{Sticky: []string{"x-route-shard"}, StickyTTL: time.Minute})
+	ctx := context.Background()
+
+	assert.NoError(t, a.DoJSON(ctx, POST, "/login", nil, nil))
+	assert.NoError(t, a.DoJSON(ctx, GET, "/cart", nil, nil))
+	// The request's own value wins.
+	assert.NoError(t, a.DoJSON(ctx, GET, "/cart", nil, nil, WithHeader("X-Route-Shard", "shard-1")))
+	clk.Advance(2 * time.Minute)
+	assert.NoError(t, a.DoJSON(ctx, GET, "/cart", nil, nil))
+	assert.Equal(t, []string{"", "shard-7", "shard-1", ""}, shards)
+}
+
+func TestHintsStickyBounded(t *testing.T) {
+	h := &hints{policy: HintPolicy{Sticky: []string{"X-Shard"}, StickyTTL: time.Minute, MaxSticky: 2},
+		sticky: make(map[stickyKey]stickyValue), backoff: make(map[string]time.Time)}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, host := range []string{"a", "b", "c"} {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		resp := &http.Response{Header: http.Header{"X-Shard": {host}}, Request: req}
+		h.capture("/", req, resp, now.Add(time.Duration(i)*time.Second))
+	}
+	assert.Len(t, h.sticky, 2)
+	_, ok := h.sticky[stickyKey{"a", "X-Shard"}]
+	assert.False(t, ok)
+}
+
+func TestHintsNoRetry(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/final" {
+			w.Header().Set("X-Client-No-Retry", "true")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(fakeClock())
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	a.SetHints(&HintPolicy{NoRetry: "X-Client-No-Retry"})
+
+	err := a.DoJSON(context.Background(), GET, "/final", nil, nil)
+	var se *StatusError
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	assert.Error(t, a.DoJSON(context.Background(), GET, "/other", nil, nil))
+	assert.Equal(t, 4, calls)
+}
+
+func TestHintsBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/1" {
+			w.Header().Set("X-Client-Backoff-Ms", "1500")
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := fakeClock()
+	a.SetClock(clk)
+	a.SetHints(&HintPolicy{Backoff: "X-Client-Backoff-Ms"})
+	users := a.Template(GET, "/users/{id}")
+	ctx := context.Background()
+
+	start := clk.Now()
+	assert.NoError(t, users.Do(ctx, map[string]string{"id": "1"}, nil, nil))
+	assert.Equal(t, time.Duration(0), clk.Now().Sub(start))
+	// Another resource isn't delayed.
+	assert.NoError(t, a.DoJSON(ctx, GET, "/health", nil, nil))
+	assert.Equal(t, time.Duration(0), clk.Now().Sub(start))
+	// The next call to the template waits.
+	assert.NoError(t, users.Do(ctx, map[string]string{"id": "2"}, nil, nil))
+	assert.Equal(t, 1500*time.Millisecond, clk.Now().Sub(start))
+	// The backoff isn't hinted again.
+	assert.NoError(t, users.Do(ctx, map[string]string{"id": "2"}, nil, nil))
+	assert.Equal(t, 1500*time.Millisecond, clk.Now().Sub(start))
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.NoError(t, users.Do(context.Background(), map[string]string{"id": "1"}, nil, nil))
+	assert.ErrorIs(t, users.Do(ctx, map[string]string{"id": "2"}, nil, nil), context.Canceled)
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Host is a host of a HostPolicy, an origin like "https://eu.api.example.com" with its share of the calls.
+type Host struct {
+	URL    string
+	Weight int
+}
+
+// HostPolicy distributes the calls of an Api over several hosts serving the same API, see SetHosts.
+type HostPolicy struct {
+	// Hosts are the hosts to distribute the calls over, each receiving a share proportional to its Weight.
+	Hosts []Host
+	// MaxFailures is the number of consecutive failures that take a host out of rotation, 3 if zero.
+	MaxFailures int
+	// Cooldown is how long a failing host stays out of rotation, 30s if zero. The next call sent to it
+	// afterwards decides whether it's back: a failure takes it out again right away.
+	Cooldown time.Duration
+	// Rand is the source of the picks, the global source of math/rand if nil. Set it to a seeded
+	// source to get a deterministic distribution in tests.
+	Rand *rand.Rand
+}
+
+// HostStats are the counters of a host of the HostPolicy, see Api.HostStats.
+type HostStats struct {
+	URL    string
+	Weight int
+	// InFlight is the number of calls sent to the host whose response body hasn't been closed yet.
+	InFlight int
+	// Requests and Errors count the calls sent to the host and the ones that failed.
+	Requests, Errors int64
+	// ConsecutiveErrors counts the failures since the last success.
+	ConsecutiveErrors int
+	// Down reports whether the host is out of rotation, until DownUntil.
+	Down      bool
+	DownUntil time.Time
+}
+
+// hostPool is the state of the HostPolicy set by SetHosts.
+type hostPool struct {
+	maxFailures int
+	cooldown    time.Duration
+	mu          sync.Mutex
+	rnd         *rand.Rand
+	hosts       []*poolHost
+}
+
+type poolHost struct {
+	pool      *hostPool
+	url       *url.URL
+	origin    string
+	weight    int
+	inFlight  int
+	requests  int64
+	errors    int64
+	failures  int
+	downUntil time.Time
+}
+
+// SetHosts makes Do and the Do-style helpers distribute the calls for the base URI over the hosts
+// of p by their weights. Their scheme and host replace the ones of the request URL, the path is kept.
+// Calls sent to any of the hosts are distributed too, e.g. the pages requested by List.
+// A host failing p.MaxFailures times in a row, with a transport error or a 5xx status,
+// is taken out of rotation for p.Cooldown; if all of them are, calls are distributed over all of them.
+// A retry is sent to another host than the one that just failed whenever possible.
+// A nil p restores the single-base behavior.
+func (a *Api) SetHosts(p *HostPolicy) error {
+	if p == nil {
+		a.hosts.Store(nil)
+		return nil
+	}
+	if len(p.Hosts) == 0 {
+		return errors.New("api: host policy without hosts")
+	}
+	pool := &hostPool{maxFailures: p.MaxFailures, cooldown: p.Cooldown, rnd: p.Rand}
+	if pool.maxFailures <= 0 {
+		pool.maxFailures = 3
+	}
+	if pool.cooldown <= 0 {
+		pool.cooldown = 30 * time.Second
+	}
+	for _, h := range p.Hosts {
+		u, err := url.ParseRequestURI(h.URL)
+		if err != nil {
+			return fmt.Errorf("api: host %s: %w", h.URL, err)
+		}
+		if u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("api: host %s: not an origin", h.URL)
+		}
+		if h.Weight <= 0 {
+			return fmt.Errorf("api: host %s: weight must be positive, got %d", h.URL, h.Weight)
+		}
+		pool.hosts = append(pool.hosts, &poolHost{pool: pool, url: u, origin: origin(u), weight: h.Weight})
+	}
+	a.hosts.Store(pool)
+	return nil
+}
+
+// HostStats returns the counters of the hosts set by SetHosts, in their order, or nil if there are none.
+func (a *Api) HostStats() []HostStats {
+	pool := a.hosts.Load()
+	if pool == nil {
+		return nil
+	}
+	now := a.clock().Now()
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	stats := make([]HostStats, len(pool.hosts))
+	for i, h := range pool.hosts {
+		stats[i] = HostStats{
+			URL:               h.origin,
+			Weight:            h.weight,
+			InFlight:          h.inFlight,
+			Requests:          h.requests,
+			Errors:            h.errors,
+			ConsecutiveErrors: h.failures,
+			Down:              now.Before(h.downUntil),
+			DownUntil:         h.downUntil,
+		}
+	}
+	return stats
+}
+
+func origin(u *url.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+// pickHost returns req retargeted to a host of the pool, and the picked host, or req itself
+// and nil if req isn't sent to the base URI or a host of the pool. The host avoid is skipped if possible.
+func (a *Api) pickHost(req *http.Request, avoid *poolHost, now time.Time) (*http.Request, *poolHost) {
+	pool := a.hosts.Load()
+	if pool == nil {
+		return req, nil
+	}
+	o := origin(req.URL)
+	if o != origin(a.baseURI()) && !pool.has(o) {
+		return req, nil
+	}
+	h := pool.pick(avoid, now)
+	u := *req.URL
+	u.Scheme, u.Host = h.url.Scheme, h.url.Host
+	r := *req
+	r.URL = &u
+	if req.Host == "" || req.Host == req.URL.Host {
+		r.Host = u.Host
+	}
+	return &r, h
+}
+
+func (p *hostPool) has(origin string) bool {
+	for _, h := range p.hosts {
+		if h.origin == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// pick chooses a host by weight among the ones in rotation other than avoid, falling back
+// to the ones in rotation and then to all of them, and counts the call as in flight.
+func (p *hostPool) pick(avoid *poolHost, now time.Time) *poolHost {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	candidates := make([]*poolHost, 0, len(p.hosts))
+	for _, h := range p.hosts {
+		if h != avoid && !now.Before(h.downUntil) {
+			candidates = append(candidates, h)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, h := range p.hosts {
+			if !now.Before(h.downUntil) {
+				candidates = append(candidates, h)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.hosts
+	}
+	total := 0
+	for _, h := range candidates {
+		total += h.weight
+	}
+	var n int
+	if p.rnd != nil {
+		n = p.rnd.Intn(total)
+	} else {
+		n = rand.Intn(total)
+	}
+	h := candidates[len(candidates)-1]
+	for _, c := range candidates {
+		if n < c.weight {
+			h = c
+			break
+		}
+		n -= c.weight
+	}
+	h.inFlight++
+	h.requests++
+	return h
+}
+
+// report records the outcome of a call sent to h.
+func (p *hostPool) report(h *poolHost, failed bool, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !failed {
+		h.failures = 0
+		return
+	}
+	h.errors++
+	if h.failures++; h.failures >= p.maxFailures {
+		h.downUntil = now.Add(p.cooldown)
+	}
+}
+
+// release records the end of a call sent to h.
+func (p *hostPool) release(h *poolHost) {
+	p.mu.Lock()
+	h.inFlight--
+	p.mu.Unlock()
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+// hostsTransport answers every request with the status set for its host, 200 by default.
+type hostsTransport struct {
+	mu     sync.Mutex
+	status map[string]int
+	hits   map[string]int
+}
+
+func (t *hostsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if req.Host != req.URL.Host {
+		return nil, errors.New("host header mismatch: " + req.Host)
+	}
+	t.hits[req.URL.Host]++
+	code := t.status[req.URL.Host]
+	if code == 0 {
+		code = http.StatusOK
+	}
+	if code < 0 {
+		return nil, syscall.ECONNREFUSED
+	}
+	return &http.Response{StatusCode: code, Header: make(http.Header), Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+}
+
+func newHostsApi(t *testing.T) (*Api, *hostsTransport) {
+	tr := &hostsTransport{status: make(map[string]int), hits: make(map[string]int)}
+	a := MustNew("https://api.example.com/v1")
+	a.Client = &http.Client{Transport: tr}
+	err := a.SetHosts(&HostPolicy{
+		Hosts: []Host{
+			{URL: "https://eu.example.com", Weight: 70},
+			{URL: "https://us.example.com", Weight: 15},
+			{URL: "https://ap.example.com", Weight: 15},
+		},
+		Rand: rand.New(rand.NewSource(1)),
+	})
+	assert.NoError(t, err)
+	return a, tr
+}
+
+func TestHostsDistribution(t *testing.T) {
+	a, tr := newHostsApi(t)
+	for i := 0; i < 10000; i++ {
+		req, err := a.Request(GET, "/items", nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp, err := a.Do(context.Background(), req)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "/v1/items", resp.Request.URL.Path)
+		assert.Equal(t, "api.example.com", req.URL.Host)
+		resp.Body.Close()
+	}
+	assert.InDelta(t, 7000, tr.hits["eu.example.com"], 150)
+	assert.InDelta(t, 1500, tr.hits["us.example.com"], 150)
+	assert.InDelta(t, 1500, tr.hits["ap.example.com"], 150)
+	assert.Equal(t, 10000, tr.hits["eu.example.com"]+tr.hits["us.example.com"]+tr.hits["ap.example.com"])
+
+	stats := a.HostStats()
+	if assert.Len(t, stats, 3) {
+		assert.Equal(t, "https://eu.example.com", stats[0].URL)
+		assert.Equal(t, int64(tr.hits["eu.example.com"]), stats[0].Requests)
+		for _, s := range stats {
+			assert.Equal(t, 0, s.InFlight)
+			assert.Equal(t, int64(0), s.Errors)
+		}
+	}
+
+	// Requests to other hosts aren't distributed.
+	req, _ := a.Request(GET, "/items", nil)
+	req.URL.Host = "other.example.com"
+	req.Host = req.URL.Host
+	resp, err := a.Do(context.Background(), req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.Equal(t, 1, tr.hits["other.example.com"])
+
+	// The in-flight count lasts until the body is closed.
+	req, _ = a.Request(GET, "/items", nil)
+	resp, err = a.Do(context.Background(), req)
+	if assert.NoError(t, err) {
+		var inFlight int
+		for _, s := range a.HostStats() {
+			inFlight += s.InFlight
+		}
+		assert.Equal(t, 1, inFlight)
+		resp.Body.Close()
+	}
+
+	assert.NoError(t, a.SetHosts(nil))
+	assert.Nil(t, a.HostStats())
+	assert.Error(t, a.SetHosts(&HostPolicy{}))
+	assert.Error(t, a.SetHosts(&HostPolicy{Hosts: []Host{{URL: "https://eu.example.com/v1", Weight: 1}}}))
+	assert.Error(t, a.SetHosts(&HostPolicy{Hosts: []Host{{URL: "https://eu.example.com", Weight: 0}}}))
+}
+
+func TestHostsFailover(t *testing.T) {
+	a, tr := newHostsApi(t)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	clk.AutoAdvance(true)
+	a.SetClock(clk)
+	a.Retry = &RetryPolicy{MaxRetries: 1, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	tr.status["eu.example.com"] = http.StatusServiceUnavailable
+
+	// Every call failing on eu is retried on another host, until eu is out of rotation.
+	for i := 0; i < 50; i++ {
+		if !assert.NoError(t, a.Get(context.Background(), "/items", nil, nil)) {
+			return
+		}
+	}
+	assert.Equal(t, 3, tr.hits["eu.example.com"])
+	assert.Equal(t, 50, tr.hits["us.example.com"]+tr.hits["ap.example.com"])
+	eu := a.HostStats()[0]
+	assert.Equal(t, int64(3), eu.Errors)
+	assert.Equal(t, 3, eu.ConsecutiveErrors)
+	assert.True(t, eu.Down)
+	assert.True(t, eu.DownUntil.After(clk.Now()))
+	assert.False(t, eu.DownUntil.After(clk.Now().Add(30*time.Second)))
+
+	// Back in rotation after the cooldown, eu is taken out again by its next failure.
+	clk.Advance(31 * time.Second)
+	assert.False(t, a.HostStats()[0].Down)
+	tr.hits = make(map[string]int)
+	for tr.hits["eu.example.com"] == 0 {
+		assert.NoError(t, a.Get(context.Background(), "/items", nil, nil))
+	}
+	assert.True(t, a.HostStats()[0].Down)
+
+	// Transport errors count too, and a recovered host is reset by its first success.
+	tr.status["us.example.com"] = -1
+	tr.hits = make(map[string]int)
+	for tr.hits["us.example.com"] == 0 {
+		assert.NoError(t, a.Get(context.Background(), "/items", nil, nil))
+	}
+	us := a.HostStats()[1]
+	assert.Equal(t, 1, us.ConsecutiveErrors)
+	assert.False(t, us.Down)
+	delete(tr.status, "us.example.com")
+	for a.HostStats()[1].ConsecutiveErrors != 0 {
+		assert.NoError(t, a.Get(context.Background(), "/items", nil, nil))
+	}
+
+	// With all hosts down, calls still go out.
+	a.Retry = nil
+	for _, host := range []string{"us.example.com", "ap.example.com"} {
+		tr.status[host] = http.StatusBadGateway
+	}
+	for i := 0; i < 20; i++ {
+		a.Get(context.Background(), "/items", nil, nil)
+	}
+	for _, s := range a.HostStats() {
+		assert.True(t, s.Down, s.URL)
+	}
+	var se *StatusError
+	assert.True(t, errors.As(a.Get(context.Background(), "/items", nil, nil), &se))
+}
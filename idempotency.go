@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidIdempotencyKey is matched by every *IdempotencyKeyError.
+var ErrInvalidIdempotencyKey = errors.New("api: invalid idempotency key")
+
+// IdempotencyKeyError is returned when the request is created for a key of IdempotencyFrom not
+// meeting the IdempotencyPolicy of the Api.
+type IdempotencyKeyError struct {
+	Key string
+	// Reason is the constraint the key doesn't meet.
+	Reason string
+}
+
+func (e *IdempotencyKeyError) Error() string {
+	return fmt.Sprintf("api: invalid idempotency key %q: %s", e.Key, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrInvalidIdempotencyKey) report true.
+func (e *IdempotencyKeyError) Is(target error) bool { return target == ErrInvalidIdempotencyKey }
+
+// Class makes the error a Permanent failure, since the same key would be derived again.
+func (e *IdempotencyKeyError) Class() Class { return Permanent }
+
+// DefaultIdempotencyCharset is the set of characters allowed in the idempotency keys unless
+// IdempotencyPolicy.Charset sets another one.
+const DefaultIdempotencyCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.:"
+
+// IdempotencyPolicy is what the vendor accepts as idempotency keys, see SetIdempotencyPolicy.
+type IdempotencyPolicy struct {
+	// Header is the header the key is sent in, "Idempotency-Key" if empty.
+	Header string
+	// MinLength and MaxLength bound the length of the keys, 1 and 255 if zero.
+	MinLength, MaxLength int
+	// Charset is the set of characters allowed in the keys, DefaultIdempotencyCharset if empty.
+	Charset string
+}
+
+// SetIdempotencyPolicy sets the constraints the keys of IdempotencyFrom are checked against.
+// A nil p restores the defaults of IdempotencyPolicy.
+func (a *Api) SetIdempotencyPolicy(p *IdempotencyPolicy) {
+	if p == nil {
+		a.idempotency.Store(nil)
+		return
+	}
+	policy := *p
+	a.idempotency.Store(&policy)
+}
+
+var defaultIdempotencyPolicy = &IdempotencyPolicy{}
+
+func (a *Api) idempotencyPolicy() *IdempotencyPolicy {
+	if p := a.idempotency.Load(); p != nil {
+		return p
+	}
+	return defaultIdempotencyPolicy
+}
+
+func (p *IdempotencyPolicy) header() string {
+	if p.Header == "" {
+		return "Idempotency-Key"
+	}
+	return p.Header
+}
+
+// check returns an *IdempotencyKeyError if key doesn't meet the policy.
+func (p *IdempotencyPolicy) check(key string) error {
+	min, max := p.MinLength, p.MaxLength
+	if min <= 0 {
+		min = 1
+	}
+	if max <= 0 {
+		max = 255
+	}
+	charset := p.Charset
+	if charset == "" {
+		charset = DefaultIdempotencyCharset
+	}
+	switch {
+	case len(key) < min:
+		return &IdempotencyKeyError{Key: key, Reason: "shorter than " + strconv.Itoa(min) + " bytes"}
+	case len(key) > max:
+		return &IdempotencyKeyError{Key: key, Reason: "longer than " + strconv.Itoa(max) + " bytes"}
+	}
+	for i := 0; i < len(key); i++ {
+		if strings.IndexByte(charset, key[i]) < 0 {
+			return &IdempotencyKeyError{Key: key, Reason: fmt.Sprintf("character %q not allowed", key[i])}
+		}
+	}
+	return nil
+}
+
+// IdempotencyFrom makes the call send the idempotency key derived by fn from the context of the
+// call and its request, in the header of the IdempotencyPolicy of the Api, so the replays of the
+// same logical operation, e.g. after a crash, carry the same key across process restarts:
+//
+//	api.IdempotencyFrom(func(ctx context.Context, req *http.Request) string {
+//		return api.IdempotencyKey(tenantOf(ctx), op.ID, api.BodyDigest(req))
+//	})
+//
+// The key is derived when the request is created, its body encoded, and checked against the
+// policy: a key not meeting it fails the creation with an *IdempotencyKeyError. A request
+// already carrying a key keeps it. The key is reported in ResponseMeta.IdempotencyKey
+// and JournalEntry.IdempotencyKey.
+func IdempotencyFrom(fn func(ctx context.Context, req *http.Request) string) Option {
+	return func(c *call) {
+		c.idempotencyKey = fn
+	}
+}
+
+// applyIdempotency sets the key of IdempotencyFrom on req, if it doesn't have one yet.
+func (a *Api) applyIdempotency(ctx context.Context, c *call, req *http.Request) error {
+	p := a.idempotencyPolicy()
+	h := p.header()
+	if c.idempotencyKey != nil && req.Header.Get(h) == "" {
+		key := c.idempotencyKey(ctx, req)
+		if err := p.check(key); err != nil {
+			return err
+		}
+		req.Header.Set(h, key)
+	}
+	if c.meta != nil {
+		c.meta.IdempotencyKey = req.Header.Get(h)
+	}
+	return nil
+}
+
+// IdempotencyKey returns a key derived from parts, the hex SHA-256 of the parts each prefixed by
+// its length, so that no two different lists of parts give the same key.
+func IdempotencyKey(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		io.WriteString(h, strconv.Itoa(len(p)))
+		io.WriteString(h, ":")
+		io.WriteString(h, p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// BodyDigest returns the hex SHA-256 of the body of req, read from its encoded bytes or a copy,
+// e.g. for IdempotencyKey. It returns "" for the bodies that can only be read once.
+func BodyDigest(req *http.Request) string {
+	h := sha256.New()
+	data, encoded := encodedBody(req)
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+	case encoded:
+		h.Write(data)
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return ""
+		}
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return ""
+		}
+	default:
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type operationKey struct{}
+
+// operationIdempotency derives the key of a call from the tenant and operation of its context
+// and the digest of its body.
+var operationIdempotency = IdempotencyFrom(func(ctx context.Context, req *http.Request) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	op, _ := ctx.Value(operationKey{}).(string)
+	return IdempotencyKey(tenant, op, BodyDigest(req))
+})
+
+func TestIdempotencyFrom(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Idempotency-Key"))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	j := &memJournal{}
+	a.SetJournal(j, nil)
+	ctx := context.WithValue(context.WithValue(context.Background(), tenantKey{}, "acme"), operationKey{}, "op-1")
+	payout := map[string]int{"amount": 100}
+
+	// Replays of the same operation, e.g. by another process, carry the same key.
+	var meta ResponseMeta
+	assert.NoError(t, a.Post(ctx, "/payouts", payout, nil, operationIdempotency, WithMeta(&meta)))
+	b := MustNew(srv.URL)
+	assert.NoError(t, b.Post(ctx, "/payouts", map[string]int{"amount": 100}, nil, operationIdempotency))
+	want := IdempotencyKey("acme", "op-1", sha256Hex(`{"amount":100}`))
+	assert.Equal(t, []string{want, want}, got)
+	assert.Equal(t, want, meta.IdempotencyKey)
+	if assert.Len(t, j.entries, 1) {
+		assert.Equal(t, want, j.entries[0].IdempotencyKey)
+	}
+
+	// Other operations don't.
+	assert.NoError(t, a.Post(ctx, "/payouts", map[string]int{"amount": 200}, nil, operationIdempotency, WithMeta(&meta)))
+	assert.NotEqual(t, want, meta.IdempotencyKey)
+	req, err := a.RequestJSON(POST, "/payouts", payout, operationIdempotency)
+	if assert.NoError(t, err) {
+		assert.Equal(t, IdempotencyKey("", "", sha256Hex(`{"amount":100}`)), req.Header.Get("Idempotency-Key"))
+	}
+
+	// A key set by the caller is kept.
+	assert.NoError(t, a.Post(ctx, "/payouts", payout, nil, operationIdempotency, WithHeader("Idempotency-Key", "mine")))
+	assert.Equal(t, "mine", got[len(got)-1])
+}
+
+func TestIdempotencyPolicy(t *testing.T) {
+	a := MustNew("http://example.com")
+	a.SetIdempotencyPolicy(&IdempotencyPolicy{Header: "X-Request-Key", MaxLength: 32, Charset: "0123456789abcdef"})
+	key := func(k string) Option {
+		return IdempotencyFrom(func(context.Context, *http.Request) string { return k })
+	}
+	req, err := a.RequestJSON(POST, "/payouts", nil, key("c0ffee"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "c0ffee", req.Header.Get("X-Request-Key"))
+	}
+	for k, reason := range map[string]string{
+		"":                     "shorter than 1 bytes",
+		IdempotencyKey("op-1"): "longer than 32 bytes",
+		"C0FFEE":               `character 'C' not allowed`,
+	} {
+		_, err := a.RequestJSON(POST, "/payouts", nil, key(k))
+		var ke *IdempotencyKeyError
+		if assert.True(t, errors.As(err, &ke), "%v", err) {
+			assert.Equal(t, reason, ke.Reason)
+		}
+		assert.True(t, errors.Is(err, ErrInvalidIdempotencyKey))
+		assert.Equal(t, InvalidRequest, KindOf(err))
+	}
+}
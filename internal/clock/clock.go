@@ -0,0 +1,203 @@
+// Package clock abstracts the time source of the api package, so that
+// retries, backoffs and other timing can be tested deterministically.
+package clock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and sleeps.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for d or until ctx is done, returning ctx's error in the latter case.
+	Sleep(ctx context.Context, d time.Duration) error
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the equivalent of *time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Real is the Clock of the time package.
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+func (Real) Sleep(ctx context.Context, d time.Duration) error {
+	return sleep(ctx, Real{}, d)
+}
+
+func (Real) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+func sleep(ctx context.Context, c Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := c.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Fake is a Clock whose time only moves when advanced. Sleepers and timers
+// fire once the time has been advanced past their deadline.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	auto    bool
+	pending []*fakeTimer
+}
+
+// NewFake creates a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Sleep(ctx context.Context, d time.Duration) error {
+	return sleep(ctx, f, d)
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the time forward by d, firing the timers whose deadline has been reached in deadline order.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advanceTo(f.now.Add(d))
+}
+
+// AutoAdvance makes the clock advance to the deadline of every new timer right away,
+// so that sleeps return immediately while the time still passes as if they were real.
+func (f *Fake) AutoAdvance(on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auto = on
+}
+
+func (f *Fake) advanceTo(now time.Time) {
+	if now.After(f.now) {
+		f.now = now
+	}
+	sort.SliceStable(f.pending, func(i, j int) bool { return f.pending[i].when.Before(f.pending[j].when) })
+	n := 0
+	for n < len(f.pending) && !f.pending[n].when.After(f.now) {
+		f.pending[n].fire(f.now)
+		n++
+	}
+	if n > 0 {
+		f.pending = append(f.pending[:0], f.pending[n:]...)
+		f.cond.Broadcast()
+	}
+}
+
+// Sleepers returns the number of pending timers, including the ones of blocked Sleep calls.
+func (f *Fake) Sleepers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pending)
+}
+
+// Deadlines returns the times at which the pending timers fire, in order.
+func (f *Fake) Deadlines() []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deadlines := make([]time.Time, len(f.pending))
+	for i, t := range f.pending {
+		deadlines[i] = t.when
+	}
+	sort.Slice(deadlines, func(i, j int) bool { return deadlines[i].Before(deadlines[j]) })
+	return deadlines
+}
+
+// BlockUntil waits until at least n timers are pending, e.g. until the code under test is sleeping.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.pending) < n {
+		f.cond.Wait()
+	}
+}
+
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, p := range f.pending {
+		if p == t {
+			f.pending = append(f.pending[:i], f.pending[i+1:]...)
+			f.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *Fake
+	c     chan time.Time
+	when  time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	active := f.remove(t)
+	t.when = f.now.Add(d)
+	if d <= 0 {
+		t.fire(f.now)
+		return active
+	}
+	f.pending = append(f.pending, t)
+	f.cond.Broadcast()
+	if f.auto {
+		f.advanceTo(t.when)
+	}
+	return active
+}
+
+// fire delivers now on the timer's channel unless a previous value hasn't been received yet.
+func (t *fakeTimer) fire(now time.Time) {
+	select {
+	case t.c <- now:
+	default:
+	}
+}
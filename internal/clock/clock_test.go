@@ -0,0 +1,87 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(epoch)
+	done := make(chan error)
+	go func() { done <- f.Sleep(context.Background(), time.Minute) }()
+
+	f.BlockUntil(1)
+	assert.Equal(t, 1, f.Sleepers())
+	assert.Equal(t, []time.Time{epoch.Add(time.Minute)}, f.Deadlines())
+	f.Advance(59 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("woke up early")
+	default:
+	}
+	f.Advance(time.Second)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 0, f.Sleepers())
+	assert.Equal(t, epoch.Add(time.Minute), f.Now())
+}
+
+func TestFakeSleepCanceled(t *testing.T) {
+	f := NewFake(epoch)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- f.Sleep(ctx, time.Hour) }()
+
+	f.BlockUntil(1)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, 0, f.Sleepers())
+	assert.NoError(t, f.Sleep(context.Background(), 0))
+}
+
+func TestFakeTimers(t *testing.T) {
+	f := NewFake(epoch)
+	t1 := f.NewTimer(2 * time.Second)
+	t2 := f.NewTimer(time.Second)
+	t3 := f.NewTimer(3 * time.Second)
+	assert.True(t, t3.Stop())
+	assert.False(t, t3.Stop())
+
+	f.Advance(5 * time.Second)
+	assert.Equal(t, epoch.Add(5*time.Second), <-t1.C())
+	assert.Equal(t, epoch.Add(5*time.Second), <-t2.C())
+	select {
+	case <-t3.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+
+	assert.False(t, t1.Reset(time.Second))
+	assert.True(t, t1.Reset(2*time.Second))
+	f.Advance(time.Second)
+	assert.Equal(t, 1, f.Sleepers())
+	f.Advance(time.Second)
+	assert.Equal(t, epoch.Add(7*time.Second), <-t1.C())
+}
+
+func TestFakeAutoAdvance(t *testing.T) {
+	f := NewFake(epoch)
+	f.AutoAdvance(true)
+	assert.NoError(t, f.Sleep(context.Background(), time.Minute))
+	assert.NoError(t, f.Sleep(context.Background(), time.Second))
+	assert.Equal(t, epoch.Add(61*time.Second), f.Now())
+	assert.Equal(t, 0, f.Sleepers())
+}
+
+func TestReal(t *testing.T) {
+	var c Clock = Real{}
+	assert.WithinDuration(t, time.Now(), c.Now(), time.Second)
+	assert.NoError(t, c.Sleep(context.Background(), time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, c.Sleep(ctx, time.Hour))
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JournalEntry records a call in a Journal, without its payloads.
+type JournalEntry struct {
+	// Time is when the request was sent.
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	// URL is the URL of the request, masked by the Api's Redactor.
+	URL string `json:"url"`
+	// RequestSHA256 is the hex SHA-256 of the request body bytes sent, RequestSize their number.
+	RequestSHA256 string `json:"requestSha256"`
+	RequestSize   int64  `json:"requestSize"`
+	// Status is the status code of the response, 0 if none was received.
+	Status int `json:"status"`
+	// ResponseSHA256 is the hex SHA-256 of the response body bytes read until it was closed,
+	// ResponseSize their number. The Do-style helpers read the whole body.
+	ResponseSHA256 string `json:"responseSha256,omitempty"`
+	ResponseSize   int64  `json:"responseSize"`
+	// Err is the error of the calls failed without a response.
+	Err string `json:"error,omitempty"`
+	// Team and Feature are the attribution of the call, see WithAttribution.
+	Team    string `json:"team,omitempty"`
+	Feature string `json:"feature,omitempty"`
+	// IdempotencyKey is the idempotency key of the request, see IdempotencyFrom, for the
+	// reconciliation of the replayed operations.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+// Journal keeps a record of calls, e.g. as an audit trail, see SetJournal and FileJournal.
+type Journal interface {
+	Record(entry JournalEntry) error
+}
+
+// JournalPolicy configures SetJournal.
+type JournalPolicy struct {
+	// Methods are the methods of the journaled calls, POST, PUT, PATCH and DELETE if nil.
+	Methods []string
+	// OnError is invoked with the entries that failed to be recorded.
+	OnError func(entry JournalEntry, err error)
+	// Strict makes the calls whose entry failed to be recorded fail too, when their response body
+	// is closed, so the Do-style helpers return the error.
+	Strict bool
+}
+
+// journaling is the state of SetJournal.
+type journaling struct {
+	journal Journal
+	policy  JournalPolicy
+	methods map[string]bool
+}
+
+// SetJournal makes the Api record every attempt of the calls made with the methods of p to j,
+// once the response body is closed or the attempt failed. The request body is hashed from its
+// encoded bytes, or from a copy, while the streamed ones are hashed as they're sent.
+// A failure to record is reported to p.OnError and doesn't fail the call unless p.Strict is set.
+// Responses answered by the cache of SetCache aren't journaled, since no call was made.
+// A nil j disables journaling; a nil p uses the defaults of JournalPolicy.
+func (a *Api) SetJournal(j Journal, p *JournalPolicy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if j == nil {
+		a.journal = nil
+		return
+	}
+	var policy JournalPolicy
+	if p != nil {
+		policy = *p
+	}
+	methods := policy.Methods
+	if methods == nil {
+		methods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	jl := &journaling{journal: j, policy: policy, methods: make(map[string]bool, len(methods))}
+	for _, m := range methods {
+		jl.methods[m] = true
+	}
+	a.journal = jl
+}
+
+// journalCall is the entry of an attempt being journaled.
+type journalCall struct {
+	jl    *journaling
+	entry JournalEntry
+	// sent hashes a streamed request body as it's sent.
+	sent *hashReader
+}
+
+// journalFor returns the journal state of an attempt sending req, nil if it isn't journaled.
+// req's body is replaced by a hashing one if it can only be read once.
+func (a *Api) journalFor(ctx context.Context, req *http.Request) (*journalCall, error) {
+	a.mu.Lock()
+	jl := a.journal
+	a.mu.Unlock()
+	if jl == nil || !jl.methods[req.Method] {
+		return nil, nil
+	}
+	at := a.attributionOf(ctx)
+	jc := &journalCall{jl: jl, entry: JournalEntry{Method: req.Method, URL: a.Redactor().RedactURL(req.URL), Team: at.Team, Feature: at.Feature,
+		IdempotencyKey: req.Header.Get(a.idempotencyPolicy().header())}}
+	h := sha256.New()
+	data, encoded := encodedBody(req)
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+	case encoded:
+		h.Write(data)
+		jc.entry.RequestSize = int64(len(data))
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		jc.entry.RequestSize = n
+	default:
+		jc.sent = &hashReader{ReadCloser: req.Body, h: h}
+		req.Body = jc.sent
+	}
+	if jc.sent == nil {
+		jc.entry.RequestSHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return jc, nil
+}
+
+// record records the entry of the attempt, given its response or error. It returns the error
+// failing the call in strict mode.
+func (jc *journalCall) record(resp *http.Response, err error) error {
+	if jc.sent != nil {
+		jc.entry.RequestSHA256, jc.entry.RequestSize = hex.EncodeToString(jc.sent.h.Sum(nil)), jc.sent.n
+	}
+	if resp != nil {
+		jc.entry.Status = resp.StatusCode
+	}
+	if err != nil {
+		jc.entry.Err = err.Error()
+	}
+	rerr := jc.jl.journal.Record(jc.entry)
+	if rerr == nil {
+		return nil
+	}
+	if jc.jl.policy.OnError != nil {
+		jc.jl.policy.OnError(jc.entry, rerr)
+	}
+	if !jc.jl.policy.Strict {
+		return nil
+	}
+	return fmt.Errorf("api: journal: %w", rerr)
+}
+
+// wrap returns the body of resp hashing what's read from it, recording the entry once closed.
+func (jc *journalCall) wrap(resp *http.Response) io.ReadCloser {
+	b := &journalBody{hashReader: hashReader{ReadCloser: resp.Body, h: sha256.New()}}
+	b.done = func() error {
+		jc.entry.ResponseSHA256, jc.entry.ResponseSize = hex.EncodeToString(b.h.Sum(nil)), b.n
+		return jc.record(resp, nil)
+	}
+	return b
+}
+
+// hashReader hashes and counts the bytes read.
+type hashReader struct {
+	io.ReadCloser
+	h hash.Hash
+	n int64
+}
+
+func (r *hashReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.h.Write(p[:n])
+	r.n += int64(n)
+	return n, err
+}
+
+// journalBody records the entry of its call once closed.
+type journalBody struct {
+	hashReader
+	once sync.Once
+	done func() error
+}
+
+func (b *journalBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		if jerr := b.done(); jerr != nil && err == nil {
+			err = jerr
+		}
+	})
+	return err
+}
+
+// FileJournal is a Journal appending the entries to a file as JSON lines. Once the file would
+// grow past MaxSize, it's renamed with the next free numbered suffix, e.g. "calls.jsonl.1",
+// and a new one is started, so no entry is ever overwritten. It's safe for concurrent use.
+type FileJournal struct {
+	path    string
+	maxSize int64
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	next    int
+}
+
+// NewFileJournal opens the journal at path, appending to it if it exists. A maxSize of 0 or less
+// disables the rotation.
+func NewFileJournal(path string, maxSize int64) (*FileJournal, error) {
+	j := &FileJournal{path: path, maxSize: maxSize, next: 1}
+	for {
+		if _, err := os.Stat(j.rotated(j.next)); os.IsNotExist(err) {
+			break
+		}
+		j.next++
+	}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *FileJournal) rotated(n int) string {
+	return j.path + "." + strconv.Itoa(n)
+}
+
+func (j *FileJournal) open() error {
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	j.f, j.size = f, fi.Size()
+	return nil
+}
+
+// Record implements Journal, writing the entry with a single write.
+func (j *FileJournal) Record(entry JournalEntry) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return os.ErrClosed
+	}
+	if j.maxSize > 0 && j.size > 0 && j.size+int64(buf.Len()) > j.maxSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := j.f.Write(buf.Bytes())
+	j.size += int64(n)
+	return err
+}
+
+func (j *FileJournal) rotate() error {
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	j.f = nil
+	if err := os.Rename(j.path, j.rotated(j.next)); err != nil {
+		return err
+	}
+	j.next++
+	return j.open()
+}
+
+// Close closes the journal file.
+func (j *FileJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memJournal struct {
+	entries []JournalEntry
+	err     error
+}
+
+func (j *memJournal) Record(e JournalEntry) error {
+	if j.err != nil {
+		return j.err
+	}
+	j.entries = append(j.entries, e)
+	return nil
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestJournal(t *testing.T) {
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, string(body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	j := &memJournal{}
+	a.SetJournal(j, nil)
+	ctx := context.Background()
+
+	send := func(req *http.Request, err error) {
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp, err := a.Do(ctx, req)
+		if assert.NoError(t, err) {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+	send(a.RequestJSONWithQuery(POST, "/items", url.Values{"token": {"s3cr3t"}}, map[string]string{"name": "a"}))
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil))
+	assert.NoError(t, a.Delete(ctx, "/items/1", nil))
+	send(a.RequestReader(PUT, "/items/1", "text/plain", io.MultiReader(strings.NewReader("raw data"))))
+
+	if !assert.Len(t, j.entries, 3) {
+		return
+	}
+	e := j.entries[0]
+	assert.Equal(t, "POST", e.Method)
+	assert.Equal(t, srv.URL+"/items?token=%3Credacted%3A6+chars%3E", e.URL)
+	assert.Equal(t, `{"name":"a"}`, received[0])
+	assert.Equal(t, sha256Hex(received[0]), e.RequestSHA256)
+	assert.Equal(t, int64(12), e.RequestSize)
+	assert.Equal(t, http.StatusOK, e.Status)
+	assert.Equal(t, sha256Hex(`{"id": 1}`), e.ResponseSHA256)
+	assert.Equal(t, int64(9), e.ResponseSize)
+	assert.False(t, e.Time.IsZero())
+
+	assert.Equal(t, "DELETE", j.entries[1].Method)
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", j.entries[1].RequestSHA256)
+	assert.Equal(t, sha256Hex("raw data"), j.entries[2].RequestSHA256)
+	assert.Equal(t, int64(8), j.entries[2].RequestSize)
+
+	a.SetJournal(j, &JournalPolicy{Methods: []string{"GET"}})
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil))
+	assert.NoError(t, a.Post(ctx, "/items", nil, nil))
+	if assert.Len(t, j.entries, 4) {
+		assert.Equal(t, "GET", j.entries[3].Method)
+	}
+}
+
+func TestJournalErrors(t *testing.T) {
+	a := MustNew("http://example.com")
+	a.Client = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/down" {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: req}, nil
+	})}
+	j := &memJournal{}
+	a.SetJournal(j, nil)
+	ctx := context.Background()
+	assert.ErrorIs(t, a.Post(ctx, "/down", nil, nil), io.ErrUnexpectedEOF)
+	if assert.Len(t, j.entries, 1) {
+		assert.Equal(t, 0, j.entries[0].Status)
+		assert.Contains(t, j.entries[0].Err, "unexpected EOF")
+	}
+
+	// A journal failing is only reported, unless the policy is strict.
+	full := errors.New("disk full")
+	j.err = full
+	var failed []JournalEntry
+	a.SetJournal(j, &JournalPolicy{OnError: func(e JournalEntry, err error) {
+		assert.Equal(t, full, err)
+		failed = append(failed, e)
+	}})
+	assert.NoError(t, a.Post(ctx, "/items", nil, nil))
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, http.StatusNoContent, failed[0].Status)
+	}
+	a.SetJournal(j, &JournalPolicy{Strict: true})
+	assert.ErrorIs(t, a.Post(ctx, "/items", nil, nil), full)
+	assert.ErrorIs(t, a.Post(ctx, "/down", nil, nil), io.ErrUnexpectedEOF)
+
+	a.SetJournal(nil, nil)
+	assert.NoError(t, a.Post(ctx, "/items", nil, nil))
+}
+
+func TestFileJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.jsonl")
+	j, err := NewFileJournal(path, 300)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, j.Record(JournalEntry{Method: "POST", URL: "http://example.com/items/" + string(rune('a'+i)), Status: 201}))
+	}
+	assert.NoError(t, j.Close())
+	assert.ErrorIs(t, j.Record(JournalEntry{}), os.ErrClosed)
+
+	// Reopening appends, and rotates past the existing files.
+	j, err = NewFileJournal(path, 300)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, j.Record(JournalEntry{Method: "DELETE", URL: "http://example.com/items/f"}))
+	assert.NoError(t, j.Close())
+
+	read := func(name string) []string {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var urls []string
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var e JournalEntry
+			if assert.NoError(t, json.Unmarshal(sc.Bytes(), &e)) {
+				urls = append(urls, e.URL[len(e.URL)-1:])
+			}
+		}
+		return urls
+	}
+	all := [][]string{read(path + ".1"), read(path + ".2"), read(path)}
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}}, all)
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrJSONNotFound is matched by the *JSONPathErrors of JSONLookup for the paths not found in the
+// document.
+var ErrJSONNotFound = errors.New("api: json value not found")
+
+// JSONPathError is returned by JSONLookup when the path is invalid, isn't found in the document,
+// or the document isn't valid JSON.
+type JSONPathError struct {
+	Path string
+	// Reason tells why, e.g. `no key "id" in an array`.
+	Reason   string
+	notFound bool
+}
+
+func (e *JSONPathError) Error() string {
+	return fmt.Sprintf("api: json path %q: %s", e.Path, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrJSONNotFound) report true for the paths not found.
+func (e *JSONPathError) Is(target error) bool { return target == ErrJSONNotFound && e.notFound }
+
+// JSONKind is the kind of a JSONValue.
+type JSONKind int
+
+const (
+	JSONNull JSONKind = iota
+	JSONBool
+	JSONNumber
+	JSONString
+	JSONObject
+	JSONArray
+)
+
+func (k JSONKind) String() string {
+	switch k {
+	case JSONBool:
+		return "a boolean"
+	case JSONNumber:
+		return "a number"
+	case JSONString:
+		return "a string"
+	case JSONObject:
+		return "an object"
+	case JSONArray:
+		return "an array"
+	default:
+		return "null"
+	}
+}
+
+// JSONValue is a value found by JSONLookup.
+type JSONValue struct {
+	// Raw is the JSON text of the value, a slice of the document.
+	Raw json.RawMessage
+}
+
+// Kind returns the kind of the value.
+func (v JSONValue) Kind() JSONKind {
+	if len(v.Raw) == 0 {
+		return JSONNull
+	}
+	switch v.Raw[0] {
+	case '"':
+		return JSONString
+	case '{':
+		return JSONObject
+	case '[':
+		return JSONArray
+	case 't', 'f':
+		return JSONBool
+	case 'n':
+		return JSONNull
+	default:
+		return JSONNumber
+	}
+}
+
+// Value returns the value as a string, a json.Number, a bool or nil, and the objects and arrays
+// as their Raw JSON.
+func (v JSONValue) Value() interface{} {
+	switch v.Kind() {
+	case JSONString:
+		return v.String()
+	case JSONNumber:
+		return json.Number(v.Raw)
+	case JSONBool:
+		return v.Raw[0] == 't'
+	case JSONObject, JSONArray:
+		return v.Raw
+	default:
+		return nil
+	}
+}
+
+// String returns the value of a JSON string, and the JSON text of the other values, e.g. "42"
+// or "null".
+func (v JSONValue) String() string {
+	if v.Kind() != JSONString {
+		return string(v.Raw)
+	}
+	if bytes.IndexByte(v.Raw, '\\') < 0 {
+		return string(v.Raw[1 : len(v.Raw)-1])
+	}
+	var s string
+	json.Unmarshal(v.Raw, &s)
+	return s
+}
+
+// JSONLookup returns the value at path in the JSON document data. The path is either an RFC 6901
+// JSON Pointer, like "/items/0/id" or "/a~1b", or a dotted path with brackets, like "items.0.id",
+// "$.items[0].id" or `meta["next.cursor"]`, "$" being the document; the empty path is the document
+// too. The numbers of the dotted paths index the arrays and name the keys of the objects, those in
+// brackets only index arrays. The document is scanned rather than decoded, up to the value found,
+// which is checked to be valid JSON; with duplicate keys, the first one is found. A path not found
+// fails with a *JSONPathError matching ErrJSONNotFound.
+func JSONLookup(data []byte, path string) (JSONValue, error) {
+	var buf [8]jsonStep
+	steps, err := parseJSONPath(path, buf[:0])
+	if err != nil {
+		return JSONValue{}, err
+	}
+	return lookupSteps(data, path, steps)
+}
+
+// lookupPointer returns the raw JSON value found at the JSON pointer ptr (RFC 6901) in data.
+func lookupPointer(data []byte, ptr string) (json.RawMessage, error) {
+	if ptr != "" && ptr[0] != '/' {
+		return nil, fmt.Errorf("api: invalid json pointer %q", ptr)
+	}
+	v, err := JSONLookup(data, ptr)
+	return v.Raw, err
+}
+
+// jsonStep is a step of a path: key names a member of an object and index, if not negative, an
+// element of an array.
+type jsonStep struct {
+	key      string
+	index    int
+	indexing bool
+}
+
+func parseJSONPath(path string, steps []jsonStep) ([]jsonStep, error) {
+	invalid := func(reason string) error {
+		return &JSONPathError{Path: path, Reason: "invalid path: " + reason}
+	}
+	if strings.HasPrefix(path, "/") {
+		for _, token := range strings.Split(path[1:], "/") {
+			if strings.Contains(token, "~") {
+				token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+			}
+			steps = append(steps, jsonStep{key: token, index: pointerIndex(token)})
+		}
+		return steps, nil
+	}
+	s := path
+	if strings.HasPrefix(s, "$") {
+		// "$.a" is "a", and "$" the document.
+		s = strings.TrimPrefix(s[1:], ".")
+	}
+	for first := true; s != ""; first = false {
+		if s[0] == '[' {
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, invalid("unclosed [")
+			}
+			if s[1] == '"' {
+				// The key is a JSON string, which may hold a ].
+				var key string
+				q := stringEnd([]byte(s), 1)
+				if q < 0 || q >= len(s) || s[q] != ']' || json.Unmarshal([]byte(s[1:q]), &key) != nil {
+					return nil, invalid("bad key in " + s)
+				}
+				steps = append(steps, jsonStep{key: key, index: -1})
+				end = q
+			} else {
+				n, err := strconv.Atoi(s[1:end])
+				if err != nil || n < 0 {
+					return nil, invalid("bad index " + s[:end+1])
+				}
+				steps = append(steps, jsonStep{index: n, indexing: true})
+			}
+			s = s[end+1:]
+			if s != "" && s[0] != '.' && s[0] != '[' {
+				return nil, invalid(fmt.Sprintf("unexpected %q after ]", s[0]))
+			}
+			continue
+		}
+		if !first {
+			if s[0] != '.' {
+				return nil, invalid(fmt.Sprintf("unexpected %q", s[0]))
+			}
+			s = s[1:]
+		}
+		end := strings.IndexAny(s, ".[")
+		if end < 0 {
+			end = len(s)
+		}
+		if end == 0 {
+			return nil, invalid("empty key")
+		}
+		key := s[:end]
+		index := -1
+		if n, err := strconv.Atoi(key); err == nil && n >= 0 {
+			index = n
+		}
+		steps = append(steps, jsonStep{key: key, index: index})
+		s = s[end:]
+	}
+	return steps, nil
+}
+
+// pointerIndex returns the array index of a JSON pointer token, -1 if it isn't one: RFC 6901
+// doesn't allow leading zeros.
+func pointerIndex(token string) int {
+	if token == "" || len(token) > 1 && token[0] == '0' {
+		return -1
+	}
+	n, err := strconv.Atoi(token)
+	if err != nil || n < 0 || token[0] == '+' {
+		return -1
+	}
+	return n
+}
+
+// lookupSteps scans data for the value at steps.
+func lookupSteps(data []byte, path string, steps []jsonStep) (JSONValue, error) {
+	notFound := func(reason string) error {
+		return &JSONPathError{Path: path, Reason: reason, notFound: true}
+	}
+	malformed := func() error {
+		return &JSONPathError{Path: path, Reason: "invalid JSON"}
+	}
+	i := skipSpace(data, 0)
+	for _, step := range steps {
+		if i >= len(data) {
+			return JSONValue{}, malformed()
+		}
+		var found bool
+		var err error
+		switch c := data[i]; {
+		case c == '{' && !step.indexing:
+			if i, found, err = objectMember(data, i, step.key); err != nil {
+				return JSONValue{}, malformed()
+			}
+			if !found {
+				return JSONValue{}, notFound(fmt.Sprintf("no key %q", step.key))
+			}
+		case c == '[' && step.index >= 0:
+			n := 0
+			if i, n, err = arrayElement(data, i, step.index); err != nil {
+				return JSONValue{}, malformed()
+			}
+			if n <= step.index {
+				return JSONValue{}, notFound(fmt.Sprintf("index %d out of range of %d elements", step.index, n))
+			}
+		default:
+			kind := JSONValue{Raw: data[i:]}.Kind()
+			if step.indexing {
+				return JSONValue{}, notFound(fmt.Sprintf("no index %d in %s", step.index, kind))
+			}
+			return JSONValue{}, notFound(fmt.Sprintf("no key %q in %s", step.key, kind))
+		}
+	}
+	end := valueEnd(data, i)
+	if end < 0 || !json.Valid(data[i:end]) {
+		return JSONValue{}, malformed()
+	}
+	if len(steps) == 0 && skipSpace(data, end) != len(data) {
+		return JSONValue{}, malformed()
+	}
+	return JSONValue{Raw: data[i:end]}, nil
+}
+
+// errMalformedJSON is the error of the scanning functions for a malformed document.
+var errMalformedJSON = errors.New("api: malformed json")
+
+func skipSpace(d []byte, i int) int {
+	for i < len(d) && (d[i] == ' ' || d[i] == '\t' || d[i] == '\n' || d[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// stringEnd returns the offset after the string starting at d[i], -1 if it isn't terminated.
+func stringEnd(d []byte, i int) int {
+	for i++; i < len(d); i++ {
+		switch d[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// valueEnd returns the offset after the value starting at d[i], -1 if it's malformed. The
+// objects and arrays are only checked to be balanced.
+func valueEnd(d []byte, i int) int {
+	if i >= len(d) {
+		return -1
+	}
+	switch d[i] {
+	case '"':
+		return stringEnd(d, i)
+	case '{', '[':
+		depth := 0
+		for ; i < len(d); i++ {
+			switch d[i] {
+			case '"':
+				if i = stringEnd(d, i); i < 0 {
+					return -1
+				}
+				i--
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return -1
+	default:
+		start := i
+		for i < len(d) && !strings.ContainsRune(",}] \t\r\n", rune(d[i])) {
+			i++
+		}
+		if i == start {
+			return -1
+		}
+		return i
+	}
+}
+
+// objectMember returns the offset of the value of the first member key of the object at d[i].
+func objectMember(d []byte, i int, key string) (int, bool, error) {
+	i = skipSpace(d, i+1)
+	if i < len(d) && d[i] == '}' {
+		return 0, false, nil
+	}
+	for i < len(d) {
+		if d[i] != '"' {
+			return 0, false, errMalformedJSON
+		}
+		end := stringEnd(d, i)
+		if end < 0 {
+			return 0, false, errMalformedJSON
+		}
+		match := keyEquals(d[i:end], key)
+		i = skipSpace(d, end)
+		if i >= len(d) || d[i] != ':' {
+			return 0, false, errMalformedJSON
+		}
+		i = skipSpace(d, i+1)
+		if match {
+			return i, true, nil
+		}
+		if i = valueEnd(d, i); i < 0 {
+			return 0, false, errMalformedJSON
+		}
+		i = skipSpace(d, i)
+		if i < len(d) && d[i] == '}' {
+			return 0, false, nil
+		}
+		if i >= len(d) || d[i] != ',' {
+			return 0, false, errMalformedJSON
+		}
+		i = skipSpace(d, i+1)
+	}
+	return 0, false, errMalformedJSON
+}
+
+// keyEquals reports whether the JSON string raw is key.
+func keyEquals(raw []byte, key string) bool {
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return string(raw[1:len(raw)-1]) == key
+	}
+	var s string
+	return json.Unmarshal(raw, &s) == nil && s == key
+}
+
+// arrayElement returns the offset of the element n of the array at d[i], or the number of its
+// elements if it has fewer.
+func arrayElement(d []byte, i int, n int) (int, int, error) {
+	i = skipSpace(d, i+1)
+	if i < len(d) && d[i] == ']' {
+		return 0, 0, nil
+	}
+	for count := 0; i < len(d); count++ {
+		if count == n {
+			return i, count + 1, nil
+		}
+		if i = valueEnd(d, i); i < 0 {
+			return 0, 0, errMalformedJSON
+		}
+		i = skipSpace(d, i)
+		if i < len(d) && d[i] == ']' {
+			return 0, count + 1, nil
+		}
+		if i >= len(d) || d[i] != ',' {
+			return 0, 0, errMalformedJSON
+		}
+		i = skipSpace(d, i+1)
+	}
+	return 0, 0, errMalformedJSON
+}
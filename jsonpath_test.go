@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const lookupDoc = `{
+	"items": [{"id": 42, "name": "a \"b\"", "tags": ["x", "y"]}, {"id": 4.5e1, "ok": true, "none": null}],
+	"meta": {"next.cursor": "c]1", "total": "7", "0": "zero", "a/b": 1, "m~n": 2, "": 3, "kéy": 4},
+	"esc\\aped": {"brace}": "[{"}
+}`
+
+func TestJSONLookup(t *testing.T) {
+	data := []byte(lookupDoc)
+	for path, want := range map[string]string{
+		"items.0.id":               "42",
+		"$.items[0].id":            "42",
+		"$.items[1].id":            "4.5e1",
+		"items[0].tags[1]":         `"y"`,
+		"items.0.tags":             `["x", "y"]`,
+		"items.1.none":             "null",
+		`meta["next.cursor"]`:      `"c]1"`,
+		`$["meta"]["m~n"]`:         "2",
+		`meta[""]`:                 "3",
+		"meta.0":                   `"zero"`,
+		`meta["kéy"]`:              "4",
+		"meta.kéy":                 "4",
+		`$["esc\\aped"]["brace}"]`: `"[{"`,
+		"/items/0/id":              "42",
+		"/items/1/ok":              "true",
+		"/meta/a~1b":               "1",
+		"/meta/m~0n":               "2",
+		"/meta/":                   "3",
+		"/meta/0":                  `"zero"`,
+		"/esc\\aped/brace}":        `"[{"`,
+	} {
+		v, err := JSONLookup(data, path)
+		if assert.NoError(t, err, path) {
+			assert.Equal(t, want, string(v.Raw), path)
+		}
+	}
+	for _, path := range []string{"", "$"} {
+		v, err := JSONLookup(data, path)
+		if assert.NoError(t, err, path) {
+			assert.Equal(t, lookupDoc, string(v.Raw))
+		}
+	}
+}
+
+func TestJSONLookupTyped(t *testing.T) {
+	data := []byte(lookupDoc)
+	for path, want := range map[string]interface{}{
+		"items.0.id":          json.Number("42"),
+		"items.0.name":        `a "b"`,
+		"items.1.ok":          true,
+		"items.1.none":        nil,
+		`meta["next.cursor"]`: "c]1",
+		"items.0.tags":        json.RawMessage(`["x", "y"]`),
+	} {
+		v, err := JSONLookup(data, path)
+		if assert.NoError(t, err, path) {
+			assert.Equal(t, want, v.Value(), path)
+		}
+	}
+	kinds := map[string]JSONKind{"items": JSONArray, "meta": JSONObject, "meta.total": JSONString, "items.0.id": JSONNumber, "items.1.ok": JSONBool, "items.1.none": JSONNull}
+	for path, want := range kinds {
+		v, _ := JSONLookup(data, path)
+		assert.Equal(t, want, v.Kind(), path)
+	}
+	v, _ := JSONLookup(data, "items.0.name")
+	assert.Equal(t, `a "b"`, v.String())
+	v, _ = JSONLookup(data, "items.1.id")
+	assert.Equal(t, "4.5e1", v.String())
+}
+
+func TestJSONLookupNotFound(t *testing.T) {
+	data := []byte(lookupDoc)
+	for path, reason := range map[string]string{
+		"missing":          `no key "missing"`,
+		"items.2":          "index 2 out of range of 2 elements",
+		"items[5].id":      "index 5 out of range of 2 elements",
+		"items.name":       `no key "name" in an array`,
+		"meta[0]":          "no index 0 in an object",
+		"items.0.id.x":     `no key "x" in a number`,
+		"items.1.none[0]":  "no index 0 in null",
+		"/items/01":        `no key "01" in an array`,
+		"/items/-":         `no key "-" in an array`,
+		"/meta/a/b":        `no key "a"`,
+		"meta.next.cursor": `no key "next"`,
+		"items.0.tags.2":   "index 2 out of range of 2 elements",
+	} {
+		_, err := JSONLookup(data, path)
+		var pe *JSONPathError
+		if assert.True(t, errors.As(err, &pe), path) {
+			assert.Equal(t, reason, pe.Reason, path)
+			assert.Equal(t, path, pe.Path)
+		}
+		assert.True(t, errors.Is(err, ErrJSONNotFound), path)
+	}
+}
+
+func TestJSONLookupInvalid(t *testing.T) {
+	for path, reason := range map[string]string{
+		"items..id":  "invalid path: empty key",
+		".items":     "invalid path: empty key",
+		"items.":     "invalid path: empty key",
+		"items[0":    "invalid path: unclosed [",
+		"items[-1]":  "invalid path: bad index [-1]",
+		"items[a]":   "invalid path: bad index [a]",
+		`meta["a]`:   `invalid path: bad key in ["a]`,
+		"items[0]id": `invalid path: unexpected 'i' after ]`,
+		`meta["a"]x`: `invalid path: unexpected 'x' after ]`,
+	} {
+		_, err := JSONLookup([]byte(lookupDoc), path)
+		var pe *JSONPathError
+		if assert.True(t, errors.As(err, &pe), path) {
+			assert.Equal(t, reason, pe.Reason, path)
+		}
+		assert.False(t, errors.Is(err, ErrJSONNotFound), path)
+	}
+	for i, doc := range []string{``, `{`, `{"a" 1}`, `{"a": }`, `{"a": [1, 2}`, `{"a": tru}`, `{"a": 1} x`, `"abc`} {
+		paths := []string{""}
+		if i < 6 {
+			paths = append(paths, "a")
+		}
+		for _, path := range paths {
+			_, err := JSONLookup([]byte(doc), path)
+			var pe *JSONPathError
+			if assert.True(t, errors.As(err, &pe), "%s %s", doc, path) {
+				assert.Equal(t, "invalid JSON", pe.Reason, "%s %s", doc, path)
+			}
+			assert.False(t, errors.Is(err, ErrJSONNotFound))
+		}
+	}
+	// The members after the value found aren't checked.
+	v, err := JSONLookup([]byte(`{"a": 1, "b": ]`), "a")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1", string(v.Raw))
+	}
+	// The first of duplicate keys is found.
+	v, _ = JSONLookup([]byte(`{"a": 1, "a": 2}`), "a")
+	assert.Equal(t, "1", string(v.Raw))
+}
+
+// lookupUnmarshal is the lookup through a full decoding of the document, for comparison.
+func lookupUnmarshal(data []byte, path string) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			var i int
+			fmt.Sscan(key, &i)
+			v = node[i]
+		}
+	}
+	return v, nil
+}
+
+func benchmarkDoc() []byte {
+	var b strings.Builder
+	b.WriteString(`{"meta": {"next_cursor": "abc"}, "data": [`)
+	for i := 0; i < 500; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id": %d, "name": "item %d", "tags": ["a", "b", "c"], "attrs": {"x": 1.5, "y": null}}`, i, i)
+	}
+	b.WriteString(`], "total": 500}`)
+	return []byte(b.String())
+}
+
+func BenchmarkJSONLookup(b *testing.B) {
+	data := benchmarkDoc()
+	for _, path := range []string{"meta.next_cursor", "data.250.id", "total"} {
+		b.Run(path, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := JSONLookup(data, path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkJSONLookupUnmarshal(b *testing.B) {
+	data := benchmarkDoc()
+	for _, path := range []string{"meta.next_cursor", "data.250.id", "total"} {
+		b.Run(path, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := lookupUnmarshal(data, path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
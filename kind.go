@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// Kind is the normalized kind of a failed call, whatever the layer it failed in: the resolver,
+// the transport, the server or the decoding of its response. See KindOf and SetErrorTaxonomy.
+type Kind int
+
+const (
+	// KindUnknown is the kind of the errors not recognized, e.g. those returned by a Preparer.
+	KindUnknown Kind = iota
+	// DNSFailure is a failure to resolve the host.
+	DNSFailure
+	// ConnectionRefused is a connection refused by the host.
+	ConnectionRefused
+	// ConnectionReset is a connection reset or closed before the response was received.
+	ConnectionReset
+	// TLSHandshake is a failed TLS handshake, e.g. an untrusted certificate.
+	TLSHandshake
+	// Timeout is a call timed out, by its context deadline, WithTimeout or the client's Timeout.
+	Timeout
+	// Canceled is a call canceled by the caller.
+	Canceled
+	// HTTPStatus is a failed response: a *StatusError, *VendorError or *RedirectionError.
+	HTTPStatus
+	// DecodeFailure is a response which couldn't be decoded or redacted, or failed its validation.
+	DecodeFailure
+	// BudgetExceeded is a call refused for lack of budget, see WithBudget and SetDeadlineHeader.
+	BudgetExceeded
+	// Blocked is a call refused by the target policy, the TLS audit, a maintenance window or the
+	// robots.txt of SetPoliteness.
+	Blocked
+	// Shed is a call refused by the load shedding of SetShedding.
+	Shed
+	// ClientClosed is a call made after Shutdown.
+	ClientClosed
+	// TruncatedBody is a response body shorter than its Content-Length.
+	TruncatedBody
+	// InvalidRequest is a request refused before being sent, e.g. by WithMaxRequestBytes.
+	InvalidRequest
+)
+
+func (k Kind) String() string {
+	switch k {
+	case DNSFailure:
+		return "dns_failure"
+	case ConnectionRefused:
+		return "connection_refused"
+	case ConnectionReset:
+		return "connection_reset"
+	case TLSHandshake:
+		return "tls_handshake"
+	case Timeout:
+		return "timeout"
+	case Canceled:
+		return "canceled"
+	case HTTPStatus:
+		return "http_status"
+	case DecodeFailure:
+		return "decode_failure"
+	case BudgetExceeded:
+		return "budget_exceeded"
+	case Blocked:
+		return "blocked"
+	case Shed:
+		return "shed"
+	case ClientClosed:
+		return "client_closed"
+	case TruncatedBody:
+		return "truncated_body"
+	case InvalidRequest:
+		return "invalid_request"
+	default:
+		return "unknown"
+	}
+}
+
+// Error is a failed call of the Do-style helpers and Do with SetErrorTaxonomy or WithErrorTaxonomy.
+// Its message is the one of Err, which stays reachable by errors.Is and errors.As:
+//
+//	var e *api.Error
+//	if errors.As(err, &e) && e.Kind == api.Timeout {
+//		...
+//	}
+type Error struct {
+	Kind   Kind
+	Method string
+	// Resource is the template of the calls made from a Template, the resource of the others.
+	Resource string
+	// Attempts is the number of attempts made, 1 for the calls failing before being sent.
+	Attempts int
+	Err      error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// KindOf returns the kind of err, the Kind of an *Error or the one derived from the errors it
+// wraps otherwise, so it works with SetErrorTaxonomy off too. It's KindUnknown for a nil error.
+func KindOf(err error) Kind {
+	if err == nil {
+		return KindUnknown
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	var (
+		se  *StatusError
+		ve  *VendorError
+		re  *RedirectionError
+		te  *TimeoutError
+		be  *BudgetError
+		ibe *InsufficientBudgetError
+		bte *BlockedTargetError
+		tae *TLSAuditError
+		mae *MaintenanceError
+		she *ShedError
+		tbe *TruncatedBodyError
+		rbe *RobotsError
+	)
+	switch {
+	case errors.As(err, &se), errors.As(err, &ve), errors.As(err, &re):
+		return HTTPStatus
+	case errors.As(err, &te):
+		if isTimeout(te.Err) {
+			return Timeout
+		}
+		return Canceled
+	case errors.As(err, &be), errors.As(err, &ibe):
+		return BudgetExceeded
+	case errors.As(err, &bte), errors.As(err, &tae), errors.As(err, &mae), errors.As(err, &rbe):
+		return Blocked
+	case errors.As(err, &she):
+		return Shed
+	case errors.Is(err, ErrClientClosed):
+		return ClientClosed
+	case errors.As(err, &tbe):
+		return TruncatedBody
+	case isInvalidRequest(err):
+		return InvalidRequest
+	case isDecodeFailure(err):
+		return DecodeFailure
+	}
+	return transportKind(err)
+}
+
+// isInvalidRequest reports whether err refused a request before it was sent.
+func isInvalidRequest(err error) bool {
+	var (
+		rte *RequestTooLargeError
+		pe  *PreparerError
+		cte *ContentTypeError
+		upe *UnknownParamError
+		ike *IdempotencyKeyError
+	)
+	return errors.As(err, &rte) || errors.As(err, &pe) || errors.As(err, &cte) || errors.As(err, &upe) ||
+		errors.As(err, &ike)
+}
+
+// isDecodeFailure reports whether err failed the decoding or the validation of a response.
+func isDecodeFailure(err error) bool {
+	var (
+		syn *json.SyntaxError
+		ute *json.UnmarshalTypeError
+		uce *UnexpectedContentError
+		jae *JSONArrayError
+		che *CharsetError
+		tre *TransformError
+		bce *BodyCodecError
+		dbe *DecompressionBombError
+		vae *ValidationError
+		sde *SchemaDriftError
+		rde *RedactionError
+	)
+	return errors.As(err, &syn) || errors.As(err, &ute) || errors.As(err, &uce) || errors.As(err, &jae) ||
+		errors.As(err, &che) || errors.As(err, &tre) || errors.As(err, &bce) || errors.As(err, &dbe) ||
+		errors.As(err, &vae) || errors.As(err, &sde) || errors.As(err, &rde)
+}
+
+// transportKind returns the kind of a failure of the resolver, the dialer or the transport.
+// The timeouts come before the TLS errors, since a TLS handshake timing out may be reported as both.
+func transportKind(err error) Kind {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return DNSFailure
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.EOF):
+		return ConnectionReset
+	case isTimeout(err):
+		return Timeout
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	}
+	var (
+		rhe tls.RecordHeaderError
+		ae  tls.AlertError
+		cve *tls.CertificateVerificationError
+		uae x509.UnknownAuthorityError
+		hne x509.HostnameError
+		cie x509.CertificateInvalidError
+	)
+	if errors.As(err, &rhe) || errors.As(err, &ae) || errors.As(err, &cve) ||
+		errors.As(err, &uae) || errors.As(err, &hne) || errors.As(err, &cie) {
+		return TLSHandshake
+	}
+	return KindUnknown
+}
+
+// SetErrorTaxonomy makes the Do-style helpers and Do return every failure as an *Error carrying
+// its Kind, unless overridden by WithErrorTaxonomy. It's off by default, so the errors keep
+// their own types for the type assertions of existing callers; errors.As works either way.
+func (a *Api) SetErrorTaxonomy(on bool) {
+	a.mu.Lock()
+	a.taxonomy = on
+	a.mu.Unlock()
+}
+
+// WithErrorTaxonomy overrides SetErrorTaxonomy for the call.
+func WithErrorTaxonomy(on bool) Option {
+	return func(c *call) {
+		c.taxonomy, c.taxonomySet = on, true
+	}
+}
+
+// wrapError returns err as an *Error of the call c if the taxonomy applies to it.
+func (a *Api) wrapError(c *call, req *http.Request, err error) error {
+	if err == nil {
+		return nil
+	}
+	on := c.taxonomy
+	if !c.taxonomySet {
+		a.mu.Lock()
+		on = a.taxonomy
+		a.mu.Unlock()
+	}
+	var e *Error
+	if !on || errors.As(err, &e) {
+		return err
+	}
+	resource := c.template
+	if resource == "" {
+		resource = c.resource
+	}
+	if resource == "" {
+		resource = req.URL.Path
+	}
+	return &Error{Kind: KindOf(err), Method: req.Method, Resource: resource, Attempts: c.attempt + 1, Err: err}
+}
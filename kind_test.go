@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorKinds(t *testing.T) {
+	started, block := make(chan struct{}, 1), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		case "/held":
+			started <- struct{}{}
+			<-block
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/portal":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>Sign in</body></html>"))
+		case "/lying":
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte(`{"items": [1, 2,`))
+		case "/reset":
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsSrv.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	closed := "http://" + l.Addr().String()
+	l.Close()
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name string
+		uri  string
+		fail func(a *Api) error
+		kind Kind
+	}{
+		{name: "dns", uri: "http://nonexistent.invalid", kind: DNSFailure},
+		{name: "refused", uri: closed, kind: ConnectionRefused},
+		{name: "reset", fail: func(a *Api) error { return a.Get(ctx, "/reset", nil, nil) }, kind: ConnectionReset},
+		{name: "tls", uri: tlsSrv.URL, kind: TLSHandshake},
+		{name: "timeout", fail: func(a *Api) error {
+			return a.Get(ctx, "/slow", nil, nil, WithTimeout(20*time.Millisecond))
+		}, kind: Timeout},
+		{name: "canceled", fail: func(a *Api) error {
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+			return a.Get(ctx, "/slow", nil, nil)
+		}, kind: Canceled},
+		{name: "status", fail: func(a *Api) error { return a.Get(ctx, "/unavailable", nil, nil) }, kind: HTTPStatus},
+		{name: "decode", fail: func(a *Api) error {
+			var out interface{}
+			return a.Get(ctx, "/portal", nil, &out)
+		}, kind: DecodeFailure},
+		{name: "budget", fail: func(a *Api) error {
+			ctx := WithBudget(ctx, Budget{MaxCalls: 1})
+			assert.NoError(t, a.Get(ctx, "/", nil, nil))
+			return a.Get(ctx, "/", nil, nil)
+		}, kind: BudgetExceeded},
+		{name: "blocked", fail: func(a *Api) error {
+			a.SetTargetPolicy(&TargetPolicy{BlockPrivate: true})
+			return a.Get(ctx, "/", nil, nil)
+		}, kind: Blocked},
+		{name: "shed", fail: func(a *Api) error {
+			a.SetShedding(&ShedPolicy{MaxInFlight: 1})
+			done := make(chan error)
+			go func() { done <- a.Get(ctx, "/held", nil, nil, WithPriority(High)) }()
+			<-started
+			err := a.Get(ctx, "/", nil, nil, WithPriority(Low))
+			block <- struct{}{}
+			assert.NoError(t, <-done)
+			return err
+		}, kind: Shed},
+		{name: "closed", fail: func(a *Api) error {
+			assert.NoError(t, a.Shutdown(ctx))
+			return a.Get(ctx, "/", nil, nil)
+		}, kind: ClientClosed},
+		{name: "truncated", fail: func(a *Api) error {
+			var out interface{}
+			return a.Get(ctx, "/lying", nil, &out)
+		}, kind: TruncatedBody},
+		{name: "too large", fail: func(a *Api) error {
+			return a.Post(ctx, "/", map[string]string{"name": "too long"}, nil, WithMaxRequestBytes(4))
+		}, kind: InvalidRequest},
+	} {
+		uri := tc.uri
+		if uri == "" {
+			uri = srv.URL
+		}
+		a := MustNew(uri)
+		a.SetErrorTaxonomy(true)
+		fail := tc.fail
+		if fail == nil {
+			fail = func(a *Api) error { return a.Get(ctx, "/", nil, nil) }
+		}
+		err := fail(a)
+		var e *Error
+		if !assert.True(t, errors.As(err, &e), "%s: %v", tc.name, err) {
+			continue
+		}
+		assert.Equal(t, tc.kind, e.Kind, "%s: %v", tc.name, err)
+		assert.Equal(t, tc.kind, KindOf(e.Err), tc.name)
+		assert.Equal(t, 1, e.Attempts, tc.name)
+		assert.Equal(t, e.Err.Error(), err.Error(), tc.name)
+	}
+}
+
+func TestErrorTaxonomy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	// Off by default, the errors keep their types, and their kind.
+	err := a.Get(ctx, "/users/1", nil, nil)
+	var se *StatusError
+	assert.IsType(t, se, err)
+	assert.Equal(t, HTTPStatus, KindOf(err))
+	var e *Error
+	assert.True(t, errors.As(a.Get(ctx, "/users/1", nil, nil, WithErrorTaxonomy(true)), &e))
+
+	// On, the errors carry the resource template and the attempts made.
+	a.SetErrorTaxonomy(true)
+	a.Retry = &RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	err = a.Template(GET, "/users/{id}").Do(ctx, map[string]string{"id": "1"}, nil, nil)
+	if assert.True(t, errors.As(err, &e), "%v", err) {
+		assert.Equal(t, HTTPStatus, e.Kind)
+		assert.Equal(t, "GET", e.Method)
+		assert.Equal(t, "/users/{id}", e.Resource)
+		assert.Equal(t, 3, e.Attempts)
+		assert.True(t, errors.As(err, &se))
+		assert.Equal(t, http.StatusServiceUnavailable, se.Code)
+		assert.True(t, IsTemporary(err))
+	}
+	a.Retry = nil
+	assert.IsType(t, se, a.Get(ctx, "/users/1", nil, nil, WithErrorTaxonomy(false)))
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	req, _ := a.Request(GET, "/users/1", nil)
+	_, err = a.Do(canceled, req)
+	if assert.True(t, errors.As(err, &e), "%v", err) {
+		assert.Equal(t, Canceled, e.Kind)
+		assert.Equal(t, "/users/1", e.Resource)
+	}
+
+	// The calls are logged with their kind.
+	var buf bytes.Buffer
+	a.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	a.Get(ctx, "/users/1", nil, nil)
+	assert.Contains(t, buf.String(), "kind=http_status")
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// maxDrain limits how much of an unread response body is discarded before closing it,
+// so the connection can be reused without reading arbitrarily large bodies.
+const maxDrain = 16 << 10
+
+// drainClose discards up to maxDrain bytes of the rest of body and closes it.
+// A truncation of the body found while draining it is reported, see TruncatedBodyError.
+func drainClose(body io.ReadCloser) error {
+	_, err := io.CopyN(io.Discard, body, maxDrain)
+	if cerr := body.Close(); !errors.Is(err, ErrTruncatedBody) {
+		return cerr
+	}
+	return err
+}
+
+var leakReport atomic.Pointer[func(site string)]
+
+// DetectLeaks enables detection of response bodies returned by Do that are garbage-collected
+// without being closed. For each of them report is called with the call site of Do,
+// formatted as "file:line function". Passing nil disables the detection.
+//
+// The detection is meant for debugging: it captures a stack trace per call and sets
+// a finalizer on the body. When disabled, it costs a single atomic load per call.
+// Building with the apidebug tag enables it by default, logging via the log package.
+func DetectLeaks(report func(site string)) {
+	if report == nil {
+		leakReport.Store(nil)
+		return
+	}
+	leakReport.Store(&report)
+}
+
+// trackLeak wraps the body of resp with the leak detector, if it's enabled. The response is copied,
+// since the transport keeps referencing the original one until the body is closed. The bodies of
+// SpoolToDisk report their leaks themselves.
+func trackLeak(resp *http.Response) *http.Response {
+	report := leakReport.Load()
+	if report == nil {
+		return resp
+	}
+	if _, ok := resp.Body.(*spoolBody); ok {
+		return resp
+	}
+	b := &leakBody{ReadCloser: resp.Body}
+	site := callSite()
+	runtime.SetFinalizer(b, func(b *leakBody) {
+		(*report)(site)
+	})
+	tracked := *resp
+	tracked.Body = b
+	return &tracked
+}
+
+type leakBody struct {
+	io.ReadCloser
+}
+
+func (b *leakBody) Close() error {
+	runtime.SetFinalizer(b, nil)
+	return b.ReadCloser.Close()
+}
+
+var packageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// callSite returns the first caller outside of this package, tests excluded.
+func callSite() string {
+	pc := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	for {
+		f, more := frames.Next()
+		if filepath.Dir(f.File) != packageDir || strings.HasSuffix(f.File, "_test.go") {
+			return fmt.Sprintf("%s:%d %s", f.File, f.Line, f.Function)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
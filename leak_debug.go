@@ -0,0 +1,11 @@
+//go:build apidebug
+
+package api
+
+import "log"
+
+func init() {
+	DetectLeaks(func(site string) {
+		log.Printf("api: response body of the call at %s was not closed", site)
+	})
+}
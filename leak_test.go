@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoJSONDrainsOnDecodeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			io.WriteString(w, "not json"+strings.Repeat(" ", 8<<10))
+			return
+		}
+		io.WriteString(w, `{}`)
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var out struct{}
+	assert.Error(t, a.DoJSON(context.Background(), GET, "/bad", nil, &out))
+
+	var meta ResponseMeta
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/good", nil, &out, WithMeta(&meta)))
+	assert.True(t, meta.ConnReused)
+}
+
+func TestDetectLeaks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var sites []string
+	DetectLeaks(func(site string) {
+		mu.Lock()
+		sites = append(sites, site)
+		mu.Unlock()
+	})
+	defer DetectLeaks(nil)
+
+	a := MustNew(srv.URL)
+	func() {
+		req, _ := a.Request(GET, "/closed", nil)
+		resp, err := a.Do(context.Background(), req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}()
+	func() {
+		req, _ := a.Request(GET, "/leaked", nil)
+		_, err := a.Do(context.Background(), req)
+		assert.NoError(t, err)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime.GC()
+		mu.Lock()
+		n := len(sites)
+		mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.Len(t, sites, 1) {
+		assert.Contains(t, sites[0], "leak_test.go:")
+		assert.Contains(t, sites[0], "TestDetectLeaks")
+	}
+}
+
+func TestDetectLeaksDisabled(t *testing.T) {
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(""))}
+	assert.True(t, resp == trackLeak(resp))
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// lazy is a resource of the Api created on first use, exactly once even when the first uses are
+// concurrent, so New stays cheap. Load and Store are those of the pointer, e.g. for ForTenant.
+type lazy[T any] struct {
+	atomic.Pointer[T]
+	mu sync.Mutex
+}
+
+// get returns the resource, creating it with create if there's none yet.
+func (l *lazy[T]) get(create func() *T) *T {
+	if v := l.Load(); v != nil {
+		return v
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if v := l.Load(); v != nil {
+		return v
+	}
+	v := create()
+	l.Store(v)
+	return v
+}
+
+// background tracks the goroutines the Api runs on its own, which Close stops and waits for.
+type background struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newBackground() *background {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &background{ctx: ctx, cancel: cancel}
+}
+
+// goBackground runs fn in a goroutine with a context derived from ctx, canceled by Close too.
+// Once the Api is closed, fn gets a canceled context.
+func (a *Api) goBackground(ctx context.Context, fn func(ctx context.Context)) {
+	bg := a.bg.get(newBackground)
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(bg.ctx, cancel)
+	bg.mu.Lock()
+	tracked := !bg.closed
+	if tracked {
+		bg.wg.Add(1)
+	}
+	bg.mu.Unlock()
+	go func() {
+		if tracked {
+			defer bg.wg.Done()
+		}
+		defer stop()
+		defer cancel()
+		fn(ctx)
+	}()
+}
+
+// Initialize creates the resources of the Api created on first use otherwise, so the first
+// calls don't pay for them, and gets a first token from the TokenSource and the AuthForPrefix
+// sources, and a first client certificate from SetClientCertificateFunc, so misconfigured
+// credentials fail at startup rather than on the first call. It's optional: every resource
+// is created when it's first needed anyway. It fails with ErrClientClosed after Close or Shutdown.
+func (a *Api) Initialize(ctx context.Context) error {
+	a.mu.Lock()
+	closed := a.closed
+	sources := []TokenSource{a.tokens}
+	for _, r := range a.authRules {
+		sources = append(sources, r.ts)
+	}
+	a.mu.Unlock()
+	if closed {
+		return ErrClientClosed
+	}
+	a.poolStats()
+	a.fences()
+	a.bg.get(newBackground)
+	a.client()
+	for _, ts := range sources {
+		if ts == nil {
+			continue
+		}
+		if _, err := ts.Token(ctx); err != nil {
+			return fmt.Errorf("api: initialize token source: %w", err)
+		}
+	}
+	if c := a.cert.Load(); c != nil {
+		if _, err := c.get(&tls.CertificateRequestInfo{}); err != nil {
+			return fmt.Errorf("api: initialize client certificate: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close releases the resources of the Api: it stops the goroutines it runs in the background,
+// those of WatchHealth, Watch, ExportPoolStats, the mirrored requests, the refreshes of
+// SetCache and the workers of DoAsync, failing its queued calls, waits for them to return, and
+// closes the idle connections of the client. New calls fail with ErrClientClosed, while the calls
+// in flight are left to finish: call Shutdown first to wait for them. The Apis derived by ForTenant have their own background goroutines, which
+// aren't stopped. Close is safe to call more than once, and always returns nil.
+func (a *Api) Close() error {
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+	bg := a.bg.get(newBackground)
+	bg.mu.Lock()
+	bg.closed = true
+	bg.mu.Unlock()
+	bg.cancel()
+	bg.wg.Wait()
+	a.client().CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazy(t *testing.T) {
+	var l lazy[int]
+	var created atomic.Int32
+	got := make([]*int, 50)
+	var wg sync.WaitGroup
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = l.get(func() *int {
+				created.Add(1)
+				return new(int)
+			})
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), created.Load())
+	for _, p := range got {
+		assert.Same(t, got[0], p)
+	}
+}
+
+// countingToken is a TokenSource counting its calls, failing with err if set.
+type countingToken struct {
+	calls atomic.Int32
+	err   error
+}
+
+func (c *countingToken) Token(context.Context) (*Token, error) {
+	c.calls.Add(1)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &Token{AccessToken: "t0k"}, nil
+}
+
+func TestInitialize(t *testing.T) {
+	a := MustNew("http://example.com/api")
+	ctx := context.Background()
+	ts, admin := &countingToken{}, &countingToken{}
+	a.SetTokenSource(ts)
+	assert.NoError(t, a.AuthForPrefix("/admin", admin))
+	assert.Nil(t, a.pool.Load())
+	assert.NoError(t, a.Initialize(ctx))
+	assert.NotNil(t, a.pool.Load())
+	assert.NotNil(t, a.serial.Load())
+	assert.Equal(t, int32(1), ts.calls.Load())
+	assert.Equal(t, int32(1), admin.calls.Load())
+
+	// The credentials fail at startup.
+	errDenied := errors.New("denied")
+	admin.err = errDenied
+	err := a.Initialize(ctx)
+	assert.True(t, errors.Is(err, errDenied), "%v", err)
+	admin.err = nil
+	a.SetClientCertificateFunc(func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return nil, errDenied })
+	err = a.Initialize(ctx)
+	assert.True(t, errors.Is(err, errDenied), "%v", err)
+
+	assert.NoError(t, a.Close())
+	assert.Equal(t, ErrClientClosed, a.Initialize(ctx))
+}
+
+func TestCloseGoroutines(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+	before := runtime.NumGoroutine()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	healthy := make(chan struct{}, 1)
+	a.WatchHealth(ctx, 10*time.Millisecond, "/health", func(tr HealthTransition) {
+		if tr.Healthy {
+			healthy <- struct{}{}
+		}
+	})
+	changes, errs := a.Watch(ctx, "/events", WatchOptions{})
+	stop := a.ExportPoolStats(time.Hour, func(PoolStats) {})
+	defer stop()
+	assert.NoError(t, a.Get(ctx, "/", nil, nil))
+	<-healthy
+	assert.Greater(t, runtime.NumGoroutine(), before)
+
+	assert.NoError(t, a.Close())
+	for range changes {
+	}
+	for range errs {
+	}
+	assertGoroutines(t, before)
+	assert.Equal(t, ErrClientClosed, a.Get(ctx, "/", nil, nil))
+	assert.NoError(t, a.Close())
+
+	// The goroutines started after Close return right away.
+	changes, errs = a.Watch(ctx, "/events", WatchOptions{})
+	for range changes {
+	}
+	for range errs {
+	}
+}
+
+// assertGoroutines asserts the number of goroutines falls back to n within a second, once the
+// connections closed have been noticed by the server. It doesn't use assert.Eventually, which
+// runs the condition in a goroutine of its own.
+func assertGoroutines(t *testing.T, n int) {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if left := runtime.NumGoroutine(); left > n {
+		buf := make([]byte, 1<<20)
+		t.Errorf("%d goroutines left over %d:\n%s", left, n, buf[:runtime.Stack(buf, true)])
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Link is a single entry of a Link header (RFC 8288).
+type Link struct {
+	// URL is the target of the link as given, possibly relative to the request URL.
+	URL string
+	// Params holds the link parameters by their lowercased names, unquoted.
+	// Parameters without a value are present with an empty one.
+	Params map[string]string
+}
+
+// ParseLinks parses all Link headers of h into a map of rel to link, the rel values lowercased.
+// Links with multiple rel values are stored under each of them; for duplicate rels the first link wins.
+// Malformed entries are skipped.
+func ParseLinks(h http.Header) map[string]Link {
+	links := make(map[string]Link)
+	for _, v := range h.Values("Link") {
+		for v != "" {
+			var l Link
+			var ok bool
+			if l, v, ok = parseLink(v); !ok {
+				continue
+			}
+			for _, rel := range strings.Fields(l.Params["rel"]) {
+				rel = strings.ToLower(rel)
+				if _, dup := links[rel]; !dup {
+					links[rel] = l
+				}
+			}
+		}
+	}
+	return links
+}
+
+// parseLink parses the first link-value of s and returns the rest of s.
+// Malformed entries are skipped up to the next top-level comma.
+func parseLink(s string) (l Link, rest string, ok bool) {
+	s = strings.TrimLeft(s, " \t,")
+	if !strings.HasPrefix(s, "<") {
+		return l, skipElement(s), false
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 0 {
+		return l, "", false
+	}
+	l.URL, s = strings.TrimSpace(s[1:end]), s[end+1:]
+	l.Params = make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return l, "", true
+		}
+		if s[0] == ',' {
+			return l, s[1:], true
+		}
+		if s[0] != ';' {
+			return l, skipElement(s), false
+		}
+		s = strings.TrimLeft(s[1:], " \t")
+		i := strings.IndexAny(s, "=;,")
+		if i < 0 {
+			l.Params[strings.ToLower(strings.TrimSpace(s))] = ""
+			return l, "", true
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		if s[i] != '=' {
+			l.Params[key] = ""
+			s = s[i:]
+			continue
+		}
+		s = strings.TrimLeft(s[i+1:], " \t")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			if value, s, ok = unquote(s); !ok {
+				return l, "", false
+			}
+		} else {
+			j := strings.IndexAny(s, ";,")
+			if j < 0 {
+				j = len(s)
+			}
+			value, s = strings.TrimSpace(s[:j]), s[j:]
+		}
+		l.Params[key] = value
+	}
+}
+
+// skipElement skips s up to the next comma that is not within a quoted string or angle brackets.
+func skipElement(s string) string {
+	var quoted, bracket bool
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == '<':
+			bracket = true
+		case !quoted && c == '>':
+			bracket = false
+		case !quoted && !bracket && c == ',':
+			return s[i+1:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLinksHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		values []string
+		want   map[string]Link
+	}{
+		{"none", nil, map[string]Link{}},
+		{"github", []string{`<https://api.github.com/repositories/1/issues?page=2>; rel="next", <https://api.github.com/repositories/1/issues?page=5>; rel="last"`}, map[string]Link{
+			"next": {URL: "https://api.github.com/repositories/1/issues?page=2", Params: map[string]string{"rel": "next"}},
+			"last": {URL: "https://api.github.com/repositories/1/issues?page=5", Params: map[string]string{"rel": "last"}},
+		}},
+		{"comma and semicolon in quoted param", []string{`</a>; title="x, y; z"; rel=next, </b>; rel=prev`}, map[string]Link{
+			"next": {URL: "/a", Params: map[string]string{"title": "x, y; z", "rel": "next"}},
+			"prev": {URL: "/b", Params: map[string]string{"rel": "prev"}},
+		}},
+		{"comma in url", []string{`</items?ids=1,2,3>; rel="next"`}, map[string]Link{
+			"next": {URL: "/items?ids=1,2,3", Params: map[string]string{"rel": "next"}},
+		}},
+		{"spaces everywhere", []string{`  <  /a  >  ;  REL = "Next"  ;  Type = text/html  ,`}, map[string]Link{
+			"next": {URL: "/a", Params: map[string]string{"rel": "Next", "type": "text/html"}},
+		}},
+		{"escaped quote", []string{`</a>; title="say \"hi\", bye"; rel=next`}, map[string]Link{
+			"next": {URL: "/a", Params: map[string]string{"title": `say "hi", bye`, "rel": "next"}},
+		}},
+		{"flag param", []string{`</a>; crossorigin; rel=preload`}, map[string]Link{
+			"preload": {URL: "/a", Params: map[string]string{"crossorigin": "", "rel": "preload"}},
+		}},
+		{"multiple rels", []string{`</a>; rel="next  alternate"`}, map[string]Link{
+			"next":      {URL: "/a", Params: map[string]string{"rel": "next  alternate"}},
+			"alternate": {URL: "/a", Params: map[string]string{"rel": "next  alternate"}},
+		}},
+		{"first rel wins across headers", []string{`</1>; rel=next`, `</2>; rel=next, </3>; rel=last`}, map[string]Link{
+			"next": {URL: "/1", Params: map[string]string{"rel": "next"}},
+			"last": {URL: "/3", Params: map[string]string{"rel": "last"}},
+		}},
+		{"malformed entries skipped", []string{`garbage, "quoted, <x>", </a>; rel=next`, `</b>; rel="unterminated`, `<https://x/c`}, map[string]Link{
+			"next": {URL: "/a", Params: map[string]string{"rel": "next"}},
+		}},
+		{"no rel", []string{`</a>; title=x`}, map[string]Link{}},
+	} {
+		assert.Equal(t, tc.want, ParseLinks(http.Header{"Link": tc.values}), tc.name)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Locale sets the Accept-Language header of the call from the given language tags.
+// Tags may carry an explicit quality value ("en;q=0.8"); tags without one get
+// decreasing quality values in the order given, starting from the implicit 1 of the first tag:
+//
+//	Locale("de-DE", "fr", "en;q=0.5") // Accept-Language: de-DE, fr;q=0.9, en;q=0.5
+//
+// The per-call locale takes precedence over ContextLocale and the Api default set by SetLocale.
+func Locale(tags ...string) Option {
+	v, err := formatLocale(tags)
+	return func(c *call) {
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.locale = v
+	}
+}
+
+// SetLocale sets the default Accept-Language for all calls made via Do, see Locale for the format.
+// Calling it without tags removes the default.
+func (a *Api) SetLocale(tags ...string) error {
+	v, err := formatLocale(tags)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.locale = v
+	a.mu.Unlock()
+	return nil
+}
+
+type localeKey struct{}
+
+type contextLocale struct {
+	value string
+	err   error
+}
+
+// ContextLocale returns a copy of ctx that carries the Accept-Language for calls made with it,
+// so a middleware can set the end user's locale once. See Locale for the format and precedence.
+// Invalid tags make the calls fail.
+func ContextLocale(ctx context.Context, tags ...string) context.Context {
+	v, err := formatLocale(tags)
+	return context.WithValue(ctx, localeKey{}, contextLocale{value: v, err: err})
+}
+
+// applyLocale sets Accept-Language following the per-call > context > Api default precedence.
+func (a *Api) applyLocale(ctx context.Context, c *call, req *http.Request) error {
+	v := c.locale
+	if v == "" {
+		if l, ok := ctx.Value(localeKey{}).(contextLocale); ok {
+			if l.err != nil {
+				return l.err
+			}
+			v = l.value
+		}
+	}
+	if v == "" {
+		a.mu.Lock()
+		v = a.locale
+		a.mu.Unlock()
+	}
+	if v != "" {
+		req.Header.Set("Accept-Language", v)
+	}
+	return nil
+}
+
+func formatLocale(tags []string) (string, error) {
+	parts := make([]string, 0, len(tags))
+	q := 10
+	for i, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		var weight string
+		if j := strings.IndexByte(tag, ';'); j >= 0 {
+			tag, weight = strings.TrimSpace(tag[:j]), strings.TrimSpace(tag[j+1:])
+		}
+		if !validLanguageTag(tag) {
+			return "", fmt.Errorf("api: invalid language tag: %q", tag)
+		}
+		switch {
+		case weight != "":
+			if !strings.HasPrefix(weight, "q=") {
+				return "", fmt.Errorf("api: invalid language tag parameter: %q", weight)
+			}
+			f, err := strconv.ParseFloat(weight[2:], 64)
+			if err != nil || f < 0 || f > 1 {
+				return "", fmt.Errorf("api: invalid quality value: %q", weight)
+			}
+			parts = append(parts, tag+";q="+strconv.FormatFloat(f, 'f', -1, 64))
+		case i == 0:
+			parts = append(parts, tag)
+		default:
+			if q > 1 {
+				q--
+			}
+			parts = append(parts, fmt.Sprintf("%s;q=0.%d", tag, q))
+		}
+	}
+	return strings.Join(parts, ", "), nil
+}
+
+// validLanguageTag loosely checks a BCP 47 tag: "*" or 1-8 alphanumeric subtags separated by dashes.
+func validLanguageTag(tag string) bool {
+	if tag == "*" {
+		return true
+	}
+	if tag == "" {
+		return false
+	}
+	for _, sub := range strings.Split(tag, "-") {
+		if len(sub) == 0 || len(sub) > 8 {
+			return false
+		}
+		for _, r := range sub {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
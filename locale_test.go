@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatLocale(t *testing.T) {
+	v, err := formatLocale([]string{"de-DE", "en;q=0.8"})
+	assert.NoError(t, err)
+	assert.Equal(t, "de-DE, en;q=0.8", v)
+
+	v, err = formatLocale([]string{"de-DE", "fr", "en-GB", "*;q=0.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "de-DE, fr;q=0.9, en-GB;q=0.8, *;q=0.1", v)
+
+	_, err = formatLocale([]string{"en_US"})
+	assert.Error(t, err)
+	_, err = formatLocale([]string{"en;q=2"})
+	assert.Error(t, err)
+	_, err = formatLocale([]string{"en;level=1"})
+	assert.Error(t, err)
+}
+
+func TestLocalePrecedence(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Accept-Language")
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	do := func(ctx context.Context, opts ...Option) string {
+		got = ""
+		assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil, opts...))
+		return got
+	}
+	ctx := context.Background()
+	assert.Equal(t, "", do(ctx))
+
+	assert.NoError(t, a.SetLocale("en-US"))
+	assert.Equal(t, "en-US", do(ctx))
+
+	userCtx := ContextLocale(ctx, "fr-FR", "fr")
+	assert.Equal(t, "fr-FR, fr;q=0.9", do(userCtx))
+	assert.Equal(t, "de-DE", do(userCtx, Locale("de-DE")))
+
+	err := a.DoJSON(ctx, GET, "/", nil, nil, Locale("not a tag"))
+	assert.Error(t, err)
+	err = a.DoJSON(ContextLocale(ctx, "bad tag"), GET, "/", nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CallLog describes a completed call made via the Do-style helpers.
+type CallLog struct {
+	Method string
+	// Resource is the resource as given to the helper, or the URL path for List's next pages.
+	Resource string
+	// Env is the current environment of Apis created by NewMulti.
+	Env string
+	// Tenant is the tenant id of Apis derived by ForTenant.
+	Tenant string
+	// Team and Feature are the attribution of the call, see WithAttribution.
+	Team    string
+	Feature string
+	// Host is the host of the request URL, e.g. the one of WithBaseURL; the hosts picked
+	// by SetHosts aren't reflected.
+	Host string
+	// Status is the final HTTP status code, 0 if no response was received.
+	Status int
+	// Duration is the time from sending the first attempt until the response body was closed.
+	Duration time.Duration
+	Retries  int
+	// Hedges is the number of duplicate requests sent because of WithHedging.
+	Hedges int
+	// RequestSize is the request body size, counted as it was sent for the bodies streamed
+	// without a known length, -1 if unknown.
+	RequestSize int64
+	// ResponseSize is the number of response body bytes read by the caller.
+	ResponseSize int64
+	// RequestID is the X-Request-Id of the request, or of the response if the request didn't have one.
+	RequestID string
+	// Err is the error the call failed with, its URL masked by the Api's Redactor.
+	Err error
+	// Kind is the kind of Err, see KindOf.
+	Kind Kind
+	// Trace holds the timings of the last attempt of the calls made with WithTrace, nil otherwise.
+	Trace *TraceStats
+}
+
+// CallLogger receives one CallLog per completed call. Headers are never passed to it,
+// and the URLs in errors are masked by the Api's Redactor, so nothing sensitive can leak through it. See SlogLogger for a log/slog adapter.
+type CallLogger interface {
+	LogCall(ctx context.Context, l CallLog)
+}
+
+// SetLogger makes the Api log every completed call to l using NewSlogLogger defaults.
+// Passing nil disables logging.
+func (a *Api) SetLogger(l *slog.Logger) {
+	if l == nil {
+		a.SetCallLogger(nil)
+		return
+	}
+	a.SetCallLogger(NewSlogLogger(l))
+}
+
+// SetCallLogger sets the logger of completed calls.
+func (a *Api) SetCallLogger(l CallLogger) {
+	a.mu.Lock()
+	a.logger = l
+	a.mu.Unlock()
+}
+
+// SlogLogger is a CallLogger writing to a *slog.Logger.
+type SlogLogger struct {
+	Logger *slog.Logger
+	// SuccessLevel is used for 1xx-3xx responses.
+	SuccessLevel slog.Level
+	// ClientErrorLevel is used for 4xx responses.
+	ClientErrorLevel slog.Level
+	// ErrorLevel is used for 5xx responses and transport errors.
+	ErrorLevel slog.Level
+	// SampleSuccess logs only every Nth successful call if greater than 1. Failures are always logged.
+	SampleSuccess int
+
+	successes atomic.Int64
+}
+
+// NewSlogLogger creates a SlogLogger logging successes at Info, 4xx at Warn and other failures at Error.
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	return &SlogLogger{
+		Logger:           l,
+		SuccessLevel:     slog.LevelInfo,
+		ClientErrorLevel: slog.LevelWarn,
+		ErrorLevel:       slog.LevelError,
+	}
+}
+
+// LogCall implements CallLogger.
+func (s *SlogLogger) LogCall(ctx context.Context, l CallLog) {
+	level := s.SuccessLevel
+	switch {
+	case l.Status >= 400 && l.Status <= 499:
+		level = s.ClientErrorLevel
+	case l.Err != nil || l.Status >= 500:
+		level = s.ErrorLevel
+	default:
+		if n := s.SampleSuccess; n > 1 && (s.successes.Add(1)-1)%int64(n) != 0 {
+			return
+		}
+	}
+	if !s.Logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("method", l.Method),
+		slog.String("resource", l.Resource),
+		slog.Int("status", l.Status),
+		slog.Duration("duration", l.Duration),
+		slog.Int("retries", l.Retries),
+		slog.Int64("request_size", l.RequestSize),
+		slog.Int64("response_size", l.ResponseSize),
+	}
+	if l.RequestID != "" {
+		attrs = append(attrs, slog.String("request_id", l.RequestID))
+	}
+	if l.Hedges > 0 {
+		attrs = append(attrs, slog.Int("hedges", l.Hedges))
+	}
+	if l.Env != "" {
+		attrs = append(attrs, slog.String("env", l.Env))
+	}
+	if l.Tenant != "" {
+		attrs = append(attrs, slog.String("tenant", l.Tenant))
+	}
+	if l.Team != "" {
+		attrs = append(attrs, slog.String("team", l.Team))
+	}
+	if l.Feature != "" {
+		attrs = append(attrs, slog.String("feature", l.Feature))
+	}
+	if l.Host != "" {
+		attrs = append(attrs, slog.String("host", l.Host))
+	}
+	if l.Trace != nil {
+		attrs = append(attrs, slog.Duration("ttfb", l.Trace.TTFB), slog.Bool("conn_reused", l.Trace.ConnReused))
+	}
+	if l.Err != nil {
+		attrs = append(attrs, slog.String("error", l.Err.Error()), slog.String("kind", l.Kind.String()))
+	}
+	s.Logger.LogAttrs(ctx, level, "api call", attrs...)
+}
+
+func (a *Api) callLogger() CallLogger {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.logger
+}
+
+// logCall logs a failed call right away, or a successful one once its body is closed.
+func (a *Api) logCall(ctx context.Context, c *call, req *http.Request, resp *http.Response, err error, start time.Time, retries int) {
+	logger := a.callLogger()
+	if logger == nil {
+		return
+	}
+	clk := a.clock()
+	at := a.attributionOf(ctx)
+	l := CallLog{
+		Method:      req.Method,
+		Resource:    c.resource,
+		Env:         a.Env(),
+		Tenant:      a.tenant,
+		Team:        at.Team,
+		Feature:     at.Feature,
+		Host:        req.URL.Host,
+		Retries:     retries,
+		Hedges:      c.hedges,
+		RequestSize: req.ContentLength,
+		RequestID:   req.Header.Get("X-Request-Id"),
+		Err:         a.Redactor().redactError(err),
+		Kind:        KindOf(err),
+	}
+	if c.trace != nil {
+		l.Trace = c.trace.stats
+	}
+	if c.sent != nil {
+		l.RequestSize = c.sent.n.Load()
+	} else if req.ContentLength == 0 && req.Body != nil && req.Body != http.NoBody {
+		l.RequestSize = -1
+	}
+	if resp == nil {
+		var se *StatusError
+		if errors.As(err, &se) {
+			l.Status = se.Code
+			l.ResponseSize = int64(len(se.Body))
+			if l.RequestID == "" {
+				l.RequestID = se.Header.Get("X-Request-Id")
+			}
+		}
+		l.Duration = clk.Now().Sub(start)
+		logger.LogCall(ctx, l)
+		return
+	}
+	l.Status = resp.StatusCode
+	if l.RequestID == "" {
+		l.RequestID = resp.Header.Get("X-Request-Id")
+	}
+	resp.Body = &loggedBody{ReadCloser: resp.Body, done: func(n int64) {
+		l.ResponseSize = n
+		l.Duration = clk.Now().Sub(start)
+		logger.LogCall(ctx, l)
+	}}
+}
+
+// loggedBody counts the bytes read and reports them once closed.
+type loggedBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64)
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
+}
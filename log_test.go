@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	h.records = append(h.records, r)
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler      { return h }
+
+func recordAttrs(r slog.Record) map[string]string {
+	attrs := make(map[string]string)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.String()
+		return true
+	})
+	return attrs
+}
+
+func TestSetLogger(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-1")
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	a.Header = http.Header{"Authorization": {"Bearer secret-token"}}
+	h := &recordHandler{}
+	a.SetLogger(slog.New(h))
+
+	var out struct{ OK bool }
+	assert.NoError(t, a.DoJSON(context.Background(), POST, "/items", nil, &out))
+	assert.Error(t, a.DoJSON(context.Background(), GET, "/missing", nil, nil))
+	assert.Error(t, a.DoJSON(context.Background(), GET, "/broken", nil, nil))
+
+	if !assert.Len(t, h.records, 3) {
+		return
+	}
+	assert.Equal(t, slog.LevelInfo, h.records[0].Level)
+	assert.Equal(t, slog.LevelWarn, h.records[1].Level)
+	assert.Equal(t, slog.LevelError, h.records[2].Level)
+
+	attrs := recordAttrs(h.records[0])
+	assert.Equal(t, "POST", attrs["method"])
+	assert.Equal(t, "/items", attrs["resource"])
+	assert.Equal(t, "200", attrs["status"])
+	assert.Equal(t, "0", attrs["retries"])
+	assert.Equal(t, "0", attrs["request_size"])
+	assert.Equal(t, "11", attrs["response_size"])
+	assert.Equal(t, "req-1", attrs["request_id"])
+	assert.Contains(t, attrs, "duration")
+	assert.NotContains(t, attrs, "env")
+	assert.Equal(t, "404", recordAttrs(h.records[1])["status"])
+
+	for _, r := range h.records {
+		for k, v := range recordAttrs(r) {
+			assert.NotContains(t, v, "secret-token", k)
+		}
+		assert.False(t, strings.Contains(r.Message, "secret-token"))
+	}
+}
+
+func TestSetLoggerTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	a := MustNew(srv.URL)
+	h := &recordHandler{}
+	a.SetLogger(slog.New(h))
+	assert.Error(t, a.DoJSON(context.Background(), GET, "/items", nil, nil))
+	if !assert.Len(t, h.records, 1) {
+		return
+	}
+	assert.Equal(t, slog.LevelError, h.records[0].Level)
+	attrs := recordAttrs(h.records[0])
+	assert.Equal(t, "0", attrs["status"])
+	assert.Contains(t, attrs, "error")
+}
+
+func TestSlogLoggerSampling(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	h := &recordHandler{}
+	l := NewSlogLogger(slog.New(h))
+	l.SampleSuccess = 3
+	l.ClientErrorLevel = slog.LevelError
+	a.SetCallLogger(l)
+
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, a.DoJSON(context.Background(), GET, "/items", nil, nil))
+	}
+	assert.Error(t, a.DoJSON(context.Background(), GET, "/missing", nil, nil))
+	assert.Error(t, a.DoJSON(context.Background(), GET, "/missing", nil, nil))
+	if !assert.Len(t, h.records, 5) {
+		return
+	}
+	assert.Equal(t, slog.LevelError, h.records[3].Level)
+	assert.Equal(t, slog.LevelError, h.records[4].Level)
+}
+
+func TestSetLoggerEnv(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	a, err := NewMulti(map[string]string{"sandbox": srv.URL}, "sandbox")
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := &recordHandler{}
+	a.SetLogger(slog.New(h))
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/items", nil, nil))
+	if assert.Len(t, h.records, 1) {
+		assert.Equal(t, "sandbox", recordAttrs(h.records[0])["env"])
+	}
+}
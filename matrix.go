@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Matrix are the matrix parameters of a path segment, like the "version" and "lang" of
+// "/things;lang=en;version=2/items", see Matrix.Seg.
+type Matrix map[string]string
+
+// Seg returns the path segment name with the parameters of m, for a resource or as the value of
+// a parameter of a Template, e.g.
+//
+//	req, err := a.Request(api.GET, "/"+api.Matrix{"version": "2", "lang": "en"}.Seg("things")+"/items", nil)
+//
+// is a GET of "/things;lang=en;version=2/items". The parameters are sorted by name, the ones
+// with an empty value holding just their name. Like the one of Seg, the segment is data: the
+// name and the parameters are escaped as RFC 3986 says, the ";" and "=" within them included,
+// and the URL keeps them escaped through its RawPath, so the server gets the literal structure
+// of the segment whatever the values.
+func (m Matrix) Seg(name string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(segMark)
+	b.WriteString(escapeMatrix(name))
+	for _, k := range keys {
+		b.WriteByte(';')
+		b.WriteString(escapeMatrix(k))
+		if v := m[k]; v != "" {
+			b.WriteByte('=')
+			b.WriteString(escapeMatrix(v))
+		}
+	}
+	b.WriteString(segMark)
+	return b.String()
+}
+
+var matrixEscaper = strings.NewReplacer(";", "%3B", "=", "%3D")
+
+// escapeMatrix escapes s as the name or a parameter of a matrix segment.
+func escapeMatrix(s string) string {
+	return matrixEscaper.Replace(escapeSegment(s))
+}
+
+// validMatrix reports whether piece is a segment of Matrix.Seg, in its escaped form.
+func validMatrix(piece string) bool {
+	parts := strings.Split(piece, ";")
+	valid := func(s string) bool {
+		v, err := url.PathUnescape(s)
+		return err == nil && escapeMatrix(v) == s
+	}
+	if !valid(parts[0]) {
+		return false
+	}
+	for _, p := range parts[1:] {
+		k, v, ok := strings.Cut(p, "=")
+		if !valid(k) || ok && (v == "" || !valid(v)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatrix(t *testing.T) {
+	a := MustNew("http://api.example.com/v1/")
+	for _, tt := range []struct {
+		m    Matrix
+		name string
+		raw  string
+	}{
+		{Matrix{"version": "2", "lang": "en"}, "things", "/v1/things;lang=en;version=2/items"},
+		{Matrix{"q": "a;b=c", "k=1": "x y"}, "things", "/v1/things;k%3D1=x%20y;q=a%3Bb%3Dc/items"},
+		{Matrix{"flag": "", "p": "/?#%"}, "a;b", "/v1/a%3Bb;flag;p=%2F%3F%23%25/items"},
+		{Matrix{}, "plain", "/v1/plain/items"},
+		{nil, "..", "/v1/%2E%2E/items"},
+	} {
+		req, err := a.Request(GET, "/"+tt.m.Seg(tt.name)+"/items", url.Values{"a": {"1;2"}})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, tt.raw, req.URL.EscapedPath())
+		// The query is left as it is.
+		assert.Equal(t, "a=1%3B2", req.URL.RawQuery)
+		// The URL parses back into the same path.
+		u, err := url.Parse(req.URL.String())
+		if assert.NoError(t, err) {
+			assert.Equal(t, tt.raw, u.EscapedPath())
+			assert.Equal(t, req.URL.Path, u.Path)
+		}
+	}
+
+	// The parameters of the templates take the matrix segments as they are.
+	things := a.Template(GET, "/{thing}/items/{id}")
+	req, err := things.Build(map[string]string{"thing": Matrix{"lang": "fr;be", "v": "="}.Seg("things"), "id": "a;b"}, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/v1/things;lang=fr%3Bbe;v=%3D/items/a;b", req.URL.EscapedPath())
+	}
+	req, _ = things.Build(map[string]string{"thing": Seg("x/y"), "id": "1"}, nil)
+	assert.Equal(t, "/v1/x%2Fy/items/1", req.URL.EscapedPath())
+}
+
+func TestMatrixServer(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.RequestURI
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	err := a.Get(context.Background(), "/"+Matrix{"version": "2", "lang": "en us"}.Seg("things")+"/items", url.Values{"page": {"2"}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/things;lang=en%20us;version=2/items?page=2", got)
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultMemoLimit is the number of memoized results kept unless SetMemoLimit is called.
+const defaultMemoLimit = 1000
+
+// Memoize makes a GET call through DoJSON, its helpers or a Template keep the JSON response for ttl,
+// whatever its cache headers say, e.g. for reference data like currencies whose upstream sends
+// none. Unlike SetCache, it isn't HTTP caching: the calls for the same URL within ttl are answered
+// without a request, and the concurrent first calls share a single request whose failure they all
+// get. The body is kept rather than the decoded value, so every caller decodes its own copy and
+// may modify it. The memoized answers are reported in ResponseMeta as Cached. See InvalidateMemo
+// for forgetting results before ttl and SetMemoLimit for the number of results kept.
+func Memoize(ttl time.Duration) Option {
+	return func(c *call) {
+		c.memo = ttl
+	}
+}
+
+// SetMemoLimit sets the number of results kept by Memoize, 1000 by default: once it's reached,
+// the expired results are dropped, then those expiring first.
+func (a *Api) SetMemoLimit(n int) {
+	a.mu.Lock()
+	a.memoLimit = n
+	a.mu.Unlock()
+}
+
+// InvalidateMemo forgets the results of Memoize for the resources matching the template with params
+// substituted in, whatever their query: the parameters missing from params match any segment, so
+// InvalidateMemo("/users/{id}", nil) forgets every user and InvalidateMemo("/currencies", nil)
+// forgets the currencies.
+func (a *Api) InvalidateMemo(resourceTemplate string, params map[string]string) {
+	tmpl := expandParams(resourceTemplate, params)
+	m := a.memoStore()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, e := range m.entries {
+		if matchTemplate(tmpl, e.resource) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+// memoEntry is a result of Memoize.
+type memoEntry struct {
+	resource string
+	code     int
+	header   http.Header
+	body     []byte
+	received time.Time
+	expires  time.Time
+}
+
+// memoCall is the request of the first of concurrent calls, shared by the others.
+type memoCall struct {
+	done  chan struct{}
+	entry *memoEntry
+	err   error
+	// shared is the number of calls waiting for it, guarded by the mutex of the memo.
+	shared int
+}
+
+// memo is the store of Memoize of an Api.
+type memo struct {
+	mu      sync.Mutex
+	entries map[string]*memoEntry
+	calls   map[string]*memoCall
+}
+
+// expandParams returns the template with the params substituted in, the missing ones left as they are.
+func expandParams(template string, params map[string]string) string {
+	lits, names := splitParams(template)
+	values := make([]string, len(names))
+	for i, name := range names {
+		if v, ok := params[name]; ok {
+			values[i] = v
+		} else {
+			values[i] = "{" + name + "}"
+		}
+	}
+	return substitute(lits, values)
+}
+
+func (a *Api) memoStore() *memo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.memo == nil {
+		a.memo = &memo{entries: make(map[string]*memoEntry), calls: make(map[string]*memoCall)}
+	}
+	return a.memo
+}
+
+func (a *Api) memoLimitOf() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.memoLimit <= 0 {
+		return defaultMemoLimit
+	}
+	return a.memoLimit
+}
+
+// decodeMemo is decodeJSON for the calls made with Memoize.
+func (a *Api) decodeMemo(ctx context.Context, c *call, req *http.Request, out interface{}) error {
+	e, hit, err := a.memoized(ctx, c, req)
+	if err != nil {
+		return err
+	}
+	if hit && c.meta != nil {
+		c.meta.fill(&http.Response{StatusCode: e.code, Header: e.header, Request: req}, e.received, e.received)
+		c.meta.Retries = 0
+		c.meta.stored(ProvenanceMemoized, e.received, a.clock().Now().Sub(e.received))
+	}
+	if e.code == http.StatusNoContent || len(bytes.TrimSpace(e.body)) == 0 {
+		c.noContent()
+		return nil
+	}
+	if out == nil {
+		return nil
+	}
+	return c.decodeValue(c.decoder(bytes.NewReader(e.body)), out)
+}
+
+// memoized returns the result for req, reporting whether it was memoized already.
+func (a *Api) memoized(ctx context.Context, c *call, req *http.Request) (*memoEntry, bool, error) {
+	m := a.memoStore()
+	clk := a.clock()
+	key := req.URL.String()
+	m.mu.Lock()
+	if e, ok := m.entries[key]; ok && clk.Now().Before(e.expires) {
+		m.mu.Unlock()
+		return e, true, nil
+	}
+	if mc, ok := m.calls[key]; ok {
+		mc.shared++
+		m.mu.Unlock()
+		select {
+		case <-mc.done:
+			return mc.entry, true, mc.err
+		case <-ctx.Done():
+			return nil, false, withCause(ctx, ctx.Err())
+		}
+	}
+	mc := &memoCall{done: make(chan struct{})}
+	m.calls[key] = mc
+	m.mu.Unlock()
+
+	mc.entry, mc.err = a.fetchMemo(ctx, c, req, clk)
+	limit := a.memoLimitOf()
+	m.mu.Lock()
+	delete(m.calls, key)
+	if mc.err == nil {
+		m.entries[key] = mc.entry
+		m.evict(limit, clk.Now())
+	}
+	m.mu.Unlock()
+	close(mc.done)
+	return mc.entry, false, mc.err
+}
+
+// fetchMemo sends req and reads its JSON response.
+func (a *Api) fetchMemo(ctx context.Context, c *call, req *http.Request, clk Clock) (*memoEntry, error) {
+	resp, err := a.send(ctx, c, req)
+	if err != nil {
+		return nil, err
+	}
+	defer drainClose(resp.Body)
+	if resp.StatusCode != http.StatusNoContent {
+		if err := checkJSONContent(req, resp); err != nil {
+			return nil, err
+		}
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	now := clk.Now()
+	return &memoEntry{resource: c.resource, code: resp.StatusCode, header: resp.Header, body: body,
+		received: now, expires: now.Add(c.memo)}, nil
+}
+
+// evict drops the expired entries once there are more than limit, then those expiring first.
+func (m *memo) evict(limit int, now time.Time) {
+	if len(m.entries) <= limit {
+		return
+	}
+	for key, e := range m.entries {
+		if !now.Before(e.expires) {
+			delete(m.entries, key)
+		}
+	}
+	for len(m.entries) > limit {
+		var first string
+		for key, e := range m.entries {
+			if first == "" || e.expires.Before(m.entries[first].expires) {
+				first = key
+			}
+		}
+		delete(m.entries, first)
+	}
+}
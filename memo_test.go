@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestMemoize(t *testing.T) {
+	var hits atomic.Int64
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/currencies" {
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"path":"` + r.URL.Path + `","codes":["EUR","USD"]}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a.SetClock(clk)
+	ctx := context.Background()
+	type currencies struct {
+		Path  string
+		Codes []string
+	}
+
+	// The concurrent first calls share a request.
+	results := make([]currencies, 5)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, a.Get(ctx, "/currencies", nil, &results[i], Memoize(time.Minute)))
+		}(i)
+	}
+	// The other calls wait for the first one.
+	assert.Eventually(t, func() bool {
+		m := a.memoStore()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		mc := m.calls[srv.URL+"/currencies"]
+		return mc != nil && mc.shared == len(results)-1
+	}, 5*time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), hits.Load())
+
+	// Every caller has its own copy.
+	results[0].Codes[0] = "GBP"
+	var meta ResponseMeta
+	var again currencies
+	clk.Advance(30 * time.Second)
+	assert.NoError(t, a.Get(ctx, "/currencies", nil, &again, Memoize(time.Minute), WithMeta(&meta)))
+	assert.Equal(t, []string{"EUR", "USD"}, again.Codes)
+	assert.Equal(t, []string{"EUR", "USD"}, results[1].Codes)
+	assert.True(t, meta.Cached)
+	assert.Equal(t, 30*time.Second, meta.Age)
+	assert.Equal(t, http.StatusOK, meta.StatusCode)
+	assert.Equal(t, int64(1), hits.Load())
+
+	// Expired results are fetched again.
+	clk.Advance(time.Minute)
+	assert.NoError(t, a.Get(ctx, "/currencies", nil, &again, Memoize(time.Minute)))
+	assert.Equal(t, int64(2), hits.Load())
+
+	// Templates memoize per URL.
+	tpl := a.Template(GET, "/users/{id}", Memoize(time.Hour))
+	var user currencies
+	for _, id := range []string{"1", "2", "1"} {
+		assert.NoError(t, tpl.Do(ctx, map[string]string{"id": id}, nil, &user))
+	}
+	assert.Equal(t, int64(4), hits.Load())
+
+	// Invalidation forces a refetch of the matching resources only.
+	a.InvalidateMemo("/users/{id}", map[string]string{"id": "2"})
+	assert.NoError(t, tpl.Do(ctx, map[string]string{"id": "1"}, nil, &user))
+	assert.NoError(t, tpl.Do(ctx, map[string]string{"id": "2"}, nil, &user))
+	assert.Equal(t, int64(5), hits.Load())
+	a.InvalidateMemo("/users/{id}", nil)
+	assert.NoError(t, tpl.Do(ctx, map[string]string{"id": "1"}, nil, &user))
+	assert.NoError(t, a.Get(ctx, "/currencies", nil, &again, Memoize(time.Minute)))
+	assert.Equal(t, int64(6), hits.Load())
+	a.InvalidateMemo("/currencies", nil)
+	assert.NoError(t, a.Get(ctx, "/currencies", nil, &again, Memoize(time.Minute)))
+	assert.Equal(t, int64(7), hits.Load())
+
+	// Once full, the results expiring first are dropped.
+	a.SetMemoLimit(2)
+	for _, id := range []string{"3", "4"} {
+		assert.NoError(t, tpl.Do(ctx, map[string]string{"id": id}, nil, &user))
+	}
+	assert.Len(t, a.memo.entries, 2)
+	assert.Equal(t, int64(9), hits.Load())
+
+	// Without Memoize, every call is sent.
+	assert.NoError(t, a.Get(ctx, "/currencies", nil, &again))
+	assert.Equal(t, int64(10), hits.Load())
+}
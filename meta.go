@@ -0,0 +1,250 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResponseMeta describes a completed call beyond its decoded body.
+type ResponseMeta struct {
+	// StatusCode is the HTTP status code of the final response.
+	StatusCode int
+	// Header is the header of the final response.
+	Header http.Header
+	// URL is the final URL after following redirects.
+	URL *url.URL
+	// Warnings holds entries parsed from the Warning headers.
+	Warnings []Warning
+	// TotalCount is the value of X-Total-Count, or -1 if the response doesn't have it.
+	TotalCount int64
+	// Date is the value of the Date header, zero if missing or malformed.
+	Date time.Time
+	// ClockSkew is the difference between the server Date and the local clock when the
+	// response was received, positive if the server clock is ahead. Since Date has a
+	// resolution of one second, so does the skew.
+	ClockSkew time.Duration
+	// Duration is the time from sending the request until the response headers arrived.
+	Duration time.Duration
+	// Retries is the number of retries made before the final response was obtained, or before
+	// the call failed for a stale answer of SetStaleIfError.
+	Retries int
+	// ConnReused reports whether the final response was served over a reused connection.
+	ConnReused bool
+	// Hedges is the number of duplicate requests sent because of WithHedging.
+	Hedges int
+	// Cached reports whether the response was answered by the cache of SetCache, either fresh
+	// or after a 304 Not Modified revalidation.
+	Cached bool
+	// Stale reports whether the response is a stale answer served by SetStaleIfError,
+	// or by SetCache within a stale-while-revalidate window.
+	Stale bool
+	// Age is the age of a stale or fresh cached answer, computed from when it was received, or the
+	// value of the Age header of a response from the network, set by a cache along the way.
+	Age time.Duration
+	// Provenance tells where the response came from.
+	Provenance Provenance
+	// NoContent is set by the decoding helpers, like DoJSON, when the response has nothing to
+	// decode: it's a 204 No Content, or its body is empty or only whitespace.
+	NoContent bool
+	// Continue is the outcome of the Expect: 100-continue of the final request, see ExpectContinue.
+	Continue ContinueStatus
+	// TLS is what was negotiated on the connection of the final response, nil over plain HTTP.
+	TLS *TLSInfo
+	// RequestSize is the size of the body of the final request, counted as it was sent for the
+	// bodies streamed without a known length. See Api.MaxRequestBytes.
+	RequestSize int64
+	// TunneledStatus is the status found in the body of the final response by SetStatusTunnel,
+	// zero if none was.
+	TunneledStatus int
+	// Flags are the feature flags of BindFlag that were on for the final request, sorted.
+	Flags []string
+	// IdempotencyKey is the idempotency key of the final request, see IdempotencyFrom.
+	IdempotencyKey string
+	// ContentLanguage is the Content-Language of the final response.
+	ContentLanguage string
+	// Variant holds the values of the request headers named by the Vary header of the final
+	// response, the ones that selected its representation, e.g. Accept-Language; nil if it
+	// doesn't vary.
+	Variant map[string]string
+}
+
+// Provenance is where the response of a call came from, see ResponseMeta.
+type Provenance int
+
+const (
+	// ProvenanceNetwork is a response received from the server.
+	ProvenanceNetwork Provenance = iota
+	// ProvenanceRevalidated is a response of the cache of SetCache, revalidated by the server
+	// with a 304 Not Modified.
+	ProvenanceRevalidated
+	// ProvenanceCache is a fresh response of the cache of SetCache.
+	ProvenanceCache
+	// ProvenanceCacheStale is a stale response of the cache of SetCache, served within its
+	// stale-while-revalidate window.
+	ProvenanceCacheStale
+	// ProvenanceStaleIfError is a stale response of SetStaleIfError, served since the call failed.
+	ProvenanceStaleIfError
+	// ProvenanceMemoized is a response kept by Memoize.
+	ProvenanceMemoized
+)
+
+func (p Provenance) String() string {
+	switch p {
+	case ProvenanceRevalidated:
+		return "revalidated"
+	case ProvenanceCache:
+		return "cache"
+	case ProvenanceCacheStale:
+		return "cache-stale"
+	case ProvenanceStaleIfError:
+		return "stale-if-error"
+	case ProvenanceMemoized:
+		return "memoized"
+	default:
+		return "network"
+	}
+}
+
+// Warning is a single entry of a Warning header as defined by RFC 7234.
+type Warning struct {
+	Code  int
+	Agent string
+	Text  string
+	// Date is the optional warn-date, zero if omitted.
+	Date time.Time
+}
+
+// WithMeta makes the call fill meta when the response arrives, regardless of its status code.
+func WithMeta(meta *ResponseMeta) Option {
+	return func(c *call) {
+		c.meta = meta
+	}
+}
+
+func (m *ResponseMeta) fill(resp *http.Response, sent, received time.Time) {
+	m.StatusCode = resp.StatusCode
+	m.TunneledStatus = 0
+	m.Header = resp.Header
+	if resp.Request != nil {
+		m.URL = resp.Request.URL
+	}
+	m.Warnings = parseWarnings(resp.Header)
+	m.TotalCount = -1
+	if v := resp.Header.Get("X-Total-Count"); v != "" {
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			m.TotalCount = n
+		}
+	}
+	m.Date, m.ClockSkew = time.Time{}, 0
+	if d, err := ParseHTTPDate(resp.Header.Get("Date")); err == nil {
+		m.Date = d
+		m.ClockSkew = d.Sub(received.Truncate(time.Second))
+	}
+	m.Duration = received.Sub(sent)
+	m.TLS = newTLSInfo(resp.TLS)
+	m.Stale, m.Age, m.Cached = false, 0, false
+	if v := resp.Header.Get("Age"); v != "" {
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && n >= 0 {
+			m.Age = time.Duration(n) * time.Second
+		}
+	}
+	m.Provenance = ProvenanceNetwork
+	m.ContentLanguage, m.Variant = resp.Header.Get("Content-Language"), nil
+	if resp.Request != nil {
+		m.Variant, _ = varyOf(resp.Request, resp.Header)
+	}
+	m.NoContent = false
+	m.Continue = ContinueNone
+}
+
+// stored sets the fields of a kept response, received at received: its age, its provenance and
+// the clock skew of its Date at the time.
+func (m *ResponseMeta) stored(p Provenance, received time.Time, age time.Duration) {
+	m.Provenance, m.Age = p, age
+	m.Cached = p == ProvenanceCache || p == ProvenanceRevalidated || p == ProvenanceMemoized
+	m.Stale = p == ProvenanceCacheStale || p == ProvenanceStaleIfError
+	if !m.Date.IsZero() {
+		m.ClockSkew = m.Date.Sub(received.Truncate(time.Second))
+	}
+}
+
+// parseWarnings parses all Warning headers, skipping malformed entries.
+func parseWarnings(h http.Header) []Warning {
+	var warnings []Warning
+	for _, v := range h.Values("Warning") {
+		for v != "" {
+			var w Warning
+			var ok bool
+			if w, v, ok = parseWarning(v); ok {
+				warnings = append(warnings, w)
+			}
+		}
+	}
+	return warnings
+}
+
+// parseWarning parses the first warning-value of s and returns the rest of s.
+// If the entry is malformed, it's skipped up to the next comma.
+func parseWarning(s string) (w Warning, rest string, ok bool) {
+	s = strings.TrimLeft(s, " \t,")
+	skip := func() string {
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			return s[i+1:]
+		}
+		return ""
+	}
+	if len(s) < 4 || s[3] != ' ' {
+		return w, skip(), false
+	}
+	code, err := strconv.Atoi(s[:3])
+	if err != nil {
+		return w, skip(), false
+	}
+	w.Code = code
+	s = strings.TrimLeft(s[4:], " ")
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return w, skip(), false
+	}
+	w.Agent, s = s[:i], strings.TrimLeft(s[i:], " ")
+	text, s, ok := unquote(s)
+	if !ok {
+		return w, skip(), false
+	}
+	w.Text = text
+	s = strings.TrimLeft(s, " ")
+	if strings.HasPrefix(s, `"`) {
+		date, tail, ok := unquote(s)
+		if !ok {
+			return w, skip(), false
+		}
+		w.Date, _ = ParseHTTPDate(date)
+		s = tail
+	}
+	return w, s, true
+}
+
+// unquote reads a quoted-string from the beginning of s, handling backslash escapes.
+func unquote(s string) (value, rest string, ok bool) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", s, false
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], true
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", s, false
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestResponseMeta(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/items", http.StatusFound)
+			return
+		}
+		w.Header().Set("X-Total-Count", "42")
+		w.Header().Add("Warning", `299 api.example.com "Deprecated, use /v2/items"`)
+		w.Header().Set("Date", "Wed, 01 Jan 2020 01:00:00 GMT")
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	a.SetClock(clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	var meta ResponseMeta
+	var out []int
+	err := a.DoJSON(context.Background(), GET, "/old", nil, &out, WithMeta(&meta))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, meta.StatusCode)
+	assert.Equal(t, int64(42), meta.TotalCount)
+	assert.Equal(t, 0, meta.Retries)
+	assert.Equal(t, srv.URL+"/items", meta.URL.String())
+	assert.Equal(t, []Warning{{Code: 299, Agent: "api.example.com", Text: "Deprecated, use /v2/items"}}, meta.Warnings)
+	assert.Equal(t, time.Hour, meta.ClockSkew)
+	assert.Equal(t, time.Duration(0), meta.Duration)
+
+	err = a.DoJSON(context.Background(), GET, "/items", nil, &out, WithMeta(&meta))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, meta.ConnReused)
+}
+
+func TestResponseMetaNoTotal(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var meta ResponseMeta
+	err := a.DoJSON(context.Background(), GET, "/", nil, nil, WithMeta(&meta))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, meta.StatusCode)
+	assert.Equal(t, int64(-1), meta.TotalCount)
+}
+
+func TestParseWarnings(t *testing.T) {
+	h := http.Header{}
+	h.Add("Warning", `110 anderson/1.3.37 "Response is stale", 112 - "cache down" "Wed, 21 Oct 2015 07:28:00 GMT"`)
+	h.Add("Warning", `garbage, 199 proxy:8080 "say \"hi\""`)
+	date := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	assert.Equal(t, []Warning{
+		{Code: 110, Agent: "anderson/1.3.37", Text: "Response is stale"},
+		{Code: 112, Agent: "-", Text: "cache down", Date: date},
+		{Code: 199, Agent: "proxy:8080", Text: `say "hi"`},
+	}, parseWarnings(h))
+}
+
+func TestResponseMetaProvenance(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var flaky atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server clock is 90s ahead.
+		w.Header().Set("Date", clk.Now().Add(90*time.Second).UTC().Format(http.TimeFormat))
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/swr":
+			w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/flaky":
+			if flaky.Add(1) > 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/aged":
+			w.Header().Set("Age", "30")
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetClock(clk)
+	a.SetCache(&CachePolicy{})
+	a.SetStaleIfError(time.Minute)
+	ctx := context.Background()
+
+	get := func(resource string, opts ...Option) ResponseMeta {
+		var meta ResponseMeta
+		assert.NoError(t, a.Get(ctx, resource, nil, nil, append(opts, WithMeta(&meta))...), resource)
+		return meta
+	}
+	for _, resource := range []string{"/fresh", "/swr", "/etag", "/flaky"} {
+		meta := get(resource)
+		assert.Equal(t, ProvenanceNetwork, meta.Provenance, resource)
+		assert.Equal(t, 90*time.Second, meta.ClockSkew, resource)
+		assert.Zero(t, meta.Age, resource)
+	}
+	memo := get("/memo", Memoize(time.Minute))
+	assert.Equal(t, ProvenanceNetwork, memo.Provenance)
+	clk.Advance(5 * time.Second)
+
+	for resource, want := range map[string]struct {
+		provenance    Provenance
+		cached, stale bool
+		age           time.Duration
+	}{
+		"/fresh": {ProvenanceCache, true, false, 5 * time.Second},
+		"/swr":   {ProvenanceCacheStale, false, true, 5 * time.Second},
+		"/etag":  {ProvenanceRevalidated, true, false, 0},
+		"/flaky": {ProvenanceStaleIfError, false, true, 5 * time.Second},
+	} {
+		meta := get(resource)
+		assert.Equal(t, want.provenance, meta.Provenance, resource)
+		assert.Equal(t, want.cached, meta.Cached, resource)
+		assert.Equal(t, want.stale, meta.Stale, resource)
+		assert.Equal(t, want.age, meta.Age, resource)
+		// The skew is the one of when the response was received.
+		assert.Equal(t, 90*time.Second, meta.ClockSkew, resource)
+		assert.Equal(t, 0, meta.Retries, resource)
+	}
+
+	memo = get("/memo", Memoize(time.Minute))
+	assert.Equal(t, ProvenanceMemoized, memo.Provenance)
+	assert.Equal(t, "memoized", memo.Provenance.String())
+	assert.True(t, memo.Cached)
+	assert.Equal(t, 5*time.Second, memo.Age)
+	assert.Equal(t, 90*time.Second, memo.ClockSkew)
+
+	// The age set by a cache along the way is reported for the network responses.
+	aged := get("/aged")
+	assert.Equal(t, ProvenanceNetwork, aged.Provenance)
+	assert.Equal(t, 30*time.Second, aged.Age)
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MigrationPolicy configures the endpoint migrations of Migrate, see SetMigrationPolicy.
+type MigrationPolicy struct {
+	// Notify makes the rewrites reported to the CallLogger of the Api if it's a DeprecationLogger,
+	// along with the stack of the calling code, once per NotifyEvery for each migration.
+	Notify bool
+	// NotifyEvery is the time between the notices of a migration, a minute if zero.
+	NotifyEvery time.Duration
+	// Learn makes the permanent redirects (301 and 308) of a resource to another one on the same
+	// scheme and host, under the base path, migrations for the life of the Api.
+	Learn bool
+}
+
+// MigrationStat is the use of a migration reported by MigrationReport.
+type MigrationStat struct {
+	From string
+	To   string
+	// Learned is set for the migrations learned from permanent redirects, see MigrationPolicy.Learn.
+	// Their From and To are resources rather than templates.
+	Learned  bool
+	Rewrites int64
+}
+
+// migrations are the migrations of an Api, replaced as a whole when they change.
+type migrations struct {
+	policy MigrationPolicy
+	rules  []*migration
+	// learned are the migrations learned from redirects, by the cleaned resource they're from.
+	learned map[string]*migration
+}
+
+type migration struct {
+	from, to string
+	learned  bool
+	rewrites atomic.Int64
+
+	mu         sync.Mutex
+	notifiedAt time.Time
+	suppressed int64
+}
+
+// Migrate rewrites the resources matching from, a template like "/v1/users/{id}", into the
+// template to when the requests are created, so an endpoint moved by the vendor is followed
+// from a single place rather than its call sites:
+//
+//	a.Migrate("/v1/users/{id}", "/v2/users/{id}")
+//
+// The parameters of from are substituted into to, which mustn't have others, and the query is
+// kept as it is. It applies to the request constructors and the Templates alike, and the calls
+// are still tracked, logged and checked against the declarations with the resource they were
+// made for. The migrations are matched in the order they were declared; calling it again with
+// the same from replaces its target. MigrationReport counts the rewrites, and SetMigrationPolicy
+// configures the notices and the migrations learned from redirects.
+func (a *Api) Migrate(from, to string) error {
+	_, fromNames := splitParams(from)
+	_, toNames := splitParams(to)
+	for _, name := range toNames {
+		found := false
+		for _, n := range fromNames {
+			found = found || n == name
+		}
+		if !found {
+			return fmt.Errorf("api: migration of %s to %s: no parameter %q in %s", from, to, name, from)
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ms := a.migrations.Load().clone()
+	m := &migration{from: from, to: to}
+	for i, r := range ms.rules {
+		if r.from == from {
+			ms.rules[i], m = m, nil
+			break
+		}
+	}
+	if m != nil {
+		ms.rules = append(ms.rules, m)
+	}
+	a.migrations.Store(ms)
+	return nil
+}
+
+// SetMigrationPolicy sets the policy of the migrations, none of its features by default.
+func (a *Api) SetMigrationPolicy(p MigrationPolicy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ms := a.migrations.Load().clone()
+	ms.policy = p
+	a.migrations.Store(ms)
+}
+
+// MigrationReport returns the migrations with their number of rewrites, those of Migrate in the
+// order they were declared, then those learned from redirects, sorted by resource.
+func (a *Api) MigrationReport() []MigrationStat {
+	ms := a.migrations.Load()
+	if ms == nil {
+		return nil
+	}
+	var stats []MigrationStat
+	for _, m := range ms.rules {
+		stats = append(stats, m.stat())
+	}
+	learned := make([]MigrationStat, 0, len(ms.learned))
+	for _, m := range ms.learned {
+		learned = append(learned, m.stat())
+	}
+	sort.Slice(learned, func(i, j int) bool { return learned[i].From < learned[j].From })
+	return append(stats, learned...)
+}
+
+func (m *migration) stat() MigrationStat {
+	return MigrationStat{From: m.from, To: m.to, Learned: m.learned, Rewrites: m.rewrites.Load()}
+}
+
+// clone returns a copy of ms to modify, an empty one for nil.
+func (ms *migrations) clone() *migrations {
+	c := &migrations{}
+	if ms != nil {
+		c.policy = ms.policy
+		c.rules = append(c.rules, ms.rules...)
+		c.learned = ms.learned
+	}
+	return c
+}
+
+// rewrite returns resource rewritten into the target of m, if it matches its template.
+func (m *migration) rewrite(resource string) (string, bool) {
+	ts := strings.Split(strings.Trim(m.from, "/"), "/")
+	rs := strings.Split(strings.Trim(resource, "/"), "/")
+	if len(ts) != len(rs) {
+		return "", false
+	}
+	var values map[string]string
+	for i, seg := range ts {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if rs[i] == "" {
+				return "", false
+			}
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[seg[1:len(seg)-1]] = rs[i]
+		} else if seg != rs[i] {
+			return "", false
+		}
+	}
+	lits, names := splitParams(m.to)
+	vs := make([]string, len(names))
+	for i, name := range names {
+		vs[i] = values[name]
+	}
+	return substitute(lits, vs), true
+}
+
+// migrate returns resource rewritten by the migrations of the Api, counting the rewrites.
+func (a *Api) migrate(resource string) string {
+	ms := a.migrations.Load()
+	if ms == nil {
+		return resource
+	}
+	for _, m := range ms.rules {
+		if to, ok := m.rewrite(resource); ok {
+			a.rewritten(ms, m)
+			resource = to
+			break
+		}
+	}
+	if len(ms.learned) > 0 {
+		if m := ms.learned[path.Clean("/"+resource)]; m != nil {
+			a.rewritten(ms, m)
+			resource = m.to
+		}
+	}
+	return resource
+}
+
+// rewritten counts a rewrite of m, notifying it if the policy of ms says so.
+func (a *Api) rewritten(ms *migrations, m *migration) {
+	calls := m.rewrites.Add(1)
+	if !ms.policy.Notify {
+		return
+	}
+	l, ok := a.callLogger().(DeprecationLogger)
+	if !ok {
+		return
+	}
+	now := a.clock().Now()
+	m.mu.Lock()
+	notify := m.notifiedAt.IsZero() || now.Sub(m.notifiedAt) >= durationOr(ms.policy.NotifyEvery, time.Minute)
+	suppressed := m.suppressed
+	if notify {
+		m.notifiedAt, m.suppressed = now, 0
+	} else {
+		m.suppressed++
+	}
+	m.mu.Unlock()
+	if notify {
+		l.LogDeprecated(context.Background(), DeprecatedUse{Resource: m.from, Message: "migrated to " + m.to,
+			Calls: calls, Suppressed: suppressed, Stack: callerStack()})
+	}
+}
+
+// learnMigration learns the migration of the resource of req from the permanent redirects
+// resp followed, if the policy says so.
+func (a *Api) learnMigration(req *http.Request, resp *http.Response) {
+	ms := a.migrations.Load()
+	if ms == nil || !ms.policy.Learn || resp.Request == nil || resp.Request.Response == nil {
+		return
+	}
+	for r := resp.Request; r.Response != nil; r = r.Response.Request {
+		if s := r.Response.StatusCode; s != http.StatusMovedPermanently && s != http.StatusPermanentRedirect {
+			return
+		}
+		if r.URL.Scheme != req.URL.Scheme || r.URL.Host != req.URL.Host {
+			return
+		}
+	}
+	prefix := strings.TrimSuffix(a.baseURI().Path, "/")
+	if seg := a.currentVersion().segment; seg != "" {
+		prefix += "/" + seg
+	}
+	from, to := req.URL.Path, resp.Request.URL.Path
+	if !strings.HasPrefix(from, prefix+"/") || !strings.HasPrefix(to, prefix+"/") {
+		return
+	}
+	from, to = path.Clean(from[len(prefix):]), to[len(prefix):]
+	if from == path.Clean(to) {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ms = a.migrations.Load().clone()
+	learned := make(map[string]*migration, len(ms.learned)+1)
+	for k, m := range ms.learned {
+		learned[k] = m
+	}
+	if m := learned[from]; m != nil && m.to == to {
+		return
+	}
+	learned[from] = &migration{from: from, to: to, learned: true}
+	ms.learned = learned
+	a.migrations.Store(ms)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestMigrate(t *testing.T) {
+	a := MustNew("http://api.example.com/api/")
+	assert.NoError(t, a.Migrate("/v1/users/{id}/orders/{order}", "/v2/orders/{order}/by-user/{id}"))
+	assert.NoError(t, a.Migrate("/v1/users/{id}", "/v2/users/{id}"))
+	assert.EqualError(t, a.Migrate("/v1/items/{id}", "/v2/items/{item}"),
+		`api: migration of /v1/items/{id} to /v2/items/{item}: no parameter "item" in /v1/items/{id}`)
+
+	req, err := a.Request(GET, "/v1/users/7/orders/9", url.Values{"expand": {"lines"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "http://api.example.com/api/v2/orders/9/by-user/7?expand=lines", req.URL.String())
+	req, _ = a.Request(GET, "v1/users/7", nil)
+	assert.Equal(t, "http://api.example.com/api/v2/users/7", req.URL.String())
+	req, _ = a.Request(GET, "/v1/users", nil)
+	assert.Equal(t, "http://api.example.com/api/v1/users", req.URL.String())
+
+	// The templates are rewritten alike, whatever their parameters.
+	users := a.Template(GET, "/v1/users/{id}")
+	for id, want := range map[string]string{"8": "/api/v2/users/8", "a/b": "/api/v2/users/a%2Fb"} {
+		req, err = users.Build(map[string]string{"id": id}, url.Values{"q": {"1"}})
+		if assert.NoError(t, err) {
+			assert.Equal(t, want, req.URL.EscapedPath())
+			assert.Equal(t, "q=1", req.URL.RawQuery)
+		}
+	}
+	req, _ = a.Template(GET, "/v1/users/{id}/orders/{order}").Build(map[string]string{"id": "1", "order": "2"}, nil)
+	assert.Equal(t, "/api/v2/orders/2/by-user/1", req.URL.Path)
+
+	// Migrating the same template again replaces its target.
+	assert.NoError(t, a.Migrate("/v1/users/{id}", "/v3/users/{id}"))
+	req, _ = a.Request(GET, "/v1/users/7", nil)
+	assert.Equal(t, "/api/v3/users/7", req.URL.Path)
+	assert.Equal(t, []MigrationStat{
+		{From: "/v1/users/{id}/orders/{order}", To: "/v2/orders/{order}/by-user/{id}", Rewrites: 2},
+		{From: "/v1/users/{id}", To: "/v3/users/{id}", Rewrites: 1},
+	}, a.MigrationReport())
+}
+
+func TestMigrateNotices(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a.SetClock(clk)
+	logs := &deprecationLog{}
+	a.SetCallLogger(logs)
+	a.Migrate("/v1/users/{id}", "/v2/users/{id}")
+	ctx := context.Background()
+
+	// Without Notify, the rewrites are only counted.
+	assert.NoError(t, a.Get(ctx, "/v1/users/1", nil, nil))
+	assert.Empty(t, logs.uses)
+
+	a.SetMigrationPolicy(MigrationPolicy{Notify: true, NotifyEvery: time.Hour})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Get(ctx, "/v1/users/1", nil, nil))
+	}
+	clk.Advance(time.Hour)
+	assert.NoError(t, a.Template(GET, "/v1/users/{id}").Do(ctx, map[string]string{"id": "2"}, nil, nil))
+	assert.Equal(t, []string{"/v2/users/1", "/v2/users/1", "/v2/users/1", "/v2/users/1", "/v2/users/2"}, paths)
+	if !assert.Len(t, logs.uses, 2) {
+		return
+	}
+	assert.Equal(t, DeprecatedUse{Resource: "/v1/users/{id}", Message: "migrated to /v2/users/{id}", Calls: 2, Stack: logs.uses[0].Stack}, logs.uses[0])
+	assert.Equal(t, int64(5), logs.uses[1].Calls)
+	assert.Equal(t, int64(2), logs.uses[1].Suppressed)
+	// The notices point at the calling code.
+	if assert.NotEmpty(t, logs.uses[0].Stack) {
+		assert.Contains(t, logs.uses[0].Stack[0], "migrate_test.go")
+	}
+}
+
+func TestMigrateLearned(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/v1/things/"):
+			to := &url.URL{Path: "/api/v2/" + strings.TrimPrefix(r.URL.Path, "/api/v1/"), RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, to.String(), http.StatusPermanentRedirect)
+		case r.URL.Path == "/api/v1/moved":
+			http.Redirect(w, r, "/api/v2/moved", http.StatusMovedPermanently)
+		case r.URL.Path == "/api/v1/found":
+			http.Redirect(w, r, "/api/v2/found", http.StatusFound)
+		case r.URL.Path == "/api/outside":
+			http.Redirect(w, r, "/elsewhere", http.StatusPermanentRedirect)
+		}
+	}))
+	defer srv.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, srv.URL+"/api/v2/remote", http.StatusPermanentRedirect)
+	}))
+	defer other.Close()
+	a := MustNew(srv.URL + "/api")
+	ctx := context.Background()
+	sent := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		r := requests
+		requests = nil
+		return r
+	}
+
+	// The redirects aren't learned by default.
+	assert.NoError(t, a.Get(ctx, "/v1/things/1", url.Values{"a": {"1"}}, nil))
+	assert.NoError(t, a.Get(ctx, "/v1/things/1", url.Values{"a": {"1"}}, nil))
+	assert.Equal(t, []string{"GET /api/v1/things/1?a=1", "GET /api/v2/things/1?a=1", "GET /api/v1/things/1?a=1", "GET /api/v2/things/1?a=1"}, sent())
+	assert.Nil(t, a.MigrationReport())
+
+	a.SetMigrationPolicy(MigrationPolicy{Learn: true})
+	for _, resource := range []string{"/v1/things/1", "/v1/moved", "/v1/found", "/outside"} {
+		assert.NoError(t, a.Get(ctx, resource, url.Values{"a": {"1"}}, nil))
+	}
+	sent()
+	assert.NoError(t, a.Get(ctx, "/v1/things/1", url.Values{"a": {"2"}}, nil))
+	assert.NoError(t, a.Get(ctx, "v1/moved", nil, nil))
+	assert.NoError(t, a.Get(ctx, "/v1/things/2", nil, nil))
+	assert.NoError(t, a.Get(ctx, "/v1/found", nil, nil))
+	assert.Equal(t, []string{"GET /api/v2/things/1?a=2", "GET /api/v2/moved", "GET /api/v1/things/2", "GET /api/v2/things/2", "GET /api/v1/found", "GET /api/v2/found"}, sent())
+
+	// The redirects across hosts are never learned.
+	b := MustNew(other.URL + "/api")
+	b.SetMigrationPolicy(MigrationPolicy{Learn: true})
+	assert.NoError(t, b.Get(ctx, "/v1/remote", nil, nil))
+	assert.Empty(t, b.MigrationReport())
+
+	assert.Equal(t, []MigrationStat{
+		{From: "/v1/moved", To: "/v2/moved", Learned: true, Rewrites: 1},
+		{From: "/v1/things/1", To: "/v2/things/1", Learned: true, Rewrites: 1},
+		{From: "/v1/things/2", To: "/v2/things/2", Learned: true},
+	}, a.MigrationReport())
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MirrorPolicy mirrors a share of the calls of an Api to a secondary base URL, e.g. to compare
+// the responses of the next version of an API with the current one before migrating, see SetMirror.
+type MirrorPolicy struct {
+	// URL is the base URL of the mirror: the path of a request relative to the base URI of the Api
+	// is resolved relative to it.
+	URL string
+	// Rate is the share of the calls that are mirrored, from 0 to 1.
+	Rate float64
+	// Methods are the methods of the calls that may be mirrored, GET and HEAD if empty.
+	Methods []Method
+	// Timeout bounds each mirrored request, 10s if zero.
+	Timeout time.Duration
+	// Credentials rewrites the header of a mirrored request, e.g. to replace the Authorization
+	// with the credentials of the mirror. If nil, Authorization, Proxy-Authorization and Cookie
+	// are removed.
+	Credentials func(header http.Header)
+	// Compare is called with the response of the call and the one of the mirror once both are
+	// complete. It's called from the goroutine of the mirror.
+	Compare func(req *http.Request, primary, mirror *MirrorResult)
+	// MaxBody is the number of bytes of each body kept for Compare, 1MB if zero.
+	MaxBody int64
+	// Rand is the source of the sampling, the global source of math/rand if nil. Set it to a
+	// seeded source to get a deterministic sampling in tests.
+	Rand *rand.Rand
+}
+
+// MirrorResult is a response compared by MirrorPolicy.Compare.
+type MirrorResult struct {
+	// StatusCode is zero if the request failed with Err before a response arrived.
+	StatusCode int
+	Header     http.Header
+	// Body holds up to MirrorPolicy.MaxBody bytes of the body, Truncated reporting whether
+	// there were more. The body of the call is the part the caller read, or that of its
+	// *StatusError.
+	Body      []byte
+	Truncated bool
+	Err       error
+	// Latency is the time from sending the request until its response headers arrived.
+	Latency time.Duration
+}
+
+// SetMirror makes Do and the Do-style helpers send a copy of a share of their calls to the URL
+// of p once their response arrives, and hand both responses to p.Compare. The caller always
+// gets the response of the call: the mirrored requests are sent asynchronously with the client
+// of the Api, without its retries, hosts or shedding, and their failures only reach Compare.
+// Their bodies are replayed from GetBody, so the calls with a body that isn't replayable
+// aren't mirrored. A nil p stops the mirroring.
+func (a *Api) SetMirror(p *MirrorPolicy) error {
+	if p == nil {
+		a.mirror.Store(nil)
+		return nil
+	}
+	u, err := url.ParseRequestURI(p.URL)
+	if err != nil {
+		return fmt.Errorf("api: mirror %s: %w", p.URL, err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("api: mirror %s: not an absolute URL", p.URL)
+	}
+	if p.Rate < 0 || p.Rate > 1 {
+		return fmt.Errorf("api: mirror rate must be within 0 and 1, got %v", p.Rate)
+	}
+	m := &mirroring{policy: *p, base: u}
+	if len(m.policy.Methods) == 0 {
+		m.policy.Methods = []Method{GET, HEAD}
+	}
+	if m.policy.Timeout <= 0 {
+		m.policy.Timeout = 10 * time.Second
+	}
+	if m.policy.MaxBody <= 0 {
+		m.policy.MaxBody = 1 << 20
+	}
+	a.mirror.Store(m)
+	return nil
+}
+
+// mirroring is the state of a MirrorPolicy.
+type mirroring struct {
+	policy MirrorPolicy
+	base   *url.URL
+	mu     sync.Mutex
+}
+
+// mirrorFor returns the mirroring of the call of req and when it starts, nil if it isn't mirrored.
+func (a *Api) mirrorFor(req *http.Request) (*mirroring, time.Time) {
+	if m := a.mirror.Load(); m != nil && m.sample(req) {
+		return m, a.clock().Now()
+	}
+	return nil, time.Time{}
+}
+
+// sample reports whether the call of req is mirrored.
+func (m *mirroring) sample(req *http.Request) bool {
+	if !m.allows(req.Method) || !IsReplayable(req) {
+		return false
+	}
+	if m.policy.Rate >= 1 {
+		return true
+	}
+	if m.policy.Rand == nil {
+		return rand.Float64() < m.policy.Rate
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.policy.Rand.Float64() < m.policy.Rate
+}
+
+func (m *mirroring) allows(method string) bool {
+	for _, allowed := range m.policy.Methods {
+		if allowed.String() == method {
+			return true
+		}
+	}
+	return false
+}
+
+// startMirror sends the copy of req, whose call sent at sent got resp or err, and returns the
+// response to give the caller.
+func (a *Api) startMirror(m *mirroring, req *http.Request, sent time.Time, resp *http.Response, err error) *http.Response {
+	clk := a.clock()
+	primary := &MirrorResult{Latency: clk.Now().Sub(sent), Err: err}
+	done := make(chan struct{})
+	if resp != nil {
+		primary.StatusCode, primary.Header = resp.StatusCode, resp.Header
+		resp.Body = &mirrorBody{ReadCloser: resp.Body, result: primary, max: m.policy.MaxBody, done: done}
+	} else {
+		var se *StatusError
+		if errors.As(err, &se) {
+			primary.StatusCode, primary.Header, primary.Body = se.Code, se.Header, se.Body
+			primary.Truncated = se.Size < 0 || se.Size > int64(len(se.Body))
+		}
+		close(done)
+	}
+	mreq, ok := m.request(a.baseURI(), req)
+	if !ok {
+		return resp
+	}
+	client := a.baseClient()
+	a.goBackground(context.Background(), func(bg context.Context) {
+		ctx, cancel := context.WithTimeout(bg, m.policy.Timeout)
+		defer cancel()
+		mreq = mreq.WithContext(ctx)
+		mirror := &MirrorResult{}
+		start := clk.Now()
+		mresp, err := client.Do(mreq)
+		mirror.Latency, mirror.Err = clk.Now().Sub(start), err
+		if err == nil {
+			mirror.StatusCode, mirror.Header = mresp.StatusCode, mresp.Header
+			mirror.Body, mirror.Err = io.ReadAll(io.LimitReader(mresp.Body, m.policy.MaxBody+1))
+			if int64(len(mirror.Body)) > m.policy.MaxBody {
+				mirror.Body, mirror.Truncated = mirror.Body[:m.policy.MaxBody], true
+			}
+			drainClose(mresp.Body)
+		}
+		select {
+		case <-done:
+		case <-bg.Done():
+			// Closed while the primary body is still open: the comparison is dropped.
+			return
+		}
+		if m.policy.Compare != nil {
+			m.policy.Compare(mreq, primary, mirror)
+		}
+	})
+	return resp
+}
+
+// request returns the copy of req sent to the mirror, reporting whether req is a request for base.
+func (m *mirroring) request(base *url.URL, req *http.Request) (*http.Request, bool) {
+	u, ok := rebase(req.URL, base, m.base)
+	if !ok {
+		return nil, false
+	}
+	mreq := req.Clone(context.Background())
+	mreq.URL, mreq.Host = u, ""
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, false
+		}
+		mreq.Body = body
+	}
+	if m.policy.Credentials != nil {
+		m.policy.Credentials(mreq.Header)
+	} else {
+		for _, k := range []string{"Authorization", "Proxy-Authorization", "Cookie"} {
+			mreq.Header.Del(k)
+		}
+	}
+	return mreq, true
+}
+
+// mirrorBody keeps the bytes of the body of a mirrored call read by the caller.
+type mirrorBody struct {
+	io.ReadCloser
+	result *MirrorResult
+	buf    bytes.Buffer
+	max    int64
+	once   sync.Once
+	done   chan struct{}
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := b.max - int64(b.buf.Len()); int64(n) > room {
+		b.buf.Write(p[:room])
+		b.result.Truncated = true
+	} else {
+		b.buf.Write(p[:n])
+	}
+	if err != nil && err != io.EOF {
+		b.result.Err = err
+	}
+	return n, err
+}
+
+func (b *mirrorBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.result.Body = b.buf.Bytes()
+		close(b.done)
+	})
+	return err
+}
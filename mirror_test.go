@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetMirror(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version":1,"path":"` + r.URL.Path + `"}`))
+	}))
+	defer primary.Close()
+	var mu sync.Mutex
+	var mirrored []*http.Request
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		mirrored = append(mirrored, r)
+		mu.Unlock()
+		w.Write([]byte(`{"version":2,"path":"` + r.URL.Path + `"}`))
+	}))
+	defer secondary.Close()
+	a := MustNew(primary.URL + "/v1")
+	a.Header = http.Header{"Authorization": {"Bearer v1"}, "X-Trace": {"abc"}}
+	type comparison struct {
+		url             string
+		primary, mirror *MirrorResult
+	}
+	compared := make(chan comparison, 1)
+	err := a.SetMirror(&MirrorPolicy{
+		URL:  secondary.URL + "/v2/",
+		Rate: 1,
+		Compare: func(req *http.Request, primary, mirror *MirrorResult) {
+			compared <- comparison{url: req.URL.String(), primary: primary, mirror: mirror}
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx := context.Background()
+
+	var out struct {
+		Version int
+		Path    string
+	}
+	if !assert.NoError(t, a.DoJSON(ctx, GET, "/users", nil, &out)) {
+		return
+	}
+	assert.Equal(t, 1, out.Version)
+	assert.Equal(t, "/v1/users", out.Path)
+	c := <-compared
+	assert.Equal(t, secondary.URL+"/v2/users", c.url)
+	assert.Equal(t, http.StatusOK, c.primary.StatusCode)
+	assert.Equal(t, `{"version":1,"path":"/v1/users"}`, string(c.primary.Body))
+	assert.Equal(t, http.StatusOK, c.mirror.StatusCode)
+	assert.Equal(t, `{"version":2,"path":"/v2/users"}`, string(c.mirror.Body))
+	assert.NoError(t, c.mirror.Err)
+	mu.Lock()
+	assert.Empty(t, mirrored[0].Header.Get("Authorization"))
+	assert.Equal(t, "abc", mirrored[0].Header.Get("X-Trace"))
+	mu.Unlock()
+
+	// POST isn't mirrored by default.
+	assert.NoError(t, a.DoJSON(ctx, POST, "/users", nil, nil))
+	select {
+	case c := <-compared:
+		t.Fatalf("unexpected comparison of %s", c.url)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The credentials of the mirror replace those of the Api.
+	a.SetMirror(&MirrorPolicy{
+		URL:         secondary.URL,
+		Rate:        1,
+		Credentials: func(h http.Header) { h.Set("Authorization", "Bearer v2") },
+		Compare: func(req *http.Request, primary, mirror *MirrorResult) {
+			compared <- comparison{url: req.URL.String(), primary: primary, mirror: mirror}
+		},
+	})
+	req, err := a.Request(GET, "/items", url.Values{"page": {"2"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := a.Do(ctx, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	c = <-compared
+	assert.Equal(t, secondary.URL+"/items?page=2", c.url)
+	assert.Empty(t, c.primary.Body, "the caller didn't read the body")
+	mu.Lock()
+	assert.Equal(t, "Bearer v2", mirrored[1].Header.Get("Authorization"))
+	mu.Unlock()
+}
+
+func TestMirrorRate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	var mu sync.Mutex
+	var compared int
+	var wg sync.WaitGroup
+	a := MustNew(srv.URL)
+	a.SetMirror(&MirrorPolicy{
+		URL:  srv.URL,
+		Rate: 0.25,
+		Rand: rand.New(rand.NewSource(42)),
+		Compare: func(req *http.Request, primary, mirror *MirrorResult) {
+			mu.Lock()
+			compared++
+			mu.Unlock()
+			wg.Done()
+		},
+	})
+	expected := 0
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 200; i++ {
+		if rnd.Float64() < 0.25 {
+			expected++
+		}
+	}
+	wg.Add(expected)
+	for i := 0; i < 200; i++ {
+		assert.NoError(t, a.DoJSON(context.Background(), GET, "/", nil, nil))
+	}
+	wg.Wait()
+	assert.Equal(t, expected, compared)
+	assert.InDelta(t, 50, expected, 15)
+}
+
+func TestMirrorFailure(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer secondary.Close()
+	a := MustNew(primary.URL)
+	compared := make(chan [2]*MirrorResult, 1)
+	a.SetMirror(&MirrorPolicy{
+		URL:     secondary.URL,
+		Rate:    1,
+		Timeout: 20 * time.Millisecond,
+		Compare: func(req *http.Request, primary, mirror *MirrorResult) {
+			compared <- [2]*MirrorResult{primary, mirror}
+		},
+	})
+	start := time.Now()
+	err := a.DoJSON(context.Background(), GET, "/", nil, nil)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 200*time.Millisecond, "the mirror doesn't block the call")
+	c := <-compared
+	assert.Equal(t, http.StatusNotFound, c[0].StatusCode)
+	assert.Equal(t, "gone\n", string(c[0].Body))
+	assert.Error(t, c[1].Err)
+	assert.Zero(t, c[1].StatusCode)
+
+	assert.Error(t, a.SetMirror(&MirrorPolicy{URL: "/relative", Rate: 1}))
+	assert.Error(t, a.SetMirror(&MirrorPolicy{URL: secondary.URL, Rate: 2}))
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+// AuthStyle is how ClientCredentials authenticate the client to the token endpoint.
+type AuthStyle int
+
+const (
+	// AuthStyleHeader sends the client id and secret with HTTP Basic authentication.
+	AuthStyleHeader AuthStyle = iota
+	// AuthStyleForm sends them as the client_id and client_secret parameters of the form body.
+	AuthStyleForm
+)
+
+// ClientCredentials is a TokenSource obtaining tokens with the OAuth2 client_credentials grant
+// (RFC 6749, section 4.4). Tokens are cached and refreshed ExpirySkew before they expire;
+// concurrent callers needing a new token share a single token request. Use it by pointer:
+//
+//	a.SetTokenSource(&api.ClientCredentials{
+//		TokenURL:     "https://auth.example.com/oauth/token",
+//		ClientID:     id,
+//		ClientSecret: secret,
+//		Scopes:       []string{"orders:read"},
+//	})
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	AuthStyle    AuthStyle
+	// ExpirySkew is how long before its expiry a token is refreshed, 30s if zero. Tokens living less
+	// than twice the skew are refreshed halfway through their lifetime.
+	ExpirySkew time.Duration
+	// Client is the client of the token requests, http.DefaultClient if nil.
+	Client *http.Client
+	// Clock is the source of time used for expiries, the real time if nil.
+	Clock Clock
+
+	mu      sync.Mutex
+	token   *Token
+	renewAt time.Time
+	refresh *tokenRefresh
+}
+
+// tokenRefresh is a token request in progress, shared by the callers waiting for it.
+type tokenRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// TokenError is returned by ClientCredentials when the token endpoint refuses to issue a token.
+type TokenError struct {
+	// Code, Description and URI are the error, error_description and error_uri of the response.
+	Code        string
+	Description string
+	URI         string
+	// Status is the response the error was sent with.
+	Status *StatusError
+}
+
+func (e *TokenError) Error() string {
+	switch {
+	case e.Code == "":
+		return fmt.Sprintf("api: token endpoint: %s", e.Status.Status)
+	case e.Description == "":
+		return fmt.Sprintf("api: token endpoint: %s", e.Code)
+	}
+	return fmt.Sprintf("api: token endpoint: %s: %s", e.Code, e.Description)
+}
+
+func (e *TokenError) Unwrap() error {
+	if e.Status == nil {
+		return nil
+	}
+	return e.Status
+}
+
+// Token implements TokenSource.
+func (c *ClientCredentials) Token(ctx context.Context) (*Token, error) {
+	for {
+		c.mu.Lock()
+		if c.token != nil && (c.renewAt.IsZero() || c.now().Before(c.renewAt)) {
+			t := c.token
+			c.mu.Unlock()
+			return t, nil
+		}
+		if r := c.refresh; r != nil {
+			c.mu.Unlock()
+			select {
+			case <-r.done:
+				if r.err != nil {
+					return nil, r.err
+				}
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		r := &tokenRefresh{done: make(chan struct{})}
+		c.refresh = r
+		c.mu.Unlock()
+
+		t, renewAt, err := c.fetch(ctx)
+		c.mu.Lock()
+		if err == nil {
+			c.token, c.renewAt = t, renewAt
+		}
+		c.refresh, r.err = nil, err
+		c.mu.Unlock()
+		close(r.done)
+		return t, err
+	}
+}
+
+func (c *ClientCredentials) now() time.Time {
+	if c.Clock == nil {
+		return clock.Real{}.Now()
+	}
+	return c.Clock.Now()
+}
+
+// fetch requests a new token, returning it along with when it should be renewed.
+func (c *ClientCredentials) fetch(ctx context.Context) (*Token, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	if c.AuthStyle == AuthStyleForm {
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.AuthStyle == AuthStyleHeader {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	issued := c.now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var v struct {
+		AccessToken      string          `json:"access_token"`
+		TokenType        string          `json:"token_type"`
+		ExpiresIn        json.RawMessage `json:"expires_in"`
+		Error            string          `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+		ErrorURI         string          `json:"error_uri"`
+	}
+	jerr := json.Unmarshal(body, &v)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || v.Error != "" {
+		return nil, time.Time{}, &TokenError{
+			Code:        v.Error,
+			Description: v.ErrorDescription,
+			URI:         v.ErrorURI,
+			Status: &StatusError{
+				Code:   resp.StatusCode,
+				Status: resp.Status,
+				Header: resp.Header,
+				Body:   body,
+				Size:   resp.ContentLength,
+			},
+		}
+	}
+	if jerr != nil {
+		return nil, time.Time{}, fmt.Errorf("api: token endpoint: %w", jerr)
+	}
+	if v.AccessToken == "" {
+		return nil, time.Time{}, errors.New("api: token endpoint: no access_token in the response")
+	}
+	t := &Token{AccessToken: v.AccessToken, TokenType: v.TokenType}
+	var renewAt time.Time
+	// Some servers send expires_in as a string.
+	if s := strings.Trim(string(v.ExpiresIn), `"`); s != "" && s != "null" {
+		secs, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("api: token endpoint: invalid expires_in: %s", v.ExpiresIn)
+		}
+		lifetime := time.Duration(secs) * time.Second
+		skew := c.ExpirySkew
+		if skew <= 0 {
+			skew = 30 * time.Second
+		}
+		if skew > lifetime/2 {
+			skew = lifetime / 2
+		}
+		t.Expiry = issued.Add(lifetime)
+		renewAt = t.Expiry.Add(-skew)
+	}
+	return t, renewAt, nil
+}
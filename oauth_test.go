@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func newTokenEndpoint(t *testing.T, requests *atomic.Int32, delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		time.Sleep(delay)
+		id, secret, ok := r.BasicAuth()
+		if ok {
+			// The credentials are form-encoded before being sent with Basic authentication.
+			id, _ = url.QueryUnescape(id)
+			secret, _ = url.QueryUnescape(secret)
+		} else {
+			id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+		}
+		assert.Equal(t, "client_credentials", r.PostFormValue("grant_type"))
+		w.Header().Set("Content-Type", "application/json")
+		if id != "client id" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "invalid_client", "error_description": "Client authentication failed"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "bearer", "expires_in": 3600, "scope": %q}`, n, r.PostFormValue("scope"))
+	}))
+}
+
+func TestClientCredentialsRefresh(t *testing.T) {
+	var requests atomic.Int32
+	srv := newTokenEndpoint(t, &requests, 0)
+	defer srv.Close()
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := &ClientCredentials{
+		TokenURL:     srv.URL,
+		ClientID:     "client id",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"a", "b"},
+		ExpirySkew:   time.Minute,
+		Clock:        clk,
+	}
+	tok, err := ts.Token(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "token-1", tok.AccessToken)
+	assert.Equal(t, clk.Now().Add(time.Hour), tok.Expiry)
+
+	clk.Advance(58 * time.Minute)
+	tok, _ = ts.Token(context.Background())
+	assert.Equal(t, "token-1", tok.AccessToken)
+	// The token is refreshed ExpirySkew before it expires.
+	clk.Advance(time.Minute)
+	tok, _ = ts.Token(context.Background())
+	assert.Equal(t, "token-2", tok.AccessToken)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// The token of the source is sent by the requests of the Api.
+	a := MustNew("http://example.com")
+	a.Header = http.Header{"Authorization": {"Basic old"}}
+	a.SetTokenSource(ts)
+	req, err := a.Request(GET, "/", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Bearer token-2", req.Header.Get("Authorization"))
+	}
+	a.SetTokenSource(nil)
+	req, _ = a.Request(GET, "/", nil)
+	assert.Equal(t, "Basic old", req.Header.Get("Authorization"))
+
+	// Form authentication.
+	form := &ClientCredentials{TokenURL: srv.URL, ClientID: "client id", ClientSecret: "s3cret", AuthStyle: AuthStyleForm}
+	tok, err = form.Token(context.Background())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "token-3", tok.AccessToken)
+	}
+}
+
+func TestClientCredentialsSingleFlight(t *testing.T) {
+	var requests atomic.Int32
+	srv := newTokenEndpoint(t, &requests, 50*time.Millisecond)
+	defer srv.Close()
+	ts := &ClientCredentials{TokenURL: srv.URL, ClientID: "client id", ClientSecret: "s3cret"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tok, err := ts.Token(context.Background())
+			if assert.NoError(t, err) {
+				assert.Equal(t, "token-1", tok.AccessToken)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestClientCredentialsError(t *testing.T) {
+	var requests atomic.Int32
+	srv := newTokenEndpoint(t, &requests, 0)
+	defer srv.Close()
+	ts := &ClientCredentials{TokenURL: srv.URL, ClientID: "client id", ClientSecret: "wrong"}
+	_, err := ts.Token(context.Background())
+	assert.EqualError(t, err, "api: token endpoint: invalid_client: Client authentication failed")
+	var te *TokenError
+	if assert.True(t, errors.As(err, &te)) {
+		assert.Equal(t, "invalid_client", te.Code)
+		assert.Equal(t, http.StatusUnauthorized, te.Status.Code)
+	}
+	// Failures aren't cached.
+	_, err = ts.Token(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+
+	a := MustNew("http://example.com")
+	a.SetTokenSource(ts)
+	_, err = a.Request(GET, "/", nil)
+	assert.EqualError(t, err, "api: preparer api.TokenSource (priority -50): api: token endpoint: invalid_client: Client authentication failed")
+	assert.True(t, errors.As(err, &te))
+}
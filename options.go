@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// Option configures a single call made through Do or the Do-style helpers (DoJSON, DoNDJSON, etc.).
+// Options are applied in order, after the Api defaults set by SetDefaults, so later options win.
+// The request builders (Request, RequestBytes, etc.) accept options too, but only apply those
+// modifying the request itself, like WithHeader, WithQuery or UploadChecksum.
+type Option func(*call)
+
+// call holds the per-call settings assembled from options.
+type call struct {
+	prepare  []func(*http.Request) error
+	wrappers []func(*http.Response, io.ReadCloser) io.ReadCloser
+	resource string
+	meta     *ResponseMeta
+	locale   string
+	timeout  time.Duration
+	retry    *RetryPolicy
+	retrySet bool
+	strict   bool
+	// errorBody is the limit of the body kept in StatusError.
+	errorBody int
+	// ctx is the context passed to the preparers when the request is created.
+	ctx        context.Context
+	hedgeDelay time.Duration
+	hedgeMax   int
+	// hedges counts the duplicates sent by WithHedging.
+	hedges int
+	// avoid is the host of the HostPolicy the last attempt failed on.
+	avoid *poolHost
+	// cache is the state of SetCache, nil if the cache doesn't apply to the call.
+	cache *cacheCall
+	// attempt is the 0-based attempt of the call being made by send.
+	attempt int
+	// template is the resource of the Template the call is made from.
+	template string
+	// seeOther is set by FollowSeeOther.
+	seeOther bool
+	// trace is set by WithTrace.
+	trace *callTrace
+	// anyParams is set by AllowUndeclaredParams.
+	anyParams bool
+	// anyRules is set by SkipParamRules.
+	anyRules bool
+	// anyBody is set by SkipRequestValidation.
+	anyBody bool
+	// limits is set by WithResponseLimits.
+	limits *ResponseLimits
+	// errorType is the type of the prototype of ErrorInto.
+	errorType reflect.Type
+	priority  Priority
+	// memo is the ttl of Memoize.
+	memo time.Duration
+	// fields is the value of WithFields.
+	fields interface{}
+	// serializeKey is the key of WithSerializeKey.
+	serializeKey string
+	// queued is set once the call went through the write queue of SetWriteQueue.
+	queued bool
+	// conditions are the preconditions of ExpectVersion and ExpectField.
+	conditions []condition
+	// maxRequest is the limit of WithMaxRequestBytes.
+	maxRequest    int64
+	maxRequestSet bool
+	// taxonomy is the value of WithErrorTaxonomy.
+	taxonomy    bool
+	taxonomySet bool
+	// idempotencyKey is the derivation of IdempotencyFrom.
+	idempotencyKey func(ctx context.Context, req *http.Request) string
+	// sent counts the request body of the last attempt if its length isn't known.
+	sent *sizeBody
+	// trailer is set by TrailerChecksum.
+	trailer *trailerChecksum
+	// spool is set by SpoolToDisk.
+	spool *spool
+	// async is set for the calls of DoAsync.
+	async bool
+	// watchdog is set by WithWatchdog.
+	watchdog *watchdog
+	err      error
+}
+
+// SetDefaults sets the options applied to every call before its own options, and after the
+// shared options of the Registry of the Api, if any.
+func (a *Api) SetDefaults(opts ...Option) {
+	a.mu.Lock()
+	a.defaults = append([]Option(nil), opts...)
+	a.mu.Unlock()
+}
+
+func (a *Api) newCall(opts []Option) *call {
+	a.mu.Lock()
+	defaults := a.defaults
+	a.mu.Unlock()
+	c := &call{}
+	if a.registry != nil {
+		for _, opt := range a.registry.options() {
+			opt(c)
+		}
+	}
+	for _, opt := range defaults {
+		opt(c)
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// retryPolicy returns the retry policy of the call, the Api's one unless WithRetry was used.
+func (c *call) retryPolicy(a *Api) *RetryPolicy {
+	if c.retrySet {
+		return c.retry
+	}
+	return a.Retry
+}
+
+// shape applies the options modifying the request to req, for the request builders.
+// The Api defaults aren't applied, since they are applied when the request is sent.
+func (a *Api) shape(req *http.Request, opts []Option) error {
+	c := &call{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.err != nil {
+		return c.err
+	}
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := a.overrideRequestBase(ctx, req); err != nil {
+		return err
+	}
+	if err := a.encodeBody(req); err != nil {
+		return err
+	}
+	if err := a.prepare(ctx, req); err != nil {
+		return err
+	}
+	for _, prepare := range c.prepare {
+		if err := prepare(req); err != nil {
+			return err
+		}
+	}
+	return a.applyIdempotency(ctx, c, req)
+}
+
+// buildContext passes the context of a Do-style helper call to the preparers run by the request constructors.
+func buildContext(ctx context.Context) Option {
+	return func(c *call) {
+		c.ctx = ctx
+	}
+}
+
+// WithHeader sets the header key of the request to the given values, replacing the Api's Header.
+// Without values, the header is removed.
+func WithHeader(key string, values ...string) Option {
+	return func(c *call) {
+		c.prepare = append(c.prepare, func(req *http.Request) error {
+			if len(values) == 0 {
+				req.Header.Del(key)
+				return nil
+			}
+			req.Header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+			return nil
+		})
+	}
+}
+
+// WithQuery sets the query parameter key of the request URL to the given values.
+// Without values, the parameter is removed.
+func WithQuery(key string, values ...string) Option {
+	return func(c *call) {
+		c.prepare = append(c.prepare, func(req *http.Request) error {
+			setQuery(req, key, values)
+			return nil
+		})
+	}
+}
+
+// Variant selects the representation of the call, setting its Accept and Accept-Language headers
+// together, over those of the Api and its locale, e.g. Variant("application/json", "fr-CH"). An
+// empty value leaves its header as it is. See ResponseMeta.Variant for the one answered.
+func Variant(accept, lang string) Option {
+	return func(c *call) {
+		c.prepare = append(c.prepare, func(req *http.Request) error {
+			if accept != "" {
+				req.Header.Set("Accept", accept)
+			}
+			if lang != "" {
+				req.Header.Set("Accept-Language", lang)
+			}
+			return nil
+		})
+	}
+}
+
+// WithTimeout limits each attempt of the call to d, including reading the response body.
+// Retries get their own d each; use a context deadline to bound the call as a whole.
+// Zero removes a timeout set by the defaults, leaving the one of SetAdaptiveTimeout if any.
+func WithTimeout(d time.Duration) Option {
+	return func(c *call) {
+		c.timeout = d
+	}
+}
+
+// WithRetry makes the call use the retry policy p instead of the Api's Retry; nil disables retries.
+func WithRetry(p *RetryPolicy) Option {
+	return func(c *call) {
+		c.retry, c.retrySet = p, true
+	}
+}
+
+// WithDecodeStrict makes the JSON decoding of DoJSON, DoJSONArray and the helpers built
+// on them fail on fields that the destination doesn't have.
+func WithDecodeStrict() Option {
+	return func(c *call) {
+		c.strict = true
+	}
+}
+
+// WithErrorBody makes the call keep up to n bytes of the body of a failed response in StatusError,
+// instead of the default 64KB.
+func WithErrorBody(n int) Option {
+	return func(c *call) {
+		c.errorBody = n
+	}
+}
+
+// decoder creates a JSON decoder of r honoring WithDecodeStrict.
+func (c *call) decoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	if c.strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec
+}
+
+// fail records an error of an invalid option, reported when the call is made.
+func (c *call) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// wrapBody applies the response body wrappers in the order the options were given.
+func (c *call) wrapBody(resp *http.Response) io.ReadCloser {
+	body := resp.Body
+	for _, wrap := range c.wrappers {
+		body = wrap(resp, body)
+	}
+	return body
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionsPrecedence(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" && calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"header": r.Header.Get("X-Mode"),
+			"query":  r.URL.RawQuery,
+		})
+	}))
+	defer srv.Close()
+
+	type result struct {
+		Header string `json:"header"`
+		Query  string `json:"query"`
+	}
+	retry := &RetryPolicy{MaxRetries: 1}
+	for _, tc := range []struct {
+		name     string
+		header   http.Header
+		defaults []Option
+		opts     []Option
+		want     result
+		calls    int32
+		failing  bool
+	}{
+		{name: "none", want: result{Query: "q=x"}},
+		{name: "api header", header: http.Header{"X-Mode": {"api"}}, want: result{Header: "api", Query: "q=x"}},
+		{name: "default over api header", header: http.Header{"X-Mode": {"api"}},
+			defaults: []Option{WithHeader("X-Mode", "default")}, want: result{Header: "default", Query: "q=x"}},
+		{name: "call over default", defaults: []Option{WithHeader("X-Mode", "default")},
+			opts: []Option{WithHeader("X-Mode", "call")}, want: result{Header: "call", Query: "q=x"}},
+		{name: "later call wins", opts: []Option{WithHeader("X-Mode", "first"), WithHeader("x-mode", "second")},
+			want: result{Header: "second", Query: "q=x"}},
+		{name: "header removed", header: http.Header{"X-Mode": {"api"}}, opts: []Option{WithHeader("X-Mode")},
+			want: result{Query: "q=x"}},
+		{name: "query merged", defaults: []Option{WithQuery("a", "1")}, opts: []Option{WithQuery("b", "2")},
+			want: result{Query: "q=x&a=1&b=2"}},
+		{name: "query overridden", defaults: []Option{WithQuery("q", "default")}, opts: []Option{WithQuery("q", "y", "z")},
+			want: result{Query: "q=y&q=z"}},
+		{name: "query removed", opts: []Option{WithQuery("q")}, want: result{}},
+		{name: "no retry by default", opts: []Option{WithQuery("fail", "1")}, calls: 1, failing: true},
+		{name: "default retry", defaults: []Option{WithRetry(retry)}, opts: []Option{WithQuery("fail", "1")},
+			want: result{Query: "q=x&fail=1"}, calls: 2},
+		{name: "call disables retry", defaults: []Option{WithRetry(retry)}, opts: []Option{WithQuery("fail", "1"), WithRetry(nil)},
+			calls: 1, failing: true},
+	} {
+		calls.Store(0)
+		a := MustNew(srv.URL)
+		a.Header = tc.header
+		a.SetClock(fakeClock())
+		a.SetDefaults(tc.defaults...)
+
+		var out result
+		err := a.DoJSON(context.Background(), GET, "/", url.Values{"q": {"x"}}, &out, tc.opts...)
+		if tc.failing {
+			assert.Error(t, err, tc.name)
+		} else if assert.NoError(t, err, tc.name) {
+			assert.Equal(t, tc.want, out, tc.name)
+		}
+		if tc.calls > 0 {
+			assert.Equal(t, tc.calls, calls.Load(), tc.name)
+		}
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	a := MustNew(srv.URL)
+	a.SetDefaults(WithTimeout(time.Hour))
+	err := a.Get(context.Background(), "/", nil, nil, WithTimeout(20*time.Millisecond))
+	var te *TimeoutError
+	if assert.ErrorAs(t, err, &te) {
+		assert.InDelta(t, 20*time.Millisecond, te.Timeout, float64(time.Millisecond))
+	}
+}
+
+func TestWithDecodeStrict(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 1, "extra": true}`))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var out struct{ ID int }
+	assert.NoError(t, a.Get(context.Background(), "/", nil, &out))
+	assert.Error(t, a.Get(context.Background(), "/", nil, &out, WithDecodeStrict()))
+}
+
+func TestRequestOptions(t *testing.T) {
+	a := MustNew("http://example.com")
+	a.Header = http.Header{"X-Mode": {"api"}}
+	a.SetDefaults(WithHeader("X-Default", "1"))
+	req, err := a.Request(GET, "/items", url.Values{"a": {"1"}}, WithHeader("X-Mode", "call"), WithQuery("b", "2"), WithTimeout(time.Second))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "call", req.Header.Get("X-Mode"))
+	assert.Equal(t, "", req.Header.Get("X-Default"))
+	assert.Equal(t, "a=1&b=2", req.URL.RawQuery)
+
+	_, err = a.RequestBytes(POST, "/items", "text/plain", nil, Locale("not a tag"))
+	assert.Error(t, err)
+}
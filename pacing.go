@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pacing spaces the page fetches of List, so a walk spreads its requests over time rather than
+// fetching the pages as fast as possible and going idle, see SetListPacing. The zero value
+// doesn't pace.
+type Pacing struct {
+	// PagesPerMinute, if positive, is the rate of the page fetches: they start 1m/PagesPerMinute
+	// apart.
+	PagesPerMinute float64
+	// Delay, if positive, is the time between the starts of the page fetches, if longer than the
+	// one of PagesPerMinute.
+	Delay time.Duration
+	// Adaptive derives the time between the page fetches from the rate limit headers of the last
+	// page, to use the Budget share of the remaining requests spread until the reset, e.g. 50 pages
+	// a minute when 100 requests remain for the next minute and the Budget is 0.5. The delays of
+	// PagesPerMinute and Delay are then the shortest ones, and the only ones when a page doesn't
+	// have the headers.
+	Adaptive bool
+	// Budget is the share of the remaining requests a walk may use, 0.5 if zero.
+	Budget float64
+	// RemainingHeader is the header of the number of requests remaining, X-RateLimit-Remaining or
+	// else RateLimit-Remaining if empty.
+	RemainingHeader string
+	// ResetHeader is the header of when the rate limit is reset, a number of seconds or a Unix
+	// time, X-RateLimit-Reset or else RateLimit-Reset if empty.
+	ResetHeader string
+}
+
+// SetListPacing sets the pacing of the walks of List whose ListOptions.Pacing is nil. The first
+// page of a walk is fetched right away, and the next ones once its pacing allows, sleeping on the
+// clock of the Api until then or until the context of the walk is done. A nil p disables it.
+func (a *Api) SetListPacing(p *Pacing) {
+	if p == nil {
+		a.listPacing.Store(nil)
+		return
+	}
+	pacing := *p
+	a.listPacing.Store(&pacing)
+}
+
+// pacer paces a walk of List.
+type pacer struct {
+	p   *Pacing
+	clk Clock
+	// start is when the last page fetch started, and until when the next one may.
+	start, until time.Time
+}
+
+// pacerFor returns the pacer of a walk made with opts, nil if it isn't paced.
+func (a *Api) pacerFor(opts *ListOptions) *pacer {
+	p := opts.Pacing
+	if p == nil {
+		p = a.listPacing.Load()
+	}
+	if p == nil || p.interval() <= 0 && !p.Adaptive {
+		return nil
+	}
+	return &pacer{p: p, clk: a.clock()}
+}
+
+// interval returns the fixed time between the page fetches.
+func (p *Pacing) interval() time.Duration {
+	d := p.Delay
+	if p.PagesPerMinute > 0 {
+		if rate := time.Duration(float64(time.Minute) / p.PagesPerMinute); rate > d {
+			d = rate
+		}
+	}
+	return d
+}
+
+// wait waits until the next page can be fetched, and records the start of its fetch.
+func (p *pacer) wait(ctx context.Context) error {
+	if !p.until.IsZero() {
+		if d := p.until.Sub(p.clk.Now()); d > 0 {
+			if err := p.clk.Sleep(ctx, d); err != nil {
+				return err
+			}
+		}
+	}
+	p.start = p.clk.Now()
+	return nil
+}
+
+// fetched sets when the page following the one fetched with header may be.
+func (p *pacer) fetched(header http.Header) {
+	d := p.p.interval()
+	if p.p.Adaptive {
+		if adaptive, ok := p.p.adaptive(header, p.clk.Now()); ok && adaptive > d {
+			d = adaptive
+		}
+	}
+	p.until = p.start.Add(d)
+}
+
+// adaptive returns the time between the page fetches derived from the rate limit headers.
+func (p *Pacing) adaptive(header http.Header, now time.Time) (time.Duration, bool) {
+	remaining, ok := headerInt(header, p.RemainingHeader, "X-RateLimit-Remaining", "RateLimit-Remaining")
+	if !ok || remaining < 0 {
+		return 0, false
+	}
+	reset, ok := headerInt(header, p.ResetHeader, "X-RateLimit-Reset", "RateLimit-Reset")
+	if !ok || reset < 0 {
+		return 0, false
+	}
+	window := time.Duration(reset) * time.Second
+	// The values too large to be a number of seconds are Unix times.
+	if reset > 1e9 {
+		window = time.Unix(reset, 0).Sub(now)
+	}
+	if window <= 0 {
+		return 0, true
+	}
+	budget := p.Budget
+	if budget <= 0 {
+		budget = 0.5
+	}
+	allowed := float64(remaining) * budget
+	if allowed < 1 {
+		return window, true
+	}
+	return time.Duration(float64(window) / allowed), true
+}
+
+// headerInt returns the integer value of the header name, or of the first of defaults present
+// if name is empty.
+func headerInt(header http.Header, name string, defaults ...string) (int64, bool) {
+	names := defaults
+	if name != "" {
+		names = []string{name}
+	}
+	for _, name := range names {
+		if v := strings.TrimSpace(header.Get(name)); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+// pacedServer serves len(limits) pages of one item at /items, the page n carrying the rate limit
+// headers of limits[n-1], and records when each page was fetched on clk.
+type pacedServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	fetched []time.Time
+}
+
+func newPacedServer(clk *clock.Fake, limits [][2]string) *pacedServer {
+	s := &pacedServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.fetched = append(s.fetched, clk.Now())
+		s.mu.Unlock()
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		if page < len(limits) {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/items?page=%d>; rel="next"`, r.Host, page+1))
+		}
+		if l := limits[page-1]; l[0] != "" {
+			w.Header().Set("X-RateLimit-Remaining", l[0])
+			w.Header().Set("X-RateLimit-Reset", l[1])
+		}
+		fmt.Fprintf(w, `[{"id": %d}]`, page)
+	}))
+	return s
+}
+
+// delays returns the time between the page fetches.
+func (s *pacedServer) delays() []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var d []time.Duration
+	for i := 1; i < len(s.fetched); i++ {
+		d = append(d, s.fetched[i].Sub(s.fetched[i-1]))
+	}
+	return d
+}
+
+func walk(a *Api, opts *ListOptions) error {
+	return List(context.Background(), a, "/items", opts, func(it testItem) error { return nil })
+}
+
+func TestListPacing(t *testing.T) {
+	clk := fakeClock()
+	var s *pacedServer
+	run := func(opts *ListOptions) []time.Duration {
+		s = newPacedServer(clk, make([][2]string, 4))
+		defer s.Close()
+		a := MustNew(s.URL)
+		a.SetClock(clk)
+		a.SetListPacing(&Pacing{PagesPerMinute: 30})
+		if !assert.NoError(t, walk(a, opts)) {
+			return nil
+		}
+		return s.delays()
+	}
+	// The first page is fetched right away, the next ones 2s apart.
+	start := clk.Now()
+	assert.Equal(t, []time.Duration{2 * time.Second, 2 * time.Second, 2 * time.Second}, run(nil))
+	assert.Equal(t, start, s.fetched[0])
+
+	// The walks override it.
+	assert.Equal(t, []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second}, run(&ListOptions{Pacing: &Pacing{Delay: 5 * time.Second, PagesPerMinute: 60}}))
+	assert.Equal(t, []time.Duration{0, 0, 0}, run(&ListOptions{Pacing: &Pacing{}}))
+}
+
+func TestListPacingAdaptive(t *testing.T) {
+	clk := fakeClock()
+	reset := strconv.FormatInt(clk.Now().Add(2*time.Minute).Unix(), 10)
+	s := newPacedServer(clk, [][2]string{
+		{"100", "60"}, // half of 100 requests in 60s: 1.2s apart
+		{"10", "30"},  // 5 requests in 30s
+		{"", ""},      // no headers: the fixed 1s
+		{"0", "20"},   // none left: until the reset
+		{"4", reset},  // 2 requests in the 91.8s left until a Unix time 2m after the start
+		{"1", "10"},
+	})
+	defer s.Close()
+	a := MustNew(s.URL)
+	a.SetClock(clk)
+	assert.NoError(t, walk(a, &ListOptions{Pacing: &Pacing{Adaptive: true, Delay: time.Second}}))
+	assert.Equal(t, []time.Duration{1200 * time.Millisecond, 6 * time.Second, time.Second, 20 * time.Second, 45900 * time.Millisecond}, s.delays()[:5])
+
+	// The budget and the headers can be set.
+	s2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/items?page=2>; rel="next"`, r.Host))
+		}
+		w.Header().Set("Quota-Left", "40")
+		w.Header().Set("Quota-Reset", "60")
+		w.Write([]byte(`[]`))
+	}))
+	defer s2.Close()
+	b := MustNew(s2.URL)
+	b.SetClock(clk)
+	before := clk.Now()
+	assert.NoError(t, walk(b, &ListOptions{Pacing: &Pacing{Adaptive: true, Budget: 0.25, RemainingHeader: "Quota-Left", ResetHeader: "Quota-Reset"}}))
+	assert.Equal(t, 6*time.Second, clk.Now().Sub(before))
+}
+
+func TestListPacingCanceled(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := newPacedServer(clk, make([][2]string, 2))
+	defer s.Close()
+	a := MustNew(s.URL)
+	a.SetClock(clk)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- List(ctx, a, "/items", &ListOptions{Pacing: &Pacing{Delay: time.Minute}}, func(it testItem) error { return nil })
+	}()
+	clk.BlockUntil(1)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Len(t, s.delays(), 0)
+}
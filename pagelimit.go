@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// ErrPageSize is matched by every *PageSizeError.
+var ErrPageSize = errors.New("api: page size out of range")
+
+// PageSizeError is returned by the request constructors and the Do-style helpers in the
+// RejectPageSize mode when the args ask for a page size out of the range declared with
+// DeclarePageLimit. It's matched by ErrPageSize.
+type PageSizeError struct {
+	Resource string
+	Param    string
+	// Size is the page size asked for, Max the declared maximum.
+	Size, Max int
+}
+
+func (e *PageSizeError) Error() string {
+	return fmt.Sprintf("api: page size %s=%d for %s out of range 1..%d", e.Param, e.Size, e.Resource, e.Max)
+}
+
+// Is makes errors.Is(err, ErrPageSize) report true.
+func (e *PageSizeError) Is(target error) bool { return target == ErrPageSize }
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *PageSizeError) Class() Class { return Permanent }
+
+// PageLimitMode is what the request constructors do with the page sizes out of the range of
+// DeclarePageLimit, see SetPageLimitMode.
+type PageLimitMode int
+
+const (
+	// ClampPageSize sends the nearest page size in range instead, e.g. 100 for 500.
+	ClampPageSize PageLimitMode = iota
+	// RejectPageSize fails the call with a *PageSizeError.
+	RejectPageSize
+)
+
+// pageLimits are the declarations of DeclarePageLimit.
+type pageLimits struct {
+	mode PageLimitMode
+	// limits are the declared limits by resource template.
+	limits map[string]pageLimit
+}
+
+type pageLimit struct {
+	param string
+	max   int
+}
+
+// DeclarePageLimit declares that the resource, a template like DeclareParams ones, serves pages
+// of at most max items, the page size being asked for in the query parameter param. The vendors
+// capping the page sizes often truncate the larger ones silently, which looks like a shorter
+// list: the page sizes of the args of the request constructors out of 1..max are clamped or
+// rejected, see SetPageLimitMode, and List takes param for the page size whose shorter pages it
+// reports to ListOptions.Truncated. Declaring the resource again replaces its limit.
+func (a *Api) DeclarePageLimit(resource, param string, max int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := &pageLimits{limits: make(map[string]pageLimit)}
+	if a.pageLimits != nil {
+		p.mode = a.pageLimits.mode
+		for tmpl, l := range a.pageLimits.limits {
+			p.limits[tmpl] = l
+		}
+	}
+	p.limits[resource] = pageLimit{param: param, max: max}
+	a.pageLimits = p
+}
+
+// SetPageLimitMode sets whether the page sizes out of the range of DeclarePageLimit are clamped,
+// the default, or rejected.
+func (a *Api) SetPageLimitMode(mode PageLimitMode) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := &pageLimits{mode: mode}
+	if a.pageLimits != nil {
+		p.limits = a.pageLimits.limits
+	}
+	a.pageLimits = p
+}
+
+// pageLimit returns the limit declared for resource, false if there's none.
+func (a *Api) pageLimit(resource string) (pageLimit, PageLimitMode, bool) {
+	a.mu.Lock()
+	p := a.pageLimits
+	a.mu.Unlock()
+	if p == nil {
+		return pageLimit{}, 0, false
+	}
+	for tmpl, l := range p.limits {
+		if matchTemplate(tmpl, resource) {
+			return l, p.mode, true
+		}
+	}
+	return pageLimit{}, 0, false
+}
+
+// limitPageSize returns args with their page size clamped to the limit of resource, lowered to
+// the discovered MaxPageSize with the PageSizes of the DiscoveryPolicy, or the *PageSizeError
+// of the RejectPageSize mode. The args are copied before being changed. A page
+// size that isn't a number is left to the server.
+func (a *Api) limitPageSize(resource string, args url.Values) (url.Values, error) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	l, mode, ok := a.pageLimit(resource)
+	if !ok {
+		return args, nil
+	}
+	if caps := a.discovered(func(p *DiscoveryPolicy) bool { return p.PageSizes }); caps.MaxPageSize() > 0 {
+		l.max = min(l.max, caps.MaxPageSize())
+	}
+	size, err := strconv.Atoi(args.Get(l.param))
+	if err != nil || size >= 1 && size <= l.max {
+		return args, nil
+	}
+	if mode == RejectPageSize {
+		return nil, &PageSizeError{Resource: resource, Param: l.param, Size: size, Max: l.max}
+	}
+	clamped := make(url.Values, len(args))
+	for k, vs := range args {
+		clamped[k] = vs
+	}
+	clamped.Set(l.param, strconv.Itoa(min(max(size, 1), l.max)))
+	return clamped, nil
+}
+
+// PageTruncation reports a page of List holding fewer items than the page size asked for while
+// it isn't the last one, which is how the servers silently truncating the page sizes they don't
+// support show. A server dropping the items the caller can't see from its pages does it too.
+type PageTruncation struct {
+	// URL is the URL of the page, Page its 1-based number.
+	URL  *url.URL
+	Page int
+	// Requested is the page size asked for, Items the number of items of the page.
+	Requested, Items int
+}
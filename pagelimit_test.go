@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageLimitClamp(t *testing.T) {
+	a := MustNew("http://api.example.com")
+	a.DeclarePageLimit("/items", "per_page", 100)
+	a.DeclarePageLimit("/users/{id}/events", "limit", 50)
+	args := url.Values{"per_page": {"500"}, "q": {"x"}}
+	req, err := a.Request(GET, "/items", args)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "per_page=100&q=x", req.URL.RawQuery)
+	}
+	// The args aren't changed.
+	assert.Equal(t, "500", args.Get("per_page"))
+	for size, want := range map[string]string{"0": "limit=1", "-3": "limit=1", "50": "limit=50", "20": "limit=20", "all": "limit=all"} {
+		req, err := a.Request(GET, "/users/7/events", url.Values{"limit": {size}})
+		if assert.NoError(t, err) {
+			assert.Equal(t, want, req.URL.RawQuery, size)
+		}
+	}
+	req, _ = a.RequestJSONWithQuery(POST, "/items", url.Values{"per_page": {"101"}}, map[string]int{})
+	assert.Equal(t, "per_page=100", req.URL.RawQuery)
+	req, _ = a.Template(GET, "/users/{id}/events").Build(map[string]string{"id": "7"}, url.Values{"limit": {"51"}})
+	assert.Equal(t, "limit=50", req.URL.RawQuery)
+	// The other resources and params aren't limited.
+	req, _ = a.Request(GET, "/orders", url.Values{"per_page": {"500"}, "limit": {"900"}})
+	assert.Equal(t, "limit=900&per_page=500", req.URL.RawQuery)
+}
+
+func TestPageLimitReject(t *testing.T) {
+	a := MustNew("http://api.example.com")
+	a.SetPageLimitMode(RejectPageSize)
+	a.DeclarePageLimit("/items", "per_page", 100)
+	_, err := a.Request(GET, "/items", url.Values{"per_page": {"500"}})
+	var pe *PageSizeError
+	if assert.True(t, errors.As(err, &pe)) {
+		assert.Equal(t, PageSizeError{Resource: "/items", Param: "per_page", Size: 500, Max: 100}, *pe)
+	}
+	assert.ErrorIs(t, err, ErrPageSize)
+	assert.Equal(t, Permanent, Classify(err))
+	assert.Equal(t, "api: page size per_page=500 for /items out of range 1..100", err.Error())
+	_, err = a.Request(GET, "/items", url.Values{"per_page": {"100"}})
+	assert.NoError(t, err)
+
+	// A List asking for too large pages fails before the first fetch.
+	err = List(context.Background(), a, "/items", &ListOptions{PageSize: 200}, func(it testItem) error { return nil })
+	assert.ErrorIs(t, err, ErrPageSize)
+}
+
+// truncatingServer serves total items at /items in pages of limit items, truncated to max.
+func truncatingServer(total, max int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		size = min(size, max)
+		from := page * size
+		items := []testItem{}
+		for i := from; i < min(from+size, total); i++ {
+			items = append(items, testItem{ID: i})
+		}
+		if from+size < total {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/items?limit=%s&page=%d>; rel="next"`, r.Host, r.URL.Query().Get("limit"), page+1))
+		}
+		json.NewEncoder(w).Encode(items)
+	}))
+}
+
+func TestListTruncation(t *testing.T) {
+	srv := truncatingServer(25, 10)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.DeclarePageLimit("/items", "limit", 1000)
+	var truncations []PageTruncation
+	n := 0
+	opts := &ListOptions{PageSize: 20, Truncated: func(tr PageTruncation) { truncations = append(truncations, tr) }}
+	assert.NoError(t, List(context.Background(), a, "/items", opts, func(it testItem) error { n++; return nil }))
+	assert.Equal(t, 25, n)
+	// The server truncated the pages of 20 to 10 items; the last page is legitimately short.
+	if assert.Len(t, truncations, 2) {
+		assert.Equal(t, 1, truncations[0].Page)
+		assert.Equal(t, 2, truncations[1].Page)
+		assert.Equal(t, 20, truncations[0].Requested)
+		assert.Equal(t, 10, truncations[0].Items)
+		assert.Equal(t, "/items?limit=20&page=1", truncations[1].URL.RequestURI())
+	}
+
+	// With the pages the server serves, only the last one is short, and it isn't reported.
+	truncations = nil
+	opts.PageSize = 10
+	assert.NoError(t, List(context.Background(), a, "/items", opts, func(it testItem) error { return nil }))
+	assert.Empty(t, truncations)
+
+	// The declared limit clamps the pages asked for to the size the server serves.
+	a.DeclarePageLimit("/items", "limit", 10)
+	opts.PageSize = 20
+	assert.NoError(t, List(context.Background(), a, "/items", opts, func(it testItem) error { return nil }))
+	assert.Empty(t, truncations)
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Page is a single fetched page of a paginated list.
+type Page struct {
+	// Number is the 1-based page number within the walk.
+	Number int
+	// URL is the URL the page was fetched from.
+	URL *url.URL
+	// Header is the response header.
+	Header http.Header
+	// Body is the raw response body.
+	Body []byte
+}
+
+// Paginator determines where the next page of a list is.
+type Paginator interface {
+	// Next returns the URL of the page following the given one, or nil if it was the last page.
+	Next(page *Page) (*url.URL, error)
+}
+
+// LinkPaginator follows the rel="next" entry of the Link header. It's the default paginator of List.
+type LinkPaginator struct{}
+
+// Next implements Paginator.
+func (LinkPaginator) Next(page *Page) (*url.URL, error) {
+	next, ok := ParseLinks(page.Header)["next"]
+	if !ok {
+		return nil, nil
+	}
+	return page.URL.Parse(next.URL)
+}
+
+// CursorPaginator reads the next cursor from the JSON body at Path (dotted, e.g. "meta.next_cursor")
+// and passes it with the next request as the Param query parameter. A missing, null or empty cursor ends the list.
+type CursorPaginator struct {
+	Path  string
+	Param string
+}
+
+// Next implements Paginator.
+func (p CursorPaginator) Next(page *Page) (*url.URL, error) {
+	v, err := JSONLookup(page.Body, p.Path)
+	if err != nil || v.Kind() == JSONNull {
+		return nil, nil
+	}
+	if k := v.Kind(); k != JSONString && k != JSONNumber {
+		return nil, fmt.Errorf("api: cursor at %q is not a string or number", p.Path)
+	}
+	s := v.String()
+	if s == "" {
+		return nil, nil
+	}
+	next := *page.URL
+	SetQuery(&http.Request{URL: &next}, p.Param, s)
+	return &next, nil
+}
+
+// ListOptions configures List. The zero value walks the pages following Link headers,
+// treating each page body as a JSON array of items.
+type ListOptions struct {
+	// Args are the query arguments of the first page.
+	Args url.Values
+	// ItemsPath is the dotted path to the items array within a page body, e.g. "data".
+	// If empty, the page body itself must be the array.
+	ItemsPath string
+	// PageSize, if positive, is sent with the first request as the PageSizeParam query parameter.
+	PageSize int
+	// PageSizeParam defaults to the param of the DeclarePageLimit of the resource, or else to
+	// "per_page".
+	PageSizeParam string
+	// Truncated, if set, is invoked for the pages holding fewer items than the page size in their
+	// PageSizeParam query parameter while they aren't the last one, see PageTruncation.
+	Truncated func(t PageTruncation)
+	// Paginator defaults to LinkPaginator.
+	Paginator Paginator
+	// Total, if not nil, receives the total count of items reported by the first page,
+	// or -1 if the API doesn't report it.
+	Total *int64
+	// TotalHeader is the header reporting the total count of items, X-Total-Count by default.
+	TotalHeader string
+	// TotalPath, if set, is the dotted path to the total count of items within a page body,
+	// e.g. "meta.total", taking precedence over TotalHeader.
+	TotalPath string
+	// Progress, if set, is invoked after the items of each page were processed.
+	Progress func(p PageProgress)
+	// Checkpoint, if set, stores the URL of the next page and the number of items processed
+	// after the items of each page were, for a walk interrupted later to be resumed from it.
+	// The last page stores an empty cursor.
+	Checkpoint Checkpoint
+	// ResumeFrom, if set, starts the walk from the cursor it loads rather than from the first
+	// page, and counts the items processed from its count; Args and PageSize are then those of
+	// the cursor. It's usually the Checkpoint too. A walk interrupted within a page fetches the
+	// page again, so its items processed before the interruption are processed twice.
+	ResumeFrom Checkpoint
+	// CursorExpired reports whether the failure of a page fetched from a cursor, as the next page
+	// or resumed, is the server telling the cursor expired, failing the walk with a
+	// *CursorExpiredError then. If nil, a 410 Gone does.
+	CursorExpired func(err *StatusError) bool
+	// Pacing, if set, spaces the page fetches of the walk in place of the pacing of SetListPacing;
+	// a zero Pacing disables it.
+	Pacing *Pacing
+	// Options are applied to every page request.
+	Options []Option
+}
+
+// PageProgress reports the progress of List after a page.
+type PageProgress struct {
+	// Page is the 1-based number of the page.
+	Page int
+	// Items is the number of items on the page, and Processed the number of items so far.
+	Items     int
+	Processed int64
+	// Total is the total count of items, as last reported by the API, or -1 if unknown.
+	Total int64
+	// Next is the URL of the next page, nil after the last one.
+	Next *url.URL
+}
+
+// List walks all pages of the list at resource, decoding every item into T and invoking fn for it.
+// Only one page is buffered at a time. The walk stops at the first error returned by fn,
+// which is returned as is, or when ctx is done.
+func List[T any](ctx context.Context, a *Api, resource string, opts *ListOptions, fn func(item T) error) error {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	args := url.Values{}
+	for k, v := range opts.Args {
+		args[k] = v
+	}
+	sizeParam := opts.PageSizeParam
+	if sizeParam == "" {
+		sizeParam = "per_page"
+		if l, _, ok := a.pageLimit(resource); ok {
+			sizeParam = l.param
+		}
+	}
+	if opts.PageSize > 0 {
+		args.Set(sizeParam, strconv.Itoa(opts.PageSize))
+	}
+	paginator := opts.Paginator
+	if paginator == nil {
+		paginator = LinkPaginator{}
+	}
+	progress := PageProgress{Total: -1}
+	var req *http.Request
+	var err error
+	resumed := false
+	if opts.ResumeFrom != nil {
+		cursor, processed, err := opts.ResumeFrom.Load()
+		if err != nil {
+			return err
+		}
+		if cursor != "" {
+			u, err := url.Parse(cursor)
+			if err != nil {
+				return fmt.Errorf("api: invalid list cursor: %w", err)
+			}
+			if req, err = a.pageRequest(ctx, u); err != nil {
+				return err
+			}
+			progress.Processed, resumed = processed, true
+		}
+	}
+	if req == nil {
+		if req, err = a.callRequest(ctx, GET, resource, args, opts.Options); err != nil {
+			return err
+		}
+	}
+	pace := a.pacerFor(opts)
+	for number := 1; req != nil; number++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if pace != nil {
+			if err := pace.wait(ctx); err != nil {
+				return err
+			}
+		}
+		page, err := a.fetchPage(ctx, req, number, opts)
+		if err != nil {
+			if number > 1 || resumed {
+				return cursorError(req, err, opts)
+			}
+			return err
+		}
+		if pace != nil {
+			pace.fetched(page.Header)
+		}
+		items, err := decodeItems(page.Body, opts.ItemsPath, fn)
+		if err != nil {
+			return err
+		}
+		next, err := paginator.Next(page)
+		if err != nil {
+			return err
+		}
+		if opts.Truncated != nil && next != nil {
+			if size, err := strconv.Atoi(page.URL.Query().Get(sizeParam)); err == nil && items < size {
+				opts.Truncated(PageTruncation{URL: page.URL, Page: number, Requested: size, Items: items})
+			}
+		}
+		progress.Processed += int64(items)
+		if opts.Progress != nil {
+			if total := pageTotal(page, opts); total >= 0 {
+				progress.Total = total
+			}
+			progress.Page, progress.Items, progress.Next = number, items, next
+			opts.Progress(progress)
+		}
+		if opts.Checkpoint != nil {
+			cursor := ""
+			if next != nil {
+				cursor = next.String()
+			}
+			if err := opts.Checkpoint.Store(cursor, progress.Processed); err != nil {
+				return err
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		if req, err = a.pageRequest(ctx, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Api) fetchPage(ctx context.Context, req *http.Request, number int, opts *ListOptions) (*Page, error) {
+	c := a.newCall(opts.Options)
+	resp, err := a.send(ctx, c, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkJSONContent(req, resp); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page{Number: number, URL: req.URL, Header: resp.Header, Body: body}
+	if number == 1 && opts.Total != nil {
+		*opts.Total = pageTotal(page, opts)
+	}
+	return page, nil
+}
+
+// pageTotal returns the total count of items reported by page, or -1.
+func pageTotal(page *Page, opts *ListOptions) int64 {
+	var s string
+	if opts.TotalPath != "" {
+		v, err := JSONLookup(page.Body, opts.TotalPath)
+		if err != nil {
+			return -1
+		}
+		s = v.String()
+	} else {
+		name := opts.TotalHeader
+		if name == "" {
+			name = "X-Total-Count"
+		}
+		s = strings.TrimSpace(page.Header.Get(name))
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+		return n
+	}
+	return -1
+}
+
+// pageRequest creates a GET request for the absolute URL of a next page.
+func (a *Api) pageRequest(ctx context.Context, u *url.URL) (*http.Request, error) {
+	req, err := http.NewRequest(GET.String(), u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.shape(req, []Option{buildContext(ctx)}); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// decodeItems decodes the array at path element by element, invoking fn for each,
+// and returns the number of items.
+func decodeItems[T any](body []byte, path string, fn func(item T) error) (int, error) {
+	v, err := JSONLookup(body, path)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = decodeArray(context.Background(), json.NewDecoder(bytes.NewReader(v.Raw)), func(dec *json.Decoder) error {
+		var item T
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		n++
+		return fn(item)
+	})
+	return n, err
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testItem struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// pagedServer serves 3 pages of 2 items each at /items, linking pages via the Link header.
+func pagedServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		if page < 3 {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/items?page=%d>; rel="next", <%s/items?page=3>; rel="last"`,
+				"http://"+r.Host, page+1, "http://"+r.Host))
+		}
+		w.Header().Set("X-Total-Count", "6")
+		fmt.Fprintf(w, `{"data": [{"id": %d, "name": "item"}, {"id": %d, "name": "item"}]}`, page*2-1, page*2)
+	}))
+}
+
+func TestList(t *testing.T) {
+	srv := pagedServer()
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var ids []int
+	var total int64
+	err := List(context.Background(), a, "/items", &ListOptions{
+		ItemsPath: "data",
+		Total:     &total,
+	}, func(it testItem) error {
+		ids = append(ids, it.ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, ids)
+	assert.Equal(t, int64(6), total)
+}
+
+func TestListEarlyStop(t *testing.T) {
+	srv := pagedServer()
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	errStop := errors.New("stop")
+	var ids []int
+	err := List(context.Background(), a, "/items", &ListOptions{ItemsPath: "data"}, func(it testItem) error {
+		if it.ID == 4 {
+			return errStop
+		}
+		ids = append(ids, it.ID)
+		return nil
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, []int{1, 2, 3}, ids)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = List(ctx, a, "/items", &ListOptions{ItemsPath: "data"}, func(it testItem) error {
+		cancel()
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestListCursor(t *testing.T) {
+	var sizes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sizes = append(sizes, r.URL.Query().Get("limit"))
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Write([]byte(`{"items": [{"id": 1}], "next": "abc"}`))
+		case "abc":
+			w.Write([]byte(`{"items": [{"id": 2}], "next": null}`))
+		}
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var ids []int
+	err := List(context.Background(), a, "/items", &ListOptions{
+		ItemsPath:     "items",
+		PageSize:      1,
+		PageSizeParam: "limit",
+		Paginator:     CursorPaginator{Path: "next", Param: "cursor"},
+	}, func(it testItem) error {
+		ids = append(ids, it.ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, ids)
+	assert.Equal(t, []string{"1", "1"}, sizes)
+}
+
+func TestParseLinks(t *testing.T) {
+	h := http.Header{}
+	h.Add("Link", `</items?page=2>; rel="next"; title="a, b; c", </items?page=9>;rel=last`)
+	h.Add("Link", `<https://example.com/x>; rel="prev first"`)
+	links := ParseLinks(h)
+	assert.Equal(t, "/items?page=2", links["next"].URL)
+	assert.Equal(t, "a, b; c", links["next"].Params["title"])
+	assert.Equal(t, "/items?page=9", links["last"].URL)
+	assert.Equal(t, "https://example.com/x", links["prev"].URL)
+	assert.Equal(t, "https://example.com/x", links["first"].URL)
+}
+
+func TestListProgress(t *testing.T) {
+	srv := pagedServer()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	var got []PageProgress
+	err := List(context.Background(), a, "/items", &ListOptions{
+		ItemsPath: "data",
+		Progress:  func(p PageProgress) { got = append(got, p) },
+	}, func(testItem) error { return nil })
+	if !assert.NoError(t, err) || !assert.Len(t, got, 3) {
+		return
+	}
+	for i, p := range got {
+		assert.Equal(t, i+1, p.Page)
+		assert.Equal(t, 2, p.Items)
+		assert.Equal(t, int64(2*(i+1)), p.Processed)
+		assert.Equal(t, int64(6), p.Total)
+	}
+	assert.Equal(t, srv.URL+"/items?page=2", got[0].Next.String())
+	assert.Equal(t, srv.URL+"/items?page=3", got[1].Next.String())
+	assert.Nil(t, got[2].Next)
+}
+
+func TestListProgressTotalPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Write([]byte(`{"items": [{"id": 1}, {"id": 2}], "meta": {"next": "abc"}}`))
+		case "abc":
+			w.Write([]byte(`{"items": [{"id": 3}], "meta": {"next": null, "total": 3}}`))
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	var got []PageProgress
+	total := int64(42)
+	err := List(context.Background(), a, "/items", &ListOptions{
+		ItemsPath: "items",
+		Paginator: CursorPaginator{Path: "meta.next", Param: "cursor"},
+		TotalPath: "meta.total",
+		Total:     &total,
+		Progress:  func(p PageProgress) { got = append(got, p) },
+	}, func(testItem) error { return nil })
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), total)
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, PageProgress{Page: 1, Items: 2, Processed: 2, Total: -1, Next: got[0].Next}, got[0])
+		assert.Equal(t, "abc", got[0].Next.Query().Get("cursor"))
+		assert.Equal(t, PageProgress{Page: 2, Items: 1, Processed: 3, Total: 3}, got[1])
+	}
+}
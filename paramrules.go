@@ -0,0 +1,172 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrParamRule is matched by every *ParamRuleError.
+var ErrParamRule = errors.New("api: query parameters break a rule")
+
+// ParamRule is a rule between the query parameters of a resource, see DeclareParamRules.
+type ParamRule struct {
+	kind   paramRuleKind
+	params []string
+	// when is the parameter requiring params[0], for a ParamRequiredIf rule.
+	when string
+}
+
+type paramRuleKind int
+
+const (
+	exclusiveParams paramRuleKind = iota
+	paramsTogether
+	paramRequiredIf
+)
+
+// ExclusiveParams is the rule that at most one of the parameters names is set, e.g. two filters
+// the server would silently pick from.
+func ExclusiveParams(names ...string) ParamRule {
+	return ParamRule{kind: exclusiveParams, params: names}
+}
+
+// ParamsTogether is the rule that the parameters names are set together or not at all, e.g. the
+// bounds of a range.
+func ParamsTogether(names ...string) ParamRule {
+	return ParamRule{kind: paramsTogether, params: names}
+}
+
+// ParamRequiredIf is the rule that the parameter param is set whenever the parameter when is.
+func ParamRequiredIf(param, when string) ParamRule {
+	return ParamRule{kind: paramRequiredIf, params: []string{param}, when: when}
+}
+
+// String returns the rule, e.g. "exclusive(status, state)", "together(from, to)" or
+// "currency if amount".
+func (r ParamRule) String() string {
+	switch r.kind {
+	case exclusiveParams:
+		return "exclusive(" + strings.Join(r.params, ", ") + ")"
+	case paramsTogether:
+		return "together(" + strings.Join(r.params, ", ") + ")"
+	}
+	return r.params[0] + " if " + r.when
+}
+
+// check returns the parameters of args breaking the rule, nil if it holds: the ones set for
+// ExclusiveParams, the missing ones for ParamsTogether and ParamRequiredIf.
+func (r ParamRule) check(args url.Values) []string {
+	var set, missing []string
+	for _, name := range r.params {
+		if _, ok := args[name]; ok {
+			set = append(set, name)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	switch r.kind {
+	case exclusiveParams:
+		if len(set) > 1 {
+			return set
+		}
+	case paramsTogether:
+		if len(set) > 0 && len(missing) > 0 {
+			return missing
+		}
+	case paramRequiredIf:
+		if _, ok := args[r.when]; ok && len(missing) > 0 {
+			return missing
+		}
+	}
+	return nil
+}
+
+// ParamRuleError is returned by the request constructors and the Do-style helpers when the args
+// of a resource break one of its rules, see DeclareParamRules. It's matched by ErrParamRule.
+type ParamRuleError struct {
+	// Resource is the resource the args were given for.
+	Resource string
+	Rule     ParamRule
+	// Params are the offending keys: the ones set together for ExclusiveParams, the missing ones
+	// for ParamsTogether and ParamRequiredIf.
+	Params []string
+}
+
+func (e *ParamRuleError) Error() string {
+	switch e.Rule.kind {
+	case exclusiveParams:
+		return fmt.Sprintf("api: query parameters %s for %s are mutually exclusive", quoteParams(e.Params), e.Resource)
+	case paramsTogether:
+		return fmt.Sprintf("api: query parameters %s for %s go together, missing %s", quoteParams(e.Rule.params), e.Resource, quoteParams(e.Params))
+	}
+	return fmt.Sprintf("api: query parameter %q for %s is required with %q", e.Rule.params[0], e.Resource, e.Rule.when)
+}
+
+// Is makes errors.Is(err, ErrParamRule) report true.
+func (e *ParamRuleError) Is(target error) bool {
+	return target == ErrParamRule
+}
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *ParamRuleError) Class() Class { return Permanent }
+
+func quoteParams(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// DeclareParamRules declares rules between the query parameters of the resource, a template like
+// DeclareParams ones, e.g. for a server silently preferring one of two filters sent together:
+//
+//	a.DeclareParamRules("/reports",
+//		api.ExclusiveParams("status", "state"),
+//		api.ParamsTogether("from", "to"),
+//		api.ParamRequiredIf("currency", "amount"))
+//
+// Request, RequestJSONWithQuery, RequestForm, Template and the Do-style helpers then fail with a
+// *ParamRuleError, whether SetStrictParams is on or not, when the args break one, a key being set
+// whatever its values. They're checked as the url.Values they're given, so the args encoded from
+// a struct are checked alike. The rules are checked in the order they're declared, the first one
+// broken failing the call. Calling it again for the same resource adds to its rules.
+// SkipParamRules disables them for a call.
+func (a *Api) DeclareParamRules(resource string, rules ...ParamRule) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := &paramDecls{rules: make(map[string][]ParamRule)}
+	if a.params != nil {
+		p.strict, p.names = a.params.strict, a.params.names
+		for tmpl, declared := range a.params.rules {
+			p.rules[tmpl] = declared
+		}
+	}
+	p.rules[resource] = append(p.rules[resource][:len(p.rules[resource]):len(p.rules[resource])], rules...)
+	a.params = p
+}
+
+// SkipParamRules lets the args of the call break the rules of DeclareParamRules, e.g. for a test
+// checking what the server does with them. It applies to the request constructors too.
+func SkipParamRules() Option {
+	return func(c *call) {
+		c.anyRules = true
+	}
+}
+
+// checkRules checks args against the rules of resource.
+func (p *paramDecls) checkRules(resource string, args url.Values) error {
+	for tmpl, rules := range p.rules {
+		if !matchTemplate(tmpl, resource) {
+			continue
+		}
+		for _, r := range rules {
+			if bad := r.check(args); bad != nil {
+				return &ParamRuleError{Resource: resource, Rule: r, Params: bad}
+			}
+		}
+	}
+	return nil
+}
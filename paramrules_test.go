@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamRules(t *testing.T) {
+	a := MustNew("http://api.example.com")
+	a.DeclareParamRules("/reports", ExclusiveParams("status", "state", "phase"))
+	a.DeclareParamRules("/reports", ParamsTogether("from", "to"), ParamRequiredIf("currency", "amount"))
+
+	for _, args := range []url.Values{
+		nil,
+		{"status": {"open"}, "from": {"1"}, "to": {"2"}},
+		{"state": {"x"}, "amount": {"10"}, "currency": {"EUR"}},
+		{"currency": {"EUR"}, "other": {"1"}},
+	} {
+		_, err := a.Request(GET, "/reports", args)
+		assert.NoError(t, err, "%v", args)
+	}
+
+	for _, tc := range []struct {
+		args   url.Values
+		rule   string
+		params []string
+		msg    string
+	}{
+		{url.Values{"status": {"open"}, "phase": {""}}, "exclusive(status, state, phase)", []string{"status", "phase"},
+			`api: query parameters "status", "phase" for /reports are mutually exclusive`},
+		{url.Values{"to": {"2"}}, "together(from, to)", []string{"from"},
+			`api: query parameters "from", "to" for /reports go together, missing "from"`},
+		{url.Values{"amount": {"10"}}, "currency if amount", []string{"currency"},
+			`api: query parameter "currency" for /reports is required with "amount"`},
+		// The first rule broken in the order of the declarations fails the call.
+		{url.Values{"status": {"a"}, "state": {"b"}, "from": {"1"}, "amount": {"1"}}, "exclusive(status, state, phase)", []string{"status", "state"},
+			`api: query parameters "status", "state" for /reports are mutually exclusive`},
+		{url.Values{"from": {"1"}, "amount": {"1"}}, "together(from, to)", []string{"to"},
+			`api: query parameters "from", "to" for /reports go together, missing "to"`},
+	} {
+		_, err := a.Request(GET, "/reports", tc.args)
+		var re *ParamRuleError
+		if assert.True(t, errors.As(err, &re), "%v", tc.args) {
+			assert.Equal(t, tc.rule, re.Rule.String())
+			assert.Equal(t, tc.params, re.Params)
+			assert.Equal(t, "/reports", re.Resource)
+		}
+		assert.ErrorIs(t, err, ErrParamRule)
+		assert.Equal(t, Permanent, Classify(err))
+		assert.EqualError(t, err, tc.msg)
+	}
+
+	// The rules apply whatever the strict mode, and to the forms and templates alike.
+	a.DeclareParamRules("/users/{id}/events", ExclusiveParams("since", "cursor"))
+	_, err := a.Request(POST, "/users/7/events", url.Values{"since": {"1"}, "cursor": {"c"}})
+	assert.ErrorIs(t, err, ErrParamRule)
+	_, err = a.RequestForm(POST, "/users/7/events", (&Form{}).Append("since", "1").Append("cursor", "c"))
+	assert.ErrorIs(t, err, ErrParamRule)
+	_, err = a.Template(GET, "/users/{id}/events").Build(map[string]string{"id": "7"}, url.Values{"since": {"1"}, "cursor": {"c"}})
+	assert.ErrorIs(t, err, ErrParamRule)
+	_, err = a.Request(GET, "/users/7", url.Values{"since": {"1"}, "cursor": {"c"}})
+	assert.NoError(t, err)
+}
+
+func TestSkipParamRules(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.DeclareParams("/reports", "status", "state")
+	a.DeclareParamRules("/reports", ExclusiveParams("status", "state"))
+	a.SetStrictParams(true)
+	ctx := context.Background()
+	both := url.Values{"status": {"a"}, "state": {"b"}}
+
+	assert.ErrorIs(t, a.Get(ctx, "/reports", both, nil), ErrParamRule)
+	assert.Empty(t, queries)
+	assert.NoError(t, a.Get(ctx, "/reports", both, nil, SkipParamRules()))
+	_, err := a.Request(GET, "/reports", both, SkipParamRules())
+	assert.NoError(t, err)
+	assert.NoError(t, a.Template(GET, "/reports", SkipParamRules()).Do(ctx, nil, both, nil))
+	assert.Equal(t, []string{"state=b&status=a", "state=b&status=a"}, queries)
+
+	// Skipping the rules doesn't skip the declarations, and the other way around.
+	withTypo := url.Values{"status": {"a"}, "stat": {"b"}}
+	assert.ErrorIs(t, a.Get(ctx, "/reports", withTypo, nil, SkipParamRules()), ErrUnknownParam)
+	both.Set("x", "1")
+	assert.ErrorIs(t, a.Get(ctx, "/reports", both, nil, AllowUndeclaredParams()), ErrParamRule)
+	_, err = a.Request(GET, "/reports", both, AllowUndeclaredParams())
+	assert.ErrorIs(t, err, ErrParamRule)
+	_, err = a.Template(GET, "/reports", AllowUndeclaredParams()).Build(nil, both)
+	assert.ErrorIs(t, err, ErrParamRule)
+	assert.NoError(t, a.Get(ctx, "/reports", both, nil, AllowUndeclaredParams(), SkipParamRules()))
+	assert.Len(t, queries, 3)
+
+	// The defaults skip them too.
+	a.SetDefaults(SkipParamRules())
+	both.Del("x")
+	assert.NoError(t, a.Get(ctx, "/reports", both, nil))
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownParam is matched by every *UnknownParamError.
+var ErrUnknownParam = errors.New("api: unknown query parameter")
+
+// UnknownParamError is returned by the request constructors and the Do-style helpers when strict
+// parameters are on and the args have a key which the resource doesn't declare, see DeclareParams.
+// It's matched by ErrUnknownParam.
+type UnknownParamError struct {
+	// Resource is the resource the args were given for.
+	Resource string
+	// Param is the unknown key, the first in sorted order if there are several.
+	Param string
+	// Suggestion is the declared name closest to Param, empty if none is close enough.
+	Suggestion string
+}
+
+func (e *UnknownParamError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("api: unknown query parameter %q for %s, did you mean %q?", e.Param, e.Resource, e.Suggestion)
+	}
+	return fmt.Sprintf("api: unknown query parameter %q for %s", e.Param, e.Resource)
+}
+
+// Is makes errors.Is(err, ErrUnknownParam) report true.
+func (e *UnknownParamError) Is(target error) bool {
+	return target == ErrUnknownParam
+}
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *UnknownParamError) Class() Class { return Permanent }
+
+// paramDecls are the declarations of DeclareParams and DeclareParamRules.
+type paramDecls struct {
+	strict bool
+	// names are the declared names by resource template.
+	names map[string][]string
+	// rules are the declared rules by resource template.
+	rules map[string][]ParamRule
+}
+
+// DeclareParams declares the names of the query parameters of the resource, a template like
+// "/items/{id}" whose "{...}" segments match any segment. A name may have "*" matching any run
+// of characters, e.g. "filter[*]"; "*" alone accepts every name. Calling it again for the same
+// resource adds to its names. Once SetStrictParams is on, the args of the calls to the resources
+// having declarations must only have declared keys; the other resources aren't checked.
+func (a *Api) DeclareParams(resource string, names ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := &paramDecls{names: make(map[string][]string)}
+	if a.params != nil {
+		p.strict, p.rules = a.params.strict, a.params.rules
+		for tmpl, declared := range a.params.names {
+			p.names[tmpl] = declared
+		}
+	}
+	p.names[resource] = append(p.names[resource][:len(p.names[resource]):len(p.names[resource])], names...)
+	a.params = p
+}
+
+// SetStrictParams makes Request, RequestJSONWithQuery, Template and the Do-style helpers fail
+// with an *UnknownParamError when the args of a resource declared with DeclareParams have an
+// undeclared key, e.g. a typo like "fliter" the server would silently ignore. The query of the
+// requests given to Do as they are isn't checked. AllowUndeclaredParams disables it for a call.
+func (a *Api) SetStrictParams(strict bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := &paramDecls{strict: strict}
+	if a.params != nil {
+		p.names, p.rules = a.params.names, a.params.rules
+	}
+	a.params = p
+}
+
+// AllowUndeclaredParams lets the call have args undeclared by DeclareParams despite SetStrictParams.
+// It applies to the request constructors too.
+func AllowUndeclaredParams() Option {
+	return func(c *call) {
+		c.anyParams = true
+	}
+}
+
+// checkParams checks args against the declarations and then the rules of resource.
+func (a *Api) checkParams(resource string, args url.Values) error {
+	if len(args) == 0 {
+		return nil
+	}
+	p := a.paramDecls()
+	if p == nil {
+		return nil
+	}
+	if err := p.checkNames(resource, args); err != nil {
+		return err
+	}
+	return p.checkRules(resource, args)
+}
+
+func (a *Api) paramDecls() *paramDecls {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.params
+}
+
+// checkNames checks the keys of args against the declared names of resource in strict mode.
+func (p *paramDecls) checkNames(resource string, args url.Values) error {
+	if !p.strict {
+		return nil
+	}
+	var declared []string
+	found := false
+	for tmpl, names := range p.names {
+		if matchTemplate(tmpl, resource) {
+			declared, found = append(declared, names...), true
+		}
+	}
+	if !found {
+		return nil
+	}
+	var unknown []string
+	for k := range args {
+		if !matchParam(declared, k) {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return &UnknownParamError{Resource: resource, Param: unknown[0], Suggestion: closestName(unknown[0], declared)}
+}
+
+// checkParamsFor is checkParams for the request constructors given opts.
+func (a *Api) checkParamsFor(resource string, args url.Values, opts []Option) error {
+	err := a.checkParams(resource, args)
+	if err == nil {
+		return nil
+	}
+	c := &call{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return a.paramsErrorFor(c, resource, args, err)
+}
+
+// paramsErrorFor returns err, the error of checkParams for resource and args, unless the call c
+// skips the check failing. The rules are still checked when only the declarations are skipped.
+func (a *Api) paramsErrorFor(c *call, resource string, args url.Values, err error) error {
+	var re *ParamRuleError
+	switch {
+	case errors.As(err, &re):
+		if c.anyRules {
+			return nil
+		}
+	case c.anyParams:
+		if c.anyRules {
+			return nil
+		}
+		return a.paramDecls().checkRules(resource, args)
+	}
+	return err
+}
+
+// callRequest creates the request of a Do-style helper just like Request, checking args given
+// the defaults and the opts of the call.
+func (a *Api) callRequest(ctx context.Context, method Method, resource string, args url.Values, opts []Option) (*http.Request, error) {
+	if err := a.checkParams(resource, args); err != nil {
+		if err = a.paramsErrorFor(a.newCall(opts), resource, args, err); err != nil {
+			return nil, err
+		}
+	}
+	return a.Request(method, resource, args, buildContext(ctx), AllowUndeclaredParams(), SkipParamRules())
+}
+
+// matchParam reports whether name matches one of the declared names, case-sensitively.
+func matchParam(declared []string, name string) bool {
+	for _, d := range declared {
+		if globMatch(d, name, func(a, b string) bool { return a == b }) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestName returns the declared name without wildcards closest to name in edit distance,
+// empty if even the closest one is too far from it to be a typo.
+func closestName(name string, declared []string) string {
+	best, bestDist := "", max(2, len(name)/3)+1
+	for _, d := range declared {
+		if strings.Contains(d, "*") {
+			continue
+		}
+		if dist := editDistance(name, d); dist < bestDist || dist == bestDist && d < best {
+			best, bestDist = d, dist
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b, in bytes.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
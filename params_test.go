@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeclareParams(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.DeclareParams("/items", "filter", "limit")
+	a.DeclareParams("/items", "cursor", "sort[*]")
+	a.DeclareParams("/items/{id}/logs", "*")
+	ctx := context.Background()
+
+	// Declarations are inert until strict parameters are on.
+	assert.NoError(t, a.Get(ctx, "/items", url.Values{"fliter": {"a"}}, nil))
+	a.SetStrictParams(true)
+
+	err := a.Get(ctx, "/items", url.Values{"fliter": {"a"}, "limit": {"1"}}, nil)
+	assert.ErrorIs(t, err, ErrUnknownParam)
+	assert.EqualError(t, err, `api: unknown query parameter "fliter" for /items, did you mean "filter"?`)
+	_, err = a.Request(GET, "/items", url.Values{"zzz": {"1"}, "cursr": {"x"}})
+	assert.Equal(t, &UnknownParamError{Resource: "/items", Param: "cursr", Suggestion: "cursor"}, err)
+	_, err = a.Request(GET, "/items", url.Values{"expand": {"all"}})
+	assert.EqualError(t, err, `api: unknown query parameter "expand" for /items`)
+	err = a.Delete(ctx, "/items", url.Values{"filter": {"a"}, "limt": {"1"}})
+	assert.ErrorIs(t, err, ErrUnknownParam)
+	assert.Len(t, queries, 1)
+
+	args := url.Values{"filter": {"a"}, "sort[name]": {"asc"}, "cursor": {"c"}}
+	assert.NoError(t, a.Get(ctx, "/items", args, nil))
+	assert.NoError(t, a.Get(ctx, "/items/7/logs", url.Values{"anything": {"1"}}, nil))
+	// Resources without declarations aren't checked.
+	assert.NoError(t, a.Get(ctx, "/orders", url.Values{"fliter": {"a"}}, nil))
+	assert.NoError(t, a.Get(ctx, "/items/7", url.Values{"fliter": {"a"}}, nil))
+	assert.Len(t, queries, 5)
+
+	// The check can be disabled per call.
+	assert.NoError(t, a.Get(ctx, "/items", url.Values{"debug": {"1"}}, nil, AllowUndeclaredParams()))
+	_, err = a.Request(GET, "/items", url.Values{"debug": {"1"}}, AllowUndeclaredParams())
+	assert.NoError(t, err)
+	_, err = a.RequestJSONWithQuery(POST, "/items", url.Values{"debug": {"1"}}, nil)
+	assert.ErrorIs(t, err, ErrUnknownParam)
+
+	tmpl := a.Template(GET, "/items/{id}/logs")
+	assert.NoError(t, tmpl.Do(ctx, map[string]string{"id": "1"}, url.Values{"x": {"1"}}, nil))
+	tmpl = a.Template(GET, "/items")
+	err = tmpl.Do(ctx, nil, url.Values{"limits": {"1"}}, nil)
+	assert.EqualError(t, err, `api: unknown query parameter "limits" for /items, did you mean "limit"?`)
+	_, err = a.Template(GET, "/items", AllowUndeclaredParams()).Build(nil, url.Values{"limits": {"1"}})
+	assert.NoError(t, err)
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 2, editDistance("fliter", "filter"))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+	assert.Equal(t, 4, editDistance("", "page"))
+	assert.Equal(t, "", closestName("page", []string{"filter", "cursor"}))
+	assert.Equal(t, "page_size", closestName("pagesize", []string{"page", "page_size", "size"}))
+}
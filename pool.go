@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// handshakeBounds are the upper bounds of the buckets of PoolStats.TLSHandshakeBuckets.
+var handshakeBounds = [...]time.Duration{
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+}
+
+// PoolStats are the connection counters of an Api, see PoolStats. They're collected with httptrace
+// from every attempt sent via Do, hedges included.
+type PoolStats struct {
+	// NewConns is the number of requests sent over a new connection, ReusedConns the number
+	// of those sent over a connection of the pool.
+	NewConns    int64
+	ReusedConns int64
+	// IdleConns is the number of connections put back in the pool minus those taken from it.
+	// It's an upper bound for HTTP/1.x, since the transport doesn't report the idle connections it
+	// closes, and always zero for HTTP/2, whose connections are shared rather than put back.
+	IdleConns int64
+	// TLSHandshakes is the number of TLS handshakes, TLSHandshakeErrors the number of those that
+	// failed and TLSHandshakeTime the time they took altogether.
+	TLSHandshakes      int64
+	TLSHandshakeErrors int64
+	TLSHandshakeTime   time.Duration
+	// TLSHandshakeBuckets counts the handshakes by duration, taking up to 10ms, 25ms, 50ms, 100ms,
+	// 250ms, 500ms, 1s, 2.5s, then more.
+	TLSHandshakeBuckets [len(handshakeBounds) + 1]int64
+	// StaleRetries is the number of requests sent again on another connection because the
+	// server had closed the reused one, see Do.
+	StaleRetries int64
+}
+
+// ReuseRatio returns the share of the requests sent over a connection of the pool, 0 if there
+// were none.
+func (s PoolStats) ReuseRatio() float64 {
+	total := s.NewConns + s.ReusedConns
+	if total == 0 {
+		return 0
+	}
+	return float64(s.ReusedConns) / float64(total)
+}
+
+// poolStats are the counters behind PoolStats.
+type poolStats struct {
+	newConns, reusedConns, idleConns       atomic.Int64
+	handshakes, handshakeErrors, handshake atomic.Int64
+	staleRetries                           atomic.Int64
+	buckets                                [len(handshakeBounds) + 1]atomic.Int64
+	// putIdle is the PutIdleConn hook of the attempts, shared by all of them.
+	putIdle func(err error)
+}
+
+func (a *Api) poolStats() *poolStats {
+	return a.pool.get(newPoolStats)
+}
+
+func newPoolStats() *poolStats {
+	p := &poolStats{}
+	p.putIdle = func(err error) {
+		if err == nil {
+			p.idleConns.Add(1)
+		}
+	}
+	return p
+}
+
+// gotConn counts a connection obtained for a request.
+func (p *poolStats) gotConn(reused, wasIdle bool) {
+	if !reused {
+		p.newConns.Add(1)
+		return
+	}
+	p.reusedConns.Add(1)
+	if wasIdle {
+		p.idleConns.Add(-1)
+	}
+}
+
+// handshakeDone counts a TLS handshake that took d.
+func (p *poolStats) handshakeDone(d time.Duration, err error) {
+	p.handshakes.Add(1)
+	if err != nil {
+		p.handshakeErrors.Add(1)
+	}
+	p.handshake.Add(int64(d))
+	i := 0
+	for i < len(handshakeBounds) && d > handshakeBounds[i] {
+		i++
+	}
+	p.buckets[i].Add(1)
+}
+
+// snapshot returns the counters, setting them to zero if reset, except the idle connections.
+func (p *poolStats) snapshot(reset bool) PoolStats {
+	load := func(v *atomic.Int64) int64 {
+		if reset {
+			return v.Swap(0)
+		}
+		return v.Load()
+	}
+	s := PoolStats{
+		NewConns:           load(&p.newConns),
+		ReusedConns:        load(&p.reusedConns),
+		IdleConns:          max(p.idleConns.Load(), 0),
+		TLSHandshakes:      load(&p.handshakes),
+		TLSHandshakeErrors: load(&p.handshakeErrors),
+		TLSHandshakeTime:   time.Duration(load(&p.handshake)),
+		StaleRetries:       load(&p.staleRetries),
+	}
+	for i := range p.buckets {
+		s.TLSHandshakeBuckets[i] = load(&p.buckets[i])
+	}
+	return s
+}
+
+// PoolStats returns the connection counters of the calls made through the Api since it was
+// created or ResetPoolStats was called, e.g. for capacity planning. The counters are atomic,
+// so a snapshot taken while calls are in flight may be a few calls apart between counters.
+func (a *Api) PoolStats() PoolStats {
+	return a.poolStats().snapshot(false)
+}
+
+// ResetPoolStats returns the connection counters like PoolStats and sets them to zero, except
+// IdleConns which counts the pool as it is.
+func (a *Api) ResetPoolStats() PoolStats {
+	return a.poolStats().snapshot(true)
+}
+
+// ExportPoolStats calls fn with the counters of the Api reset every interval, e.g. for exporting
+// them to a metrics system as rates, until the returned stop function is called or the Api is
+// closed. interval is measured with the Api's Clock.
+func (a *Api) ExportPoolStats(interval time.Duration, fn func(PoolStats)) (stop func()) {
+	done := make(chan struct{})
+	clk := a.clock()
+	a.goBackground(context.Background(), func(ctx context.Context) {
+		t := clk.NewTimer(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C():
+				fn(a.ResetPoolStats())
+				t.Reset(interval)
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestPoolStats(t *testing.T) {
+	var arrived sync.WaitGroup
+	var barrier chan struct{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/parallel" {
+			arrived.Done()
+			<-barrier
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Client = &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 10}}
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		if !assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil)) {
+			return
+		}
+	}
+	s := a.PoolStats()
+	assert.Equal(t, int64(1), s.NewConns)
+	assert.Equal(t, int64(4), s.ReusedConns)
+	assert.Equal(t, int64(1), s.IdleConns)
+	assert.Equal(t, 0.8, s.ReuseRatio())
+	assert.Equal(t, int64(0), s.TLSHandshakes)
+
+	// The concurrent calls need a connection each: the idle one, then new ones.
+	s = a.ResetPoolStats()
+	assert.Equal(t, int64(5), s.NewConns+s.ReusedConns)
+	const n = 6
+	barrier = make(chan struct{})
+	arrived.Add(n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, a.DoJSON(ctx, GET, "/parallel", nil, nil))
+		}()
+	}
+	arrived.Wait()
+	close(barrier)
+	wg.Wait()
+	s = a.PoolStats()
+	assert.Equal(t, int64(n-1), s.NewConns)
+	assert.Equal(t, int64(1), s.ReusedConns)
+	assert.Equal(t, int64(n), s.IdleConns)
+
+	// A derived Api shares the counters along with the pool.
+	assert.NoError(t, a.ForTenant("acme").DoJSON(ctx, GET, "/", nil, nil))
+	s = a.PoolStats()
+	assert.Equal(t, int64(2), s.ReusedConns)
+	assert.Equal(t, int64(n), s.IdleConns)
+}
+
+func TestPoolStatsTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Client = srv.Client()
+	for i := 0; i < 3; i++ {
+		if !assert.NoError(t, a.DoJSON(context.Background(), GET, "/", nil, nil)) {
+			return
+		}
+	}
+	s := a.PoolStats()
+	assert.Equal(t, int64(1), s.TLSHandshakes)
+	assert.Equal(t, int64(0), s.TLSHandshakeErrors)
+	var bucketed int64
+	for _, c := range s.TLSHandshakeBuckets {
+		bucketed += c
+	}
+	assert.Equal(t, int64(1), bucketed)
+	assert.Equal(t, int64(1), s.NewConns)
+	assert.Equal(t, int64(2), s.ReusedConns)
+}
+
+func TestExportPoolStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a.SetClock(clk)
+	exported := make(chan PoolStats)
+	stop := a.ExportPoolStats(time.Minute, func(s PoolStats) { exported <- s })
+	defer stop()
+	clk.BlockUntil(1)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.DoJSON(context.Background(), GET, "/", nil, nil))
+	}
+	clk.Advance(time.Minute)
+	s := <-exported
+	assert.Equal(t, int64(3), s.NewConns+s.ReusedConns)
+	assert.Equal(t, int64(0), a.PoolStats().NewConns+a.PoolStats().ReusedConns)
+
+	stop()
+	stop()
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Preparer mutates requests as they are created, e.g. setting an authorization or a tenant header.
+type Preparer interface {
+	Prepare(ctx context.Context, req *http.Request) error
+}
+
+// PreparerFunc adapts a function to the Preparer interface.
+type PreparerFunc func(ctx context.Context, req *http.Request) error
+
+// Prepare implements Preparer.
+func (f PreparerFunc) Prepare(ctx context.Context, req *http.Request) error {
+	return f(ctx, req)
+}
+
+// Priorities of the built-in preparers, see also PriorityContext, PriorityQuery and PriorityToken. Preparers added with a priority
+// of zero run after them.
+const (
+	// PriorityHeader is the priority of the preparer copying the Api's Header into requests.
+	// Headers already set by the request constructor, like Content-Type, are kept.
+	PriorityHeader = -200
+	// PriorityVersion is the priority of the preparer setting the header version, see VersionInHeader.
+	PriorityVersion = -100
+)
+
+// PreparerError reports the failure of a preparer, aborting the creation of a request.
+type PreparerError struct {
+	// Name identifies the preparer: its String() if it has one, or its type.
+	Name     string
+	Priority int
+	Err      error
+}
+
+func (e *PreparerError) Error() string {
+	return fmt.Sprintf("api: preparer %s (priority %d): %v", e.Name, e.Priority, e.Err)
+}
+
+func (e *PreparerError) Unwrap() error { return e.Err }
+
+type preparerEntry struct {
+	p        Preparer
+	name     string
+	priority int
+	// builtin marks the preparers bound to their Api, see builtinPreparers.
+	builtin bool
+}
+
+// AddPreparer adds p to the chain run by every request constructor (Request, RequestJSON, etc.,
+// including those used by the Do-style helpers) just before the options of the request are applied.
+// Preparers run by ascending priority, those of the same priority in the order they were added.
+// The context is the one of the Do-style helper call, or context.Background for the constructors.
+func (a *Api) AddPreparer(p Preparer, priority int) {
+	name := fmt.Sprintf("%T", p)
+	if s, ok := p.(fmt.Stringer); ok {
+		name = s.String()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	chain := a.chain
+	if chain == nil {
+		chain = a.builtinPreparers()
+	}
+	chain = append(chain[:len(chain):len(chain)], preparerEntry{p: p, name: name, priority: priority})
+	sort.SliceStable(chain, func(i, j int) bool {
+		return chain[i].priority < chain[j].priority
+	})
+	a.chain = chain
+}
+
+// builtinPreparers returns the chain of the built-in preparers, used until AddPreparer is called.
+func (a *Api) builtinPreparers() []preparerEntry {
+	return []preparerEntry{
+		{p: PreparerFunc(a.prepareContext), name: "api.Context", priority: PriorityContext, builtin: true},
+		{p: (*queryPreparer)(a), name: "api.Query", priority: PriorityQuery, builtin: true},
+		{p: PreparerFunc(a.prepareHeader), name: "api.Header", priority: PriorityHeader, builtin: true},
+		{p: PreparerFunc(a.prepareVersion), name: "api.HeaderVersion", priority: PriorityVersion, builtin: true},
+		{p: PreparerFunc(a.prepareToken), name: "api.TokenSource", priority: PriorityToken, builtin: true},
+	}
+}
+
+// prepare runs the preparer chain on req.
+func (a *Api) prepare(ctx context.Context, req *http.Request) error {
+	a.mu.Lock()
+	chain := a.chain
+	a.mu.Unlock()
+	if chain == nil {
+		chain = a.builtinPreparers()
+	}
+	for _, e := range chain {
+		if err := e.p.Prepare(ctx, req); err != nil {
+			return &PreparerError{Name: e.name, Priority: e.priority, Err: err}
+		}
+	}
+	return nil
+}
+
+func (a *Api) prepareHeader(_ context.Context, req *http.Request) error {
+	for k := range a.Header {
+		if _, ok := req.Header[http.CanonicalHeaderKey(k)]; !ok {
+			req.Header.Add(k, a.Header.Get(k))
+		}
+	}
+	return nil
+}
+
+func (a *Api) prepareVersion(_ context.Context, req *http.Request) error {
+	a.versionHeader(req.Header)
+	return nil
+}
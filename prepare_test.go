@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tenantKey struct{}
+
+// tenantPreparer sets the tenant header from the context.
+type tenantPreparer struct{}
+
+func (tenantPreparer) Prepare(ctx context.Context, req *http.Request) error {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	if !ok {
+		return errors.New("no tenant in context")
+	}
+	req.Header.Set("X-Tenant", tenant)
+	return nil
+}
+
+type namedPreparer string
+
+func (p namedPreparer) String() string { return string(p) }
+
+func (p namedPreparer) Prepare(ctx context.Context, req *http.Request) error {
+	return errors.New("refused")
+}
+
+func TestPreparerOrder(t *testing.T) {
+	a := MustNew("http://example.com")
+	a.Header = http.Header{"Authorization": {"Bearer api"}, "Content-Type": {"text/plain"}}
+	a.SetVersion(VersionInHeader("X-Version", "2"))
+	var order []string
+	record := func(name string) Preparer {
+		return PreparerFunc(func(ctx context.Context, req *http.Request) error {
+			order = append(order, name+" auth="+req.Header.Get("Authorization")+" version="+req.Header.Get("X-Version"))
+			req.Header.Set("X-Last", name)
+			return nil
+		})
+	}
+	a.AddPreparer(record("late"), 10)
+	a.AddPreparer(record("first zero"), 0)
+	a.AddPreparer(record("between"), -150)
+	a.AddPreparer(record("second zero"), 0)
+	a.AddPreparer(record("early"), -300)
+
+	req, err := a.RequestJSON(POST, "/items", map[string]int{"a": 1}, WithHeader("X-Last", "option"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{
+		"early auth= version=",
+		"between auth=Bearer api version=",
+		"first zero auth=Bearer api version=2",
+		"second zero auth=Bearer api version=2",
+		"late auth=Bearer api version=2",
+	}, order)
+	// Options are applied after the preparers, and the constructor's Content-Type is kept.
+	assert.Equal(t, "option", req.Header.Get("X-Last"))
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+	// Every constructor runs the chain.
+	for _, build := range []func() (*http.Request, error){
+		func() (*http.Request, error) { return a.Request(GET, "/items", nil) },
+		func() (*http.Request, error) { return a.RequestBytes(PUT, "/items", "text/plain", nil) },
+		func() (*http.Request, error) { return a.RequestReader(PUT, "/items", "text/plain", nil) },
+		func() (*http.Request, error) { return a.RequestSOAP("/items", "Get", nil) },
+	} {
+		order = nil
+		req, err := build()
+		if assert.NoError(t, err) {
+			assert.Len(t, order, 5)
+			assert.Equal(t, "late", req.Header.Get("X-Last"))
+		}
+	}
+}
+
+func TestPreparerError(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte(`"` + r.Header.Get("X-Tenant") + `"`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.AddPreparer(tenantPreparer{}, 0)
+
+	_, err := a.Request(GET, "/", nil)
+	assert.EqualError(t, err, "api: preparer api.tenantPreparer (priority 0): no tenant in context")
+	var pe *PreparerError
+	if assert.True(t, errors.As(err, &pe)) {
+		assert.Equal(t, "api.tenantPreparer", pe.Name)
+	}
+
+	// The Do-style helpers pass their context to the preparers.
+	var tenant string
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	assert.NoError(t, a.Get(ctx, "/", nil, &tenant))
+	assert.Equal(t, "acme", tenant)
+	assert.Error(t, a.Get(context.Background(), "/", nil, &tenant))
+	assert.Equal(t, 1, hits)
+
+	// A failure stops the chain.
+	var ran bool
+	a.AddPreparer(namedPreparer("signer"), -1)
+	a.AddPreparer(PreparerFunc(func(ctx context.Context, req *http.Request) error {
+		ran = true
+		return nil
+	}), 5)
+	err = a.Post(ctx, "/", map[string]int{}, nil)
+	assert.EqualError(t, err, "api: preparer signer (priority -1): refused")
+	assert.False(t, ran)
+	assert.Equal(t, 1, hits)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// PreparedRequest is a request built by an Api for the caller to send with its own client,
+// see Prepare. Its response is handled by HandleResponse just like the Do-style helpers would.
+type PreparedRequest struct {
+	// Request is the request to send, with the Header, the preparers, the defaults and the
+	// options of the call applied.
+	Request *http.Request
+
+	a        *Api
+	c        *call
+	prepared time.Time
+}
+
+// Prepare creates the request for the resource like DoJSON would, without sending it, for the
+// callers executing the requests with their own instrumented clients:
+//
+//	p, err := api.Prepare(ctx, a, api.GET, "/users/1", api.WithMeta(&meta))
+//	...
+//	resp, err := client.Do(p.Request)
+//	...
+//	err = p.HandleResponse(resp, &user)
+//
+// The query args are given with WithQuery. See PrepareRequest for the requests with a body.
+func Prepare(ctx context.Context, a *Api, method Method, resource string, opts ...Option) (*PreparedRequest, error) {
+	req, err := a.callRequest(ctx, method, resource, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	return PrepareRequest(ctx, a, req, resource, opts...)
+}
+
+// PrepareRequest is like Prepare for a request created by the Api, e.g. by RequestJSON. The
+// resource is the one the request was created for, used by the resource-scoped settings like
+// SetStatusTunnel; the URL path of the request if empty.
+func PrepareRequest(ctx context.Context, a *Api, req *http.Request, resource string, opts ...Option) (*PreparedRequest, error) {
+	if resource == "" {
+		resource = req.URL.Path
+	}
+	c := a.newCallFor(resource, opts)
+	if c.err != nil {
+		return nil, c.err
+	}
+	if err := a.prepareCall(ctx, c, req); err != nil {
+		return nil, err
+	}
+	return &PreparedRequest{Request: req, a: a, c: c, prepared: a.clock().Now()}, nil
+}
+
+// HandleResponse applies the response handling of the Do-style helpers to resp, the response to
+// the prepared request obtained by the caller, decoding its JSON body into out and closing it:
+// the deprecation signals, ResponseMeta, the body codec and transforms, the status errors and
+// their mapping, the status tunnel, the validation, the content type check and the decoding
+// options like WithDecodeStrict. A nil out discards the body but still checks the status.
+// The duration of ResponseMeta is the time since Prepare. Sending the request, with the retries,
+// the cache and the timeouts of the Api, is the caller's concern.
+func (p *PreparedRequest) HandleResponse(resp *http.Response, out interface{}) error {
+	a, c, req := p.a, p.c, p.Request
+	if resp.Request == nil {
+		resp.Request = req
+	}
+	a.checkDeprecation(resp)
+	if c.meta != nil {
+		c.meta.fill(resp, p.prepared, a.clock().Now())
+		c.meta.RequestSize = requestSize(req, nil)
+	}
+	resp.Body = newLengthBody(req, resp)
+	if err := a.decodeResponseBody(resp); err != nil {
+		return err
+	}
+	resp.Body = c.wrapBody(resp)
+	if err := a.check(c, resp); err != nil {
+		return err
+	}
+	if err := a.validate(c, req, resp); err != nil {
+		return err
+	}
+	return decodeResponse(c, req, resp, out)
+}
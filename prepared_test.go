@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareHandleResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/1":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Total-Count", "1")
+			w.Write([]byte(`{"id": 1, "name": "` + r.Header.Get("X-Client") + r.URL.Query().Get("fields") + `"}`))
+		case "/users/2":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "NO_USER"}}`))
+		case "/login":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><title>Sign in</title></html>`))
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Header = http.Header{"X-Client": {"go"}}
+	a.MapError("NO_USER", ErrNotFound)
+	ctx := context.Background()
+
+	type user struct {
+		ID   int
+		Name string
+	}
+	var results []bool
+	for _, resource := range []string{"/users/1", "/users/2", "/login", "/empty"} {
+		var doOut, prepOut user
+		var doMeta, prepMeta ResponseMeta
+		doErr := a.Get(ctx, resource, nil, &doOut, WithMeta(&doMeta), WithQuery("fields", "+name"))
+
+		p, err := Prepare(ctx, a, GET, resource, WithMeta(&prepMeta), WithQuery("fields", "+name"))
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp, err := srv.Client().Do(p.Request)
+		if !assert.NoError(t, err) {
+			return
+		}
+		prepErr := p.HandleResponse(resp, &prepOut)
+
+		if doErr != nil {
+			assert.EqualError(t, prepErr, doErr.Error(), resource)
+			assert.IsType(t, doErr, prepErr, resource)
+			assert.Equal(t, errors.Is(doErr, ErrNotFound), errors.Is(prepErr, ErrNotFound), resource)
+		} else {
+			assert.NoError(t, prepErr, resource)
+		}
+		assert.Equal(t, doOut, prepOut, resource)
+		assert.Equal(t, doMeta.StatusCode, prepMeta.StatusCode, resource)
+		assert.Equal(t, doMeta.TotalCount, prepMeta.TotalCount, resource)
+		assert.Equal(t, doMeta.NoContent, prepMeta.NoContent, resource)
+		results = append(results, doErr == nil)
+	}
+	assert.Equal(t, []bool{true, false, false, true}, results)
+}
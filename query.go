@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// RawQueryValue is a query parameter value the caller has percent-encoded already, which
+// WithRawQuery sends verbatim. The values of url.Values are always encoded when the request is
+// built, so "a%2Fb" goes out as "a%252Fb" and "a/b" as "a%2Fb"; a RawQueryValue of "a%2Fb" goes
+// out as "a%2Fb" and one of "a/b" as "a/b". It's the caller's promise that the value is valid in
+// a query: one with a space, "&", "#" or an invalid escape fails the call.
+type RawQueryValue string
+
+func (v RawQueryValue) check() error {
+	s := string(v)
+	if strings.ContainsAny(s, " &#") {
+		return fmt.Errorf("api: invalid raw query value %q", s)
+	}
+	if _, err := url.QueryUnescape(s); err != nil {
+		return fmt.Errorf("api: invalid raw query value %q: %w", s, err)
+	}
+	return nil
+}
+
+// WithRawQuery adds the query parameter key with the pre-encoded value to the request URL,
+// after the args and keeping the parameters already there. The key is encoded as usual.
+func WithRawQuery(key string, value RawQueryValue) Option {
+	return func(c *call) {
+		if err := value.check(); err != nil {
+			c.fail(err)
+			return
+		}
+		c.prepare = append(c.prepare, func(req *http.Request) error {
+			if req.URL.RawQuery != "" {
+				req.URL.RawQuery += "&"
+			}
+			req.URL.RawQuery += url.QueryEscape(key) + "=" + string(value)
+			return nil
+		})
+	}
+}
+
+// QueryOf returns the query parameters of req, parsed again from its raw query, so changing them
+// doesn't affect req. The pairs that can't be decoded are skipped.
+func QueryOf(req *http.Request) url.Values {
+	if req.URL == nil {
+		return url.Values{}
+	}
+	q, _ := url.ParseQuery(req.URL.RawQuery)
+	return q
+}
+
+// AddQuery adds the query parameter key with value to the URL of req, after the parameters
+// already there. Unlike encoding req.URL.Query() again, the other pairs are kept as they are, in
+// order and with their encoding, e.g. the values of WithRawQuery, so the helpers are safe for the
+// preparers signing or tagging requests already built; the path and its RawPath aren't touched.
+// The key and value are encoded like those of url.Values.
+func AddQuery(req *http.Request, key, value string) {
+	q := req.URL.RawQuery
+	if q != "" {
+		q += "&"
+	}
+	req.URL.RawQuery = q + url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
+
+// SetQuery sets the query parameter key of the URL of req to value, in place of its first pair
+// and removing the others, or after the parameters already there if it has none. The other pairs
+// are kept as they are, see AddQuery.
+func SetQuery(req *http.Request, key, value string) {
+	setQuery(req, key, []string{value})
+}
+
+// DelQuery removes the query parameter key from the URL of req, keeping the other pairs as they
+// are, see AddQuery.
+func DelQuery(req *http.Request, key string) {
+	setQuery(req, key, nil)
+}
+
+// setQuery sets the query parameter key of req to values, or removes it if there are none.
+func setQuery(req *http.Request, key string, values []string) {
+	var b strings.Builder
+	b.Grow(len(req.URL.RawQuery))
+	set := false
+	put := func(pair string) {
+		if b.Len() > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(pair)
+	}
+	putValues := func() {
+		for _, v := range values {
+			put(url.QueryEscape(key) + "=" + url.QueryEscape(v))
+		}
+		set = true
+	}
+	for _, p := range strings.Split(req.URL.RawQuery, "&") {
+		if p == "" {
+			continue
+		}
+		k, _, _ := strings.Cut(p, "=")
+		if uk, err := url.QueryUnescape(k); err == nil {
+			k = uk
+		}
+		switch {
+		case k != key:
+			put(p)
+		case !set:
+			putValues()
+		}
+	}
+	if !set {
+		putValues()
+	}
+	req.URL.RawQuery = b.String()
+}
+
+// SetQueryLint makes the request constructors and the Do-style helpers report the args values
+// that look percent-encoded already, like "a%2Fb", which would go out double-encoded: report is
+// invoked with their key and value, and the request is still built. It's a debugging aid, e.g.
+// for logging the suspects in tests; values meant to be sent as they are go in WithRawQuery.
+// A nil report disables it.
+func (a *Api) SetQueryLint(report func(key, value string)) {
+	a.mu.Lock()
+	a.queryLint = report
+	a.mu.Unlock()
+}
+
+// lintArgs reports the args values that look encoded to the report of SetQueryLint.
+func (a *Api) lintArgs(args url.Values) {
+	if len(args) == 0 {
+		return
+	}
+	a.mu.Lock()
+	report := a.queryLint
+	a.mu.Unlock()
+	if report == nil {
+		return
+	}
+	for k, vs := range args {
+		for _, v := range vs {
+			if looksEncoded(v) {
+				report(k, v)
+			}
+		}
+	}
+}
+
+// looksEncoded reports whether s has a percent escape like "%2F" and is a valid encoding as a whole.
+func looksEncoded(s string) bool {
+	for i := 0; i+2 < len(s); i++ {
+		if s[i] == '%' && isHex(s[i+1]) && isHex(s[i+2]) {
+			_, err := url.QueryUnescape(s)
+			return err == nil
+		}
+	}
+	return false
+}
+
+// PriorityQuery is the priority of the preparer normalizing the query, see SetQueryNormalization.
+const PriorityQuery = -250
+
+// QueryNormalization configures the normalization of the query of the requests of an Api, see
+// SetQueryNormalization.
+type QueryNormalization struct {
+	// KeepOrder names the parameters whose values are meaningful in order, which aren't sorted.
+	KeepOrder []string
+}
+
+// SetQueryNormalization makes the requests of the Api carry their query parameters sorted by key,
+// then the values of every multi-valued parameter sorted too, except those of the parameters named
+// by p.KeepOrder, so that building ?ids=3&ids=1&ids=2 from values in any order gives the same URL,
+// e.g. for a CDN caching by URL. The query is normalized by a preparer of PriorityQuery, after the
+// query of ContextQuery is added and before the preparers signing the requests, and once more
+// before sending, for the parameters added by the options like WithQuery, before the keys of the
+// caches are computed. The pairs are moved as they are, so the values of WithRawQuery keep their
+// encoding. A nil p disables the normalization.
+func (a *Api) SetQueryNormalization(p *QueryNormalization) {
+	if p == nil {
+		a.querySort.Store(nil)
+		return
+	}
+	s := &querySort{keep: make(map[string]bool, len(p.KeepOrder))}
+	for _, name := range p.KeepOrder {
+		s.keep[name] = true
+	}
+	a.querySort.Store(s)
+}
+
+// querySort is the state of SetQueryNormalization.
+type querySort struct {
+	keep map[string]bool
+}
+
+// queryPreparer is the Preparer normalizing the query of the requests of its Api. Unlike a method
+// value, converting the *Api to it doesn't allocate.
+type queryPreparer Api
+
+func (q *queryPreparer) Prepare(_ context.Context, req *http.Request) error {
+	(*Api)(q).normalizeQuery(req)
+	return nil
+}
+
+// normalizeQuery sorts the query of req according to SetQueryNormalization.
+func (a *Api) normalizeQuery(req *http.Request) {
+	s := a.querySort.Load()
+	if s == nil || req.URL == nil || !strings.Contains(req.URL.RawQuery, "&") {
+		return
+	}
+	req.URL.RawQuery = s.sort(req.URL.RawQuery)
+}
+
+// sort returns the pairs of the raw query sorted by key and value, the values of the keys of keep
+// staying in order.
+func (s *querySort) sort(raw string) string {
+	type pair struct {
+		raw, key, value string
+	}
+	var pairs []pair
+	for _, p := range strings.Split(raw, "&") {
+		if p == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(p, "=")
+		if uk, err := url.QueryUnescape(k); err == nil {
+			k = uk
+		}
+		if uv, err := url.QueryUnescape(v); err == nil {
+			v = uv
+		}
+		pairs = append(pairs, pair{raw: p, key: k, value: v})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return !s.keep[pairs[i].key] && pairs[i].value < pairs[j].value
+	})
+	var b strings.Builder
+	b.Grow(len(raw))
+	for i, p := range pairs {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(p.raw)
+	}
+	return b.String()
+}
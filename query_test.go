@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawQueryValue(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.RawQuery)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		args url.Values
+		opts []Option
+		want string
+	}{
+		{url.Values{"path": {"a/b"}}, nil, "path=a%2Fb"},
+		{url.Values{"path": {"a%2Fb"}}, nil, "path=a%252Fb"},
+		{nil, []Option{WithRawQuery("path", "a%2Fb")}, "path=a%2Fb"},
+		{nil, []Option{WithRawQuery("path", "a/b")}, "path=a/b"},
+		{url.Values{"q": {"x y"}}, []Option{WithRawQuery("sig", "k%3D%3D"), WithRawQuery("sig", "a+b")},
+			"q=x+y&sig=k%3D%3D&sig=a+b"},
+		{nil, []Option{WithQuery("page", "2"), WithRawQuery("my key", "%7E")}, "page=2&my+key=%7E"},
+	} {
+		req, err := a.Request(GET, "/items", tc.args, tc.opts...)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, tc.want, req.URL.RawQuery)
+		assert.Equal(t, srv.URL+"/items?"+tc.want, req.URL.String())
+	}
+
+	// The Do-style helpers send the bytes as they are too.
+	assert.NoError(t, a.Get(ctx, "/items", url.Values{"id": {"1"}}, nil, WithRawQuery("path", "a%2Fb")))
+	assert.Equal(t, []string{"id=1&path=a%2Fb"}, got)
+
+	for _, v := range []RawQueryValue{"a b", "a&b=c", "a#b", "a%zz"} {
+		_, err := a.Request(GET, "/items", nil, WithRawQuery("path", v))
+		assert.Error(t, err, v)
+	}
+}
+
+func TestQueryLint(t *testing.T) {
+	a := MustNew("http://example.com")
+	var reported []string
+	a.SetQueryLint(func(key, value string) {
+		reported = append(reported, key+"="+value)
+	})
+	args := url.Values{"path": {"a%2Fb", "a/b"}, "discount": {"50%off", "100%"}, "name": {"%E2%82%AC"}}
+	_, err := a.Request(GET, "/items", args)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"path=a%2Fb", "name=%E2%82%AC"}, reported)
+
+	reported = nil
+	a.SetQueryLint(nil)
+	_, err = a.Request(GET, "/items", args)
+	assert.NoError(t, err)
+	assert.Empty(t, reported)
+}
+
+func TestSetQueryNormalization(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.RawQuery)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetQueryNormalization(&QueryNormalization{KeepOrder: []string{"sort"}})
+	var signed []string
+	a.AddPreparer(PreparerFunc(func(_ context.Context, req *http.Request) error {
+		signed = append(signed, req.URL.RawQuery)
+		return nil
+	}), 0)
+
+	// Permuted values give the same URL, the order-sensitive parameter aside.
+	var urls []string
+	for _, ids := range [][]string{{"3", "1", "2"}, {"2", "3", "1"}, {"1", "2", "3"}} {
+		req, err := a.Request(GET, "/items", url.Values{"ids": ids, "sort": {"name", "-date"}, "q": {"x y"}})
+		if !assert.NoError(t, err) {
+			return
+		}
+		urls = append(urls, req.URL.String())
+	}
+	want := srv.URL + "/items?ids=1&ids=2&ids=3&q=x+y&sort=name&sort=-date"
+	assert.Equal(t, []string{want, want, want}, urls)
+	assert.Equal(t, "ids=1&ids=2&ids=3&q=x+y&sort=name&sort=-date", signed[0])
+
+	// The parameters of the options are normalized before sending, keeping the raw values as they are.
+	opts := []Option{WithQuery("tags", "b", "a"), WithRawQuery("tags", "%7E"), WithRawQuery("sort", "z")}
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/items", url.Values{"sort": {"y"}}, nil, opts...))
+	assert.Equal(t, []string{"sort=y&sort=z&tags=a&tags=b&tags=%7E"}, got)
+
+	tmpl := a.Template(GET, "/items/{id}")
+	req, err := tmpl.Build(map[string]string{"id": "1"}, url.Values{"ids": {"b", "a"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "ids=a&ids=b", req.URL.RawQuery)
+	}
+
+	a.SetQueryNormalization(nil)
+	req, err = a.Request(GET, "/items", url.Values{"ids": {"3", "1"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "ids=3&ids=1", req.URL.RawQuery)
+	}
+}
+
+func TestQueryHelpers(t *testing.T) {
+	a := MustNew("http://example.com")
+	req, err := a.Request(GET, "/files", url.Values{"q": {"a b"}}, WithRawQuery("sig", "x%2Fy"), WithRawQuery("path", "a/b"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "q=a+b&sig=x%2Fy&path=a/b", req.URL.RawQuery)
+	req.URL.Path, req.URL.RawPath = "/files/a/b", "/files/a%2Fb"
+
+	// The pairs not changed keep their encoding and order, nor is the path encoded again.
+	AddQuery(req, "tag", "c&d")
+	assert.Equal(t, "q=a+b&sig=x%2Fy&path=a/b&tag=c%26d", req.URL.RawQuery)
+	SetQuery(req, "sig", "x/y z")
+	assert.Equal(t, "q=a+b&sig=x%2Fy+z&path=a/b&tag=c%26d", req.URL.RawQuery)
+	AddQuery(req, "q", "2")
+	SetQuery(req, "q", "%41")
+	assert.Equal(t, "q=%2541&sig=x%2Fy+z&path=a/b&tag=c%26d", req.URL.RawQuery)
+	DelQuery(req, "path")
+	SetQuery(req, "new", "1")
+	assert.Equal(t, "q=%2541&sig=x%2Fy+z&tag=c%26d&new=1", req.URL.RawQuery)
+	assert.Equal(t, "/files/a%2Fb", req.URL.EscapedPath())
+	assert.Equal(t, url.Values{"q": {"%41"}, "sig": {"x/y z"}, "tag": {"c&d"}, "new": {"1"}}, QueryOf(req))
+
+	// The keys are matched decoded, and QueryOf is a copy.
+	DelQuery(req, "sig")
+	AddQuery(req, "a[]", "1")
+	DelQuery(req, "a[]")
+	q := QueryOf(req)
+	q.Set("tag", "changed")
+	assert.Equal(t, "q=%2541&tag=c%26d&new=1", req.URL.RawQuery)
+
+	// The normalization of the Api keeps sorting them before sending.
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RawQuery
+	}))
+	defer srv.Close()
+	b := MustNew(srv.URL)
+	b.SetQueryNormalization(&QueryNormalization{})
+	b.AddPreparer(PreparerFunc(func(ctx context.Context, req *http.Request) error {
+		AddQuery(req, "b", "1")
+		return nil
+	}), 0)
+	if assert.NoError(t, b.Get(context.Background(), "/items", url.Values{"c": {"1"}}, nil, WithRawQuery("a", "x%2Fy"))) {
+		assert.Equal(t, "a=x%2Fy&b=1&c=1", got)
+	}
+}
+
+func TestQueryOptionsKeepRawValues(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RawQuery
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	// The options writing the query after WithRawQuery leave its value as it is.
+	if assert.NoError(t, a.Get(context.Background(), "/items", nil, nil, WithRawQuery("sig", "a/b%2Fc"), WithQuery("z", "1"))) {
+		assert.Equal(t, "sig=a/b%2Fc&z=1", got)
+	}
+	var out fieldsOwner
+	if assert.NoError(t, a.Get(context.Background(), "/owner", nil, &out, WithRawQuery("sig", "a/b%2Fc"), WithFields(&out))) {
+		assert.Equal(t, "sig=a/b%2Fc&fields=email%2Cname", got)
+	}
+	ctx := ContextQuery(context.Background(), url.Values{"tenant": {"acme"}})
+	if assert.NoError(t, a.Get(ctx, "/items", nil, nil, WithRawQuery("sig", "a/b%2Fc"))) {
+		assert.Equal(t, "tenant=acme&sig=a/b%2Fc", got)
+	}
+}
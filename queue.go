@@ -0,0 +1,372 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueued is returned for the calls put in the write queue of SetWriteQueue instead of being sent,
+// since the Api is offline. They're sent by Flush.
+var ErrQueued = errors.New("api: call queued while offline")
+
+// QueuedCall is a call kept in a WriteQueue until it's sent by Flush.
+type QueuedCall struct {
+	// Time is when the call was queued.
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	URL    string    `json:"url"`
+	// Header is the header of the request without the credentials, which are set again by
+	// the Api when the call is sent. The Idempotency-Key is kept, so that the server can tell
+	// a call sent again after a failed Flush.
+	Header http.Header `json:"header,omitempty"`
+	// Body is the encoded request body.
+	Body []byte `json:"body,omitempty"`
+}
+
+// WriteQueue keeps the calls queued while an Api is offline, in order, see SetWriteQueue and FileQueue.
+type WriteQueue interface {
+	// Push adds the call at the end of the queue.
+	Push(call QueuedCall) error
+	// Calls returns the calls of the queue, in order.
+	Calls() ([]QueuedCall, error)
+	// Remove removes the first n calls of the queue.
+	Remove(n int) error
+}
+
+// WriteQueuePolicy configures SetWriteQueue.
+type WriteQueuePolicy struct {
+	// Methods are the methods of the queued calls, POST, PUT, PATCH and DELETE if empty.
+	Methods []Method
+	// Failures is the number of consecutive calls failing to connect after which the Api goes
+	// offline, 3 if zero. If negative, the Api only goes offline with SetOffline.
+	Failures int
+	// Credentials removes the credentials from the header of a queued call. If nil,
+	// Authorization, Proxy-Authorization and Cookie are removed.
+	Credentials func(header http.Header)
+}
+
+// writeQueue is the state of SetWriteQueue.
+type writeQueue struct {
+	queue    WriteQueue
+	policy   WriteQueuePolicy
+	failures atomic.Int32
+	// flushing serializes the calls of Flush.
+	flushing sync.Mutex
+}
+
+const (
+	online int32 = iota
+	// offlineDetected is the state entered after WriteQueuePolicy.Failures, left on the next response.
+	offlineDetected
+	// offlineSet is the state set by SetOffline, left with SetOffline or Flush.
+	offlineSet
+)
+
+// SetWriteQueue makes the Do-style helpers put their calls made with the methods of p in q while
+// the Api is offline, returning ErrQueued, rather than sending them; Flush sends them later,
+// in order, once the connectivity is back. The Api goes offline after p.Failures consecutive
+// calls failed to connect, the write failing last being queued too, and online again as soon as
+// a response arrives, or when set by SetOffline. The other calls are sent as usual.
+//
+// The calls are queued with their encoded body, so those with a body that isn't replayable fail
+// instead. Their credentials are removed, and set again by the Api on Flush.
+// A nil q disables the queue and leaves the queued calls in it; a nil p uses the defaults of
+// WriteQueuePolicy.
+func (a *Api) SetWriteQueue(q WriteQueue, p *WriteQueuePolicy) {
+	if q == nil {
+		a.queue.Store(nil)
+		return
+	}
+	wq := &writeQueue{queue: q}
+	if p != nil {
+		wq.policy = *p
+	}
+	if len(wq.policy.Methods) == 0 {
+		wq.policy.Methods = []Method{POST, PUT, PATCH, DELETE}
+	}
+	if wq.policy.Failures == 0 {
+		wq.policy.Failures = 3
+	}
+	a.queue.Store(wq)
+}
+
+// SetOffline sets whether the Api is offline, making the calls of SetWriteQueue queued until
+// SetOffline(false) or Flush.
+func (a *Api) SetOffline(offline bool) {
+	if offline {
+		a.offline.Store(offlineSet)
+	} else {
+		a.offline.Store(online)
+	}
+}
+
+// Offline reports whether the Api is offline, see SetWriteQueue.
+func (a *Api) Offline() bool {
+	return a.offline.Load() != online
+}
+
+// FlushReport tells what Flush sent.
+type FlushReport struct {
+	// Sent are the calls sent successfully, in order, and removed from the queue.
+	Sent []QueuedCall
+	// Remaining is the number of calls left in the queue.
+	Remaining int
+}
+
+// Flush sends the calls of the write queue of SetWriteQueue in order, with the credentials and
+// the options of the Api and opts, removing each one from the queue once its response arrived.
+// The calls are retried as usual, and the first one failing stops the flush with its error,
+// leaving it first in the queue with the ones following it. The Api is online again after a
+// complete flush. The calls queued while flushing are sent too.
+func (a *Api) Flush(ctx context.Context, opts ...Option) (FlushReport, error) {
+	var report FlushReport
+	wq := a.queue.Load()
+	if wq == nil {
+		return report, nil
+	}
+	wq.flushing.Lock()
+	defer wq.flushing.Unlock()
+	for {
+		calls, err := wq.queue.Calls()
+		if err != nil {
+			return report, fmt.Errorf("api: write queue: %w", err)
+		}
+		if len(calls) == 0 {
+			break
+		}
+		for i, qc := range calls {
+			if err := a.replay(ctx, qc, opts); err != nil {
+				report.Remaining = len(calls) - i
+				return report, err
+			}
+			if err := wq.queue.Remove(1); err != nil {
+				report.Remaining = len(calls) - i
+				return report, fmt.Errorf("api: write queue: %w", err)
+			}
+			report.Sent = append(report.Sent, qc)
+		}
+	}
+	wq.failures.Store(0)
+	a.offline.Store(online)
+	return report, nil
+}
+
+// replay sends the queued call qc.
+func (a *Api) replay(ctx context.Context, qc QueuedCall, opts []Option) error {
+	req, err := http.NewRequestWithContext(ctx, qc.Method, qc.URL, nil)
+	if err != nil {
+		return err
+	}
+	for k, vs := range qc.Header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	setBytesBody(req, qc.Body)
+	if err := a.prepare(ctx, req); err != nil {
+		return err
+	}
+	c := a.newCall(opts)
+	c.queued = true
+	resp, err := a.send(ctx, c, req)
+	if err != nil {
+		return err
+	}
+	return drainClose(resp.Body)
+}
+
+// send sends req via Api.send as the call c, queuing it if the Api is offline.
+func (wq *writeQueue) send(ctx context.Context, a *Api, c *call, req *http.Request) (*http.Response, error) {
+	c.queued = true
+	var qc *QueuedCall
+	if wq.queues(req.Method) {
+		qc = wq.capture(a, c, req)
+		if a.Offline() {
+			return nil, wq.push(qc, req)
+		}
+	}
+	resp, err := a.send(ctx, c, req)
+	var se *StatusError
+	switch {
+	case err == nil || errors.As(err, &se):
+		wq.failures.Store(0)
+		a.offline.CompareAndSwap(offlineDetected, online)
+	case ctx.Err() == nil && connFailure(err):
+		if n := wq.failures.Add(1); wq.policy.Failures > 0 && int(n) >= wq.policy.Failures {
+			a.offline.CompareAndSwap(online, offlineDetected)
+		}
+		if qc != nil && a.Offline() {
+			return nil, wq.push(qc, req)
+		}
+	}
+	return resp, err
+}
+
+func (wq *writeQueue) queues(method string) bool {
+	for _, m := range wq.policy.Methods {
+		if m.String() == method {
+			return true
+		}
+	}
+	return false
+}
+
+// capture returns the queued call of req made as the call c, nil if its body isn't replayable.
+// The preparers of the options of c are applied to a copy of req, so that the call is queued with
+// the headers they set, like an Idempotency-Key.
+func (wq *writeQueue) capture(a *Api, c *call, req *http.Request) *QueuedCall {
+	data, ok := replayBody(req)
+	if !ok {
+		return nil
+	}
+	r := req.Clone(req.Context())
+	setBytesBody(r, data)
+	for _, prepare := range c.prepare {
+		if err := prepare(r); err != nil {
+			return nil
+		}
+	}
+	if data, ok = replayBody(r); !ok {
+		return nil
+	}
+	qc := &QueuedCall{Time: a.clock().Now(), Method: r.Method, URL: r.URL.String(), Header: r.Header, Body: data}
+	if wq.policy.Credentials != nil {
+		wq.policy.Credentials(qc.Header)
+	} else {
+		for _, k := range []string{"Authorization", "Proxy-Authorization", "Cookie"} {
+			qc.Header.Del(k)
+		}
+	}
+	return qc
+}
+
+// replayBody returns the bytes of the body of req, reporting whether it can be read again.
+func replayBody(req *http.Request) ([]byte, bool) {
+	if data, ok := encodedBody(req); ok {
+		return data, true
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	return data, err == nil
+}
+
+// push queues qc, the call of req.
+func (wq *writeQueue) push(qc *QueuedCall, req *http.Request) error {
+	if qc == nil {
+		return fmt.Errorf("api: %s %s can't be queued while offline: its body isn't replayable", req.Method, req.URL.Redacted())
+	}
+	if err := wq.queue.Push(*qc); err != nil {
+		return fmt.Errorf("api: write queue: %w", err)
+	}
+	return ErrQueued
+}
+
+// connFailure reports whether err is a failure to reach the server, rather than an error response.
+func connFailure(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &opErr) || errors.As(err, &dnsErr)
+}
+
+// FileQueue is a WriteQueue keeping the calls in a file as JSON lines, so they survive a restart.
+// It's safe for concurrent use, but not for sharing the file between processes.
+type FileQueue struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileQueue returns the queue of the file at path, created by the first call pushed.
+func NewFileQueue(path string) *FileQueue {
+	return &FileQueue{path: path}
+}
+
+// Push implements WriteQueue, appending the call with a single write.
+func (q *FileQueue) Push(call QueuedCall) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(call); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Calls implements WriteQueue.
+func (q *FileQueue) Calls() ([]QueuedCall, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.read()
+}
+
+func (q *FileQueue) read() ([]QueuedCall, error) {
+	f, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var calls []QueuedCall
+	dec := json.NewDecoder(f)
+	for {
+		var call QueuedCall
+		if err := dec.Decode(&call); err == io.EOF {
+			return calls, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", q.path, err)
+		}
+		calls = append(calls, call)
+	}
+}
+
+// Remove implements WriteQueue, replacing the file with one holding the remaining calls.
+func (q *FileQueue) Remove(n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	calls, err := q.read()
+	if err != nil {
+		return err
+	}
+	if n > len(calls) {
+		n = len(calls)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, call := range calls[n:] {
+		if err := enc.Encode(call); err != nil {
+			return err
+		}
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteQueue(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	a := MustNew("http://" + addr)
+	a.Header = http.Header{"Authorization": {"Bearer t"}}
+	a.SetWriteQueue(NewFileQueue(path), &WriteQueuePolicy{Failures: 1})
+	ctx := context.Background()
+
+	// The first write fails to connect and is queued, the next ones are queued right away.
+	err = a.Post(ctx, "/items", map[string]int{"n": 1}, nil, WithHeader("Idempotency-Key", "k1"))
+	assert.True(t, errors.Is(err, ErrQueued), "%v", err)
+	assert.True(t, a.Offline())
+	assert.ErrorIs(t, a.Put(ctx, "/items/2", map[string]int{"n": 2}, nil, WithHeader("Idempotency-Key", "k2")), ErrQueued)
+	assert.ErrorIs(t, a.Delete(ctx, "/items/3", nil), ErrQueued)
+	// The reads are still sent.
+	err = a.DoJSON(ctx, GET, "/items", nil, nil)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrQueued))
+
+	data, err := os.ReadFile(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+	assert.NotContains(t, string(data), "Bearer t")
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("the address was taken: %v", err)
+	}
+	var mu sync.Mutex
+	var got []string
+	fail := true
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, r.Method+" "+r.URL.Path+" "+r.Header.Get("Idempotency-Key")+" "+r.Header.Get("Authorization")+" "+string(body))
+		if r.URL.Path == "/items/2" && fail {
+			fail = false
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	// The flush stops on the failed call, leaving it queued with the next one.
+	report, err := a.Flush(ctx)
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se), "%v", err) {
+		assert.Equal(t, http.StatusConflict, se.Code)
+	}
+	if assert.Len(t, report.Sent, 1) {
+		assert.Equal(t, "POST", report.Sent[0].Method)
+	}
+	assert.Equal(t, 2, report.Remaining)
+	calls, err := NewFileQueue(path).Calls()
+	assert.NoError(t, err)
+	assert.Len(t, calls, 2)
+
+	report, err = a.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, report.Sent, 2)
+	assert.Equal(t, 0, report.Remaining)
+	assert.False(t, a.Offline())
+	assert.Equal(t, []string{
+		`POST /items k1 Bearer t {"n":1}`,
+		`PUT /items/2 k2 Bearer t {"n":2}`,
+		`PUT /items/2 k2 Bearer t {"n":2}`,
+		`DELETE /items/3  Bearer t `,
+	}, got)
+	calls, err = NewFileQueue(path).Calls()
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+
+	// Once online, the writes are sent.
+	assert.NoError(t, a.Post(ctx, "/items", map[string]int{"n": 4}, nil))
+	assert.Len(t, got, 5)
+}
+
+func TestWriteQueueSetOffline(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetWriteQueue(NewFileQueue(filepath.Join(t.TempDir(), "queue.jsonl")), &WriteQueuePolicy{Failures: -1})
+	ctx := context.Background()
+
+	a.SetOffline(true)
+	assert.ErrorIs(t, a.Post(ctx, "/items", map[string]int{"n": 1}, nil), ErrQueued)
+	// A response doesn't end the offline mode set explicitly.
+	assert.NoError(t, a.DoJSON(ctx, GET, "/items", nil, nil))
+	assert.True(t, a.Offline())
+	assert.ErrorIs(t, a.Post(ctx, "/items", map[string]int{"n": 2}, nil), ErrQueued)
+	body := &oneShotReader{Reader: strings.NewReader("raw")}
+	req, err := a.RequestReader(POST, "/items", "text/plain", body)
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = a.sendJSON(ctx, req, "/items", nil, nil)
+	assert.ErrorContains(t, err, "isn't replayable")
+	assert.Equal(t, 1, calls)
+
+	report, err := a.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, report.Sent, 2)
+	assert.False(t, a.Offline())
+	assert.Equal(t, 3, calls)
+}
+
+// oneShotReader is a reader that can't seek, making the body of a request not replayable.
+type oneShotReader struct {
+	io.Reader
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package api
+
+// raceEnabled is set when testing with the race detector, whose bookkeeping skews memory measurements.
+const raceEnabled = true
@@ -0,0 +1,302 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redactor masks the secrets in the traffic the package records or logs: the values of the
+// matching headers, query parameters and JSON body fields are replaced with placeholders telling
+// their length, e.g. "<redacted:7 chars>". HARRecorder and the call logs route their output
+// through the Redactor of the Api, see SetRedactor; its methods are there for the output of
+// other tools. Start from DefaultRedactor to add rules rather than replace the defaults:
+//
+//	r := api.DefaultRedactor()
+//	r.Fields = append(r.Fields, "card.number")
+//	a.SetRedactor(r)
+//
+// Names are matched case-insensitively, "*" matching any run of characters. A Redactor mustn't
+// be modified once in use.
+type Redactor struct {
+	// Headers are the names of the headers to mask, e.g. "*-Token".
+	Headers []string
+	// Query are the names of the query parameters to mask, also masked in form bodies.
+	Query []string
+	// Fields are the paths of the JSON body fields to mask, dotted names matched against the end
+	// of the path of every field, array elements aside: "password" masks the password field of
+	// every object, "user.password" that of the user objects, "secret.*" every field of theirs.
+	Fields []string
+}
+
+// DefaultRedactor returns the rules used unless SetRedactor is called, masking the common
+// credentials: the Authorization, Cookie and *-Token headers, the api_key and token query
+// parameters, and the password and secret fields.
+func DefaultRedactor() *Redactor {
+	return &Redactor{
+		Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "*-Token", "*-Api-Key"},
+		Query:   []string{"api_key", "apikey", "token", "access_token"},
+		Fields:  []string{"password", "secret", "client_secret", "access_token", "refresh_token"},
+	}
+}
+
+var defaultRedactor = DefaultRedactor()
+
+// SetRedactor sets the rules masking the secrets in the output of HARRecorder and the call logs.
+// Passing nil restores DefaultRedactor; an empty Redactor masks nothing.
+func (a *Api) SetRedactor(r *Redactor) {
+	a.mu.Lock()
+	a.redactor = r
+	a.mu.Unlock()
+}
+
+// Redactor returns the rules set by SetRedactor, or DefaultRedactor.
+func (a *Api) Redactor() *Redactor {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.redactor == nil {
+		return defaultRedactor
+	}
+	return a.redactor
+}
+
+type redactorKey struct{}
+
+// withRedactor passes the Redactor set on a to the HARRecorder sending the requests made with ctx.
+func (a *Api) withRedactor(ctx context.Context) context.Context {
+	a.mu.Lock()
+	r := a.redactor
+	a.mu.Unlock()
+	if r == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, redactorKey{}, r)
+}
+
+// redacted returns the placeholder of s.
+func redacted(s string) string {
+	return "<redacted:" + strconv.Itoa(utf8.RuneCountInString(s)) + " chars>"
+}
+
+// RedactHeader returns a copy of h with the values of the matching headers masked.
+func (r *Redactor) RedactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for k, vs := range out {
+		if matchAny(r.Headers, k) {
+			for i, v := range vs {
+				vs[i] = redacted(v)
+			}
+		}
+	}
+	return out
+}
+
+// RedactURL returns u as a string, with its password and the values of the matching query
+// parameters masked.
+func (r *Redactor) RedactURL(u *url.URL) string {
+	v := *u
+	v.RawQuery = r.redactPairs(u.RawQuery)
+	return v.Redacted()
+}
+
+// RedactBody returns body with the matching fields masked if it's JSON or a form, the formatting
+// being kept. Bodies truncated in the middle of a masked field are masked to the end.
+// Other bodies are returned unchanged.
+func (r *Redactor) RedactBody(contentType string, body []byte) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasSuffix(mediaType, "json"):
+		return r.redactJSON(body)
+	case mediaType == "application/x-www-form-urlencoded":
+		return []byte(r.redactPairs(string(body)))
+	}
+	return body
+}
+
+// redactError masks the URL in the message of err if it's a *url.Error or wraps one.
+// The returned error still wraps err.
+func (r *Redactor) redactError(err error) error {
+	var ue *url.Error
+	if !errors.As(err, &ue) {
+		return err
+	}
+	u, perr := url.Parse(ue.URL)
+	if perr != nil {
+		return err
+	}
+	masked := r.RedactURL(u)
+	if masked == ue.URL {
+		return err
+	}
+	return &redactedError{msg: strings.ReplaceAll(err.Error(), ue.URL, masked), err: err}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactPairs masks the values of the matching names in a query or form body, keeping the rest as is.
+func (r *Redactor) redactPairs(s string) string {
+	if s == "" || len(r.Query) == 0 {
+		return s
+	}
+	pairs := strings.Split(s, "&")
+	masked := false
+	for i, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(k)
+		if !ok || err != nil || !matchAny(r.Query, name) {
+			continue
+		}
+		if value, err := url.QueryUnescape(v); err == nil {
+			v = value
+		}
+		pairs[i] = k + "=" + url.QueryEscape(redacted(v))
+		masked = true
+	}
+	if !masked {
+		return s
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactJSON masks the values of the matching fields in place.
+func (r *Redactor) redactJSON(body []byte) []byte {
+	if len(r.Fields) == 0 {
+		return body
+	}
+	type span struct {
+		start, end int
+		value      string
+	}
+	var spans []span
+	dec := json.NewDecoder(bytes.NewReader(body))
+	var walk func(path []string) error
+	walk = func(path []string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				name, _ := key.(string)
+				p := append(path[:len(path):len(path)], name)
+				if !r.matchField(p) {
+					if err := walk(p); err != nil {
+						return err
+					}
+					continue
+				}
+				after := int(dec.InputOffset())
+				var raw json.RawMessage
+				if err := dec.Decode(&raw); err != nil {
+					rest := strings.TrimLeft(string(body[after:]), ": \t\r\n")
+					spans = append(spans, span{len(body) - len(rest), len(body), rest})
+					return err
+				}
+				start := after + bytes.Index(body[after:], raw)
+				value := string(raw)
+				var s string
+				if json.Unmarshal(raw, &s) == nil {
+					value = s
+				}
+				spans = append(spans, span{start, start + len(raw), value})
+			}
+		case json.Delim('['):
+			for dec.More() {
+				if err := walk(path); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+		_, err = dec.Token()
+		return err
+	}
+	walk(nil)
+	if len(spans) == 0 {
+		return body
+	}
+	var b bytes.Buffer
+	prev := 0
+	for _, s := range spans {
+		b.Write(body[prev:s.start])
+		b.WriteString(`"` + redacted(s.value) + `"`)
+		prev = s.end
+	}
+	b.Write(body[prev:])
+	return b.Bytes()
+}
+
+// matchField reports whether a pattern of Fields matches the end of path.
+func (r *Redactor) matchField(path []string) bool {
+	for _, f := range r.Fields {
+		segs := strings.Split(f, ".")
+		if len(segs) > len(path) {
+			continue
+		}
+		tail := path[len(path)-len(segs):]
+		match := true
+		for i, seg := range segs {
+			if !matchName(seg, tail[i]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchName(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchName matches name against pattern case-insensitively, "*" matching any run of characters.
+func matchName(pattern, name string) bool {
+	return globMatch(pattern, name, strings.EqualFold)
+}
+
+// globMatch matches name against pattern, "*" matching any run of characters and the rest
+// compared with equal.
+func globMatch(pattern, name string, equal func(a, b string) bool) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return equal(pattern, name)
+	}
+	if len(name) < i || !equal(pattern[:i], name[:i]) {
+		return false
+	}
+	pattern, name = pattern[i+1:], name[i:]
+	for j := 0; j <= len(name); j++ {
+		if globMatch(pattern, name[j:], equal) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ErrRedaction is matched by every *RedactionError.
+var ErrRedaction = errors.New("api: response redaction failed")
+
+// RedactionError is returned when the body of a response with redacted fields can't be redacted:
+// it isn't valid JSON, or a field is missing with SetStrictRedaction on. The body itself is never
+// part of the error.
+type RedactionError struct {
+	Resource string
+	// Missing are the paths of the fields missing from the body, sorted.
+	Missing []string
+	// Err is the error of decoding the body, nil for the missing fields.
+	Err error
+}
+
+func (e *RedactionError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("api: redacting the response of %s: %v", e.Resource, e.Err)
+	}
+	return fmt.Sprintf("api: redacting the response of %s: missing %s", e.Resource, strings.Join(e.Missing, ", "))
+}
+
+func (e *RedactionError) Unwrap() error { return e.Err }
+
+// Is makes errors.Is(err, ErrRedaction) report true.
+func (e *RedactionError) Is(target error) bool { return target == ErrRedaction }
+
+// Class makes the error a Permanent failure: another attempt would get the same body.
+func (e *RedactionError) Class() Class { return Permanent }
+
+// RedactField is a field of the JSON response bodies redacted by DeclareRedaction.
+type RedactField struct {
+	// Path is the path of the field from the root of the body, a dotted path like "user.email"
+	// or "$.user.email", or a JSON Pointer like "/user/email", see JSONLookup. A "*" or "[*]"
+	// step matches every element of an array: "items[*].ssn", "items.*.ssn" and "/items/*/ssn"
+	// redact the ssn of every item, and "[*].ssn" the ones of a body that's an array.
+	Path string
+	// Remove removes the field rather than masking its value; the elements of an array matched
+	// by a last "*" step are removed from it.
+	Remove bool
+	// Mask replaces the value of the field, whatever its type, "[redacted]" if empty.
+	Mask string
+}
+
+// redactField is a declared RedactField with its parsed path.
+type redactField struct {
+	RedactField
+	steps []jsonStep
+}
+
+// redactions are the declarations of DeclareRedaction.
+type redactions struct {
+	strict bool
+	// fields are the declared fields by resource template.
+	fields map[string][]redactField
+}
+
+// DeclareRedaction declares fields of the JSON response bodies of the resource, a template like
+// DeclareParams ones, to be masked or removed before the bodies leave the client layer, e.g. the
+// personal data general code mustn't see:
+//
+//	a.DeclareRedaction("/users/{id}", api.RedactField{Path: "email"}, api.RedactField{Path: "ssn", Remove: true})
+//	a.DeclareRedaction("/users", api.RedactField{Path: "items[*].email"})
+//
+// The bodies of a JSON content type of the calls to the resource, or made from a Template of
+// it, are read in full on arrival and redacted before anything else reads them: the decoding,
+// the caches, the captures of Capture and the error bodies of StatusError only ever see the
+// redacted body, and the HARRecorder of the transport records it too, or no body at all when it
+// can't redact the one it got. The journal of SetJournal keeps hashing the bytes received, so
+// its hashes still match what the server sent. The redacted body is encoded again with its keys
+// sorted. A body that isn't valid JSON fails the call with a *RedactionError rather than being
+// passed through. Calling it again for the same resource adds to its fields; a path that can't
+// be parsed is an error, and nothing is declared then.
+func (a *Api) DeclareRedaction(resource string, fields ...RedactField) error {
+	parsed := make([]redactField, len(fields))
+	for i, f := range fields {
+		path := f.Path
+		if !strings.HasPrefix(path, "/") {
+			path = strings.ReplaceAll(path, "[*]", ".*")
+			path = strings.TrimPrefix(path, ".")
+		}
+		steps, err := parseJSONPath(path, nil)
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			return &JSONPathError{Path: f.Path, Reason: "invalid path: empty"}
+		}
+		parsed[i] = redactField{RedactField: f, steps: steps}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := &redactions{fields: make(map[string][]redactField)}
+	if a.redactions != nil {
+		r.strict = a.redactions.strict
+		for tmpl, declared := range a.redactions.fields {
+			r.fields[tmpl] = declared
+		}
+	}
+	r.fields[resource] = append(r.fields[resource][:len(r.fields[resource]):len(r.fields[resource])], parsed...)
+	a.redactions = r
+	return nil
+}
+
+// SetStrictRedaction makes the 2xx responses whose JSON body misses a field declared by
+// DeclareRedaction fail with a *RedactionError, to catch the drift of the schema around the
+// sensitive fields, e.g. one renamed and then passed through unredacted. A field under an
+// array step is missing when any element of the array misses it; an empty array misses nothing.
+func (a *Api) SetStrictRedaction(strict bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := &redactions{strict: strict}
+	if a.redactions != nil {
+		r.fields = a.redactions.fields
+	}
+	a.redactions = r
+}
+
+// responseRedaction is the redaction of the responses of a call.
+type responseRedaction struct {
+	resource string
+	strict   bool
+	fields   []redactField
+}
+
+// redactionFor returns the redaction of the responses of resource, nil if it has no fields.
+func (a *Api) redactionFor(resource string) *responseRedaction {
+	a.mu.Lock()
+	r := a.redactions
+	a.mu.Unlock()
+	if r == nil {
+		return nil
+	}
+	var rr *responseRedaction
+	for tmpl, fields := range r.fields {
+		if matchTemplate(tmpl, resource) {
+			if rr == nil {
+				rr = &responseRedaction{resource: resource, strict: r.strict}
+			}
+			rr.fields = append(rr.fields, fields...)
+		}
+	}
+	return rr
+}
+
+type redactionKey struct{}
+
+// withRedaction passes rr to the HARRecorder sending the requests made with ctx.
+func withRedaction(ctx context.Context, rr *responseRedaction) context.Context {
+	return context.WithValue(ctx, redactionKey{}, rr)
+}
+
+// wrap redacts the body of resp once it's read if it's JSON.
+func (rr *responseRedaction) wrap(resp *http.Response) io.ReadCloser {
+	if resp.Body == nil || resp.Body == http.NoBody || !isJSONType(resp.Header.Get("Content-Type")) {
+		return resp.Body
+	}
+	strict := rr.strict && resp.StatusCode >= 200 && resp.StatusCode < 300
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return &redactBody{ReadCloser: resp.Body, redact: func(data []byte) ([]byte, error) {
+		return rr.redact(data, strict)
+	}}
+}
+
+// redact returns the JSON data with the fields redacted, failing with the missing ones if strict.
+// Empty data is returned as it is.
+func (rr *responseRedaction) redact(data []byte, strict bool) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, &RedactionError{Resource: rr.resource, Err: err}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, &RedactionError{Resource: rr.resource, Err: errors.New("invalid data after the JSON value")}
+	}
+	var missing []string
+	for i := range rr.fields {
+		f := &rr.fields[i]
+		var found bool
+		if v, found = f.redactIn(v, f.steps); !found {
+			missing = append(missing, f.Path)
+		}
+	}
+	if strict && len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, &RedactionError{Resource: rr.resource, Missing: missing}
+	}
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, v); err != nil {
+		return nil, &RedactionError{Resource: rr.resource, Err: err}
+	}
+	return buf.Bytes(), nil
+}
+
+// removedValue replaces the values of the fields removed by redactIn.
+type removedValue struct{}
+
+// redactIn redacts the field at steps in v, returning the redacted v, removedValue if it's the
+// field removed, and whether the field was found.
+func (f *redactField) redactIn(v interface{}, steps []jsonStep) (interface{}, bool) {
+	if len(steps) == 0 {
+		if f.Remove {
+			return removedValue{}, true
+		}
+		if f.Mask == "" {
+			return "[redacted]", true
+		}
+		return f.Mask, true
+	}
+	step := steps[0]
+	switch v := v.(type) {
+	case map[string]interface{}:
+		child, ok := v[step.key]
+		if !ok || step.indexing {
+			return v, false
+		}
+		redacted, found := f.redactIn(child, steps[1:])
+		if _, ok := redacted.(removedValue); ok {
+			delete(v, step.key)
+		} else {
+			v[step.key] = redacted
+		}
+		return v, found
+	case []interface{}:
+		if step.key == "*" && !step.indexing {
+			found := true
+			kept := v[:0]
+			for _, e := range v {
+				redacted, ok := f.redactIn(e, steps[1:])
+				found = found && ok
+				if _, ok := redacted.(removedValue); !ok {
+					kept = append(kept, redacted)
+				}
+			}
+			return kept, found
+		}
+		if step.index < 0 || step.index >= len(v) {
+			return v, false
+		}
+		redacted, found := f.redactIn(v[step.index], steps[1:])
+		if _, ok := redacted.(removedValue); ok {
+			return append(v[:step.index:step.index], v[step.index+1:]...), found
+		}
+		v[step.index] = redacted
+		return v, found
+	}
+	return v, false
+}
+
+// redactBody reads the body in full on its first read, to serve its redaction.
+type redactBody struct {
+	io.ReadCloser
+	redact func([]byte) ([]byte, error)
+	r      *bytes.Reader
+	err    error
+}
+
+func (b *redactBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		data, err := io.ReadAll(b.ReadCloser)
+		if err == nil {
+			data, err = b.redact(data)
+		}
+		if err != nil {
+			b.err = err
+		} else {
+			b.r = bytes.NewReader(data)
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.r.Read(p)
+}
+
+// recorded returns the body recorded by a HARRecorder redacted, nil if it can't be.
+func (rr *responseRedaction) recorded(contentType string, body []byte) []byte {
+	if !isJSONType(contentType) {
+		return body
+	}
+	data, err := rr.redact(body, false)
+	if err != nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const redactionUser = `{"id": 1, "name": "Ann", "email": "ann@example.com", "ssn": "123-45-6789",
+	"contacts": [{"kind": "home", "email": "a@home.example"}, {"kind": "work", "email": "a@work.example", "ssn": "x"}],
+	"profile": {"address": {"street": "1 Main St", "city": "Springfield"}}}`
+
+func redactionServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/users":
+			w.Write([]byte(`[` + redactionUser + `, {"id": 2, "name": "Bob", "email": "bob@example.com", "contacts": []}]`))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "not found", "email": "ann@example.com"}`))
+		case "/users/text":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(`ann@example.com`))
+		case "/broken":
+			w.Write([]byte(`{"email": "ann@example.com"`))
+		default:
+			w.Write([]byte(redactionUser))
+		}
+	}))
+}
+
+func TestRedactionNested(t *testing.T) {
+	srv := redactionServer()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	assert.NoError(t, a.DeclareRedaction("/users/{id}",
+		RedactField{Path: "email"},
+		RedactField{Path: "ssn", Remove: true},
+		RedactField{Path: "$.profile.address.street", Mask: "***"},
+		RedactField{Path: "contacts[*].email"},
+		RedactField{Path: "/contacts/*/ssn", Remove: true}))
+
+	var out map[string]interface{}
+	assert.NoError(t, a.Get(context.Background(), "/users/1", nil, &out))
+	assert.Equal(t, map[string]interface{}{
+		"id": float64(1), "name": "Ann", "email": "[redacted]",
+		"contacts": []interface{}{
+			map[string]interface{}{"kind": "home", "email": "[redacted]"},
+			map[string]interface{}{"kind": "work", "email": "[redacted]"},
+		},
+		"profile": map[string]interface{}{"address": map[string]interface{}{"street": "***", "city": "Springfield"}},
+	}, out)
+
+	// The other resources aren't redacted.
+	out = nil
+	assert.NoError(t, a.Get(context.Background(), "/accounts/1", nil, &out))
+	assert.Equal(t, "ann@example.com", out["email"])
+
+	// Neither are the bodies which aren't JSON.
+	req, _ := a.Request(GET, "/users/text", nil)
+	resp, err := a.Do(context.Background(), req)
+	if assert.NoError(t, err) {
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		resp.Body.Close()
+		assert.Contains(t, buf.String(), "ann@example.com")
+	}
+}
+
+func TestRedactionArrayElements(t *testing.T) {
+	srv := redactionServer()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	assert.NoError(t, a.DeclareRedaction("/users", RedactField{Path: "[*].email"}, RedactField{Path: "[*].contacts[*]", Remove: true}))
+
+	var out []map[string]interface{}
+	assert.NoError(t, a.Get(context.Background(), "/users", nil, &out))
+	if assert.Len(t, out, 2) {
+		assert.Equal(t, "[redacted]", out[0]["email"])
+		assert.Equal(t, "[redacted]", out[1]["email"])
+		assert.Equal(t, []interface{}{}, out[0]["contacts"])
+		assert.Equal(t, "123-45-6789", out[0]["ssn"])
+	}
+
+	// The error bodies are redacted too.
+	assert.NoError(t, a.DeclareRedaction("/missing", RedactField{Path: "email"}))
+	err := a.Get(context.Background(), "/missing", nil, nil)
+	var se *StatusError
+	if assert.ErrorAs(t, err, &se) {
+		assert.NotContains(t, string(se.Body), "ann@example.com")
+		assert.Contains(t, string(se.Body), "[redacted]")
+	}
+
+	err = a.DeclareRedaction("/users", RedactField{Path: "items[x"})
+	var pe *JSONPathError
+	assert.ErrorAs(t, err, &pe)
+}
+
+func TestRedactionStrict(t *testing.T) {
+	srv := redactionServer()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	assert.NoError(t, a.DeclareRedaction("/users/{id}", RedactField{Path: "email"}, RedactField{Path: "contacts[*].ssn"}, RedactField{Path: "tax_id"}))
+
+	// Without the strict mode, the missing fields are ignored.
+	var out map[string]interface{}
+	assert.NoError(t, a.Get(context.Background(), "/users/1", nil, &out))
+	assert.Equal(t, "[redacted]", out["email"])
+
+	a.SetStrictRedaction(true)
+	err := a.Get(context.Background(), "/users/1", nil, &out)
+	assert.True(t, errors.Is(err, ErrRedaction), err)
+	var re *RedactionError
+	if assert.ErrorAs(t, err, &re) {
+		assert.Equal(t, "/users/1", re.Resource)
+		assert.Equal(t, []string{"contacts[*].ssn", "tax_id"}, re.Missing)
+		assert.NotContains(t, err.Error(), "ann@example.com")
+	}
+	assert.Equal(t, DecodeFailure, KindOf(err))
+
+	// The error responses aren't checked.
+	assert.NoError(t, a.DeclareRedaction("/missing", RedactField{Path: "ssn"}))
+	err = a.Get(context.Background(), "/missing", nil, nil)
+	var se *StatusError
+	assert.ErrorAs(t, err, &se)
+
+	// A body which isn't valid JSON isn't passed through.
+	assert.NoError(t, a.DeclareRedaction("/broken", RedactField{Path: "email"}))
+	err = a.Get(context.Background(), "/broken", nil, &out)
+	assert.True(t, errors.Is(err, ErrRedaction), err)
+	assert.NotContains(t, err.Error(), "ann@example.com")
+}
+
+func TestRedactionDebugDumps(t *testing.T) {
+	srv := redactionServer()
+	defer srv.Close()
+	rec := NewHARRecorder(nil)
+	a := MustNew(srv.URL)
+	a.Client = &http.Client{Transport: rec}
+	sink := &captureLog{}
+	a.SetCaptureSink(sink)
+	a.Capture(CaptureRule{ResourceTemplate: "/users/{id}"})
+	assert.NoError(t, a.DeclareRedaction("/users/{id}", RedactField{Path: "email"}, RedactField{Path: "ssn", Remove: true}))
+
+	var out map[string]interface{}
+	assert.NoError(t, a.Get(context.Background(), "/users/1", nil, &out))
+
+	calls := sink.take()
+	if assert.Len(t, calls, 1) {
+		text := calls[0].Entry.Response.Content.Text
+		assert.Contains(t, text, `"email":"[redacted]"`)
+		assert.NotContains(t, text, "ann@example.com")
+		assert.NotContains(t, text, "123-45-6789")
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, rec.WriteHAR(&buf))
+	var har HAR
+	if assert.NoError(t, json.Unmarshal(buf.Bytes(), &har)) && assert.Len(t, har.Log.Entries, 1) {
+		text := har.Log.Entries[0].Response.Content.Text
+		assert.Contains(t, text, `"email":"[redacted]"`)
+		assert.NotContains(t, text, "ann@example.com")
+		assert.NotContains(t, text, "123-45-6789")
+	}
+}
+
+func TestRedactionJournalHash(t *testing.T) {
+	srv := redactionServer()
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	j := &memJournal{}
+	a.SetJournal(j, &JournalPolicy{Methods: []string{"GET"}})
+	assert.NoError(t, a.DeclareRedaction("/users/{id}", RedactField{Path: "email"}, RedactField{Path: "ssn", Remove: true}))
+
+	// The journal hashes the bytes received, not the redacted ones.
+	var out map[string]interface{}
+	assert.NoError(t, a.Get(context.Background(), "/users/1", nil, &out))
+	assert.Equal(t, "[redacted]", out["email"])
+	if assert.Len(t, j.entries, 1) {
+		assert.Equal(t, sha256Hex(redactionUser), j.entries[0].ResponseSHA256)
+		assert.Equal(t, int64(len(redactionUser)), j.entries[0].ResponseSize)
+	}
+}
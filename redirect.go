@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ErrNoLocation is returned by Location for the responses without a Location header.
+var ErrNoLocation = errors.New("api: no Location in response")
+
+// Location returns the Location header of resp, e.g. of a 201 Created or a redirect, resolved
+// against the URL of the request. It returns ErrNoLocation if the header is missing.
+func Location(resp *http.Response) (*url.URL, error) {
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil, ErrNoLocation
+	}
+	u, err := url.Parse(loc)
+	if err != nil {
+		return nil, fmt.Errorf("api: invalid Location: %w", err)
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		u = resp.Request.URL.ResolveReference(u)
+	}
+	return u, nil
+}
+
+// RedirectionError is returned by the Do-style helpers for the 3xx responses the Client didn't
+// follow, e.g. because its CheckRedirect returns http.ErrUseLastResponse. 304 Not Modified isn't
+// a redirection and is still returned as a plain *StatusError. errors.As finds the *StatusError
+// it embeds too.
+type RedirectionError struct {
+	*StatusError
+	// Location is the target of the redirection, resolved against the URL of the request,
+	// nil if the response had no valid Location header.
+	Location *url.URL
+}
+
+func (e *RedirectionError) Error() string {
+	if e.Location == nil {
+		return fmt.Sprintf("api: unexpected redirection %s without Location", e.Status)
+	}
+	return fmt.Sprintf("api: unexpected redirection %s to %s", e.Status, e.Location.Redacted())
+}
+
+// Unwrap returns the *StatusError of the response.
+func (e *RedirectionError) Unwrap() error { return e.StatusError }
+
+// isRedirection reports whether the status code is a redirection for RedirectionError.
+func isRedirection(code int) bool {
+	return code >= 300 && code <= 399 && code != http.StatusNotModified
+}
+
+// FollowSeeOther makes a Do-style helper follow a 303 See Other response, e.g. to a POST, with a
+// GET of its Location, whose response is then checked and decoded in place of the 303 one. It's
+// for the Clients not following redirects, as http.DefaultClient already does. The headers of
+// the request are kept, but its Content-Type and Content-Length, and its Authorization and Cookie
+// when the Location is on another host. A 303 without a valid Location fails with a
+// *RedirectionError.
+func FollowSeeOther() Option {
+	return func(c *call) {
+		c.seeOther = true
+	}
+}
+
+// seeOther returns the GET of the Location of the 303 resp to req, false if it has none.
+func seeOther(req *http.Request, resp *http.Response) (*http.Request, bool) {
+	loc, err := Location(resp)
+	if err != nil {
+		return nil, false
+	}
+	next := newRequest(GET, loc)
+	next.Header = req.Header.Clone()
+	for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+		next.Header.Del(k)
+	}
+	if loc.Host != req.URL.Host {
+		next.Header.Del("Authorization")
+		next.Header.Del("Cookie")
+	}
+	return next.WithContext(req.Context()), true
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirection(t *testing.T) {
+	var gets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/items":
+			w.Header().Set("Location", "items/1")
+			w.WriteHeader(http.StatusCreated)
+		case "/orders":
+			w.Header().Set("Location", "/orders/7?view=full")
+			w.WriteHeader(http.StatusSeeOther)
+		case "/orders/7":
+			gets = append(gets, r.Method+" "+r.Header.Get("Authorization")+" "+r.Header.Get("Content-Type"))
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": 7, "view": "` + r.URL.Query().Get("view") + `"}`))
+		case "/old":
+			w.Header().Set("Location", "/new")
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusSeeOther)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Client = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	a.Header = http.Header{"Authorization": {"Bearer t0k"}}
+	ctx := context.Background()
+
+	// A 201 isn't a redirection: its Location is just there to read.
+	req, err := a.RequestJSON(POST, "/items", map[string]string{"name": "a"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := a.Do(ctx, req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	loc, err := Location(resp)
+	if assert.NoError(t, err) {
+		assert.Equal(t, srv.URL+"/items/1", loc.String())
+	}
+	_, err = Location(&http.Response{Header: http.Header{}})
+	assert.Equal(t, ErrNoLocation, err)
+
+	var out struct {
+		ID   int
+		View string
+	}
+	var meta ResponseMeta
+	if !assert.NoError(t, a.Post(ctx, "/orders", map[string]string{"item": "a"}, &out, FollowSeeOther(), WithMeta(&meta))) {
+		return
+	}
+	assert.Equal(t, 7, out.ID)
+	assert.Equal(t, "full", out.View)
+	assert.Equal(t, http.StatusOK, meta.StatusCode)
+	assert.Equal(t, []string{"GET Bearer t0k "}, gets)
+
+	// Without FollowSeeOther, a 303 is an unexpected redirection like any other.
+	err = a.Post(ctx, "/orders", nil, nil)
+	var re *RedirectionError
+	if assert.ErrorAs(t, err, &re) {
+		assert.Equal(t, srv.URL+"/orders/7?view=full", re.Location.String())
+	}
+
+	err = a.Get(ctx, "/old", nil, nil)
+	if assert.ErrorAs(t, err, &re) {
+		assert.Equal(t, http.StatusFound, re.Code)
+		assert.Equal(t, srv.URL+"/new", re.Location.String())
+		assert.Equal(t, "api: unexpected redirection 302 Found to "+srv.URL+"/new", err.Error())
+	}
+	var se *StatusError
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, Permanent, Classify(err))
+
+	err = a.Post(ctx, "/nowhere", nil, nil, FollowSeeOther())
+	if assert.ErrorAs(t, err, &re) {
+		assert.Nil(t, re.Location)
+		assert.Equal(t, "api: unexpected redirection 303 See Other without Location", err.Error())
+	}
+}
+
+func TestSeeOtherOtherHost(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `"`+r.Header.Get("Authorization")+r.Header.Get("X-Trace")+`"`)
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", other.URL+"/result")
+		w.WriteHeader(http.StatusSeeOther)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Client = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	a.Header = http.Header{"Authorization": {"Bearer t0k"}, "X-Trace": {"1"}}
+	var out string
+	assert.NoError(t, a.Post(context.Background(), "/jobs", nil, &out, FollowSeeOther()))
+	assert.Equal(t, "1", out)
+}
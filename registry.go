@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Registry makes the Apis of several services sharing their configuration, e.g. the internal
+// services of an application having the same auth, tracing, retry and TLS settings but their own
+// base URIs. The shared options are applied to every call of the services before their own
+// defaults, when the call is made, so changing them later, e.g. to rotate a credential or add a
+// middleware, affects all the registered services without rebuilding them.
+//
+//	reg := api.NewRegistry(api.WithRetry(policy))
+//	reg.Client = &http.Client{Transport: tlsTransport}
+//	billing, err := reg.Service("billing", "https://billing.internal", api.WithHeader("X-Team", "payments"))
+//	reg.SetHeader("Authorization", "Bearer "+token)
+type Registry struct {
+	// Client is the HTTP client given to the services registered afterwards, sharing its transport.
+	Client *http.Client
+
+	mu       sync.Mutex
+	shared   []Option
+	header   http.Header
+	services map[string]*Api
+}
+
+// NewRegistry creates a Registry with the shared options opts.
+func NewRegistry(opts ...Option) *Registry {
+	return &Registry{shared: append([]Option(nil), opts...), header: http.Header{}, services: make(map[string]*Api)}
+}
+
+// Service registers the service name with the base URI and returns its Api. The opts are the
+// defaults of the service, applied after the shared options so they override them; like other
+// defaults, they're replaced by SetDefaults. Registering a name twice fails.
+func (r *Registry) Service(name, uri string, opts ...Option) (*Api, error) {
+	a, err := New(uri)
+	if err != nil {
+		return nil, err
+	}
+	a.Client = r.Client
+	a.registry = r
+	a.defaults = append([]Option(nil), opts...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.services[name]; ok {
+		return nil, fmt.Errorf("api: service %q already registered", name)
+	}
+	r.services[name] = a
+	return a, nil
+}
+
+// Get returns the Api of the service name, if registered.
+func (r *Registry) Get(name string) (*Api, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.services[name]
+	return a, ok
+}
+
+// Names returns the names of the registered services in order, e.g. for checking their health.
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.services))
+	for name := range r.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetShared replaces the shared options.
+func (r *Registry) SetShared(opts ...Option) {
+	r.mu.Lock()
+	r.shared = append([]Option(nil), opts...)
+	r.mu.Unlock()
+}
+
+// AddShared adds opts to the shared options, after the current ones.
+func (r *Registry) AddShared(opts ...Option) {
+	r.mu.Lock()
+	r.shared = append(r.shared[:len(r.shared):len(r.shared)], opts...)
+	r.mu.Unlock()
+}
+
+// SetHeader sets the shared header key to the given values, removing it without values. The shared
+// header is added to the requests of the services lacking it, so the Header of a service or
+// its options override it.
+func (r *Registry) SetHeader(key string, values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	header := r.header.Clone()
+	if len(values) == 0 {
+		header.Del(key)
+	} else {
+		header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+	}
+	r.header = header
+}
+
+// options returns the shared options, the shared header first.
+func (r *Registry) options() []Option {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	opts := make([]Option, 0, len(r.shared)+1)
+	if header := r.header; len(header) > 0 {
+		opts = append(opts, func(c *call) {
+			c.prepare = append(c.prepare, func(req *http.Request) error {
+				for k, vs := range header {
+					if _, ok := req.Header[k]; !ok {
+						req.Header[k] = append([]string(nil), vs...)
+					}
+				}
+				return nil
+			})
+		})
+	}
+	return append(opts, r.shared...)
+}
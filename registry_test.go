@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	type seen struct{ path, auth, team, trace string }
+	var got []seen
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, seen{r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("X-Team"), r.Header.Get("X-Trace")})
+	}))
+	defer srv.Close()
+	reg := NewRegistry(WithHeader("X-Team", "platform"))
+	reg.Client = srv.Client()
+	reg.SetHeader("Authorization", "Bearer 1")
+	billing, err := reg.Service("billing", srv.URL+"/billing", WithHeader("X-Team", "payments"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	users, err := reg.Service("users", srv.URL+"/users")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = reg.Service("users", srv.URL+"/other")
+	assert.EqualError(t, err, `api: service "users" already registered`)
+	assert.True(t, billing.Client == reg.Client)
+
+	a, ok := reg.Get("billing")
+	assert.True(t, ok && a == billing)
+	_, ok = reg.Get("orders")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"billing", "users"}, reg.Names())
+
+	ctx := context.Background()
+	assert.NoError(t, billing.Get(ctx, "/invoices", nil, nil))
+	assert.NoError(t, users.Get(ctx, "/me", nil, nil))
+
+	// The shared changes reach the services already registered.
+	reg.SetHeader("Authorization", "Bearer 2")
+	reg.AddShared(WithHeader("X-Trace", "on"))
+	assert.NoError(t, billing.Get(ctx, "/invoices", nil, nil))
+	assert.NoError(t, users.Get(ctx, "/me", nil, nil))
+
+	// The Header of a service overrides the shared header.
+	users.Header = http.Header{"Authorization": {"Bearer users"}}
+	assert.NoError(t, users.Get(ctx, "/me", nil, nil))
+	reg.SetHeader("Authorization")
+	assert.NoError(t, billing.Get(ctx, "/invoices", nil, nil))
+
+	assert.Equal(t, []seen{
+		{"/billing/invoices", "Bearer 1", "payments", ""},
+		{"/users/me", "Bearer 1", "platform", ""},
+		{"/billing/invoices", "Bearer 2", "payments", "on"},
+		{"/users/me", "Bearer 2", "platform", "on"},
+		{"/users/me", "Bearer users", "platform", "on"},
+		{"/billing/invoices", "", "payments", "on"},
+	}, got)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrRequestTooLarge is matched by every *RequestTooLargeError.
+var ErrRequestTooLarge = errors.New("api: request too large")
+
+// RequestTooLargeError is returned for the calls whose request body is larger than
+// Api.MaxRequestBytes, or the limit of WithMaxRequestBytes. It's matched by ErrRequestTooLarge.
+type RequestTooLargeError struct {
+	// Resource is the resource of the call, the template for the calls made from one.
+	Resource string
+	// Size is the size of the body, or the bytes read until the limit was crossed for the bodies
+	// streamed without a known length.
+	Size  int64
+	Limit int64
+	// Streamed is set for the bodies without a known length, aborted while they were sent.
+	Streamed bool
+}
+
+func (e *RequestTooLargeError) Error() string {
+	if e.Streamed {
+		return fmt.Sprintf("api: request to %s too large: over %d bytes streamed", e.Resource, e.Limit)
+	}
+	return fmt.Sprintf("api: request to %s too large: %d bytes, limit %d", e.Resource, e.Size, e.Limit)
+}
+
+// Is makes errors.Is(err, ErrRequestTooLarge) report true.
+func (e *RequestTooLargeError) Is(target error) bool { return target == ErrRequestTooLarge }
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *RequestTooLargeError) Class() Class { return Permanent }
+
+// WithMaxRequestBytes makes the call use the request body limit n instead of
+// Api.MaxRequestBytes, e.g. for a known large upload. Zero removes the limit.
+func WithMaxRequestBytes(n int64) Option {
+	return func(c *call) {
+		c.maxRequest, c.maxRequestSet = n, true
+	}
+}
+
+// maxRequestBytes returns the request body limit of the call, zero if there's none.
+func (c *call) maxRequestBytes(a *Api) int64 {
+	if c.maxRequestSet {
+		return c.maxRequest
+	}
+	return a.MaxRequestBytes
+}
+
+// streamedBody reports whether the body of req has no known length.
+func streamedBody(req *http.Request) bool {
+	return req.ContentLength <= 0 && req.Body != nil && req.Body != http.NoBody
+}
+
+// checkRequestSize fails the call whose body of a known length is over its limit, before it's sent.
+func (a *Api) checkRequestSize(c *call, req *http.Request, resource string) error {
+	if limit := c.maxRequestBytes(a); limit > 0 && req.ContentLength > limit {
+		return &RequestTooLargeError{Resource: resource, Size: req.ContentLength, Limit: limit}
+	}
+	return nil
+}
+
+// sizeBody counts the bytes of a request body without a known length as they're sent, failing
+// the read crossing the limit, if any.
+type sizeBody struct {
+	io.ReadCloser
+	n        atomic.Int64
+	limit    int64
+	resource string
+}
+
+func (b *sizeBody) Read(p []byte) (int, error) {
+	if b.limit > 0 {
+		// Reading a byte past the limit is enough to tell the body crosses it.
+		if left := b.limit - b.n.Load() + 1; int64(len(p)) > left {
+			p = p[:left]
+		}
+	}
+	k, err := b.ReadCloser.Read(p)
+	n := b.n.Add(int64(k))
+	if b.limit > 0 && n > b.limit {
+		return k - int(n-b.limit), &RequestTooLargeError{Resource: b.resource, Size: n, Limit: b.limit, Streamed: true}
+	}
+	return k, err
+}
+
+// sizeFor returns the copy of req sent with its body counted by a sizeBody if its length isn't
+// known, and the sizeBody, nil otherwise.
+func (a *Api) sizeFor(c *call, req *http.Request, resource string) (*http.Request, *sizeBody) {
+	if !streamedBody(req) {
+		return req, nil
+	}
+	b := &sizeBody{ReadCloser: req.Body, limit: c.maxRequestBytes(a), resource: resource}
+	r := *req
+	r.Body = b
+	return &r, b
+}
+
+// requestSize returns the bytes of the request body sent, given the sizeBody counting them if any.
+func requestSize(req *http.Request, b *sizeBody) int64 {
+	if b != nil {
+		return b.n.Load()
+	}
+	return req.ContentLength
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sizeLog keeps the RequestSize of the calls logged.
+type sizeLog struct {
+	mu    sync.Mutex
+	sizes []int64
+}
+
+func (s *sizeLog) LogCall(_ context.Context, l CallLog) {
+	s.mu.Lock()
+	s.sizes = append(s.sizes, l.RequestSize)
+	s.mu.Unlock()
+}
+
+func TestMaxRequestBytes(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.MaxRequestBytes = 100
+	logs := &sizeLog{}
+	a.SetCallLogger(logs)
+	ctx := context.Background()
+	big := map[string]string{"data": strings.Repeat("x", 200)}
+
+	// A buffered body over the limit isn't sent.
+	err := a.Post(ctx, "/items", big, nil)
+	var te *RequestTooLargeError
+	if assert.True(t, errors.As(err, &te), "%v", err) {
+		assert.Equal(t, "/items", te.Resource)
+		assert.Equal(t, int64(211), te.Size)
+		assert.Equal(t, int64(100), te.Limit)
+		assert.False(t, te.Streamed)
+	}
+	assert.True(t, errors.Is(err, ErrRequestTooLarge))
+	assert.EqualError(t, err, "api: request to /items too large: 211 bytes, limit 100")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	// The limit is overridden per call.
+	var meta ResponseMeta
+	assert.NoError(t, a.Post(ctx, "/items", big, nil, WithMaxRequestBytes(1<<20), WithMeta(&meta)))
+	assert.Equal(t, int64(211), meta.RequestSize)
+	assert.NoError(t, a.Post(ctx, "/items", big, nil, WithMaxRequestBytes(0)))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// A streamed body is aborted once it crosses the limit.
+	stream := func(n int) io.Reader { return io.MultiReader(strings.NewReader(strings.Repeat("y", n))) }
+	err = a.Upload(ctx, PUT, "/blob", "text/plain", stream(1000), nil, WithRetry(&RetryPolicy{MaxRetries: 2}))
+	if assert.True(t, errors.As(err, &te), "%v", err) {
+		assert.True(t, te.Streamed)
+		assert.Equal(t, "/blob", te.Resource)
+		assert.Equal(t, int64(101), te.Size)
+	}
+	assert.EqualError(t, te, "api: request to /blob too large: over 100 bytes streamed")
+
+	// The size of a streamed body is counted as it's sent.
+	assert.NoError(t, a.Upload(ctx, PUT, "/blob", "text/plain", stream(80), nil, WithMeta(&meta)))
+	assert.Equal(t, int64(80), meta.RequestSize)
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	if assert.Len(t, logs.sizes, 5) {
+		assert.Equal(t, int64(211), logs.sizes[1])
+		assert.Equal(t, int64(80), logs.sizes[4])
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// RequestSpecVersion is the version of the RequestSpec format written by SaveRequestSpec.
+const RequestSpecVersion = 1
+
+// ErrNotSerializable is returned by SaveRequestSpec for the requests whose body can't be saved.
+var ErrNotSerializable = errors.New("api: request not serializable")
+
+// RequestSpec is a request saved as data by SaveRequestSpec, e.g. to attach it to a support
+// escalation and send it again later with RequestFromSpec.
+type RequestSpec struct {
+	// Version is the version of the format, RequestSpecVersion.
+	Version int    `json:"version"`
+	Method  string `json:"method"`
+	// Resource is the path of the request relative to the base URI of the Api.
+	Resource string `json:"resource"`
+	// Query and Header are the query parameters and the headers of the request, in order.
+	Query  []SpecField `json:"query,omitempty"`
+	Header []SpecField `json:"header,omitempty"`
+	// Body is the request body, base64 encoded if Base64 is set, e.g. for binary bodies.
+	Body   string `json:"body,omitempty"`
+	Base64 bool   `json:"base64,omitempty"`
+}
+
+// SpecField is a query parameter or a header of a RequestSpec. The values of the ones matched by
+// the Redactor of the Api aren't saved, only flagged as Redacted.
+type SpecField struct {
+	Name     string `json:"name"`
+	Value    string `json:"value,omitempty"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
+// SaveRequestSpec saves req, made by a, as an indented JSON RequestSpec. The values of the
+// headers and the query parameters matched by the Redactor of a, like the credentials, aren't
+// saved. The body is read from a copy, so req can still be sent; the streamed bodies that can't
+// be replayed and the multipart bodies, whose boundaries and files don't make a spec one could
+// edit, fail with an error wrapping ErrNotSerializable.
+func (a *Api) SaveRequestSpec(req *http.Request) ([]byte, error) {
+	red := a.Redactor()
+	spec := RequestSpec{Version: RequestSpecVersion, Method: req.Method, Resource: a.relativeResource(req.URL)}
+	for _, pair := range strings.Split(req.URL.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := url.QueryUnescape(v)
+		if err != nil {
+			return nil, err
+		}
+		spec.Query = append(spec.Query, specField(name, value, matchAny(red.Query, name)))
+	}
+	names := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		for _, v := range req.Header[k] {
+			spec.Header = append(spec.Header, specField(k, v, matchAny(red.Headers, k)))
+		}
+	}
+	body, err := specBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if utf8.Valid(body) {
+		spec.Body = string(body)
+	} else {
+		spec.Body, spec.Base64 = base64.StdEncoding.EncodeToString(body), true
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+func specField(name, value string, redacted bool) SpecField {
+	if redacted {
+		return SpecField{Name: name, Redacted: true}
+	}
+	return SpecField{Name: name, Value: value}
+}
+
+// relativeResource returns the path of u relative to the base URI of a.
+func (a *Api) relativeResource(u *url.URL) string {
+	base := strings.TrimSuffix(a.baseURI().Path, "/")
+	if p := u.Path; strings.HasPrefix(p, base+"/") {
+		return strings.TrimPrefix(p, base)
+	}
+	return u.Path
+}
+
+// specBody returns a copy of the body of req.
+func specBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if mediatype, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); strings.HasPrefix(mediatype, "multipart/") {
+		return nil, fmt.Errorf("%w: %s body, save its parts and rebuild it from them instead", ErrNotSerializable, mediatype)
+	}
+	if data, ok := encodedBody(req); ok {
+		return data, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("%w: the streamed body can't be read twice", ErrNotSerializable)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// RequestFromSpec rebuilds the request saved by SaveRequestSpec against the current base URI of
+// a, like a request made by Request: the headers and query parameters whose values weren't saved
+// are left out, for the Api's Header, its TokenSource and its other preparers to add the current
+// credentials. A spec of an unknown version fails.
+func (a *Api) RequestFromSpec(data []byte) (*http.Request, error) {
+	var spec RequestSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("api: invalid request spec: %w", err)
+	}
+	if spec.Version != RequestSpecVersion {
+		return nil, fmt.Errorf("api: unsupported request spec version %d, want %d", spec.Version, RequestSpecVersion)
+	}
+	if spec.Method == "" {
+		return nil, errors.New("api: invalid request spec: no method")
+	}
+	u, err := a.resourceURL(spec.Resource)
+	if err != nil {
+		return nil, err
+	}
+	var query []string
+	for _, f := range spec.Query {
+		if !f.Redacted {
+			query = append(query, url.QueryEscape(f.Name)+"="+url.QueryEscape(f.Value))
+		}
+	}
+	u.RawQuery = strings.Join(query, "&")
+	req := newRequest(GET, u)
+	req.Method = spec.Method
+	for _, f := range spec.Header {
+		if !f.Redacted {
+			req.Header[http.CanonicalHeaderKey(f.Name)] = append(req.Header[http.CanonicalHeaderKey(f.Name)], f.Value)
+		}
+	}
+	body := []byte(spec.Body)
+	if spec.Base64 {
+		if body, err = base64.StdEncoding.DecodeString(spec.Body); err != nil {
+			return nil, fmt.Errorf("api: invalid request spec body: %w", err)
+		}
+	}
+	if len(body) > 0 {
+		setBytesBody(req, body)
+	}
+	if err := a.shape(req, nil); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestSpec(t *testing.T) {
+	a := MustNew("https://api.example.com/v1")
+	a.Header = http.Header{"Authorization": {"Bearer old"}, "X-Client": {"ops"}}
+	args := url.Values{}
+	args.Set("api_key", "k1")
+	orig, err := a.RequestJSONWithQuery(POST, "/tickets", args, map[string]interface{}{"title": "disk full", "tags": []string{"db"}}, WithHeader("X-Trace", "t1"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	data, err := a.SaveRequestSpec(orig)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotContains(t, string(data), "Bearer old")
+	assert.NotContains(t, string(data), "k1")
+	assert.Contains(t, string(data), `"redacted": true`)
+
+	// Rebuilt by another Api, the request gets its base and credentials.
+	b := MustNew("https://staging.example.com/v1")
+	b.Header = http.Header{"Authorization": {"Bearer new"}}
+	req, err := b.RequestFromSpec(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "https://staging.example.com/v1/tickets", req.URL.String())
+	assert.Equal(t, "Bearer new", req.Header.Get("Authorization"))
+	for k := range orig.Header {
+		if k != "Authorization" {
+			assert.Equal(t, orig.Header[k], req.Header[k], k)
+		}
+	}
+	assert.Len(t, req.Header, len(orig.Header))
+	want, _ := io.ReadAll(orig.Body)
+	got, _ := io.ReadAll(req.Body)
+	assert.Equal(t, string(want), string(got))
+	assert.Equal(t, orig.ContentLength, req.ContentLength)
+
+	// A binary body and a custom method round-trip too.
+	orig, err = a.RequestBytes(POST, "/blobs/1", "application/octet-stream", []byte{0xff, 0, 0xfe}, func(c *call) {
+		c.prepare = append(c.prepare, func(req *http.Request) error {
+			req.Method = "PROPPATCH"
+			return nil
+		})
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	data, err = a.SaveRequestSpec(orig)
+	if !assert.NoError(t, err) {
+		return
+	}
+	req, err = a.RequestFromSpec(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "PROPPATCH", req.Method)
+		assert.Equal(t, orig.URL.String(), req.URL.String())
+		got, _ = io.ReadAll(req.Body)
+		assert.Equal(t, []byte{0xff, 0, 0xfe}, got)
+	}
+}
+
+func TestRequestSpecErrors(t *testing.T) {
+	a := MustNew("https://api.example.com")
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	w.WriteField("name", "report")
+	w.Close()
+	req, err := a.RequestBytes(POST, "/upload", w.FormDataContentType(), buf.Bytes())
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = a.SaveRequestSpec(req)
+	assert.True(t, errors.Is(err, ErrNotSerializable), "%v", err)
+	assert.Contains(t, err.Error(), "multipart/form-data")
+
+	req, err = a.RequestReader(POST, "/upload", "text/plain", strings.NewReader("streamed"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	req.GetBody = nil
+	_, err = a.SaveRequestSpec(req)
+	assert.True(t, errors.Is(err, ErrNotSerializable), "%v", err)
+
+	_, err = a.RequestFromSpec([]byte(`{"version": 2, "method": "GET", "resource": "/"}`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported request spec version 2")
+	}
+	_, err = a.RequestFromSpec([]byte(`{"method": "GET"}`))
+	assert.Error(t, err)
+}
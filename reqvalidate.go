@@ -0,0 +1,151 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidRequestBody is matched by every *RequestBodyError.
+var ErrInvalidRequestBody = errors.New("api: invalid request body")
+
+// RequestBodyError is returned by the request constructors and the Do-style helpers when the
+// body fails the validation of ValidateRequests, before anything is sent. It's matched by
+// ErrInvalidRequestBody.
+type RequestBodyError struct {
+	Method string
+	// Resource is the resource of the request, the template for the calls of a Template.
+	Resource string
+	Err      error
+}
+
+func (e *RequestBodyError) Error() string {
+	return fmt.Sprintf("api: invalid request body for %s %s: %v", e.Method, e.Resource, e.Err)
+}
+
+// Is makes errors.Is(err, ErrInvalidRequestBody) report true.
+func (e *RequestBodyError) Is(target error) bool { return target == ErrInvalidRequestBody }
+
+func (e *RequestBodyError) Unwrap() error { return e.Err }
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *RequestBodyError) Class() Class { return Permanent }
+
+// ValidateRequests makes RequestJSON, RequestJSONWithQuery, RequestSOAP, the Do-style helpers
+// sending a body and the calls of a Template check the Go value of the body with fn before
+// it's encoded, failing with a *RequestBodyError if fn returns an error, so the payloads the
+// server would reject with a 422 are caught without a round trip. fn is typically the Validate
+// function of a struct tag validator; RequiredTags is a minimal one. The *Form bodies aren't
+// checked. SkipRequestValidation disables it for a call. A nil fn disables it.
+func (a *Api) ValidateRequests(fn func(v interface{}) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.validateBody = fn
+}
+
+// SkipRequestValidation skips the validation of ValidateRequests for the call, e.g. for a test
+// sending an invalid body on purpose. It applies to the request constructors too.
+func SkipRequestValidation() Option {
+	return func(c *call) {
+		c.anyBody = true
+	}
+}
+
+// validateRequestBody checks the body v of a request to resource with the validator of
+// ValidateRequests, unless opts skip it.
+func (a *Api) validateRequestBody(method Method, resource string, v interface{}, opts []Option) error {
+	a.mu.Lock()
+	fn := a.validateBody
+	a.mu.Unlock()
+	if fn == nil || v == nil {
+		return nil
+	}
+	if _, ok := v.(*Form); ok {
+		return nil
+	}
+	err := fn(v)
+	if err == nil {
+		return nil
+	}
+	c := &call{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.anyBody {
+		return nil
+	}
+	return &RequestBodyError{Method: method.String(), Resource: resource, Err: err}
+}
+
+// RequiredTags is a validator for ValidateRequests requiring the fields of structs tagged
+// `api:"required"` not to be zero, e.g. an empty string or a nil pointer, in v and in the structs
+// it holds, through pointers, slices, arrays and maps. The error lists the missing fields by
+// their JSON paths in order, e.g. "missing required fields items[1].sku, name".
+func RequiredTags(v interface{}) error {
+	var missing []string
+	requiredFields(reflect.ValueOf(v), "", &missing, nil)
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("missing required fields %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// requiredFields appends the paths of the zero required fields of v at path to missing. The
+// pointers in seen are the ones being visited, so cycles end.
+func requiredFields(v reflect.Value, path string, missing *[]string, seen []uintptr) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if v.Kind() == reflect.Pointer {
+			for _, p := range seen {
+				if p == v.Pointer() {
+					return
+				}
+			}
+			seen = append(seen, v.Pointer())
+		}
+		requiredFields(v.Elem(), path, missing, seen)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			requiredFields(v.Index(i), fmt.Sprintf("%s[%d]", path, i), missing, seen)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			requiredFields(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), missing, seen)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			fieldPath := path
+			if !sf.Anonymous || name != "" {
+				if name == "" {
+					name = sf.Name
+				}
+				if fieldPath != "" {
+					fieldPath += "."
+				}
+				fieldPath += name
+			}
+			f := v.Field(i)
+			if sf.Tag.Get("api") == "required" && f.IsZero() {
+				*missing = append(*missing, fieldPath)
+				continue
+			}
+			requiredFields(f, fieldPath, missing, seen)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type orderLine struct {
+	SKU      string `json:"sku" api:"required"`
+	Quantity int    `json:"quantity"`
+}
+
+type order struct {
+	Name    string      `json:"name" api:"required"`
+	Note    string      `json:"note,omitempty"`
+	Lines   []orderLine `json:"lines" api:"required"`
+	Billing *struct {
+		City string `json:"city" api:"required"`
+	} `json:"billing,omitempty"`
+	Tags map[string]orderLine `json:"tags,omitempty"`
+}
+
+func TestRequiredTags(t *testing.T) {
+	assert.NoError(t, RequiredTags(order{Name: "a", Lines: []orderLine{{SKU: "x"}}}))
+	assert.NoError(t, RequiredTags(nil))
+	assert.NoError(t, RequiredTags(map[string]int{}))
+	assert.EqualError(t, RequiredTags(&order{}), "missing required fields lines, name")
+	o := &order{Name: "a", Lines: []orderLine{{SKU: "x"}, {Quantity: 2}}, Tags: map[string]orderLine{"k": {}}}
+	o.Billing = &struct {
+		City string `json:"city" api:"required"`
+	}{}
+	assert.EqualError(t, RequiredTags(o), "missing required fields billing.city, lines[1].sku, tags[k].sku")
+	assert.EqualError(t, RequiredTags([]order{{Name: "a", Lines: []orderLine{{}}}}), "missing required fields [0].lines[0].sku")
+}
+
+func TestValidateRequests(t *testing.T) {
+	var sent int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.ValidateRequests(RequiredTags)
+	ctx := context.Background()
+
+	err := a.Post(ctx, "/orders", &order{Name: "a"}, nil)
+	var be *RequestBodyError
+	if assert.True(t, errors.As(err, &be)) {
+		assert.Equal(t, "POST", be.Method)
+		assert.Equal(t, "/orders", be.Resource)
+	}
+	assert.ErrorIs(t, err, ErrInvalidRequestBody)
+	assert.Equal(t, Permanent, Classify(err))
+	assert.EqualError(t, err, "api: invalid request body for POST /orders: missing required fields lines")
+	_, err = a.RequestJSON(PUT, "/orders/1", order{})
+	assert.ErrorIs(t, err, ErrInvalidRequestBody)
+	err = a.Template(PATCH, "/orders/{id}").DoBody(ctx, map[string]string{"id": "7"}, nil, order{}, nil)
+	if assert.True(t, errors.As(err, &be)) {
+		assert.Equal(t, "/orders/{id}", be.Resource)
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&sent))
+
+	// The valid bodies, the forms and the bodies of the calls skipping it are sent.
+	assert.NoError(t, a.Post(ctx, "/orders", order{Name: "a", Lines: []orderLine{{SKU: "x"}}}, nil))
+	assert.NoError(t, a.Post(ctx, "/orders", (&Form{}).Append("name", ""), nil))
+	assert.NoError(t, a.Post(ctx, "/orders", order{}, nil, SkipRequestValidation()))
+	req, err := a.RequestJSON(POST, "/orders", order{}, SkipRequestValidation())
+	if assert.NoError(t, err) {
+		resp, err := a.Do(ctx, req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}
+	assert.NoError(t, a.Template(PATCH, "/orders/{id}", SkipRequestValidation()).DoBody(ctx, map[string]string{"id": "7"}, nil, order{}, nil))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&sent))
+
+	// A custom validator gets the Go value.
+	var got interface{}
+	a.ValidateRequests(func(v interface{}) error {
+		got = v
+		if o, ok := v.(order); ok && o.Note == "" {
+			return errors.New("note is required for orders")
+		}
+		return nil
+	})
+	err = a.Put(ctx, "/orders/1", order{Name: "a"}, nil)
+	assert.EqualError(t, err, "api: invalid request body for PUT /orders/1: note is required for orders")
+	assert.Equal(t, order{Name: "a"}, got)
+	assert.NoError(t, a.Put(ctx, "/orders/1", map[string]string{"x": "y"}, nil))
+
+	a.ValidateRequests(nil)
+	assert.NoError(t, a.Post(ctx, "/orders", order{}, nil))
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how the Do-style helpers retry failed calls.
+// Requests whose body can't be replayed are never retried, unless the server answered a request
+// made with ExpectContinue before its body was sent.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries after the first attempt.
+	MaxRetries int
+	// MinBackoff is the base of the exponential backoff, 100ms if zero.
+	MinBackoff time.Duration
+	// MaxBackoff caps the backoff, 10s if zero. A Retry-After sent by the server is honored even if larger.
+	MaxBackoff time.Duration
+	// Retryable decides whether an error is worth retrying, IsRetryable if nil.
+	Retryable func(err error) bool
+	// RetryResponse decides whether a successful response is worth retrying anyway, e.g. a 200
+	// with {"status":"PENDING"} while a resource is being provisioned, given its body. A positive
+	// after is the delay before the retry instead of the backoff. Once the retries are exhausted or
+	// RetryResponse says stop, the last response is returned with its body intact.
+	RetryResponse func(status int, header http.Header, body []byte) (retry bool, after time.Duration)
+	// MaxResponseBody is the size up to which the bodies are read for RetryResponse, 64KB if zero.
+	// The larger bodies aren't retried and are returned as they are.
+	MaxResponseBody int64
+	// Rand is the source of the jitter of the backoff, the global source of math/rand if nil.
+	// Set it to a seeded source to get deterministic backoffs in tests.
+	Rand *rand.Rand
+}
+
+// defaultMaxResponseBody is the size of the bodies read for RetryResponse unless MaxResponseBody is set.
+const defaultMaxResponseBody = 64 << 10
+
+// backoff returns how long to wait before retrying the failed attempt, or false if it shouldn't be retried.
+// The delay is an exponential backoff with full jitter, unless the server asked for a delay via Retry-After.
+func (p *RetryPolicy) backoff(attempt int, err error, now time.Time) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxRetries {
+		return 0, false
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	if !retryable(err) {
+		return 0, false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		if d, ok := ParseRetryAfter(se.Header, now); ok {
+			return d, true
+		}
+	}
+	return p.delay(attempt), true
+}
+
+// delay returns the exponential backoff with full jitter before retrying attempt.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	min, max := p.MinBackoff, p.MaxBackoff
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 10 * time.Second
+	}
+	d := max
+	if attempt < 32 {
+		if d = min << uint(attempt); d > max || d <= 0 {
+			d = max
+		}
+	}
+	return time.Duration(int63n(p.Rand, int64(d)+1))
+}
+
+// randMu guards the sources of RetryPolicy and WarmOptions, since a *rand.Rand isn't safe for
+// concurrent use.
+var randMu sync.Mutex
+
+// int63n returns a random number in [0, n) from r, or from the global source if r is nil.
+func int63n(r *rand.Rand, n int64) int64 {
+	if r == nil {
+		return rand.Int63n(n)
+	}
+	randMu.Lock()
+	defer randMu.Unlock()
+	return r.Int63n(n)
+}
+
+// retryResponse evaluates RetryResponse on resp, whose body is read and replaced by a copy,
+// returning whether the attempt should be retried and after how long. It fails if the body
+// couldn't be read, which is then closed.
+func (p *RetryPolicy) retryResponse(attempt int, resp *http.Response) (bool, time.Duration, error) {
+	if p == nil || p.RetryResponse == nil {
+		return false, 0, nil
+	}
+	limit := p.MaxResponseBody
+	if limit <= 0 {
+		limit = defaultMaxResponseBody
+	}
+	body := resp.Body
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		body.Close()
+		return false, 0, err
+	}
+	if int64(len(data)) > limit {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), body), body}
+		return false, 0, nil
+	}
+	body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	retry, after := p.RetryResponse(resp.StatusCode, resp.Header, data)
+	if !retry || attempt >= p.MaxRetries {
+		return false, 0, nil
+	}
+	if after <= 0 {
+		after = p.delay(attempt)
+	}
+	return true, after, nil
+}
+
+// rewind prepares the request body for another attempt. It fails for bodies that can't be replayed.
+func rewind(req *http.Request) error {
+	if b, ok := req.Body.(*continueBody); ok && b.rewind() {
+		// The server answered before the body was sent.
+		return nil
+	}
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+		return nil
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		req.Body = body
+		return nil
+	}
+	if s, ok := req.Body.(io.Seeker); ok {
+		_, err := s.Seek(0, io.SeekStart)
+		return err
+	}
+	return errors.New("api: request body can't be replayed")
+}
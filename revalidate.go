@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// RevalidateEntry is a resource of a local index revalidated by Revalidate, with the validators
+// of the version of the index.
+type RevalidateEntry struct {
+	Resource     string
+	ETag         string
+	LastModified string
+}
+
+// RevalidateOptions configures Revalidate.
+type RevalidateOptions struct {
+	// MaxErrors is the number of failed entries the sweep tolerates: once more entries failed,
+	// the entries not started yet are skipped. Zero never aborts the sweep.
+	MaxErrors int
+	// Options are applied to every conditional request.
+	Options []Option
+}
+
+// RevalidateSummary counts the outcomes of the entries of a Revalidate sweep.
+type RevalidateSummary struct {
+	Unchanged, Changed, Gone, Errored int
+	// Skipped is the number of entries not checked because the sweep was aborted or its context
+	// was done.
+	Skipped int
+	// Failed are the failed entries, by ascending index.
+	Failed []RevalidateFailure
+}
+
+// RevalidateFailure is an entry that failed in Revalidate.
+type RevalidateFailure struct {
+	// Index is the index of the entry in entries.
+	Index int
+	// Err is the error of the request, or the one returned by fn for the entry.
+	Err error
+}
+
+// Revalidate sends a conditional GET for every entry, with its validators in If-None-Match and
+// If-Modified-Since, from up to concurrency calls in flight, and calls fn with the index of the
+// entry, whether it changed, and the response:
+//
+//   - a 304 Not Modified, or a 200 carrying the validators of the entry for the servers ignoring
+//     the conditional headers, is unchanged;
+//   - another 2xx is changed, fn reading the new version from the body;
+//   - a 404 Not Found or a 410 Gone is gone, and reported as changed.
+//
+// The body is closed once fn returns, and fn is called concurrently. The calls go through the
+// Api like that of Get, so they're retried, throttled and shed like the others, and wait for the
+// Backoff hints of SetHints. The other failures, and the errors returned by fn, don't stop the
+// sweep unless there are more than opts.MaxErrors of them; Revalidate then returns the error of
+// the last one with the summary. Revalidate returns ctx.Err() if it's done before the end of
+// the sweep. A nil opts uses the defaults of RevalidateOptions.
+func (a *Api) Revalidate(ctx context.Context, entries []RevalidateEntry, concurrency int, fn func(i int, changed bool, resp *http.Response) error, opts *RevalidateOptions) (*RevalidateSummary, error) {
+	if opts == nil {
+		opts = &RevalidateOptions{}
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		mu      sync.Mutex
+		summary = &RevalidateSummary{}
+		aborted error
+	)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(concurrency, len(entries)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				mu.Lock()
+				stop := aborted != nil
+				mu.Unlock()
+				if stop {
+					continue
+				}
+				outcome, err := a.revalidate(ctx, entries[i], opts.Options, func(changed bool, resp *http.Response) error {
+					return fn(i, changed, resp)
+				})
+				mu.Lock()
+				switch {
+				case err != nil:
+					summary.Errored++
+					summary.Failed = append(summary.Failed, RevalidateFailure{Index: i, Err: err})
+					if opts.MaxErrors > 0 && summary.Errored > opts.MaxErrors && aborted == nil {
+						aborted = fmt.Errorf("api: revalidation aborted after %d errors: %w", summary.Errored, err)
+					}
+				case outcome == revalidateGone:
+					summary.Gone++
+				case outcome == revalidateChanged:
+					summary.Changed++
+				default:
+					summary.Unchanged++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	sent := 0
+feed:
+	for ; sent < len(entries); sent++ {
+		mu.Lock()
+		stop := aborted != nil
+		mu.Unlock()
+		if stop {
+			break
+		}
+		select {
+		case next <- sent:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	summary.Skipped = len(entries) - summary.Unchanged - summary.Changed - summary.Gone - summary.Errored
+	sort.Slice(summary.Failed, func(i, j int) bool { return summary.Failed[i].Index < summary.Failed[j].Index })
+	if aborted != nil {
+		return summary, aborted
+	}
+	return summary, ctx.Err()
+}
+
+type revalidateOutcome int
+
+const (
+	revalidateUnchanged revalidateOutcome = iota
+	revalidateChanged
+	revalidateGone
+)
+
+// revalidate sends the conditional GET of an entry and passes its response to fn.
+func (a *Api) revalidate(ctx context.Context, e RevalidateEntry, opts []Option, fn func(changed bool, resp *http.Response) error) (revalidateOutcome, error) {
+	req, err := a.emptyRequest(GET, e.Resource, buildContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+	resp, err := a.send(ctx, a.newCallFor(e.Resource, opts), req)
+	outcome := revalidateChanged
+	var se *StatusError
+	if errors.As(err, &se) {
+		switch se.Code {
+		case http.StatusNotModified:
+			outcome = revalidateUnchanged
+		case http.StatusNotFound, http.StatusGone:
+			outcome = revalidateGone
+		default:
+			return 0, err
+		}
+		// The body of a failed response was consumed by the checks.
+		resp = &http.Response{Status: se.Status, StatusCode: se.Code, Header: se.Header,
+			Body: io.NopCloser(bytes.NewReader(se.Body)), ContentLength: int64(len(se.Body)), Request: req}
+	} else if err != nil {
+		return 0, err
+	}
+	defer drainClose(resp.Body)
+	if outcome == revalidateChanged {
+		etag, lm := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		if etag != "" && etag == e.ETag || etag == "" && lm != "" && lm == e.LastModified {
+			outcome = revalidateUnchanged
+		}
+	}
+	if err := fn(outcome != revalidateUnchanged, resp); err != nil {
+		return 0, err
+	}
+	return outcome, nil
+}
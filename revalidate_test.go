@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevalidate(t *testing.T) {
+	var inFlight, max atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := max.Load(); n > m && !max.CompareAndSwap(m, n); m = max.Load() {
+		}
+		switch r.URL.Path {
+		case "/docs/1":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/docs/2":
+			w.Header().Set("ETag", `"v3"`)
+			io.WriteString(w, "new")
+		case "/docs/3":
+			w.WriteHeader(http.StatusNotFound)
+		case "/docs/4":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/docs/5":
+			// The conditional headers are ignored.
+			w.Header().Set("Last-Modified", r.Header.Get("If-Modified-Since"))
+			io.WriteString(w, "same")
+		case "/docs/6":
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	entries := []RevalidateEntry{
+		{Resource: "/docs/1", ETag: `"v1"`},
+		{Resource: "/docs/2", ETag: `"v2"`},
+		{Resource: "/docs/3", ETag: `"v1"`},
+		{Resource: "/docs/4", ETag: `"v1"`},
+		{Resource: "/docs/5", LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"},
+		{Resource: "/docs/6"},
+	}
+	type call struct {
+		changed bool
+		status  int
+		body    string
+	}
+	var mu sync.Mutex
+	calls := make(map[int]call)
+	summary, err := a.Revalidate(context.Background(), entries, 3, func(i int, changed bool, resp *http.Response) error {
+		body, _ := io.ReadAll(resp.Body)
+		mu.Lock()
+		calls[i] = call{changed, resp.StatusCode, string(body)}
+		mu.Unlock()
+		return nil
+	}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[int]call{
+		0: {false, http.StatusNotModified, ""},
+		1: {true, http.StatusOK, "new"},
+		2: {true, http.StatusNotFound, ""},
+		4: {false, http.StatusOK, "same"},
+		5: {true, http.StatusGone, ""},
+	}, calls)
+	assert.Equal(t, 2, summary.Unchanged)
+	assert.Equal(t, 1, summary.Changed)
+	assert.Equal(t, 2, summary.Gone)
+	assert.Equal(t, 1, summary.Errored)
+	assert.Zero(t, summary.Skipped)
+	if assert.Len(t, summary.Failed, 1) {
+		assert.Equal(t, 3, summary.Failed[0].Index)
+		var se *StatusError
+		assert.True(t, errors.As(summary.Failed[0].Err, &se))
+	}
+	assert.LessOrEqual(t, max.Load(), int32(3))
+}
+
+func TestRevalidateMaxErrors(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	entries := make([]RevalidateEntry, 10)
+	for i := range entries {
+		entries[i].Resource = "/docs/" + strconv.Itoa(i)
+	}
+	noop := func(int, bool, *http.Response) error { return nil }
+
+	summary, err := a.Revalidate(context.Background(), entries, 1, noop, &RevalidateOptions{MaxErrors: 2})
+	var se *StatusError
+	assert.True(t, errors.As(err, &se), "%v", err)
+	assert.Equal(t, 3, summary.Errored)
+	assert.Equal(t, 7, summary.Skipped)
+	assert.Equal(t, int32(3), requests.Load())
+
+	// An error of fn fails its entry too.
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	failing := errors.New("index full")
+	summary, err = a.Revalidate(context.Background(), entries[:4], 2, func(i int, _ bool, _ *http.Response) error {
+		if i%2 == 1 {
+			return failing
+		}
+		return nil
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, summary.Changed)
+	assert.Equal(t, []RevalidateFailure{{Index: 1, Err: failing}, {Index: 3, Err: failing}}, summary.Failed)
+}
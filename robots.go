@@ -0,0 +1,347 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDisallowedByRobots is matched by every *RobotsError.
+var ErrDisallowedByRobots = errors.New("api: disallowed by robots.txt")
+
+// RobotsError is returned for the calls to a path the robots.txt of its host disallows, see
+// SetPoliteness.
+type RobotsError struct {
+	Method string
+	// URL is the URL of the request, redacted.
+	URL string
+	// Agent is the user agent of the group of rules applied, "*" for the default one, and Rule
+	// the path pattern of the Disallow rule matched.
+	Agent, Rule string
+}
+
+func (e *RobotsError) Error() string {
+	return fmt.Sprintf("api: %s %s disallowed by robots.txt (user-agent %s, disallow %s)", e.Method, e.URL, e.Agent, e.Rule)
+}
+
+// Is makes errors.Is(err, ErrDisallowedByRobots) report true.
+func (e *RobotsError) Is(target error) bool { return target == ErrDisallowedByRobots }
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *RobotsError) Class() Class { return Permanent }
+
+// Politeness configures SetPoliteness.
+type Politeness struct {
+	// UserAgent is the product token the groups of robots.txt are matched against, e.g. "MyBot",
+	// the one of the User-Agent of the Header of the Api if empty, "MyBot" for
+	// "MyBot/1.2 (+https://example.com/bot)". Only the "*" group applies without either.
+	UserAgent string
+	// Delay is the minimum time between the starts of the requests to a host whose robots.txt
+	// has no Crawl-delay for the agent.
+	Delay time.Duration
+	// TTL is how long a robots.txt is kept before it's fetched again, a day if zero.
+	TTL time.Duration
+}
+
+func (p *Politeness) ttl() time.Duration {
+	return durationOr(p.TTL, 24*time.Hour)
+}
+
+// SetPoliteness makes the calls of the Api respect the robots.txt of their host, e.g. for
+// walking a public API or feed on behalf of a crawler. It's fetched by the first call to the
+// host, sent with the User-Agent of the Header, and fetched again by the first call after its
+// TTL: the calls to the paths it disallows for the agent fail with a *RobotsError, and the
+// others are spaced by its Crawl-delay, or else by the Delay of p, sleeping on the clock of the
+// Api. The rules are matched against the path and query of the request: "*" matches any
+// sequence of characters and a trailing "$" the end of the path, and the longest pattern
+// matching wins, Allow over Disallow for patterns as long. A robots.txt missing, or answered
+// with another 4xx status, allows everything; a call fails with the error of fetching it when
+// it can't be fetched otherwise, while a robots.txt already fetched is kept until the next
+// call when refreshing it fails. A nil p disables it.
+func (a *Api) SetPoliteness(p *Politeness) {
+	if p == nil {
+		a.politeness.Store(nil)
+		return
+	}
+	a.politeness.Store(&politeness{Politeness: *p, hosts: make(map[string]*robotsHost)})
+}
+
+// politeness is the state of SetPoliteness.
+type politeness struct {
+	Politeness
+
+	mu    sync.Mutex
+	hosts map[string]*robotsHost
+}
+
+// robotsHost is the robots.txt of a host and the time of its next request.
+type robotsHost struct {
+	// fetching serializes the fetches of the robots.txt.
+	fetching sync.Mutex
+
+	mu      sync.Mutex
+	robots  *robots
+	expires time.Time
+	next    time.Time
+}
+
+func (p *politeness) host(key string) *robotsHost {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.hosts[key]
+	if h == nil {
+		h = &robotsHost{}
+		p.hosts[key] = h
+	}
+	return h
+}
+
+// polite checks req against the robots.txt of its host and waits for its turn to be sent.
+func (a *Api) polite(ctx context.Context, p *politeness, req *http.Request, clk Clock) error {
+	h := p.host(req.URL.Scheme + "://" + req.URL.Host)
+	r, err := a.robotsOf(ctx, p, h, req, clk)
+	if err != nil {
+		return err
+	}
+	if req.URL.Path != "/robots.txt" {
+		path := req.URL.EscapedPath()
+		if req.URL.RawQuery != "" {
+			path += "?" + req.URL.RawQuery
+		}
+		if rule, ok := r.disallowed(path); ok {
+			return &RobotsError{Method: req.Method, URL: req.URL.Redacted(), Agent: r.agent, Rule: rule}
+		}
+	}
+	delay := p.Delay
+	if r.delay >= 0 {
+		delay = r.delay
+	}
+	now := clk.Now()
+	h.mu.Lock()
+	at := h.next
+	if at.Before(now) {
+		at = now
+	}
+	h.next = at.Add(delay)
+	h.mu.Unlock()
+	if d := at.Sub(now); d > 0 {
+		return clk.Sleep(ctx, d)
+	}
+	return nil
+}
+
+// robotsOf returns the robots.txt of h, fetching it for req if it's missing or expired.
+func (a *Api) robotsOf(ctx context.Context, p *politeness, h *robotsHost, req *http.Request, clk Clock) (*robots, error) {
+	h.mu.Lock()
+	r := h.robots
+	fresh := r != nil && clk.Now().Before(h.expires)
+	h.mu.Unlock()
+	if fresh {
+		return r, nil
+	}
+	h.fetching.Lock()
+	defer h.fetching.Unlock()
+	h.mu.Lock()
+	r = h.robots
+	fresh = r != nil && clk.Now().Before(h.expires)
+	h.mu.Unlock()
+	if fresh {
+		return r, nil
+	}
+	agent := p.UserAgent
+	if agent == "" {
+		agent = productToken(a.Header.Get("User-Agent"))
+	}
+	fetched, err := a.fetchRobots(ctx, req, agent)
+	if err != nil {
+		if r != nil {
+			return r, nil
+		}
+		return nil, err
+	}
+	h.mu.Lock()
+	h.robots, h.expires = fetched, clk.Now().Add(p.ttl())
+	h.mu.Unlock()
+	return fetched, nil
+}
+
+// maxRobots is the size of a robots.txt read, the rest being ignored.
+const maxRobots = 500 << 10
+
+// fetchRobots fetches the robots.txt of the host of req and parses its rules for agent.
+func (a *Api) fetchRobots(ctx context.Context, req *http.Request, agent string) (*robots, error) {
+	u := *req.URL
+	u.Path, u.RawPath, u.RawQuery, u.Fragment, u.User = "/robots.txt", "", "", "", nil
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if ua := a.Header.Get("User-Agent"); ua != "" {
+		r.Header.Set("User-Agent", ua)
+	}
+	resp, err := a.client().Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("api: fetching robots.txt: %w", err)
+	}
+	defer drainClose(resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobots))
+		if err != nil {
+			return nil, fmt.Errorf("api: fetching robots.txt: %w", err)
+		}
+		return parseRobots(body, agent), nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &robots{agent: "*", delay: -1}, nil
+	default:
+		return nil, fmt.Errorf("api: fetching robots.txt: status %s", resp.Status)
+	}
+}
+
+// productToken returns the product token of the User-Agent ua, its part before the version.
+func productToken(ua string) string {
+	if i := strings.IndexAny(ua, "/ \t"); i >= 0 {
+		ua = ua[:i]
+	}
+	return ua
+}
+
+// robots are the rules of a robots.txt applying to an agent.
+type robots struct {
+	// agent is the user agent of the group applied, "*" for the default one.
+	agent string
+	rules []robotsRule
+	// delay is the Crawl-delay, -1 if there's none.
+	delay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsGroup is a group of rules of a robots.txt, for one or more agents.
+type robotsGroup struct {
+	agents []string
+	rules  []robotsRule
+	delay  time.Duration
+}
+
+// parseRobots parses the rules of the robots.txt body applying to agent: the ones of the groups
+// of the agent, matched case-insensitively, or else the ones of the "*" groups.
+func parseRobots(body []byte, agent string) *robots {
+	var groups []*robotsGroup
+	var g *robotsGroup
+	// agents is set while reading the User-agent lines of a group.
+	agents := false
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if !agents {
+				g = &robotsGroup{delay: -1}
+				groups = append(groups, g)
+				agents = true
+			}
+			g.agents = append(g.agents, productToken(value))
+			continue
+		case "allow", "disallow":
+			if g != nil && value != "" {
+				g.rules = append(g.rules, robotsRule{allow: key == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			if secs, err := strconv.ParseFloat(value, 64); g != nil && err == nil && secs >= 0 {
+				g.delay = time.Duration(secs * float64(time.Second))
+			}
+		}
+		agents = false
+	}
+	r := &robots{agent: "*", delay: -1}
+	if agent != "" && r.merge(groups, agent) {
+		r.agent = agent
+		return r
+	}
+	r.merge(groups, "*")
+	return r
+}
+
+// merge adds the rules of the groups of agent to r, reporting whether there's any.
+func (r *robots) merge(groups []*robotsGroup, agent string) bool {
+	found := false
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if strings.EqualFold(a, agent) {
+				found = true
+				r.rules = append(r.rules, g.rules...)
+				if g.delay >= 0 {
+					r.delay = g.delay
+				}
+				break
+			}
+		}
+	}
+	return found
+}
+
+// disallowed returns the pattern of the rule disallowing path, if any.
+func (r *robots) disallowed(path string) (string, bool) {
+	var best *robotsRule
+	for i, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if best == nil || len(rule.pattern) > len(best.pattern) || len(rule.pattern) == len(best.pattern) && rule.allow {
+			best = &r.rules[i]
+		}
+	}
+	if best == nil || best.allow {
+		return "", false
+	}
+	return best.pattern, true
+}
+
+// robotsMatch reports whether the rule pattern matches path: "*" matches any sequence of
+// characters, a trailing "$" the end of path, and the pattern is a prefix otherwise.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
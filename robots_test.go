@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestParseRobots(t *testing.T) {
+	body := []byte(`# robots
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Crawl-delay: 5
+
+User-agent: OtherBot
+User-agent: mybot  # ours
+Disallow: /*.json$
+Disallow: /search*q=
+Allow: /search
+Disallow: /feeds/
+Allow: /feeds/*/public
+Crawl-delay: 1.5
+
+Sitemap: https://example.com/sitemap.xml
+`)
+	r := parseRobots(body, "MyBot")
+	assert.Equal(t, "MyBot", r.agent)
+	assert.Equal(t, 1500*time.Millisecond, r.delay)
+	for _, tc := range []struct {
+		path string
+		rule string
+	}{
+		{"/private", ""},
+		{"/items.json", "/*.json$"},
+		{"/items.json?page=2", ""},
+		{"/a/b/c.json", "/*.json$"},
+		{"/search?q=go", "/search*q="},
+		{"/search?page=1", ""},
+		{"/feeds/1", "/feeds/"},
+		{"/feeds/1/public", ""},
+		{"/feeds/1/public/x.json", ""},
+		{"/search/x.json", "/*.json$"},
+	} {
+		rule, ok := r.disallowed(tc.path)
+		assert.Equal(t, tc.rule != "", ok, tc.path)
+		assert.Equal(t, tc.rule, rule, tc.path)
+	}
+
+	// Without a group of its own, an agent gets the ones of "*".
+	r = parseRobots(body, "ThirdBot")
+	assert.Equal(t, "*", r.agent)
+	assert.Equal(t, 5*time.Second, r.delay)
+	rule, ok := r.disallowed("/private/x")
+	assert.True(t, ok)
+	assert.Equal(t, "/private", rule)
+	_, ok = r.disallowed("/private/public/x")
+	assert.False(t, ok)
+	_, ok = r.disallowed("/items.json")
+	assert.False(t, ok)
+
+	// The longest pattern wins, Allow over Disallow for patterns as long.
+	r = parseRobots([]byte("User-agent: *\nDisallow: /a\nAllow: /a\nDisallow: /b$\nAllow: /b*\n"), "")
+	_, ok = r.disallowed("/a")
+	assert.False(t, ok)
+	_, ok = r.disallowed("/b")
+	assert.False(t, ok)
+	assert.Equal(t, time.Duration(-1), r.delay)
+}
+
+func TestPoliteness(t *testing.T) {
+	var mu sync.Mutex
+	robotsTxt := "User-agent: *\nDisallow: /\n\nUser-agent: mybot\nDisallow: /private\nCrawl-delay: 2\n"
+	var fetches atomic.Int32
+	var agent atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fetches.Add(1)
+			agent.Store(r.Header.Get("User-Agent"))
+			mu.Lock()
+			w.Write([]byte(robotsTxt))
+			mu.Unlock()
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Header = http.Header{"User-Agent": {"MyBot/1.0 (+https://example.com/bot)"}}
+	clk := clock.NewFake(time.Now())
+	a.SetClock(clk)
+	a.SetPoliteness(&Politeness{Delay: time.Second, TTL: time.Hour})
+
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/items", nil, nil))
+	assert.Equal(t, int32(1), fetches.Load())
+	assert.Equal(t, "MyBot/1.0 (+https://example.com/bot)", agent.Load())
+
+	err := a.DoJSON(context.Background(), GET, "/private/1", nil, nil)
+	assert.True(t, errors.Is(err, ErrDisallowedByRobots), err)
+	var re *RobotsError
+	if assert.ErrorAs(t, err, &re) {
+		assert.Equal(t, "GET", re.Method)
+		assert.Equal(t, srv.URL+"/private/1", re.URL)
+		assert.Equal(t, "MyBot", re.Agent)
+		assert.Equal(t, "/private", re.Rule)
+	}
+	assert.Equal(t, Blocked, KindOf(err))
+
+	// The next call waits for the Crawl-delay since the first one.
+	done := make(chan error, 1)
+	go func() { done <- a.DoJSON(context.Background(), GET, "/items", nil, nil) }()
+	clk.BlockUntil(1)
+	assert.Equal(t, []time.Time{clk.Now().Add(2 * time.Second)}, clk.Deadlines())
+	select {
+	case err := <-done:
+		t.Fatalf("the call didn't wait: %v", err)
+	default:
+	}
+	clk.Advance(2 * time.Second)
+	assert.NoError(t, <-done)
+
+	// The robots.txt is fetched again after its TTL.
+	mu.Lock()
+	robotsTxt = "User-agent: *\nDisallow: /items\n"
+	mu.Unlock()
+	clk.Advance(30 * time.Minute)
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/items", nil, nil))
+	assert.Equal(t, int32(1), fetches.Load())
+	clk.Advance(30 * time.Minute)
+	err = a.DoJSON(context.Background(), GET, "/items", nil, nil)
+	assert.True(t, errors.Is(err, ErrDisallowedByRobots), err)
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// Without a Crawl-delay, the calls are spaced by the Delay of the policy.
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/other", nil, nil))
+	go func() { done <- a.DoJSON(context.Background(), GET, "/other", nil, nil) }()
+	clk.BlockUntil(1)
+	assert.Equal(t, []time.Time{clk.Now().Add(time.Second)}, clk.Deadlines())
+	clk.Advance(time.Second)
+	assert.NoError(t, <-done)
+}
+
+func TestPolitenessMissingRobots(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetPoliteness(&Politeness{})
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/private", nil, nil))
+
+	a.SetPoliteness(nil)
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/private", nil, nil))
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// rotation is a credential rotation in its grace period, see RotateCredentials.
+type rotation struct {
+	current, previous TokenSource
+	until             time.Time
+}
+
+// CredentialStats are the counters of the calls served during the grace periods of
+// RotateCredentials.
+type CredentialStats struct {
+	// Current is the number of calls served with the credential rotated to, Previous the number
+	// of those served with the one rotated from.
+	Current  int64
+	Previous int64
+	// Retries is the number of calls sent again with the other credential after a 401.
+	Retries int64
+}
+
+// credentialStats are the counters behind CredentialStats.
+type credentialStats struct {
+	current, previous, retries atomic.Int64
+}
+
+// RotateCredentials makes ts the TokenSource of the Api like SetTokenSource, keeping the previous
+// one for the grace period, measured with the Api's Clock, so the keys can be rotated without
+// failing calls whichever side switches first:
+//
+//	a.RotateCredentials(newSource, 5*time.Minute)
+//
+// During the grace period, the calls getting a 401 are sent again once with the token of the
+// other source, if their body is replayable: the previous one for the requests carrying the new
+// token, the new one for those created before the rotation. It only applies to the calls whose
+// token is the Api's one, not those of AuthForPrefix. CredentialStats counts the credential each
+// of them was served with. When the grace period is over, the previous source is dropped and
+// closed, if it's an io.Closer. Rotating again during a grace period drops the source it kept at
+// once. A zero grace drops the previous source right away.
+func (a *Api) RotateCredentials(ts TokenSource, grace time.Duration) {
+	clk := a.clock()
+	a.mu.Lock()
+	prev, dropped := a.tokens, a.rotation
+	a.tokens, a.rotation = ts, nil
+	if grace > 0 && prev != nil && prev != ts {
+		a.rotation = &rotation{current: ts, previous: prev, until: clk.Now().Add(grace)}
+		prev = nil
+	}
+	r := a.rotation
+	a.mu.Unlock()
+	if dropped != nil {
+		closeTokenSource(dropped.previous)
+	}
+	closeTokenSource(prev)
+	if r == nil {
+		return
+	}
+	timer := clk.NewTimer(grace)
+	a.goBackground(context.Background(), func(ctx context.Context) {
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+		}
+		a.endRotation(r)
+	})
+}
+
+// endRotation ends the rotation r if it's still the one of the Api, closing its previous source.
+func (a *Api) endRotation(r *rotation) {
+	a.mu.Lock()
+	current := a.rotation == r
+	if current {
+		a.rotation = nil
+	}
+	a.mu.Unlock()
+	if current {
+		closeTokenSource(r.previous)
+	}
+}
+
+// CredentialStats returns the counters of the calls served during the grace periods of
+// RotateCredentials since the Api was created.
+func (a *Api) CredentialStats() CredentialStats {
+	s := a.creds.get(newCredentialStats)
+	return CredentialStats{Current: s.current.Load(), Previous: s.previous.Load(), Retries: s.retries.Load()}
+}
+
+func newCredentialStats() *credentialStats { return &credentialStats{} }
+
+func closeTokenSource(ts TokenSource) {
+	if c, ok := ts.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// rotationFor returns the rotation in its grace period of the calls with req's token, nil if
+// there's none.
+func (a *Api) rotationFor(req *http.Request) *rotation {
+	clk := a.clock()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := a.rotation
+	if r == nil || !clk.Now().Before(r.until) || a.tokenSourceFor(req) != r.current {
+		return nil
+	}
+	return r
+}
+
+// retryCredential sends req like do, then again once with the other credential of the rotation
+// in its grace period when the response is a 401, and counts the credential the call was served
+// with.
+func (a *Api) retryCredential(ctx context.Context, c *call, req *http.Request) (*http.Response, error) {
+	resp, err := a.do(ctx, c, req)
+	if err != nil {
+		return resp, err
+	}
+	r := a.rotationFor(req)
+	if r == nil {
+		return resp, nil
+	}
+	stats := a.creds.get(newCredentialStats)
+	used := req.Header.Get("Authorization")
+	prev, perr := tokenHeader(ctx, r.previous)
+	if resp.StatusCode == http.StatusUnauthorized {
+		alt := prev
+		if used == prev || perr != nil {
+			alt, perr = tokenHeader(ctx, r.current)
+		}
+		if perr == nil && alt != used && rewind(req) == nil {
+			drainClose(resp.Body)
+			stats.retries.Add(1)
+			req.Header.Set("Authorization", alt)
+			used = alt
+			if resp, err = a.do(ctx, c, req); err != nil {
+				return resp, err
+			}
+		}
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		if used == prev {
+			stats.previous.Add(1)
+		} else {
+			stats.current.Add(1)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+// closingToken is a staticToken recording whether it was closed.
+type closingToken struct {
+	staticToken
+	closed atomic.Bool
+}
+
+func (c *closingToken) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// rotationServer accepts the requests carrying the bearer token in accepted, echoing their body.
+func rotationServer(accepted *atomic.Value) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Bearer "+accepted.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if len(body) == 0 {
+			body = []byte(`{}`)
+		}
+		w.Write(body)
+	}))
+}
+
+func TestRotateCredentials(t *testing.T) {
+	var accepted atomic.Value
+	accepted.Store("old")
+	srv := rotationServer(&accepted)
+	defer srv.Close()
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a := MustNew(srv.URL)
+	defer a.Close()
+	a.SetClock(clk)
+	old := &closingToken{staticToken: staticToken{token: "old"}}
+	a.SetTokenSource(old)
+	ctx := context.Background()
+	before, err := a.RequestJSON(POST, "/items", map[string]int{"n": 0})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	a.RotateCredentials(&staticToken{token: "new"}, time.Minute)
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 10 {
+				// The server switches to the new key while the calls are in flight.
+				accepted.Store("new")
+			}
+			var out map[string]int
+			if a.Post(ctx, "/items", map[string]int{"n": i}, &out) != nil || out["n"] != i {
+				failed.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Zero(t, failed.Load())
+	// The request created with the old token is sent again with the new one.
+	resp, err := a.Do(ctx, before)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	}
+	s := a.CredentialStats()
+	assert.Equal(t, int64(21), s.Current+s.Previous)
+	assert.True(t, s.Retries >= 1)
+	assert.False(t, old.closed.Load())
+
+	// The server rolling back is served with the old key.
+	accepted.Store("old")
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil))
+	assert.Equal(t, s.Previous+1, a.CredentialStats().Previous)
+
+	// Once the grace period is over, the old source is dropped.
+	clk.Advance(time.Minute)
+	assert.Eventually(t, old.closed.Load, time.Second, time.Millisecond)
+	err = a.Get(ctx, "/items", nil, nil)
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se), "%v", err) {
+		assert.Equal(t, http.StatusUnauthorized, se.Code)
+	}
+	assert.Equal(t, s.Retries+1, a.CredentialStats().Retries)
+}
+
+func TestRotateCredentialsReplaced(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a := MustNew("http://example.com")
+	a.SetClock(clk)
+	first := &closingToken{staticToken: staticToken{token: "1"}}
+	second := &closingToken{staticToken: staticToken{token: "2"}}
+	a.SetTokenSource(first)
+
+	a.RotateCredentials(second, time.Hour)
+	assert.False(t, first.closed.Load())
+	req, _ := a.Request(GET, "/", nil)
+	assert.Equal(t, "Bearer 2", req.Header.Get("Authorization"))
+	// Rotating again drops the source kept by the first rotation.
+	a.RotateCredentials(&staticToken{token: "3"}, time.Hour)
+	assert.True(t, first.closed.Load())
+	assert.False(t, second.closed.Load())
+	a.Close()
+	assert.True(t, second.closed.Load())
+
+	// Without a grace period, the previous source is dropped at once.
+	b := MustNew("http://example.com")
+	defer b.Close()
+	third := &closingToken{staticToken: staticToken{token: "3"}}
+	b.SetTokenSource(third)
+	b.RotateCredentials(&staticToken{token: "4"}, 0)
+	assert.True(t, third.closed.Load())
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrMaintenanceWindow is matched by every *MaintenanceError.
+var ErrMaintenanceWindow = errors.New("api: maintenance window")
+
+// MaintenanceError is returned for the calls made during a WindowBlock window of the Schedule.
+type MaintenanceError struct {
+	// Window is the name of the window.
+	Window string
+	// Until is the end of the window, zero if it doesn't end within a week.
+	Until time.Time
+}
+
+func (e *MaintenanceError) Error() string {
+	if e.Until.IsZero() {
+		return "api: maintenance window " + e.Window
+	}
+	return fmt.Sprintf("api: maintenance window %s until %s", e.Window, e.Until.Format(time.RFC3339))
+}
+
+// Is makes errors.Is(err, ErrMaintenanceWindow) report true.
+func (e *MaintenanceError) Is(target error) bool { return target == ErrMaintenanceWindow }
+
+// Class makes the error a Permanent failure, so it's not retried into the window.
+func (e *MaintenanceError) Class() Class { return Permanent }
+
+// WindowPolicy is what a Window of the Schedule does to the calls made during it.
+type WindowPolicy int
+
+const (
+	// WindowBlock fails the calls with a *MaintenanceError.
+	WindowBlock WindowPolicy = iota
+	// WindowQueue makes the calls wait for the end of the window, unless their context is done first.
+	WindowQueue
+	// WindowRateLimit spaces the calls out to Window.Rate per second.
+	WindowRateLimit
+)
+
+func (p WindowPolicy) String() string {
+	switch p {
+	case WindowBlock:
+		return "block"
+	case WindowQueue:
+		return "queue"
+	case WindowRateLimit:
+		return "rate limit"
+	default:
+		return fmt.Sprintf("WindowPolicy(%d)", int(p))
+	}
+}
+
+// Window is a recurring time range of the Schedule, e.g. the maintenance of Sundays from 02:00
+// to 04:00:
+//
+//	api.Window{Name: "maintenance", Days: []time.Weekday{time.Sunday}, Start: 2 * time.Hour, End: 4 * time.Hour}
+type Window struct {
+	Name string
+	// Days are the weekdays the window starts on, every day if empty.
+	Days []time.Weekday
+	// Start and End are the times of day of the window, as offsets from midnight in the location
+	// of the Schedule. An End before the Start ends the window the next day.
+	Start, End time.Duration
+	Policy     WindowPolicy
+	// Rate is the number of calls per second allowed by WindowRateLimit, and Burst the number
+	// of calls that can be made at once, 1 if zero.
+	Rate  float64
+	Burst int
+}
+
+// Schedule configures SetSchedule.
+type Schedule struct {
+	// Location is the zone of the windows, UTC if nil.
+	Location *time.Location
+	// Windows are the windows of the schedule. When they overlap, the first one applies.
+	Windows []Window
+}
+
+// ScheduleStatus is the state of the Schedule at a time, see ScheduleStatus.
+type ScheduleStatus struct {
+	// Active is the window in effect, nil if none is.
+	Active *Window
+	// Next is the time of the next transition: the end of the active window, or the start of
+	// the next one. It's zero if there's no transition within the next week.
+	Next time.Time
+}
+
+// scheduler is the state of the Schedule set by SetSchedule.
+type scheduler struct {
+	loc      *time.Location
+	windows  []Window
+	mu       sync.Mutex
+	limiters []limiter
+}
+
+// limiter is the token bucket of a WindowRateLimit window.
+type limiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// SetSchedule makes Do and the Do-style helpers apply the windows of s to their calls, e.g. to stay
+// off a vendor's API during its maintenance windows, or go easy on it during its business hours.
+// The windows are evaluated before every attempt with the clock of the Api, so a retry falling
+// into a WindowBlock window fails with a *MaintenanceError. A nil s removes the schedule.
+func (a *Api) SetSchedule(s *Schedule) error {
+	if s == nil {
+		a.schedule.Store(nil)
+		return nil
+	}
+	sc := &scheduler{loc: s.Location, windows: append([]Window(nil), s.Windows...), limiters: make([]limiter, len(s.Windows))}
+	if sc.loc == nil {
+		sc.loc = time.UTC
+	}
+	for i := range sc.windows {
+		w := &sc.windows[i]
+		w.Days = append([]time.Weekday(nil), w.Days...)
+		switch {
+		case w.Start < 0 || w.Start >= 24*time.Hour || w.End < 0 || w.End > 24*time.Hour:
+			return fmt.Errorf("api: window %s: times of day must be within 0 and 24h", w.Name)
+		case w.Start == w.End:
+			return fmt.Errorf("api: window %s: empty", w.Name)
+		case w.Policy < WindowBlock || w.Policy > WindowRateLimit:
+			return fmt.Errorf("api: window %s: unknown %v", w.Name, w.Policy)
+		case w.Policy == WindowRateLimit && w.Rate <= 0:
+			return fmt.Errorf("api: window %s: rate must be positive, got %v", w.Name, w.Rate)
+		}
+		if w.Burst <= 0 {
+			w.Burst = 1
+		}
+		sc.limiters[i].tokens = float64(w.Burst)
+	}
+	a.schedule.Store(sc)
+	return nil
+}
+
+// ScheduleStatus returns the state of the Schedule of the Api now, by its clock, e.g. for dashboards.
+func (a *Api) ScheduleStatus() ScheduleStatus {
+	sc := a.schedule.Load()
+	if sc == nil {
+		return ScheduleStatus{}
+	}
+	i, next := sc.at(a.clock().Now())
+	st := ScheduleStatus{Next: next}
+	if i >= 0 {
+		w := sc.windows[i]
+		w.Days = append([]time.Weekday(nil), w.Days...)
+		st.Active = &w
+	}
+	return st
+}
+
+// wait applies the window in effect to a call: it fails it, or waits for the end of the window
+// or for the rate limit to allow it.
+func (sc *scheduler) wait(ctx context.Context, clk Clock) error {
+	for {
+		now := clk.Now()
+		i, next := sc.at(now)
+		if i < 0 {
+			return nil
+		}
+		w := &sc.windows[i]
+		switch w.Policy {
+		case WindowBlock:
+			return &MaintenanceError{Window: w.Name, Until: next}
+		case WindowQueue:
+			if next.IsZero() {
+				// The window never ends.
+				return &MaintenanceError{Window: w.Name}
+			}
+			if err := clk.Sleep(ctx, next.Sub(now)); err != nil {
+				return withCause(ctx, err)
+			}
+			// The next window, if any, applies from there.
+		case WindowRateLimit:
+			d := sc.reserve(i, now)
+			if d <= 0 {
+				return nil
+			}
+			if err := clk.Sleep(ctx, d); err != nil {
+				sc.mu.Lock()
+				sc.limiters[i].tokens++
+				sc.mu.Unlock()
+				return withCause(ctx, err)
+			}
+			return nil
+		}
+	}
+}
+
+// reserve takes a token of the bucket of window i, returning the time to wait for it.
+func (sc *scheduler) reserve(i int, now time.Time) time.Duration {
+	w := &sc.windows[i]
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	l := &sc.limiters[i]
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * w.Rate
+	}
+	if l.tokens > float64(w.Burst) {
+		l.tokens = float64(w.Burst)
+	}
+	if now.After(l.last) {
+		l.last = now
+	}
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / w.Rate * float64(time.Second))
+}
+
+// span is an occurrence of a window.
+type span struct {
+	window     int
+	start, end time.Time
+}
+
+// spans returns the occurrences of the windows starting from the day before t to a week after it.
+func (sc *scheduler) spans(t time.Time) []span {
+	t = t.In(sc.loc)
+	var spans []span
+	for d := -1; d <= 7; d++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+d, 0, 0, 0, 0, sc.loc)
+		for i, w := range sc.windows {
+			if !w.on(day.Weekday()) {
+				continue
+			}
+			end := w.End
+			if end <= w.Start {
+				end += 24 * time.Hour
+			}
+			spans = append(spans, span{window: i, start: day.Add(w.Start), end: day.Add(end)})
+		}
+	}
+	return spans
+}
+
+func (w *Window) on(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// at returns the index of the window in effect at t, -1 if none is, and the time of the next
+// transition, zero if there's none within a week.
+func (sc *scheduler) at(t time.Time) (int, time.Time) {
+	spans := sc.spans(t)
+	active := activeAt(spans, t)
+	var bounds []time.Time
+	for _, s := range spans {
+		for _, b := range []time.Time{s.start, s.end} {
+			if b.After(t) {
+				bounds = append(bounds, b)
+			}
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Before(bounds[j]) })
+	for _, b := range bounds {
+		if activeAt(spans, b) != active {
+			return active, b
+		}
+	}
+	return active, time.Time{}
+}
+
+// activeAt returns the first window of the spans containing t, -1 if none does.
+func activeAt(spans []span, t time.Time) int {
+	active := -1
+	for _, s := range spans {
+		if !t.Before(s.start) && t.Before(s.end) && (active < 0 || s.window < active) {
+			active = s.window
+		}
+	}
+	return active
+}
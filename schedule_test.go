@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestScheduleBlock(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	// Sunday, a second before the maintenance.
+	clk := clock.NewFake(time.Date(2020, 1, 5, 1, 59, 59, 0, time.UTC))
+	clk.AutoAdvance(true)
+	a.SetClock(clk)
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	maintenance := Window{Name: "maintenance", Days: []time.Weekday{time.Sunday}, Start: 2 * time.Hour, End: 4 * time.Hour}
+	assert.NoError(t, a.SetSchedule(&Schedule{Windows: []Window{maintenance}}))
+	assert.Equal(t, ScheduleStatus{Next: time.Date(2020, 1, 5, 2, 0, 0, 0, time.UTC)}, a.ScheduleStatus())
+
+	// The retry of the call falls into the window.
+	err := a.Get(context.Background(), "/", nil, nil)
+	var me *MaintenanceError
+	if assert.True(t, errors.As(err, &me), "%v", err) {
+		assert.Equal(t, "maintenance", me.Window)
+		assert.Equal(t, time.Date(2020, 1, 5, 4, 0, 0, 0, time.UTC), me.Until)
+	}
+	assert.True(t, errors.Is(err, ErrMaintenanceWindow))
+	assert.Equal(t, Blocked, KindOf(err))
+	assert.Equal(t, int32(1), calls.Load())
+	st := a.ScheduleStatus()
+	if assert.NotNil(t, st.Active) {
+		assert.Equal(t, "maintenance", st.Active.Name)
+	}
+	assert.Equal(t, time.Date(2020, 1, 5, 4, 0, 0, 0, time.UTC), st.Next)
+
+	// Past the window, the next one is a week later.
+	clk.Advance(2 * time.Hour)
+	assert.NoError(t, a.Get(context.Background(), "/", nil, nil))
+	assert.Equal(t, ScheduleStatus{Next: time.Date(2020, 1, 12, 2, 0, 0, 0, time.UTC)}, a.ScheduleStatus())
+}
+
+func TestScheduleQueue(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	paris, err := time.LoadLocation("Europe/Paris")
+	if !assert.NoError(t, err) {
+		return
+	}
+	// 23:58 in Paris, within the window queueing the calls until 00:05.
+	clk := clock.NewFake(time.Date(2020, 1, 6, 23, 58, 0, 0, paris))
+	a.SetClock(clk)
+	assert.NoError(t, a.SetSchedule(&Schedule{Location: paris, Windows: []Window{
+		{Name: "batch", Start: 23*time.Hour + 30*time.Minute, End: 5 * time.Minute, Policy: WindowQueue},
+	}}))
+	ctx := context.Background()
+
+	done := make(chan error)
+	go func() { done <- a.Get(ctx, "/", nil, nil) }()
+	clk.BlockUntil(1)
+	assert.Equal(t, int32(0), calls.Load())
+	clk.Advance(6*time.Minute + 59*time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("done before the end of the window: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clk.Advance(time.Second)
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// The wait is bounded by the context.
+	clk.Advance(23*time.Hour + 30*time.Minute)
+	ctx, cancel := context.WithCancel(ctx)
+	go func() { done <- a.Get(ctx, "/", nil, nil) }()
+	clk.BlockUntil(1)
+	cancel()
+	assert.True(t, errors.Is(<-done, context.Canceled))
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestScheduleRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	start := time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	clk.AutoAdvance(true)
+	a.SetClock(clk)
+	assert.NoError(t, a.SetSchedule(&Schedule{Windows: []Window{
+		{Name: "business hours", Days: []time.Weekday{time.Monday}, Start: 9 * time.Hour, End: 17 * time.Hour, Policy: WindowRateLimit, Rate: 2, Burst: 2},
+	}}))
+
+	// The burst goes through, then the calls are spaced out by 500ms.
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, a.Get(context.Background(), "/", nil, nil))
+	}
+	assert.Equal(t, start.Add(2*time.Second), clk.Now())
+
+	// Out of the window, they aren't.
+	clk.Advance(8 * time.Hour)
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, a.Get(context.Background(), "/", nil, nil))
+	}
+	assert.Equal(t, start.Add(8*time.Hour+2*time.Second), clk.Now())
+
+	for _, w := range []Window{
+		{Name: "empty", Start: time.Hour, End: time.Hour},
+		{Name: "late", Start: 25 * time.Hour, End: time.Hour},
+		{Name: "no rate", Start: time.Hour, End: 2 * time.Hour, Policy: WindowRateLimit},
+	} {
+		assert.Error(t, a.SetSchedule(&Schedule{Windows: []Window{w}}), w.Name)
+	}
+}
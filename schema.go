@@ -0,0 +1,332 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrSchemaDrift is matched by every *SchemaDriftError.
+var ErrSchemaDrift = errors.New("api: response schema drift")
+
+// Schema is the structure of a JSON value: the JSON type of every path of the value, "$" being the
+// value itself, "$.name" a field of an object and "$.items[]" the elements of an array, e.g.
+//
+//	api.Schema{"$": "object", "$.id": "number", "$.tags": "array", "$.tags[]": "string"}
+//
+// The types are "object", "array", "string", "number", "boolean" and "null". A path holding values
+// of several types, like the elements of a mixed array, has them sorted and joined by "|".
+type Schema map[string]string
+
+// SchemaOf returns the schema of the JSON value data.
+func SchemaOf(data []byte) (Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	s := make(Schema)
+	s.add("$", v)
+	return s, nil
+}
+
+func (s Schema) add(path string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		s.merge(path, "object")
+		for k, e := range v {
+			s.add(path+"."+k, e)
+		}
+	case []interface{}:
+		s.merge(path, "array")
+		for _, e := range v {
+			s.add(path+"[]", e)
+		}
+	case string:
+		s.merge(path, "string")
+	case json.Number:
+		s.merge(path, "number")
+	case bool:
+		s.merge(path, "boolean")
+	default:
+		s.merge(path, "null")
+	}
+}
+
+// merge adds the types typ to those of path. A null only stands until another type is seen.
+func (s Schema) merge(path, typ string) {
+	old, ok := s[path]
+	switch {
+	case !ok || old == "null":
+		s[path] = typ
+	case typ == "null" || old == typ:
+	default:
+		types := strings.Split(old, "|")
+		for _, t := range strings.Split(typ, "|") {
+			if !containsString(types, t) {
+				types = append(types, t)
+			}
+		}
+		sort.Strings(types)
+		s[path] = strings.Join(types, "|")
+	}
+}
+
+// SchemaChange is a difference between the schema of a response and its golden schema.
+type SchemaChange struct {
+	// Kind is "added", "removed" or "changed".
+	Kind string
+	Path string
+	// Golden and Got are the types of the path in the golden schema and in the response,
+	// empty for a path they don't have.
+	Golden, Got string
+}
+
+func (c SchemaChange) String() string {
+	switch c.Kind {
+	case "changed":
+		return fmt.Sprintf("%s %s from %s to %s", c.Kind, c.Path, c.Golden, c.Got)
+	default:
+		return c.Kind + " " + c.Path
+	}
+}
+
+// SchemaDriftError is reported, or returned in strict mode, for a response whose schema differs
+// from its golden schema, see LoadGoldenSchemas. It's matched by ErrSchemaDrift.
+type SchemaDriftError struct {
+	Method   string
+	Resource string
+	// Template is the resource template of the golden schema.
+	Template string
+	// Changes are sorted by path.
+	Changes []SchemaChange
+}
+
+func (e *SchemaDriftError) Error() string {
+	changes := make([]string, len(e.Changes))
+	for i, c := range e.Changes {
+		changes[i] = c.String()
+	}
+	return fmt.Sprintf("api: schema drift of %s %s (%s): %s", e.Method, e.Resource, e.Template, strings.Join(changes, ", "))
+}
+
+// Is makes errors.Is(err, ErrSchemaDrift) report true.
+func (e *SchemaDriftError) Is(target error) bool {
+	return target == ErrSchemaDrift
+}
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *SchemaDriftError) Class() Class { return Permanent }
+
+// breaking reports whether the drift has changes other than additions.
+func (e *SchemaDriftError) breaking() bool {
+	for _, c := range e.Changes {
+		if c.Kind != "added" {
+			return true
+		}
+	}
+	return false
+}
+
+// DriftPolicy configures the drift detection of LoadGoldenSchemas.
+type DriftPolicy struct {
+	// Report is called with the drift of every response differing from its golden schema.
+	Report func(err *SchemaDriftError)
+	// Strict makes the calls whose response has removed paths or changed types fail with the
+	// *SchemaDriftError. The added paths are only reported, since they don't break the decoding.
+	Strict bool
+	// Update makes the schemas of the responses merged into the golden ones instead of being
+	// compared with them, to write them with WriteGoldenSchemas, e.g. to record the goldens
+	// against a known good version of the API.
+	Update bool
+	// Templates are the resource templates whose responses are recorded in Update mode, in
+	// addition to those of the golden file.
+	Templates []string
+}
+
+// drifting is the state of LoadGoldenSchemas.
+type drifting struct {
+	path    string
+	policy  DriftPolicy
+	mu      sync.Mutex
+	goldens map[string]Schema
+}
+
+// LoadGoldenSchemas makes the Do-style helpers compare the schema of the JSON body of every
+// successful response with the golden schema of its resource, e.g. to catch the field types
+// changed by the upstream in staging, and pass the differences to p.Report without failing
+// the call, unless p.Strict is set. The golden file at path is a JSON object holding the Schema
+// of each resource template, e.g. "/users/{id}", matched like those of RequiredFields, or of the
+// Template the call is made from. The empty bodies and the resources without a golden schema
+// aren't checked. A null value matches any type, and the paths under an empty array or a null
+// value aren't reported as removed.
+//
+// In p.Update mode, the file may not exist yet and is written by WriteGoldenSchemas.
+// An empty path disables the detection; a nil p uses the defaults of DriftPolicy.
+func (a *Api) LoadGoldenSchemas(path string, p *DriftPolicy) error {
+	if path == "" {
+		a.mu.Lock()
+		a.drift = nil
+		a.mu.Unlock()
+		return nil
+	}
+	d := &drifting{path: path, goldens: make(map[string]Schema)}
+	if p != nil {
+		d.policy = *p
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &d.goldens); err != nil {
+			return fmt.Errorf("api: golden schemas %s: %w", path, err)
+		}
+	case !os.IsNotExist(err) || !d.policy.Update:
+		return err
+	}
+	a.mu.Lock()
+	a.drift = d
+	a.mu.Unlock()
+	return nil
+}
+
+// WriteGoldenSchemas writes the golden schemas of LoadGoldenSchemas to its file, with those
+// recorded in DriftPolicy.Update mode.
+func (a *Api) WriteGoldenSchemas() error {
+	a.mu.Lock()
+	d := a.drift
+	a.mu.Unlock()
+	if d == nil {
+		return errors.New("api: no golden schemas loaded")
+	}
+	d.mu.Lock()
+	data, err := json.MarshalIndent(d.goldens, "", "  ")
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+// check compares the schema of the body of a response to the call c with its golden schema,
+// returning the drift failing the call.
+func (d *drifting) check(c *call, req *http.Request, status int, body []byte) error {
+	if status == http.StatusNoContent || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	tmpl, golden := d.golden(c)
+	if tmpl == "" {
+		return nil
+	}
+	got, err := SchemaOf(body)
+	if err != nil {
+		// The bodies that aren't JSON are left to the decoding.
+		return nil
+	}
+	if d.policy.Update {
+		if golden == nil {
+			golden = make(Schema)
+			d.goldens[tmpl] = golden
+		}
+		for path, typ := range got {
+			golden.merge(path, typ)
+		}
+		return nil
+	}
+	changes := compareSchemas(golden, got)
+	if len(changes) == 0 {
+		return nil
+	}
+	derr := &SchemaDriftError{Method: req.Method, Resource: c.resource, Template: tmpl, Changes: changes}
+	if d.policy.Report != nil {
+		d.policy.Report(derr)
+	}
+	if d.policy.Strict && derr.breaking() {
+		return derr
+	}
+	return nil
+}
+
+// golden returns the template of the call and its golden schema, nil if it has none yet, or an
+// empty template if the call isn't checked.
+func (d *drifting) golden(c *call) (string, Schema) {
+	if s, ok := d.goldens[c.template]; ok && c.template != "" {
+		return c.template, s
+	}
+	templates := make([]string, 0, len(d.goldens))
+	for tmpl := range d.goldens {
+		templates = append(templates, tmpl)
+	}
+	if d.policy.Update {
+		templates = append(templates, d.policy.Templates...)
+		if c.template != "" && containsString(d.policy.Templates, c.template) {
+			return c.template, nil
+		}
+	}
+	sort.Strings(templates)
+	for _, tmpl := range templates {
+		if matchTemplate(tmpl, c.resource) {
+			return tmpl, d.goldens[tmpl]
+		}
+	}
+	return "", nil
+}
+
+// compareSchemas returns the changes from golden to got, sorted by path.
+func compareSchemas(golden, got Schema) []SchemaChange {
+	var changes []SchemaChange
+	for path, typ := range got {
+		g, ok := golden[path]
+		switch {
+		case !ok:
+			changes = append(changes, SchemaChange{Kind: "added", Path: path, Got: typ})
+		case typ != "null" && g != "null" && !typesWithin(typ, g):
+			changes = append(changes, SchemaChange{Kind: "changed", Path: path, Golden: g, Got: typ})
+		}
+	}
+	for path, g := range golden {
+		if _, ok := got[path]; ok || !containerSeen(got, path) {
+			continue
+		}
+		changes = append(changes, SchemaChange{Kind: "removed", Path: path, Golden: g})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// typesWithin reports whether the types of typ are all within those of golden.
+func typesWithin(typ, golden string) bool {
+	types := strings.Split(golden, "|")
+	for _, t := range strings.Split(typ, "|") {
+		if !containsString(types, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// containerSeen reports whether s has the object holding the field path, or the elements of its
+// array, so that its absence is a removal.
+func containerSeen(s Schema, path string) bool {
+	if strings.HasSuffix(path, "[]") {
+		// An empty array has no elements.
+		return false
+	}
+	i := strings.LastIndexByte(path, '.')
+	if i < 0 {
+		return false
+	}
+	return strings.Contains(s[path[:i]], "object")
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	goldenUser = `{"id": 1, "name": "bob", "owner": {"email": "bob@example.com"}, "tags": ["a"], "roles": [{"name": "admin"}], "manager": null}`
+	// driftedUser has an added field and a changed type, but its empty arrays and null values
+	// don't remove anything.
+	driftedUser = `{"id": "1", "name": null, "nick": "b", "owner": null, "tags": [], "roles": [], "manager": {"id": 2}}`
+)
+
+func TestSchemaOf(t *testing.T) {
+	s, err := SchemaOf([]byte(`{"id": 1, "tags": ["a", 2, null], "items": [{"ok": true}, {"ok": null, "n": 1}], "none": null}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, Schema{
+		"$":            "object",
+		"$.id":         "number",
+		"$.tags":       "array",
+		"$.tags[]":     "number|string",
+		"$.items":      "array",
+		"$.items[]":    "object",
+		"$.items[].ok": "boolean",
+		"$.items[].n":  "number",
+		"$.none":       "null",
+	}, s)
+
+	_, err = SchemaOf([]byte(`{"id":`))
+	assert.Error(t, err)
+}
+
+func TestGoldenSchemas(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/1":
+			w.Write([]byte(goldenUser))
+		case "/users/2":
+			w.Write([]byte(`{"id": 2, "name": "al", "nick": "al", "owner": {"email": "al@example.com"}, "tags": [], "roles": [], "manager": null}`))
+		case "/users/3":
+			w.Write([]byte(driftedUser))
+		default:
+			w.Write([]byte(`{"anything": true}`))
+		}
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	// The goldens are recorded from the live responses.
+	if !assert.NoError(t, a.LoadGoldenSchemas(path, &DriftPolicy{Update: true, Templates: []string{"/users/{id}"}})) {
+		return
+	}
+	assert.NoError(t, a.DoJSON(ctx, GET, "/users/1", nil, nil))
+	assert.NoError(t, a.DoJSON(ctx, GET, "/other", nil, nil))
+	assert.NoError(t, a.WriteGoldenSchemas())
+	data, err := os.ReadFile(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var goldens map[string]Schema
+	assert.NoError(t, json.Unmarshal(data, &goldens))
+	golden, _ := SchemaOf([]byte(goldenUser))
+	assert.Equal(t, map[string]Schema{"/users/{id}": golden}, goldens)
+
+	var reports []*SchemaDriftError
+	report := func(err *SchemaDriftError) { reports = append(reports, err) }
+	if !assert.NoError(t, a.LoadGoldenSchemas(path, &DriftPolicy{Report: report})) {
+		return
+	}
+
+	// An added field is reported without failing the call.
+	var out struct{ Nick string }
+	assert.NoError(t, a.DoJSON(ctx, GET, "/users/2", nil, &out))
+	assert.Equal(t, "al", out.Nick)
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, "/users/{id}", reports[0].Template)
+		assert.Equal(t, []SchemaChange{{Kind: "added", Path: "$.nick", Got: "string"}}, reports[0].Changes)
+	}
+	assert.NoError(t, a.DoJSON(ctx, GET, "/users/1", nil, nil))
+	assert.NoError(t, a.DoJSON(ctx, GET, "/other", nil, nil))
+	assert.Len(t, reports, 1)
+
+	// A changed type fails the call in strict mode.
+	assert.NoError(t, a.LoadGoldenSchemas(path, &DriftPolicy{Report: report, Strict: true}))
+	assert.NoError(t, a.DoJSON(ctx, GET, "/users/2", nil, nil))
+	err = a.DoJSON(ctx, GET, "/users/3", nil, nil)
+	var derr *SchemaDriftError
+	if assert.True(t, errors.As(err, &derr), "%v", err) {
+		assert.Equal(t, []SchemaChange{
+			{Kind: "changed", Path: "$.id", Golden: "number", Got: "string"},
+			{Kind: "added", Path: "$.manager.id", Got: "number"},
+			{Kind: "added", Path: "$.nick", Got: "string"},
+		}, derr.Changes)
+		assert.Equal(t, "GET", derr.Method)
+		assert.Equal(t, "/users/3", derr.Resource)
+	}
+	assert.True(t, errors.Is(err, ErrSchemaDrift))
+	assert.Len(t, reports, 3)
+
+	// A removed field fails too.
+	assert.NoError(t, a.LoadGoldenSchemas(path, &DriftPolicy{Strict: true}))
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 1, "owner": {}, "tags": ["a"], "roles": [{}], "manager": null}`))
+	})
+	err = a.Template(GET, "/users/{id}").Do(ctx, map[string]string{"id": "1"}, nil, nil)
+	if assert.True(t, errors.As(err, &derr), "%v", err) {
+		assert.Equal(t, []SchemaChange{
+			{Kind: "removed", Path: "$.name", Golden: "string"},
+			{Kind: "removed", Path: "$.owner.email", Golden: "string"},
+			{Kind: "removed", Path: "$.roles[].name", Golden: "string"},
+		}, derr.Changes)
+	}
+
+	assert.NoError(t, a.LoadGoldenSchemas("", nil))
+	assert.NoError(t, a.DoJSON(ctx, GET, "/users/3", nil, nil))
+	assert.Error(t, a.LoadGoldenSchemas(filepath.Join(t.TempDir(), "missing.json"), nil))
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// segMark delimits the segments of Seg in a resource. NUL never legitimately appears in a path,
+// and Seg escapes it in the segments.
+const segMark = "\x00"
+
+// Seg returns v formatted with fmt.Sprint as a single path segment for a resource, e.g.
+//
+//	req, err := a.Request(api.GET, "/users/"+api.Seg(email)+"/orders", nil)
+//
+// The segment is data: its "/", "?", "#", "%" and other reserved characters are escaped in the
+// URL, through its RawPath, rather than interpreted, and "." and ".." don't move up the path,
+// so the server gets back the exact value once it decodes the segment. The string is only
+// meaningful in a resource; the values of the parameters of a Template are segments already,
+// though they may be a Seg or a Matrix.Seg too.
+func Seg(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+	return segMark + escapeSegment(s) + segMark
+}
+
+// escapeSegment escapes s as a path segment, including the dot segments, the way the paths are
+// escaped but for the slashes.
+func escapeSegment(s string) string {
+	switch s {
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+	return strings.ReplaceAll((&url.URL{Path: s}).EscapedPath(), "/", "%2F")
+}
+
+// marked reports whether v is a segment of Seg or Matrix.Seg.
+func marked(v string) bool {
+	return len(v) >= 2*len(segMark) && strings.HasPrefix(v, segMark) && strings.HasSuffix(v, segMark) &&
+		!strings.Contains(v[len(segMark):len(v)-len(segMark)], segMark)
+}
+
+// needsSeg reports whether the template parameter value v needs to be a Seg to stay a segment.
+func needsSeg(v string) bool {
+	return v == "" || v == "." || v == ".." || strings.ContainsAny(v, "/\x00")
+}
+
+// unmarkSegments replaces the segments of Seg in the path of u, in their escaped form, by their
+// values, setting the RawPath that keeps them escaped. A path whose marks don't delimit valid
+// segments is left as it is.
+func unmarkSegments(u *url.URL) {
+	pieces := strings.Split(u.Path, segMark)
+	if len(pieces)%2 == 0 {
+		return
+	}
+	var p, raw strings.Builder
+	for i, piece := range pieces {
+		if i%2 == 0 {
+			p.WriteString(piece)
+			raw.WriteString((&url.URL{Path: piece}).EscapedPath())
+			continue
+		}
+		v, err := url.PathUnescape(piece)
+		if err != nil || escapeSegment(v) != piece && !validMatrix(piece) {
+			return
+		}
+		p.WriteString(v)
+		raw.WriteString(piece)
+	}
+	u.Path, u.RawPath = p.String(), raw.String()
+	if u.RawPath == (&url.URL{Path: u.Path}).EscapedPath() {
+		u.RawPath = ""
+	}
+}
+
+// ErrRawReserved is matched by every *RawReservedError.
+var ErrRawReserved = errors.New("api: raw reserved character in the resource")
+
+// RawReservedError is returned by the request constructors in the strict mode of
+// SetStrictPaths for a resource holding a raw "?" or "#", outside of the segments of Seg and
+// the parameters of a Template. It's matched by ErrRawReserved.
+type RawReservedError struct {
+	Resource string
+	// Char is "?" or "#".
+	Char string
+}
+
+func (e *RawReservedError) Error() string {
+	return fmt.Sprintf("api: raw %q in the resource %q, use api.Seg or a template for the values holding it", e.Char, strings.ReplaceAll(e.Resource, segMark, ""))
+}
+
+// Is makes errors.Is(err, ErrRawReserved) report true.
+func (e *RawReservedError) Is(target error) bool { return target == ErrRawReserved }
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *RawReservedError) Class() Class { return Permanent }
+
+// SetStrictPaths makes the request constructors reject the resources holding a raw "?" or "#"
+// with a *RawReservedError. Resources are paths, so those are escaped rather than starting a
+// query or a fragment, which is almost always a bug: the query goes in the args, and the
+// identifiers holding them in a Seg or the parameters of a Template. Templates take the mode
+// when they're created.
+func (a *Api) SetStrictPaths(strict bool) {
+	a.strictPaths.Store(strict)
+}
+
+// checkRawReserved returns the *RawReservedError of resource, if it holds a raw "?" or "#".
+func checkRawReserved(resource string) error {
+	for i, piece := range strings.Split(resource, segMark) {
+		if j := strings.IndexAny(piece, "?#"); j >= 0 && i%2 == 0 {
+			return &RawReservedError{Resource: resource, Char: piece[j : j+1]}
+		}
+	}
+	return nil
+}
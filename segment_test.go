@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// nastyIDs are identifiers with the characters the paths reserve or mangle.
+var nastyIDs = []string{
+	"plain", "a+b", "a b", "a#b", "a?b", "?", "#", "a?b#c", "100%", "%", "%2F", "a%20b", "%zz",
+	"a/b", "/", "//", "/a/", ".", "..", "../..", "./x", "...", "a\\b", `..\..`, "a;b", "a,b",
+	"a=b&c=d", "@user:1", "~user", "'\"<>", "ü", "日本", "🙂 x", "\x00", "a\tb\n", "$&+,:;=@", "",
+}
+
+// segmentServer records the escaped paths of the requests to /api/users/{id}/orders, decoding
+// the id.
+type segmentServer struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (s *segmentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.EscapedPath()
+	id, ok := strings.CutPrefix(p, "/api/users/")
+	if id, ok = strings.CutSuffix(id, "/orders"); !ok || strings.Contains(id, "/") {
+		http.Error(w, "unexpected path "+p, http.StatusNotFound)
+		return
+	}
+	v, err := url.PathUnescape(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.ids = append(s.ids, v)
+	s.mu.Unlock()
+	w.Write([]byte(`{}`))
+}
+
+func TestSegments(t *testing.T) {
+	s := &segmentServer{}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	a := MustNew(srv.URL + "/api")
+	ctx := context.Background()
+	tpl := a.Template(GET, "/users/{id}/orders")
+	for _, id := range nastyIDs {
+		s.ids = nil
+		req, err := a.Request(GET, "/users/"+Seg(id)+"/orders", nil)
+		if !assert.NoError(t, err, "%q", id) {
+			continue
+		}
+		if resp, err := a.Do(ctx, req); assert.NoError(t, err, "%q", id) {
+			resp.Body.Close()
+		}
+		assert.NoError(t, a.Get(ctx, "/users/"+Seg(id)+"/orders", nil, nil), "%q", id)
+		assert.NoError(t, tpl.Do(ctx, map[string]string{"id": id}, nil, nil), "%q", id)
+		built, err := tpl.Build(map[string]string{"id": id}, url.Values{"q": {"1"}})
+		if assert.NoError(t, err, "%q", id) {
+			assert.Equal(t, req.URL.Path, built.URL.Path, "%q", id)
+			assert.Equal(t, req.URL.EscapedPath(), built.URL.EscapedPath(), "%q", id)
+		}
+		assert.Equal(t, []string{id, id, id}, s.ids, "%q", id)
+	}
+
+	// The segments don't climb, whatever the path policy.
+	a.SetPathPolicy(RejectTraversal)
+	req, err := a.Request(GET, "/users/"+Seg("../../..")+"/orders", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/api/users/..%2F..%2F../orders", req.URL.EscapedPath())
+	}
+	// Values other than strings are formatted.
+	req, _ = a.Request(GET, "/users/"+Seg(42)+"/orders", nil)
+	assert.Equal(t, srv.URL+"/api/users/42/orders", req.URL.String())
+	// A raw NUL that doesn't delimit a segment is escaped like the other control bytes.
+	req, _ = a.Request(GET, "/users/a\x00b", nil)
+	assert.Equal(t, "/api/users/a%00b", req.URL.EscapedPath())
+}
+
+func TestStrictPaths(t *testing.T) {
+	a := MustNew("http://api.example.com")
+	req, err := a.Request(GET, "/users/a?b", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/users/a%3Fb", req.URL.EscapedPath())
+	}
+
+	a.SetStrictPaths(true)
+	for resource, char := range map[string]string{"/users/a?b": "?", "/users?page=2": "?", "/docs#intro": "#", Seg("a?") + "/b#": "#"} {
+		_, err := a.Request(GET, resource, nil)
+		var re *RawReservedError
+		if assert.True(t, errors.As(err, &re), resource) {
+			assert.Equal(t, char, re.Char, resource)
+		}
+		assert.ErrorIs(t, err, ErrRawReserved)
+		assert.Equal(t, Permanent, Classify(err))
+		_, err = a.Template(GET, resource).Build(nil, nil)
+		assert.ErrorIs(t, err, ErrRawReserved, resource)
+	}
+	_, err = a.Request(GET, "/docs#intro", nil)
+	assert.EqualError(t, err, `api: raw "#" in the resource "/docs#intro", use api.Seg or a template for the values holding it`)
+
+	// The segments and the template parameters are data.
+	req, err = a.Request(GET, "/users/"+Seg("a?b#c"), nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/users/a%3Fb%23c", req.URL.EscapedPath())
+	}
+	req, err = a.Template(GET, "/users/{id}").Build(map[string]string{"id": "a?b#c"}, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/users/a%3Fb%23c", req.URL.EscapedPath())
+	}
+	_, err = a.Template(GET, "/users/{id}/x?y").Build(map[string]string{"id": "a/b"}, nil)
+	assert.ErrorIs(t, err, ErrRawReserved)
+
+	// Templates take the mode when they're created.
+	tpl := a.Template(GET, "/users/a?b")
+	a.SetStrictPaths(false)
+	_, err = tpl.Build(nil, nil)
+	assert.ErrorIs(t, err, ErrRawReserved)
+	_, err = a.Template(GET, "/users/a?b").Build(nil, nil)
+	assert.NoError(t, err)
+}
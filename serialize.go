@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrFenceFull is matched by every *FenceError.
+var ErrFenceFull = errors.New("api: serialization queue full")
+
+// FenceError is returned for the calls of WithSerializeKey failing fast since the queue of their
+// key is full, see SetSerializeLimit. It's matched by ErrFenceFull and never retried, since
+// retrying would add to the queue.
+type FenceError struct {
+	Key string
+	// Waiting is the number of calls waiting for the key.
+	Waiting int
+}
+
+func (e *FenceError) Error() string {
+	return fmt.Sprintf("api: %d calls already waiting for serialization key %q", e.Waiting, e.Key)
+}
+
+// Is makes errors.Is(err, ErrFenceFull) report true.
+func (e *FenceError) Is(target error) bool {
+	return target == ErrFenceFull
+}
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *FenceError) Class() Class { return Permanent }
+
+// WithSerializeKey makes the call wait until no other call of the Api with the same key is in
+// flight, from sending its request until its response body is closed, e.g. with the URL or the
+// id of a resource whose concurrent updates conflict. The waiting calls proceed one by one in
+// the order they arrived, unless their context is done first; the calls with other keys, or
+// without one, aren't held back. Every attempt of a call waits on its own, behind the calls
+// that arrived meanwhile. An empty key doesn't serialize the call.
+func WithSerializeKey(key string) Option {
+	return func(c *call) {
+		c.serializeKey = key
+	}
+}
+
+// SetSerializeLimit makes the calls of WithSerializeKey fail fast with a *FenceError once n calls
+// are waiting for their key. Zero, the default, lets any number of calls wait.
+func (a *Api) SetSerializeLimit(n int) {
+	a.fences().limit.Store(int64(n))
+}
+
+// SerializeQueues returns the number of calls waiting for each key of WithSerializeKey having
+// a call in flight, e.g. for monitoring.
+func (a *Api) SerializeQueues() map[string]int {
+	fs := a.fences()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	queues := make(map[string]int, len(fs.keys))
+	for key, f := range fs.keys {
+		queues[key] = len(f.waiting)
+	}
+	return queues
+}
+
+// fenceSet is the state of the calls of WithSerializeKey.
+type fenceSet struct {
+	limit atomic.Int64
+	mu    sync.Mutex
+	keys  map[string]*fence
+}
+
+// fence is the state of a key with a call in flight. Its waiting calls are woken in order by
+// closing their channel, handing them the key.
+type fence struct {
+	waiting []chan struct{}
+}
+
+// fences returns the fenceSet of the Api, creating it on first use.
+func (a *Api) fences() *fenceSet {
+	return a.serial.get(func() *fenceSet { return &fenceSet{} })
+}
+
+// enter waits for the key to be free, returning the function to call once the call is over.
+func (fs *fenceSet) enter(ctx context.Context, key string) (func(), error) {
+	fs.mu.Lock()
+	f := fs.keys[key]
+	if f == nil {
+		if fs.keys == nil {
+			fs.keys = make(map[string]*fence)
+		}
+		fs.keys[key] = &fence{}
+		fs.mu.Unlock()
+		return fs.leaver(key), nil
+	}
+	if limit := fs.limit.Load(); limit > 0 && int64(len(f.waiting)) >= limit {
+		n := len(f.waiting)
+		fs.mu.Unlock()
+		return nil, &FenceError{Key: key, Waiting: n}
+	}
+	wake := make(chan struct{})
+	f.waiting = append(f.waiting, wake)
+	fs.mu.Unlock()
+	select {
+	case <-wake:
+		return fs.leaver(key), nil
+	case <-ctx.Done():
+	}
+	fs.mu.Lock()
+	for i, w := range f.waiting {
+		if w == wake {
+			f.waiting = append(f.waiting[:i], f.waiting[i+1:]...)
+			fs.mu.Unlock()
+			return nil, withCause(ctx, ctx.Err())
+		}
+	}
+	fs.mu.Unlock()
+	// The key was handed over meanwhile, so it's passed on.
+	fs.leave(key)
+	return nil, withCause(ctx, ctx.Err())
+}
+
+func (fs *fenceSet) leaver(key string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { fs.leave(key) })
+	}
+}
+
+// leave hands the key over to the first waiting call, or frees it.
+func (fs *fenceSet) leave(key string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f := fs.keys[key]
+	if len(f.waiting) == 0 {
+		delete(fs.keys, key)
+		return
+	}
+	close(f.waiting[0])
+	f.waiting = f.waiting[1:]
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitFor polls cond for up to a second.
+func waitFor(t *testing.T, cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return assert.Fail(t, "condition not met")
+}
+
+func TestSerializeKey(t *testing.T) {
+	var inFlight, overlap atomic.Int32
+	var mu sync.Mutex
+	var order []string
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if inFlight.Add(1) > 1 {
+			overlap.Add(1)
+		}
+		defer inFlight.Add(-1)
+		if r.URL.Query().Get("n") == "0" {
+			<-release
+		}
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		order = append(order, r.URL.Query().Get("n"))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := a.Patch(ctx, "/items/7", map[string]int{"n": i}, nil, WithQuery("n", strconv.Itoa(i)), WithSerializeKey("items/7"))
+			assert.NoError(t, err)
+		}(i)
+		// The calls are started one by one, so they're waiting in order.
+		if i == 0 {
+			waitFor(t, func() bool { return inFlight.Load() == 1 })
+		} else {
+			waitFor(t, func() bool { return a.SerializeQueues()["items/7"] == i })
+		}
+	}
+	close(release)
+	wg.Wait()
+	assert.Zero(t, overlap.Load())
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, order)
+	assert.Empty(t, a.SerializeQueues())
+}
+
+func TestSerializeKeyParallel(t *testing.T) {
+	var arrived sync.WaitGroup
+	arrived.Add(5)
+	var inFlight, max atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := max.Load(); n > m && !max.CompareAndSwap(m, n); m = max.Load() {
+		}
+		// Every call waits for the others, which only works if they're in flight together.
+		arrived.Done()
+		arrived.Wait()
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			assert.NoError(t, a.Patch(ctx, "/items/"+strconv.Itoa(i), nil, nil, WithSerializeKey("items/"+strconv.Itoa(i))))
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(5), max.Load())
+}
+
+func TestSerializeLimit(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetSerializeLimit(1)
+	ctx := context.Background()
+
+	done := make(chan error, 2)
+	go func() { done <- a.Patch(ctx, "/items/7", nil, nil, WithSerializeKey("k")) }()
+	waitFor(t, func() bool { return calls.Load() == 1 })
+	go func() { done <- a.Patch(ctx, "/items/7", nil, nil, WithSerializeKey("k")) }()
+	waitFor(t, func() bool { return a.SerializeQueues()["k"] == 1 })
+
+	err := a.Patch(ctx, "/items/7", nil, nil, WithSerializeKey("k"))
+	var fe *FenceError
+	if assert.True(t, errors.As(err, &fe), "%v", err) {
+		assert.Equal(t, 1, fe.Waiting)
+	}
+	assert.True(t, errors.Is(err, ErrFenceFull))
+	assert.False(t, IsRetryable(err))
+
+	// A canceled call leaves the queue.
+	a.SetSerializeLimit(0)
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = a.Patch(cctx, "/items/7", nil, nil, WithSerializeKey("k"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.Equal(t, map[string]int{"k": 1}, a.SerializeQueues())
+
+	// Other keys proceed.
+	go func() { done <- a.Patch(ctx, "/items/8", nil, nil, WithSerializeKey("other")) }()
+	waitFor(t, func() bool { return calls.Load() == 2 })
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(3), calls.Load())
+}
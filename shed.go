@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShedded is matched by every *ShedError.
+var ErrShedded = errors.New("api: call shedded")
+
+// Priority is the priority class of a call, see WithPriority and SetShedding.
+type Priority int
+
+const (
+	// Low is the priority of background work, shedded first under load.
+	Low Priority = iota - 1
+	// Normal is the priority of the calls without WithPriority, delayed under load.
+	Normal
+	// High is the priority of the calls that always proceed.
+	High
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Low:
+		return "low"
+	case High:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// WithPriority sets the priority class of the call for the ShedPolicy of the Api.
+func WithPriority(p Priority) Option {
+	return func(c *call) {
+		c.priority = p
+	}
+}
+
+// ShedPolicy configures the load shedding of an Api, see SetShedding. The Api is overloaded while
+// one of the thresholds is crossed; a zero threshold is never crossed.
+type ShedPolicy struct {
+	// MaxInFlight is the number of calls in flight, from sending the request until the response
+	// body is closed, at which further calls are held back. High calls proceed past it.
+	MaxInFlight int
+	// MaxErrorRate is the share of the recent calls failing with a transport error or a 5xx
+	// at which the Api is overloaded, e.g. 0.5.
+	MaxErrorRate float64
+	// Window is the period of the error rate, 10s if zero.
+	Window time.Duration
+	// MinCalls is the number of calls in the window under which the error rate isn't
+	// considered, 10 if zero.
+	MinCalls int
+	// QueueSize is the number of Low calls waiting like Normal ones while the Api is overloaded;
+	// the others are shedded. Zero sheds all of them.
+	QueueSize int
+	// Adaptive makes MaxInFlight the initial limit of an adaptive one, Adaptive.Min if zero, see
+	// AdaptiveConcurrency.
+	Adaptive *AdaptiveConcurrency
+}
+
+// ShedError is returned for the calls shedded by the ShedPolicy. It's matched by ErrShedded and
+// never retried, since retrying would add to the load.
+type ShedError struct {
+	Priority Priority
+	// Reason is the crossed threshold, "in-flight" or "error rate".
+	Reason string
+}
+
+func (e *ShedError) Error() string {
+	return fmt.Sprintf("api: %s priority call shedded: %s threshold crossed", e.Priority, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrShedded) report true.
+func (e *ShedError) Is(target error) bool {
+	return target == ErrShedded
+}
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *ShedError) Class() Class { return Permanent }
+
+// SetShedding makes the Api shed load by priority class while it's overloaded according to p:
+// Low calls fail fast with a *ShedError, unless there's room in the queue of p, Normal calls wait
+// until it's not overloaded anymore or their context is done, and High calls always proceed.
+// Every attempt of a call is admitted on its own, so retries can be shedded too. The state is
+// kept in atomic counters, so admitting a call takes no lock. With the Adaptive policy of p, the
+// in-flight limit follows the latencies of the calls, see ConcurrencyStats. A nil p disables
+// shedding.
+func (a *Api) SetShedding(p *ShedPolicy) {
+	if p == nil {
+		a.shed.Store(nil)
+		return
+	}
+	s := &shedder{policy: *p, wake: make(chan struct{})}
+	if s.policy.Window <= 0 {
+		s.policy.Window = 10 * time.Second
+	}
+	if s.policy.MinCalls <= 0 {
+		s.policy.MinCalls = 10
+	}
+	s.width = int64(s.policy.Window / shedBuckets)
+	if p.Adaptive != nil {
+		s.aimd = newAIMD(*p.Adaptive, p.MaxInFlight)
+	}
+	a.shed.Store(s)
+}
+
+// shedBuckets is the number of buckets of the error rate window.
+const shedBuckets = 10
+
+// shedder is the state of a ShedPolicy.
+type shedder struct {
+	policy   ShedPolicy
+	width    int64
+	inFlight atomic.Int64
+	queued   atomic.Int64
+	buckets  [shedBuckets]shedBucket
+	// aimd is the controller of the Adaptive policy, nil without one.
+	aimd *aimd
+
+	waiting atomic.Int64
+	mu      sync.Mutex
+	wake    chan struct{}
+}
+
+// shedBucket counts the calls of a slot of the window: a period of width nanoseconds.
+type shedBucket struct {
+	slot, calls, errs atomic.Int64
+}
+
+// admit waits for the call of priority p to be allowed to proceed, returning the function to
+// call once it's over with whether it failed.
+func (s *shedder) admit(ctx context.Context, p Priority, clk Clock) (func(failed bool), error) {
+	done := func(failed bool) {
+		s.inFlight.Add(-1)
+		s.record(failed, clk.Now())
+		s.wakeAll()
+	}
+	if p >= High {
+		n := s.inFlight.Add(1)
+		if s.aimd != nil {
+			s.aimd.entered(n)
+		}
+		return done, nil
+	}
+	queued := false
+	defer func() {
+		if queued {
+			s.queued.Add(-1)
+		}
+	}()
+	for {
+		s.mu.Lock()
+		wake := s.wake
+		s.mu.Unlock()
+		reason := s.enter(clk.Now())
+		if reason == "" {
+			return done, nil
+		}
+		if p <= Low && !queued {
+			if s.queued.Add(1) > int64(s.policy.QueueSize) {
+				s.queued.Add(-1)
+				return nil, &ShedError{Priority: p, Reason: reason}
+			}
+			queued = true
+		}
+		// The error rate falls as time passes, not only when calls are over.
+		s.waiting.Add(1)
+		t := clk.NewTimer(time.Duration(s.width))
+		select {
+		case <-wake:
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+			s.waiting.Add(-1)
+			return nil, withCause(ctx, ctx.Err())
+		}
+		t.Stop()
+		s.waiting.Add(-1)
+	}
+}
+
+// wakeAll wakes the calls waiting to be admitted.
+func (s *shedder) wakeAll() {
+	if s.waiting.Load() > 0 {
+		s.mu.Lock()
+		close(s.wake)
+		s.wake = make(chan struct{})
+		s.mu.Unlock()
+	}
+}
+
+// observe reports the latency of a call to the Adaptive controller, with the status of its
+// response, 0 for a transport error.
+func (s *shedder) observe(latency time.Duration, status int, now time.Time) {
+	if s.aimd != nil && s.aimd.observe(latency, status, now, s.inFlight.Load()) {
+		s.wakeAll()
+	}
+}
+
+// enter counts the call in flight unless the Api is overloaded, returning the crossed threshold.
+func (s *shedder) enter(now time.Time) string {
+	if s.policy.MaxErrorRate > 0 && s.errorRate(now) >= s.policy.MaxErrorRate {
+		return "error rate"
+	}
+	max := int64(s.policy.MaxInFlight)
+	if s.aimd != nil {
+		max = s.aimd.limit.Load()
+	}
+	for {
+		n := s.inFlight.Load()
+		if max > 0 && n >= max {
+			return "in-flight"
+		}
+		if s.inFlight.CompareAndSwap(n, n+1) {
+			if s.aimd != nil {
+				s.aimd.entered(n + 1)
+			}
+			return ""
+		}
+	}
+}
+
+// record counts a call over in the bucket of now.
+func (s *shedder) record(failed bool, now time.Time) {
+	slot := now.UnixNano() / s.width
+	b := &s.buckets[slot%shedBuckets]
+	if old := b.slot.Load(); old != slot && b.slot.CompareAndSwap(old, slot) {
+		// The counts of a concurrent call may be lost, which the rate can afford.
+		b.calls.Store(0)
+		b.errs.Store(0)
+	}
+	b.calls.Add(1)
+	if failed {
+		b.errs.Add(1)
+	}
+}
+
+// errorRate returns the share of the calls of the window that failed, 0 under MinCalls.
+func (s *shedder) errorRate(now time.Time) float64 {
+	slot := now.UnixNano() / s.width
+	var calls, errs int64
+	for i := range s.buckets {
+		b := &s.buckets[i]
+		if d := slot - b.slot.Load(); d >= 0 && d < shedBuckets {
+			calls += b.calls.Load()
+			errs += b.errs.Load()
+		}
+	}
+	if calls < int64(s.policy.MinCalls) {
+		return 0
+	}
+	return float64(errs) / float64(calls)
+}
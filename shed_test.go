@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShedInFlight(t *testing.T) {
+	block := make(chan struct{})
+	var started sync.WaitGroup
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started.Done()
+			<-block
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetShedding(&ShedPolicy{MaxInFlight: 2, QueueSize: 1})
+	ctx := context.Background()
+
+	// Two slow calls fill the in-flight budget.
+	var slow sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		started.Add(1)
+		slow.Add(1)
+		go func() {
+			defer slow.Done()
+			assert.NoError(t, a.Get(ctx, "/slow", nil, nil, WithPriority(Low)))
+		}()
+	}
+	started.Wait()
+
+	// High proceeds, Normal and the queued Low wait, the other Low is shedded.
+	assert.NoError(t, a.Get(ctx, "/fast", nil, nil, WithPriority(High)))
+	results := make(chan string, 2)
+	go func() {
+		if a.Get(ctx, "/fast", nil, nil) == nil {
+			results <- "normal"
+		}
+	}()
+	go func() {
+		if a.Get(ctx, "/fast", nil, nil, WithPriority(Low)) == nil {
+			results <- "queued low"
+		}
+	}()
+	// Normal and the queued Low wait to be admitted.
+	assert.Eventually(t, func() bool { return a.shed.Load().waiting.Load() == 2 }, 5*time.Second, time.Millisecond)
+	err := a.Get(ctx, "/fast", nil, nil, WithPriority(Low))
+	assert.ErrorIs(t, err, ErrShedded)
+	assert.EqualError(t, err, "api: low priority call shedded: in-flight threshold crossed")
+	assert.Equal(t, Permanent, Classify(err))
+	assert.Len(t, results, 0)
+
+	// The waiting calls are served once the slow ones are over.
+	close(block)
+	slow.Wait()
+	served := []string{<-results, <-results}
+	assert.ElementsMatch(t, []string{"normal", "queued low"}, served)
+
+	// A waiting call gives up with its context.
+	block = make(chan struct{})
+	started.Add(2)
+	for i := 0; i < 2; i++ {
+		slow.Add(1)
+		go func() {
+			defer slow.Done()
+			a.Get(ctx, "/slow", nil, nil, WithPriority(High))
+		}()
+	}
+	started.Wait()
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = a.Get(short, "/fast", nil, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	close(block)
+	slow.Wait()
+}
+
+func TestShedErrorRate(t *testing.T) {
+	var served []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = append(served, r.URL.Path)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetShedding(&ShedPolicy{MaxErrorRate: 0.5, MinCalls: 4, Window: time.Minute})
+	ctx := context.Background()
+
+	// Under MinCalls, the failures don't count yet.
+	for i := 0; i < 3; i++ {
+		assert.Error(t, a.Get(ctx, "/fail", nil, nil, WithPriority(Low)))
+	}
+	assert.NoError(t, a.Get(ctx, "/ok", nil, nil, WithPriority(Low)))
+	// 3 of 4 failed: the Low calls are shedded, the High ones served.
+	assert.ErrorIs(t, a.Get(ctx, "/low", nil, nil, WithPriority(Low)), ErrShedded)
+	assert.NoError(t, a.Get(ctx, "/high", nil, nil, WithPriority(High)))
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, a.Get(short, "/normal", nil, nil), context.DeadlineExceeded)
+
+	// Successful High calls bring the rate down, under 3 of 7.
+	assert.NoError(t, a.Get(ctx, "/high", nil, nil, WithPriority(High)))
+	assert.ErrorIs(t, a.Get(ctx, "/low", nil, nil, WithPriority(Low)), ErrShedded)
+	assert.NoError(t, a.Get(ctx, "/high", nil, nil, WithPriority(High)))
+	assert.NoError(t, a.Get(ctx, "/low", nil, nil, WithPriority(Low)))
+	assert.Equal(t, []string{"/fail", "/fail", "/fail", "/ok", "/high", "/high", "/high", "/low"}, served)
+
+	a.SetShedding(nil)
+	assert.NoError(t, a.Get(ctx, "/ok", nil, nil, WithPriority(Low)))
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a body is sniffed, as http.DetectContentType considers at most 512 bytes.
+const sniffLen = 512
+
+// maxPreview limits the preview of an unexpected body in runes.
+const maxPreview = 200
+
+// ErrUnexpectedHTML is matched by an *UnexpectedContentError for an HTML body, like a captive
+// portal's login page or a proxy's error page.
+var ErrUnexpectedHTML = errors.New("api: unexpected html response")
+
+// UnexpectedContentError is returned by the JSON decoding helpers when the response body is
+// declared or sniffed as HTML, when its declared type isn't acceptable per the request's Accept header,
+// or when it doesn't even start like JSON, e.g. a plain "OK".
+type UnexpectedContentError struct {
+	// Code is the HTTP status code of the response.
+	Code int
+	// ContentType is the media type of the body, as declared or sniffed.
+	ContentType string
+	// Title is the title of an HTML page, if it has one.
+	Title string
+	// Preview holds up to 200 characters of the beginning of the body, whitespace collapsed.
+	Preview string
+}
+
+func (e *UnexpectedContentError) Error() string {
+	if e.Title != "" {
+		return fmt.Sprintf("api: unexpected %s response (status %d, title %q): %s", e.ContentType, e.Code, e.Title, e.Preview)
+	}
+	return fmt.Sprintf("api: unexpected %s response (status %d): %s", e.ContentType, e.Code, e.Preview)
+}
+
+// Is makes errors.Is(err, ErrUnexpectedHTML) report true for HTML bodies.
+func (e *UnexpectedContentError) Is(target error) bool {
+	return target == ErrUnexpectedHTML && isHTML(e.ContentType)
+}
+
+func isHTML(mediatype string) bool {
+	return mediatype == "text/html" || mediatype == "application/xhtml+xml"
+}
+
+// checkJSONContent fails if the body of resp is HTML or its declared type isn't acceptable for req.
+// Bodies with a missing or ambiguous declared type, like text/plain, are sniffed from their first
+// 512 bytes, which are put back, and only fail if they turn out to be HTML. Empty or whitespace-only
+// bodies pass, having nothing to decode.
+func checkJSONContent(req *http.Request, resp *http.Response) error {
+	mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var head []byte
+	ambiguous := false
+	switch mediatype {
+	case "", "text/plain", "application/octet-stream":
+		ambiguous = true
+		buf := make([]byte, sniffLen)
+		n, err := io.ReadFull(resp.Body, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		head = buf[:n]
+		resp.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(head), resp.Body), Closer: resp.Body}
+		if n > 0 {
+			if sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head)); isHTML(sniffed) {
+				mediatype = sniffed
+			}
+		}
+	}
+	if !isHTML(mediatype) && (ambiguous || acceptable(req.Header.Values("Accept"), mediatype)) {
+		return nil
+	}
+	if head == nil {
+		buf := make([]byte, sniffLen)
+		n, _ := io.ReadFull(resp.Body, buf)
+		head = buf[:n]
+		if len(bytes.TrimSpace(head)) == 0 && n < sniffLen {
+			resp.Body = &prefixedBody{Reader: bytes.NewReader(head), Closer: resp.Body}
+			return nil
+		}
+	}
+	e := &UnexpectedContentError{Code: resp.StatusCode, ContentType: mediatype, Preview: preview(head)}
+	if m := htmlTitle.FindSubmatch(head); m != nil && isHTML(mediatype) {
+		e.Title = htmlText(m[1])
+	}
+	return e
+}
+
+// notJSON turns the syntax error of a body that doesn't even start like JSON, e.g. a plain "OK",
+// into an *UnexpectedContentError previewing it, since the error alone hides what the body was.
+// Other errors are returned as is.
+func notJSON(resp *http.Response, dec *json.Decoder, err error) error {
+	var syntax *json.SyntaxError
+	if !errors.As(err, &syntax) {
+		return err
+	}
+	head, _ := io.ReadAll(io.LimitReader(dec.Buffered(), sniffLen))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	if len(head) == 0 || bytes.IndexByte([]byte(`{["-0123456789tfn`), head[0]) >= 0 {
+		return err
+	}
+	mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediatype == "" {
+		mediatype, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	}
+	return &UnexpectedContentError{Code: resp.StatusCode, ContentType: mediatype, Preview: preview(head)}
+}
+
+// acceptable reports whether mediatype matches any of the media ranges of the Accept values.
+// Types with a +json suffix are accepted for application/json. No Accept accepts anything.
+func acceptable(accept []string, mediatype string) bool {
+	if len(accept) == 0 {
+		return true
+	}
+	typ, sub, _ := strings.Cut(mediatype, "/")
+	for _, v := range accept {
+		for _, r := range strings.Split(v, ",") {
+			r, _, _ = strings.Cut(r, ";")
+			rtyp, rsub, _ := strings.Cut(strings.ToLower(strings.TrimSpace(r)), "/")
+			switch {
+			case rtyp == "*" || rtyp == typ && (rsub == "*" || rsub == sub):
+				return true
+			case rtyp == typ && rsub == "json" && strings.HasSuffix(sub, "+json"):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// preview collapses the whitespace of b and cuts it to maxPreview runes.
+func preview(b []byte) string {
+	s := strings.Join(strings.Fields(strings.ToValidUTF8(string(b), "�")), " ")
+	if utf8.RuneCountInString(s) <= maxPreview {
+		return s
+	}
+	n := 0
+	for i := range s {
+		if n == maxPreview {
+			return s[:i] + "..."
+		}
+		n++
+	}
+	return s
+}
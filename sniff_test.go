@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const portalPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Wi-Fi login required</title>
+</head>
+<body>
+  <h1>Welcome to Airport Wi-Fi</h1>
+  <p>Please accept the terms of use to continue.</p>
+</body>
+</html>`
+
+func TestUnexpectedHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/declared":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(portalPage))
+		case "/sniffed":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(portalPage))
+		case "/csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("id\n1\n"))
+		case "/plain-json":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(`{"id": 1}`))
+		case "/large":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte(`{"id": 1, "pad": "` + strings.Repeat("x", 4096) + `"}`))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": 1}`))
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	for _, resource := range []string{"/declared", "/sniffed"} {
+		var out struct{ ID int }
+		err := a.Get(context.Background(), resource, nil, &out)
+		assert.True(t, errors.Is(err, ErrUnexpectedHTML), resource)
+		var ue *UnexpectedContentError
+		if assert.True(t, errors.As(err, &ue), resource) {
+			assert.Equal(t, http.StatusOK, ue.Code)
+			assert.Equal(t, "text/html", ue.ContentType)
+			assert.Equal(t, "Wi-Fi login required", ue.Title)
+			assert.Equal(t, `<!DOCTYPE html> <html> <head> <title>Wi-Fi login required</title> </head> <body> <h1>Welcome to Airport Wi-Fi</h1> <p>Please accept the terms of use to continue.</p> </body> </html>`, ue.Preview)
+		}
+		assert.Equal(t, 0, out.ID)
+	}
+	err := a.DoJSONArray(context.Background(), GET, "/declared", nil, nil)
+	assert.True(t, errors.Is(err, ErrUnexpectedHTML))
+	err = a.DoNDJSON(context.Background(), GET, "/sniffed", nil, nil)
+	assert.True(t, errors.Is(err, ErrUnexpectedHTML))
+
+	// JSON bodies, including sniffed ones, decode as usual.
+	for _, resource := range []string{"/json", "/plain-json", "/large"} {
+		var out struct{ ID int }
+		assert.NoError(t, a.Get(context.Background(), resource, nil, &out), resource)
+		assert.Equal(t, 1, out.ID, resource)
+	}
+
+	// A declared type the request doesn't accept fails too.
+	var out struct{ ID int }
+	assert.NoError(t, a.Get(context.Background(), "/csv", nil, nil))
+	err = a.Get(context.Background(), "/csv", nil, &out, WithHeader("Accept", "application/json"))
+	var ue *UnexpectedContentError
+	if assert.True(t, errors.As(err, &ue)) {
+		assert.False(t, errors.Is(err, ErrUnexpectedHTML))
+		assert.EqualError(t, err, `api: unexpected text/csv response (status 200): id 1`)
+	}
+	assert.NoError(t, a.Get(context.Background(), "/json", nil, &out, WithHeader("Accept", "text/*, application/json;q=0.9")))
+}
+
+func TestAcceptable(t *testing.T) {
+	for _, tc := range []struct {
+		accept    []string
+		mediatype string
+		want      bool
+	}{
+		{nil, "text/csv", true},
+		{[]string{"application/json"}, "application/json", true},
+		{[]string{"application/json"}, "application/problem+json", true},
+		{[]string{"application/json"}, "text/json", false},
+		{[]string{"text/html, application/JSON; q=0.9"}, "application/json", true},
+		{[]string{"application/*"}, "application/xml", true},
+		{[]string{"*/*"}, "image/png", true},
+		{[]string{"application/xml", "text/plain"}, "text/plain", true},
+		{[]string{"application/xml"}, "application/json", false},
+	} {
+		assert.Equal(t, tc.want, acceptable(tc.accept, tc.mediatype), "%v %s", tc.accept, tc.mediatype)
+	}
+	assert.Equal(t, strings.Repeat("é", 200)+"...", preview([]byte(strings.Repeat("é", 300))))
+}
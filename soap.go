@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SOAPEnvelopeNS is the namespace of SOAP 1.1 envelopes.
+const SOAPEnvelopeNS = "http://schemas.xmlsoap.org/soap/envelope/"
+
+// SOAPEnvelope configures the envelope RequestSOAP wraps the bodies into.
+type SOAPEnvelope struct {
+	// Prefix is the prefix of the envelope elements, "soap" by default.
+	Prefix string
+	// Namespaces are declared on the Envelope element, by prefix, e.g. {"tns": "urn:example:prices"}.
+	Namespaces map[string]string
+	// Header, if not nil, is encoded into the Header element of the envelope.
+	Header interface{}
+}
+
+// SetSOAPEnvelope sets the envelope of the requests created by RequestSOAP.
+func (a *Api) SetSOAPEnvelope(e SOAPEnvelope) {
+	ns := make(map[string]string, len(e.Namespaces))
+	for k, v := range e.Namespaces {
+		ns[k] = v
+	}
+	e.Namespaces = ns
+	a.mu.Lock()
+	a.soap = &e
+	a.mu.Unlock()
+}
+
+func (a *Api) soapEnvelope() SOAPEnvelope {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.soap == nil {
+		return SOAPEnvelope{}
+	}
+	return *a.soap
+}
+
+// SOAPFault is the error returned by DoSOAP when the response carries a Fault element.
+type SOAPFault struct {
+	// Code is the faultcode, e.g. "soap:Server".
+	Code string
+	// String is the human readable faultstring.
+	String string
+	// Actor is the optional faultactor.
+	Actor string
+	// Detail holds the raw XML content of the detail element, if any.
+	Detail string
+	// Status is the non-2xx response the fault was sent with, nil if it came with a 2xx status.
+	Status *StatusError
+}
+
+func (f *SOAPFault) Error() string {
+	return fmt.Sprintf("api: soap fault %s: %s", f.Code, f.String)
+}
+
+func (f *SOAPFault) Unwrap() error {
+	if f.Status == nil {
+		return nil
+	}
+	return f.Status
+}
+
+// RequestSOAP creates a SOAP 1.1 POST request with body encoded as XML into the Body of
+// the envelope set by SetSOAPEnvelope, the SOAPAction header set to soapAction.
+func (a *Api) RequestSOAP(resource, soapAction string, body interface{}, opts ...Option) (req *http.Request, err error) {
+	if err := a.validateRequestBody(POST, resource, body, opts); err != nil {
+		return nil, err
+	}
+	u, err := a.resourceURL(resource)
+	if err != nil {
+		return nil, err
+	}
+	e := a.soapEnvelope()
+	req = newRequest(POST, u)
+	if err = setLazyBody(req, func(buf *bytes.Buffer) error {
+		return encodeSOAP(buf, &e, body)
+	}); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	if !strings.HasPrefix(soapAction, `"`) {
+		soapAction = strconv.Quote(soapAction)
+	}
+	req.Header.Set("SOAPAction", soapAction)
+	if err = a.shape(req, opts); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// DoSOAP creates a request just like RequestSOAP does, executes it and decodes the content of
+// the response envelope's Body into out. A Fault is returned as *SOAPFault whatever the status code,
+// and isn't retried. If out is nil, the Body is only checked for a Fault. A 204 No Content response,
+// or an empty or whitespace-only body, leaves out untouched and sets ResponseMeta.NoContent.
+func (a *Api) DoSOAP(ctx context.Context, resource, soapAction string, body, out interface{}, opts ...Option) error {
+	c := a.newCallFor(resource, opts)
+	build := []Option{buildContext(ctx)}
+	if c.anyBody {
+		build = append(build, SkipRequestValidation())
+	}
+	req, err := a.RequestSOAP(resource, soapAction, body, build...)
+	if err != nil {
+		return err
+	}
+	if p := c.retryPolicy(a); p != nil {
+		policy := *p
+		retryable := policy.Retryable
+		if retryable == nil {
+			retryable = IsRetryable
+		}
+		policy.Retryable = func(err error) bool {
+			return soapFaultOf(err) == nil && retryable(err)
+		}
+		c.retry, c.retrySet = &policy, true
+	}
+	resp, err := a.send(ctx, c, req)
+	if err != nil {
+		if f := soapFaultOf(err); f != nil {
+			return f
+		}
+		return err
+	}
+	if err := decodeSOAP(resp.Body, out); err == errNoContent {
+		c.noContent()
+	} else if err != nil {
+		drainClose(resp.Body)
+		return err
+	}
+	return drainClose(resp.Body)
+}
+
+// soapFaultOf returns the fault carried by a failed response, or nil.
+func soapFaultOf(err error) *SOAPFault {
+	var se *StatusError
+	if !errors.As(err, &se) {
+		return nil
+	}
+	var f *SOAPFault
+	if !errors.As(decodeSOAP(bytes.NewReader(se.Body), nil), &f) {
+		return nil
+	}
+	f.Status = se
+	return f
+}
+
+// encodeSOAP writes the envelope with body in its Body element to buf.
+func encodeSOAP(buf *bytes.Buffer, e *SOAPEnvelope, body interface{}) error {
+	prefix := e.Prefix
+	if prefix == "" {
+		prefix = "soap"
+	}
+	buf.WriteString(xml.Header)
+	fmt.Fprintf(buf, `<%s:Envelope xmlns:%s="%s"`, prefix, prefix, SOAPEnvelopeNS)
+	names := make([]string, 0, len(e.Namespaces))
+	for name := range e.Namespaces {
+		if name != prefix {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(buf, ` xmlns:%s="`, name)
+		xml.EscapeText(buf, []byte(e.Namespaces[name]))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+	if e.Header != nil {
+		fmt.Fprintf(buf, "<%s:Header>", prefix)
+		if err := xml.NewEncoder(buf).Encode(e.Header); err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, "</%s:Header>", prefix)
+	}
+	fmt.Fprintf(buf, "<%s:Body>", prefix)
+	if body != nil {
+		if err := xml.NewEncoder(buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(buf, "</%s:Body></%s:Envelope>", prefix, prefix)
+	return nil
+}
+
+type soapFaultXML struct {
+	Code   string `xml:"faultcode"`
+	String string `xml:"faultstring"`
+	Actor  string `xml:"faultactor"`
+	Detail struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"detail"`
+}
+
+// errNoContent is returned by decodeSOAP for a body that's empty or only whitespace.
+var errNoContent = errors.New("api: no content")
+
+// decodeSOAP decodes the first element of the envelope's Body from r into out,
+// or returns it as *SOAPFault if it's a Fault.
+func decodeSOAP(r io.Reader, out interface{}) error {
+	dec := xml.NewDecoder(r)
+	depth := 0
+	started := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF && !started {
+			return errNoContent
+		}
+		if err == io.EOF {
+			return errors.New("api: soap envelope without a body")
+		}
+		if err != nil {
+			return err
+		}
+		if cd, ok := tok.(xml.CharData); !ok || len(bytes.TrimSpace(cd)) > 0 {
+			started = true
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case depth == 0 && t.Name.Local != "Envelope", depth == 0 && t.Name.Space != SOAPEnvelopeNS:
+				return fmt.Errorf("api: not a soap envelope: <%s>", t.Name.Local)
+			case depth == 1 && t.Name.Local == "Body":
+				return decodeSOAPBody(dec, out)
+			case depth == 1:
+				// Skip the Header and anything else before the Body.
+				if err := dec.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+func decodeSOAPBody(dec *xml.Decoder, out interface{}) error {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "Fault" && t.Name.Space == SOAPEnvelopeNS {
+				var f soapFaultXML
+				if err := dec.DecodeElement(&f, &t); err != nil {
+					return err
+				}
+				return &SOAPFault{Code: f.Code, String: f.String, Actor: f.Actor, Detail: strings.TrimSpace(f.Detail.Inner)}
+			}
+			if out == nil {
+				return nil
+			}
+			return dec.DecodeElement(out, &t)
+		case xml.EndElement:
+			// An empty Body.
+			return nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type getPrice struct {
+	XMLName xml.Name `xml:"urn:example:prices GetPrice"`
+	Item    string   `xml:"Item"`
+}
+
+type getPriceResponse struct {
+	XMLName xml.Name `xml:"GetPriceResponse"`
+	Price   float64  `xml:"Price"`
+}
+
+type authHeader struct {
+	XMLName xml.Name `xml:"tns:Auth"`
+	Token   string   `xml:"Token"`
+}
+
+func TestRequestSOAP(t *testing.T) {
+	a := MustNew("http://example.com/ws")
+	a.SetSOAPEnvelope(SOAPEnvelope{
+		Prefix:     "env",
+		Namespaces: map[string]string{"tns": "urn:example:prices", "xsi": "http://www.w3.org/2001/XMLSchema-instance"},
+		Header:     authHeader{Token: "a&b"},
+	})
+	req, err := a.RequestSOAP("/prices", "urn:example:prices#GetPrice", getPrice{Item: "apple"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "text/xml; charset=utf-8", req.Header.Get("Content-Type"))
+	assert.Equal(t, `"urn:example:prices#GetPrice"`, req.Header.Get("SOAPAction"))
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<env:Envelope xmlns:env="http://schemas.xmlsoap.org/soap/envelope/" xmlns:tns="urn:example:prices" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`+
+		`<env:Header><tns:Auth><Token>a&amp;b</Token></tns:Auth></env:Header>`+
+		`<env:Body><GetPrice xmlns="urn:example:prices"><Item>apple</Item></GetPrice></env:Body></env:Envelope>`, string(body))
+	assert.EqualValues(t, len(body), req.ContentLength)
+}
+
+func TestDoSOAP(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		var env struct {
+			Body struct {
+				GetPrice getPrice
+			}
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&env); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		switch env.Body.GetPrice.Item {
+		case "apple":
+			w.Write([]byte(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+  <s:Header><Session>1</Session></s:Header>
+  <s:Body><m:GetPriceResponse xmlns:m="urn:example:prices"><m:Price>1.25</m:Price></m:GetPriceResponse></s:Body>
+</s:Envelope>`))
+		case "pear":
+			w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<s:Fault><faultcode>s:Client</faultcode><faultstring>quota exceeded</faultstring></s:Fault></s:Body></s:Envelope>`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<s:Fault>
+  <faultcode>s:Server</faultcode>
+  <faultstring>Unknown item</faultstring>
+  <faultactor>http://example.com/ws</faultactor>
+  <detail><e:Code xmlns:e="urn:example:errors">404</e:Code></detail>
+</s:Fault></s:Body></s:Envelope>`))
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	a.SetClock(fakeClock())
+
+	var out getPriceResponse
+	assert.NoError(t, a.DoSOAP(context.Background(), "/prices", "GetPrice", getPrice{Item: "apple"}, &out))
+	assert.Equal(t, 1.25, out.Price)
+
+	err := a.DoSOAP(context.Background(), "/prices", "GetPrice", getPrice{Item: "pear"}, &out)
+	var f *SOAPFault
+	if assert.True(t, errors.As(err, &f)) {
+		assert.Equal(t, "s:Client", f.Code)
+		assert.Nil(t, f.Status)
+	}
+
+	attempts = 0
+	err = a.DoSOAP(context.Background(), "/prices", "GetPrice", getPrice{Item: "plum"}, &out)
+	assert.EqualError(t, err, "api: soap fault s:Server: Unknown item")
+	if assert.True(t, errors.As(err, &f)) {
+		assert.Equal(t, "http://example.com/ws", f.Actor)
+		assert.Equal(t, `<e:Code xmlns:e="urn:example:errors">404</e:Code>`, f.Detail)
+	}
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, http.StatusInternalServerError, se.Code)
+	}
+	assert.Equal(t, 1, attempts, "faults aren't retried")
+}
+
+func TestDecodeSOAPInvalid(t *testing.T) {
+	for body, want := range map[string]string{
+		`<html></html>`: "api: not a soap envelope: <html>",
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Header/></s:Envelope>`: "api: soap envelope without a body",
+	} {
+		err := decodeSOAP(strings.NewReader(body), nil)
+		assert.EqualError(t, err, want)
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// spoolPrefix starts the names of the spool files, followed by the process id, see CleanSpool.
+const spoolPrefix = "api-spool-"
+
+// spool is the policy of SpoolToDisk.
+type spool struct {
+	threshold int64
+	dir       string
+}
+
+// SpoolToDisk reads the whole response body before the call returns, keeping up to threshold
+// bytes of it in memory and spilling it to a temporary file in dir (os.TempDir if empty) beyond
+// that, so a body too large for memory still gets random access: the body of the response is an
+// io.ReadSeeker, whose Close removes the file.
+//
+//	resp, err := a.Do(ctx, req, api.VerifyChecksum(api.SHA256, ""), api.SpoolToDisk(8<<20, ""))
+//	...
+//	body := resp.Body.(io.ReadSeeker)
+//
+// The body is spooled as the other options modifying it left it, so VerifyChecksum checks the
+// whole body up front, failing the call on a mismatch, and the decode helpers read it as usual.
+// The Content-Length of the response is set to the size of the body. A body that's never closed
+// has its file removed once it's garbage collected; the files left by a process that crashed
+// are removed by CleanSpool.
+func SpoolToDisk(threshold int64, dir string) Option {
+	return func(c *call) {
+		c.spool = &spool{threshold: threshold, dir: dir}
+	}
+}
+
+// spoolBody returns the body of resp read to its end, in memory or in a file as the policy says.
+func (s *spool) spoolBody(resp *http.Response) (io.ReadCloser, int64, error) {
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(resp.Body, s.threshold+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if n <= s.threshold {
+		return &spoolBody{ReadSeeker: bytes.NewReader(buf.Bytes())}, n, nil
+	}
+	dir := s.dir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	f, err := os.CreateTemp(dir, spoolPrefix+strconv.Itoa(os.Getpid())+"-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	b := &spoolBody{ReadSeeker: f, file: f}
+	if n, err = io.Copy(f, io.MultiReader(&buf, resp.Body)); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		b.Close()
+		return nil, 0, err
+	}
+	var site string
+	if leakReport.Load() != nil {
+		site = callSite()
+	}
+	runtime.SetFinalizer(b, func(b *spoolBody) {
+		if report := leakReport.Load(); report != nil {
+			(*report)(site)
+		}
+		b.Close()
+	})
+	return b, n, nil
+}
+
+// spoolBody is a body spooled by SpoolToDisk.
+type spoolBody struct {
+	io.ReadSeeker
+	// file is the spool file, nil for a body kept in memory.
+	file *os.File
+
+	once sync.Once
+	err  error
+}
+
+// ReadAt reads from the body at off, whatever its offset.
+func (b *spoolBody) ReadAt(p []byte, off int64) (int, error) {
+	return b.ReadSeeker.(io.ReaderAt).ReadAt(p, off)
+}
+
+// Close removes the spool file.
+func (b *spoolBody) Close() error {
+	b.once.Do(func() {
+		if b.file == nil {
+			return
+		}
+		runtime.SetFinalizer(b, nil)
+		b.err = b.file.Close()
+		if err := os.Remove(b.file.Name()); b.err == nil {
+			b.err = err
+		}
+	})
+	return b.err
+}
+
+// CleanSpool removes the spool files of SpoolToDisk left in dir (os.TempDir if empty) by the
+// processes that didn't close their bodies, e.g. because they crashed, to be called when a
+// process starts. The files of the current process are kept; a dir shared by processes running
+// at the same time must not be cleaned, since their files would be removed too. It returns the
+// number of files removed.
+func CleanSpool(dir string) (int, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	names, err := filepath.Glob(filepath.Join(dir, spoolPrefix+"*"))
+	if err != nil {
+		return 0, err
+	}
+	own := spoolPrefix + strconv.Itoa(os.Getpid()) + "-"
+	removed := 0
+	var errs []error
+	for _, name := range names {
+		if strings.HasPrefix(filepath.Base(name), own) {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+	return removed, errors.Join(errs...)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func spoolFiles(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, spoolPrefix+"*"))
+	assert.NoError(t, err)
+	return names
+}
+
+func TestSpoolToDisk(t *testing.T) {
+	body := strings.Repeat("0123456789", 1000)
+	sum := sha256.Sum256([]byte(body))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amz-Checksum-Sha256", sum64(sum[:]))
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Del("X-Amz-Checksum-Sha256")
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"data": "`+body+`"}`)
+			return
+		case "/corrupt":
+			io.WriteString(w, body[1:])
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	for _, threshold := range []int64{int64(len(body)), 1024} {
+		req, _ := a.Request(GET, "/data", nil)
+		resp, err := a.Do(ctx, req, VerifyChecksum(SHA256, ""), SpoolToDisk(threshold, dir))
+		if !assert.NoError(t, err) {
+			return
+		}
+		spilled := threshold < int64(len(body))
+		assert.Len(t, spoolFiles(t, dir), map[bool]int{false: 0, true: 1}[spilled], threshold)
+		assert.Equal(t, int64(len(body)), resp.ContentLength)
+		rs, ok := resp.Body.(io.ReadSeeker)
+		if !assert.True(t, ok) {
+			return
+		}
+		data, err := io.ReadAll(rs)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(data))
+		// The body can be read again after it was read to its end.
+		pos, err := rs.Seek(-5, io.SeekEnd)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(body)-5), pos)
+		data, _ = io.ReadAll(rs)
+		assert.Equal(t, "56789", string(data))
+		p := make([]byte, 4)
+		_, err = resp.Body.(io.ReaderAt).ReadAt(p, 12)
+		assert.NoError(t, err)
+		assert.Equal(t, "2345", string(p))
+
+		assert.NoError(t, resp.Body.Close())
+		assert.NoError(t, resp.Body.Close())
+		assert.Empty(t, spoolFiles(t, dir))
+	}
+
+	// The decode helpers read the spooled body, and the checksum is checked as it's spooled.
+	var out struct{ Data string }
+	assert.NoError(t, a.Get(ctx, "/json", nil, &out, SpoolToDisk(100, dir)))
+	assert.Equal(t, body, out.Data)
+	assert.Empty(t, spoolFiles(t, dir))
+	req, _ := a.Request(GET, "/corrupt", nil)
+	_, err := a.Do(ctx, req, VerifyChecksum(SHA256, ""), SpoolToDisk(100, dir))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.Empty(t, spoolFiles(t, dir))
+}
+
+func TestCleanSpool(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{spoolPrefix + "1-123", spoolPrefix + "2-456", "other"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+	b, _, err := (&spool{dir: dir}).spoolBody(&http.Response{Body: io.NopCloser(strings.NewReader("live"))})
+	if !assert.NoError(t, err) {
+		return
+	}
+	removed, err := CleanSpool(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	// The files of the running process are kept.
+	assert.Len(t, spoolFiles(t, dir), 1)
+	_, err = os.Stat(filepath.Join(dir, "other"))
+	assert.NoError(t, err)
+	assert.NoError(t, b.Close())
+	assert.Empty(t, spoolFiles(t, dir))
+}
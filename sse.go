@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+)
+
+// EventStream returns the StreamFactory of the server-sent events (text/event-stream) of a GET
+// of resource with args, sending the checkpoint in the Last-Event-ID header, for Supervise:
+//
+//	s := api.Supervise(ctx, a.EventStream("/events", nil), api.ResubscribePolicy{})
+//
+// The events have the id, the event type and the data of their fields, the data lines joined
+// with newlines, and the last id received as their checkpoint, even those without an id. The
+// comments and the retry fields are ignored, and so are the events without data. The
+// subscriptions aren't retried by the Retry policy of the Api: Supervise takes care of it.
+func (a *Api) EventStream(resource string, args url.Values, opts ...Option) StreamFactory {
+	opts = append([]Option{WithRetry(nil)}, opts...)
+	return func(ctx context.Context, checkpoint string) (Stream, error) {
+		req, err := a.Request(GET, resource, args, opts...)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("Cache-Control", "no-cache")
+		if checkpoint != "" {
+			req.Header.Set("Last-Event-ID", checkpoint)
+		}
+		resp, err := a.send(ctx, a.newCallFor(resource, opts), req)
+		if err != nil {
+			return nil, err
+		}
+		return &sseStream{body: resp.Body, r: bufio.NewReader(resp.Body), last: checkpoint}, nil
+	}
+}
+
+// sseStream parses the server-sent events of a response body.
+type sseStream struct {
+	body io.Closer
+	r    *bufio.Reader
+	// last is the last event id received.
+	last string
+}
+
+func (s *sseStream) Next() (StreamEvent, error) {
+	var e StreamEvent
+	var data [][]byte
+	for {
+		line, err := s.r.ReadBytes('\n')
+		if err != nil {
+			// An event cut by the end of the stream is dropped.
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return StreamEvent{}, err
+		}
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		if len(line) == 0 {
+			if data == nil {
+				e = StreamEvent{}
+				continue
+			}
+			e.Data, e.Checkpoint = bytes.Join(data, []byte("\n")), s.last
+			return e, nil
+		}
+		if line[0] == ':' {
+			continue
+		}
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(field) {
+		case "data":
+			data = append(data, append([]byte(nil), value...))
+		case "event":
+			e.Type = string(value)
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				e.ID, s.last = string(value), string(value)
+			}
+		}
+	}
+}
+
+func (s *sseStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSEParse(t *testing.T) {
+	body := ": hello\r\n\r\nretry: 1000\nevent: ignored\n\ndata: a\r\ndata:b\ndata\nid: 7\n\n" +
+		"event: ping\ndata: {}\n\nid\ndata: x\n\ndata: cut"
+	s := &sseStream{body: io.NopCloser(nil), r: bufio.NewReader(strings.NewReader(body)), last: "5"}
+	var events []StreamEvent
+	for {
+		e, err := s.Next()
+		if err != nil {
+			assert.Equal(t, io.ErrUnexpectedEOF, err)
+			break
+		}
+		events = append(events, e)
+	}
+	assert.Equal(t, []StreamEvent{
+		{ID: "7", Data: []byte("a\nb\n"), Checkpoint: "7"},
+		{Type: "ping", Data: []byte("{}"), Checkpoint: "7"},
+		// An empty id resets the last one.
+		{Data: []byte("x")},
+	}, events)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxStaleBody limits the size of a response body kept for SetStaleIfError; larger ones aren't kept.
+	maxStaleBody = 1 << 20
+	// maxStaleEntries limits the number of responses kept for SetStaleIfError.
+	maxStaleEntries = 1024
+)
+
+// staleHeaders are the request headers distinguishing responses kept for SetStaleIfError,
+// so an answer is never served to a caller with different credentials or preferences.
+var staleHeaders = []string{"Accept", "Accept-Language", "Authorization"}
+
+// staleCache keeps the last successful response of GET and HEAD calls for SetStaleIfError.
+type staleCache struct {
+	window  time.Duration
+	mu      sync.Mutex
+	entries map[string]*staleEntry
+}
+
+type staleEntry struct {
+	status int
+	header http.Header
+	body   []byte
+	stored time.Time
+}
+
+// SetStaleIfError makes the Do-style helpers answer GET and HEAD calls with the last successful
+// response to the same request when the upstream fails with a transport error or a 5xx status,
+// as long as that response was received at most window ago. Other failures, like 4xx statuses,
+// are never masked. A stale answer carries a Warning 111 "Revalidation Failed" and an Age header,
+// and sets ResponseMeta.Stale. Only bodies read to their end are kept, up to 1MB each.
+//
+// The mode is disabled by default; a zero window disables it again, dropping the kept responses.
+func (a *Api) SetStaleIfError(window time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if window <= 0 {
+		a.stale = nil
+		return
+	}
+	a.stale = &staleCache{window: window, entries: make(map[string]*staleEntry)}
+}
+
+// staleCacheFor returns the stale cache if it applies to req.
+func (a *Api) staleCacheFor(req *http.Request) *staleCache {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stale
+}
+
+// keep makes resp be kept by the cache once its body has been read to the end.
+func (s *staleCache) keep(req *http.Request, resp *http.Response, clk Clock) {
+	key, err := CanonicalKey(req, staleHeaders)
+	if err != nil {
+		return
+	}
+	resp.Body = &keptBody{ReadCloser: resp.Body, limit: maxStaleBody, head: req.Method == http.MethodHead, done: func(body []byte) {
+		s.store(key, &staleEntry{status: resp.StatusCode, header: resp.Header.Clone(), body: body, stored: clk.Now()})
+	}}
+}
+
+func (s *staleCache) store(key string, e *staleEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; !ok && len(s.entries) >= maxStaleEntries {
+		for k, old := range s.entries {
+			if e.stored.Sub(old.stored) > s.window {
+				delete(s.entries, k)
+			}
+		}
+		for k := range s.entries {
+			if len(s.entries) < maxStaleEntries {
+				break
+			}
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = e
+}
+
+// serve returns the kept response to req if err may be masked and the response is fresh enough.
+func (s *staleCache) serve(req *http.Request, err error, now time.Time) (*http.Response, time.Duration, bool) {
+	if !staleable(err) {
+		return nil, 0, false
+	}
+	key, kerr := CanonicalKey(req, staleHeaders)
+	if kerr != nil {
+		return nil, 0, false
+	}
+	s.mu.Lock()
+	e, ok := s.entries[key]
+	s.mu.Unlock()
+	if !ok {
+		return nil, 0, false
+	}
+	age := now.Sub(e.stored)
+	if age > s.window {
+		return nil, 0, false
+	}
+	header := e.header.Clone()
+	header.Add("Warning", `111 - "Revalidation Failed"`)
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	return storedResponse(req, e.status, header, e.body), age, true
+}
+
+// storedResponse creates a response to req from a kept status, header and body.
+func storedResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// staleable reports whether err is a transport error or a 5xx status, which a stale answer may mask.
+func staleable(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code >= 500
+	}
+	var ue *url.Error
+	return errors.As(err, &ue) && !errors.Is(err, ErrBlockedTarget)
+}
+
+// keptBody captures the body and hands it to done once it's been read to the end,
+// unless it's larger than limit. HEAD bodies are handed over empty once closed.
+type keptBody struct {
+	io.ReadCloser
+	limit int
+	head  bool
+	buf   bytes.Buffer
+	done  func(body []byte)
+	over  bool
+}
+
+func (b *keptBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.over {
+		if b.buf.Len()+n > b.limit {
+			b.over, b.buf = true, bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.over && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
+
+func (b *keptBody) Close() error {
+	if b.head && b.done != nil {
+		b.done(nil)
+		b.done = nil
+	}
+	return b.ReadCloser.Close()
+}
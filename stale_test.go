@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestStaleIfError(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte(`{"n":1}`))
+	}))
+	defer srv.Close()
+
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a := MustNew(srv.URL)
+	a.SetClock(clk)
+	var out struct{ N int }
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+
+	// Disabled by default.
+	status.Store(http.StatusServiceUnavailable)
+	var se *StatusError
+	assert.True(t, errors.As(a.Get(context.Background(), "/items", nil, &out), &se))
+
+	a.SetStaleIfError(time.Minute)
+	status.Store(http.StatusOK)
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out))
+	assert.NoError(t, a.Post(context.Background(), "/items", nil, nil))
+
+	status.Store(http.StatusServiceUnavailable)
+	clk.Advance(20 * time.Second)
+	out.N = 0
+	var meta ResponseMeta
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.Equal(t, 1, out.N)
+	assert.True(t, meta.Stale)
+	assert.Equal(t, 20*time.Second, meta.Age)
+	assert.Equal(t, http.StatusOK, meta.StatusCode)
+	assert.Equal(t, "20", meta.Header.Get("Age"))
+	assert.Equal(t, []Warning{{Code: 111, Agent: "-", Text: "Revalidation Failed"}}, meta.Warnings)
+
+	// Other requests, 4xx statuses and other methods aren't masked.
+	assert.True(t, errors.As(a.Get(context.Background(), "/items", url.Values{"page": {"2"}}, &out), &se))
+	assert.True(t, errors.As(a.Get(context.Background(), "/items", nil, &out, WithHeader("Authorization", "Bearer other")), &se))
+	assert.True(t, errors.As(a.Post(context.Background(), "/items", nil, nil), &se))
+	status.Store(http.StatusNotFound)
+	if assert.True(t, errors.As(a.Get(context.Background(), "/items", nil, &out), &se)) {
+		assert.Equal(t, http.StatusNotFound, se.Code)
+	}
+
+	srv.Close()
+	out.N = 0
+	assert.NoError(t, a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta)))
+	assert.Equal(t, 1, out.N)
+	assert.True(t, meta.Stale)
+
+	clk.Advance(41 * time.Second)
+	err := a.Get(context.Background(), "/items", nil, &out, WithMeta(&meta))
+	var ue *url.Error
+	assert.True(t, errors.As(err, &ue), "%v", err)
+
+	// Disabling the mode drops the kept responses.
+	a.SetStaleIfError(0)
+	a.SetStaleIfError(time.Hour)
+	assert.Error(t, a.Get(context.Background(), "/items", nil, &out))
+}
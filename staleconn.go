@@ -0,0 +1,51 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// retriesStaleConn reports whether req is sent again by roundTrip when its reused connection was
+// closed by the server: it's the case of the requests with a replayable body that the transport
+// doesn't retry itself, those that aren't idempotent by its rules.
+func retriesStaleConn(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	if _, ok := req.Header["Idempotency-Key"]; ok {
+		return false
+	}
+	if _, ok := req.Header["X-Idempotency-Key"]; ok {
+		return false
+	}
+	if _, ok := req.Body.(*continueBody); ok {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// isStaleConn reports whether err is the failure of a connection closed by the server,
+// which the transport reports as "http: server closed idle connection", an EOF or a reset.
+func isStaleConn(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		strings.Contains(err.Error(), "server closed idle connection")
+}
+
+// replay returns a copy of req with its body replayed.
+func replay(req *http.Request) (*http.Request, error) {
+	r := *req
+	if req.Body == nil || req.Body == http.NoBody {
+		return &r, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = body
+	return &r, nil
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// closingServer is an HTTP/1.1 server closing every connection as the request after the first
+// one arrives, as a server closing an idle connection right when the client reuses it does.
+// It answers with the request body; partial makes it send the start of its status line before
+// closing.
+type closingServer struct {
+	l       net.Listener
+	partial atomic.Bool
+	mu      sync.Mutex
+	bodies  []string
+	wg      sync.WaitGroup
+}
+
+func newClosingServer(t *testing.T) *closingServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &closingServer{l: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+func (s *closingServer) URL() string { return "http://" + s.l.Addr().String() }
+
+func (s *closingServer) Close() {
+	s.l.Close()
+	s.wg.Wait()
+}
+
+func (s *closingServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+func (s *closingServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			br := bufio.NewReader(conn)
+			for n := 0; ; n++ {
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				body, _ := io.ReadAll(req.Body)
+				s.mu.Lock()
+				s.bodies = append(s.bodies, string(body))
+				s.mu.Unlock()
+				if n > 0 {
+					if s.partial.Load() {
+						conn.Write([]byte("HTTP/1.1 2"))
+					}
+					return
+				}
+				resp := &http.Response{StatusCode: http.StatusOK, ProtoMajor: 1, ProtoMinor: 1, ContentLength: int64(len(body)), Body: io.NopCloser(strings.NewReader(string(body)))}
+				resp.Write(conn)
+			}
+		}()
+	}
+}
+
+func TestStaleConnRetry(t *testing.T) {
+	srv := newClosingServer(t)
+	defer srv.Close()
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	a := MustNew(srv.URL())
+	a.Client = &http.Client{Transport: transport}
+	ctx := context.Background()
+
+	// The second POST is sent on the connection of the first one, which the server closes:
+	// it's sent again right away on a new one.
+	var out map[string]int
+	assert.NoError(t, a.Post(ctx, "/items", map[string]int{"id": 1}, &out))
+	assert.NoError(t, a.Post(ctx, "/items", map[string]int{"id": 2}, &out))
+	assert.Equal(t, map[string]int{"id": 2}, out)
+	assert.Equal(t, []string{`{"id":1}`, `{"id":2}`, `{"id":2}`}, srv.received())
+	stats := a.PoolStats()
+	assert.Equal(t, int64(1), stats.StaleRetries)
+	assert.Equal(t, int64(1), stats.ReusedConns)
+
+	// The idempotent requests are retried by the transport itself.
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil))
+	assert.NoError(t, a.Get(ctx, "/items", nil, nil))
+	assert.Equal(t, int64(1), a.PoolStats().StaleRetries)
+
+	// Once a byte of the response arrived, the request isn't sent again.
+	srv.partial.Store(true)
+	transport.CloseIdleConnections()
+	assert.NoError(t, a.Post(ctx, "/items", map[string]int{"id": 3}, nil))
+	err := a.Post(ctx, "/items", map[string]int{"id": 4}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), a.PoolStats().StaleRetries)
+	assert.Equal(t, `{"id":4}`, srv.received()[len(srv.received())-1])
+
+	// Nor is a request whose body can't be replayed.
+	srv.partial.Store(false)
+	transport.CloseIdleConnections()
+	assert.NoError(t, a.Post(ctx, "/items", map[string]int{"id": 5}, nil))
+	req, _ := a.RequestReader(POST, "/items", "application/json", io.LimitReader(strings.NewReader(`{"id":6}`), 8))
+	_, err = a.Do(ctx, req)
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), a.PoolStats().StaleRetries)
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+// StreamEvent is an event of a stream supervised by Supervise.
+type StreamEvent struct {
+	// ID is the id of the event, the one the duplicates are told apart by; empty if it has none.
+	ID string
+	// Type is the type of the event, e.g. the event field of a server-sent event.
+	Type string
+	Data []byte
+	// Checkpoint is where the stream resumes after the event, e.g. the Last-Event-ID or a watch
+	// token; ID is used if it's empty.
+	Checkpoint string
+}
+
+// Stream is a subscription to a stream of events, see StreamFactory.
+type Stream interface {
+	// Next returns the next event, blocking until there's one. Any error, io.EOF included,
+	// ends the subscription.
+	Next() (StreamEvent, error)
+	Close() error
+}
+
+// StreamFactory subscribes to a stream resuming after checkpoint, the checkpoint of the last
+// event received, empty for the first subscription. A *StatusError of a 410 Gone tells the
+// checkpoint has expired. See EventStream for the server-sent events.
+type StreamFactory func(ctx context.Context, checkpoint string) (Stream, error)
+
+// StreamState is the health of a supervised stream, see ResubscribePolicy.OnHealth.
+type StreamState int
+
+const (
+	// StreamConnected is a stream subscribed to.
+	StreamConnected StreamState = iota
+	// StreamDegraded is a stream disconnected, being subscribed to again.
+	StreamDegraded
+	// StreamDown is a stream given up on.
+	StreamDown
+)
+
+// String returns the state, e.g. "connected".
+func (s StreamState) String() string {
+	switch s {
+	case StreamConnected:
+		return "connected"
+	case StreamDegraded:
+		return "degraded"
+	case StreamDown:
+		return "down"
+	}
+	return fmt.Sprintf("StreamState(%d)", int(s))
+}
+
+// ResubscribePolicy configures Supervise.
+type ResubscribePolicy struct {
+	// MinBackoff and MaxBackoff bound the exponential backoff between the subscriptions after a
+	// disconnect, like the ones of RetryPolicy. A Retry-After sent by the server is honored.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxDowntime is how long the stream may stay disconnected altogether, from the disconnect
+	// until a subscription succeeds, before it's given up on; unlimited if zero.
+	MaxDowntime time.Duration
+	// Window is the number of the last event ids remembered for suppressing the duplicates,
+	// 1000 if zero.
+	Window int
+	// OnHealth is called with the state of the stream whenever it changes, with the error that
+	// caused it, from the goroutine of the supervision.
+	OnHealth func(state StreamState, err error)
+	// Clock is the source of time of the backoffs and the downtime, the real time if nil.
+	Clock Clock
+}
+
+// ErrCheckpointExpired is matched by every *CheckpointExpiredError.
+var ErrCheckpointExpired = errors.New("api: stream checkpoint expired")
+
+// CheckpointExpiredError ends a supervised stream whose checkpoint the server no longer has,
+// answering a 410 Gone: the caller must resync before subscribing again.
+type CheckpointExpiredError struct {
+	Checkpoint string
+	Err        error
+}
+
+func (e *CheckpointExpiredError) Error() string {
+	return fmt.Sprintf("api: stream checkpoint %q expired: %v", e.Checkpoint, e.Err)
+}
+
+// Is makes errors.Is(err, ErrCheckpointExpired) report true.
+func (e *CheckpointExpiredError) Is(target error) bool {
+	return target == ErrCheckpointExpired
+}
+
+func (e *CheckpointExpiredError) Unwrap() error {
+	return e.Err
+}
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *CheckpointExpiredError) Class() Class { return Permanent }
+
+// ErrStreamDown is matched by every *StreamDownError.
+var ErrStreamDown = errors.New("api: stream down")
+
+// StreamDownError ends a supervised stream disconnected for longer than
+// ResubscribePolicy.MaxDowntime.
+type StreamDownError struct {
+	Downtime time.Duration
+	// Err is the error of the last subscription.
+	Err error
+}
+
+func (e *StreamDownError) Error() string {
+	return fmt.Sprintf("api: stream down for %s: %v", e.Downtime, e.Err)
+}
+
+// Is makes errors.Is(err, ErrStreamDown) report true.
+func (e *StreamDownError) Is(target error) bool {
+	return target == ErrStreamDown
+}
+
+func (e *StreamDownError) Unwrap() error {
+	return e.Err
+}
+
+// Supervision is a stream supervised by Supervise.
+type Supervision struct {
+	events chan StreamEvent
+	done   chan struct{}
+	err    error
+}
+
+// Supervise subscribes to stream in a goroutine until ctx is done, subscribing again after
+// every disconnect from the checkpoint of the last event received, so its events flow through
+// the single channel of Events across the subscriptions:
+//
+//	s := api.Supervise(ctx, a.EventStream("/events", nil), api.ResubscribePolicy{
+//		MaxDowntime: 5 * time.Minute,
+//		OnHealth:    func(state api.StreamState, err error) { ... },
+//	})
+//	for e := range s.Events() {
+//		...
+//	}
+//	if errors.Is(s.Err(), api.ErrCheckpointExpired) {
+//		// resync
+//	}
+//
+// The events whose id is among the last ones received are dropped, for the servers replaying
+// the events around the checkpoint. The subscriptions failing with an error that isn't
+// IsRetryable, like a 401, end the supervision, so does a 410 Gone with a *CheckpointExpiredError,
+// and a downtime over the policy's MaxDowntime with a *StreamDownError; the other failures, and
+// the streams ending or failing, are followed by another subscription after a backoff.
+func Supervise(ctx context.Context, stream StreamFactory, policy ResubscribePolicy) *Supervision {
+	s := &Supervision{events: make(chan StreamEvent), done: make(chan struct{})}
+	go func() {
+		defer close(s.events)
+		defer close(s.done)
+		s.err = s.run(ctx, stream, &policy)
+	}()
+	return s
+}
+
+// Events returns the channel of the events, closed when the supervision ends. It must be
+// received from until then.
+func (s *Supervision) Events() <-chan StreamEvent {
+	return s.events
+}
+
+// Err waits for the supervision to end and returns why, nil if ctx was done.
+func (s *Supervision) Err() error {
+	<-s.done
+	return s.err
+}
+
+func (s *Supervision) run(ctx context.Context, stream StreamFactory, p *ResubscribePolicy) error {
+	clk := p.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+	state := StreamState(-1)
+	health := func(st StreamState, err error) {
+		if st != state {
+			state = st
+			if p.OnHealth != nil {
+				p.OnHealth(st, err)
+			}
+		}
+	}
+	backoff := &RetryPolicy{MinBackoff: p.MinBackoff, MaxBackoff: p.MaxBackoff}
+	seen := newIDWindow(p.Window)
+	var checkpoint string
+	var downSince time.Time
+	failures := 0
+	for {
+		st, err := stream(ctx, checkpoint)
+		if ctx.Err() != nil {
+			if st != nil {
+				st.Close()
+			}
+			return nil
+		}
+		if err == nil {
+			failures, downSince = 0, time.Time{}
+			health(StreamConnected, nil)
+			err = s.pump(ctx, st, &checkpoint, seen)
+			st.Close()
+			if ctx.Err() != nil {
+				return nil
+			}
+		} else if isGone(err) {
+			err = &CheckpointExpiredError{Checkpoint: checkpoint, Err: err}
+			health(StreamDown, err)
+			return err
+		} else if !IsRetryable(err) {
+			health(StreamDown, err)
+			return err
+		}
+		now := clk.Now()
+		if downSince.IsZero() {
+			downSince = now
+		}
+		if p.MaxDowntime > 0 && now.Sub(downSince) >= p.MaxDowntime {
+			err = &StreamDownError{Downtime: now.Sub(downSince), Err: err}
+			health(StreamDown, err)
+			return err
+		}
+		health(StreamDegraded, err)
+		d := backoff.delay(failures)
+		var se *StatusError
+		if errors.As(err, &se) {
+			if after, ok := ParseRetryAfter(se.Header, now); ok {
+				d = after
+			}
+		}
+		failures++
+		if clk.Sleep(ctx, d) != nil {
+			return nil
+		}
+	}
+}
+
+// isGone reports whether err is the *StatusError of a 410 Gone.
+func isGone(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.Code == http.StatusGone
+}
+
+// pump sends the events of st until it fails, keeping the checkpoint of the last one.
+func (s *Supervision) pump(ctx context.Context, st Stream, checkpoint *string, seen *idWindow) error {
+	for {
+		e, err := st.Next()
+		if err != nil {
+			return err
+		}
+		if e.Checkpoint == "" {
+			e.Checkpoint = e.ID
+		}
+		if e.Checkpoint != "" {
+			*checkpoint = e.Checkpoint
+		}
+		if e.ID != "" && !seen.add(e.ID) {
+			continue
+		}
+		if !sendOrDone(ctx, s.events, e) {
+			return ctx.Err()
+		}
+	}
+}
+
+// idWindow remembers the last ids added to it.
+type idWindow struct {
+	ids  []string
+	next int
+	set  map[string]struct{}
+}
+
+func newIDWindow(n int) *idWindow {
+	if n <= 0 {
+		n = 1000
+	}
+	return &idWindow{ids: make([]string, 0, n), set: make(map[string]struct{}, n)}
+}
+
+// add adds id, reporting whether it wasn't among the last ones.
+func (w *idWindow) add(id string) bool {
+	if _, ok := w.set[id]; ok {
+		return false
+	}
+	if len(w.ids) < cap(w.ids) {
+		w.ids = append(w.ids, id)
+	} else {
+		delete(w.set, w.ids[w.next])
+		w.ids[w.next] = id
+		w.next = (w.next + 1) % len(w.ids)
+	}
+	w.set[id] = struct{}{}
+	return true
+}
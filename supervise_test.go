@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervise(t *testing.T) {
+	var mu sync.Mutex
+	var lastIDs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastIDs = append(lastIDs, r.Header.Get("Last-Event-ID"))
+		n := len(lastIDs)
+		mu.Unlock()
+		assert.Equal(t, "text/event-stream", r.Header.Get("Accept"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch n {
+		case 1:
+			fmt.Fprint(w, "id: 1\ndata: one\n\nid: 2\nevent: update\ndata: two\n\n")
+		case 2:
+			// The server replays the event of the checkpoint after the first disconnect.
+			fmt.Fprint(w, "id: 2\nevent: update\ndata: two\n\nid: 3\ndata: three\n\n")
+		case 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 4:
+			fmt.Fprint(w, ": keep-alive\n\ndata: no id\n\n")
+		default:
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	var states []string
+	s := Supervise(context.Background(), a.EventStream("/events", nil), ResubscribePolicy{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+		OnHealth: func(state StreamState, err error) {
+			states = append(states, state.String())
+		},
+	})
+	var events []StreamEvent
+	for e := range s.Events() {
+		events = append(events, e)
+	}
+	assert.Equal(t, []StreamEvent{
+		{ID: "1", Data: []byte("one"), Checkpoint: "1"},
+		{ID: "2", Type: "update", Data: []byte("two"), Checkpoint: "2"},
+		{ID: "3", Data: []byte("three"), Checkpoint: "3"},
+		{Data: []byte("no id"), Checkpoint: "3"},
+	}, events)
+	assert.Equal(t, []string{"", "2", "3", "3", "3"}, lastIDs)
+	assert.Equal(t, []string{"connected", "degraded", "connected", "degraded", "connected", "degraded", "down"}, states)
+
+	// The expired checkpoint ends the supervision distinctly.
+	err := s.Err()
+	assert.ErrorIs(t, err, ErrCheckpointExpired)
+	var ce *CheckpointExpiredError
+	if assert.ErrorAs(t, err, &ce) {
+		assert.Equal(t, "3", ce.Checkpoint)
+	}
+	var se *StatusError
+	if assert.ErrorAs(t, err, &se) {
+		assert.Equal(t, http.StatusGone, se.Code)
+	}
+}
+
+// failingStream is a StreamFactory failing with err.
+func failingStream(err error) StreamFactory {
+	return func(context.Context, string) (Stream, error) {
+		return nil, err
+	}
+}
+
+func TestSuperviseGiveUp(t *testing.T) {
+	// The failures that aren't retryable end the supervision at once.
+	denied := &StatusError{Code: http.StatusUnauthorized, Header: http.Header{}}
+	s := Supervise(context.Background(), failingStream(denied), ResubscribePolicy{})
+	for range s.Events() {
+	}
+	assert.Equal(t, error(denied), s.Err())
+
+	// The others until MaxDowntime.
+	unavailable := &StatusError{Code: http.StatusServiceUnavailable, Header: http.Header{}}
+	start := time.Now()
+	s = Supervise(context.Background(), failingStream(unavailable), ResubscribePolicy{
+		MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, MaxDowntime: 20 * time.Millisecond,
+	})
+	for range s.Events() {
+	}
+	err := s.Err()
+	assert.ErrorIs(t, err, ErrStreamDown)
+	var de *StreamDownError
+	if assert.ErrorAs(t, err, &de) {
+		assert.True(t, de.Downtime >= 20*time.Millisecond)
+		assert.Equal(t, error(unavailable), de.Err)
+	}
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+	// Canceling the context ends it without an error.
+	ctx, cancel := context.WithCancel(context.Background())
+	s = Supervise(ctx, failingStream(unavailable), ResubscribePolicy{MinBackoff: time.Hour, MaxBackoff: time.Hour})
+	cancel()
+	for range s.Events() {
+	}
+	assert.NoError(t, s.Err())
+	assert.False(t, errors.Is(err, ErrCheckpointExpired))
+}
+
+func TestIDWindow(t *testing.T) {
+	w := newIDWindow(2)
+	for _, tc := range []struct {
+		id  string
+		add bool
+	}{{"a", true}, {"b", true}, {"a", false}, {"c", true}, {"a", true}, {"b", true}, {"a", false}, {"c", true}} {
+		assert.Equal(t, tc.add, w.add(tc.id), tc.id)
+	}
+}
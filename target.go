@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrBlockedTarget is matched by every *BlockedTargetError, see SetTargetPolicy.
+var ErrBlockedTarget = errors.New("api: blocked target")
+
+// TargetRule is a rule of the TargetPolicy.
+type TargetRule int
+
+const (
+	// RuleScheme blocks URLs whose scheme is neither http nor https.
+	RuleScheme TargetRule = iota + 1
+	// RuleHost blocks hosts missing from TargetPolicy.AllowHosts.
+	RuleHost
+	// RulePrivate blocks connections to private, loopback, link-local and unspecified addresses.
+	RulePrivate
+)
+
+func (r TargetRule) String() string {
+	switch r {
+	case RuleScheme:
+		return "scheme"
+	case RuleHost:
+		return "host allowlist"
+	case RulePrivate:
+		return "private address"
+	default:
+		return "unknown"
+	}
+}
+
+// BlockedTargetError is returned when a call is blocked by the TargetPolicy of the Api.
+type BlockedTargetError struct {
+	// Rule is the rule that blocked the call.
+	Rule TargetRule
+	// Target is the blocked URL, or the dialed address for RulePrivate.
+	Target string
+	// IP is the blocked address for RulePrivate.
+	IP net.IP
+}
+
+func (e *BlockedTargetError) Error() string {
+	if e.IP != nil {
+		return fmt.Sprintf("api: blocked target %s (%s): %s rule", e.Target, e.IP, e.Rule)
+	}
+	return fmt.Sprintf("api: blocked target %s: %s rule", e.Target, e.Rule)
+}
+
+// Is makes errors.Is(err, ErrBlockedTarget) report true.
+func (e *BlockedTargetError) Is(target error) bool {
+	return target == ErrBlockedTarget
+}
+
+// TargetPolicy restricts where an Api may send requests, guarding against SSRF when resources
+// or followed links are influenced by callers. Only http and https URLs are allowed under any policy.
+type TargetPolicy struct {
+	// AllowHosts lists the hosts that may be requested; empty allows any host.
+	// An entry starting with a dot allows the subdomains of the rest, so ".example.com"
+	// allows "api.example.com" but not "example.com" itself.
+	AllowHosts []string
+	// BlockPrivate blocks connections to private, loopback, link-local and unspecified addresses.
+	// It's checked at dial time against the addresses actually connected to, so DNS rebinding
+	// doesn't bypass it. When a proxy is used, the address of the proxy is checked.
+	// It requires the Transport of the client to be an *http.Transport or nil.
+	BlockPrivate bool
+}
+
+// targetGuard is the policy set by SetTargetPolicy along with the client derived from base to enforce it.
+type targetGuard struct {
+	policy TargetPolicy
+	base   *http.Client
+	client *http.Client
+}
+
+// SetTargetPolicy restricts the targets of the calls made via Do, including redirects they follow
+// and pages requested by List, to those allowed by p. Violations fail the call with
+// a *BlockedTargetError. A nil p removes the restrictions.
+//
+// The policy is enforced by a copy of the Api's client that is derived again whenever Client
+// is replaced, so changes made to the fields of the current Client aren't picked up.
+func (a *Api) SetTargetPolicy(p *TargetPolicy) {
+	if p == nil {
+		a.target.Store(nil)
+		return
+	}
+	policy := TargetPolicy{BlockPrivate: p.BlockPrivate}
+	for _, host := range p.AllowHosts {
+		policy.AllowHosts = append(policy.AllowHosts, strings.ToLower(strings.TrimSuffix(host, ".")))
+	}
+	a.target.Store(newTargetGuard(policy, a.certClient(a.baseClient())))
+}
+
+// checkTarget checks u against the target policy, if one is set.
+func (a *Api) checkTarget(u *url.URL) error {
+	if g := a.target.Load(); g != nil {
+		return g.policy.check(u)
+	}
+	return nil
+}
+
+// targetClient returns the client enforcing the target policy for base, deriving it if needed.
+func (a *Api) targetClient(base *http.Client) *http.Client {
+	g := a.target.Load()
+	if g == nil {
+		return base
+	}
+	if g.base != base {
+		fresh := newTargetGuard(g.policy, base)
+		if !a.target.CompareAndSwap(g, fresh) {
+			return a.targetClient(base)
+		}
+		g = fresh
+	}
+	return g.client
+}
+
+func newTargetGuard(policy TargetPolicy, base *http.Client) *targetGuard {
+	g := &targetGuard{policy: policy, base: base}
+	client := *base
+	check := base.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if err := policy.check(req.URL); err != nil {
+			return err
+		}
+		if check != nil {
+			return check(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	if policy.BlockPrivate {
+		client.Transport = guardTransport(base.Transport)
+	}
+	g.client = &client
+	return g
+}
+
+// check applies the scheme and host rules to u.
+func (p *TargetPolicy) check(u *url.URL) error {
+	if scheme := strings.ToLower(u.Scheme); scheme != "http" && scheme != "https" {
+		return &BlockedTargetError{Rule: RuleScheme, Target: u.Redacted()}
+	}
+	if len(p.AllowHosts) == 0 {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	for _, allowed := range p.AllowHosts {
+		if host == allowed || strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed) {
+			return nil
+		}
+	}
+	return &BlockedTargetError{Rule: RuleHost, Target: u.Redacted()}
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// guardTransport returns a copy of rt that refuses to connect to private addresses.
+// Transports other than *http.Transport can't be guarded, so they fail every request.
+func guardTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return errTransport{fmt.Errorf("api: the %s rule requires an *http.Transport, got %T", RulePrivate, rt)}
+	}
+	t = t.Clone()
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = guardDial(dial)
+	if t.DialTLSContext != nil {
+		t.DialTLSContext = guardDial(t.DialTLSContext)
+	}
+	return t
+}
+
+// guardDial resolves the host of addr, checks all of its addresses and dials the checked ones,
+// so a second resolution can't change where the connection goes.
+func guardDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		var ips []net.IP
+		if ip := net.ParseIP(host); ip != nil {
+			ips = []net.IP{ip}
+		} else {
+			addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range addrs {
+				ips = append(ips, a.IP)
+			}
+		}
+		for _, ip := range ips {
+			if privateIP(ip) {
+				return nil, &BlockedTargetError{Rule: RulePrivate, Target: addr, IP: ip}
+			}
+		}
+		if len(ips) == 0 {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		for _, ip := range ips {
+			var conn net.Conn
+			if conn, err = dial(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+func privateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+// errTransport fails every request with err.
+type errTransport struct {
+	err error
+}
+
+func (t errTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
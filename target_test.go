@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetPolicyPrivate(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	for _, uri := range []string{"http://localhost:" + port, srv.URL} {
+		a := MustNew(uri)
+		a.SetTargetPolicy(&TargetPolicy{BlockPrivate: true})
+		err := a.Get(context.Background(), "/", nil, nil)
+		var be *BlockedTargetError
+		if !assert.True(t, errors.As(err, &be), "%s: %v", uri, err) {
+			continue
+		}
+		assert.Equal(t, RulePrivate, be.Rule)
+		assert.True(t, be.IP.IsLoopback())
+		assert.True(t, errors.Is(err, ErrBlockedTarget))
+		assert.Equal(t, Permanent, Classify(err))
+	}
+	assert.Equal(t, 0, hits)
+
+	a := MustNew(srv.URL)
+	a.SetTargetPolicy(&TargetPolicy{BlockPrivate: true})
+	a.SetTargetPolicy(nil)
+	assert.NoError(t, a.Get(context.Background(), "/", nil, nil))
+	assert.Equal(t, 1, hits)
+}
+
+func TestTargetPolicyTransport(t *testing.T) {
+	a := MustNew("http://example.com")
+	a.Client = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("unreachable")
+	})}
+	a.SetTargetPolicy(&TargetPolicy{BlockPrivate: true})
+	err := a.Get(context.Background(), "/", nil, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "private address rule requires an *http.Transport")
+	}
+}
+
+type roundTripper func(req *http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestTargetPolicyAllowHosts(t *testing.T) {
+	var hosts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	// Every host is served by srv, so only the policy decides which calls get through.
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	a := MustNew("http://api.example.com")
+	a.Client = &http.Client{Transport: &http.Transport{DialContext: dial}}
+	a.SetTargetPolicy(&TargetPolicy{AllowHosts: []string{".Example.com", "other.org."}})
+
+	for _, tc := range []struct {
+		url  string
+		rule TargetRule
+	}{
+		{"http://api.example.com/", 0},
+		{"https://deep.api.example.com./", 0},
+		{"http://example.com/", RuleHost},
+		{"http://evilexample.com/", RuleHost},
+		{"http://example.com.evil.net/", RuleHost},
+		{"http://OTHER.org:8080/", 0},
+		{"http://sub.other.org/", RuleHost},
+		{"file:///etc/passwd", RuleScheme},
+		{"gopher://api.example.com/", RuleScheme},
+		{"http://api.example.com/redirect?to=" + url.QueryEscape("http://169.254.169.254/latest"), RuleHost},
+		{"http://api.example.com/redirect?to=" + url.QueryEscape("http://www.other.org/"), RuleHost},
+		{"http://api.example.com/redirect?to=" + url.QueryEscape("http://other.org/"), 0},
+	} {
+		hosts = nil
+		req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		if strings.HasPrefix(tc.url, "https") {
+			// Served over plain http by srv; only the policy check matters here.
+			req.URL.Scheme = "http"
+		}
+		resp, err := a.Do(context.Background(), req)
+		if tc.rule == 0 {
+			if assert.NoError(t, err, tc.url) {
+				resp.Body.Close()
+				assert.NotEmpty(t, hosts, tc.url)
+			}
+			continue
+		}
+		var be *BlockedTargetError
+		if assert.True(t, errors.As(err, &be), "%s: %v", tc.url, err) {
+			assert.Equal(t, tc.rule, be.Rule, tc.url)
+		}
+		if !strings.Contains(tc.url, "redirect") {
+			assert.Empty(t, hosts, tc.url)
+		}
+	}
+}
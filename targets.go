@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Targets decodes the members of a JSON object into their own destinations, see DecodeTargets.
+type Targets struct {
+	targets map[string]interface{}
+}
+
+// DecodeTargets returns an out for DoJSON and the other decoding calls decoding the members of
+// the JSON object of the response into the destinations of targets by key, in a single pass:
+//
+//	var items []Item
+//	var meta PageMeta
+//	var warns []string
+//	err := a.Get(ctx, "/items", nil, api.DecodeTargets(map[string]interface{}{
+//		"data": &items, "meta": &meta, "warnings": &warns,
+//	}))
+//
+// The other members are skipped without being decoded; with WithDecodeStrict they fail the call
+// like unknown fields do. The destinations of the members missing in the object are left as they
+// are, and a member given twice is decoded twice into its destination, the last one winning like
+// with encoding/json. A null body leaves them all as they are. It's a json.Unmarshaler too.
+func DecodeTargets(targets map[string]interface{}) *Targets {
+	return &Targets{targets: targets}
+}
+
+// UnmarshalJSON decodes the members of the object in data into their destinations.
+func (t *Targets) UnmarshalJSON(data []byte) error {
+	err := t.decode(json.NewDecoder(bytes.NewReader(data)), false)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// decode decodes the next value of dec, which must be an object or null, into the destinations.
+// With strict set, the members without one are an error. An empty input is io.EOF.
+func (t *Targets) decode(dec *json.Decoder, strict bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return &json.UnmarshalTypeError{Value: jsonKind(tok), Type: reflect.TypeOf(t), Offset: dec.InputOffset()}
+	}
+	var skip skipValue
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		key := tok.(string)
+		if target, ok := t.targets[key]; ok {
+			err = dec.Decode(target)
+		} else if strict {
+			return fmt.Errorf("json: unknown field %q", key)
+		} else {
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	_, err = dec.Token()
+	return unexpectedEOF(err)
+}
+
+// skipValue discards the JSON value decoded into it without decoding it.
+type skipValue struct{}
+
+func (*skipValue) UnmarshalJSON([]byte) error { return nil }
+
+// jsonKind names the kind of the JSON value starting with tok.
+func jsonKind(tok json.Token) string {
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			return "array"
+		}
+	case string:
+		return "string"
+	case float64, json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "value"
+}
+
+// decodeValue decodes the next value of dec into out, which may be a *Targets.
+func (c *call) decodeValue(dec *json.Decoder, out interface{}) error {
+	if t, ok := out.(*Targets); ok {
+		return t.decode(dec, c.strict)
+	}
+	return dec.Decode(out)
+}
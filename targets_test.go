@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type targetsMeta struct {
+	Next  string `json:"next"`
+	Total int    `json:"total"`
+}
+
+func TestDecodeTargets(t *testing.T) {
+	var items []testItem
+	var meta targetsMeta
+	var warns []string
+	rate := "untouched"
+	targets := DecodeTargets(map[string]interface{}{"data": &items, "meta": &meta, "warnings": &warns, "rate": &rate})
+	body := `{"extra": {"deep": [1, {"x": [2, "}"]}], "s": "]"}, "data": [{"ID": 1, "Name": "a"}, {"ID": 2}],
+		"n": 1.5e3, "meta": {"next": "abc", "total": 10}, "b": true, "nil": null, "warnings": ["slow"], "meta": {"total": 12}}`
+	if !assert.NoError(t, json.Unmarshal([]byte(body), targets)) {
+		return
+	}
+	assert.Equal(t, []testItem{{ID: 1, Name: "a"}, {ID: 2}}, items)
+	// The last duplicate wins, over the fields decoded before.
+	assert.Equal(t, targetsMeta{Next: "abc", Total: 12}, meta)
+	assert.Equal(t, []string{"slow"}, warns)
+	assert.Equal(t, "untouched", rate)
+
+	assert.NoError(t, json.Unmarshal([]byte(`null`), targets))
+	assert.Equal(t, "untouched", rate)
+	err := json.Unmarshal([]byte(`[1]`), targets)
+	assert.EqualError(t, err, "json: cannot unmarshal array into Go value of type *api.Targets")
+	assert.Error(t, json.Unmarshal([]byte(`{"data": [1,`), targets))
+	assert.Error(t, json.Unmarshal([]byte(`{"data": "x"}`), targets))
+}
+
+func TestDecodeTargetsCall(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/empty":
+		case "/array":
+			io.WriteString(w, `[]`)
+		default:
+			io.WriteString(w, `{"id": "x", "data": [{"ID": 7}], "meta": {"next": "n2"}}`)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	var items []testItem
+	var meta targetsMeta
+	targets := DecodeTargets(map[string]interface{}{"data": &items, "meta": &meta})
+	if !assert.NoError(t, a.Get(ctx, "/items", nil, targets)) {
+		return
+	}
+	assert.Equal(t, []testItem{{ID: 7}}, items)
+	assert.Equal(t, "n2", meta.Next)
+
+	// The unknown members fail the strict calls.
+	err := a.Get(ctx, "/items", nil, targets, WithDecodeStrict())
+	assert.EqualError(t, err, `json: unknown field "id"`)
+	// The empty bodies have no content, the other values fail.
+	var m ResponseMeta
+	assert.NoError(t, a.Get(ctx, "/empty", nil, targets, WithMeta(&m)))
+	assert.True(t, m.NoContent)
+	assert.Error(t, a.Get(ctx, "/array", nil, targets))
+
+	// The memoized results are decoded the same way.
+	tpl := a.Template(GET, "/items", Memoize(time.Minute))
+	for i := 0; i < 2; i++ {
+		items = nil
+		assert.NoError(t, tpl.Do(ctx, nil, nil, targets))
+		assert.Equal(t, []testItem{{ID: 7}}, items)
+	}
+}
+
+// targetsBody is a page of n items along with sidecar members.
+func targetsBody(n int) []byte {
+	var b strings.Builder
+	b.WriteString(`{"rate": {"limit": 100, "remaining": 42}, "data": [`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"ID": %d, "Name": "item %d"}`, i, i)
+	}
+	b.WriteString(`], "debug": {"trace": [`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"span": %d, "tags": {"a": "b"}}`, i)
+	}
+	b.WriteString(`]}, "meta": {"next": "c2", "total": 1000}, "warnings": ["deprecated"]}`)
+	return []byte(b.String())
+}
+
+func BenchmarkDecodeTargets(b *testing.B) {
+	body := targetsBody(100)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var items []testItem
+		var meta targetsMeta
+		var warns []string
+		dec := json.NewDecoder(bytes.NewReader(body))
+		err := DecodeTargets(map[string]interface{}{"data": &items, "meta": &meta, "warnings": &warns}).decode(dec, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodeTwice decodes the same members by decoding the body into a struct, then into a map.
+func BenchmarkDecodeTwice(b *testing.B) {
+	body := targetsBody(100)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var page struct {
+			Data []testItem `json:"data"`
+		}
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(&page); err != nil {
+			b.Fatal(err)
+		}
+		var rest map[string]interface{}
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(&rest); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
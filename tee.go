@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// TeeResponse makes the call copy everything read from the response body into w,
+// including bodies of non-2xx responses and NDJSON streams. If the response was
+// transparently decompressed by the transport (gzip), w receives the decompressed bytes.
+// When the body is closed, w is flushed if it has a Flush() error method; w itself is never closed.
+func TeeResponse(w io.Writer) Option {
+	return func(c *call) {
+		c.wrappers = append(c.wrappers, func(_ *http.Response, body io.ReadCloser) io.ReadCloser {
+			return &teeBody{body: body, w: w}
+		})
+	}
+}
+
+// CaptureResponse is like TeeResponse, but stores the captured bytes into dst once the call completes.
+func CaptureResponse(dst *[]byte) Option {
+	return func(c *call) {
+		c.wrappers = append(c.wrappers, func(_ *http.Response, body io.ReadCloser) io.ReadCloser {
+			buf := new(bytes.Buffer)
+			return &teeBody{body: body, w: buf, done: func() {
+				*dst = buf.Bytes()
+			}}
+		})
+	}
+}
+
+type teeBody struct {
+	body io.ReadCloser
+	w    io.Writer
+	err  error
+	once sync.Once
+	done func()
+}
+
+func (t *teeBody) Read(p []byte) (n int, err error) {
+	n, err = t.body.Read(p)
+	if n > 0 && t.err == nil {
+		if _, werr := t.w.Write(p[:n]); werr != nil {
+			t.err = werr
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (t *teeBody) Close() error {
+	err := t.body.Close()
+	t.once.Do(func() {
+		if f, ok := t.w.(interface{ Flush() error }); ok {
+			if ferr := f.Flush(); ferr != nil && err == nil {
+				err = ferr
+			}
+		}
+		if t.done != nil {
+			t.done()
+		}
+	})
+	return err
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeeResponse(t *testing.T) {
+	const served = `{"id": 1, "name": "foo"}` + "\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(served))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var buf bytes.Buffer
+	var out struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	err := a.DoJSON(context.Background(), GET, "/", nil, &out, TeeResponse(&buf))
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", out.Name)
+	assert.Equal(t, served, buf.String())
+}
+
+func TestCaptureResponseNDJSON(t *testing.T) {
+	const served = "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(served))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var captured []byte
+	var count int
+	err := a.DoNDJSON(context.Background(), GET, "/", nil, func(v json.RawMessage) error {
+		count++
+		return nil
+	}, CaptureResponse(&captured))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, served, string(captured))
+}
+
+func TestCaptureResponseGzip(t *testing.T) {
+	const served = `{"data": "compressed but captured in plain form"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(served))
+		zw.Close()
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var captured []byte
+	var out map[string]string
+	err := a.DoJSON(context.Background(), GET, "/", nil, &out, CaptureResponse(&captured))
+	assert.NoError(t, err)
+	assert.Equal(t, served, string(captured))
+}
+
+func TestCaptureResponseDecodeError(t *testing.T) {
+	const served = `{"id": "not a number"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(served))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var captured []byte
+	var out struct {
+		ID int `json:"id"`
+	}
+	err := a.DoJSON(context.Background(), GET, "/", nil, &out, CaptureResponse(&captured))
+	assert.Error(t, err)
+	assert.Equal(t, served, string(captured))
+}
+
+func TestCaptureResponseStatusError(t *testing.T) {
+	const served = `{"error": "missing"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(served))
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var captured []byte
+	err := a.DoJSON(context.Background(), GET, "/", nil, nil, CaptureResponse(&captured))
+	var se *StatusError
+	if assert.ErrorAs(t, err, &se) {
+		assert.Equal(t, http.StatusNotFound, se.Code)
+		assert.Equal(t, served, string(se.Body))
+	}
+	assert.Equal(t, served, string(captured))
+}
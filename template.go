@@ -0,0 +1,313 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// RequestTemplate is a request precompiled by Template for the endpoints called over and over.
+// It's immutable and safe for concurrent use.
+type RequestTemplate struct {
+	a        *Api
+	method   Method
+	resource string
+	base     *url.URL
+	seg      string
+	policy   PathPolicy
+	strict   bool
+	// lits and names split the resource around its parameters: lits[0] {names[0]} lits[1] ...
+	lits, names []string
+	// path splits the joined path the same way, nil if the parameters can't be substituted into it.
+	path []string
+	// header is the header set by the Header and version preparers, which are run once.
+	header http.Header
+	// chain is the rest of the preparer chain, run for every request.
+	chain []preparerEntry
+	// ctxValues is set when the context preparer runs first, see applyContext.
+	ctxValues bool
+	// shape holds the opts, applied to the requests of Build.
+	shape *call
+	// proto holds the defaults and the opts, copied for every call of Do.
+	proto *call
+}
+
+// Template precompiles the requests for the method and resource, doing once the work Request
+// does for every request: joining the resource with the base URI and the path version,
+// merging the Header, and processing opts. The resource may have parameters, e.g.
+// "/users/{id}/orders", substituted by Build and Do:
+//
+//	orders := a.Template(api.GET, "/users/{id}/orders")
+//	...
+//	err := orders.Do(ctx, map[string]string{"id": id}, url.Values{"status": {"open"}}, &out)
+//
+// Each parameter is a single segment, its value being data like the one of a Seg: a "/", "?" or
+// "#" in it is escaped rather than interpreted, and "." and ".." don't move up the path. A value
+// made by Seg or Matrix.Seg is the segment it holds, e.g. for the matrix parameters.
+//
+// The base URI, the Header, the version, the path policy and strictness, the defaults and the
+// preparer chain are taken when Template is called; later changes to them aren't picked up. The preparers
+// themselves still run for every request, so the token of the TokenSource stays current.
+// Since opts are shared by every call, they mustn't hold per-call state like WithMeta.
+// Invalid opts, like an unknown method, fail Build and Do.
+func (a *Api) Template(method Method, resource string, opts ...Option) *RequestTemplate {
+	t := &RequestTemplate{
+		a:        a,
+		method:   method,
+		resource: resource,
+		base:     a.baseURI(),
+		seg:      a.currentVersion().segment,
+		policy:   PathPolicy(a.pathPolicy.Load()),
+		strict:   a.strictPaths.Load(),
+		header:   make(http.Header),
+		shape:    &call{},
+		proto:    a.newCall(opts),
+	}
+	for _, opt := range opts {
+		opt(t.shape)
+	}
+	if method < GET || method > PATCH {
+		t.shape.fail(fmt.Errorf("api: unknown method: %d", method))
+		t.proto.fail(t.shape.err)
+	}
+	t.lits, t.names = splitParams(resource)
+	t.path = t.splitPath()
+
+	a.mu.Lock()
+	chain := a.chain
+	a.mu.Unlock()
+	if chain == nil {
+		chain = a.builtinPreparers()
+	}
+	// The context preparer is run by build, over the Header frozen below.
+	if len(chain) > 0 && chain[0].builtin && chain[0].name == "api.Context" {
+		t.ctxValues = true
+		chain = chain[1:]
+	}
+	// The Header and version preparers only depend on the Api's configuration, so they're run now,
+	// unless other preparers precede them. The query one doesn't touch the header, so it's kept.
+	var kept []preparerEntry
+	for len(chain) > 0 && chain[0].builtin && (chain[0].name == "api.Header" || chain[0].name == "api.HeaderVersion" || chain[0].name == "api.Query") {
+		if chain[0].name == "api.Query" {
+			kept = append(kept, chain[0])
+		} else {
+			chain[0].p.Prepare(context.Background(), &http.Request{Header: t.header})
+		}
+		chain = chain[1:]
+	}
+	t.chain = append(kept, chain...)
+	return t
+}
+
+// splitParams splits resource around its "{name}" parameters.
+func splitParams(resource string) (lits, names []string) {
+	for {
+		i := strings.IndexByte(resource, '{')
+		j := strings.IndexByte(resource[i+1:], '}')
+		if i < 0 || j <= 0 {
+			return append(lits, resource), names
+		}
+		lits = append(lits, resource[:i])
+		names = append(names, resource[i+1:i+1+j])
+		resource = resource[i+2+j:]
+	}
+}
+
+// splitPath joins the resource with the base path and splits the result around the parameters.
+// It returns nil if the parameters don't come through the join unchanged, or if the version
+// segment depends on them.
+func (t *RequestTemplate) splitPath() []string {
+	if len(t.names) == 0 || t.base.RawPath != "" {
+		return nil
+	}
+	if first, _, _ := strings.Cut(strings.TrimPrefix(path.Clean("/"+t.resource), "/"), "/"); strings.Contains(first, "{") {
+		return nil
+	}
+	u, err := t.a.resolveURL(t.base, t.seg, t.policy, t.strict, t.resource)
+	if err != nil || u.RawPath != "" {
+		return nil
+	}
+	lits, names := splitParams(u.Path)
+	if len(names) != len(t.names) {
+		return nil
+	}
+	for i, name := range names {
+		if name != t.names[i] {
+			return nil
+		}
+	}
+	return lits
+}
+
+// url returns the URL of the request for params, along with the resource they were substituted into.
+func (t *RequestTemplate) url(params map[string]string) (*url.URL, string, error) {
+	if len(t.names) == 0 {
+		u, err := t.a.resolveURL(t.base, t.seg, t.policy, t.strict, t.a.migrate(t.resource))
+		return u, t.resource, err
+	}
+	values := make([]string, len(t.names))
+	// The migrations rewrite the resource before the join.
+	plain := t.path != nil && t.a.migrations.Load() == nil
+	for i, name := range t.names {
+		v, ok := params[name]
+		if !ok {
+			return nil, "", fmt.Errorf("api: missing template parameter %q", name)
+		}
+		// Values that can't be substituted into the path as they are go through the whole join as
+		// segments.
+		if needsSeg(v) {
+			plain = false
+		}
+		values[i] = v
+	}
+	if !plain {
+		segs := make([]string, len(values))
+		for i, v := range values {
+			segs[i] = v
+			if marked(v) {
+				values[i] = v[len(segMark) : len(v)-len(segMark)]
+			} else if needsSeg(v) {
+				segs[i], values[i] = Seg(v), escapeSegment(v)
+			}
+		}
+		u, err := t.a.resolveURL(t.base, t.seg, t.policy, t.strict, t.a.migrate(substitute(t.lits, segs)))
+		return u, substitute(t.lits, values), err
+	}
+	resource := substitute(t.lits, values)
+	u := *t.base
+	u.Path = substitute(t.path, values)
+	return &u, resource, nil
+}
+
+// substitute interleaves lits with values.
+func substitute(lits, values []string) string {
+	n := 0
+	for _, s := range lits {
+		n += len(s)
+	}
+	for _, s := range values {
+		n += len(s)
+	}
+	var b strings.Builder
+	b.Grow(n)
+	b.WriteString(lits[0])
+	for i, v := range values {
+		b.WriteString(v)
+		b.WriteString(lits[i+1])
+	}
+	return b.String()
+}
+
+// Build creates the request for params and args just like Request creates it for the resource
+// with params substituted in, given the opts of the template.
+func (t *RequestTemplate) Build(params map[string]string, args url.Values) (*http.Request, error) {
+	if t.shape.err != nil {
+		return nil, t.shape.err
+	}
+	ctx := t.shape.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, _, err := t.build(ctx, params, args, nil, t.shape)
+	if err != nil {
+		return nil, err
+	}
+	for _, prepare := range t.shape.prepare {
+		if err := prepare(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// Do creates the request for params and args, executes it and decodes the JSON response into out,
+// just like DoJSON does for the resource with params substituted in, given the opts of the template.
+func (t *RequestTemplate) Do(ctx context.Context, params map[string]string, args url.Values, out interface{}) error {
+	return t.do(ctx, params, args, nil, out)
+}
+
+// DoBody is like Do, but sends body encoded as JSON, or form-encoded in order if it's a *Form,
+// like Post does, and args in the query whatever the method. If body is nil, it's just like Do.
+func (t *RequestTemplate) DoBody(ctx context.Context, params map[string]string, args url.Values, body, out interface{}) error {
+	return t.do(ctx, params, args, body, out)
+}
+
+func (t *RequestTemplate) do(ctx context.Context, params map[string]string, args url.Values, body, out interface{}) error {
+	if t.proto.err != nil {
+		return t.proto.err
+	}
+	if !t.proto.anyBody {
+		if err := t.a.validateRequestBody(t.method, t.resource, body, nil); err != nil {
+			return err
+		}
+	}
+	req, resource, err := t.build(ctx, params, args, body, t.proto)
+	if err != nil {
+		return err
+	}
+	c := *t.proto
+	c.resource, c.template = resource, t.resource
+	return t.a.decodeJSON(ctx, &c, req, out)
+}
+
+// build creates the request without applying the opts of the template, checking args
+// against the parameter declarations and rules of the template unless c skips them. A non-nil
+// body is encoded as JSON, the args going into the query.
+func (t *RequestTemplate) build(ctx context.Context, params map[string]string, args url.Values, body interface{}, c *call) (*http.Request, string, error) {
+	if err := t.a.checkParams(t.resource, args); err != nil {
+		if err = t.a.paramsErrorFor(c, t.resource, args, err); err != nil {
+			return nil, "", err
+		}
+	}
+	args, err := t.a.limitPageSize(t.resource, args)
+	if err != nil {
+		return nil, "", err
+	}
+	t.a.lintArgs(args)
+	u, resource, err := t.url(params)
+	if err != nil {
+		return nil, "", err
+	}
+	if u, err = t.a.overrideBase(ctx, u, t.base); err != nil {
+		return nil, "", err
+	}
+	form := t.method == POST && body == nil
+	if !form {
+		u.RawQuery = args.Encode()
+	}
+	req := newRequest(t.method, u)
+	req.Header = t.header.Clone()
+	if t.ctxValues {
+		applyContext(ctx, req, t.header)
+	}
+	if f, ok := body.(*Form); ok {
+		setFormBody(req, f)
+		body = nil
+	}
+	switch {
+	case body != nil:
+		if err := setLazyBody(req, func(buf *bytes.Buffer) error {
+			return encodeJSON(buf, body)
+		}); err != nil {
+			return nil, "", err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	case form:
+		setFormValuesBody(req, args)
+	}
+	if err := t.a.encodeBody(req); err != nil {
+		return nil, "", err
+	}
+	for _, e := range t.chain {
+		if err := e.p.Prepare(ctx, req); err != nil {
+			return nil, "", &PreparerError{Name: e.name, Priority: e.priority, Err: err}
+		}
+	}
+	return req, resource, nil
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateMatchesRequest(t *testing.T) {
+	for _, tc := range []struct {
+		base     string
+		version  Version
+		method   Method
+		resource string
+		params   map[string]string
+		want     string
+	}{
+		{"http://example.com/api/", VersionInPath("v2"), GET, "/users/{id}/orders", map[string]string{"id": "42"}, "/api/v2/users/42/orders"},
+		{"http://example.com", Version{}, POST, "users/{id}/files/{name}.json", map[string]string{"id": "7", "name": "a b?"}, "/users/7/files/a b?.json"},
+		{"http://example.com/api", VersionInHeader("X-Api-Version", "2023-10-01"), DELETE, "/users/{id}", map[string]string{"id": "a/b"}, "/api/users/a/b"},
+		{"http://example.com/api", Version{}, GET, "/users/{id}/orders", map[string]string{"id": ".."}, "/api/users/../orders"},
+		{"http://example.com/api", VersionInPath("v2"), GET, "/{version}/users", map[string]string{"version": "v2"}, "/api/v2/users"},
+		{"http://example.com/api", Version{}, PUT, "/users/{id}/../x", map[string]string{"id": ".."}, "/api/users/x"},
+		{"http://example.com/a%2Fb", Version{}, PATCH, "/users/{id}", map[string]string{"id": "1"}, "/a/b/users/1"},
+		{"http://example.com", Version{}, HEAD, "/ping", nil, "/ping"},
+	} {
+		a := MustNew(tc.base)
+		a.Header = http.Header{"Authorization": {"Bearer t0k"}, "X-Client": {"test"}}
+		a.SetVersion(tc.version)
+		a.AddPreparer(PreparerFunc(func(_ context.Context, req *http.Request) error {
+			req.Header.Set("X-Prepared", req.URL.Path)
+			return nil
+		}), 0)
+		args := url.Values{"b": {"2"}, "a": {"1"}}
+		opts := []Option{WithHeader("X-Trace", "1")}
+
+		tpl := a.Template(tc.method, tc.resource, opts...)
+		got, err := tpl.Build(tc.params, args)
+		if !assert.NoError(t, err, tc.resource) {
+			continue
+		}
+		resource := tc.resource
+		for k, v := range tc.params {
+			resource = replaceParam(resource, k, v)
+		}
+		want, err := a.Request(tc.method, resource, args, opts...)
+		if !assert.NoError(t, err, tc.resource) {
+			continue
+		}
+		assert.Equal(t, tc.want, got.URL.Path, tc.resource)
+		assert.Equal(t, want.Method, got.Method, tc.resource)
+		assert.Equal(t, want.URL, got.URL, tc.resource)
+		assert.Equal(t, want.URL.String(), got.URL.String(), tc.resource)
+		assert.Equal(t, want.Host, got.Host, tc.resource)
+		assert.Equal(t, want.Header, got.Header, tc.resource)
+		assert.Equal(t, want.ContentLength, got.ContentLength, tc.resource)
+		if want.Body != nil {
+			wb, _ := io.ReadAll(want.Body)
+			gb, _ := io.ReadAll(got.Body)
+			assert.Equal(t, string(wb), string(gb), tc.resource)
+		}
+	}
+}
+
+// replaceParam substitutes the value of the named parameter of resource, as a Seg if it isn't a
+// segment on its own.
+func replaceParam(resource, name, value string) string {
+	lits, names := splitParams(resource)
+	values := make([]string, len(names))
+	for i, n := range names {
+		values[i] = "{" + n + "}"
+		if n == name {
+			values[i] = value
+			if needsSeg(value) {
+				values[i] = Seg(value)
+			}
+		}
+	}
+	return substitute(lits, values)
+}
+
+func TestTemplateErrors(t *testing.T) {
+	a := MustNew("http://example.com")
+	_, err := a.Template(GET, "/users/{id}").Build(nil, nil)
+	assert.EqualError(t, err, `api: missing template parameter "id"`)
+
+	_, err = a.Template(Method(42), "/users").Build(nil, nil)
+	assert.EqualError(t, err, "api: unknown method: 42")
+	assert.EqualError(t, a.Template(Method(42), "/users").Do(context.Background(), nil, nil, nil), "api: unknown method: 42")
+
+	// The path policy is the one of the Api when the template was created; the parameters are
+	// segments, which never climb.
+	tpl := a.Template(GET, "/users/{id}/../../..")
+	a.SetPathPolicy(RejectTraversal)
+	_, err = tpl.Build(map[string]string{"id": "1"}, nil)
+	assert.NoError(t, err)
+	_, err = a.Template(GET, "/users/{id}/../../..").Build(map[string]string{"id": "1"}, nil)
+	assert.Equal(t, ErrPathTraversal, err)
+	req, err := a.Template(GET, "/users/{id}").Build(map[string]string{"id": "../.."}, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/users/..%2F..", req.URL.EscapedPath())
+	}
+}
+
+func TestTemplateDo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"path":"` + r.URL.Path + `","auth":"` + r.Header.Get("Authorization") + `","client":"` + r.Header.Get("X-Client") + `"}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Header = http.Header{"X-Client": {"v1"}}
+	ts := &staticToken{token: "one"}
+	a.SetTokenSource(ts)
+	tpl := a.Template(GET, "/users/{id}")
+
+	// The Header is frozen, the token isn't.
+	a.Header = http.Header{"X-Client": {"v2"}}
+	ts.set("two")
+	var out struct{ Path, Auth, Client string }
+	if !assert.NoError(t, tpl.Do(context.Background(), map[string]string{"id": "42"}, nil, &out)) {
+		return
+	}
+	assert.Equal(t, "/users/42", out.Path)
+	assert.Equal(t, "Bearer two", out.Auth)
+	assert.Equal(t, "v1", out.Client)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			var out struct{ Path string }
+			assert.NoError(t, tpl.Do(context.Background(), map[string]string{"id": id}, nil, &out))
+			assert.Equal(t, "/users/"+id, out.Path)
+		}(string(rune('a' + i)))
+	}
+	wg.Wait()
+}
+
+// staticToken is a TokenSource returning its current token.
+type staticToken struct {
+	mu    sync.Mutex
+	token string
+}
+
+func (s *staticToken) set(token string) {
+	s.mu.Lock()
+	s.token = token
+	s.mu.Unlock()
+}
+
+func (s *staticToken) Token(context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Token{AccessToken: s.token}, nil
+}
+
+func benchmarkApi() *Api {
+	a := MustNew("http://example.com/api/")
+	a.Header = http.Header{"Authorization": {"Bearer t0k"}, "X-Client": {"bench"}}
+	a.SetVersion(VersionInPath("v2"))
+	a.Client = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(`{"id":42}`)), Request: req}, nil
+	})}
+	return a
+}
+
+func BenchmarkTemplateBuild(b *testing.B) {
+	a := benchmarkApi()
+	tpl := a.Template(GET, "/users/{id}/orders", WithHeader("X-Trace", "1"))
+	params := map[string]string{"id": "42"}
+	args := url.Values{"status": {"open"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tpl.Build(params, args)
+	}
+}
+
+func BenchmarkTemplateBuildRequest(b *testing.B) {
+	a := benchmarkApi()
+	args := url.Values{"status": {"open"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		a.Request(GET, "/users/"+"42"+"/orders", args, WithHeader("X-Trace", "1"))
+	}
+}
+
+func BenchmarkTemplateDo(b *testing.B) {
+	a := benchmarkApi()
+	tpl := a.Template(GET, "/users/{id}/orders", WithHeader("X-Trace", "1"))
+	params := map[string]string{"id": "42"}
+	args := url.Values{"status": {"open"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var out struct{ ID int }
+		if err := tpl.Do(context.Background(), params, args, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTemplateDoJSON(b *testing.B) {
+	a := benchmarkApi()
+	args := url.Values{"status": {"open"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var out struct{ ID int }
+		if err := a.DoJSON(context.Background(), GET, "/users/"+"42"+"/orders", args, &out, WithHeader("X-Trace", "1")); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTemplateDoBody(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = r.Method + " " + r.URL.RequestURI() + " " + r.Header.Get("Content-Type") + " " + string(body)
+		w.Write([]byte(`{"id":"7"}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	users := a.Template(POST, "/orgs/{org}/users")
+	var out struct{ ID string }
+	err := users.DoBody(context.Background(), map[string]string{"org": "acme"}, url.Values{"notify": {"1"}}, map[string]string{"name": "bob"}, &out)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "7", out.ID)
+	assert.Equal(t, `POST /orgs/acme/users?notify=1 application/json {"name":"bob"}`, got)
+
+	// Without a body, the args of a POST are still sent as a form.
+	assert.NoError(t, users.DoBody(context.Background(), map[string]string{"org": "acme"}, url.Values{"notify": {"1"}}, nil, nil))
+	assert.Equal(t, "POST /orgs/acme/users application/x-www-form-urlencoded notify=1", got)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+)
+
+// TenantPlaceholder is the segment of the base URI path replaced by the tenant id in ForTenant.
+const TenantPlaceholder = "{tenant}"
+
+// ForTenant derives a lightweight Api for the tenant id, e.g. one per customer of a multi-tenant
+// service. Its base URI is the current one of a with the TenantPlaceholder of its path replaced
+// by the escaped id, or with the id appended as a last segment if there's no placeholder.
+// The opts are applied to every call of the derived Api after the defaults of a, so they can
+// set the tenant's header or credentials, e.g. WithHeader("Authorization", "Bearer "+token);
+// the tenant's TokenSource is set on the derived Api with SetTokenSource.
+//
+// The derived Api shares the heavy resources of a: the Client and so its transport and connection
+// pool, the Retry policy, the MaxRequestBytes limit, the TokenSource and the AuthForPrefix rules
+// until they're replaced, the target policy, the TLS audit, the client certificate, the header
+// order, the raw headers, the 100 Continue timeout, the load shedding state, the schedule and its
+// rate limits, the serialization keys, the adaptive timeout estimator, the body codec and
+// transforms, the deadline header, the connection counters of PoolStats, the state of SetHints, the
+// hosts of SetHosts and their health, the hosts of AllowBaseURLs, the caches of SetStaleIfError and
+// SetCache, the error classification, mapping, taxonomy and status tunnel, the logger, the
+// redactor, the journal, the capture rules and sink, the attribution policy, the feature flag
+// provider and bindings, the idempotency policy, the request compression and its host states, the
+// compression dictionary, the list pacing, the robots.txt and delays of SetPoliteness, the
+// migrations, the capabilities of SetCapabilities, the resource of Discover, whose capabilities it
+// fetches on its own, and their policy, the policy of SetAsyncPolicy, the conditional writes style,
+// the parameter declarations, rules and page limits, the redacted fields, the strict content types
+// and paths, the query lint and normalization, the fields style, the clock, the validators of the
+// responses and the request bodies, the golden schemas and the shared options of its Registry.
+// It gets copies of the Header, the defaults, the preparers, the locale and the version, so changing
+// them on either Api doesn't affect the other, and it tracks its own calls for Shutdown and its
+// own background goroutines for Close, and keeps its own results of Memoize, its own tokens
+// of SetConsistency and its own queue of DoAsync.
+// The configuration of a is taken when ForTenant is called; later changes aren't picked up.
+// The calls of the derived Api are logged with the tenant id, see CallLog.Tenant.
+func (a *Api) ForTenant(id string, opts ...Option) *Api {
+	base := tenantBase(a.baseURI(), id)
+	t := &Api{BaseURI: base, Header: a.Header.Clone(), Client: a.Client, Retry: a.Retry, MaxRequestBytes: a.MaxRequestBytes, tenant: id, registry: a.registry}
+	if e := a.env.Load(); e != nil {
+		t.env.Store(&env{name: e.name, base: base})
+	}
+	t.pathPolicy.Store(a.pathPolicy.Load())
+	t.strictPaths.Store(a.strictPaths.Load())
+	t.target.Store(a.target.Load())
+	t.cert.Store(a.cert.Load())
+	t.hosts.Store(a.hosts.Load())
+	t.baseAllow.Store(a.baseAllow.Load())
+	t.fieldsStyle.Store(a.fieldsStyle.Load())
+	t.order.Store(a.order.Load())
+	t.expect.Store(a.expect.Load())
+	t.shed.Store(a.shed.Load())
+	t.schedule.Store(a.schedule.Load())
+	t.flags.Store(a.flags.Load())
+	t.idempotency.Store(a.idempotency.Load())
+	t.compression.Store(a.compression.Load())
+	t.dictionary.Store(a.dictionary.Load())
+	t.consistency.Store(a.consistency.Load().fork())
+	t.listPacing.Store(a.listPacing.Load())
+	t.politeness.Store(a.politeness.Load())
+	t.serial.Store(a.fences())
+	t.captures.Store(a.captures.get(newCaptureSet))
+	t.deadline.Store(a.deadline.Load())
+	t.pool.Store(a.poolStats())
+	t.querySort.Store(a.querySort.Load())
+	t.hints.Store(a.hints.Load())
+	t.attribution.Store(a.attribution.Load())
+	t.conditions.Store(a.conditions.Load())
+	t.tlsAudit.Store(a.tlsAudit.Load())
+	t.transforms.Store(a.transforms.Load())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t.locale = a.locale
+	t.version = a.version
+	t.onDeprecation = a.onDeprecation
+	t.classifier = a.classifier
+	t.codes = a.codes
+	t.tunnel = a.tunnel
+	t.logger = a.logger
+	t.clk = a.clk
+	t.defaults = append(append([]Option(nil), a.defaults...), opts...)
+	t.stale = a.stale
+	t.cache = a.cache
+	t.redactor = a.redactor
+	t.journal = a.journal
+	t.params = a.params
+	t.redactions = a.redactions
+	t.pageLimits = a.pageLimits
+	t.queryLint = a.queryLint
+	t.rawHeaders = a.rawHeaders
+	t.memoLimit = a.memoLimit
+	t.estimator = a.estimator
+	t.codec = a.codec
+	t.soap = a.soap
+	t.validation = a.validation
+	t.validateBody = a.validateBody
+	t.drift = a.drift
+	t.tokens = a.tokens
+	t.rotation = a.rotation
+	t.migrations.Store(a.migrations.Load())
+	t.discovery.Store(a.discovery.Load().forTenant())
+	t.discoveryPolicy.Store(a.discoveryPolicy.Load())
+	t.asyncPolicy.Store(a.asyncPolicy.Load())
+	t.authRules = a.authRules
+	t.strictTypes = a.strictTypes
+	t.taxonomy = a.taxonomy
+	if a.chain != nil {
+		// The built-in preparers are bound to their Api, so they're replaced by those of t.
+		builtins := make(map[string]preparerEntry)
+		for _, e := range t.builtinPreparers() {
+			builtins[e.name] = e
+		}
+		t.chain = make([]preparerEntry, len(a.chain))
+		for i, e := range a.chain {
+			if e.builtin {
+				e = builtins[e.name]
+			}
+			t.chain[i] = e
+		}
+	}
+	return t
+}
+
+// Tenant returns the tenant id of an Api derived by ForTenant, or an empty string.
+func (a *Api) Tenant() string {
+	return a.tenant
+}
+
+// tenantBase returns base with the tenant placeholder of its path replaced by id, or id appended.
+func tenantBase(base *url.URL, id string) *url.URL {
+	u := *base
+	before, after, ok := strings.Cut(u.Path, TenantPlaceholder)
+	if !ok {
+		before, after = strings.TrimSuffix(u.Path, "/")+"/", ""
+	}
+	u.Path = before + id + after
+	u.RawPath = (&url.URL{Path: before}).EscapedPath() + url.PathEscape(id) + (&url.URL{Path: after}).EscapedPath()
+	return &u
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForTenantShared(t *testing.T) {
+	a := MustNew("https://api.example.com/t/{tenant}/v1")
+	tr := &http.Transport{}
+	a.Client = &http.Client{Transport: tr}
+	a.Header = http.Header{"Accept": {"application/json"}}
+	a.SetStaleIfError(time.Minute)
+
+	tenants := make([]*Api, 1000)
+	for i := range tenants {
+		tenants[i] = a.ForTenant(fmt.Sprintf("tenant %d", i))
+	}
+	for i, tn := range tenants {
+		if !assert.True(t, tn.client().Transport == tr) || !assert.True(t, tn.stale == a.stale) {
+			return
+		}
+		req, err := tn.Request(GET, "/items", nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, fmt.Sprintf("https://api.example.com/t/tenant%%20%d/v1/items", i), req.URL.String())
+		assert.Equal(t, fmt.Sprintf("tenant %d", i), tn.Tenant())
+	}
+	assert.Equal(t, "", a.Tenant())
+
+	b := MustNew("https://api.example.com/v1/")
+	req, _ := b.ForTenant("acme").Request(GET, "/items", nil)
+	assert.Equal(t, "https://api.example.com/v1/acme/items", req.URL.String())
+}
+
+func TestForTenantIsolation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		fmt.Fprintf(w, "%q", parts[1]+" "+r.Header.Get("Authorization")+" "+r.Header.Get("X-Tenant")+" "+r.Header.Get("Accept"))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Header = http.Header{"Accept": {"application/json"}}
+	h := &recordHandler{}
+	a.SetLogger(slog.New(h))
+
+	acme := a.ForTenant("acme", WithHeader("Authorization", "Bearer acme-token"))
+	acme.Header.Set("X-Tenant", "acme")
+	globex := a.ForTenant("globex", WithHeader("Authorization", "Bearer globex-token"))
+	globex.Header.Set("X-Tenant", "globex")
+	globex.AddPreparer(PreparerFunc(func(ctx context.Context, req *http.Request) error {
+		req.Header.Set("Accept", "text/plain")
+		return nil
+	}), 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, tn := range []*Api{acme, globex} {
+			wg.Add(1)
+			go func(tn *Api) {
+				defer wg.Done()
+				var got string
+				if assert.NoError(t, tn.Get(context.Background(), "/items", nil, &got)) {
+					want := "acme Bearer acme-token acme application/json"
+					if tn == globex {
+						want = "globex Bearer globex-token globex text/plain"
+					}
+					assert.Equal(t, want, got)
+				}
+			}(tn)
+		}
+	}
+	wg.Wait()
+
+	// The parent isn't affected by its tenants.
+	var got string
+	assert.NoError(t, a.Get(context.Background(), "/acme", nil, &got))
+	assert.Equal(t, "acme   application/json", got)
+	assert.Equal(t, http.Header{"Accept": {"application/json"}}, a.Header)
+
+	tenants := make(map[string]int)
+	h.mu.Lock()
+	for _, r := range h.records {
+		tenants[recordAttrs(r)["tenant"]]++
+	}
+	h.mu.Unlock()
+	assert.Equal(t, map[string]int{"acme": 50, "globex": 50, "": 1}, tenants)
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Phase is the stage a call had reached when it timed out or was canceled.
+type Phase int
+
+const (
+	// PhaseConnecting is before a connection was obtained: dialing, the TLS handshake or waiting for a pooled connection.
+	PhaseConnecting Phase = iota
+	// PhaseWaitingHeaders is after the connection was obtained and before the response headers arrived.
+	PhaseWaitingHeaders
+	// PhaseReadingBody is after the response headers arrived.
+	PhaseReadingBody
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseConnecting:
+		return "connecting"
+	case PhaseWaitingHeaders:
+		return "waiting for headers"
+	case PhaseReadingBody:
+		return "reading body"
+	default:
+		return fmt.Sprintf("Phase(%d)", int(p))
+	}
+}
+
+// TimeoutError is returned by Do and the Do-style helpers, and by reads of the response body,
+// when a call times out or is canceled. It wraps the original error, so
+// errors.Is(err, context.DeadlineExceeded) keeps working, and errors.Is matches its Cause too.
+type TimeoutError struct {
+	Method string
+	// Resource is the resource as given to the helper, or the URL path for Do.
+	Resource string
+	Phase    Phase
+	// Elapsed is the time since the request was sent.
+	Elapsed time.Duration
+	// Timeout is the time the call was given by the context deadline or the client's Timeout,
+	// whichever is shorter, or zero if none was set.
+	Timeout time.Duration
+	Err     error
+	// Cause is the cause the context of the call was canceled with, as returned by context.Cause,
+	// e.g. ErrShutdown or the one given to the CancelCauseFunc of context.WithCancelCause.
+	// It's nil if the context was canceled without a cause or the call timed out.
+	Cause error
+	// ctxErr is the error of the canceled context, which the transport replaces by the cause.
+	ctxErr error
+}
+
+func (e *TimeoutError) Error() string {
+	what := "canceled"
+	if isTimeout(e.Err) {
+		what = "timed out"
+	}
+	s := fmt.Sprintf("api: %s %s %s after %s while %s", e.Method, e.Resource, what, e.Elapsed.Round(time.Millisecond), e.Phase)
+	if e.Timeout > 0 {
+		s += fmt.Sprintf(" (timeout %s)", e.Timeout.Round(time.Millisecond))
+	}
+	s += ": " + e.Err.Error()
+	if e.Cause != nil && !errors.Is(e.Err, e.Cause) {
+		s += " (" + e.Cause.Error() + ")"
+	}
+	return s
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// Is makes errors.Is(err, target) report true if target matches the cause, or the error
+// of the canceled context, e.g. context.Canceled.
+func (e *TimeoutError) Is(target error) bool {
+	return e.Cause != nil && (errors.Is(e.Cause, target) || target == e.ctxErr)
+}
+
+// withCause returns err, a failure caused by ctx being done, annotated with the cause ctx was
+// canceled with if it's not the error of ctx itself, so that errors.Is matches both.
+func withCause(ctx context.Context, err error) error {
+	if cause := causeOf(ctx); cause != nil && !errors.Is(err, cause) {
+		return fmt.Errorf("%w (%w)", err, cause)
+	}
+	return err
+}
+
+// causeOf returns the cause ctx was canceled with, nil if it's not done or has no explicit cause.
+func causeOf(ctx context.Context) error {
+	cause := context.Cause(ctx)
+	if cause == ctx.Err() {
+		return nil
+	}
+	return cause
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout()
+}
+
+// callTimer tracks the phase of a call to turn its timeouts into *TimeoutError.
+type callTimer struct {
+	ctx      context.Context
+	method   string
+	resource string
+	clk      Clock
+	sent     time.Time
+	timeout  time.Duration
+	phase    atomic.Int32
+}
+
+func newCallTimer(ctx context.Context, client *http.Client, req *http.Request, resource string, clk Clock) *callTimer {
+	if resource == "" {
+		resource = req.URL.Path
+	}
+	t := &callTimer{ctx: ctx, method: req.Method, resource: resource, clk: clk, sent: clk.Now()}
+	if deadline, ok := ctx.Deadline(); ok {
+		t.timeout = deadline.Sub(t.sent)
+	}
+	if client.Timeout > 0 && (t.timeout == 0 || client.Timeout < t.timeout) {
+		t.timeout = client.Timeout
+	}
+	return t
+}
+
+func (t *callTimer) enter(p Phase) {
+	t.phase.Store(int32(p))
+}
+
+// wrap turns timeout and cancellation errors into *TimeoutError.
+func (t *callTimer) wrap(err error) error {
+	cause := causeOf(t.ctx)
+	if err == nil || !isTimeout(err) && !errors.Is(err, context.Canceled) && (cause == nil || !errors.Is(err, cause)) {
+		return err
+	}
+	var te *TimeoutError
+	if errors.As(err, &te) {
+		return err
+	}
+	return &TimeoutError{
+		Method:   t.method,
+		Resource: t.resource,
+		Phase:    Phase(t.phase.Load()),
+		Elapsed:  t.clk.Now().Sub(t.sent),
+		Timeout:  t.timeout,
+		Err:      err,
+		Cause:    cause,
+		ctxErr:   t.ctx.Err(),
+	}
+}
+
+// timeoutBody reports timeouts while reading the response body as *TimeoutError.
+type timeoutBody struct {
+	io.ReadCloser
+	t *callTimer
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = b.t.wrap(err)
+	}
+	return n, err
+}
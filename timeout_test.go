@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestTimeoutErrorPhases(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/body" {
+			io.WriteString(w, `{"items": [`)
+			w.(http.Flusher).Flush()
+		}
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	stalled := MustNew("http://example.com")
+	stalled.Client = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}}
+	a := MustNew(srv.URL)
+
+	for _, tc := range []struct {
+		a        *Api
+		resource string
+		phase    Phase
+	}{
+		{stalled, "/items", PhaseConnecting},
+		{a, "/headers", PhaseWaitingHeaders},
+		{a, "/body", PhaseReadingBody},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		var out interface{}
+		err := tc.a.DoJSON(ctx, GET, tc.resource, nil, &out)
+		cancel()
+
+		var te *TimeoutError
+		if !assert.ErrorAs(t, err, &te, tc.resource) {
+			continue
+		}
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), tc.resource)
+		assert.Equal(t, tc.phase, te.Phase, tc.resource)
+		assert.Equal(t, "GET", te.Method)
+		assert.Equal(t, tc.resource, te.Resource)
+		assert.True(t, te.Elapsed >= 40*time.Millisecond, te.Elapsed)
+		assert.InDelta(t, 50*time.Millisecond, te.Timeout, float64(10*time.Millisecond))
+		assert.Contains(t, err.Error(), "api: GET "+tc.resource+" timed out after ")
+		assert.Contains(t, err.Error(), "while "+tc.phase.String())
+		assert.True(t, IsTemporary(err), tc.resource)
+	}
+}
+
+func TestTimeoutErrorCanceled(t *testing.T) {
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	a := MustNew(srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-arrived
+		cancel()
+	}()
+	req, _ := a.Request(GET, "/items/1", nil)
+	_, err := a.Do(ctx, req)
+	var te *TimeoutError
+	if assert.ErrorAs(t, err, &te) {
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, PhaseWaitingHeaders, te.Phase)
+		assert.Equal(t, "/items/1", te.Resource)
+		assert.Equal(t, time.Duration(0), te.Timeout)
+		assert.Contains(t, err.Error(), "api: GET /items/1 canceled after ")
+	}
+}
+
+func TestTimeoutErrorCause(t *testing.T) {
+	errBudget := errors.New("budget exceeded")
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		close(arrived)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	a := MustNew(srv.URL)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go func() {
+		<-arrived
+		cancel(errBudget)
+	}()
+	req, _ := a.Request(GET, "/items/1", nil)
+	_, err := a.Do(ctx, req)
+	var te *TimeoutError
+	if assert.ErrorAs(t, err, &te) {
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, errBudget)
+		assert.Equal(t, errBudget, te.Cause)
+		assert.Contains(t, err.Error(), "api: GET /items/1 canceled after ")
+		assert.Contains(t, err.Error(), "budget exceeded")
+	}
+
+	// The cause survives a cancellation while waiting to retry.
+	clk := clock.NewFake(time.Now())
+	a.SetClock(clk)
+	a.Retry = &RetryPolicy{MaxRetries: 3}
+	ctx, cancel = context.WithCancelCause(context.Background())
+	go func() {
+		clk.BlockUntil(1)
+		cancel(errBudget)
+	}()
+	err = a.Get(ctx, "/busy", nil, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, errBudget)
+}
+
+func TestTimeoutErrorClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	a := MustNew(srv.URL)
+	a.Client = &http.Client{Timeout: 30 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	err := a.Get(ctx, "/slow", nil, nil)
+	var te *TimeoutError
+	if assert.ErrorAs(t, err, &te) {
+		assert.Equal(t, 30*time.Millisecond, te.Timeout)
+		assert.Equal(t, PhaseWaitingHeaders, te.Phase)
+	}
+}
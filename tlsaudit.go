@@ -0,0 +1,179 @@
+package api
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTLSAudit is matched by every *TLSAuditError.
+var ErrTLSAudit = errors.New("api: tls audit failed")
+
+// TLSInfo is what was negotiated on the TLS connection of a response, see ResponseMeta.TLS.
+type TLSInfo struct {
+	// Version is the TLS version, e.g. tls.VersionTLS13, and CipherSuite the cipher suite.
+	Version     uint16
+	CipherSuite uint16
+	// NegotiatedProtocol is the protocol agreed with ALPN, e.g. "h2", empty if none was.
+	NegotiatedProtocol string
+	ServerName         string
+	// Verified reports whether the certificate chain was verified against the roots of the
+	// tls.Config of the transport, e.g. the pinned CA, which isn't the case with
+	// InsecureSkipVerify.
+	Verified bool
+	// Subject is the subject of the leaf certificate, and NotBefore and NotAfter its validity.
+	Subject   string
+	NotBefore time.Time
+	NotAfter  time.Time
+	// ChainNotAfter is the earliest expiry of the certificates presented by the server.
+	ChainNotAfter time.Time
+}
+
+// VersionName returns the name of the TLS version, e.g. "TLS 1.3".
+func (i *TLSInfo) VersionName() string {
+	return tls.VersionName(i.Version)
+}
+
+// CipherSuiteName returns the name of the cipher suite, e.g. "TLS_AES_128_GCM_SHA256".
+func (i *TLSInfo) CipherSuiteName() string {
+	return tls.CipherSuiteName(i.CipherSuite)
+}
+
+// newTLSInfo returns the TLSInfo of state, nil if the connection isn't a TLS one.
+func newTLSInfo(state *tls.ConnectionState) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+	info := &TLSInfo{
+		Version:            state.Version,
+		CipherSuite:        state.CipherSuite,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ServerName:         state.ServerName,
+		Verified:           len(state.VerifiedChains) > 0,
+	}
+	for i, cert := range state.PeerCertificates {
+		if i == 0 {
+			info.Subject, info.NotBefore, info.NotAfter = cert.Subject.String(), cert.NotBefore, cert.NotAfter
+		}
+		if info.ChainNotAfter.IsZero() || cert.NotAfter.Before(info.ChainNotAfter) {
+			info.ChainNotAfter = cert.NotAfter
+		}
+	}
+	return info
+}
+
+// TLSIssueKind is what a TLSIssue is about.
+type TLSIssueKind int
+
+const (
+	// TLSExpiring is a leaf certificate expiring within TLSAuditPolicy.ExpiryWarning, or expired.
+	TLSExpiring TLSIssueKind = iota + 1
+	// TLSVersionTooLow is a TLS version below TLSAuditPolicy.MinVersion.
+	TLSVersionTooLow
+)
+
+func (k TLSIssueKind) String() string {
+	switch k {
+	case TLSExpiring:
+		return "expiring certificate"
+	case TLSVersionTooLow:
+		return "tls version too low"
+	default:
+		return "unknown"
+	}
+}
+
+// TLSIssue is a connection failing the audit of SetTLSAudit.
+type TLSIssue struct {
+	Kind TLSIssueKind
+	// Host is the host of the request URL.
+	Host string
+	Info *TLSInfo
+	// ExpiresIn is the time left until the leaf certificate expires, negative once it expired.
+	ExpiresIn time.Duration
+}
+
+func (i TLSIssue) String() string {
+	switch i.Kind {
+	case TLSExpiring:
+		return fmt.Sprintf("%s: %s of %s expires in %s", i.Host, i.Kind, i.Info.Subject, i.ExpiresIn)
+	default:
+		return fmt.Sprintf("%s: %s: %s", i.Host, i.Kind, i.Info.VersionName())
+	}
+}
+
+// TLSAuditError is returned in TLSAuditPolicy.Fail mode for the calls over a connection failing
+// the audit. It's matched by ErrTLSAudit.
+type TLSAuditError struct {
+	Issue TLSIssue
+}
+
+func (e *TLSAuditError) Error() string {
+	return "api: tls audit: " + e.Issue.String()
+}
+
+// Is makes errors.Is(err, ErrTLSAudit) report true.
+func (e *TLSAuditError) Is(target error) bool {
+	return target == ErrTLSAudit
+}
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *TLSAuditError) Class() Class { return Permanent }
+
+// TLSAuditPolicy configures SetTLSAudit.
+type TLSAuditPolicy struct {
+	// ExpiryWarning is how long before the expiry of the leaf certificate of a connection it's
+	// reported, e.g. 30 days. Zero disables the check.
+	ExpiryWarning time.Duration
+	// MinVersion is the lowest TLS version accepted, e.g. tls.VersionTLS12. Zero disables the check.
+	MinVersion uint16
+	// OnIssue is called with every issue of every new connection.
+	OnIssue func(issue TLSIssue)
+	// Fail makes the calls over a connection with an issue fail with a *TLSAuditError, checking
+	// the connections reused too. The response is discarded.
+	Fail bool
+}
+
+// SetTLSAudit makes the Api audit the TLS connections of its calls: the new connections whose
+// leaf certificate expires within p.ExpiryWarning, or whose version is below p.MinVersion,
+// are reported to p.OnIssue, and the calls over them fail in p.Fail mode. What was negotiated
+// is reported for every call in ResponseMeta.TLS, audited or not. A nil p disables the audit.
+func (a *Api) SetTLSAudit(p *TLSAuditPolicy) {
+	if p == nil {
+		a.tlsAudit.Store(nil)
+		return
+	}
+	policy := *p
+	a.tlsAudit.Store(&policy)
+}
+
+// check audits the connection to host the response was received over, reporting its issues if it
+// wasn't reused, and returns the error failing the call.
+func (p *TLSAuditPolicy) check(host string, state *tls.ConnectionState, reused bool, now time.Time) error {
+	if !p.Fail && (reused || p.OnIssue == nil) {
+		return nil
+	}
+	info := newTLSInfo(state)
+	var issues []TLSIssue
+	if p.ExpiryWarning > 0 && !info.NotAfter.IsZero() {
+		if left := info.NotAfter.Sub(now); left < p.ExpiryWarning {
+			issues = append(issues, TLSIssue{Kind: TLSExpiring, Host: host, Info: info, ExpiresIn: left})
+		}
+	}
+	if p.MinVersion != 0 && info.Version < p.MinVersion {
+		issues = append(issues, TLSIssue{Kind: TLSVersionTooLow, Host: host, Info: info})
+	}
+	if len(issues) == 0 {
+		return nil
+	}
+	if !reused && p.OnIssue != nil {
+		for _, issue := range issues {
+			p.OnIssue(issue)
+		}
+	}
+	if p.Fail {
+		return &TLSAuditError{Issue: issues[0]}
+	}
+	return nil
+}
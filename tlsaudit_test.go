@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// shortLivedServer starts a TLS server with a self-signed certificate expiring in 48h, and returns
+// it with a client trusting only that certificate.
+func shortLivedServer(t *testing.T) (*httptest.Server, *http.Client) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "vendor.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(48 * time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cert, _ := x509.ParseCertificate(der)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	srv.StartTLS()
+	pinned := x509.NewCertPool()
+	pinned.AddCert(cert)
+	return srv, &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pinned, NextProtos: []string{"http/1.1"}}}}
+}
+
+func TestTLSInfo(t *testing.T) {
+	srv, client := shortLivedServer(t)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Client = client
+
+	var meta ResponseMeta
+	if !assert.NoError(t, a.DoJSON(context.Background(), GET, "/", nil, nil, WithMeta(&meta))) || !assert.NotNil(t, meta.TLS) {
+		return
+	}
+	info := meta.TLS
+	assert.Equal(t, uint16(tls.VersionTLS13), info.Version)
+	assert.Equal(t, "TLS 1.3", info.VersionName())
+	assert.NotEmpty(t, info.CipherSuiteName())
+	assert.Equal(t, "http/1.1", info.NegotiatedProtocol)
+	assert.True(t, info.Verified)
+	assert.Equal(t, "CN=vendor.test", info.Subject)
+	assert.WithinDuration(t, time.Now().Add(48*time.Hour), info.NotAfter, time.Minute)
+	assert.Equal(t, info.NotAfter, info.ChainNotAfter)
+
+	// Without verification, the chain isn't.
+	a.Client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/", nil, nil, WithMeta(&meta)))
+	assert.False(t, meta.TLS.Verified)
+}
+
+func TestTLSAudit(t *testing.T) {
+	srv, client := shortLivedServer(t)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Client = client
+	var mu sync.Mutex
+	var issues []TLSIssue
+	a.SetTLSAudit(&TLSAuditPolicy{ExpiryWarning: 7 * 24 * time.Hour, OnIssue: func(issue TLSIssue) {
+		mu.Lock()
+		issues = append(issues, issue)
+		mu.Unlock()
+	}})
+	ctx := context.Background()
+
+	// The issue is reported once per connection, without failing the calls.
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil))
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil))
+	if assert.Len(t, issues, 1) {
+		assert.Equal(t, TLSExpiring, issues[0].Kind)
+		assert.Equal(t, srv.Listener.Addr().String(), issues[0].Host)
+		assert.InDelta(t, float64(48*time.Hour), float64(issues[0].ExpiresIn), float64(time.Minute))
+	}
+
+	// The version floor fails the calls, reused connections included.
+	a.SetTLSAudit(&TLSAuditPolicy{MinVersion: tls.VersionTLS13 + 1, Fail: true})
+	err := a.DoJSON(ctx, GET, "/", nil, nil)
+	var ae *TLSAuditError
+	if assert.True(t, errors.As(err, &ae), "%v", err) {
+		assert.Equal(t, TLSVersionTooLow, ae.Issue.Kind)
+	}
+	assert.True(t, errors.Is(err, ErrTLSAudit))
+	assert.False(t, IsRetryable(err))
+
+	a.SetTLSAudit(&TLSAuditPolicy{ExpiryWarning: time.Hour, MinVersion: tls.VersionTLS12, Fail: true})
+	assert.NoError(t, a.DoJSON(ctx, GET, "/", nil, nil))
+	assert.Len(t, issues, 1)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Token is an access token obtained from a TokenSource.
+type Token struct {
+	AccessToken string
+	// TokenType is the scheme of the Authorization header, "Bearer" if empty.
+	TokenType string
+	// Expiry is when the token expires, zero if it doesn't.
+	Expiry time.Time
+}
+
+// TokenSource provides the access tokens of an Api, see SetTokenSource and ClientCredentials.
+// It's called for every request, so it must cache its tokens and be safe for concurrent use.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// PriorityToken is the priority of the preparer setting the Authorization header from the TokenSource.
+const PriorityToken = -50
+
+// SetTokenSource makes every request created by the Api carry a token of ts in its Authorization
+// header, replacing the one of the Api's Header. A failure to get a token fails the request creation
+// with a *PreparerError. A nil ts removes the token source. See AuthForPrefix for the resources
+// needing other credentials, and RotateCredentials for replacing it without downtime.
+func (a *Api) SetTokenSource(ts TokenSource) {
+	a.mu.Lock()
+	a.tokens = ts
+	a.rotation = nil
+	a.mu.Unlock()
+}
+
+func (a *Api) prepareToken(ctx context.Context, req *http.Request) error {
+	a.mu.Lock()
+	ts := a.tokenSourceFor(req)
+	a.mu.Unlock()
+	if ts == nil {
+		return nil
+	}
+	h, err := tokenHeader(ctx, ts)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", h)
+	return nil
+}
+
+// tokenHeader returns the Authorization header of the current token of ts.
+func tokenHeader(ctx context.Context, ts TokenSource) (string, error) {
+	t, err := ts.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	if t == nil || t.AccessToken == "" {
+		return "", errors.New("api: empty access token")
+	}
+	return t.tokenType() + " " + t.AccessToken, nil
+}
+
+// tokenType returns the scheme of the token, with the usual case for Bearer tokens.
+func (t *Token) tokenType() string {
+	if t.TokenType == "" || strings.EqualFold(t.TokenType, "bearer") {
+		return "Bearer"
+	}
+	return t.TokenType
+}
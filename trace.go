@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// TraceStats tells where the time of a call went, see WithTrace. The durations of the phases
+// that didn't happen are zero, e.g. DNS, Connect and TLS for a reused connection.
+type TraceStats struct {
+	// GetConn is the time to get a connection, the DNS lookup, connect and TLS handshake included.
+	GetConn time.Duration
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// ConnReused tells whether the connection was reused from the pool, and Idle how long it
+	// had been idle.
+	ConnReused bool
+	Idle       time.Duration
+	// Wait is the time from writing the request until the first byte of the response, the time
+	// taken by the server.
+	Wait time.Duration
+	// TTFB is the time from starting the request until the first byte of the response.
+	TTFB time.Duration
+	// BodyRead is the time from the first byte of the response until its body was closed.
+	BodyRead time.Duration
+	// Total is the time from starting the request until its body was closed or it failed.
+	Total time.Duration
+}
+
+// WithTrace makes the call fill stats with the timings of its connection and exchange, traced
+// with httptrace, once the response body is closed or the call failed. For a call that was
+// retried, they're those of the last attempt. The stats are passed to the CallLogger too,
+// see CallLog.Trace. stats mustn't be read before the call has completed.
+func WithTrace(stats *TraceStats) Option {
+	return func(c *call) {
+		t := &callTrace{stats: stats}
+		c.trace = t
+		c.wrappers = append(c.wrappers, func(_ *http.Response, body io.ReadCloser) io.ReadCloser {
+			return &traceBody{ReadCloser: body, t: t}
+		})
+	}
+}
+
+// callTrace collects the timings of the attempts of a call for WithTrace.
+type callTrace struct {
+	stats *TraceStats
+	// mu guards the timings, which the duplicates of WithHedging mark concurrently.
+	mu sync.Mutex
+	traceTimes
+}
+
+// traceTimes are the timings of an attempt.
+type traceTimes struct {
+	clk                                                           Clock
+	start, getConn, gotConn, dnsStart, connStart, tlsStart, wrote time.Time
+	firstByte                                                     time.Time
+	dns, connect, handshake, idle                                 time.Duration
+	reused                                                        bool
+}
+
+// attempt resets the timings for an attempt starting now, returning ctx tracing it.
+func (t *callTrace) attempt(ctx context.Context, clk Clock) context.Context {
+	t.mu.Lock()
+	t.traceTimes = traceTimes{clk: clk, start: clk.Now()}
+	t.mu.Unlock()
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { t.mark(&t.getConn) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.gotConn.IsZero() {
+				t.gotConn, t.reused, t.idle = t.clk.Now(), info.Reused, info.IdleTime
+			}
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.since(&t.dnsStart, &t.dns) },
+		ConnectStart:         func(string, string) { t.mark(&t.connStart) },
+		ConnectDone:          func(string, string, error) { t.since(&t.connStart, &t.connect) },
+		TLSHandshakeStart:    func() { t.mark(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.since(&t.tlsStart, &t.handshake) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.mark(&t.wrote) },
+		GotFirstResponseByte: func() { t.mark(&t.firstByte) },
+	})
+}
+
+// mark sets *at to now, unless a duplicate set it first.
+func (t *callTrace) mark(at *time.Time) {
+	t.mu.Lock()
+	if at.IsZero() {
+		*at = t.clk.Now()
+	}
+	t.mu.Unlock()
+}
+
+// since sets *d to the time since start, unless a duplicate set it first.
+func (t *callTrace) since(start *time.Time, d *time.Duration) {
+	t.mu.Lock()
+	if *d == 0 && !start.IsZero() {
+		*d = t.clk.Now().Sub(*start)
+	}
+	t.mu.Unlock()
+}
+
+// finish fills the stats once the attempt is over.
+func (t *callTrace) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clk.Now()
+	s := TraceStats{DNS: t.dns, Connect: t.connect, TLS: t.handshake, ConnReused: t.reused, Idle: t.idle, Total: now.Sub(t.start)}
+	if !t.gotConn.IsZero() {
+		s.GetConn = t.gotConn.Sub(t.start)
+	}
+	if !t.firstByte.IsZero() {
+		s.TTFB = t.firstByte.Sub(t.start)
+		s.BodyRead = now.Sub(t.firstByte)
+		if !t.wrote.IsZero() {
+			s.Wait = t.firstByte.Sub(t.wrote)
+		}
+	}
+	*t.stats = s
+}
+
+// traceBody finishes the trace of its call once closed.
+type traceBody struct {
+	io.ReadCloser
+	t    *callTrace
+	once sync.Once
+}
+
+func (b *traceBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.t.finish)
+	return err
+}
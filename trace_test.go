@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTrace(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer srv.Close()
+	// Dialing by name makes the DNS lookup show; the certificate is for example.com.
+	tr := srv.Client().Transport.(*http.Transport).Clone()
+	tr.TLSClientConfig.ServerName = "example.com"
+	a := MustNew(strings.Replace(srv.URL, "127.0.0.1", "localhost", 1))
+	a.Client = &http.Client{Transport: tr}
+	h := &recordHandler{}
+	a.SetLogger(slog.New(h))
+	ctx := context.Background()
+
+	var cold, warm TraceStats
+	if !assert.NoError(t, a.Get(ctx, "/items", nil, nil, WithTrace(&cold))) {
+		return
+	}
+	assert.False(t, cold.ConnReused)
+	for _, d := range []time.Duration{cold.DNS, cold.Connect, cold.TLS} {
+		assert.True(t, d > 0, d)
+	}
+	assert.True(t, cold.GetConn >= cold.DNS+cold.TLS, cold)
+	assert.True(t, cold.Wait >= 10*time.Millisecond, cold)
+	assert.True(t, cold.TTFB >= cold.GetConn+cold.Wait, cold)
+	assert.True(t, cold.Total >= cold.TTFB+cold.BodyRead, cold)
+
+	if !assert.NoError(t, a.Get(ctx, "/items", nil, nil, WithTrace(&warm))) {
+		return
+	}
+	assert.True(t, warm.ConnReused)
+	assert.Equal(t, []time.Duration{0, 0, 0}, []time.Duration{warm.DNS, warm.Connect, warm.TLS})
+	assert.True(t, warm.GetConn < cold.GetConn, warm)
+	assert.True(t, warm.TTFB >= warm.Wait && warm.Wait >= 10*time.Millisecond, warm)
+
+	if assert.Len(t, h.records, 2) {
+		attrs := recordAttrs(h.records[1])
+		assert.Equal(t, warm.TTFB.String(), attrs["ttfb"])
+		assert.Equal(t, "true", attrs["conn_reused"])
+	}
+
+	// A failed call is traced too.
+	var failed TraceStats
+	b := MustNew("https://localhost:1")
+	b.Client = a.Client
+	assert.Error(t, b.Get(ctx, "/items", nil, nil, WithTrace(&failed)))
+	assert.True(t, failed.Total > 0)
+	assert.Zero(t, failed.TTFB)
+}
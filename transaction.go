@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TxStep is a step of a transaction run by RunTransaction.
+type TxStep struct {
+	// Name identifies the step in errors and in Tx.Response, "#1" for the first one if empty.
+	Name string
+	// Action builds the request of the step from the responses of the previous steps.
+	Action func(tx *Tx) (*http.Request, error)
+	// Compensate, if set, builds the request undoing the action once it succeeded, e.g. the
+	// DELETE of what it created, from the responses of the steps up to this one.
+	Compensate func(tx *Tx) (*http.Request, error)
+	// Undo, if set, undoes the action in place of Compensate, e.g. without a call.
+	Undo func(ctx context.Context, tx *Tx) error
+	// CompensateTimeout bounds the compensation of the step, 30s if zero.
+	CompensateTimeout time.Duration
+	// Options are applied to the calls of the action and of Compensate.
+	Options []Option
+}
+
+// Tx holds the responses of the steps of a transaction run by RunTransaction.
+type Tx struct {
+	responses []*TxResponse
+}
+
+// TxResponse is the response to the action of a transaction step.
+type TxResponse struct {
+	Step   string
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Response returns the response to the action of the named step, nil if it didn't run yet.
+func (tx *Tx) Response(step string) *TxResponse {
+	for _, r := range tx.responses {
+		if r.Step == step {
+			return r
+		}
+	}
+	return nil
+}
+
+// Value returns the value at path in the JSON body of the response to the named step, like
+// JSONLookup does, e.g. tx.Value("create", "id"), empty if the step didn't run or the path isn't
+// found in the body.
+func (tx *Tx) Value(step, path string) string {
+	r := tx.Response(step)
+	if r == nil {
+		return ""
+	}
+	v, err := JSONLookup(r.Body, path)
+	if err != nil {
+		return ""
+	}
+	return v.String()
+}
+
+// CompensationError is the failure of the compensation of a transaction step.
+type CompensationError struct {
+	Step string
+	Err  error
+}
+
+func (e *CompensationError) Error() string {
+	return fmt.Sprintf("compensating %s: %v", e.Step, e.Err)
+}
+
+func (e *CompensationError) Unwrap() error { return e.Err }
+
+// TransactionError is returned by RunTransaction when a step fails. errors.Is and errors.As
+// find the failure of the step as well as those of the compensations.
+type TransactionError struct {
+	// Step is the name of the failed step, Index its 0-based index.
+	Step  string
+	Index int
+	Err   error
+	// Compensated are the names of the steps compensated, in the order they were.
+	Compensated []string
+	// Compensations are the failures of the compensations, in the order they were attempted.
+	Compensations []*CompensationError
+}
+
+func (e *TransactionError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "api: transaction step %s: %v", e.Step, e.Err)
+	for _, c := range e.Compensations {
+		b.WriteString("; ")
+		b.WriteString(c.Error())
+	}
+	return b.String()
+}
+
+func (e *TransactionError) Unwrap() []error {
+	errs := []error{e.Err}
+	for _, c := range e.Compensations {
+		errs = append(errs, c)
+	}
+	return errs
+}
+
+// RunTransaction runs the actions of steps in order, saga style: when one fails, the steps that
+// succeeded before it are compensated in reverse order, e.g. creating an order, then its payment,
+// the order being deleted if the payment fails:
+//
+//	tx, err := a.RunTransaction(ctx, []api.TxStep{{
+//		Name:   "order",
+//		Action: func(*api.Tx) (*http.Request, error) { return a.RequestJSON(api.POST, "/orders", order) },
+//		Compensate: func(tx *api.Tx) (*http.Request, error) {
+//			return a.Request(api.DELETE, "/orders/"+tx.Value("order", "id"), nil)
+//		},
+//	}, {
+//		Name: "payment",
+//		Action: func(tx *api.Tx) (*http.Request, error) {
+//			return a.RequestJSON(api.POST, "/payments", payment{Order: tx.Value("order", "id")})
+//		},
+//	}})
+//
+// The calls are made just like DoJSON makes them, non-2xx responses failing the step, and the
+// responses to the actions are kept in the Tx for the next steps. The compensations are best
+// effort: they all run, whether the previous ones failed or ctx is done, each under its own
+// CompensateTimeout, and their failures are gathered into the *TransactionError along with the
+// failure of the step. A step without Compensate nor Undo is skipped. The Tx is returned either
+// way.
+func (a *Api) RunTransaction(ctx context.Context, steps []TxStep) (*Tx, error) {
+	tx := &Tx{}
+	for i := range steps {
+		step := &steps[i]
+		err := a.runTxAction(ctx, step, txStepName(step, i), tx)
+		if err == nil {
+			continue
+		}
+		terr := &TransactionError{Step: txStepName(step, i), Index: i, Err: err}
+		for j := i - 1; j >= 0; j-- {
+			s := &steps[j]
+			if s.Compensate == nil && s.Undo == nil {
+				continue
+			}
+			if err := a.compensate(ctx, s, tx); err != nil {
+				terr.Compensations = append(terr.Compensations, &CompensationError{Step: txStepName(s, j), Err: err})
+				continue
+			}
+			terr.Compensated = append(terr.Compensated, txStepName(s, j))
+		}
+		return tx, terr
+	}
+	return tx, nil
+}
+
+func txStepName(step *TxStep, i int) string {
+	if step.Name != "" {
+		return step.Name
+	}
+	return "#" + strconv.Itoa(i+1)
+}
+
+func (a *Api) runTxAction(ctx context.Context, step *TxStep, name string, tx *Tx) error {
+	if step.Action == nil {
+		return errors.New("no action")
+	}
+	req, err := step.Action(tx)
+	if err != nil {
+		return err
+	}
+	resp, err := a.txSend(ctx, req, step.Options)
+	if err != nil {
+		return err
+	}
+	resp.Step = name
+	tx.responses = append(tx.responses, resp)
+	return nil
+}
+
+// compensate undoes step, with a context that isn't canceled with ctx.
+func (a *Api) compensate(ctx context.Context, step *TxStep, tx *Tx) error {
+	timeout := step.CompensateTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	if step.Undo != nil {
+		return step.Undo(ctx, tx)
+	}
+	req, err := step.Compensate(tx)
+	if err != nil {
+		return err
+	}
+	_, err = a.txSend(ctx, req, step.Options)
+	return err
+}
+
+// txSend sends req and reads the response.
+func (a *Api) txSend(ctx context.Context, req *http.Request, opts []Option) (*TxResponse, error) {
+	resp, err := a.send(ctx, a.newCallFor(req.URL.Path, opts), req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &TxResponse{Status: resp.StatusCode, Header: resp.Header, Body: body}, nil
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// txServer creates orders and payments, failing the calls to the paths in fail with a 500.
+type txServer struct {
+	mu    sync.Mutex
+	calls []string
+	fail  map[string]bool
+}
+
+func (s *txServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.calls = append(s.calls, r.Method+" "+r.URL.Path)
+	fail := s.fail[r.Method+" "+r.URL.Path]
+	s.mu.Unlock()
+	if fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/orders":
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"o1"}`))
+	case r.Method == "POST" && r.URL.Path == "/payments":
+		w.Write([]byte(`{"id":"p1","order":"o1"}`))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func txSteps(a *Api) []TxStep {
+	return []TxStep{{
+		Name:   "order",
+		Action: func(*Tx) (*http.Request, error) { return a.RequestJSON(POST, "/orders", map[string]int{"total": 10}) },
+		Compensate: func(tx *Tx) (*http.Request, error) {
+			return a.Request(DELETE, "/orders/"+tx.Value("order", "id"), nil)
+		},
+	}, {
+		Name: "payment",
+		Action: func(tx *Tx) (*http.Request, error) {
+			return a.RequestJSON(POST, "/payments", map[string]string{"order": tx.Value("order", "id")})
+		},
+		Compensate: func(tx *Tx) (*http.Request, error) {
+			return a.Request(DELETE, "/payments/"+tx.Value("payment", "id"), nil)
+		},
+	}}
+}
+
+func TestRunTransaction(t *testing.T) {
+	s := &txServer{}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	tx, err := a.RunTransaction(context.Background(), txSteps(a))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"POST /orders", "POST /payments"}, s.calls)
+	if r := tx.Response("order"); assert.NotNil(t, r) {
+		assert.Equal(t, http.StatusCreated, r.Status)
+	}
+	assert.Equal(t, "p1", tx.Value("payment", "id"))
+	assert.Equal(t, "", tx.Value("payment", "missing"))
+	assert.Nil(t, tx.Response("refund"))
+}
+
+func TestRunTransactionCompensation(t *testing.T) {
+	s := &txServer{fail: map[string]bool{"POST /payments": true}}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	steps := append(txSteps(a), TxStep{
+		Action: func(*Tx) (*http.Request, error) { return a.Request(POST, "/receipts", nil) },
+	})
+	// A step without a compensation, e.g. a read, is skipped.
+	steps = append([]TxStep{{
+		Action: func(*Tx) (*http.Request, error) { return a.Request(GET, "/stock", nil) },
+	}}, steps...)
+	tx, err := a.RunTransaction(context.Background(), steps)
+	var te *TransactionError
+	if !assert.True(t, errors.As(err, &te)) {
+		return
+	}
+	assert.Equal(t, "payment", te.Step)
+	assert.Equal(t, 2, te.Index)
+	assert.Equal(t, []string{"order"}, te.Compensated)
+	assert.Empty(t, te.Compensations)
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, http.StatusInternalServerError, se.Code)
+	}
+	assert.Equal(t, []string{"GET /stock", "POST /orders", "POST /payments", "DELETE /orders/o1"}, s.calls)
+	assert.NotNil(t, tx.Response("#1"))
+	assert.Nil(t, tx.Response("payment"))
+}
+
+func TestRunTransactionCompensationFailures(t *testing.T) {
+	s := &txServer{fail: map[string]bool{"DELETE /payments/p1": true}}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	// The compensations run even though the failure canceled ctx.
+	ctx, cancel := context.WithCancel(context.Background())
+	undoErr := errors.New("ledger unavailable")
+	var undone bool
+	steps := append(txSteps(a), TxStep{
+		Name: "ledger",
+		Action: func(*Tx) (*http.Request, error) {
+			return a.Request(PUT, "/ledger/o1", nil)
+		},
+		Undo: func(ctx context.Context, tx *Tx) error {
+			undone = ctx.Err() == nil
+			return undoErr
+		},
+	}, TxStep{
+		Name: "receipt",
+		Action: func(*Tx) (*http.Request, error) {
+			cancel()
+			return nil, errors.New("receipt refused")
+		},
+	})
+	_, err := a.RunTransaction(ctx, steps)
+	var te *TransactionError
+	if !assert.True(t, errors.As(err, &te)) {
+		return
+	}
+	assert.True(t, undone)
+	assert.Equal(t, []string{"order"}, te.Compensated)
+	if assert.Len(t, te.Compensations, 2) {
+		assert.Equal(t, "ledger", te.Compensations[0].Step)
+		assert.Equal(t, "payment", te.Compensations[1].Step)
+	}
+	assert.ErrorIs(t, err, undoErr)
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, http.StatusInternalServerError, se.Code)
+	}
+	assert.True(t, strings.HasPrefix(err.Error(), "api: transaction step receipt: receipt refused; compensating ledger: ledger unavailable; compensating payment: "), err.Error())
+	assert.Equal(t, []string{"POST /orders", "POST /payments", "PUT /ledger/o1", "DELETE /payments/p1", "DELETE /orders/o1"}, s.calls)
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ErrTransform is the error the failures of a JSONTransform match with errors.Is.
+var ErrTransform = errors.New("api: body transform failed")
+
+// JSONTransform rewrites a JSON object in place, see TransformRequestJSON. The numbers of the
+// object are json.Numbers, so they keep their precision.
+type JSONTransform func(obj map[string]interface{}) error
+
+// TransformError is returned when a JSONTransform fails, or when the body to transform isn't
+// valid JSON.
+type TransformError struct {
+	// Op is "request" or "response".
+	Op  string
+	Err error
+}
+
+func (e *TransformError) Error() string {
+	return fmt.Sprintf("api: %s body transform: %v", e.Op, e.Err)
+}
+
+func (e *TransformError) Unwrap() error { return e.Err }
+
+// Is makes the error match ErrTransform.
+func (e *TransformError) Is(target error) bool { return target == ErrTransform }
+
+// Class makes the error permanent: another attempt would fail the same way.
+func (e *TransformError) Class() Class { return Permanent }
+
+type jsonTransforms struct {
+	request  []JSONTransform
+	response []JSONTransform
+}
+
+// TransformRequestJSON makes the Api pass the JSON request bodies through ts, in order, once
+// they're encoded and before the body codec, so the wire names of the fields can be mapped
+// centrally instead of duplicating the structs:
+//
+//	a.TransformRequestJSON(api.RenameKey("userId", "user_id"), api.SetKey("source", "go-client"), api.StripNulls())
+//
+// The transforms are given the body if it's an object, or each of its elements which are
+// objects if it's an array. The transformed body is encoded with its keys sorted. Like the body
+// codec, the transforms apply to the bodies encoded by the request constructors and templates,
+// of a JSON content type; the streamed bodies are sent as they are. No ts removes the transforms.
+func (a *Api) TransformRequestJSON(ts ...JSONTransform) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := jsonTransforms{request: ts}
+	if cur := a.transforms.Load(); cur != nil {
+		t.response = cur.response
+	}
+	a.transforms.Store(&t)
+}
+
+// TransformResponseJSON makes the Api pass the JSON response bodies through ts, in order, after
+// the body codec and before they're decoded, like TransformRequestJSON does for the requests.
+// The response bodies of a JSON content type are read in full on arrival to be transformed.
+// No ts removes the transforms.
+func (a *Api) TransformResponseJSON(ts ...JSONTransform) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := jsonTransforms{response: ts}
+	if cur := a.transforms.Load(); cur != nil {
+		t.request = cur.request
+	}
+	a.transforms.Store(&t)
+}
+
+// RenameKey returns a JSONTransform renaming the key from to to. A plain key is renamed in the
+// objects at any depth, the elements of the arrays included. A dotted key, like "user.user_id",
+// is only renamed at its path from the root object, where the elements of an array have the path
+// of the array: "items.user_id" renames the key in every element of items. Renaming a key to an
+// existing one is an error.
+func RenameKey(from, to string) JSONTransform {
+	parent, key := "", from
+	dotted := strings.Contains(from, ".")
+	if dotted {
+		i := strings.LastIndexByte(from, '.')
+		parent, key = from[:i], from[i+1:]
+	}
+	return func(obj map[string]interface{}) error {
+		return walkJSON(obj, "", func(p string, obj map[string]interface{}) error {
+			if dotted && p != parent {
+				return nil
+			}
+			v, ok := obj[key]
+			if !ok {
+				return nil
+			}
+			if _, ok := obj[to]; ok {
+				return fmt.Errorf("rename %s: %s already set", from, to)
+			}
+			delete(obj, key)
+			obj[to] = v
+			return nil
+		})
+	}
+}
+
+// SetKey returns a JSONTransform setting the key of the root object to v, e.g. a constant field
+// the API requires.
+func SetKey(key string, v interface{}) JSONTransform {
+	return func(obj map[string]interface{}) error {
+		obj[key] = v
+		return nil
+	}
+}
+
+// StripNulls returns a JSONTransform removing the keys set to null from the objects at any depth.
+// The null elements of the arrays are kept.
+func StripNulls() JSONTransform {
+	return func(obj map[string]interface{}) error {
+		return walkJSON(obj, "", func(_ string, obj map[string]interface{}) error {
+			for k, v := range obj {
+				if v == nil {
+					delete(obj, k)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// walkJSON calls fn for obj and every object under it, with their dotted paths from the root,
+// the parents before their children.
+func walkJSON(obj map[string]interface{}, p string, fn func(p string, obj map[string]interface{}) error) error {
+	if err := fn(p, obj); err != nil {
+		return err
+	}
+	for k, v := range obj {
+		if err := walkJSONValue(v, keyPath(p, k), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkJSONValue(v interface{}, p string, fn func(p string, obj map[string]interface{}) error) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return walkJSON(v, p, fn)
+	case []interface{}:
+		for _, e := range v {
+			if err := walkJSONValue(e, p, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func keyPath(p, k string) string {
+	if p == "" {
+		return k
+	}
+	return p + "." + k
+}
+
+// transformJSON returns the JSON data passed through ts.
+func transformJSON(data []byte, ts []JSONTransform) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after the JSON value")
+	}
+	apply := func(v interface{}) error {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, t := range ts {
+			if err := t(obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if arr, ok := v.([]interface{}); ok {
+		for _, e := range arr {
+			if err := apply(e); err != nil {
+				return nil, err
+			}
+		}
+	} else if err := apply(v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isJSONType(contentType string) bool {
+	mediatype, _, _ := mime.ParseMediaType(contentType)
+	return mediatype == "application/json" || strings.HasSuffix(mediatype, "+json")
+}
+
+// transformResponse replaces the JSON body of resp by its transformation by ts.
+func transformResponse(ts []JSONTransform, resp *http.Response) error {
+	if resp.Body == nil || resp.Body == http.NoBody || !isJSONType(resp.Header.Get("Content-Type")) {
+		return nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if data, err = transformJSON(data, ts); err != nil {
+			return &TransformError{Op: "response", Err: err}
+		}
+	}
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = int64(len(data))
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return nil
+}
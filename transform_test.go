@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransformJSON(t *testing.T) {
+	var sent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sent = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"user_id": 9007199254740993, "org": {"org_id": 12345678901234567890, "owner_id": 1},
+			"items": [{"item_id": 1, "note": null}, {"item_id": 2}], "next": null}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.TransformRequestJSON(RenameKey("userId", "user_id"), RenameKey("items.itemId", "item_id"),
+		SetKey("source", "go-client"), StripNulls())
+	a.TransformResponseJSON(RenameKey("user_id", "userId"), RenameKey("org.org_id", "orgId"), RenameKey("item_id", "itemId"))
+
+	type item struct {
+		ItemID int     `json:"itemId"`
+		Note   *string `json:"note,omitempty"`
+	}
+	in := map[string]interface{}{
+		"userId": uint64(1<<53 + 1),
+		"items":  []item{{ItemID: 1}, {ItemID: 2}},
+		"parent": map[string]interface{}{"userId": 7, "itemId": 3, "email": nil},
+		"email":  nil,
+	}
+	var out struct {
+		UserID uint64 `json:"userId"`
+		Org    struct {
+			OrgID   uint64 `json:"orgId"`
+			OwnerID int    `json:"owner_id"`
+		} `json:"org"`
+		Items []item `json:"items"`
+	}
+	if !assert.NoError(t, a.Post(context.Background(), "/users", in, &out)) {
+		return
+	}
+	// Nested renames, the dotted keys only at their path, and no precision lost.
+	assert.JSONEq(t, `{"user_id": 9007199254740993, "items": [{"item_id": 1}, {"item_id": 2}],
+		"parent": {"user_id": 7, "itemId": 3}, "source": "go-client"}`, sent)
+	assert.Equal(t, uint64(9007199254740993), out.UserID)
+	assert.Equal(t, uint64(12345678901234567890), out.Org.OrgID)
+	assert.Equal(t, 1, out.Org.OwnerID)
+	assert.Equal(t, []item{{ItemID: 1}, {ItemID: 2}}, out.Items)
+
+	// The transforms are given the objects of an array body.
+	assert.NoError(t, a.Post(context.Background(), "/users", []map[string]interface{}{{"userId": 1}, {"userId": 2}}, nil))
+	assert.JSONEq(t, `[{"user_id": 1, "source": "go-client"}, {"user_id": 2, "source": "go-client"}]`, sent)
+
+	// A clash fails the request creation.
+	err := a.Post(context.Background(), "/users", map[string]interface{}{"userId": 1, "user_id": 2}, nil)
+	var te *TransformError
+	if assert.True(t, errors.As(err, &te), "%v", err) {
+		assert.Equal(t, "request", te.Op)
+	}
+	assert.True(t, errors.Is(err, ErrTransform))
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrTruncatedBody is matched by every *TruncatedBodyError.
+var ErrTruncatedBody = errors.New("api: truncated response body")
+
+// TruncatedBodyError is returned by reads of a response body received via Do that ended before
+// its Content-Length was reached or went past it, or whose connection was cut mid-body,
+// e.g. in the middle of a chunk. It's matched by ErrTruncatedBody.
+type TruncatedBodyError struct {
+	// Expected is the Content-Length of the response, -1 if it wasn't announced, e.g. for chunked bodies.
+	Expected int64
+	// Received is the number of bytes of the body read until the truncation was detected.
+	Received int64
+}
+
+func (e *TruncatedBodyError) Error() string {
+	if e.Expected < 0 {
+		return fmt.Sprintf("api: truncated response body: body of unknown length cut short after %d bytes", e.Received)
+	}
+	return fmt.Sprintf("api: truncated response body: expected %d bytes, received %d", e.Expected, e.Received)
+}
+
+// Is makes errors.Is(err, ErrTruncatedBody) report true.
+func (e *TruncatedBodyError) Is(target error) bool {
+	return target == ErrTruncatedBody
+}
+
+// Class makes the error a Temporary failure, like a connection closed prematurely.
+func (e *TruncatedBodyError) Class() Class { return Temporary }
+
+// lengthBody verifies that the body has the length announced by the response. A zero length
+// isn't verified, since the responses made up by test transports often leave it unset.
+type lengthBody struct {
+	io.ReadCloser
+	expected int64
+	n        int64
+}
+
+func newLengthBody(req *http.Request, resp *http.Response) io.ReadCloser {
+	if req.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return resp.Body
+	}
+	return &lengthBody{ReadCloser: resp.Body, expected: resp.ContentLength}
+}
+
+func (b *lengthBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	switch {
+	case b.expected > 0 && b.n > b.expected:
+		err = &TruncatedBodyError{Expected: b.expected, Received: b.n}
+	case err == io.EOF && b.n < b.expected,
+		errors.Is(err, io.ErrUnexpectedEOF):
+		err = &TruncatedBodyError{Expected: b.expected, Received: b.n}
+	}
+	return n, err
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncatedBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/lying":
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte(`{"items": [1, 2,`))
+		case "/chunked":
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nContent-Type: application/x-ndjson\r\n\r\n")
+			buf.WriteString("10\r\n{\"a\":1}\n{\"a\":2}\n\r\n")
+			buf.WriteString("10\r\n{\"a\":")
+			buf.Flush()
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+
+	var out interface{}
+	err := a.Get(context.Background(), "/lying", nil, &out)
+	var te *TruncatedBodyError
+	if assert.True(t, errors.As(err, &te), "%v", err) {
+		assert.Equal(t, int64(100), te.Expected)
+		assert.Equal(t, int64(16), te.Received)
+	}
+	assert.True(t, errors.Is(err, ErrTruncatedBody))
+	assert.EqualError(t, err, "api: truncated response body: expected 100 bytes, received 16")
+	assert.Equal(t, Temporary, Classify(err))
+
+	var values []string
+	err = a.DoNDJSON(context.Background(), GET, "/chunked", nil, func(v json.RawMessage) error {
+		values = append(values, string(v))
+		return nil
+	})
+	assert.Equal(t, []string{`{"a":1}`, `{"a":2}`}, values)
+	if assert.True(t, errors.As(err, &te), "%v", err) {
+		assert.Equal(t, int64(-1), te.Expected)
+		assert.Equal(t, int64(21), te.Received)
+	}
+
+	req, _ := a.Request(GET, "/lying", nil)
+	resp, err := a.Do(context.Background(), req)
+	if assert.NoError(t, err) {
+		_, err = io.ReadAll(resp.Body)
+		assert.True(t, errors.Is(err, ErrTruncatedBody))
+		resp.Body.Close()
+	}
+}
+
+func TestTruncatedBodyTransport(t *testing.T) {
+	a := MustNew("http://example.com")
+	var body string
+	var length int64
+	a.Client = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          io.NopCloser(bufio.NewReader(strings.NewReader(body))),
+			ContentLength: length,
+			Request:       req,
+		}, nil
+	})}
+
+	// A body shorter than announced, ending with a plain EOF.
+	body, length = `[1]`, 10
+	var out []int
+	err := a.Get(context.Background(), "/", nil, &out)
+	assert.EqualError(t, err, "api: truncated response body: expected 10 bytes, received 3")
+
+	// A body going past its Content-Length.
+	body, length = `[1, 2, 3]`, 3
+	err = a.Get(context.Background(), "/", nil, &out)
+	assert.True(t, errors.Is(err, ErrTruncatedBody), "%v", err)
+
+	body, length = `[1, 2, 3]`, 9
+	assert.NoError(t, a.Get(context.Background(), "/", nil, &out))
+	assert.Equal(t, []int{1, 2, 3}, out)
+	body, length = `[1, 2, 3]`, -1
+	assert.NoError(t, a.Get(context.Background(), "/", nil, &out))
+	// A zero length isn't verified.
+	body, length = `[1, 2, 3]`, 0
+	assert.NoError(t, a.Get(context.Background(), "/", nil, &out))
+
+	// HEAD responses announce the length of a body they don't have.
+	body, length = ``, 10
+	req, _ := a.Request(HEAD, "/", nil)
+	resp, err := a.Do(context.Background(), req)
+	if assert.NoError(t, err) {
+		_, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+}
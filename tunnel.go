@@ -0,0 +1,89 @@
+package api
+
+import (
+	"strconv"
+)
+
+// StatusTunnel describes where an API tunneling its real status in the body of the 2xx
+// responses puts it, see SetStatusTunnel.
+type StatusTunnel struct {
+	// Path is the dotted JSON path of the status in the body, "code" if empty. Its value may be
+	// a number or a string, like 404 or "404".
+	Path string
+	// Codes maps the values which aren't HTTP statuses to the statuses they stand for, e.g.
+	// "NOT_FOUND" to 404. The other values from 100 to 599 are taken as statuses.
+	Codes map[string]int
+	// Resources limits the tunnel to these resources, as given to the helpers or the templates
+	// of the calls made from a Template, e.g. "/v1/users/{id}". All resources if empty.
+	Resources []string
+	// Except are the resources the tunnel doesn't apply to, e.g. a list endpoint whose items
+	// have a "code" field of their own.
+	Except []string
+}
+
+// SetStatusTunnel makes the Do-style helpers look up the real status of the 2xx responses in
+// their body, for the upstreams that always answer 200, e.g. with {"code": 404, "message": "..."}:
+//
+//	a.SetStatusTunnel(&api.StatusTunnel{Path: "code", Except: []string{"/v1/events"}})
+//
+// A tunneled status overrides the one of the response for the error mapping, the Classifier,
+// the retries and the CallLog: a tunneled 429 is retried like a real one, and a tunneled 404
+// fails with a *StatusError of Code 404, with Tunneled set, matching ErrNotFound. The status of
+// the response stays in ResponseMeta.StatusCode, and the tunneled one is in
+// ResponseMeta.TunneledStatus. The responses tunneling a 2xx status, or none, are returned with
+// their body intact. Only the first 64KB of a body are looked at, or the limit set by
+// WithErrorBody. A nil t disables the tunnel.
+func (a *Api) SetStatusTunnel(t *StatusTunnel) {
+	var tunnel *StatusTunnel
+	if t != nil {
+		c := *t
+		if c.Path == "" {
+			c.Path = "code"
+		}
+		tunnel = &c
+	}
+	a.mu.Lock()
+	a.tunnel = tunnel
+	a.mu.Unlock()
+}
+
+// applies reports whether the tunnel applies to the resource of c.
+func (t *StatusTunnel) applies(c *call) bool {
+	resource := c.template
+	if resource == "" {
+		resource = c.resource
+	}
+	for _, r := range t.Except {
+		if r == resource {
+			return false
+		}
+	}
+	if len(t.Resources) == 0 {
+		return true
+	}
+	for _, r := range t.Resources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// extract returns the status tunneled in body, zero if there's none.
+func (t *StatusTunnel) extract(body []byte) int {
+	v, err := JSONLookup(body, t.Path)
+	if err != nil {
+		return 0
+	}
+	if k := v.Kind(); k != JSONString && k != JSONNumber {
+		return 0
+	}
+	s := v.String()
+	if code, ok := t.Codes[s]; ok {
+		return code
+	}
+	if code, err := strconv.Atoi(s); err == nil && code >= 100 && code <= 599 {
+		return code
+	}
+	return 0
+}
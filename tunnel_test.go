@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusTunnel(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/users/1":
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.Write([]byte(`{"code": 429, "message": "slow down"}`))
+				return
+			}
+			w.Write([]byte(`{"code": 200, "id": 1}`))
+		case "/users/2":
+			w.Write([]byte(`{"code": "NOT_FOUND", "message": "no such user"}`))
+		case "/events":
+			w.Write([]byte(`{"code": 500, "items": []}`))
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.Retry = &RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond}
+	a.SetStatusTunnel(&StatusTunnel{Codes: map[string]int{"NOT_FOUND": http.StatusNotFound}, Except: []string{"/events"}})
+	ctx := context.Background()
+	var meta ResponseMeta
+	users := a.Template(GET, "/users/{id}", WithMeta(&meta))
+
+	// A tunneled 429 is retried.
+	var user struct{ ID int }
+	assert.NoError(t, users.Do(ctx, map[string]string{"id": "1"}, nil, &user))
+	assert.Equal(t, 1, user.ID)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, 1, meta.Retries)
+	assert.Equal(t, http.StatusOK, meta.TunneledStatus)
+
+	// A tunneled 404 fails like a real one, the raw status staying in the meta.
+	err := users.Do(ctx, map[string]string{"id": "2"}, nil, &user)
+	assert.True(t, errors.Is(err, ErrNotFound), "%v", err)
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, http.StatusNotFound, se.Code)
+		assert.Equal(t, "404 Not Found", se.Status)
+		assert.True(t, se.Tunneled)
+		assert.Contains(t, string(se.Body), "no such user")
+	}
+	assert.Equal(t, http.StatusOK, meta.StatusCode)
+	assert.Equal(t, http.StatusNotFound, meta.TunneledStatus)
+
+	// The excluded resources are decoded as they are.
+	var events struct{ Code int }
+	assert.NoError(t, a.Get(ctx, "/events", nil, &events, WithMeta(&meta)))
+	assert.Equal(t, 500, events.Code)
+	assert.Equal(t, 0, meta.TunneledStatus)
+
+	a.SetStatusTunnel(nil)
+	assert.NoError(t, users.Do(ctx, map[string]string{"id": "2"}, nil, nil))
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrFileChanged is matched by every *FileChangedError.
+var ErrFileChanged = errors.New("api: file changed during upload")
+
+// FileChangedError is returned by UploadFile when the size of the file changed while it was sent.
+// It's matched by ErrFileChanged and is never retried, since the request would announce a stale size.
+type FileChangedError struct {
+	// Name is the name of the file.
+	Name string
+	// Size is the size of the upload, as announced by the Content-Length of the request.
+	Size int64
+	// Read is the number of bytes read before the change was detected.
+	Read int64
+}
+
+func (e *FileChangedError) Error() string {
+	return fmt.Sprintf("api: file changed during upload: %s: %d bytes announced, %d read", e.Name, e.Size, e.Read)
+}
+
+// Is makes errors.Is(err, ErrFileChanged) report true.
+func (e *FileChangedError) Is(target error) bool {
+	return target == ErrFileChanged
+}
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *FileChangedError) Class() Class { return Permanent }
+
+// UploadFile sends the content of f, from its current offset to its end, as the body of a request
+// to the resource, e.g. with PUT, and checks the status code, discarding the response body.
+// The Content-Length is the size of the file, and the Content-Type is derived from its extension
+// or else sniffed from its first 512 bytes; WithHeader("Content-Type", ...) overrides it.
+// The body is streamed from f without buffering and resent from the same offset on retries and
+// redirects, so UploadChecksum can hash it too. An empty file is sent without a body.
+// If the file gets shorter or longer while it's sent, the call fails with a *FileChangedError.
+// Files that aren't regular, like pipes, are streamed as they are, without a length and only once.
+func (a *Api) UploadFile(ctx context.Context, method Method, resource string, f *os.File, opts ...Option) error {
+	u, err := a.resourceURL(resource)
+	if err != nil {
+		return err
+	}
+	if method < GET || method > PATCH {
+		return fmt.Errorf("api: unknown method: %d", method)
+	}
+	req := newRequest(method, u)
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(f.Name()))
+	if !fi.Mode().IsRegular() {
+		setReaderBody(req, f)
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	} else {
+		start, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		size := fi.Size() - start
+		if contentType == "" {
+			contentType = "application/octet-stream"
+			if size > 0 {
+				head := make([]byte, 512)
+				n, err := f.ReadAt(head, start)
+				if err != nil && err != io.EOF {
+					return err
+				}
+				contentType = http.DetectContentType(head[:n])
+			}
+		}
+		if size > 0 {
+			open := func() (io.ReadCloser, error) {
+				return &fileBody{SectionReader: io.NewSectionReader(f, start, size), f: f, start: start, size: size}, nil
+			}
+			req.Body, _ = open()
+			req.GetBody = open
+			req.ContentLength = size
+		} else {
+			req.Body = http.NoBody
+		}
+		req.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := a.shape(req, []Option{buildContext(ctx)}); err != nil {
+		return err
+	}
+	resp, err := a.send(ctx, a.newCallFor(resource, opts), req)
+	if err != nil {
+		return err
+	}
+	return drainClose(resp.Body)
+}
+
+// fileBody reads size bytes of f from start, failing with a *FileChangedError if the size of f
+// changes meanwhile.
+type fileBody struct {
+	*io.SectionReader
+	f           *os.File
+	start, size int64
+}
+
+func (b *fileBody) Read(p []byte) (int, error) {
+	n, err := b.SectionReader.Read(p)
+	if err == io.EOF {
+		pos, _ := b.SectionReader.Seek(0, io.SeekCurrent)
+		fi, serr := b.f.Stat()
+		if pos < b.size || serr == nil && fi.Size()-b.start != b.size {
+			return n, &FileChangedError{Name: b.f.Name(), Size: b.size, Read: pos}
+		}
+	}
+	return n, err
+}
+
+func (*fileBody) Close() error { return nil }
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempFile(t *testing.T, name string, data []byte) *os.File {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestUploadFile(t *testing.T) {
+	type upload struct {
+		method, path, contentType, length, checksum, body string
+	}
+	uploads := make(chan upload, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/files/b", http.StatusTemporaryRedirect)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		uploads <- upload{r.Method, r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Content-Length"),
+			r.Header.Get("X-Amz-Checksum-Sha256"), string(body)}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	ctx := context.Background()
+
+	data := `{"id": 1}`
+	sum := sha256.Sum256([]byte(data))
+	f := tempFile(t, "item.json", []byte(data))
+	if !assert.NoError(t, a.UploadFile(ctx, PUT, "/files/a", f, UploadChecksum(SHA256, ""))) {
+		return
+	}
+	assert.Equal(t, upload{"PUT", "/files/a", "application/json", "9", base64.StdEncoding.EncodeToString(sum[:]), data}, <-uploads)
+
+	// The redirect resends the body from the offset of the file.
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 600)...)
+	f = tempFile(t, "image", png)
+	f.Seek(4, io.SeekStart)
+	if !assert.NoError(t, a.UploadFile(ctx, POST, "/moved", f)) {
+		return
+	}
+	assert.Equal(t, upload{"POST", "/files/b", "application/octet-stream", "604", "", string(png[4:])}, <-uploads)
+
+	f.Seek(0, io.SeekStart)
+	assert.NoError(t, a.UploadFile(ctx, PUT, "/files/c", f, WithHeader("Content-Type", "image/x-custom")))
+	assert.Equal(t, upload{"PUT", "/files/c", "image/x-custom", "608", "", string(png)}, <-uploads)
+	f.Seek(0, io.SeekStart)
+	assert.NoError(t, a.UploadFile(ctx, PUT, "/files/c", f))
+	assert.Equal(t, "image/png", (<-uploads).contentType)
+
+	f = tempFile(t, "empty", nil)
+	assert.NoError(t, a.UploadFile(ctx, PUT, "/files/d", f))
+	assert.Equal(t, upload{"PUT", "/files/d", "application/octet-stream", "0", "", ""}, <-uploads)
+}
+
+func TestUploadFileChanged(t *testing.T) {
+	for _, data := range []string{"012", "0123456789abc"} {
+		f := tempFile(t, "data.txt", []byte("0123456789"))
+		var hits atomic.Int32
+		a := MustNew("http://example.com")
+		a.Retry = &RetryPolicy{MaxRetries: 2}
+		a.Client = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+			hits.Add(1)
+			if err := os.WriteFile(f.Name(), []byte(data), 0o600); err != nil {
+				return nil, err
+			}
+			if _, err := io.ReadAll(req.Body); err != nil {
+				return nil, err
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		})}
+		err := a.UploadFile(context.Background(), PUT, "/files/a", f)
+		var fe *FileChangedError
+		if assert.ErrorAs(t, err, &fe, data) {
+			assert.ErrorIs(t, err, ErrFileChanged)
+			assert.Equal(t, int64(10), fe.Size)
+			assert.Equal(t, min(int64(len(data)), 10), fe.Read)
+		}
+		assert.Equal(t, int32(1), hits.Load(), data)
+	}
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrPathTraversal is returned when creating a request for a resource whose ".." segments
+// climb above the base path, if the Api's PathPolicy is RejectTraversal.
+var ErrPathTraversal = errors.New("api: resource escapes the base path")
+
+// PathPolicy controls how resources whose ".." segments would climb above the path of the base URI
+// (and the path version, if it's set) are handled. Resources are never resolved above it.
+type PathPolicy int
+
+const (
+	// CleanTraversal resolves such resources as if they were rooted at the base path,
+	// so "../../etc" becomes "<base>/etc".
+	CleanTraversal PathPolicy = iota
+	// RejectTraversal makes the request creation fail with ErrPathTraversal. Backslashes are
+	// treated as separators too, since some servers do so.
+	RejectTraversal
+)
+
+// SetPathPolicy sets how the Api handles resources climbing above the base path, CleanTraversal by default.
+// Either way, the resource is a path: "?" and "#" are escaped rather than starting a query or fragment,
+// and so are backslashes, NUL and other control bytes. The segments of Seg never climb.
+func (a *Api) SetPathPolicy(p PathPolicy) {
+	a.pathPolicy.Store(int32(p))
+}
+
+// traverses reports whether the ".." segments of resource climb above its root.
+// With backslash set, backslashes separate segments too.
+func traverses(resource string, backslash bool) bool {
+	if !strings.Contains(resource, "..") {
+		return false
+	}
+	depth := 0
+	for resource != "" {
+		i := strings.IndexByte(resource, '/')
+		if backslash {
+			if j := strings.IndexByte(resource, '\\'); j >= 0 && (i < 0 || j < i) {
+				i = j
+			}
+		}
+		seg := resource
+		if i >= 0 {
+			seg, resource = resource[:i], resource[i+1:]
+		} else {
+			resource = ""
+		}
+		switch seg {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
+
+// basePrefix caches the cleaned path of the base URI it was computed for.
+type basePrefix struct {
+	base *url.URL
+	path string
+	// clean is path.Clean(path), or empty if path is empty.
+	clean string
+}
+
+// resourceURL joins the resource with the current base URI, inserting the path version if it's set.
+// The result is the URL http.NewRequest would parse from the String of the base URI with
+// its path set to path.Join(base, version, resource), with resource handled by the PathPolicy.
+func (a *Api) resourceURL(resource string) (*url.URL, error) {
+	return a.resolveURL(a.baseURI(), a.currentVersion().segment, PathPolicy(a.pathPolicy.Load()), a.strictPaths.Load(), a.migrate(resource))
+}
+
+// resolveURL is resourceURL for the given base URI, version segment, path policy and strictness.
+func (a *Api) resolveURL(base *url.URL, seg string, policy PathPolicy, strict bool, resource string) (*url.URL, error) {
+	if strict {
+		if err := checkRawReserved(resource); err != nil {
+			return nil, err
+		}
+	}
+	if policy == RejectTraversal {
+		if traverses(resource, true) {
+			return nil, ErrPathTraversal
+		}
+	} else if traverses(resource, false) {
+		resource = path.Clean("/" + resource)
+	}
+	p := a.prefix.Load()
+	if p == nil || p.base != base || p.path != base.Path {
+		p = &basePrefix{base: base, path: base.Path}
+		if base.Path != "" {
+			p.clean = path.Clean(base.Path)
+		}
+		a.prefix.Store(p)
+	}
+	u := *base
+	u.Path = joinPath(p.clean, versionSegment(seg, base.Path, resource), resource)
+	if u.RawPath != "" {
+		// The encoded base path is kept only while it is still a valid encoding of the path.
+		if ep := u.EscapedPath(); ep != (&url.URL{Path: u.Path}).EscapedPath() {
+			u.RawPath = ep
+		} else {
+			u.RawPath = ""
+		}
+	}
+	if u.Host != "" && u.Path != "" && u.Path[0] != '/' {
+		// A relative path is formatted with a leading slash, and "*" is formatted unescaped.
+		if u.Path == "*" {
+			u.RawPath = "/*"
+		}
+		u.Path = "/" + u.Path
+	}
+	if strings.Contains(u.Path, segMark) {
+		unmarkSegments(&u)
+	}
+	return &u, nil
+}
+
+// joinPath is path.Join(base, seg, resource) for a clean base, allocating only the result
+// unless the joined path needs to be cleaned.
+func joinPath(base, seg, resource string) string {
+	if !strings.HasPrefix(base, "/") {
+		return path.Join(base, seg, resource)
+	}
+	var b strings.Builder
+	b.Grow(len(base) + len(seg) + len(resource) + 2)
+	b.WriteString(base)
+	last := base[len(base)-1]
+	for _, elem := range [...]string{seg, resource} {
+		if elem == "" {
+			continue
+		}
+		switch {
+		case last == '/' && elem[0] == '/':
+			elem = elem[1:]
+		case last != '/' && elem[0] != '/':
+			b.WriteByte('/')
+		}
+		b.WriteString(elem)
+		if elem != "" {
+			last = elem[len(elem)-1]
+		}
+	}
+	s := b.String()
+	if isCleanPath(s) {
+		return s
+	}
+	return path.Clean(s)
+}
+
+// isCleanPath reports whether path.Clean would return the absolute path s unchanged.
+func isCleanPath(s string) bool {
+	if s == "/" {
+		return true
+	}
+	if s == "" || s[0] != '/' || s[len(s)-1] == '/' {
+		return false
+	}
+	for i := 1; i < len(s); {
+		j := strings.IndexByte(s[i:], '/')
+		if j < 0 {
+			j = len(s) - i
+		}
+		if seg := s[i : i+j]; seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+		i += j + 1
+	}
+	return true
+}
+
+// newRequest creates a request for u like http.NewRequest does, without formatting and re-parsing u.
+func newRequest(method Method, u *url.URL) *http.Request {
+	u.Host = strings.TrimSuffix(u.Host, ":")
+	return &http.Request{
+		Method:     method.String(),
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// oldRequestURL builds the request URL the way Request did before resourceURL was reworked,
+// with traversal above the base path cleaned just like CleanTraversal does.
+func oldRequestURL(a *Api, resource string) (*url.URL, error) {
+	if traverses(resource, false) {
+		resource = path.Clean("/" + resource)
+	}
+	u := *a.baseURI()
+	u.Path = path.Join(u.Path, versionSegment(a.currentVersion().segment, u.Path, resource), resource)
+	req, err := http.NewRequest(GET.String(), u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return req.URL, nil
+}
+
+func TestResourceURL(t *testing.T) {
+	for _, tc := range []struct {
+		base, resource, version, want string
+	}{
+		{"http://example.com", "", "", "http://example.com"},
+		{"http://example.com", "/", "", "http://example.com/"},
+		{"http://example.com", "items", "", "http://example.com/items"},
+		{"http://example.com/", "/items/", "", "http://example.com/items"},
+		{"http://example.com/api/", "//items/./1/../2", "", "http://example.com/api/items/2"},
+		{"http://example.com/api", "/../../etc", "", "http://example.com/api/etc"},
+		{"http://example.com/api", "/a b/ü?x#y", "", "http://example.com/api/a%20b/%C3%BC%3Fx%23y"},
+		{"http://example.com/a%2Fb", "/items", "", "http://example.com/a/b/items"},
+		{"http://example.com/api", "/items", "v2", "http://example.com/api/v2/items"},
+		{"http://example.com/api/v2", "/items", "v2", "http://example.com/api/v2/items"},
+		{"http://example.com", "/v2/items", "v2", "http://example.com/v2/items"},
+		{"http://example.com:", "/items", "", "http://example.com/items"},
+	} {
+		a := MustNew(tc.base)
+		if tc.version != "" {
+			a.SetVersion(VersionInPath(tc.version))
+		}
+		req, err := a.Request(GET, tc.resource, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, tc.want, req.URL.String(), tc.base+" "+tc.resource)
+		old, err := oldRequestURL(a, tc.resource)
+		if assert.NoError(t, err) {
+			assert.Equal(t, old, req.URL, tc.base+" "+tc.resource)
+		}
+		assert.Equal(t, "example.com", req.Host)
+	}
+}
+
+// TestJoinSemantics locks in how the resources are joined with the base uris. A row whose result
+// differs from the one before the base uris were normalized by New says what it was.
+func TestJoinSemantics(t *testing.T) {
+	for _, tc := range []struct {
+		base, resource string
+		// want is the URL, empty if the base uri is rejected.
+		want string
+		// change is the previous result, "was <url>", empty if unchanged.
+		change string
+	}{
+		{"https://host", "", "https://host", ""},
+		{"https://host", "/", "https://host/", ""},
+		{"https://host", "users", "https://host/users", ""},
+		{"https://host", "/users", "https://host/users", ""},
+		{"https://host", "users/", "https://host/users", ""},
+		{"https://host", "//users", "https://host/users", ""},
+		{"https://host", "/users/1?x", "https://host/users/1%3Fx", ""},
+		{"https://host/", "", "https://host", "was https://host/"},
+		{"https://host/", "/", "https://host/", ""},
+		{"https://host/", "users", "https://host/users", ""},
+		{"https://host/", "/users", "https://host/users", ""},
+		{"https://host/", "users/", "https://host/users", ""},
+		{"https://host/", "//users", "https://host/users", ""},
+		{"https://host/", "/users/1?x", "https://host/users/1%3Fx", ""},
+		{"https://host/api", "", "https://host/api", ""},
+		{"https://host/api", "/", "https://host/api", ""},
+		{"https://host/api", "users", "https://host/api/users", ""},
+		{"https://host/api", "/users", "https://host/api/users", ""},
+		{"https://host/api", "users/", "https://host/api/users", ""},
+		{"https://host/api", "//users", "https://host/api/users", ""},
+		{"https://host/api", "/users/1?x", "https://host/api/users/1%3Fx", ""},
+		{"https://host/api/", "", "https://host/api", ""},
+		{"https://host/api/", "/", "https://host/api", ""},
+		{"https://host/api/", "users", "https://host/api/users", ""},
+		{"https://host/api/", "/users", "https://host/api/users", ""},
+		{"https://host/api/", "users/", "https://host/api/users", ""},
+		{"https://host/api/", "//users", "https://host/api/users", ""},
+		{"https://host/api/", "/users/1?x", "https://host/api/users/1%3Fx", ""},
+		{"https://HOST:8443/API/", "", "https://host:8443/API", "was https://HOST:8443/API"},
+		{"https://HOST:8443/API/", "/", "https://host:8443/API", "was https://HOST:8443/API"},
+		{"https://HOST:8443/API/", "users", "https://host:8443/API/users", "was https://HOST:8443/API/users"},
+		{"https://HOST:8443/API/", "/users", "https://host:8443/API/users", "was https://HOST:8443/API/users"},
+		{"https://HOST:8443/API/", "users/", "https://host:8443/API/users", "was https://HOST:8443/API/users"},
+		{"https://HOST:8443/API/", "//users", "https://host:8443/API/users", "was https://HOST:8443/API/users"},
+		{"https://HOST:8443/API/", "/users/1?x", "https://host:8443/API/users/1%3Fx", "was https://HOST:8443/API/users/1%3Fx"},
+		{"https://host/api#frag", "/users", "", "was https://host/api%23frag/users"},
+	} {
+		name := tc.base + " " + tc.resource
+		before := tc.want
+		if tc.change != "" {
+			before = strings.TrimPrefix(tc.change, "was ")
+		}
+		// The previous result is the one of the join with the base uri as parsed before.
+		u, err := url.ParseRequestURI(tc.base)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		old, err := (&Api{BaseURI: u}).Request(GET, tc.resource, nil)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, before, old.URL.String(), name)
+		}
+
+		a, err := New(tc.base)
+		if tc.want == "" {
+			assert.Error(t, err, name)
+			continue
+		}
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		got, err := a.ResolveURL(tc.resource, nil)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, tc.want, got.String(), name)
+		}
+		req, err := a.Request(GET, tc.resource, nil)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, tc.want, req.URL.String(), name)
+			assert.Equal(t, req.URL.Host, req.Host, name)
+		}
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	a := MustNew("https://Example.com:8443/api/")
+	a.SetVersion(VersionInPath("v2"))
+	args := url.Values{}
+	args.Set("b", "2")
+	args.Set("a", "1 2")
+	u, err := a.ResolveURL("/items/", args)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://example.com:8443/api/v2/items?a=1+2&b=2", u.String())
+	}
+	assert.Equal(t, "https://example.com:8443/api", a.BaseURI.String())
+
+	a.SetPathPolicy(RejectTraversal)
+	_, err = a.ResolveURL("../../etc", nil)
+	assert.ErrorIs(t, err, ErrPathTraversal)
+}
+
+func FuzzResourceURL(f *testing.F) {
+	f.Add("http://example.com/api/", "/items/1", false)
+	f.Add("http://example.com", "../a//b/./c/", true)
+	f.Add("https://example.com/a%2Fb/", "/v1/x y", true)
+	f.Add("http://example.com", "*", false)
+	f.Add("http://example.com/%00%2B", ".", false)
+	f.Fuzz(func(t *testing.T, base, resource string, version bool) {
+		a, err := New(base)
+		if err != nil || a.BaseURI.Host == "" || a.BaseURI.Scheme != "http" && a.BaseURI.Scheme != "https" {
+			return
+		}
+		if version {
+			a.SetVersion(VersionInPath("v1"))
+		}
+		old, err := oldRequestURL(a, resource)
+		if err != nil {
+			return
+		}
+		got, err := a.resourceURL(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newRequest(GET, got)
+		if got.String() != old.String() || got.Path != old.Path || got.Host != old.Host {
+			t.Fatalf("base %q resource %q: got %q (%q), want %q (%q)", base, resource, got, got.Path, old, old.Path)
+		}
+	})
+}
+
+func BenchmarkResourceURL(b *testing.B) {
+	a := MustNew("http://example.com/api/v2")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		a.resourceURL("/categories/1")
+	}
+}
+
+func TestPathPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		resource string
+		clean    string
+		rejected bool
+	}{
+		{"/items/../orders", "http://example.com/api/v1/orders", false},
+		{"/items/./1/", "http://example.com/api/v1/items/1", false},
+		{"/a/b/../../c", "http://example.com/api/v1/c", false},
+		{"../admin", "http://example.com/api/v1/admin", true},
+		{"/items/../../admin", "http://example.com/api/v1/admin", true},
+		{"/../../../../etc/passwd", "http://example.com/api/v1/etc/passwd", true},
+		{"..", "http://example.com/api/v1", true},
+		{"/..%2F..%2Fadmin", "http://example.com/api/v1/..%252F..%252Fadmin", false},
+		{"/..\\..\\admin", "http://example.com/api/v1/..%5C..%5Cadmin", true},
+		{"/items\x00/1", "http://example.com/api/v1/items%00/1", false},
+		{"/items/1?admin=1#x", "http://example.com/api/v1/items/1%3Fadmin=1%23x", false},
+		{"/..foo/bar..", "http://example.com/api/v1/..foo/bar..", false},
+	} {
+		a := MustNew("http://example.com/api")
+		a.SetVersion(VersionInPath("v1"))
+		req, err := a.Request(GET, tc.resource, nil)
+		if assert.NoError(t, err, tc.resource) {
+			assert.Equal(t, tc.clean, req.URL.String(), tc.resource)
+		}
+
+		a.SetPathPolicy(RejectTraversal)
+		req, err = a.Request(GET, tc.resource, nil)
+		if tc.rejected {
+			assert.Equal(t, ErrPathTraversal, err, tc.resource)
+			_, err = a.RequestBytes(POST, tc.resource, "text/plain", nil)
+			assert.Equal(t, ErrPathTraversal, err, tc.resource)
+			continue
+		}
+		if assert.NoError(t, err, tc.resource) {
+			assert.Equal(t, tc.clean, req.URL.String(), tc.resource)
+		}
+	}
+}
+
+func FuzzRequest(f *testing.F) {
+	f.Add("/items/1", "q", "a b", false)
+	f.Add("../../etc/passwd", "", "", false)
+	f.Add("/a/..\\..\\b", "x", "\x00", true)
+	f.Add("/%2e%2e/%2F", "&", "=", true)
+	f.Fuzz(func(t *testing.T, resource, key, value string, reject bool) {
+		a := MustNew("http://example.com/api/v1")
+		if reject {
+			a.SetPathPolicy(RejectTraversal)
+		}
+		args := url.Values{key: {value}}
+		req, err := a.Request(GET, resource, args)
+		if err != nil {
+			if !reject || err != ErrPathTraversal {
+				t.Fatalf("resource %q: %v", resource, err)
+			}
+			return
+		}
+		u, err := url.Parse(req.URL.String())
+		if err != nil {
+			t.Fatalf("resource %q: %q doesn't parse: %v", resource, req.URL, err)
+		}
+		if u.Path != req.URL.Path {
+			t.Fatalf("resource %q: path %q doesn't round-trip, got %q", resource, req.URL.Path, u.Path)
+		}
+		if u.Path != "/api/v1" && !strings.HasPrefix(u.Path, "/api/v1/") {
+			t.Fatalf("resource %q: path %q escapes the base path", resource, u.Path)
+		}
+		if u.Host != "example.com" || u.Fragment != "" {
+			t.Fatalf("resource %q: unexpected URL %q", resource, u)
+		}
+		if got := u.Query(); len(got) != 1 || got.Get(key) != value {
+			t.Fatalf("resource %q: args %v don't round-trip, got %v", resource, args, got)
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UsagePolicy configures SetUsageTracking.
+type UsagePolicy struct {
+	// Stacks makes the tracking sample the stack of the callers of every resource, once per
+	// StackEvery, for UsageStat.Stack. It's a debugging aid: capturing a stack costs a few
+	// microseconds per sample.
+	Stacks bool
+	// StackEvery is the time between the stack samples of a resource, an hour if zero.
+	StackEvery time.Duration
+	// WarnEvery is the time between the warnings of a deprecated resource, a minute if zero.
+	WarnEvery time.Duration
+}
+
+// UsageStat is the usage of a resource reported by UsageReport.
+type UsageStat struct {
+	// Resource is the template of the calls made from a Template, the resource of the others.
+	Resource string
+	Calls    int64
+	// FirstSeen and LastSeen are the times of the first and the last calls, by the clock of the Api.
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// Deprecated is set for the resources marked by Deprecate, Message being the message given.
+	Deprecated bool
+	Message    string
+	// Stack is the last sampled stack of a caller, "file:line function" frames from the first
+	// one outside of this package, with UsagePolicy.Stacks.
+	Stack []string
+}
+
+// DeprecatedUse is the use of a deprecated resource, see Deprecate, or of a migrated one, see
+// MigrationPolicy.Notify.
+type DeprecatedUse struct {
+	Resource string
+	Message  string
+	// Calls is the number of calls of the resource so far.
+	Calls int64
+	// Suppressed is the number of uses not warned about since the previous warning.
+	Suppressed int64
+	// Stack is the stack of the calling code for the notices of Migrate, like UsageStat.Stack.
+	Stack []string
+}
+
+// DeprecationLogger is implemented by the CallLoggers warned about the uses of the resources
+// marked by Deprecate, like SlogLogger.
+type DeprecationLogger interface {
+	LogDeprecated(ctx context.Context, d DeprecatedUse)
+}
+
+// LogDeprecated implements DeprecationLogger, logging d at the Warn level.
+func (s *SlogLogger) LogDeprecated(ctx context.Context, d DeprecatedUse) {
+	args := []interface{}{"resource", d.Resource, "message", d.Message, "calls", d.Calls, "suppressed", d.Suppressed}
+	if d.Stack != nil {
+		args = append(args, "stack", d.Stack)
+	}
+	s.Logger.WarnContext(ctx, "api deprecated resource", args...)
+}
+
+type usageTracker struct {
+	mu         sync.Mutex
+	all        bool
+	policy     UsagePolicy
+	entries    map[string]*usageEntry
+	deprecated map[string]string
+}
+
+type usageEntry struct {
+	stat       UsageStat
+	stackAt    time.Time
+	warnedAt   time.Time
+	suppressed int64
+}
+
+// SetUsageTracking makes the Api count the calls of every resource, keyed by the template of the
+// calls made from a Template, for UsageReport. A nil p stops tracking the resources that aren't
+// deprecated, see Deprecate.
+func (a *Api) SetUsageTracking(p *UsagePolicy) {
+	u := a.usageTracker()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.all, u.policy = p != nil, UsagePolicy{}
+	if p != nil {
+		u.policy = *p
+	}
+}
+
+// Deprecate marks the resource, usually a template like "/v1/users/{id}", as deprecated by our
+// own code, e.g. while migrating off an API version:
+//
+//	a.Deprecate("/v1/users/{id}", "use /v2/users/{id}")
+//
+// The calls of the resource are tracked even without SetUsageTracking, and their uses are
+// reported to the CallLogger of the Api if it's a DeprecationLogger, once per UsagePolicy.WarnEvery.
+func (a *Api) Deprecate(resource, message string) {
+	u := a.usageTracker()
+	u.mu.Lock()
+	u.deprecated[resource] = message
+	if e := u.entries[resource]; e != nil {
+		e.stat.Deprecated, e.stat.Message = true, message
+	}
+	u.mu.Unlock()
+}
+
+// UsageReport returns the usage of the resources tracked, sorted by resource.
+func (a *Api) UsageReport() []UsageStat {
+	u := a.usage.Load()
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	stats := make([]UsageStat, 0, len(u.entries))
+	for _, e := range u.entries {
+		stat := e.stat
+		stat.Stack = append([]string(nil), e.stat.Stack...)
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Resource < stats[j].Resource })
+	return stats
+}
+
+func (a *Api) usageTracker() *usageTracker {
+	return a.usage.get(func() *usageTracker {
+		return &usageTracker{entries: make(map[string]*usageEntry), deprecated: make(map[string]string)}
+	})
+}
+
+// trackUsage counts the call of the resource, sampling the stack of its caller and warning about
+// it if it's deprecated.
+func (a *Api) trackUsage(ctx context.Context, u *usageTracker, resource string) {
+	now := a.clock().Now()
+	u.mu.Lock()
+	message, deprecated := u.deprecated[resource]
+	if !u.all && !deprecated {
+		u.mu.Unlock()
+		return
+	}
+	e := u.entries[resource]
+	if e == nil {
+		e = &usageEntry{stat: UsageStat{Resource: resource, FirstSeen: now, Deprecated: deprecated, Message: message}}
+		u.entries[resource] = e
+	}
+	e.stat.Calls++
+	e.stat.LastSeen = now
+	sample := u.policy.Stacks && (e.stat.Stack == nil || now.Sub(e.stackAt) >= durationOr(u.policy.StackEvery, time.Hour))
+	if sample {
+		e.stackAt = now
+	}
+	var use *DeprecatedUse
+	if deprecated {
+		if e.warnedAt.IsZero() || now.Sub(e.warnedAt) >= durationOr(u.policy.WarnEvery, time.Minute) {
+			use = &DeprecatedUse{Resource: resource, Message: message, Calls: e.stat.Calls, Suppressed: e.suppressed}
+			e.warnedAt, e.suppressed = now, 0
+		} else {
+			e.suppressed++
+		}
+	}
+	u.mu.Unlock()
+	if sample {
+		stack := callerStack()
+		u.mu.Lock()
+		e.stat.Stack = stack
+		u.mu.Unlock()
+	}
+	if use != nil {
+		if l, ok := a.callLogger().(DeprecationLogger); ok {
+			l.LogDeprecated(ctx, *use)
+		}
+	}
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// callerStack returns the stack of the caller from its first frame outside of this package,
+// tests excluded, like callSite.
+func callerStack() []string {
+	pc := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	var stack []string
+	for {
+		f, more := frames.Next()
+		if stack != nil || filepath.Dir(f.File) != packageDir || strings.HasSuffix(f.File, "_test.go") {
+			stack = append(stack, fmt.Sprintf("%s:%d %s", f.File, f.Line, f.Function))
+		}
+		if !more {
+			return stack
+		}
+	}
+}
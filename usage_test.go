@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestUsageReport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	a.SetClock(clk)
+	a.SetUsageTracking(&UsagePolicy{Stacks: true})
+	ctx := context.Background()
+
+	users := a.Template(GET, "/v1/users/{id}")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				assert.NoError(t, users.Do(ctx, map[string]string{"id": strconv.Itoa(i)}, nil, nil))
+			}
+		}(i)
+	}
+	wg.Wait()
+	clk.Advance(time.Minute)
+	assert.NoError(t, a.Get(ctx, "/health", nil, nil))
+
+	report := a.UsageReport()
+	if !assert.Len(t, report, 2) {
+		return
+	}
+	assert.Equal(t, "/health", report[0].Resource)
+	assert.Equal(t, int64(1), report[0].Calls)
+	assert.Equal(t, start.Add(time.Minute), report[0].FirstSeen)
+	stat := report[1]
+	assert.Equal(t, "/v1/users/{id}", stat.Resource)
+	assert.Equal(t, int64(500), stat.Calls)
+	assert.Equal(t, start, stat.FirstSeen)
+	assert.Equal(t, start, stat.LastSeen)
+	assert.False(t, stat.Deprecated)
+	if assert.NotEmpty(t, stat.Stack) {
+		assert.Contains(t, stat.Stack[0], "usage_test.go")
+	}
+
+	// Without tracking, only the deprecated resources are counted.
+	a.SetUsageTracking(nil)
+	a.Deprecate("/v1/users/{id}", "use /v2/users/{id}")
+	assert.NoError(t, a.Get(ctx, "/health", nil, nil))
+	assert.NoError(t, users.Do(ctx, map[string]string{"id": "1"}, nil, nil))
+	report = a.UsageReport()
+	assert.Equal(t, int64(1), report[0].Calls)
+	assert.Equal(t, int64(501), report[1].Calls)
+	assert.True(t, report[1].Deprecated)
+	assert.Equal(t, "use /v2/users/{id}", report[1].Message)
+}
+
+// deprecationLog is a CallLogger keeping the warnings of the deprecated resources.
+type deprecationLog struct {
+	mu   sync.Mutex
+	uses []DeprecatedUse
+}
+
+func (d *deprecationLog) LogCall(context.Context, CallLog) {}
+
+func (d *deprecationLog) LogDeprecated(_ context.Context, use DeprecatedUse) {
+	d.mu.Lock()
+	d.uses = append(d.uses, use)
+	d.mu.Unlock()
+}
+
+func TestDeprecateWarnings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a.SetClock(clk)
+	logs := &deprecationLog{}
+	a.SetCallLogger(logs)
+	a.Deprecate("/v1/users/{id}", "use /v2/users/{id}")
+	users := a.Template(GET, "/v1/users/{id}")
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, users.Do(ctx, map[string]string{"id": "1"}, nil, nil))
+		clk.Advance(10 * time.Second)
+	}
+	assert.NoError(t, a.Get(ctx, "/v2/users/1", nil, nil))
+	clk.Advance(20 * time.Second)
+	assert.NoError(t, users.Do(ctx, map[string]string{"id": "2"}, nil, nil))
+	assert.Equal(t, []DeprecatedUse{
+		{Resource: "/v1/users/{id}", Message: "use /v2/users/{id}", Calls: 1},
+		{Resource: "/v1/users/{id}", Message: "use /v2/users/{id}", Calls: 6, Suppressed: 4},
+	}, logs.uses)
+
+	var buf bytes.Buffer
+	a.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	clk.Advance(time.Minute)
+	assert.NoError(t, users.Do(ctx, map[string]string{"id": "3"}, nil, nil))
+	line, _, _ := strings.Cut(buf.String(), "\n")
+	assert.Contains(t, line, `level=WARN msg="api deprecated resource" resource=/v1/users/{id} message="use /v2/users/{id}" calls=7 suppressed=0`)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Validator checks successful responses against the contract of the API, see SetValidator.
+type Validator interface {
+	// ValidateResponse checks the body of a response to resource with the given status code.
+	// The resource is the one given to the Do-style helper, or the URL path for List's next pages;
+	// validators using resource templates like "/users/{id}" match it against them, see RequiredFields.
+	ValidateResponse(resource string, status int, body []byte) error
+}
+
+// ValidatorFunc is a function used as a Validator.
+type ValidatorFunc func(resource string, status int, body []byte) error
+
+func (f ValidatorFunc) ValidateResponse(resource string, status int, body []byte) error {
+	return f(resource, status, body)
+}
+
+// ValidationError is returned or reported when a response fails the validation set by SetValidator.
+type ValidationError struct {
+	Method   string
+	Resource string
+	Status   int
+	Err      error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("api: invalid response to %s %s (status %d): %v", e.Method, e.Resource, e.Status, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// validation is the validator set by SetValidator.
+type validation struct {
+	v      Validator
+	report func(err *ValidationError)
+}
+
+// SetValidator makes the Do-style helpers check every successful response with v, e.g. to catch
+// contract drift of the upstream in staging. The body is read in full before it's handed to v,
+// and the caller then reads it as usual. If report is nil, a failed validation fails the call
+// with a *ValidationError; otherwise the error is passed to report and the call goes on.
+// A nil v disables the validation.
+func (a *Api) SetValidator(v Validator, report func(err *ValidationError)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if v == nil {
+		a.validation = nil
+		return
+	}
+	a.validation = &validation{v: v, report: report}
+}
+
+// validate checks the successful resp with the validator and the golden schemas, if set.
+// If the call fails, the body is closed.
+func (a *Api) validate(c *call, req *http.Request, resp *http.Response) error {
+	a.mu.Lock()
+	val, drift := a.validation, a.drift
+	a.mu.Unlock()
+	if val == nil && drift == nil {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	resp.Body = &prefixedBody{Reader: bytes.NewReader(body), Closer: resp.Body}
+	if drift != nil {
+		if err := drift.check(c, req, resp.StatusCode, body); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	}
+	if val == nil {
+		return nil
+	}
+	if err := val.v.ValidateResponse(c.resource, resp.StatusCode, body); err != nil {
+		verr := &ValidationError{Method: req.Method, Resource: c.resource, Status: resp.StatusCode, Err: err}
+		if val.report == nil {
+			resp.Body.Close()
+			return verr
+		}
+		val.report(verr)
+	}
+	return nil
+}
+
+// RequiredFields is a Validator requiring the JSON bodies of the resources matching its templates
+// to have values at the given JSON pointers (RFC 6901), e.g.
+//
+//	api.RequiredFields{
+//		"/users/{id}": {"/id", "/name"},
+//		"/users":      {"/items", "/total"},
+//	}
+//
+// A "{...}" segment of a template matches any single segment. The paths of all the matching
+// templates are required. Resources matching no template and 204 responses aren't checked.
+type RequiredFields map[string][]string
+
+// ValidateResponse implements Validator.
+func (f RequiredFields) ValidateResponse(resource string, status int, body []byte) error {
+	if status == http.StatusNoContent {
+		return nil
+	}
+	templates := make([]string, 0, len(f))
+	for tmpl := range f {
+		if matchTemplate(tmpl, resource) {
+			templates = append(templates, tmpl)
+		}
+	}
+	if len(templates) == 0 {
+		return nil
+	}
+	if !json.Valid(body) {
+		return errors.New("body is not valid JSON")
+	}
+	sort.Strings(templates)
+	var missing []string
+	for _, tmpl := range templates {
+		for _, ptr := range f[tmpl] {
+			if _, err := lookupPointer(body, ptr); err != nil {
+				missing = append(missing, ptr)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required fields %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// matchTemplate reports whether resource matches tmpl, whose "{...}" segments match any segment.
+func matchTemplate(tmpl, resource string) bool {
+	if i := strings.IndexAny(resource, "?#"); i >= 0 {
+		resource = resource[:i]
+	}
+	ts := strings.Split(strings.Trim(tmpl, "/"), "/")
+	rs := strings.Split(strings.Trim(resource, "/"), "/")
+	if len(ts) != len(rs) {
+		return false
+	}
+	for i, seg := range ts {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if rs[i] == "" {
+				return false
+			}
+			continue
+		}
+		if seg != rs[i] {
+			return false
+		}
+	}
+	return true
+}
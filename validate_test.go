@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidator(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/1":
+			w.Write([]byte(`{"id": 1, "name": "bob", "tags": ["a"], "a/b": {"~c": 0}}`))
+		case "/users/2":
+			w.Write([]byte(`{"id": 2}`))
+		case "/html/1":
+			w.Write([]byte(`<html></html>`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetValidator(RequiredFields{
+		"/users/{id}": {"/id", "/name", "/tags/0"},
+		"/html/{id}":  {"/id"},
+	}, nil)
+
+	var user struct {
+		ID   int
+		Name string
+	}
+	if assert.NoError(t, a.Get(context.Background(), "/users/1", nil, &user)) {
+		assert.Equal(t, "bob", user.Name)
+	}
+
+	err := a.Get(context.Background(), "/users/2", nil, &user)
+	assert.EqualError(t, err, "api: invalid response to GET /users/2 (status 200): missing required fields /name, /tags/0")
+	var verr *ValidationError
+	if assert.True(t, errors.As(err, &verr)) {
+		assert.Equal(t, 200, verr.Status)
+		assert.Equal(t, "/users/2", verr.Resource)
+	}
+	assert.False(t, IsRetryable(err))
+
+	err = a.Get(context.Background(), "/html/1", nil, nil)
+	assert.EqualError(t, err, "api: invalid response to GET /html/1 (status 200): body is not valid JSON")
+	// Resources matching no template aren't checked.
+	assert.NoError(t, a.Get(context.Background(), "/users", nil, nil))
+	assert.NoError(t, a.Get(context.Background(), "/users/1/avatar", nil, nil))
+
+	// In report mode, the call goes on.
+	var reported []error
+	a.SetValidator(RequiredFields{"/users/{id}": {"/name", "/a~1b/~0c"}}, func(err *ValidationError) {
+		reported = append(reported, err)
+	})
+	user.Name = ""
+	if assert.NoError(t, a.Get(context.Background(), "/users/2", nil, &user)) {
+		assert.Equal(t, 2, user.ID)
+	}
+	assert.NoError(t, a.Get(context.Background(), "/users/1", nil, &user))
+	if assert.Len(t, reported, 1) {
+		assert.EqualError(t, reported[0], "api: invalid response to GET /users/2 (status 200): missing required fields /name, /a~1b/~0c")
+	}
+
+	// Validators see the untouched body and may reject anything.
+	var seen []string
+	a.SetValidator(ValidatorFunc(func(resource string, status int, body []byte) error {
+		seen = append(seen, resource+" "+string(body))
+		return nil
+	}), nil)
+	assert.NoError(t, a.Get(context.Background(), "/users/2", nil, &user))
+	assert.Equal(t, []string{`/users/2 {"id": 2}`}, seen)
+
+	a.SetValidator(nil, nil)
+	assert.NoError(t, a.Get(context.Background(), "/html/1", nil, nil))
+}
+
+func TestLookupPointer(t *testing.T) {
+	data := []byte(`{"a": {"b": [10, {"c": null}]}, "x/y": 1, "m~n": 2, "": 3}`)
+	for ptr, want := range map[string]string{
+		"/a/b/0":   "10",
+		"/a/b/1/c": "null",
+		"/x~1y":    "1",
+		"/m~0n":    "2",
+		"/":        "3",
+	} {
+		v, err := lookupPointer(data, ptr)
+		if assert.NoError(t, err, ptr) {
+			assert.Equal(t, want, string(v), ptr)
+		}
+	}
+	for _, ptr := range []string{"a", "/a/c", "/a/b/2", "/a/b/01", "/a/b/-", "/a/b/0/c"} {
+		_, err := lookupPointer(data, ptr)
+		assert.Error(t, err, ptr)
+	}
+}
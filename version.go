@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Version is a strategy of passing the API version with every request built by the Api.
+// See VersionInPath and VersionInHeader.
+type Version struct {
+	segment string
+	header  string
+	value   string
+}
+
+// VersionInPath puts the version as a path segment right after the base path, e.g.
+// http://example.com/api + /items becomes http://example.com/api/v2/items.
+// The segment isn't added again if the base path ends with it or the resource starts with it.
+func VersionInPath(v string) Version {
+	return Version{segment: strings.Trim(v, "/")}
+}
+
+// VersionInHeader sets the version as the value of the named header, e.g. X-API-Version: 2023-10-01.
+func VersionInHeader(name, value string) Version {
+	return Version{header: http.CanonicalHeaderKey(name), value: value}
+}
+
+// SetVersion sets the version strategy applied to all the requests.
+// Setting the zero Version disables versioning.
+func (a *Api) SetVersion(v Version) {
+	a.mu.Lock()
+	a.version = v
+	a.mu.Unlock()
+}
+
+func (a *Api) currentVersion() Version {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.version
+}
+
+// versionSegment returns the path segment of the version seg to insert between the base path and the resource.
+func versionSegment(seg, basePath, resource string) string {
+	if seg == "" {
+		return ""
+	}
+	if path.Base(basePath) == seg {
+		return ""
+	}
+	if first := strings.SplitN(strings.TrimPrefix(path.Clean("/"+resource), "/"), "/", 2)[0]; first == seg {
+		return ""
+	}
+	return seg
+}
+
+func (a *Api) versionHeader(h http.Header) {
+	if v := a.currentVersion(); v.header != "" {
+		h.Set(v.header, v.value)
+	}
+}
+
+// Deprecation describes the deprecation signals the server attached to a response.
+type Deprecation struct {
+	// URL is the URL of the request.
+	URL *url.URL
+	// Warning is the value of the X-API-Warn header.
+	Warning string
+	// Deprecated is the value of the Deprecation header, e.g. "true" or a date.
+	Deprecated string
+	// Sunset is the date from the Sunset header (RFC 8594), zero if absent.
+	Sunset time.Time
+}
+
+// OnDeprecation sets a callback invoked by Do for every response that carries
+// X-API-Warn, Deprecation or Sunset headers.
+func (a *Api) OnDeprecation(fn func(d Deprecation)) {
+	a.mu.Lock()
+	a.onDeprecation = fn
+	a.mu.Unlock()
+}
+
+func (a *Api) checkDeprecation(resp *http.Response) {
+	a.mu.Lock()
+	fn := a.onDeprecation
+	a.mu.Unlock()
+	if fn == nil {
+		return
+	}
+	d := Deprecation{
+		Warning:    resp.Header.Get("X-API-Warn"),
+		Deprecated: resp.Header.Get("Deprecation"),
+	}
+	sunset := resp.Header.Get("Sunset")
+	if d.Warning == "" && d.Deprecated == "" && sunset == "" {
+		return
+	}
+	d.Sunset, _ = ParseHTTPDate(sunset)
+	if resp.Request != nil {
+		d.URL = resp.Request.URL
+	}
+	fn(d)
+}
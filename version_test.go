@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionInPath(t *testing.T) {
+	a := MustNew("http://example.com/api")
+	a.SetVersion(VersionInPath("v2"))
+	for resource, exp := range map[string]string{
+		"/items":      "http://example.com/api/v2/items",
+		"items/1":     "http://example.com/api/v2/items/1",
+		"/v2/items":   "http://example.com/api/v2/items",
+		"v2":          "http://example.com/api/v2",
+		"/v2items":    "http://example.com/api/v2/v2items",
+		"/v1/items/2": "http://example.com/api/v2/v1/items/2",
+	} {
+		req, err := a.Request(GET, resource, nil)
+		if assert.NoError(t, err) {
+			assert.Equal(t, exp, req.URL.String(), resource)
+		}
+	}
+
+	b := MustNew("http://example.com/api/v2/")
+	b.SetVersion(VersionInPath("/v2/"))
+	req, err := b.Request(GET, "/items", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/api/v2/items", req.URL.String())
+
+	b.SetVersion(Version{})
+	req, err = b.RequestBytes(POST, "/items", "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/api/v2/items", req.URL.String())
+}
+
+func TestVersionInHeader(t *testing.T) {
+	a := MustNew("http://example.com")
+	a.SetVersion(VersionInHeader("x-api-version", "2023-10-01"))
+	req, err := a.Request(POST, "/items", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "2023-10-01", req.Header.Get("X-API-Version"))
+	assert.Equal(t, "http://example.com/items", req.URL.String())
+
+	a.SetVersion(VersionInPath("v3"))
+	req, err = a.Request(GET, "/items", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, req.Header.Get("X-API-Version"))
+	assert.Equal(t, "http://example.com/v3/items", req.URL.String())
+}
+
+func TestOnDeprecation(t *testing.T) {
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			w.Header().Set("X-API-Warn", "v1 is deprecated")
+			w.Header().Set("Sunset", sunset.Format(http.TimeFormat))
+		}
+	}))
+	defer srv.Close()
+
+	a := MustNew(srv.URL)
+	var got []Deprecation
+	a.OnDeprecation(func(d Deprecation) {
+		got = append(got, d)
+	})
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/new", nil, nil))
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/old", nil, nil))
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "v1 is deprecated", got[0].Warning)
+		assert.Equal(t, sunset, got[0].Sunset)
+		assert.Equal(t, "/old", got[0].URL.Path)
+	}
+}
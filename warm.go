@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrWarmFailed is matched by every *WarmError.
+var ErrWarmFailed = errors.New("api: warming failed")
+
+// WarmError is returned by Warm when a required entry failed to be warmed.
+type WarmError struct {
+	// Resource is the resource of the entry, its template expanded.
+	Resource string
+	Err      error
+}
+
+func (e *WarmError) Error() string {
+	return fmt.Sprintf("api: warming %s failed: %v", e.Resource, e.Err)
+}
+
+// Is makes errors.Is(err, ErrWarmFailed) report true.
+func (e *WarmError) Is(target error) bool { return target == ErrWarmFailed }
+
+func (e *WarmError) Unwrap() error { return e.Err }
+
+// WarmSpec is an entry warmed by Warm: a GET of the resource template with the params
+// substituted in and the args in the query.
+type WarmSpec struct {
+	Template string
+	Params   map[string]string
+	Args     url.Values
+	// Memo is the ttl of the entry in the results of Memoize. If zero, the entry is warmed in
+	// the cache of SetCache instead, which only keeps the cacheable responses.
+	Memo time.Duration
+	// Required makes a failure of the entry fail Warm.
+	Required bool
+	// Options are the options of the call, e.g. WithHeader.
+	Options []Option
+}
+
+// WarmOptions configures Warm.
+type WarmOptions struct {
+	// Concurrency is the number of entries warmed at once, 4 if zero.
+	Concurrency int
+	// Jitter is the maximum random delay before an entry is warmed, spreading the calls of the
+	// clients starting together.
+	Jitter time.Duration
+	// Rand is the source of the jitter, the global source of math/rand if nil. Set it to a seeded
+	// source to get deterministic delays in tests.
+	Rand *rand.Rand
+	// Timeout is the time an entry is given, 10s if zero.
+	Timeout time.Duration
+	// Budget is the time the whole warm-up is given, unlimited if zero. The entries that didn't
+	// start in time fail with context.DeadlineExceeded.
+	Budget time.Duration
+}
+
+// WarmResult is the outcome of an entry of Warm.
+type WarmResult struct {
+	// Resource is the resource of the entry, its template expanded.
+	Resource string
+	// Err is the failure of the entry, nil if it was warmed.
+	Err error
+	// Duration is the time the entry took, jitter excluded.
+	Duration time.Duration
+}
+
+// WarmSummary reports the outcome of Warm, by entry in the order of the specs.
+type WarmSummary struct {
+	Results []WarmResult
+	Warmed  int
+	Failed  int
+}
+
+// Warm fills the cache of SetCache, or the results of Memoize, with the entries of specs at
+// startup, e.g. the reference data every request needs, so the later calls for them are
+// answered without a network call:
+//
+//	summary, err := a.Warm(ctx, []api.WarmSpec{
+//		{Template: "/currencies", Memo: time.Hour, Required: true},
+//		{Template: "/countries/{code}", Params: map[string]string{"code": "FR"}},
+//	}, &api.WarmOptions{Concurrency: 8, Jitter: time.Second, Budget: 10 * time.Second})
+//
+// The entries are warmed concurrently, each after a random delay up to Jitter and within its
+// Timeout. A failed entry doesn't stop the others: Warm fails with a *WarmError only for the
+// first Required entry failing, and reports all of them in the summary anyway. The delays are
+// timed by the clock of the Api.
+func (a *Api) Warm(ctx context.Context, specs []WarmSpec, opts *WarmOptions) (*WarmSummary, error) {
+	if opts == nil {
+		opts = &WarmOptions{}
+	}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = 4
+	}
+	timeout := durationOr(opts.Timeout, 10*time.Second)
+	if opts.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Budget)
+		defer cancel()
+	}
+	summary := &WarmSummary{Results: make([]WarmResult, len(specs))}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range specs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			summary.Results[i] = a.warm(ctx, &specs[i], sem, opts, timeout)
+		}(i)
+	}
+	wg.Wait()
+	var err error
+	for i, r := range summary.Results {
+		if r.Err == nil {
+			summary.Warmed++
+			continue
+		}
+		summary.Failed++
+		if specs[i].Required && err == nil {
+			err = &WarmError{Resource: r.Resource, Err: r.Err}
+		}
+	}
+	return summary, err
+}
+
+// warm warms the entry of spec once sem has room for it.
+func (a *Api) warm(ctx context.Context, spec *WarmSpec, sem chan struct{}, opts *WarmOptions, timeout time.Duration) WarmResult {
+	callOpts := spec.Options
+	if spec.Memo > 0 {
+		callOpts = append(callOpts[:len(callOpts):len(callOpts)], Memoize(spec.Memo))
+	}
+	t := a.Template(GET, spec.Template, callOpts...)
+	r := WarmResult{Resource: expandParams(spec.Template, spec.Params)}
+	if opts.Jitter > 0 {
+		if r.Err = a.clock().Sleep(ctx, time.Duration(int63n(opts.Rand, int64(opts.Jitter)))); r.Err != nil {
+			return r
+		}
+	}
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		r.Err = ctx.Err()
+		return r
+	}
+	defer func() { <-sem }()
+	clk := a.clock()
+	start := clk.Now()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	r.Err = t.Do(ctx, spec.Params, spec.Args, nil)
+	r.Duration = clk.Now().Sub(start)
+	return r
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestWarm(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/currencies":
+			w.Write([]byte(`["EUR", "USD"]`))
+		case "/countries/FR":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte(`{"code": "FR"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	a.SetCache(&CachePolicy{})
+	ctx := context.Background()
+
+	specs := []WarmSpec{
+		{Template: "/currencies", Memo: time.Hour, Required: true},
+		{Template: "/countries/{code}", Params: map[string]string{"code": "FR"}},
+		{Template: "/rates/{day}", Params: map[string]string{"day": "today"}},
+	}
+	summary, err := a.Warm(ctx, specs, &WarmOptions{Concurrency: 2, Jitter: time.Millisecond, Timeout: time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, summary.Warmed)
+	assert.Equal(t, 1, summary.Failed)
+	if assert.Len(t, summary.Results, 3) {
+		assert.Equal(t, "/countries/FR", summary.Results[1].Resource)
+		assert.NoError(t, summary.Results[1].Err)
+		assert.Equal(t, "/rates/today", summary.Results[2].Resource)
+		var se *StatusError
+		assert.True(t, errors.As(summary.Results[2].Err, &se))
+	}
+
+	// The warmed entries are answered without a network call.
+	var currencies []string
+	var meta ResponseMeta
+	assert.NoError(t, a.Get(ctx, "/currencies", nil, &currencies, Memoize(time.Hour), WithMeta(&meta)))
+	assert.Equal(t, []string{"EUR", "USD"}, currencies)
+	assert.True(t, meta.Cached)
+	var country struct{ Code string }
+	assert.NoError(t, a.Template(GET, "/countries/{code}", WithMeta(&meta)).Do(ctx, map[string]string{"code": "FR"}, nil, &country))
+	assert.Equal(t, "FR", country.Code)
+	assert.True(t, meta.Cached)
+	mu.Lock()
+	assert.Equal(t, map[string]int{"/currencies": 1, "/countries/FR": 1, "/rates/today": 1}, hits)
+	mu.Unlock()
+
+	// A required entry failing fails the warm-up.
+	specs[2].Required = true
+	summary, err = a.Warm(ctx, specs, nil)
+	var we *WarmError
+	if assert.True(t, errors.As(err, &we), "%v", err) {
+		assert.Equal(t, "/rates/today", we.Resource)
+	}
+	assert.True(t, errors.Is(err, ErrWarmFailed))
+	assert.Equal(t, 1, summary.Failed)
+}
+
+func TestWarmJitter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a.SetClock(clk)
+	specs := []WarmSpec{{Template: "/a"}, {Template: "/b"}, {Template: "/c"}}
+
+	// The delays are drawn from the seeded source, in whatever order the entries start.
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Warm(context.Background(), specs, &WarmOptions{Jitter: time.Second, Rand: rand.New(rand.NewSource(1))})
+		done <- err
+	}()
+	clk.BlockUntil(3)
+	want := rand.New(rand.NewSource(1))
+	var deadlines []time.Time
+	for range specs {
+		deadlines = append(deadlines, clk.Now().Add(time.Duration(want.Int63n(int64(time.Second)))))
+	}
+	sort.Slice(deadlines, func(i, j int) bool { return deadlines[i].Before(deadlines[j]) })
+	assert.Equal(t, deadlines, clk.Deadlines())
+	clk.Advance(time.Second)
+	assert.NoError(t, <-done)
+}
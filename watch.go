@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultPollTimeout is the time a poll of Watch is given unless WatchOptions.PollTimeout is set,
+// longer than the usual 30s the servers hold a long poll.
+const DefaultPollTimeout = 45 * time.Second
+
+// Change is an event of a change feed watched by Watch.
+type Change struct {
+	// Event is the JSON of the event.
+	Event json.RawMessage
+	// Token is the token of the poll following the one which returned the event.
+	Token string
+}
+
+// WatchOptions configures Watch.
+type WatchOptions struct {
+	// Param is the query parameter carrying the token of the poll, "since" if empty.
+	Param string
+	// Since is the token of the first poll, unless the Checkpoint holds one. The first poll has
+	// no token if both are empty.
+	Since string
+	// TokenHeader is the response header carrying the token of the next poll. If it's empty or
+	// missing from a response, the token is the value at TokenPath of the response body.
+	TokenHeader string
+	// TokenPath is the dotted path of the token in the response body, "token" if empty.
+	TokenPath string
+	// EventsPath is the dotted path of the array of events in the response body, "events" if empty.
+	EventsPath string
+	// Checkpoint persists the token of the next poll once the events of a poll are received, with
+	// the number of events received so far.
+	Checkpoint Checkpoint
+	// PollTimeout is the time a poll is given, DefaultPollTimeout if zero.
+	PollTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the exponential backoff between the polls after a failure,
+	// like the ones of RetryPolicy.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Watch follows the change feed at resource, long-polled with the token of the previous poll,
+// e.g. GET /changes?since=<token>, in a goroutine until ctx is done. The events of the polls
+// are sent on the returned channel of changes:
+//
+//	changes, errs := a.Watch(ctx, "/changes", api.WatchOptions{Checkpoint: cp})
+//	for changes != nil || errs != nil {
+//		select {
+//		case c, ok := <-changes:
+//			...
+//		case err, ok := <-errs:
+//			...
+//		}
+//	}
+//
+// The polls that time out and the empty ones, with no content or no events, are followed by
+// the next poll right away, with the same token unless the response carries another. The other
+// failures are sent on the channel of errors and followed by the next poll after a backoff, or
+// a Retry-After sent by the server; a failure that isn't IsRetryable, like a 401, ends the watch.
+// Both channels must be received from until they're closed, which happens when the watch ends.
+// When ctx is done or the Api is closed, the changes not yet received are dropped: the Checkpoint only stores the
+// token of a poll once all its events are received, so a resumed watch gets them again. The
+// polls aren't retried by the Retry policy of the Api, and the backoffs are timed by its clock.
+func (a *Api) Watch(ctx context.Context, resource string, opts WatchOptions) (<-chan Change, <-chan error) {
+	changes, errs := make(chan Change), make(chan error)
+	a.goBackground(ctx, func(ctx context.Context) {
+		defer close(errs)
+		defer close(changes)
+		a.watch(ctx, resource, &opts, changes, errs)
+	})
+	return changes, errs
+}
+
+func (a *Api) watch(ctx context.Context, resource string, opts *WatchOptions, changes chan<- Change, errs chan<- error) {
+	token, received := opts.Since, int64(0)
+	if opts.Checkpoint != nil {
+		cursor, n, err := opts.Checkpoint.Load()
+		if err != nil {
+			sendOrDone(ctx, errs, err)
+			return
+		}
+		if cursor != "" {
+			token, received = cursor, n
+		}
+	}
+	backoff := &RetryPolicy{MinBackoff: opts.MinBackoff, MaxBackoff: opts.MaxBackoff}
+	failures := 0
+	for ctx.Err() == nil {
+		events, next, err := a.poll(ctx, resource, token, opts)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			if !sendOrDone(ctx, errs, err) || !IsRetryable(err) {
+				return
+			}
+			d := backoff.delay(failures)
+			var se *StatusError
+			if errors.As(err, &se) {
+				if after, ok := ParseRetryAfter(se.Header, a.clock().Now()); ok {
+					d = after
+				}
+			}
+			failures++
+			if a.clock().Sleep(ctx, d) != nil {
+				return
+			}
+			continue
+		}
+		failures = 0
+		if next == "" {
+			next = token
+		}
+		for _, e := range events {
+			if !sendOrDone(ctx, changes, Change{Event: e, Token: next}) {
+				return
+			}
+		}
+		received += int64(len(events))
+		if opts.Checkpoint != nil && (next != token || len(events) > 0) {
+			if err := opts.Checkpoint.Store(next, received); err != nil {
+				sendOrDone(ctx, errs, err)
+				return
+			}
+		}
+		token = next
+	}
+}
+
+// poll makes a poll of Watch, returning its events and the token of the next poll, empty if the
+// response has none. A poll timing out is an empty one.
+func (a *Api) poll(ctx context.Context, resource, token string, opts *WatchOptions) ([]json.RawMessage, string, error) {
+	var args url.Values
+	if token != "" {
+		param := opts.Param
+		if param == "" {
+			param = "since"
+		}
+		args = url.Values{param: {token}}
+	}
+	req, err := a.Request(GET, resource, args)
+	if err != nil {
+		return nil, "", err
+	}
+	timeout := opts.PollTimeout
+	if timeout <= 0 {
+		timeout = DefaultPollTimeout
+	}
+	resp, err := a.send(ctx, a.newCallFor(resource, []Option{WithTimeout(timeout), WithRetry(nil)}), req)
+	var te *TimeoutError
+	if errors.As(err, &te) && isTimeout(te.Err) && ctx.Err() == nil {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if errors.As(err, &te) && isTimeout(te.Err) && ctx.Err() == nil {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	var next string
+	if opts.TokenHeader != "" {
+		next = resp.Header.Get(opts.TokenHeader)
+	}
+	if resp.StatusCode == http.StatusNoContent || len(bytes.TrimSpace(body)) == 0 {
+		return nil, next, nil
+	}
+	if next == "" {
+		path := opts.TokenPath
+		if path == "" {
+			path = "token"
+		}
+		if v, err := JSONLookup(body, path); err == nil && v.Kind() == JSONString {
+			next = v.String()
+		}
+	}
+	path := opts.EventsPath
+	if path == "" {
+		path = "events"
+	}
+	var events []json.RawMessage
+	if v, err := JSONLookup(body, path); err == nil {
+		if err := json.Unmarshal(v.Raw, &events); err != nil {
+			return nil, "", fmt.Errorf("api: events of %s: %w", resource, err)
+		}
+	}
+	return events, next, nil
+}
+
+// sendOrDone sends v on ch unless ctx is done first, reporting whether it was sent.
+func sendOrDone[T any](ctx context.Context, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestWatch(t *testing.T) {
+	type poll struct {
+		status int
+		body   string
+		hang   bool
+	}
+	script := []poll{
+		{body: `{"events": [{"id": 1}, {"id": 2}], "token": "t2"}`},
+		{status: http.StatusNoContent},
+		{status: http.StatusServiceUnavailable},
+		{body: `{"events": [{"id": 3}], "token": "t3"}`},
+		{hang: true},
+		{body: `{"events": [], "token": "t4"}`},
+		{hang: true},
+	}
+	var n int32
+	var mu sync.Mutex
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		p := script[min(int(atomic.AddInt32(&n, 1))-1, len(script)-1)]
+		tokens = append(tokens, r.URL.Query().Get("since"))
+		mu.Unlock()
+		if p.hang {
+			<-r.Context().Done()
+			return
+		}
+		if p.status != 0 {
+			w.WriteHeader(p.status)
+		}
+		w.Write([]byte(p.body))
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a.SetClock(clk)
+	cp := NewFileCheckpoint(filepath.Join(t.TempDir(), "changes.json"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, errs := a.Watch(ctx, "/changes", WatchOptions{Checkpoint: cp, PollTimeout: 100 * time.Millisecond,
+		MinBackoff: time.Second, MaxBackoff: time.Second})
+	var ids []int
+	next := func() {
+		c := <-changes
+		var e struct{ ID int }
+		assert.NoError(t, json.Unmarshal(c.Event, &e))
+		ids = append(ids, e.ID)
+	}
+	next()
+	next()
+
+	// The 503 is reported, and the next poll waits for the backoff.
+	var se *StatusError
+	assert.True(t, errors.As(<-errs, &se))
+	clk.BlockUntil(1)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&n))
+	clk.Advance(time.Second)
+	next()
+	assert.Equal(t, []int{1, 2, 3}, ids)
+
+	// The poll timing out is followed by the next one right away.
+	for atomic.LoadInt32(&n) < 7 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	_, ok := <-changes
+	assert.False(t, ok)
+	_, ok = <-errs
+	assert.False(t, ok)
+	mu.Lock()
+	assert.Equal(t, []string{"", "t2", "t2", "t2", "t3", "t3", "t4"}, tokens[:7])
+	mu.Unlock()
+	cursor, received, err := cp.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "t4", cursor)
+	assert.Equal(t, int64(3), received)
+
+	// A resumed watch polls from the token of the Checkpoint, and ends on a permanent failure.
+	mu.Lock()
+	script = []poll{{status: http.StatusUnauthorized}}
+	atomic.StoreInt32(&n, 0)
+	tokens = nil
+	mu.Unlock()
+	changes, errs = a.Watch(context.Background(), "/changes", WatchOptions{Checkpoint: cp})
+	assert.True(t, errors.As(<-errs, &se))
+	assert.Equal(t, http.StatusUnauthorized, se.Code)
+	_, ok = <-changes
+	assert.False(t, ok)
+	mu.Lock()
+	assert.Equal(t, []string{"t4"}, tokens)
+	mu.Unlock()
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHung is the cause of the cancellation of the calls canceled by the watchdog of WithWatchdog.
+var ErrHung = errors.New("api: call hung past its expected duration")
+
+// HungCall is a call still going past its expected duration, reported by WithWatchdog.
+type HungCall struct {
+	Method string
+	// URL is the URL of the request, redacted.
+	URL string
+	// Resource is the resource as given to the helper, or the URL path for Do.
+	Resource string
+	Phase    Phase
+	// Elapsed is the time since the request was sent, Expected the duration it was expected to
+	// take.
+	Elapsed  time.Duration
+	Expected time.Duration
+	// Received is the number of bytes of the response body read so far.
+	Received int64
+	// Canceled is set when the call is canceled, see Watchdog.Cancel.
+	Canceled bool
+}
+
+// Watchdog is what WithWatchdog does with the calls going past their expected duration.
+type Watchdog struct {
+	// OnHung is called with the call, from a goroutine of its own, while the call goes on.
+	OnHung func(HungCall)
+	// Cancel makes the watchdog cancel the call too, so it fails with a *TimeoutError matched by
+	// ErrHung, whether it's waiting for the response or reading its body.
+	Cancel bool
+}
+
+// WithWatchdog reports the attempts of the call still going after expected, from sending the
+// request until its response body is closed, to w.OnHung, for the calls slow but not timing out,
+// like the ones of a server trickling bytes to keep the connection alive. The watchdog fires
+// once per attempt, never after the attempt is over, on the clock of the Api; the calls without
+// it start no watchdog at all.
+func WithWatchdog(expected time.Duration, w Watchdog) Option {
+	return func(c *call) {
+		c.watchdog = &watchdog{expected: expected, Watchdog: w}
+	}
+}
+
+// watchdog is the setting of WithWatchdog.
+type watchdog struct {
+	Watchdog
+	expected time.Duration
+}
+
+// watch is the watchdog of an attempt.
+type watch struct {
+	received atomic.Int64
+	stopped  chan struct{}
+
+	mu   sync.Mutex
+	over bool
+}
+
+// start starts the watchdog of the attempt of req timed by timer, canceled by cancel.
+func (w *watchdog) start(req *http.Request, timer *callTimer, cancel func(cause error)) *watch {
+	wt := &watch{stopped: make(chan struct{})}
+	t := timer.clk.NewTimer(w.expected)
+	go func() {
+		defer t.Stop()
+		select {
+		case <-wt.stopped:
+			return
+		case <-t.C():
+		}
+		wt.mu.Lock()
+		over := wt.over
+		wt.over = true
+		wt.mu.Unlock()
+		if over {
+			return
+		}
+		if w.OnHung != nil {
+			w.OnHung(HungCall{Method: req.Method, URL: req.URL.Redacted(), Resource: timer.resource,
+				Phase: Phase(timer.phase.Load()), Elapsed: timer.clk.Now().Sub(timer.sent),
+				Expected: w.expected, Received: wt.received.Load(), Canceled: w.Cancel})
+		}
+		if w.Cancel {
+			cancel(ErrHung)
+		}
+	}()
+	return wt
+}
+
+// stop stops the watchdog once the attempt is over.
+func (wt *watch) stop() {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	if !wt.over {
+		wt.over = true
+		close(wt.stopped)
+	}
+}
+
+// watchBody counts the bytes of the response body for the watchdog.
+type watchBody struct {
+	io.ReadCloser
+	wt *watch
+}
+
+func (b *watchBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.wt.received.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestWatchdogFiresThenCompletes(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"items": [`)
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, `]}`)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Now())
+	a.SetClock(clk)
+
+	hung := make(chan HungCall, 2)
+	req, _ := a.Request(GET, "/items", nil)
+	resp, err := a.Do(context.Background(), req, WithWatchdog(time.Second, Watchdog{OnHung: func(h HungCall) { hung <- h }}))
+	if !assert.NoError(t, err) {
+		close(release)
+		return
+	}
+	p := make([]byte, 11)
+	_, err = io.ReadFull(resp.Body, p)
+	assert.NoError(t, err)
+
+	clk.Advance(1500 * time.Millisecond)
+	select {
+	case h := <-hung:
+		assert.Equal(t, "GET", h.Method)
+		assert.Equal(t, "/items", h.Resource)
+		assert.Equal(t, srv.URL+"/items", h.URL)
+		assert.Equal(t, PhaseReadingBody, h.Phase)
+		assert.Equal(t, 1500*time.Millisecond, h.Elapsed)
+		assert.Equal(t, time.Second, h.Expected)
+		assert.Equal(t, int64(11), h.Received)
+		assert.False(t, h.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("the watchdog didn't fire")
+	}
+
+	// The call goes on regardless.
+	close(release)
+	rest, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `]}`, string(rest))
+	resp.Body.Close()
+
+	clk.Advance(time.Hour)
+	select {
+	case h := <-hung:
+		t.Fatalf("the watchdog fired twice: %+v", h)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatchdogCancel(t *testing.T) {
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Now())
+	a.SetClock(clk)
+
+	hung := make(chan HungCall, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- a.DoJSON(context.Background(), GET, "/slow", nil, nil,
+			WithWatchdog(time.Second, Watchdog{OnHung: func(h HungCall) { hung <- h }, Cancel: true}))
+	}()
+	<-arrived
+	clk.Advance(time.Second)
+
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, ErrHung), err)
+		var te *TimeoutError
+		if assert.ErrorAs(t, err, &te) {
+			assert.Equal(t, "/slow", te.Resource)
+			assert.Equal(t, time.Second, te.Elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the watchdog didn't cancel the call")
+	}
+	h := <-hung
+	assert.True(t, h.Canceled)
+	assert.Equal(t, PhaseWaitingHeaders, h.Phase)
+	assert.Zero(t, h.Received)
+}
+
+func TestWatchdogFastPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{}`)
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Now())
+	a.SetClock(clk)
+
+	// A call without a watchdog starts no timer.
+	var out interface{}
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/", nil, &out))
+	assert.Zero(t, clk.Sleepers())
+
+	fired := make(chan HungCall, 1)
+	assert.NoError(t, a.DoJSON(context.Background(), GET, "/", nil, &out,
+		WithWatchdog(time.Second, Watchdog{OnHung: func(h HungCall) { fired <- h }, Cancel: true})))
+	assert.Eventually(t, func() bool { return clk.Sleepers() == 0 }, time.Second, time.Millisecond)
+	clk.Advance(time.Hour)
+	select {
+	case h := <-fired:
+		t.Fatalf("the watchdog fired after the call: %+v", h)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
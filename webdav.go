@@ -0,0 +1,254 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DepthInfinity is the depth of PropFind covering all the descendants of a collection.
+const DepthInfinity = -1
+
+// DAVResponse is the outcome for a resource in a 207 Multi-Status response, see ParseMultiStatus.
+type DAVResponse struct {
+	Href string
+	// Status is the status code of the resource, 0 if the response reports the status of its
+	// properties in PropStats instead.
+	Status    int
+	PropStats []DAVPropStat
+	// Description is the responsedescription, if any.
+	Description string
+}
+
+// DAVPropStat is the status of some properties of a resource.
+type DAVPropStat struct {
+	Status int
+	Props  []DAVProp
+}
+
+// DAVProp is a property of a resource.
+type DAVProp struct {
+	Name xml.Name
+	// Value is the inner XML of the property element, e.g. the text of a displayname.
+	Value string
+}
+
+// MultiStatusError is returned by Move, Copy and MkCol when the server answers with a 207
+// Multi-Status, reporting the resources that couldn't be processed. It's never retried.
+type MultiStatusError struct {
+	Responses []DAVResponse
+}
+
+func (e *MultiStatusError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "api: multi-status: %d resources failed", len(e.Responses))
+	for i, r := range e.Responses {
+		if i == 3 {
+			b.WriteString(", ...")
+			break
+		}
+		sep := ": "
+		if i > 0 {
+			sep = ", "
+		}
+		fmt.Fprintf(&b, "%s%s (%d)", sep, r.Href, r.Status)
+	}
+	return b.String()
+}
+
+// Class makes the error a Permanent failure, so it's never retried.
+func (e *MultiStatusError) Class() Class { return Permanent }
+
+// PropFind sends a PROPFIND request for the resource with the Depth header set to depth,
+// which is 0, 1 or DepthInfinity, and body as the XML propfind element, e.g.
+// `<propfind xmlns="DAV:"><prop><getetag/></prop></propfind>`. A nil body asks for all
+// properties. The status code is checked as in DoJSON; the caller parses the 207 Multi-Status
+// body with ParseMultiStatus and must close it.
+func (a *Api) PropFind(ctx context.Context, resource string, depth int, body []byte, opts ...Option) (*http.Response, error) {
+	var d string
+	switch depth {
+	case 0, 1:
+		d = strconv.Itoa(depth)
+	case DepthInfinity:
+		d = "infinity"
+	default:
+		return nil, fmt.Errorf("api: invalid depth: %d", depth)
+	}
+	req, err := a.davRequest("PROPFIND", resource, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", d)
+	if err := a.shape(req, []Option{buildContext(ctx)}); err != nil {
+		return nil, err
+	}
+	resp, err := a.send(ctx, a.newCallFor(resource, opts), req)
+	if err != nil {
+		return nil, err
+	}
+	return trackLeak(resp), nil
+}
+
+// MkCol creates the collection at resource with a MKCOL request.
+func (a *Api) MkCol(ctx context.Context, resource string, opts ...Option) error {
+	req, err := a.davRequest("MKCOL", resource, nil)
+	if err != nil {
+		return err
+	}
+	return a.sendDAV(ctx, req, resource, opts)
+}
+
+// Move moves the resource at src to dst with a MOVE request, dst being resolved against the
+// BaseURI like src into the absolute Destination header; the trailing slash of a collection is
+// kept in both. Unless overwrite is set, the move fails with a 412 Precondition Failed if dst
+// exists. A 207 Multi-Status, reporting the members of a collection that couldn't be moved,
+// fails with a *MultiStatusError.
+func (a *Api) Move(ctx context.Context, src, dst string, overwrite bool, opts ...Option) error {
+	return a.transfer(ctx, "MOVE", src, dst, overwrite, opts)
+}
+
+// Copy copies the resource at src to dst with a COPY request, just like Move moves it.
+func (a *Api) Copy(ctx context.Context, src, dst string, overwrite bool, opts ...Option) error {
+	return a.transfer(ctx, "COPY", src, dst, overwrite, opts)
+}
+
+func (a *Api) transfer(ctx context.Context, method, src, dst string, overwrite bool, opts []Option) error {
+	req, err := a.davRequest(method, src, nil)
+	if err != nil {
+		return err
+	}
+	u, err := a.davURL(dst)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", u.String())
+	if overwrite {
+		req.Header.Set("Overwrite", "T")
+	} else {
+		req.Header.Set("Overwrite", "F")
+	}
+	return a.sendDAV(ctx, req, src, opts)
+}
+
+// davRequest creates a request with the WebDAV method and the XML body, if any.
+func (a *Api) davRequest(method, resource string, body []byte) (*http.Request, error) {
+	u, err := a.davURL(resource)
+	if err != nil {
+		return nil, err
+	}
+	req := newRequest(GET, u)
+	req.Method = method
+	if body != nil {
+		setBytesBody(req, body)
+		req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return req, nil
+}
+
+// davURL is resourceURL keeping the trailing slash of resource, which tells the collections apart.
+func (a *Api) davURL(resource string) (*url.URL, error) {
+	u, err := a.resourceURL(resource)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(resource, "/") && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		if u.RawPath != "" {
+			u.RawPath += "/"
+		}
+	}
+	return u, nil
+}
+
+// sendDAV shapes and sends req, failing with a *MultiStatusError on a 207.
+func (a *Api) sendDAV(ctx context.Context, req *http.Request, resource string, opts []Option) error {
+	if err := a.shape(req, []Option{buildContext(ctx)}); err != nil {
+		return err
+	}
+	resp, err := a.send(ctx, a.newCallFor(resource, opts), req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return drainClose(resp.Body)
+	}
+	responses, err := ParseMultiStatus(resp.Body)
+	drainClose(resp.Body)
+	if err != nil {
+		return err
+	}
+	return &MultiStatusError{Responses: responses}
+}
+
+// davMultistatus is the multistatus element of RFC 4918.
+type davMultistatus struct {
+	Responses []struct {
+		Hrefs     []string `xml:"DAV: href"`
+		Status    string   `xml:"DAV: status"`
+		PropStats []struct {
+			Props struct {
+				Props []struct {
+					XMLName xml.Name
+					Value   string `xml:",innerxml"`
+				} `xml:",any"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+		Description string `xml:"DAV: responsedescription"`
+	} `xml:"DAV: response"`
+}
+
+// ParseMultiStatus decodes the body of a 207 Multi-Status response into the outcome of every
+// resource, in document order. A response element listing several hrefs gives an entry per href.
+func ParseMultiStatus(r io.Reader) ([]DAVResponse, error) {
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	var ms davMultistatus
+	if err := dec.Decode(&ms); err != nil {
+		return nil, fmt.Errorf("api: invalid multistatus: %w", err)
+	}
+	var out []DAVResponse
+	for _, r := range ms.Responses {
+		status, err := davStatus(r.Status)
+		if err != nil {
+			return nil, err
+		}
+		var propStats []DAVPropStat
+		for _, ps := range r.PropStats {
+			s := DAVPropStat{}
+			if s.Status, err = davStatus(ps.Status); err != nil {
+				return nil, err
+			}
+			for _, p := range ps.Props.Props {
+				s.Props = append(s.Props, DAVProp{Name: p.XMLName, Value: p.Value})
+			}
+			propStats = append(propStats, s)
+		}
+		for _, href := range r.Hrefs {
+			out = append(out, DAVResponse{Href: strings.TrimSpace(href), Status: status, PropStats: propStats,
+				Description: strings.TrimSpace(r.Description)})
+		}
+	}
+	return out, nil
+}
+
+// davStatus parses the status code of a status line like "HTTP/1.1 404 Not Found", 0 if empty.
+func davStatus(line string) (int, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return 0, nil
+	}
+	fields := strings.Fields(line)
+	if len(fields) >= 2 {
+		if code, err := strconv.Atoi(fields[1]); err == nil {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("api: invalid multistatus status %q", line)
+}
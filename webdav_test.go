@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMultiStatus = `<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:response>
+    <d:href>/cal/</d:href>
+    <d:propstat>
+      <d:prop><d:displayname>Work</d:displayname><d:getetag>"1"</d:getetag></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+    <d:propstat>
+      <d:prop><c:calendar-color/></d:prop>
+      <d:status>HTTP/1.1 404 Not Found</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/cal/a.ics</d:href>
+    <d:href>/cal/b.ics</d:href>
+    <d:status>HTTP/1.1 423 Locked</d:status>
+    <d:responsedescription>held by bob</d:responsedescription>
+  </d:response>
+</d:multistatus>`
+
+func TestWebDAV(t *testing.T) {
+	type request struct {
+		method, path, depth, destination, overwrite, contentType, body string
+	}
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, request{r.Method, r.URL.Path, r.Header.Get("Depth"), r.Header.Get("Destination"),
+			r.Header.Get("Overwrite"), r.Header.Get("Content-Type"), string(body)})
+		switch r.Method {
+		case "PROPFIND":
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusMultiStatus)
+			io.WriteString(w, testMultiStatus)
+		case "MOVE":
+			if r.URL.Path == "/dav/cal/" {
+				w.WriteHeader(http.StatusMultiStatus)
+				io.WriteString(w, testMultiStatus)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case "COPY":
+			w.WriteHeader(http.StatusPreconditionFailed)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL + "/dav")
+	ctx := context.Background()
+
+	propfind := []byte(`<propfind xmlns="DAV:"><prop><displayname/></prop></propfind>`)
+	resp, err := a.PropFind(ctx, "/cal/", 1, propfind)
+	if !assert.NoError(t, err) {
+		return
+	}
+	responses, err := ParseMultiStatus(resp.Body)
+	resp.Body.Close()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []DAVResponse{
+		{Href: "/cal/", PropStats: []DAVPropStat{
+			{Status: 200, Props: []DAVProp{{xml.Name{Space: "DAV:", Local: "displayname"}, "Work"}, {xml.Name{Space: "DAV:", Local: "getetag"}, `"1"`}}},
+			{Status: 404, Props: []DAVProp{{xml.Name{Space: "urn:ietf:params:xml:ns:caldav", Local: "calendar-color"}, ""}}},
+		}},
+		{Href: "/cal/a.ics", Status: 423, Description: "held by bob"},
+		{Href: "/cal/b.ics", Status: 423, Description: "held by bob"},
+	}, responses)
+
+	resp, err = a.PropFind(ctx, "/cal/", DepthInfinity, nil)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	_, err = a.PropFind(ctx, "/cal/", 2, nil)
+	assert.EqualError(t, err, "api: invalid depth: 2")
+
+	assert.NoError(t, a.MkCol(ctx, "/cal/new/"))
+	assert.NoError(t, a.Move(ctx, "/cal/a.ics", "/archive/a.ics", true))
+	err = a.Move(ctx, "/cal/", "/old/", false)
+	var mse *MultiStatusError
+	if assert.ErrorAs(t, err, &mse) {
+		assert.Len(t, mse.Responses, 3)
+		assert.Equal(t, "api: multi-status: 3 resources failed: /cal/ (0), /cal/a.ics (423), /cal/b.ics (423)", err.Error())
+		assert.Equal(t, Permanent, Classify(err))
+	}
+	var se *StatusError
+	if assert.ErrorAs(t, a.Copy(ctx, "/cal/a.ics", "/cal/b.ics", false), &se) {
+		assert.Equal(t, http.StatusPreconditionFailed, se.Code)
+	}
+
+	assert.Equal(t, []request{
+		{"PROPFIND", "/dav/cal/", "1", "", "", "application/xml; charset=utf-8", string(propfind)},
+		{"PROPFIND", "/dav/cal/", "infinity", "", "", "", ""},
+		{"MKCOL", "/dav/cal/new/", "", "", "", "", ""},
+		{"MOVE", "/dav/cal/a.ics", "", srv.URL + "/dav/archive/a.ics", "T", "", ""},
+		{"MOVE", "/dav/cal/", "", srv.URL + "/dav/old/", "F", "", ""},
+		{"COPY", "/dav/cal/a.ics", "", srv.URL + "/dav/cal/b.ics", "F", "", ""},
+	}, requests)
+
+	_, err = ParseMultiStatus(strings.NewReader(`<multistatus xmlns="DAV:"><response><href>/x</href><status>bogus</status></response></multistatus>`))
+	assert.EqualError(t, err, `api: invalid multistatus status "bogus"`)
+}
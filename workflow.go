@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrPollBudgetExceeded is matched by the errors of the workflow steps that weren't done
+// within the MaxPolls of their StepPoll.
+var ErrPollBudgetExceeded = errors.New("api: poll budget exceeded")
+
+// pollBudgetError is returned by a step not done within its MaxPolls.
+type pollBudgetError struct {
+	polls int
+}
+
+func (e *pollBudgetError) Error() string {
+	return fmt.Sprintf("not done after %d polls", e.polls)
+}
+
+func (e *pollBudgetError) Is(target error) bool { return target == ErrPollBudgetExceeded }
+
+// Step is a step of a workflow run by RunWorkflow.
+type Step struct {
+	// Name identifies the step in errors.
+	Name string
+	// Request builds the request of the step from the values extracted by the previous steps.
+	// It's called again for every poll.
+	Request func(vars map[string]string) (*http.Request, error)
+	// Extract maps the dotted JSON paths of the response body, e.g. "data.token", to the keys
+	// the values found there are stored under. Strings are stored as is, other values as JSON.
+	Extract map[string]string
+	// Retry is the retry policy of the step's calls, the Api's Retry if nil.
+	Retry *RetryPolicy
+	// Poll, if set, repeats the step until its response says it's done.
+	Poll *StepPoll
+	// Options are applied to the step's calls.
+	Options []Option
+}
+
+// StepPoll configures the polling of a workflow step.
+type StepPoll struct {
+	// Interval is the time between the polls, 1s if zero.
+	Interval time.Duration
+	// MaxPolls limits the number of requests made by the step; it's only bounded by the context if zero.
+	MaxPolls int
+	// Done reports whether the response body is the final one. Its error fails the step.
+	Done func(body []byte) (bool, error)
+}
+
+// WorkflowError is returned by RunWorkflow when a step fails.
+type WorkflowError struct {
+	// Step is the name of the failed step.
+	Step string
+	// Index is the 0-based index of the failed step.
+	Index int
+	Err   error
+}
+
+func (e *WorkflowError) Error() string {
+	return fmt.Sprintf("api: workflow step %s: %v", e.Step, e.Err)
+}
+
+func (e *WorkflowError) Unwrap() error { return e.Err }
+
+// RunWorkflow executes dependent calls in order, each step building its request from the values
+// extracted by the previous ones, e.g. creating a session, uploading with its token and polling
+// the upload until it's processed:
+//
+//	vars, err := a.RunWorkflow(ctx, []api.Step{{
+//		Name:    "session",
+//		Request: func(map[string]string) (*http.Request, error) { return a.RequestJSON(api.POST, "/sessions", creds) },
+//		Extract: map[string]string{"token": "token"},
+//	}, {
+//		Name: "upload",
+//		Request: func(vars map[string]string) (*http.Request, error) {
+//			return a.RequestBytes(api.POST, "/uploads", "text/csv", data, api.WithHeader("Authorization", "Bearer "+vars["token"]))
+//		},
+//		Extract: map[string]string{"id": "upload_id"},
+//	}, ...})
+//
+// The calls are made just like DoJSON makes them, non-2xx responses failing the step.
+// The first failure stops the workflow with a *WorkflowError naming the step; an extracted path
+// missing from the response is a failure too. The values extracted so far are returned either way.
+func (a *Api) RunWorkflow(ctx context.Context, steps []Step) (map[string]string, error) {
+	vars := make(map[string]string)
+	for i, step := range steps {
+		if err := a.runStep(ctx, &step, vars); err != nil {
+			name := step.Name
+			if name == "" {
+				name = "#" + strconv.Itoa(i+1)
+			}
+			return vars, &WorkflowError{Step: name, Index: i, Err: err}
+		}
+	}
+	return vars, nil
+}
+
+func (a *Api) runStep(ctx context.Context, step *Step, vars map[string]string) error {
+	if step.Request == nil {
+		return errors.New("no request")
+	}
+	opts := step.Options
+	if step.Retry != nil {
+		opts = append(opts[:len(opts):len(opts)], WithRetry(step.Retry))
+	}
+	for polls := 1; ; polls++ {
+		req, err := step.Request(vars)
+		if err != nil {
+			return err
+		}
+		body, err := a.stepBody(ctx, req, opts)
+		if err != nil {
+			return err
+		}
+		if err := extractVars(body, step.Extract, vars); err != nil {
+			return err
+		}
+		if step.Poll == nil {
+			return nil
+		}
+		if step.Poll.Done == nil {
+			return errors.New("poll without a Done func")
+		}
+		done, err := step.Poll.Done(body)
+		if err != nil || done {
+			return err
+		}
+		if step.Poll.MaxPolls > 0 && polls >= step.Poll.MaxPolls {
+			return &pollBudgetError{polls: polls}
+		}
+		interval := step.Poll.Interval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		if err := a.clock().Sleep(ctx, interval); err != nil {
+			return withCause(ctx, err)
+		}
+	}
+}
+
+// stepBody sends req and reads the response body.
+func (a *Api) stepBody(ctx context.Context, req *http.Request, opts []Option) ([]byte, error) {
+	resp, err := a.send(ctx, a.newCallFor(req.URL.Path, opts), req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return body, err
+}
+
+// extractVars stores the values found in body at the paths of extract into vars.
+func extractVars(body []byte, extract map[string]string, vars map[string]string) error {
+	paths := make([]string, 0, len(extract))
+	for path := range extract {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		key := extract[path]
+		v, err := JSONLookup(body, path)
+		if err != nil {
+			return fmt.Errorf("extracting %s: no value at %q in the response", key, path)
+		}
+		vars[key] = v.String()
+	}
+	return nil
+}
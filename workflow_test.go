@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlab/api/internal/clock"
+)
+
+func TestRunWorkflow(t *testing.T) {
+	var polls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/sessions":
+			w.Write([]byte(`{"session": {"token": "t0k"}}`))
+		case r.Header.Get("Authorization") != "Bearer t0k":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == "POST" && r.URL.Path == "/uploads":
+			w.Write([]byte(`{"id": 42}`))
+		case r.Method == "GET" && r.URL.Path == "/uploads/42":
+			if polls++; polls < 3 {
+				w.Write([]byte(`{"state": "processing"}`))
+				return
+			}
+			w.Write([]byte(`{"state": "done", "rows": 7}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	a := MustNew(srv.URL)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	clk.AutoAdvance(true)
+	a.SetClock(clk)
+
+	auth := func(vars map[string]string) Option {
+		return WithHeader("Authorization", "Bearer "+vars["token"])
+	}
+	steps := []Step{{
+		Name: "session",
+		Request: func(vars map[string]string) (*http.Request, error) {
+			return a.RequestJSON(POST, "/sessions", map[string]string{"user": "bob"})
+		},
+		Extract: map[string]string{"session.token": "token"},
+	}, {
+		Name: "upload",
+		Request: func(vars map[string]string) (*http.Request, error) {
+			return a.RequestBytes(POST, "/uploads", "text/csv", []byte("a,b\n"), auth(vars))
+		},
+		Extract: map[string]string{"id": "upload"},
+	}, {
+		Name: "poll",
+		Request: func(vars map[string]string) (*http.Request, error) {
+			return a.Request(GET, "/uploads/"+vars["upload"], nil, auth(vars))
+		},
+		Extract: map[string]string{"state": "state"},
+		Poll: &StepPoll{Interval: time.Second, MaxPolls: 5, Done: func(body []byte) (bool, error) {
+			var v struct{ State string }
+			err := json.Unmarshal(body, &v)
+			return v.State == "done", err
+		}},
+	}}
+	start := clk.Now()
+	vars, err := a.RunWorkflow(context.Background(), steps)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]string{"token": "t0k", "upload": "42", "state": "done"}, vars)
+	assert.Equal(t, 3, polls)
+	assert.Equal(t, 2*time.Second, clk.Now().Sub(start))
+
+	// Polls are limited.
+	polls = -10
+	steps[2].Poll.MaxPolls = 2
+	vars, err = a.RunWorkflow(context.Background(), steps)
+	assert.EqualError(t, err, "api: workflow step poll: not done after 2 polls")
+	assert.ErrorIs(t, err, ErrPollBudgetExceeded)
+	assert.Equal(t, "processing", vars["state"])
+
+	// A missing path fails its step, and the workflow stops there.
+	steps[0].Extract = map[string]string{"token": "token"}
+	vars, err = a.RunWorkflow(context.Background(), steps)
+	assert.EqualError(t, err, `api: workflow step session: extracting token: no value at "token" in the response`)
+	assert.Empty(t, vars)
+
+	// A failing call is reported with its step, by name or by position.
+	steps[0].Extract = map[string]string{"session": "token"}
+	steps[1].Name = ""
+	_, err = a.RunWorkflow(context.Background(), steps)
+	var we *WorkflowError
+	if assert.True(t, errors.As(err, &we)) {
+		assert.Equal(t, "#2", we.Step)
+		assert.Equal(t, 1, we.Index)
+	}
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, http.StatusUnauthorized, se.Code)
+	}
+}